@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chorus/pkg/apierror"
+)
+
+type engineOptions struct {
+	url           string
+	apiKey        string
+	rate          float64
+	instances     int
+	concurrency   int
+	steps         int
+	wait          bool
+	pollInterval  time.Duration
+	finishTimeout time.Duration
+	keepTemplate  bool
+}
+
+// engineClient calls the engine API as the load test's user, or with an API key
+type engineClient struct {
+	baseURL string
+	apiKey  string
+	token   string
+	http    *http.Client
+}
+
+// do sends body as JSON and decodes a successful response into out. A failure also
+// returns its kind: the code of the engine's error response, "timeout" or
+// "transport".
+func (c *engineClient) do(ctx context.Context, method, path string, body, out any) (string, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return "encode", err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return "request", err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "Client.Timeout") {
+			return "timeout", err
+		}
+		return "transport", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var refusal apierror.Error
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&refusal)
+		return errorKind(resp.StatusCode, refusal.Code), fmt.Errorf("%s %s answered %d: %s", method, path, resp.StatusCode, refusal.Message)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return "decode", fmt.Errorf("failed to decode %s %s: %w", method, path, err)
+		}
+	}
+	io.Copy(io.Discard, resp.Body)
+	return "", nil
+}
+
+// syntheticTemplate is a chain of log_message steps, which exercise the queue and
+// the executor without reaching other services
+func syntheticTemplate(steps int) map[string]any {
+	definitions := make([]map[string]any, steps)
+	for i := range definitions {
+		definitions[i] = map[string]any{
+			"id":   fmt.Sprintf("step_%d", i+1),
+			"name": fmt.Sprintf("Step %d", i+1),
+			"type": "action",
+			"config": map[string]any{
+				"action":  "log_message",
+				"message": fmt.Sprintf("loadtest step %d", i+1),
+				"level":   "debug",
+			},
+		}
+		if i+1 < steps {
+			definitions[i]["next_steps"] = []string{fmt.Sprintf("step_%d", i+2)}
+		}
+	}
+	return map[string]any{
+		"name":        fmt.Sprintf("loadtest %s", time.Now().UTC().Format(time.RFC3339)),
+		"description": "Synthetic template created by cmd/loadtest",
+		"category":    "loadtest",
+		"schema":      map[string]any{"steps": definitions},
+	}
+}
+
+// runEngine creates a synthetic template and then creates and starts instances of
+// it at the configured rate, recording create, start and, with -wait, finish: the
+// time from creating an instance to seeing it completed
+func runEngine(ctx context.Context, opts options, engine engineOptions, report *Report) error {
+	if engine.rate <= 0 {
+		return errors.New("-rate must be positive")
+	}
+	if engine.steps < 1 {
+		return errors.New("-steps must be at least 1")
+	}
+
+	client := &engineClient{
+		baseURL: strings.TrimSuffix(engine.url, "/"),
+		apiKey:  engine.apiKey,
+		http: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				MaxIdleConns:        engine.concurrency,
+				MaxIdleConnsPerHost: engine.concurrency,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}
+	if client.apiKey == "" {
+		token, err := opts.token(0)
+		if err != nil {
+			return fmt.Errorf("failed to issue a token: %w", err)
+		}
+		client.token = token
+	}
+
+	var template struct {
+		ID string `json:"id"`
+	}
+	if _, err := client.do(ctx, http.MethodPost, "/api/v1/templates", syntheticTemplate(engine.steps), &template); err != nil {
+		return fmt.Errorf("failed to create the synthetic template: %w", err)
+	}
+	log.Printf("Created template %s", template.ID)
+	if !engine.keepTemplate {
+		defer func() {
+			cleanup, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if _, err := client.do(cleanup, http.MethodDelete, "/api/v1/templates/"+template.ID, nil, nil); err != nil {
+				log.Printf("Failed to delete template %s: %v", template.ID, err)
+			}
+		}()
+	}
+
+	create, start, finish := newRecorder(), newRecorder(), newRecorder()
+	var inFlight, peakInFlight, skipped atomic.Int64
+	var wg sync.WaitGroup
+
+	runInstance := func(n int) {
+		defer wg.Done()
+		defer inFlight.Add(-1)
+
+		began := time.Now()
+		var instance struct {
+			ID string `json:"id"`
+		}
+		body := map[string]any{
+			"template_id": template.ID,
+			"name":        fmt.Sprintf("loadtest %d", n),
+			"is_test":     true,
+		}
+		if kind, err := client.do(ctx, http.MethodPost, "/api/v1/instances", body, &instance); err != nil {
+			create.Failure(kind)
+			return
+		}
+		create.Success(time.Since(began))
+
+		started := time.Now()
+		if kind, err := client.do(ctx, http.MethodPut, "/api/v1/instances/"+instance.ID+"/start", nil, nil); err != nil {
+			start.Failure(kind)
+			return
+		}
+		start.Success(time.Since(started))
+
+		if engine.wait {
+			waitForInstance(ctx, client, engine, instance.ID, began, finish)
+		}
+	}
+
+	loadCtx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+	go progress(loadCtx, func() string {
+		return fmt.Sprintf("instances: %d created, %d in flight, %d skipped", create.Count(), inFlight.Load(), skipped.Load())
+	})
+
+	pace := pacer{rate: engine.rate, rampUp: opts.rampUp, start: time.Now()}
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	launched := 0
+launch:
+	for {
+		select {
+		case <-loadCtx.Done():
+			break launch
+		case now := <-ticker.C:
+			for due := pace.due(now); launched < due; launched++ {
+				if engine.instances > 0 && launched >= engine.instances {
+					break launch
+				}
+				if inFlight.Load() >= int64(engine.concurrency) {
+					skipped.Add(1)
+					continue
+				}
+				peakInFlight.Store(max(peakInFlight.Load(), inFlight.Add(1)))
+				wg.Add(1)
+				go runInstance(launched)
+			}
+		}
+	}
+	elapsed := time.Since(pace.start)
+	log.Printf("Waiting for %d instances in flight", inFlight.Load())
+	wg.Wait()
+
+	report.DurationSeconds = round(elapsed.Seconds())
+	report.Operations = map[string]OperationResult{
+		"create": create.Result(elapsed),
+		"start":  start.Result(elapsed),
+	}
+	if engine.wait {
+		report.Operations["finish"] = finish.Result(elapsed)
+	}
+	report.Peaks = map[string]int64{
+		"in_flight": peakInFlight.Load(),
+		"skipped":   skipped.Load(),
+	}
+	return nil
+}
+
+// waitForInstance polls the status of an instance until it ends or the finish
+// timeout passes, recording the time since it was created
+func waitForInstance(ctx context.Context, client *engineClient, engine engineOptions, instanceID string, began time.Time, finish *Recorder) {
+	deadline := time.Now().Add(engine.finishTimeout)
+	for {
+		var status struct {
+			Status string `json:"status"`
+		}
+		if kind, err := client.do(ctx, http.MethodGet, "/api/v1/instances/"+instanceID+"/status", nil, &status); err != nil {
+			finish.Failure(kind)
+			return
+		}
+		switch status.Status {
+		case "completed":
+			finish.Success(time.Since(began))
+			return
+		case "failed", "cancelled":
+			finish.Failure("instance_" + status.Status)
+			return
+		}
+		if time.Now().After(deadline) {
+			finish.Failure("timeout")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			finish.Failure("interrupted")
+			return
+		case <-time.After(engine.pollInterval):
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"chorus/pkg/apierror"
+	"chorus/websocket-gateway/hub"
+)
+
+type gatewayOptions struct {
+	url          string
+	connections  int
+	channels     int
+	messageRate  float64
+	messageBytes int
+}
+
+// How long a connection waits for the replies to its last frames before closing
+const replyGrace = 2 * time.Second
+
+// published is the data of the frames the load test publishes
+type published struct {
+	SentAt  int64  `json:"sent_at"` // unix nanoseconds
+	From    int    `json:"from"`    // number of the connection
+	Padding string `json:"padding,omitempty"`
+}
+
+// gatewayRun is the state of a gateway load test shared by its connections
+type gatewayRun struct {
+	opts    options
+	gateway gatewayOptions
+	started time.Time
+	prefix  string // of the run's channels
+
+	connect, join, publish, deliver *Recorder
+
+	open, peakOpen, dropped atomic.Int64
+}
+
+// runGateway opens the connections over the ramp-up, has each join its channel and
+// publish to it until the duration is over, and records connect, join and publish,
+// the time until the gateway answers, and deliver, the time from publishing a frame
+// to each member receiving it
+func runGateway(ctx context.Context, opts options, gateway gatewayOptions, report *Report) error {
+	if gateway.connections < 1 || gateway.channels < 1 {
+		return errors.New("-connections and -channels must be at least 1")
+	}
+	if gateway.messageRate < 0 {
+		return errors.New("-message-rate must not be negative")
+	}
+
+	run := &gatewayRun{
+		opts:    opts,
+		gateway: gateway,
+		started: time.Now(),
+		prefix:  fmt.Sprintf("loadtest:%d:", time.Now().Unix()),
+		connect: newRecorder(),
+		join:    newRecorder(),
+		publish: newRecorder(),
+		deliver: newRecorder(),
+	}
+
+	loadCtx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+	go progress(loadCtx, func() string {
+		return fmt.Sprintf("connections: %d open, %d dropped; %d published, %d delivered",
+			run.open.Load(), run.dropped.Load(), run.publish.Count(), run.deliver.Count())
+	})
+
+	var wg sync.WaitGroup
+	for n := 0; n < gateway.connections; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run.connection(loadCtx, n)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(run.started)
+
+	report.DurationSeconds = round(elapsed.Seconds())
+	report.Operations = map[string]OperationResult{
+		"connect": run.connect.Result(elapsed),
+		"join":    run.join.Result(elapsed),
+		"publish": run.publish.Result(elapsed),
+		"deliver": run.deliver.Result(elapsed),
+	}
+	report.Peaks = map[string]int64{
+		"open_connections": run.peakOpen.Load(),
+		"dropped":          run.dropped.Load(),
+	}
+	return nil
+}
+
+// connection runs connection n: it opens at its share of the ramp-up, joins its
+// channel, publishes until ctx is done and then closes
+func (run *gatewayRun) connection(ctx context.Context, n int) {
+	opensAt := run.started.Add(run.opts.rampUp * time.Duration(n) / time.Duration(run.gateway.connections))
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Until(opensAt)):
+	}
+
+	conn, ok := run.dial(ctx, n)
+	if !ok {
+		return
+	}
+	defer conn.Close()
+	run.peakOpen.Store(max(run.peakOpen.Load(), run.open.Add(1)))
+	defer run.open.Add(-1)
+
+	var mu sync.Mutex
+	pending := make(map[string]time.Time) // frames sent and not answered yet, by ID
+	joined := make(chan struct{})
+	closing := make(chan struct{})
+	done := make(chan struct{})
+
+	answered := func(id string) (time.Time, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		sentAt, ok := pending[id]
+		delete(pending, id)
+		return sentAt, ok
+	}
+
+	go func() {
+		defer close(done)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				select {
+				case <-closing:
+				default:
+					run.dropped.Add(1)
+				}
+				return
+			}
+			var frame hub.ServerFrame
+			if json.Unmarshal(data, &frame) != nil {
+				continue
+			}
+			switch frame.Type {
+			case hub.FrameJoined:
+				if sentAt, ok := answered(frame.ID); ok {
+					run.join.Success(time.Since(sentAt))
+					close(joined)
+				}
+			case hub.FramePublished:
+				if sentAt, ok := answered(frame.ID); ok {
+					run.publish.Success(time.Since(sentAt))
+				}
+			case hub.FrameError:
+				if _, ok := answered(frame.ID); ok {
+					if strings.HasPrefix(frame.ID, "join") {
+						run.join.Failure(frame.Code)
+					} else {
+						run.publish.Failure(frame.Code)
+					}
+				}
+			case hub.FrameMessage:
+				var message published
+				if json.Unmarshal(frame.Data, &message) == nil && message.SentAt > 0 {
+					run.deliver.Success(time.Since(time.Unix(0, message.SentAt)))
+				}
+			}
+		}
+	}()
+
+	send := func(id, frameType string, payload any) error {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		envelope, err := json.Marshal(hub.Envelope{V: hub.ProtocolVersion, Type: frameType, ID: id, Payload: encoded})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		pending[id] = time.Now()
+		mu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteMessage(websocket.TextMessage, envelope)
+	}
+
+	channel := fmt.Sprintf("%s%d", run.prefix, n%run.gateway.channels)
+	if err := send("join", hub.ActionJoin, hub.JoinPayload{Channel: channel}); err != nil {
+		run.join.Failure("write")
+		return
+	}
+
+	publishing := run.gateway.messageRate > 0
+	select {
+	case <-joined:
+	case <-done:
+		publishing = false
+	case <-ctx.Done():
+		publishing = false
+	case <-time.After(10 * time.Second):
+		if _, ok := answered("join"); ok {
+			run.join.Failure("timeout")
+		}
+		publishing = false
+	}
+
+	if publishing {
+		interval := time.Duration(float64(time.Second) / run.gateway.messageRate)
+		padding := strings.Repeat("x", max(run.gateway.messageBytes-64, 0))
+		// Spread the connections' frames over the interval rather than in bursts
+		timer := time.NewTimer(rand.N(interval))
+		defer timer.Stop()
+		for seq := 0; ; seq++ {
+			select {
+			case <-ctx.Done():
+			case <-done:
+			case <-timer.C:
+				timer.Reset(interval)
+				message := published{SentAt: time.Now().UnixNano(), From: n, Padding: padding}
+				if err := send(fmt.Sprintf("publish-%d", seq), hub.ActionPublish, hub.PublishPayload{Channel: channel, Data: mustJSON(message)}); err != nil {
+					run.publish.Failure("write")
+					break
+				}
+				continue
+			}
+			break
+		}
+	} else {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+	}
+
+	// Give the last frames their replies, then count the rest as unanswered
+	deadline := time.Now().Add(replyGrace)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		left := len(pending)
+		mu.Unlock()
+		if left == 0 {
+			break
+		}
+		select {
+		case <-done:
+			deadline = time.Now()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	close(closing)
+	mu.Lock()
+	for id := range pending {
+		if strings.HasPrefix(id, "publish") {
+			run.publish.Failure("no_reply")
+		}
+	}
+	mu.Unlock()
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+}
+
+// dial opens connection n with a token of its user, recording how long the upgrade
+// took or why it failed
+func (run *gatewayRun) dial(ctx context.Context, n int) (*websocket.Conn, bool) {
+	token, err := run.opts.token(n)
+	if err != nil {
+		log.Printf("Failed to issue a token: %v", err)
+		run.connect.Failure("token")
+		return nil, false
+	}
+	target, err := url.Parse(run.gateway.url)
+	if err != nil {
+		run.connect.Failure("url")
+		return nil, false
+	}
+	query := target.Query()
+	query.Set("token", token)
+	target.RawQuery = query.Encode()
+
+	dialer := websocket.Dialer{
+		HandshakeTimeout: 10 * time.Second,
+		Subprotocols:     []string{hub.SubprotocolJSON},
+	}
+	began := time.Now()
+	conn, resp, err := dialer.DialContext(ctx, target.String(), nil)
+	if err != nil {
+		switch {
+		case resp != nil:
+			var refusal apierror.Error
+			json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&refusal)
+			resp.Body.Close()
+			run.connect.Failure(errorKind(resp.StatusCode, refusal.Code))
+		case ctx.Err() != nil:
+			// The run ended while connecting
+		case errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "timeout"):
+			run.connect.Failure("timeout")
+		default:
+			run.connect.Failure("transport")
+		}
+		return nil, false
+	}
+	run.connect.Success(time.Since(began))
+	return conn, true
+}
+
+func mustJSON(value any) json.RawMessage {
+	data, _ := json.Marshal(value)
+	return data
+}
@@ -0,0 +1,170 @@
+// Command loadtest measures how much load one instance of the workflow engine or the
+// websocket gateway takes, and writes the latencies, error rates and throughput it
+// saw as JSON, so runs can be diffed.
+//
+// The engine scenario creates a synthetic template, then creates and starts
+// instances of it at -rate per second through the engine API, optionally waiting for
+// each to finish:
+//
+//	go run . -scenario engine -engine-url http://localhost:8080 -rate 20 -duration 2m -ramp-up 30s -wait
+//
+// The gateway scenario opens -connections WebSocket connections, spread over
+// -channels channels, each of which publishes to its channel at -message-rate per
+// second:
+//
+//	go run . -scenario gateway -gateway-url ws://localhost:8082/ws -connections 2000 -channels 50 -message-rate 0.5 -duration 5m
+//
+// Tokens for users loadtest-0, loadtest-1, ... are signed like the services check
+// them, from JWT_SECRET, JWT_ISSUER and JWT_AUDIENCE; -api-key authenticates to the
+// engine instead.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"chorus/pkg/auth"
+)
+
+// Report is the JSON a run writes
+type Report struct {
+	Scenario        string                     `json:"scenario"`
+	StartedAt       time.Time                  `json:"started_at"`
+	DurationSeconds float64                    `json:"duration_seconds"`
+	Settings        map[string]string          `json:"settings"` // flags of the run, to compare like with like
+	Operations      map[string]OperationResult `json:"operations"`
+	Peaks           map[string]int64           `json:"peaks,omitempty"` // such as the most connections open at once
+}
+
+// options are the flags shared by the scenarios
+type options struct {
+	duration   time.Duration
+	rampUp     time.Duration
+	userPrefix string
+	tokenTTL   time.Duration
+	authConfig auth.Config
+}
+
+// token signs a token for the user numbered n
+func (o options) token(n int) (string, error) {
+	return auth.Issue(o.authConfig, fmt.Sprintf("%s%d", o.userPrefix, n), o.tokenTTL, nil)
+}
+
+func main() {
+	scenario := flag.String("scenario", "", "engine or gateway")
+	duration := flag.Duration("duration", time.Minute, "how long to generate load, ramp-up included")
+	rampUp := flag.Duration("ramp-up", 10*time.Second, "time to grow from no load to the full rate or connection count")
+	out := flag.String("out", "", "file to write the JSON results to, default stdout")
+	userPrefix := flag.String("user-prefix", "loadtest-", "prefix of the user IDs tokens are issued for")
+	tokenTTL := flag.Duration("token-ttl", time.Hour, "lifetime of the issued tokens; keep it above -duration")
+
+	var engine engineOptions
+	flag.StringVar(&engine.url, "engine-url", "http://localhost:8080", "workflow engine base URL")
+	flag.StringVar(&engine.apiKey, "api-key", "", "engine API key sent as X-API-Key instead of a token")
+	flag.Float64Var(&engine.rate, "rate", 10, "instances created per second")
+	flag.IntVar(&engine.instances, "instances", 0, "stop after creating this many instances, 0 for no limit")
+	flag.IntVar(&engine.concurrency, "concurrency", 200, "most instances in flight at once; creations beyond it are skipped")
+	flag.IntVar(&engine.steps, "steps", 3, "log_message steps in the synthetic template")
+	flag.BoolVar(&engine.wait, "wait", false, "poll each instance until it finishes and record the time to finish")
+	flag.DurationVar(&engine.pollInterval, "poll-interval", 250*time.Millisecond, "how often to poll an instance with -wait")
+	flag.DurationVar(&engine.finishTimeout, "finish-timeout", time.Minute, "how long to wait for an instance to finish with -wait")
+	flag.BoolVar(&engine.keepTemplate, "keep-template", false, "leave the synthetic template in place after the run")
+
+	var gateway gatewayOptions
+	flag.StringVar(&gateway.url, "gateway-url", "ws://localhost:8082/ws", "websocket gateway endpoint")
+	flag.IntVar(&gateway.connections, "connections", 100, "WebSocket connections to open")
+	flag.IntVar(&gateway.channels, "channels", 10, "channels the connections are spread over")
+	flag.Float64Var(&gateway.messageRate, "message-rate", 1, "frames each connection publishes per second, 0 to only hold connections")
+	flag.IntVar(&gateway.messageBytes, "message-bytes", 128, "approximate size of the data of each published frame")
+	flag.Parse()
+
+	opts := options{
+		duration:   *duration,
+		rampUp:     min(*rampUp, *duration),
+		userPrefix: *userPrefix,
+		tokenTTL:   *tokenTTL,
+		authConfig: auth.ConfigFromEnv(),
+	}
+	if opts.duration <= 0 {
+		log.Fatal("-duration must be positive")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report := &Report{
+		Scenario:  *scenario,
+		StartedAt: time.Now().UTC(),
+		Settings:  settings(),
+	}
+	var err error
+	switch *scenario {
+	case "engine":
+		err = runEngine(ctx, opts, engine, report)
+	case "gateway":
+		err = runGateway(ctx, opts, gateway, report)
+	default:
+		log.Fatal("-scenario must be engine or gateway")
+	}
+	if err != nil {
+		log.Fatalf("Load test failed: %v", err)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to encode results: %v", err)
+	}
+	data = append(data, '\n')
+	if *out == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		log.Fatalf("Failed to write results: %v", err)
+	}
+}
+
+// settings lists the flags of the run, leaving out the API key
+func settings() map[string]string {
+	values := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "api-key" {
+			if f.Value.String() != "" {
+				values[f.Name] = "<set>"
+			}
+			return
+		}
+		values[f.Name] = f.Value.String()
+	})
+	return values
+}
+
+// progress logs a line every 10 seconds until ctx is done
+func progress(ctx context.Context, line func() string) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			log.Print(line())
+		}
+	}
+}
+
+// errorKind names a failed request by the code of its error response, or its status
+func errorKind(status int, code string) string {
+	if code != "" {
+		return strings.ToLower(code)
+	}
+	return fmt.Sprintf("http_%d", status)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Recorder collects the outcomes of one kind of operation, such as creating an
+// instance or publishing a frame
+type Recorder struct {
+	mu        sync.Mutex
+	latencies []float64 // of successful operations, in milliseconds
+	errors    map[string]int
+}
+
+func newRecorder() *Recorder {
+	return &Recorder{errors: make(map[string]int)}
+}
+
+// Success records an operation that took latency
+func (r *Recorder) Success(latency time.Duration) {
+	r.mu.Lock()
+	r.latencies = append(r.latencies, float64(latency.Microseconds())/1000)
+	r.mu.Unlock()
+}
+
+// Failure records an operation that failed with an error of kind, such as an API
+// error code or "timeout"
+func (r *Recorder) Failure(kind string) {
+	r.mu.Lock()
+	r.errors[kind]++
+	r.mu.Unlock()
+}
+
+// Count returns how many operations were recorded so far
+func (r *Recorder) Count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := len(r.latencies)
+	for _, errors := range r.errors {
+		count += errors
+	}
+	return count
+}
+
+// OperationResult sums up a Recorder over a run
+type OperationResult struct {
+	Count      int            `json:"count"` // successes and failures
+	Errors     int            `json:"errors"`
+	ErrorRate  float64        `json:"error_rate"`
+	Throughput float64        `json:"throughput_per_second"` // successes
+	Latency    LatencyResult  `json:"latency_ms"`
+	ErrorKinds map[string]int `json:"error_kinds,omitempty"`
+}
+
+// LatencyResult are the percentiles of successful operations, in milliseconds
+type LatencyResult struct {
+	P50  float64 `json:"p50"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+	Mean float64 `json:"mean"`
+}
+
+// Result sums up the operations recorded over elapsed
+func (r *Recorder) Result(elapsed time.Duration) OperationResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := OperationResult{ErrorKinds: make(map[string]int, len(r.errors))}
+	for kind, count := range r.errors {
+		result.Errors += count
+		result.ErrorKinds[kind] = count
+	}
+	result.Count = len(r.latencies) + result.Errors
+	if result.Count > 0 {
+		result.ErrorRate = round(float64(result.Errors) / float64(result.Count))
+	}
+	if elapsed > 0 {
+		result.Throughput = round(float64(len(r.latencies)) / elapsed.Seconds())
+	}
+
+	if len(r.latencies) == 0 {
+		return result
+	}
+	sorted := slices.Clone(r.latencies)
+	slices.Sort(sorted)
+	var sum float64
+	for _, latency := range sorted {
+		sum += latency
+	}
+	result.Latency = LatencyResult{
+		P50:  round(percentile(sorted, 50)),
+		P95:  round(percentile(sorted, 95)),
+		P99:  round(percentile(sorted, 99)),
+		Max:  round(sorted[len(sorted)-1]),
+		Mean: round(sum / float64(len(sorted))),
+	}
+	return result
+}
+
+// percentile returns the nearest-rank percentile p of sorted, which is not empty
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// round keeps three decimals, which is microseconds for latencies and enough for
+// rates, so that results diff cleanly
+func round(value float64) float64 {
+	return math.Round(value*1000) / 1000
+}
+
+// pacer spreads operations at rate per second, ramping up linearly from nothing
+// over rampUp
+type pacer struct {
+	rate   float64
+	rampUp time.Duration
+	start  time.Time
+}
+
+// due returns how many operations should have started by now
+func (p pacer) due(now time.Time) int {
+	elapsed := now.Sub(p.start).Seconds()
+	ramp := p.rampUp.Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	if elapsed < ramp {
+		// The area under a rate growing from 0 to p.rate
+		return int(p.rate * elapsed * elapsed / (2 * ramp))
+	}
+	return int(p.rate*ramp/2 + p.rate*(elapsed-ramp))
+}
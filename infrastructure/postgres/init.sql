@@ -27,10 +27,16 @@ CREATE TABLE workflow.templates (
     schema JSONB NOT NULL,
     metadata JSONB DEFAULT '{}',
     is_active BOOLEAN DEFAULT true,
+    -- Existing templates stay visible to everyone; the API creates private ones by default
+    visibility VARCHAR(20) NOT NULL DEFAULT 'public',
+    owners JSONB DEFAULT '[]',
+    team VARCHAR(255),
+    encrypt_variables BOOLEAN DEFAULT false,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     created_by VARCHAR(255),
-    CONSTRAINT unique_template_name_version UNIQUE (name, version)
+    CONSTRAINT unique_template_name_version UNIQUE (name, version),
+    CONSTRAINT check_template_visibility CHECK (visibility IN ('private', 'team', 'public'))
 );
 
 -- Workflow Instances table
@@ -48,6 +54,18 @@ CREATE TABLE workflow.instances (
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     created_by VARCHAR(255),
+    -- Org of the creator's token, whose members may follow the instance
+    org_id VARCHAR(255),
+    rerun_of UUID REFERENCES workflow.instances(id) ON DELETE SET NULL,
+    is_test BOOLEAN DEFAULT false,
+    queued_at TIMESTAMP WITH TIME ZONE,
+    queue_wait_ms BIGINT DEFAULT 0,
+    timing JSONB,
+    trace_parent VARCHAR(55),
+    frontier JSONB,
+    -- Context and variables are sealed under encryption_key_id when encrypted
+    encrypted BOOLEAN DEFAULT false,
+    encryption_key_id VARCHAR(100),
     CONSTRAINT check_status CHECK (status IN ('pending', 'running', 'completed', 'failed', 'cancelled', 'paused'))
 );
 
@@ -61,12 +79,19 @@ CREATE TABLE workflow.steps (
     input_data JSONB DEFAULT '{}',
     output_data JSONB DEFAULT '{}',
     error_data JSONB,
+    definition_hash VARCHAR(64),
+    attempts JSONB DEFAULT '[]',
     started_at TIMESTAMP WITH TIME ZONE,
     completed_at TIMESTAMP WITH TIME ZONE,
     retry_count INTEGER DEFAULT 0,
+    execution_ms BIGINT DEFAULT 0,
+    retry_delay_ms BIGINT DEFAULT 0,
+    warnings JSONB DEFAULT '[]',
+    encrypted BOOLEAN DEFAULT false,
+    encryption_key_id VARCHAR(100),
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-    CONSTRAINT check_step_status CHECK (status IN ('pending', 'running', 'completed', 'failed', 'skipped'))
+    CONSTRAINT check_step_status CHECK (status IN ('pending', 'running', 'completed', 'failed', 'skipped', 'waiting'))
 );
 
 -- Workflow Triggers table
@@ -77,9 +102,175 @@ CREATE TABLE workflow.triggers (
     trigger_config JSONB NOT NULL,
     is_active BOOLEAN DEFAULT true,
     last_triggered_at TIMESTAMP WITH TIME ZONE,
+    metadata JSONB DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT check_trigger_type CHECK (trigger_type IN ('manual', 'schedule', 'event', 'webhook', 'condition', 'presence'))
+);
+
+-- Workflow Instance Comments table
+CREATE TABLE workflow.instance_comments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    instance_id UUID NOT NULL REFERENCES workflow.instances(id) ON DELETE CASCADE,
+    author VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Offloaded step payloads (inputs/outputs above MAX_STEP_PAYLOAD_SIZE)
+CREATE TABLE workflow.step_payloads (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    step_id UUID NOT NULL REFERENCES workflow.steps(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL,
+    data JSONB NOT NULL,
+    size_bytes INTEGER NOT NULL,
+    encrypted BOOLEAN DEFAULT false,
+    encryption_key_id VARCHAR(100),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT check_payload_kind CHECK (kind IN ('input', 'output'))
+);
+
+-- Reusable step lists referenced by template schemas and expanded when a template is saved
+CREATE TABLE workflow.snippets (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(255) NOT NULL,
+    version VARCHAR(50) NOT NULL DEFAULT '1.0.0',
+    description TEXT,
+    parameters JSONB DEFAULT '[]',
+    steps JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    created_by VARCHAR(255),
+    CONSTRAINT idx_snippet_name_version UNIQUE (name, version)
+);
+
+-- Renamed webhook slugs that keep resolving to their trigger until they expire
+CREATE TABLE workflow.webhook_slug_redirects (
+    slug VARCHAR(100) PRIMARY KEY,
+    trigger_id UUID NOT NULL REFERENCES workflow.triggers(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Service API keys (only hashes are stored)
+CREATE TABLE workflow.api_keys (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(32) NOT NULL UNIQUE,
+    key_hash VARCHAR(64) NOT NULL,
+    role VARCHAR(100) NOT NULL DEFAULT 'service',
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Managed template categories; templates store the slug
+CREATE TABLE workflow.template_categories (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    slug VARCHAR(100) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Published workflow events, kept for EVENT_RETENTION_HOURS so they can be replayed
+CREATE TABLE workflow.engine_events (
+    id UUID PRIMARY KEY,
+    type VARCHAR(255) NOT NULL,
+    instance_id UUID,
+    template_id UUID,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Jobs republishing kept events, with their progress
+CREATE TABLE workflow.event_replays (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    status VARCHAR(20) NOT NULL,
+    filter JSONB NOT NULL,
+    matched BIGINT DEFAULT 0,
+    replayed BIGINT DEFAULT 0,
+    error TEXT,
+    requested_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    CONSTRAINT check_event_replay_status CHECK (status IN ('running', 'completed', 'failed', 'cancelled'))
+);
+
+-- Outbound webhooks notified of the lifecycle events of a template
+CREATE TABLE workflow.template_webhooks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    template_id UUID NOT NULL REFERENCES workflow.templates(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events JSONB DEFAULT '[]',
+    is_active BOOLEAN DEFAULT true,
+    failure_rate_threshold NUMERIC DEFAULT 0,
+    failure_window_minutes INTEGER DEFAULT 60,
+    failure_min_instances INTEGER DEFAULT 10,
+    failure_alerting BOOLEAN DEFAULT false,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Events sent, or being sent, to template webhooks
+CREATE TABLE workflow.template_webhook_deliveries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    webhook_id UUID NOT NULL REFERENCES workflow.template_webhooks(id) ON DELETE CASCADE,
+    template_id UUID NOT NULL REFERENCES workflow.templates(id) ON DELETE CASCADE,
+    event VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER DEFAULT 0,
+    response_status INTEGER DEFAULT 0,
+    error TEXT,
+    lease_until TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_attempt_at TIMESTAMP WITH TIME ZONE,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    CONSTRAINT check_delivery_status CHECK (status IN ('pending', 'delivered', 'failed'))
+);
+
+-- Tasks opened by manual steps, in their assignee's inbox
+CREATE TABLE workflow.tasks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    instance_id UUID NOT NULL REFERENCES workflow.instances(id) ON DELETE CASCADE,
+    template_id UUID NOT NULL REFERENCES workflow.templates(id),
+    step_record_id UUID NOT NULL REFERENCES workflow.steps(id) ON DELETE CASCADE,
+    step_id VARCHAR(255) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    instructions TEXT,
+    actions JSONB DEFAULT '[]',
+    assignee VARCHAR(255) NOT NULL,
+    fallback_assignee VARCHAR(255),
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    context JSONB DEFAULT '{}',
+    due_at TIMESTAMP WITH TIME ZONE,
+    escalated_at TIMESTAMP WITH TIME ZONE,
+    resolved_by VARCHAR(255),
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    comment TEXT,
+    data JSONB,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-    CONSTRAINT check_trigger_type CHECK (trigger_type IN ('manual', 'schedule', 'event', 'webhook', 'condition'))
+    CONSTRAINT check_task_status CHECK (status IN ('open', 'approved', 'rejected', 'completed', 'cancelled'))
+);
+
+-- Audit trail of the tasks
+CREATE TABLE workflow.task_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    task_id UUID NOT NULL REFERENCES workflow.tasks(id) ON DELETE CASCADE,
+    event VARCHAR(50) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    from_assignee VARCHAR(255),
+    to_assignee VARCHAR(255),
+    status VARCHAR(20),
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
 
 -- =====================================================
@@ -294,13 +485,31 @@ CREATE TABLE public.audit_log (
 -- =====================================================
 
 -- Workflow indexes
+CREATE INDEX idx_workflow_templates_owners ON workflow.templates USING GIN (owners);
 CREATE INDEX idx_workflow_instances_template_id ON workflow.instances(template_id);
 CREATE INDEX idx_workflow_instances_status ON workflow.instances(status);
 CREATE INDEX idx_workflow_instances_created_at ON workflow.instances(created_at DESC);
+CREATE INDEX idx_workflow_instances_org_id ON workflow.instances(org_id) WHERE org_id IS NOT NULL;
+CREATE INDEX idx_workflow_instances_rerun_of ON workflow.instances(rerun_of) WHERE rerun_of IS NOT NULL;
 CREATE INDEX idx_workflow_steps_instance_id ON workflow.steps(instance_id);
 CREATE INDEX idx_workflow_steps_status ON workflow.steps(status);
+CREATE INDEX idx_workflow_steps_presence_wait ON workflow.steps ((output_data->'presence_wait'->>'user_id')) WHERE status = 'waiting';
+CREATE INDEX idx_workflow_instance_comments_instance_id ON workflow.instance_comments(instance_id, created_at);
+CREATE INDEX idx_workflow_step_payloads_step_id ON workflow.step_payloads(step_id, kind);
+CREATE INDEX idx_workflow_instances_test ON workflow.instances(created_at) WHERE is_test = true;
 CREATE INDEX idx_workflow_triggers_template_id ON workflow.triggers(template_id);
 CREATE INDEX idx_workflow_triggers_active ON workflow.triggers(is_active) WHERE is_active = true;
+CREATE UNIQUE INDEX idx_workflow_triggers_webhook_slug ON workflow.triggers ((trigger_config->>'slug')) WHERE trigger_type = 'webhook';
+CREATE INDEX idx_engine_events_created_at ON workflow.engine_events(created_at, id);
+CREATE INDEX idx_workflow_template_webhooks_template_id ON workflow.template_webhooks(template_id);
+CREATE INDEX idx_workflow_template_webhook_deliveries_webhook_id ON workflow.template_webhook_deliveries(webhook_id, created_at DESC);
+CREATE INDEX idx_workflow_template_webhook_deliveries_pending ON workflow.template_webhook_deliveries(lease_until) WHERE status = 'pending';
+CREATE INDEX idx_workflow_template_webhook_deliveries_created_at ON workflow.template_webhook_deliveries(created_at);
+CREATE INDEX idx_workflow_tasks_assignee ON workflow.tasks(assignee, status);
+CREATE INDEX idx_workflow_tasks_instance_id ON workflow.tasks(instance_id);
+CREATE INDEX idx_workflow_tasks_step_record_id ON workflow.tasks(step_record_id);
+CREATE INDEX idx_workflow_tasks_due_at ON workflow.tasks(due_at) WHERE status = 'open' AND escalated_at IS NULL;
+CREATE INDEX idx_workflow_task_events_task_id ON workflow.task_events(task_id, created_at);
 
 -- Monitoring indexes
 CREATE INDEX idx_system_metrics_timestamp ON monitoring.system_metrics(timestamp DESC);
@@ -357,6 +566,12 @@ CREATE TRIGGER update_workflow_steps_updated_at BEFORE UPDATE ON workflow.steps
 CREATE TRIGGER update_workflow_triggers_updated_at BEFORE UPDATE ON workflow.triggers
     FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 
+CREATE TRIGGER update_workflow_template_webhooks_updated_at BEFORE UPDATE ON workflow.template_webhooks
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TRIGGER update_workflow_tasks_updated_at BEFORE UPDATE ON workflow.tasks
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
 CREATE TRIGGER update_alerts_updated_at BEFORE UPDATE ON monitoring.alerts
     FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 
@@ -389,6 +604,9 @@ CREATE TRIGGER update_users_updated_at BEFORE UPDATE ON public.users
 INSERT INTO public.users (username, email, full_name, role) 
 VALUES ('admin', 'admin@chorus.local', 'System Administrator', 'admin');
 
+-- Insert the category of the sample template
+INSERT INTO workflow.template_categories (slug, name) VALUES ('examples', 'Examples');
+
 -- Insert sample workflow template
 INSERT INTO workflow.templates (name, description, category, schema) 
 VALUES (
@@ -0,0 +1,49 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// Where the services of docker-compose.yml are published on the host
+const (
+	gatewayURL = "http://localhost:8080"
+	engineURL  = "http://localhost:8082"
+	jaegerURL  = "http://localhost:16686"
+)
+
+// composeFile is the repository's docker-compose.yml
+var composeFile = filepath.Join("..", "docker-compose.yml")
+
+// compose runs docker compose on composeFile with the extra environment env, such as
+// values the file interpolates, failing the test if it fails
+func compose(t *testing.T, env []string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("docker", append([]string{"compose", "-f", composeFile}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("docker compose %v: %v\n%s", args, err, output.String())
+	}
+}
+
+// startCompose builds and starts services, all of them without any, and waits until
+// they are running and healthy. They are taken down at the end of the test unless
+// CHORUS_KEEP_STACK is set.
+func startCompose(t *testing.T, services ...string) {
+	t.Helper()
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker is not installed")
+	}
+	compose(t, nil, append([]string{"up", "--detach", "--build", "--wait"}, services...)...)
+	if os.Getenv("CHORUS_KEEP_STACK") == "" {
+		t.Cleanup(func() { compose(t, nil, "down", "--volumes") })
+	}
+}
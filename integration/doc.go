@@ -0,0 +1,15 @@
+// Package integration holds the tests that exercise the Go services together rather
+// than one at a time. They need Docker and only build with the integration tag:
+//
+//	go test -tags=integration ./...
+//
+// Most tests start Postgres and Redis from the repository's docker-compose.yml and
+// run the workflow engine, the presence service and the gateway in the test's own
+// process, each on a loopback port. The tracing test brings up the whole compose
+// stack instead, to read the spans the containers export to Jaeger.
+//
+// Containers are taken down again when a test is done, unless CHORUS_KEEP_STACK is
+// set. JWT_SECRET must match the one the stack runs with, which is the compose
+// default unless set. CHORUS_TEST_LOG_LEVEL=info or debug shows more of the
+// in-process services' logs than their warnings.
+package integration
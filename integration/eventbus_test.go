@@ -0,0 +1,144 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"chorus/pkg/eventbus"
+)
+
+type busEvent struct {
+	N int `json:"n"`
+}
+
+// newBus returns a bus over a client of the compose stack's Redis
+func newBus(t *testing.T) (*eventbus.Bus, *redis.Client) {
+	t.Helper()
+	startCompose(t, "redis")
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := redis.NewClient(opt)
+	t.Cleanup(func() { client.Close() })
+	waitFor(t, 30*time.Second, "Redis", func() error { return client.Ping(context.Background()).Err() })
+	return eventbus.New(client, "integration", testLogger(), eventbus.Metrics()), client
+}
+
+// waitNumSub waits until channel has a subscriber
+func waitNumSub(t *testing.T, client *redis.Client, channel string) {
+	t.Helper()
+	waitFor(t, 10*time.Second, "a subscriber on "+channel, func() error {
+		subscribers, err := client.PubSubNumSub(context.Background(), channel).Result()
+		if err != nil {
+			return err
+		}
+		if subscribers[channel] == 0 {
+			return context.DeadlineExceeded
+		}
+		return nil
+	})
+}
+
+// A subscription whose connection Redis drops subscribes again and receives the
+// events published from then on
+func TestEventBusResubscribesOnRealRedis(t *testing.T) {
+	b, client := newBus(t)
+	topic := eventbus.Topic[busEvent]{Name: "integration:" + uuid.NewString(), Version: 1}
+
+	received := make(chan eventbus.Message[busEvent], 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go eventbus.Subscribe(ctx, b, topic, func(ctx context.Context, msg eventbus.Message[busEvent]) error {
+		received <- msg
+		return nil
+	})
+	waitNumSub(t, client, topic.Name)
+
+	if err := client.ClientKillByFilter(context.Background(), "TYPE", "pubsub").Err(); err != nil {
+		t.Fatalf("kill the subscription: %v", err)
+	}
+	waitNumSub(t, client, topic.Name)
+
+	if err := eventbus.Publish(context.Background(), b, topic, busEvent{N: 1}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if msg.Data.N != 1 || msg.Source != "integration" || msg.EventID == "" {
+			t.Errorf("received %+v, want event 1 from integration with an event_id", msg)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no event after resubscribing")
+	}
+}
+
+// The consumers of a group share the events of a stream, each handled once and
+// acknowledged
+func TestEventBusConsumerGroupOnRealRedis(t *testing.T) {
+	b, client := newBus(t)
+	topic := eventbus.Topic[busEvent]{
+		Name:    "integration:" + uuid.NewString(),
+		Version: 1,
+		Stream:  &eventbus.StreamOptions{MaxLen: 1000},
+	}
+	streamKey := topic.Name + ":stream"
+	const group = "integration"
+
+	var mu sync.Mutex
+	handledBy := map[int][]string{}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	for _, consumer := range []string{"consumer-1", "consumer-2"} {
+		go eventbus.Consume(ctx, b, topic, group, consumer, func(ctx context.Context, msg eventbus.Message[busEvent]) error {
+			mu.Lock()
+			handledBy[msg.Data.N] = append(handledBy[msg.Data.N], consumer)
+			mu.Unlock()
+			return nil
+		})
+	}
+	// The group starts at the end of the stream, so it must exist before publishing
+	waitFor(t, 10*time.Second, "group "+group, func() error {
+		_, err := client.XInfoGroups(context.Background(), streamKey).Result()
+		return err
+	})
+
+	const events = 20
+	for n := 0; n < events; n++ {
+		if err := eventbus.Publish(context.Background(), b, topic, busEvent{N: n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, 20*time.Second, "every event to be handled and acknowledged", func() error {
+		mu.Lock()
+		handled := len(handledBy)
+		mu.Unlock()
+		if handled < events {
+			return context.DeadlineExceeded
+		}
+		pending, err := client.XPending(context.Background(), streamKey, group).Result()
+		if err != nil {
+			return err
+		}
+		if pending.Count > 0 {
+			return context.DeadlineExceeded
+		}
+		return nil
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	for n, consumers := range handledBy {
+		if len(consumers) != 1 {
+			t.Errorf("event %d was handled by %v, want one consumer", n, consumers)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"chorus/pkg/auth"
+)
+
+// authConfig signs tokens the services accept: HS256 with JWT_SECRET, or the
+// development default the services fall back to
+func authConfig() auth.Config {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		secret = "your-secret-key"
+	}
+	return auth.Config{
+		Secrets:  []string{secret},
+		Issuer:   os.Getenv("JWT_ISSUER"),
+		Audience: os.Getenv("JWT_AUDIENCE"),
+	}
+}
+
+// mintToken returns a token for userID valid for an hour, with extra claims such as
+// role
+func mintToken(t *testing.T, userID string, extra map[string]any) string {
+	t.Helper()
+	token, err := auth.Issue(authConfig(), userID, time.Hour, extra)
+	if err != nil {
+		t.Fatalf("mint token for %s: %v", userID, err)
+	}
+	return token
+}
+
+// waitFor calls check until it returns nil, failing the test with its last error
+// once timeout has passed
+func waitFor(t *testing.T, timeout time.Duration, what string, check func() error) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out after %s waiting for %s: %v", timeout, what, err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// waitReady waits until GET url answers 200
+func waitReady(t *testing.T, url string) {
+	t.Helper()
+	waitFor(t, time.Minute, url, func() error {
+		resp, err := http.Get(url)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("status %d", resp.StatusCode)
+		}
+		return nil
+	})
+}
+
+// request sends method url with body encoded as JSON, if not nil, and the token as a
+// bearer token, and decodes a JSON answer into out, if not nil. It returns the
+// status, and an error for anything but a 2xx.
+func request(method, url, token string, body, out any) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%s %s: status %d: %s", method, url, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return resp.StatusCode, fmt.Errorf("%s %s: decode %s: %w", method, url, data, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// mustRequest is request failing the test on an error
+func mustRequest(t *testing.T, method, url, token string, body, out any) {
+	t.Helper()
+	if _, err := request(method, url, token, body, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createTemplate creates a public template named name with steps in the engine at
+// base and returns its ID
+func createTemplate(t *testing.T, base, token, name string, steps ...map[string]any) string {
+	t.Helper()
+	var template struct {
+		ID string `json:"id"`
+	}
+	mustRequest(t, http.MethodPost, base+"/api/v1/templates", token, map[string]any{
+		"name":       name,
+		"visibility": "public",
+		"schema":     map[string]any{"steps": steps},
+	}, &template)
+	return template.ID
+}
+
+// checkPresenceStep is a step asking the presence service for the status of userID
+func checkPresenceStep(userID string) map[string]any {
+	return map[string]any{
+		"id":   "check_presence",
+		"name": "Check presence",
+		"type": "action",
+		"config": map[string]any{
+			"action":  "check_presence",
+			"user_id": userID,
+		},
+	}
+}
+
+// workflowInstance is what the tests read of a workflow instance
+type workflowInstance struct {
+	Status       string `json:"status"`
+	ErrorMessage string `json:"error_message"`
+	TraceParent  string `json:"trace_parent"`
+}
+
+// waitCompleted waits until the instance instanceID of the engine at base has
+// completed and returns it, failing the test as soon as it fails
+func waitCompleted(t *testing.T, base, token, instanceID string) workflowInstance {
+	t.Helper()
+	var got workflowInstance
+	waitFor(t, 30*time.Second, "instance "+instanceID+" to complete", func() error {
+		if _, err := request(http.MethodGet, base+"/api/v1/instances/"+instanceID, token, nil, &got); err != nil {
+			return err
+		}
+		if got.Status == "failed" {
+			t.Fatalf("instance %s failed: %s", instanceID, got.ErrorMessage)
+		}
+		if got.Status != "completed" {
+			return fmt.Errorf("instance is %s", got.Status)
+		}
+		return nil
+	})
+	return got
+}
+
+// serverFrame is what the tests read of the frames the gateway sends
+type serverFrame struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id"`
+	Channel string          `json:"channel"`
+	Code    string          `json:"code"`
+	Error   string          `json:"error"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// dialGateway opens a WebSocket to the gateway at base with token, closed at the end
+// of the test
+func dialGateway(t *testing.T, base, token string) *websocket.Conn {
+	t.Helper()
+	target, err := url.Parse(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.Scheme = "ws"
+	target.Path = "/ws"
+	target.RawQuery = url.Values{"token": {token}}.Encode()
+	conn, _, err := websocket.DefaultDialer.Dial(target.String(), nil)
+	if err != nil {
+		t.Fatalf("dial gateway: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// triggerWorkflow sends a workflow.trigger frame for templateID on conn and returns
+// the ID of the instance created; with subscribe the connection follows the
+// instance's channel
+func triggerWorkflow(t *testing.T, conn *websocket.Conn, templateID string, subscribe bool) string {
+	t.Helper()
+	err := conn.WriteJSON(map[string]any{
+		"v":       1,
+		"type":    "workflow.trigger",
+		"id":      "trigger",
+		"payload": map[string]any{"template_id": templateID, "subscribe": subscribe},
+	})
+	if err != nil {
+		t.Fatalf("send workflow.trigger: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		var frame serverFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("waiting for workflow.triggered: %v", err)
+		}
+		if frame.ID != "trigger" {
+			continue
+		}
+		if frame.Type != "workflow.triggered" {
+			t.Fatalf("workflow.trigger answered with %s %s: %s", frame.Type, frame.Code, frame.Error)
+		}
+		var data struct {
+			InstanceID string `json:"instance_id"`
+		}
+		json.Unmarshal(frame.Data, &data)
+		if data.InstanceID == "" {
+			t.Fatal("workflow.triggered has no instance_id")
+		}
+		return data.InstanceID
+	}
+}
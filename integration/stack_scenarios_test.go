@@ -0,0 +1,144 @@
+//go:build integration
+
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"chorus/workflow-engine/models"
+)
+
+// A webhook call creates an instance of a template with a webhook trigger and runs
+// it to completion, asking the presence service along the way
+func TestWebhookTriggeredWorkflowCompletes(t *testing.T) {
+	s := startStack(t)
+	templateID := createTemplate(t, s.engine, s.admin, "webhook-"+uuid.NewString()[:8], checkPresenceStep("integration-user"))
+
+	// Webhook triggers have no endpoint of their own yet, so the test stores one as
+	// an operator would
+	trigger := models.WorkflowTrigger{
+		TemplateID:    uuid.MustParse(templateID),
+		TriggerType:   models.TriggerTypeWebhook,
+		TriggerConfig: models.JSONB{},
+		IsActive:      true,
+		Metadata:      models.JSONB{},
+	}
+	if err := s.db.Create(&trigger).Error; err != nil {
+		t.Fatalf("create webhook trigger: %v", err)
+	}
+
+	var triggered struct {
+		InstanceID string `json:"instance_id"`
+	}
+	status, err := request(http.MethodPost, s.engine+"/api/v1/triggers/webhook/"+templateID, s.admin, map[string]any{
+		"variables": map[string]any{"ticket": "T-1"},
+	}, &triggered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusCreated {
+		t.Fatalf("webhook answered %d, want 201", status)
+	}
+	waitCompleted(t, s.engine, s.admin, triggered.InstanceID)
+
+	var got models.WorkflowTrigger
+	if err := s.db.First(&got, "id = ?", trigger.ID).Error; err != nil {
+		t.Fatalf("load webhook trigger: %v", err)
+	}
+	if got.LastTriggeredAt == nil {
+		t.Error("webhook trigger has no last_triggered_at")
+	}
+}
+
+// A heartbeat puts its user in the online list and the status lookup, until the
+// user is taken offline
+func TestPresenceHeartbeatInOnlineList(t *testing.T) {
+	s := startStack(t)
+	userID := "integration-" + uuid.NewString()[:8]
+	user := mintToken(t, userID, nil)
+	service := mintToken(t, "integration-service", map[string]any{"role": "service"})
+
+	mustRequest(t, http.MethodPost, s.presence+"/presence/heartbeat", user, map[string]any{"device": "web"}, nil)
+	if !listedOnline(t, s, service, userID) {
+		t.Fatalf("%s is not in the online list after a heartbeat", userID)
+	}
+
+	var presence struct {
+		Status   string `json:"status"`
+		IsOnline bool   `json:"is_online"`
+	}
+	mustRequest(t, http.MethodGet, s.presence+"/presence/status?"+url.Values{"user_id": {userID}}.Encode(), service, nil, &presence)
+	if presence.Status != "online" || !presence.IsOnline {
+		t.Errorf("status of %s = %q, is_online %v, want online", userID, presence.Status, presence.IsOnline)
+	}
+
+	// An operator taking the user offline empties their place in the list
+	mustRequest(t, http.MethodDelete, s.presence+"/presence/users/"+userID+"?suppress=false", service, nil, nil)
+	waitFor(t, 10*time.Second, userID+" to leave the online list", func() error {
+		if listedOnline(t, s, service, userID) {
+			return fmt.Errorf("%s is still listed", userID)
+		}
+		return nil
+	})
+}
+
+// listedOnline reports whether userID is in the presence service's online list
+func listedOnline(t *testing.T, s *stack, token, userID string) bool {
+	t.Helper()
+	var online struct {
+		Users []struct {
+			UserID string `json:"user_id"`
+			Status string `json:"status"`
+		} `json:"users"`
+	}
+	mustRequest(t, http.MethodGet, s.presence+"/presence/online", token, nil, &online)
+	for _, user := range online.Users {
+		if user.UserID == userID {
+			return user.Status != "offline"
+		}
+	}
+	return false
+}
+
+// A user who triggers a workflow over WebSocket and subscribes to it receives the
+// engine's events of the instance as it runs
+func TestWorkflowEventsReachSubscriber(t *testing.T) {
+	s := startStack(t)
+	templateID := createTemplate(t, s.engine, s.admin, "events-"+uuid.NewString()[:8], checkPresenceStep("integration-user"))
+
+	userToken := mintToken(t, "integration-user", nil)
+	conn := dialGateway(t, s.gateway, userToken)
+	instanceID := triggerWorkflow(t, conn, templateID, true)
+	mustRequest(t, http.MethodPut, s.engine+"/api/v1/instances/"+instanceID+"/start", userToken, nil, nil)
+
+	channel := "workflow:instance:" + instanceID
+	want := map[string]bool{"instance_started": true, "step_completed": true}
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	for len(want) > 0 {
+		var frame serverFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("waiting for the events %v of %s: %v", want, instanceID, err)
+		}
+		if frame.Type != "message" || frame.Channel != channel {
+			continue
+		}
+		var event struct {
+			Type       string `json:"type"`
+			InstanceID string `json:"instance_id"`
+		}
+		if err := json.Unmarshal(frame.Data, &event); err != nil {
+			t.Fatalf("decode event %s: %v", frame.Data, err)
+		}
+		if event.InstanceID != instanceID {
+			t.Errorf("event %s on %s is of instance %s", event.Type, channel, event.InstanceID)
+		}
+		delete(want, event.Type)
+	}
+}
@@ -0,0 +1,127 @@
+//go:build integration
+
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Services a workflow triggered over WebSocket goes through
+var tracedServices = []string{"websocket-gateway", "workflow-engine", "presence-service"}
+
+// jaegerTrace is a trace as Jaeger's query API returns it
+type jaegerTrace struct {
+	Spans []struct {
+		SpanID        string `json:"spanID"`
+		OperationName string `json:"operationName"`
+		ProcessID     string `json:"processID"`
+		References    []struct {
+			RefType string `json:"refType"`
+			SpanID  string `json:"spanID"`
+		} `json:"references"`
+	} `json:"spans"`
+	Processes map[string]struct {
+		ServiceName string `json:"serviceName"`
+	} `json:"processes"`
+}
+
+// connected checks the trace has spans of every one of services and is a single
+// tree: one root, and the parent of every other span in the trace. It returns the
+// operation of the root.
+func (tr jaegerTrace) connected(services []string) (string, error) {
+	ids := map[string]bool{}
+	seen := map[string]bool{}
+	for _, span := range tr.Spans {
+		ids[span.SpanID] = true
+		seen[tr.Processes[span.ProcessID].ServiceName] = true
+	}
+	for _, service := range services {
+		if !seen[service] {
+			return "", fmt.Errorf("no span of %s yet", service)
+		}
+	}
+
+	var roots []string
+	for _, span := range tr.Spans {
+		parent := ""
+		for _, ref := range span.References {
+			if ref.RefType == "CHILD_OF" {
+				parent = ref.SpanID
+			}
+		}
+		switch {
+		case parent == "":
+			roots = append(roots, span.OperationName)
+		case !ids[parent]:
+			return "", fmt.Errorf("parent %s of %s %q is not in the trace", parent, tr.Processes[span.ProcessID].ServiceName, span.OperationName)
+		}
+	}
+	if len(roots) != 1 {
+		return "", fmt.Errorf("trace has %d roots: %v", len(roots), roots)
+	}
+	return roots[0], nil
+}
+
+// A workflow.trigger frame sent to the gateway creates an instance in the engine,
+// whose run asks the presence service for a user's status: the frame, the engine's
+// request and run, and the presence lookup are one trace
+func TestTraceSpansServices(t *testing.T) {
+	startCompose(t)
+	waitReady(t, engineURL+"/ready")
+	waitReady(t, gatewayURL+"/health")
+	suffix := uuid.NewString()[:8]
+	admin := mintToken(t, "integration-admin", map[string]any{"role": "admin"})
+
+	// The gateway creates instances with an engine API key, which can only be made
+	// once the engine is up, so the gateway is restarted with it
+	var key struct {
+		Key string `json:"key"`
+	}
+	mustRequest(t, http.MethodPost, engineURL+"/api/v1/api-keys", admin, map[string]any{
+		"name": "integration-gateway-" + suffix,
+		"role": "gateway",
+	}, &key)
+	compose(t, []string{"GATEWAY_WORKFLOW_API_KEY=" + key.Key}, "up", "--detach", "--wait", "websocket-gateway")
+	waitReady(t, gatewayURL+"/health")
+
+	templateID := createTemplate(t, engineURL, admin, "trace-"+suffix, checkPresenceStep("integration-user"))
+
+	userToken := mintToken(t, "integration-user", nil)
+	instanceID := triggerWorkflow(t, dialGateway(t, gatewayURL, userToken), templateID, false)
+	mustRequest(t, http.MethodPut, engineURL+"/api/v1/instances/"+instanceID+"/start", userToken, nil, nil)
+
+	instance := waitCompleted(t, engineURL, userToken, instanceID)
+
+	// traceparent is version-trace_id-parent_id-flags
+	parts := strings.Split(instance.TraceParent, "-")
+	if len(parts) != 4 {
+		t.Fatalf("instance trace_parent = %q, want a W3C traceparent", instance.TraceParent)
+	}
+	traceID := parts[1]
+
+	// Spans are exported in batches, so the trace fills in over a few seconds
+	var root string
+	waitFor(t, 30*time.Second, "trace "+traceID+" in Jaeger", func() error {
+		var resp struct {
+			Data []jaegerTrace `json:"data"`
+		}
+		if _, err := request(http.MethodGet, jaegerURL+"/api/traces/"+traceID, "", nil, &resp); err != nil {
+			return err
+		}
+		if len(resp.Data) != 1 {
+			return fmt.Errorf("Jaeger returned %d traces", len(resp.Data))
+		}
+		var err error
+		root, err = resp.Data[0].connected(tracedServices)
+		return err
+	})
+	if root != "ws workflow.trigger" {
+		t.Errorf("trace root = %q, want the gateway's ws workflow.trigger", root)
+	}
+}
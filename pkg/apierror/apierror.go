@@ -0,0 +1,158 @@
+// Package apierror writes the error responses of the Chorus Go services in one
+// format, so clients parse a single envelope whichever service answered:
+//
+//	{"code": "NOT_FOUND", "message": "Template not found", "details": ..., "request_id": "..."}
+//
+// Clients that do not accept JSON get the message as plain text, as http.Error
+// wrote it. Write serves net/http handlers and Abort gin handlers.
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"chorus/pkg/logging"
+)
+
+// Codes shared by the services. Each status has a default code; services may use
+// their own codes for errors clients tell apart.
+const (
+	CodeValidationFailed = "VALIDATION_FAILED" // the request is malformed or its values are invalid
+	CodeUnauthorized     = "UNAUTHORIZED"      // no valid credentials
+	CodeForbidden        = "FORBIDDEN"         // credentials without the right to do this
+	CodeNotFound         = "NOT_FOUND"
+	CodeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	CodeConflict         = "CONFLICT" // the resource is not in a state that allows this
+	CodeTooLarge         = "PAYLOAD_TOO_LARGE"
+	CodeLocked           = "LOCKED"
+	CodeRateLimited      = "RATE_LIMITED"
+	CodeInternal         = "INTERNAL"
+	CodeUnavailable      = "UNAVAILABLE" // a dependency is down; the request may be retried
+)
+
+// Error is the body of an error response
+type Error struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`    // more on the error, such as the field that is invalid
+	RequestID string `json:"request_id,omitempty"` // of the request, to find its log lines
+}
+
+// CodeFor returns the default code of an HTTP status
+func CodeFor(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return CodeValidationFailed
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodeTooLarge
+	case http.StatusLocked:
+		return CodeLocked
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return CodeUnavailable
+	default:
+		return CodeInternal
+	}
+}
+
+// Write answers r with an error of status, whose code is the default of status.
+// It takes the place of http.Error.
+func Write(w http.ResponseWriter, r *http.Request, status int, message string, details any) {
+	WriteError(w, r, status, Error{Message: message, Details: details})
+}
+
+// WriteError answers r with e and status, filling in the code of status if e has
+// none and the request ID
+func WriteError(w http.ResponseWriter, r *http.Request, status int, e Error) {
+	contentType, body := render(r.Context(), r.Header.Get("Accept"), status, e)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// GinContext is the part of a *gin.Context Abort uses, which keeps gin out of the
+// services built on net/http. Its Value must reach the request's context, as a
+// *gin.Context does with the engine's ContextWithFallback set.
+type GinContext interface {
+	context.Context
+	GetHeader(key string) string
+	Header(key, value string)
+	Data(status int, contentType string, data []byte)
+	Abort()
+}
+
+// Abort answers a gin request with an error of status, whose code is the default
+// of status, and stops the handler chain
+func Abort(c GinContext, status int, message string, details any) {
+	AbortError(c, status, Error{Message: message, Details: details})
+}
+
+// AbortError answers a gin request with e and status, like WriteError, and stops
+// the handler chain
+func AbortError(c GinContext, status int, e Error) {
+	contentType, body := render(c, c.GetHeader("Accept"), status, e)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(status, contentType, body)
+	c.Abort()
+}
+
+// render encodes e as JSON, or as its message in plain text for clients whose
+// Accept header leaves JSON out
+func render(ctx context.Context, accept string, status int, e Error) (string, []byte) {
+	if e.Code == "" {
+		e.Code = CodeFor(status)
+	}
+	if e.RequestID == "" {
+		e.RequestID = logging.RequestID(ctx)
+	}
+
+	if !acceptsJSON(accept) {
+		return "text/plain; charset=utf-8", []byte(e.Message + "\n")
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		// Details that cannot be encoded are left out
+		e.Details = nil
+		body, _ = json.Marshal(e)
+	}
+	return "application/json; charset=utf-8", append(body, '\n')
+}
+
+// acceptsJSON reports whether an Accept header allows a JSON response. No header
+// accepts anything.
+func acceptsJSON(accept string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q <= 0 {
+			continue
+		}
+		switch {
+		case mediaType == "*/*", mediaType == "application/*", mediaType == "application/json",
+			strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"):
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,15 @@
+package auth
+
+import "crypto/subtle"
+
+// ValidAPIKey reports whether presented is one of apiKeys, comparing it with every
+// key in constant time
+func ValidAPIKey(presented string, apiKeys []string) bool {
+	valid := false
+	for _, key := range apiKeys {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(key)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims is the identity carried by a validated token
+type Claims struct {
+	UserID string
+	// The tenant_id claim, or org_id for tokens without one
+	OrgID string
+	// The role claim, if the token has one
+	Roles []string
+	// Zero for a token without an "exp" claim
+	ExpiresAt time.Time
+	// Every claim of the token, for the ones not named above
+	Raw map[string]any
+}
+
+// newClaims reads the identity out of the claims of a verified token
+func newClaims(raw jwt.MapClaims) (*Claims, error) {
+	claims := &Claims{Raw: raw}
+
+	if claims.UserID = claims.String("user_id"); claims.UserID == "" {
+		return nil, ErrMissingUserID
+	}
+	if claims.OrgID = claims.String("tenant_id"); claims.OrgID == "" {
+		claims.OrgID = claims.String("org_id")
+	}
+	if role := claims.String("role"); role != "" {
+		claims.Roles = append(claims.Roles, role)
+	}
+	if exp, err := raw.GetExpirationTime(); err == nil && exp != nil {
+		claims.ExpiresAt = exp.Time
+	}
+
+	return claims, nil
+}
+
+// String returns the claim called name if it is a string, or ""
+func (c *Claims) String(name string) string {
+	value, _ := c.Raw[name].(string)
+	return value
+}
+
+// Role returns the primary role, that of the role claim, or ""
+func (c *Claims) Role() string {
+	if len(c.Roles) == 0 {
+		return ""
+	}
+	return c.Roles[0]
+}
+
+// HasRole reports whether the token grants any of roles; empty roles never match
+func (c *Claims) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if role != "" && slices.Contains(c.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+)
+
+// ErrMissingToken is returned by Authenticate for requests without a bearer token
+var ErrMissingToken = errors.New("request has no bearer token")
+
+type claimsKey struct{}
+
+// NewContext returns a copy of ctx carrying claims
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// FromContext returns the claims stored by Middleware, and false without any
+func FromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// BearerToken returns the token of the "Authorization: Bearer" header of r, or ""
+func BearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(header, "Bearer ")
+}
+
+// Authenticate validates the bearer token of r
+func (v *Validator) Authenticate(r *http.Request) (*Claims, error) {
+	token := BearerToken(r)
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+	return v.Validate(token)
+}
+
+// Message returns the text of the 401 response to a request refused with err
+func Message(err error) string {
+	switch {
+	case errors.Is(err, ErrMissingToken):
+		return "Missing authorization token"
+	case errors.Is(err, ErrMissingUserID):
+		return "Token is missing user_id claim"
+	default:
+		return "Invalid token"
+	}
+}
+
+// Middleware requires a valid bearer token, answering 401 without one, and stores its
+// claims in the request context for FromContext. Gin services adapt Authenticate
+// directly.
+func Middleware(validator *Validator, logger *logging.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := validator.Authenticate(r)
+		if err != nil {
+			if !errors.Is(err, ErrMissingToken) {
+				logger.WarnContext(r.Context(), "Authentication failed", "reason", Reason(err), "path", r.URL.Path, "remote", r.RemoteAddr)
+			}
+			apierror.Write(w, r, http.StatusUnauthorized, Message(err), nil)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), claims)))
+	})
+}
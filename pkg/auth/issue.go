@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Issue signs an HS256 token for userID with the current secret of cfg, valid for
+// ttl and carrying cfg's issuer and audience, so a Validator of the same Config
+// accepts it. extra adds claims such as role or org_id. The services only validate
+// tokens; Issue serves tools and local development, such as cmd/loadtest.
+func Issue(cfg Config, userID string, ttl time.Duration, extra map[string]any) (string, error) {
+	if len(cfg.Secrets) == 0 {
+		return "", errors.New("issuing tokens needs a JWT secret")
+	}
+	if userID == "" {
+		return "", ErrMissingUserID
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{}
+	for name, value := range extra {
+		claims[name] = value
+	}
+	claims["user_id"] = userID
+	claims["sub"] = userID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(ttl).Unix()
+	if cfg.Issuer != "" {
+		claims["iss"] = cfg.Issuer
+	}
+	if cfg.Audience != "" {
+		claims["aud"] = cfg.Audience
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(cfg.Secrets[0]))
+}
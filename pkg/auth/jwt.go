@@ -0,0 +1,231 @@
+// Package auth validates the JWTs accepted by the Chorus services.
+//
+// Tokens are HMAC-signed with one of the configured secrets (the first is the
+// current secret, the rest are still accepted so the secret can be rotated without
+// invalidating outstanding tokens) or RS256-signed with a key published at a JWKS URL.
+//
+// Validate returns the user_id, org and roles of a token as Claims, along with its
+// other claims. Middleware is the net/http adapter; gin services wrap Authenticate.
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"chorus/pkg/env"
+)
+
+// Default secret shipped in the example configuration; rejected in production
+const defaultSecret = "your-secret-key"
+
+// Validation errors. Validate wraps the underlying parser error so callers can use
+// errors.Is; Reason turns them into a short string suitable for logs.
+var (
+	ErrMalformed            = errors.New("token is malformed")
+	ErrUnsupportedAlgorithm = errors.New("token signing algorithm is not accepted")
+	ErrInvalidSignature     = errors.New("token signature is invalid")
+	ErrUnknownKey           = errors.New("token signing key is unknown")
+	ErrExpired              = errors.New("token has expired")
+	ErrNotYetValid          = errors.New("token is not valid yet")
+	ErrMissingExpiry        = errors.New("token has no expiry")
+	ErrInvalidIssuer        = errors.New("token issuer is not accepted")
+	ErrInvalidAudience      = errors.New("token audience is not accepted")
+	ErrMissingUserID        = errors.New("token is missing the user_id claim")
+)
+
+// Config controls which tokens are accepted
+type Config struct {
+	// HMAC secrets; the first is current, the rest are accepted during rotation
+	Secrets []string
+	// Expected "iss" and "aud"; empty disables the check
+	Issuer   string
+	Audience string
+	// Tolerance applied to exp, nbf and iat
+	ClockSkew time.Duration
+	// Reject tokens without an "exp" claim
+	RequireExpiry bool
+	// Optional JWKS endpoint for RS256 tokens, refreshed every JWKSRefresh
+	JWKSURL     string
+	JWKSRefresh time.Duration
+}
+
+// ConfigFromEnv reads JWT_SECRET, JWT_PREVIOUS_SECRETS (comma-separated), JWT_ISSUER,
+// JWT_AUDIENCE, JWT_CLOCK_SKEW_SECONDS, JWT_REQUIRE_EXPIRY, JWT_JWKS_URL and
+// JWT_JWKS_REFRESH_SECONDS
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Issuer:        env.String("JWT_ISSUER", ""),
+		Audience:      env.String("JWT_AUDIENCE", ""),
+		ClockSkew:     env.Duration("JWT_CLOCK_SKEW_SECONDS", 30*time.Second, time.Second),
+		RequireExpiry: env.Bool("JWT_REQUIRE_EXPIRY", true),
+		JWKSURL:       env.URL("JWT_JWKS_URL", ""),
+		JWKSRefresh:   env.Duration("JWT_JWKS_REFRESH_SECONDS", time.Hour, time.Second),
+	}
+
+	secret := env.String("JWT_SECRET", "")
+	if secret == "" && cfg.JWKSURL == "" {
+		secret = defaultSecret
+	}
+	if secret != "" {
+		cfg.Secrets = append(cfg.Secrets, secret)
+	}
+	cfg.Secrets = append(cfg.Secrets, env.StringSlice("JWT_PREVIOUS_SECRETS", nil)...)
+
+	return cfg
+}
+
+// Validate checks the configuration at startup
+func (c Config) Validate(production bool) error {
+	if len(c.Secrets) == 0 && c.JWKSURL == "" {
+		return errors.New("either JWT_SECRET or JWT_JWKS_URL must be set")
+	}
+	if production {
+		for _, secret := range c.Secrets {
+			if secret == defaultSecret {
+				return errors.New("JWT_SECRET must be changed from the default in production")
+			}
+		}
+	}
+	if c.ClockSkew < 0 {
+		return errors.New("JWT_CLOCK_SKEW_SECONDS must not be negative")
+	}
+	return nil
+}
+
+// Validator verifies tokens against a Config
+type Validator struct {
+	secrets jwt.VerificationKeySet
+	jwks    *jwksCache
+	parser  *jwt.Parser
+}
+
+// NewValidator builds a validator for cfg
+func NewValidator(cfg Config) *Validator {
+	v := &Validator{}
+
+	var methods []string
+	for _, secret := range cfg.Secrets {
+		v.secrets.Keys = append(v.secrets.Keys, []byte(secret))
+	}
+	if len(cfg.Secrets) > 0 {
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+	if cfg.JWKSURL != "" {
+		v.jwks = newJWKSCache(cfg.JWKSURL, cfg.JWKSRefresh)
+		methods = append(methods, "RS256")
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithLeeway(cfg.ClockSkew),
+		jwt.WithIssuedAt(),
+	}
+	if cfg.RequireExpiry {
+		options = append(options, jwt.WithExpirationRequired())
+	}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Audience))
+	}
+	v.parser = jwt.NewParser(options...)
+
+	return v
+}
+
+// Validate parses and verifies tokenString and returns its claims. A token is only
+// accepted if it carries a non-empty user_id claim.
+func (v *Validator) Validate(tokenString string) (*Claims, error) {
+	raw := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(tokenString, raw, v.keyFunc); err != nil {
+		return nil, classify(err)
+	}
+	return newClaims(raw)
+}
+
+func (v *Validator) keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if len(v.secrets.Keys) == 0 {
+			return nil, ErrUnsupportedAlgorithm
+		}
+		return v.secrets, nil
+	case *jwt.SigningMethodRSA:
+		if v.jwks == nil {
+			return nil, ErrUnsupportedAlgorithm
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, err := v.jwks.key(kid)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+// classify maps parser errors onto the package's error values
+func classify(err error) error {
+	var target error
+	switch {
+	case errors.Is(err, ErrUnknownKey):
+		target = ErrUnknownKey
+	case errors.Is(err, ErrUnsupportedAlgorithm):
+		target = ErrUnsupportedAlgorithm
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		target = ErrMalformed
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		// jwt reports a disallowed "alg" as an invalid signature
+		if strings.Contains(err.Error(), "signing method") {
+			target = ErrUnsupportedAlgorithm
+		} else {
+			target = ErrInvalidSignature
+		}
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing) && strings.Contains(err.Error(), "exp claim"):
+		target = ErrMissingExpiry
+	case errors.Is(err, jwt.ErrTokenExpired):
+		target = ErrExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		target = ErrNotYetValid
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer),
+		errors.Is(err, jwt.ErrTokenRequiredClaimMissing) && strings.Contains(err.Error(), "iss claim"):
+		target = ErrInvalidIssuer
+	case errors.Is(err, jwt.ErrTokenInvalidAudience),
+		errors.Is(err, jwt.ErrTokenRequiredClaimMissing) && strings.Contains(err.Error(), "aud claim"):
+		target = ErrInvalidAudience
+	default:
+		return err
+	}
+	return errors.Join(target, err)
+}
+
+// Reason returns a short, token-free description of a validation error for logging
+func Reason(err error) string {
+	reasons := []struct {
+		err    error
+		reason string
+	}{
+		{ErrMissingToken, "missing_token"},
+		{ErrMissingUserID, "missing_user_id"},
+		{ErrMalformed, "malformed"},
+		{ErrUnsupportedAlgorithm, "unsupported_algorithm"},
+		{ErrInvalidSignature, "invalid_signature"},
+		{ErrUnknownKey, "unknown_key"},
+		{ErrExpired, "expired"},
+		{ErrNotYetValid, "not_yet_valid"},
+		{ErrMissingExpiry, "missing_expiry"},
+		{ErrInvalidIssuer, "invalid_issuer"},
+		{ErrInvalidAudience, "invalid_audience"},
+	}
+	for _, r := range reasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return "invalid"
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func testConfig() Config {
+	return Config{
+		Secrets:       []string{"current-secret", "previous-secret"},
+		Issuer:        "chorus",
+		ClockSkew:     30 * time.Second,
+		RequireExpiry: true,
+	}
+}
+
+// sign returns an HS256 token over claims with secret
+func sign(t *testing.T, secret string, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// validClaims are the claims of a token testConfig accepts
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"user_id": "alice",
+		"iss":     "chorus",
+		"iat":     now.Unix(),
+		"exp":     now.Add(time.Hour).Unix(),
+	}
+}
+
+func with(claims jwt.MapClaims, name string, value any) jwt.MapClaims {
+	if value == nil {
+		delete(claims, name)
+	} else {
+		claims[name] = value
+	}
+	return claims
+}
+
+func TestValidateRejects(t *testing.T) {
+	v := NewValidator(testConfig())
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs256, err := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims()).SignedString(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := sign(t, "current-secret", validClaims())
+
+	tests := []struct {
+		name   string
+		token  string
+		want   error
+		reason string
+	}{
+		{"empty", "", ErrMalformed, "malformed"},
+		{"not a JWT", "not-a-token", ErrMalformed, "malformed"},
+		{"undecodable segments", "a.b.c", ErrMalformed, "malformed"},
+		{"truncated", valid[:len(valid)/2], ErrMalformed, "malformed"},
+		{"RS256 without a JWKS", rs256, ErrUnsupportedAlgorithm, "unsupported_algorithm"},
+		{"unsigned", none, ErrUnsupportedAlgorithm, "unsupported_algorithm"},
+		{"unknown secret", sign(t, "other-secret", validClaims()), ErrInvalidSignature, "invalid_signature"},
+		{"expired", sign(t, "current-secret", with(validClaims(), "exp", time.Now().Add(-time.Minute).Unix())), ErrExpired, "expired"},
+		{"not valid yet", sign(t, "current-secret", with(validClaims(), "nbf", time.Now().Add(time.Minute).Unix())), ErrNotYetValid, "not_yet_valid"},
+		{"no expiry", sign(t, "current-secret", with(validClaims(), "exp", nil)), ErrMissingExpiry, "missing_expiry"},
+		{"no user_id", sign(t, "current-secret", with(validClaims(), "user_id", nil)), ErrMissingUserID, "missing_user_id"},
+		{"empty user_id", sign(t, "current-secret", with(validClaims(), "user_id", "")), ErrMissingUserID, "missing_user_id"},
+		{"non-string user_id", sign(t, "current-secret", with(validClaims(), "user_id", 42)), ErrMissingUserID, "missing_user_id"},
+		{"other issuer", sign(t, "current-secret", with(validClaims(), "iss", "elsewhere")), ErrInvalidIssuer, "invalid_issuer"},
+		{"no issuer", sign(t, "current-secret", with(validClaims(), "iss", nil)), ErrInvalidIssuer, "invalid_issuer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := v.Validate(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Validate error = %v, want %v", err, tt.want)
+			}
+			if claims != nil {
+				t.Errorf("Validate returned claims %+v with its error", claims)
+			}
+			if got := Reason(err); got != tt.reason {
+				t.Errorf("Reason = %q, want %q", got, tt.reason)
+			}
+		})
+	}
+}
+
+func TestValidateAudience(t *testing.T) {
+	cfg := testConfig()
+	cfg.Audience = "chorus-api"
+	v := NewValidator(cfg)
+
+	tests := []struct {
+		name string
+		aud  any
+		want error
+	}{
+		{"expected audience", "chorus-api", nil},
+		{"among several", []string{"other-api", "chorus-api"}, nil},
+		{"other audience", "other-api", ErrInvalidAudience},
+		{"no audience", nil, ErrInvalidAudience},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Validate(sign(t, "current-secret", with(validClaims(), "aud", tt.aud)))
+			if tt.want == nil && err != nil || !errors.Is(err, tt.want) {
+				t.Errorf("Validate error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAccepts(t *testing.T) {
+	v := NewValidator(testConfig())
+
+	tests := []struct {
+		name   string
+		secret string
+		claims jwt.MapClaims
+	}{
+		{"current secret", "current-secret", validClaims()},
+		{"previous secret", "previous-secret", validClaims()},
+		{"expired within the clock skew", "current-secret", with(validClaims(), "exp", time.Now().Add(-10*time.Second).Unix())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := v.Validate(sign(t, tt.secret, tt.claims))
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if claims.UserID != "alice" {
+				t.Errorf("UserID = %q, want alice", claims.UserID)
+			}
+		})
+	}
+
+	// Without RequireExpiry a token may omit exp
+	cfg := testConfig()
+	cfg.RequireExpiry = false
+	claims, err := NewValidator(cfg).Validate(sign(t, "current-secret", with(validClaims(), "exp", nil)))
+	if err != nil {
+		t.Fatalf("Validate without exp: %v", err)
+	}
+	if !claims.ExpiresAt.IsZero() {
+		t.Errorf("ExpiresAt = %s, want zero", claims.ExpiresAt)
+	}
+}
+
+func TestValidateClaims(t *testing.T) {
+	v := NewValidator(testConfig())
+
+	tests := []struct {
+		name  string
+		extra map[string]any
+		org   string
+		roles []string
+	}{
+		{"no org or role", nil, "", nil},
+		{"tenant_id", map[string]any{"tenant_id": "acme", "org_id": "legacy"}, "acme", nil},
+		{"org_id", map[string]any{"org_id": "legacy"}, "legacy", nil},
+		{"role", map[string]any{"role": "admin"}, "", []string{"admin"}},
+		// Only the role claim grants a role
+		{"roles array", map[string]any{"roles": []string{"admin", "service"}}, "", nil},
+		{"role and roles array", map[string]any{"role": "viewer", "roles": []string{"admin"}}, "", []string{"viewer"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := validClaims()
+			for name, value := range tt.extra {
+				raw[name] = value
+			}
+			claims, err := v.Validate(sign(t, "current-secret", raw))
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if claims.OrgID != tt.org {
+				t.Errorf("OrgID = %q, want %q", claims.OrgID, tt.org)
+			}
+			if !slices.Equal(claims.Roles, tt.roles) {
+				t.Errorf("Roles = %v, want %v", claims.Roles, tt.roles)
+			}
+			if claims.HasRole("admin") != slices.Contains(tt.roles, "admin") {
+				t.Errorf("HasRole(admin) = %v with roles %v", claims.HasRole("admin"), tt.roles)
+			}
+		})
+	}
+}
+
+func TestValidAPIKey(t *testing.T) {
+	keys := []string{"first-key", "second-key"}
+	tests := []struct {
+		presented string
+		keys      []string
+		want      bool
+	}{
+		{"first-key", keys, true},
+		{"second-key", keys, true},
+		{"third-key", keys, false},
+		{"first-key-and-more", keys, false},
+		{"first", keys, false},
+		{"", keys, false},
+		{"first-key", nil, false},
+		{"", nil, false},
+	}
+	for _, tt := range tests {
+		if got := ValidAPIKey(tt.presented, tt.keys); got != tt.want {
+			t.Errorf("ValidAPIKey(%q, %v) = %v, want %v", tt.presented, tt.keys, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Package cors implements Cross-Origin Resource Sharing shared by the Chorus services.
+//
+// The policy is framework agnostic: Apply writes the CORS headers for a request
+// and tells the caller whether the request was a preflight that has been answered.
+// Middleware returns a ready-made net/http wrapper; gin services adapt Apply directly.
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chorus/pkg/env"
+)
+
+// Config describes which cross-origin requests are allowed
+type Config struct {
+	// Allowed origins, e.g. "https://app.example.com" or "https://*.example.com".
+	// "*" allows any origin.
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+var (
+	defaultMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	defaultHeaders = []string{"Content-Type", "Authorization", "X-Tenant-ID"}
+	defaultExposed = []string{"Content-Length"}
+)
+
+// ConfigFromEnv reads the policy from CORS_ALLOWED_ORIGINS, CORS_ALLOWED_METHODS,
+// CORS_ALLOWED_HEADERS, CORS_EXPOSED_HEADERS (comma-separated), CORS_ALLOW_CREDENTIALS
+// and CORS_MAX_AGE (seconds)
+func ConfigFromEnv() Config {
+	cfg := Config{
+		AllowedOrigins:   env.StringSlice("CORS_ALLOWED_ORIGINS", nil),
+		AllowedMethods:   env.StringSlice("CORS_ALLOWED_METHODS", defaultMethods),
+		AllowedHeaders:   env.StringSlice("CORS_ALLOWED_HEADERS", defaultHeaders),
+		ExposedHeaders:   env.StringSlice("CORS_EXPOSED_HEADERS", defaultExposed),
+		AllowCredentials: env.Bool("CORS_ALLOW_CREDENTIALS", false),
+		MaxAge:           env.Duration("CORS_MAX_AGE", 10*time.Minute, time.Second),
+	}
+
+	return cfg
+}
+
+// Validate checks the configuration. In production an explicit origin list is
+// required; outside production an empty list allows any origin.
+func (c Config) Validate(production bool) error {
+	if len(c.AllowedOrigins) == 0 {
+		if production {
+			return errors.New("CORS_ALLOWED_ORIGINS must be set in production")
+		}
+		return nil
+	}
+
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if production {
+				return errors.New("CORS_ALLOWED_ORIGINS must not contain \"*\" in production")
+			}
+			if c.AllowCredentials {
+				return errors.New("CORS_ALLOWED_ORIGINS cannot be \"*\" when credentials are allowed")
+			}
+			continue
+		}
+
+		scheme, host, ok := strings.Cut(origin, "://")
+		if !ok || scheme == "" || host == "" || strings.Contains(host, "/") {
+			return fmt.Errorf("invalid CORS origin %q: expected scheme://host[:port]", origin)
+		}
+		if strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+			return fmt.Errorf("invalid CORS origin %q: wildcards are only supported as the leftmost subdomain", origin)
+		}
+	}
+
+	return nil
+}
+
+// Policy is a compiled Config
+type Policy struct {
+	allowAll         bool
+	exact            map[string]bool
+	wildcards        []wildcard
+	methods          map[string]bool
+	headers          map[string]bool
+	anyHeader        bool
+	allowMethods     string
+	allowHeaders     string
+	exposeHeaders    string
+	allowCredentials bool
+	maxAge           string
+}
+
+// wildcard matches origins of the form scheme://*.suffix
+type wildcard struct {
+	prefix string // "https://"
+	suffix string // ".example.com"
+}
+
+// New compiles a policy. An empty origin list allows any origin; call
+// Config.Validate first to reject that in production.
+func New(cfg Config) *Policy {
+	p := &Policy{
+		exact:            make(map[string]bool),
+		methods:          make(map[string]bool),
+		headers:          make(map[string]bool),
+		allowMethods:     strings.Join(cfg.AllowedMethods, ", "),
+		allowHeaders:     strings.Join(cfg.AllowedHeaders, ", "),
+		exposeHeaders:    strings.Join(cfg.ExposedHeaders, ", "),
+		allowCredentials: cfg.AllowCredentials,
+		allowAll:         len(cfg.AllowedOrigins) == 0,
+	}
+	if cfg.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			p.allowAll = true
+		case strings.Contains(origin, "://*."):
+			scheme, host, _ := strings.Cut(origin, "://")
+			p.wildcards = append(p.wildcards, wildcard{prefix: scheme + "://", suffix: strings.TrimPrefix(host, "*")})
+		default:
+			p.exact[origin] = true
+		}
+	}
+
+	for _, method := range cfg.AllowedMethods {
+		p.methods[strings.ToUpper(method)] = true
+	}
+	for _, header := range cfg.AllowedHeaders {
+		if header == "*" {
+			p.anyHeader = true
+		}
+		p.headers[http.CanonicalHeaderKey(header)] = true
+	}
+
+	return p
+}
+
+// AllowsOrigin reports whether the origin matches the policy
+func (p *Policy) AllowsOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	if p.allowAll {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if p.exact[origin] {
+		return true
+	}
+	for _, w := range p.wildcards {
+		if strings.HasPrefix(origin, w.prefix) && strings.HasSuffix(origin, w.suffix) {
+			sub := strings.TrimSuffix(strings.TrimPrefix(origin, w.prefix), w.suffix)
+			if sub != "" && !strings.ContainsAny(sub, "/:") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Apply writes the CORS response headers for r into header. It returns 0 when the
+// request should continue to the handler, or the status code to reply with when
+// r is a preflight request that has been fully answered.
+func (p *Policy) Apply(header http.Header, r *http.Request) int {
+	origin := r.Header.Get("Origin")
+	if !p.allowAll || p.allowCredentials {
+		header.Add("Vary", "Origin")
+	}
+
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if origin == "" {
+		return 0
+	}
+	if !p.AllowsOrigin(origin) {
+		if preflight {
+			return http.StatusForbidden
+		}
+		return 0
+	}
+	if preflight && !p.allowsPreflight(r) {
+		return http.StatusForbidden
+	}
+
+	if p.allowAll && !p.allowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.allowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		if p.exposeHeaders != "" {
+			header.Set("Access-Control-Expose-Headers", p.exposeHeaders)
+		}
+		return 0
+	}
+
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+	header.Set("Access-Control-Allow-Methods", p.allowMethods)
+	if p.anyHeader {
+		header.Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+	} else {
+		header.Set("Access-Control-Allow-Headers", p.allowHeaders)
+	}
+	if p.maxAge != "" {
+		header.Set("Access-Control-Max-Age", p.maxAge)
+	}
+
+	return http.StatusNoContent
+}
+
+// allowsPreflight reports whether the requested method and headers are allowed
+func (p *Policy) allowsPreflight(r *http.Request) bool {
+	if !p.methods[strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))] {
+		return false
+	}
+	if p.anyHeader {
+		return true
+	}
+	for _, requested := range splitList(r.Header.Get("Access-Control-Request-Headers")) {
+		if !p.headers[http.CanonicalHeaderKey(requested)] {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware wraps a net/http handler with the policy
+func (p *Policy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status := p.Apply(w.Header(), r); status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
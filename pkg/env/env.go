@@ -0,0 +1,291 @@
+// Package env reads the settings of the Chorus services from environment variables.
+//
+// Every key read is recorded, so a service can log the configuration it started with
+// (Dump, with secrets redacted) and refuse to start on values that could not be
+// parsed (Err) rather than quietly running on the default. Unset, empty and
+// whitespace-only variables all take the default.
+package env
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redacted replaces the value of secret settings in Dump
+const Redacted = "[redacted]"
+
+// Env reads settings through a lookup function, recording what it read
+type Env struct {
+	lookup func(string) (string, bool)
+
+	mu       sync.Mutex
+	settings map[string]string // effective value by key, as shown by Dump
+	errs     []error
+}
+
+// Default reads the process environment; the package-level functions use it
+var Default = New(os.LookupEnv)
+
+// New returns an Env reading through lookup, such as os.LookupEnv
+func New(lookup func(string) (string, bool)) *Env {
+	return &Env{lookup: lookup, settings: make(map[string]string)}
+}
+
+// get returns the trimmed value of key, and false if it is unset or blank
+func (e *Env) get(key string) (string, bool) {
+	value, _ := e.lookup(key)
+	value = strings.TrimSpace(value)
+	return value, value != ""
+}
+
+// record notes the effective value of key, and err if its value was rejected
+func (e *Env) record(key string, value any, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, seen := e.settings[key]; !seen {
+		e.settings[key] = redact(key, format(value))
+		if err != nil {
+			e.errs = append(e.errs, err)
+		}
+	}
+}
+
+func (e *Env) invalid(key, kind, value string) error {
+	return fmt.Errorf("%s must be %s, not %q", key, kind, value)
+}
+
+// String returns the value of key, or defaultValue
+func (e *Env) String(key, defaultValue string) string {
+	value, ok := e.get(key)
+	if !ok {
+		value = defaultValue
+	}
+	e.record(key, value, nil)
+	return value
+}
+
+// Secret returns the value of key, or defaultValue, like String but always redacted
+// by Dump, for settings whose name does not tell they hold secrets
+func (e *Env) Secret(key, defaultValue string) string {
+	value := e.String(key, defaultValue)
+	if value != "" {
+		e.mu.Lock()
+		e.settings[key] = Redacted
+		e.mu.Unlock()
+	}
+	return value
+}
+
+// Required returns the value of key, reporting an error through Err if it is unset
+func (e *Env) Required(key string) string {
+	value, ok := e.get(key)
+	var err error
+	if !ok {
+		err = fmt.Errorf("%s must be set", key)
+	}
+	e.record(key, value, err)
+	return value
+}
+
+// Int returns the value of key as a decimal integer, or defaultValue
+func (e *Env) Int(key string, defaultValue int) int {
+	raw, ok := e.get(key)
+	if !ok {
+		e.record(key, defaultValue, nil)
+		return defaultValue
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		e.record(key, defaultValue, e.invalid(key, "an integer", raw))
+		return defaultValue
+	}
+	e.record(key, value, nil)
+	return value
+}
+
+// Float returns the value of key as a finite number, or defaultValue
+func (e *Env) Float(key string, defaultValue float64) float64 {
+	raw, ok := e.get(key)
+	if !ok {
+		e.record(key, defaultValue, nil)
+		return defaultValue
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		e.record(key, defaultValue, e.invalid(key, "a number", raw))
+		return defaultValue
+	}
+	e.record(key, value, nil)
+	return value
+}
+
+// Bool returns the value of key as true/false, 1/0 or t/f in any case, or
+// defaultValue
+func (e *Env) Bool(key string, defaultValue bool) bool {
+	raw, ok := e.get(key)
+	if !ok {
+		e.record(key, defaultValue, nil)
+		return defaultValue
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		e.record(key, defaultValue, e.invalid(key, "true or false", raw))
+		return defaultValue
+	}
+	e.record(key, value, nil)
+	return value
+}
+
+// Duration returns the value of key as a whole number of units, such as seconds for
+// a *_SECONDS key, or as a Go duration such as "1m30s", or defaultValue
+func (e *Env) Duration(key string, defaultValue, unit time.Duration) time.Duration {
+	raw, ok := e.get(key)
+	if !ok {
+		e.record(key, defaultValue, nil)
+		return defaultValue
+	}
+	if count, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		if count > math.MaxInt64/int64(unit) || count < math.MinInt64/int64(unit) {
+			e.record(key, defaultValue, e.invalid(key, "a shorter duration", raw))
+			return defaultValue
+		}
+		value := time.Duration(count) * unit
+		e.record(key, value, nil)
+		return value
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		e.record(key, defaultValue, e.invalid(key, "a duration", raw))
+		return defaultValue
+	}
+	e.record(key, value, nil)
+	return value
+}
+
+// StringSlice returns the comma-separated entries of key, trimmed and without empty
+// ones, or defaultValue if there are none
+func (e *Env) StringSlice(key string, defaultValue []string) []string {
+	raw, _ := e.get(key)
+	var values []string
+	for _, value := range strings.Split(raw, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		values = defaultValue
+	}
+	e.record(key, values, nil)
+	return values
+}
+
+// URL returns the value of key, which must be an absolute URL with a scheme and a
+// host, or defaultValue
+func (e *Env) URL(key, defaultValue string) string {
+	raw, ok := e.get(key)
+	if !ok {
+		e.record(key, defaultValue, nil)
+		return defaultValue
+	}
+	if u, err := url.Parse(raw); err != nil || u.Scheme == "" || u.Host == "" {
+		e.record(key, defaultValue, e.invalid(key, "an absolute URL", redactURL(raw)))
+		return defaultValue
+	}
+	e.record(key, raw, nil)
+	return raw
+}
+
+// Err returns every value that was rejected so far, nil if none was
+func (e *Env) Err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return errors.Join(e.errs...)
+}
+
+// Dump returns the effective value of every key read so far, defaults included.
+// Secrets, and the passwords of URLs, are redacted.
+func (e *Env) Dump() map[string]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	dump := make(map[string]string, len(e.settings))
+	for key, value := range e.settings {
+		dump[key] = value
+	}
+	return dump
+}
+
+// String returns the value of key in the process environment, or defaultValue
+func String(key, defaultValue string) string { return Default.String(key, defaultValue) }
+
+// Secret returns the value of key in the process environment, redacted by Dump
+func Secret(key, defaultValue string) string { return Default.Secret(key, defaultValue) }
+
+// Required returns the value of key in the process environment, which must be set
+func Required(key string) string { return Default.Required(key) }
+
+// Int returns the value of key in the process environment as an integer
+func Int(key string, defaultValue int) int { return Default.Int(key, defaultValue) }
+
+// Float returns the value of key in the process environment as a number
+func Float(key string, defaultValue float64) float64 { return Default.Float(key, defaultValue) }
+
+// Bool returns the value of key in the process environment as a boolean
+func Bool(key string, defaultValue bool) bool { return Default.Bool(key, defaultValue) }
+
+// Duration returns the value of key in the process environment as a duration
+func Duration(key string, defaultValue, unit time.Duration) time.Duration {
+	return Default.Duration(key, defaultValue, unit)
+}
+
+// StringSlice returns the comma-separated entries of key in the process environment
+func StringSlice(key string, defaultValue []string) []string {
+	return Default.StringSlice(key, defaultValue)
+}
+
+// URL returns the value of key in the process environment as an absolute URL
+func URL(key, defaultValue string) string { return Default.URL(key, defaultValue) }
+
+// Err returns the values of the process environment that were rejected
+func Err() error { return Default.Err() }
+
+// Dump returns the effective settings read from the process environment
+func Dump() map[string]string { return Default.Dump() }
+
+func format(value any) string {
+	switch v := value.(type) {
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Endings of the keys holding secrets
+var secretSuffixes = []string{"SECRET", "SECRETS", "PASSWORD", "API_KEY", "API_KEYS", "TOKEN"}
+
+// redact hides the value of secret keys and the password of URLs
+func redact(key, value string) string {
+	if value == "" {
+		return value
+	}
+	for _, suffix := range secretSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return Redacted
+		}
+	}
+	return redactURL(value)
+}
+
+func redactURL(value string) string {
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return value
+}
@@ -0,0 +1,179 @@
+package env
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lookup returns an Env reading from vars
+func lookup(vars map[string]string) *Env {
+	return New(func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	})
+}
+
+// Values every parser treats as unset
+var blanks = map[string]string{"empty": "", "spaces": "   ", "whitespace": " \t\n "}
+
+func TestBlankValuesTakeTheDefault(t *testing.T) {
+	for name, blank := range blanks {
+		t.Run(name, func(t *testing.T) {
+			e := lookup(map[string]string{"KEY": blank})
+			if got := e.String("KEY", "default"); got != "default" {
+				t.Errorf("String = %q, want default", got)
+			}
+			if got := e.Int("KEY", 7); got != 7 {
+				t.Errorf("Int = %d, want 7", got)
+			}
+			if got := e.Float("KEY", 1.5); got != 1.5 {
+				t.Errorf("Float = %v, want 1.5", got)
+			}
+			if got := e.Bool("KEY", true); !got {
+				t.Error("Bool = false, want true")
+			}
+			if got := e.Duration("KEY", time.Minute, time.Second); got != time.Minute {
+				t.Errorf("Duration = %s, want 1m", got)
+			}
+			if got := e.StringSlice("KEY", []string{"a"}); !slices.Equal(got, []string{"a"}) {
+				t.Errorf("StringSlice = %v, want [a]", got)
+			}
+			if got := e.URL("KEY", "http://default"); got != "http://default" {
+				t.Errorf("URL = %q, want http://default", got)
+			}
+			if err := e.Err(); err != nil {
+				t.Errorf("Err = %v, want nil for a blank value", err)
+			}
+		})
+	}
+}
+
+func TestValuesAreTrimmed(t *testing.T) {
+	e := lookup(map[string]string{
+		"NAME":     "  chorus\n",
+		"COUNT":    " 12 ",
+		"RATIO":    "\t0.25",
+		"ENABLED":  " TRUE ",
+		"TIMEOUT":  " 90 ",
+		"INTERVAL": " 1m30s ",
+		"HOSTS":    " a , ,b,, ",
+		"ENDPOINT": " https://example.com/path ",
+	})
+	if got := e.String("NAME", ""); got != "chorus" {
+		t.Errorf("String = %q, want chorus", got)
+	}
+	if got := e.Int("COUNT", 0); got != 12 {
+		t.Errorf("Int = %d, want 12", got)
+	}
+	if got := e.Float("RATIO", 0); got != 0.25 {
+		t.Errorf("Float = %v, want 0.25", got)
+	}
+	if got := e.Bool("ENABLED", false); !got {
+		t.Error("Bool = false, want true")
+	}
+	if got := e.Duration("TIMEOUT", 0, time.Second); got != 90*time.Second {
+		t.Errorf("Duration in seconds = %s, want 1m30s", got)
+	}
+	if got := e.Duration("INTERVAL", 0, time.Second); got != 90*time.Second {
+		t.Errorf("Duration string = %s, want 1m30s", got)
+	}
+	if got := e.StringSlice("HOSTS", nil); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("StringSlice = %v, want [a b]", got)
+	}
+	if got := e.URL("ENDPOINT", ""); got != "https://example.com/path" {
+		t.Errorf("URL = %q, want https://example.com/path", got)
+	}
+	if err := e.Err(); err != nil {
+		t.Errorf("Err = %v", err)
+	}
+}
+
+func TestMalformedValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		parse func(e *Env) any
+		want  any
+	}{
+		{"Int word", "ten", func(e *Env) any { return e.Int("KEY", 3) }, 3},
+		{"Int fraction", "1.5", func(e *Env) any { return e.Int("KEY", 3) }, 3},
+		{"Int overflow", "99999999999999999999", func(e *Env) any { return e.Int("KEY", 3) }, 3},
+		{"Float word", "half", func(e *Env) any { return e.Float("KEY", 0.5) }, 0.5},
+		{"Float NaN", "NaN", func(e *Env) any { return e.Float("KEY", 0.5) }, 0.5},
+		{"Float infinite", "+Inf", func(e *Env) any { return e.Float("KEY", 0.5) }, 0.5},
+		{"Bool yes", "yes", func(e *Env) any { return e.Bool("KEY", true) }, true},
+		{"Bool number", "2", func(e *Env) any { return e.Bool("KEY", true) }, true},
+		{"Duration word", "soon", func(e *Env) any { return e.Duration("KEY", time.Second, time.Second) }, time.Second},
+		{"Duration unit only", "ms", func(e *Env) any { return e.Duration("KEY", time.Second, time.Second) }, time.Second},
+		{"Duration overflow", "9223372036854775807", func(e *Env) any { return e.Duration("KEY", time.Second, time.Second) }, time.Second},
+		{"URL without scheme", "example.com", func(e *Env) any { return e.URL("KEY", "http://default") }, "http://default"},
+		{"URL without host", "http://", func(e *Env) any { return e.URL("KEY", "http://default") }, "http://default"},
+		{"URL unparsable", "http://[::1", func(e *Env) any { return e.URL("KEY", "http://default") }, "http://default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := lookup(map[string]string{"KEY": tt.value})
+			if got := tt.parse(e); got != tt.want {
+				t.Errorf("got %v, want the default %v", got, tt.want)
+			}
+			err := e.Err()
+			if err == nil {
+				t.Fatalf("Err = nil, want %q reported", tt.value)
+			}
+			if !strings.HasPrefix(err.Error(), "KEY must be") {
+				t.Errorf("Err = %q, want it to name KEY", err)
+			}
+		})
+	}
+}
+
+func TestRequired(t *testing.T) {
+	for name, blank := range blanks {
+		e := lookup(map[string]string{"KEY": blank})
+		e.Required("KEY")
+		if err := e.Err(); err == nil || err.Error() != "KEY must be set" {
+			t.Errorf("%s: Err = %v, want KEY must be set", name, err)
+		}
+	}
+	e := lookup(map[string]string{"KEY": " value "})
+	if got := e.Required("KEY"); got != "value" || e.Err() != nil {
+		t.Errorf("Required = %q with Err %v, want value", got, e.Err())
+	}
+}
+
+func TestDumpRedactsSecrets(t *testing.T) {
+	e := lookup(map[string]string{
+		"JWT_SECRET":            "s3cret",
+		"PRESENCE_GRPC_API_KEY": "key",
+		"PRESENCE_GRPC_TOKEN":   "token",
+		"DATABASE_URL":          "postgres://chorus:hunter2@db:5432/chorus",
+		"OPAQUE":                "hidden",
+		"PLAIN":                 "shown",
+	})
+	e.String("JWT_SECRET", "")
+	e.String("PRESENCE_GRPC_API_KEY", "")
+	e.String("PRESENCE_GRPC_TOKEN", "")
+	e.URL("DATABASE_URL", "")
+	e.Secret("OPAQUE", "")
+	e.String("PLAIN", "")
+	e.String("UNSET_TOKEN", "")
+
+	dump := e.Dump()
+	for _, key := range []string{"JWT_SECRET", "PRESENCE_GRPC_API_KEY", "PRESENCE_GRPC_TOKEN", "OPAQUE"} {
+		if dump[key] != Redacted {
+			t.Errorf("Dump[%s] = %q, want it redacted", key, dump[key])
+		}
+	}
+	if strings.Contains(dump["DATABASE_URL"], "hunter2") {
+		t.Errorf("Dump[DATABASE_URL] = %q shows the password", dump["DATABASE_URL"])
+	}
+	if dump["PLAIN"] != "shown" {
+		t.Errorf("Dump[PLAIN] = %q, want shown", dump["PLAIN"])
+	}
+	// An unset secret shows as empty, so the dump tells it is missing
+	if value, ok := dump["UNSET_TOKEN"]; !ok || value != "" {
+		t.Errorf("Dump[UNSET_TOKEN] = %q, %v, want an empty entry", value, ok)
+	}
+}
@@ -0,0 +1,202 @@
+// Package eventbus publishes and receives the events the Chorus Go services exchange
+// over Redis, on topics declared with the type of their events. Events travel as
+// flat JSON objects whose envelope fields (event_id, event_version, source,
+// traceparent) sit beside the event's own, so consumers that predate the bus keep
+// reading them. Topics are Redis pub/sub channels; a topic with a stream is also
+// appended to a Redis stream, which Consume reads at least once with a consumer
+// group.
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"chorus/pkg/logging"
+	"chorus/pkg/tracing"
+)
+
+// Topic is a channel carrying events of type T, which must encode to JSON objects
+type Topic[T any] struct {
+	Name    string // of the Redis channel
+	Version int    // of the event schema; received events of a later version are skipped
+
+	// Stream, if set, also appends every event to a Redis stream for Consume
+	Stream *StreamOptions
+}
+
+// StreamOptions describe the Redis stream of a topic
+type StreamOptions struct {
+	Key    string // of the stream, default "<topic name>:stream"
+	MaxLen int64  // entries kept, approximately; 0 keeps them all
+}
+
+func (t Topic[T]) streamKey() string {
+	if t.Stream.Key != "" {
+		return t.Stream.Key
+	}
+	return t.Name + ":stream"
+}
+
+// Envelope is what the bus adds to every event it publishes
+type Envelope struct {
+	EventID     string `json:"event_id"`                // for consumers to drop re-deliveries
+	Version     int    `json:"event_version,omitempty"` // of the topic when published; 0 for events published without the bus
+	Source      string `json:"source,omitempty"`        // service that published it
+	TraceParent string `json:"traceparent,omitempty"`   // W3C trace context of the publisher
+}
+
+// Message is a received event
+type Message[T any] struct {
+	Topic string
+	Envelope
+	Data    T
+	Payload []byte // the event as published
+}
+
+// Handler publishes or receives the payload of an event on topic. Middleware wraps
+// Handlers to observe events in both directions.
+type Handler func(ctx context.Context, topic string, payload []byte) error
+
+// Middleware wraps the publishing and the receiving of events; either may be nil
+type Middleware struct {
+	Publish func(next Handler) Handler
+	Receive func(next Handler) Handler
+}
+
+// Bus publishes and receives events over a Redis client
+type Bus struct {
+	client     redis.UniversalClient
+	source     string
+	logger     *logging.Logger
+	middleware []Middleware
+}
+
+// New returns a bus over client for the service named source. Middleware runs in the
+// order given, the first one outermost.
+func New(client redis.UniversalClient, source string, logger *logging.Logger, middleware ...Middleware) *Bus {
+	return &Bus{
+		client:     client,
+		source:     source,
+		logger:     logger,
+		middleware: middleware,
+	}
+}
+
+func (b *Bus) wrapPublish(handler Handler) Handler {
+	for i := len(b.middleware) - 1; i >= 0; i-- {
+		if b.middleware[i].Publish != nil {
+			handler = b.middleware[i].Publish(handler)
+		}
+	}
+	return handler
+}
+
+func (b *Bus) wrapReceive(handler Handler) Handler {
+	for i := len(b.middleware) - 1; i >= 0; i-- {
+		if b.middleware[i].Receive != nil {
+			handler = b.middleware[i].Receive(handler)
+		}
+	}
+	return handler
+}
+
+// Publish sends event to the subscribers of topic, and appends it to the topic's
+// stream if it has one. The envelope is filled in last, so event_id may be set by
+// the event itself and traceparent names the span middleware started.
+func Publish[T any](ctx context.Context, b *Bus, topic Topic[T], event T) error {
+	_, err := PublishCounted(ctx, b, topic, event)
+	return err
+}
+
+// PublishCounted is Publish, also returning how many subscriptions received the
+// event, those of the publisher included
+func PublishCounted[T any](ctx context.Context, b *Bus, topic Topic[T], event T) (int64, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode %s event: %w", topic.Name, err)
+	}
+
+	var receivers int64
+
+	publish := b.wrapPublish(func(ctx context.Context, name string, payload []byte) error {
+		payload, err := stamp(payload, Envelope{
+			EventID:     uuid.New().String(),
+			Version:     topic.Version,
+			Source:      b.source,
+			TraceParent: tracing.TraceParent(ctx),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to encode %s event: %w", name, err)
+		}
+
+		pipe := b.client.Pipeline()
+		published := pipe.Publish(ctx, name, payload)
+		if topic.Stream != nil {
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: topic.streamKey(),
+				MaxLen: topic.Stream.MaxLen,
+				Approx: topic.Stream.MaxLen > 0,
+				Values: map[string]interface{}{streamField: payload},
+			})
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("failed to publish %s event: %w", name, err)
+		}
+		receivers = published.Val()
+		return nil
+	})
+	if err := publish(ctx, topic.Name, payload); err != nil {
+		return 0, err
+	}
+	return receivers, nil
+}
+
+// stamp adds the fields of envelope the event does not set itself to payload
+func stamp(payload []byte, envelope Envelope) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil || fields == nil {
+		return nil, errors.New("events must encode to JSON objects")
+	}
+
+	stamped, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+	var envelopeFields map[string]json.RawMessage
+	if err := json.Unmarshal(stamped, &envelopeFields); err != nil {
+		return nil, err
+	}
+	for key, value := range envelopeFields {
+		if _, set := fields[key]; !set {
+			fields[key] = value
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// receiver returns the innermost Handler of a subscription to topic: it decodes
+// events and calls handler with them. Events that cannot be decoded and events of a
+// later version than topic are logged and dropped.
+func receiver[T any](b *Bus, topic Topic[T], handler func(context.Context, Message[T]) error) Handler {
+	return func(ctx context.Context, name string, payload []byte) error {
+		msg := Message[T]{Topic: name, Payload: payload}
+		if err := json.Unmarshal(payload, &msg.Envelope); err != nil {
+			b.logger.ErrorContext(ctx, "Failed to decode event", "topic", name, "error", err)
+			return nil
+		}
+		if msg.Version > topic.Version {
+			b.logger.WarnContext(ctx, "Skipping event of a later version", "topic", name, "event_id", msg.EventID, "version", msg.Version, "supported", topic.Version)
+			return nil
+		}
+		if err := json.Unmarshal(payload, &msg.Data); err != nil {
+			b.logger.ErrorContext(ctx, "Failed to decode event", "topic", name, "event_id", msg.EventID, "error", err)
+			return nil
+		}
+		return handler(ctx, msg)
+	}
+}
@@ -0,0 +1,428 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"chorus/pkg/logging"
+)
+
+type testEvent struct {
+	EventID string `json:"event_id,omitempty"`
+	Kind    string `json:"kind"`
+	N       int    `json:"n"`
+}
+
+var testTopic = Topic[testEvent]{Name: "test:events", Version: 2}
+
+// newTestBus returns a bus over a client of mr for the service "tester"
+func newTestBus(t *testing.T, mr *miniredis.Miniredis, middleware ...Middleware) (*Bus, *redis.Client) {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	logger := &logging.Logger{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	return New(client, "tester", logger, middleware...), client
+}
+
+// received collects the messages a subscription hands over
+type received[T any] struct {
+	mu       sync.Mutex
+	messages []Message[T]
+	arrived  chan struct{}
+}
+
+func newReceived[T any]() *received[T] {
+	return &received[T]{arrived: make(chan struct{}, 100)}
+}
+
+func (r *received[T]) handle(ctx context.Context, msg Message[T]) error {
+	r.mu.Lock()
+	r.messages = append(r.messages, msg)
+	r.mu.Unlock()
+	r.arrived <- struct{}{}
+	return nil
+}
+
+// wait returns the first n messages, failing the test if they do not arrive in time
+func (r *received[T]) wait(t *testing.T, n int) []Message[T] {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		r.mu.Lock()
+		if len(r.messages) >= n {
+			messages := slices.Clone(r.messages[:n])
+			r.mu.Unlock()
+			return messages
+		}
+		r.mu.Unlock()
+		select {
+		case <-r.arrived:
+		case <-timeout:
+			t.Fatalf("received %d events, want %d", len(r.messages), n)
+		}
+	}
+}
+
+// subscribe subscribes handler to topic until the end of the test, and returns once
+// the subscription is registered in mr
+func subscribe[T any](t *testing.T, mr *miniredis.Miniredis, b *Bus, topic Topic[T], handler func(context.Context, Message[T]) error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Subscribe(ctx, b, topic, handler)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	waitSubscribed(t, mr, topic.Name)
+}
+
+func waitSubscribed(t *testing.T, mr *miniredis.Miniredis, channel string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for mr.PubSubNumSub(channel)[channel] == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("no subscriber on %s", channel)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b, _ := newTestBus(t, mr)
+	got := newReceived[testEvent]()
+	subscribe(t, mr, b, testTopic, got.handle)
+
+	if err := Publish(context.Background(), b, testTopic, testEvent{Kind: "created", N: 1}); err != nil {
+		t.Fatal(err)
+	}
+	msg := got.wait(t, 1)[0]
+
+	if msg.Topic != testTopic.Name {
+		t.Errorf("topic = %q, want %q", msg.Topic, testTopic.Name)
+	}
+	if msg.Data.Kind != "created" || msg.Data.N != 1 {
+		t.Errorf("data = %+v, want the published event", msg.Data)
+	}
+	if msg.EventID == "" {
+		t.Error("event has no event_id")
+	}
+	if msg.Version != testTopic.Version || msg.Source != "tester" {
+		t.Errorf("envelope = %+v, want version %d from tester", msg.Envelope, testTopic.Version)
+	}
+
+	// The envelope sits beside the event's fields, for consumers that predate the bus
+	var fields map[string]interface{}
+	if err := json.Unmarshal(msg.Payload, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"kind", "n", "event_id", "event_version", "source"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("payload %s has no %s", msg.Payload, key)
+		}
+	}
+	if _, ok := fields["traceparent"]; ok {
+		t.Errorf("payload %s has a traceparent without a span", msg.Payload)
+	}
+}
+
+func TestPublishKeepsEventID(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b, _ := newTestBus(t, mr)
+	got := newReceived[testEvent]()
+	subscribe(t, mr, b, testTopic, got.handle)
+
+	if err := Publish(context.Background(), b, testTopic, testEvent{EventID: "replayed-1", Kind: "replayed"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := got.wait(t, 1)[0]; msg.EventID != "replayed-1" {
+		t.Errorf("event_id = %q, want the event's own", msg.EventID)
+	}
+}
+
+func TestPublishCounted(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b, _ := newTestBus(t, mr)
+
+	receivers, err := PublishCounted(context.Background(), b, testTopic, testEvent{Kind: "unheard"})
+	if err != nil || receivers != 0 {
+		t.Fatalf("PublishCounted without subscribers = %d, %v, want 0", receivers, err)
+	}
+	for range 2 {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		go Subscribe(ctx, b, testTopic, newReceived[testEvent]().handle)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for mr.PubSubNumSub(testTopic.Name)[testTopic.Name] < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("the subscriptions were not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	receivers, err = PublishCounted(context.Background(), b, testTopic, testEvent{Kind: "heard"})
+	if err != nil || receivers != 2 {
+		t.Errorf("PublishCounted = %d, %v, want 2 receivers", receivers, err)
+	}
+}
+
+func TestPublishRejectsNonObjects(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b, _ := newTestBus(t, mr)
+
+	if err := Publish(context.Background(), b, Topic[[]string]{Name: "test:lists"}, []string{"a"}); err == nil {
+		t.Error("published an array")
+	}
+	if err := Publish(context.Background(), b, Topic[map[string]int]{Name: "test:maps"}, nil); err == nil {
+		t.Error("published null")
+	}
+}
+
+func TestSubscribeSkipsUndecodableEvents(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b, client := newTestBus(t, mr)
+	got := newReceived[testEvent]()
+	subscribe(t, mr, b, testTopic, got.handle)
+
+	ctx := context.Background()
+	for _, payload := range []string{
+		`not json`,
+		`{"event_id": "later", "event_version": 3, "kind": "later"}`,
+		`{"event_id": "mistyped", "n": "one"}`,
+		// Events published without the bus have no version and are read as the current one
+		`{"event_id": "legacy", "kind": "legacy"}`,
+	} {
+		if err := client.Publish(ctx, testTopic.Name, payload).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Publish(ctx, b, testTopic, testEvent{Kind: "current"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pub/sub keeps the order, so the skipped events would have come first
+	messages := got.wait(t, 2)
+	if messages[0].EventID != "legacy" || messages[1].Data.Kind != "current" {
+		t.Errorf("received %q and %q, want the legacy and the current event", messages[0].EventID, messages[1].Data.Kind)
+	}
+}
+
+func TestSubscribeResubscribesAfterRestart(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b, _ := newTestBus(t, mr)
+	got := newReceived[testEvent]()
+	subscribe(t, mr, b, testTopic, got.handle)
+
+	mr.Close()
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribed(t, mr, testTopic.Name)
+
+	if err := Publish(context.Background(), b, testTopic, testEvent{Kind: "after restart"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := got.wait(t, 1)[0]; msg.Data.Kind != "after restart" {
+		t.Errorf("received %q, want the event published after the restart", msg.Data.Kind)
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	mr := miniredis.RunT(t)
+	var mu sync.Mutex
+	var calls []string
+	record := func(name string) func(next Handler) Handler {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, topic string, payload []byte) error {
+				mu.Lock()
+				calls = append(calls, name)
+				mu.Unlock()
+				return next(ctx, topic, payload)
+			}
+		}
+	}
+	b, _ := newTestBus(t, mr,
+		Middleware{Publish: record("publish outer"), Receive: record("receive outer")},
+		Middleware{Receive: record("receive inner")},
+		Middleware{Publish: record("publish inner")},
+	)
+	got := newReceived[testEvent]()
+	subscribe(t, mr, b, testTopic, got.handle)
+
+	if err := Publish(context.Background(), b, testTopic, testEvent{Kind: "observed"}); err != nil {
+		t.Fatal(err)
+	}
+	got.wait(t, 1)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"publish outer", "publish inner", "receive outer", "receive inner"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("middleware ran as %v, want %v", calls, want)
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b, _ := newTestBus(t, mr, Metrics())
+	topic := Topic[testEvent]{Name: "test:metrics", Version: 1}
+	failing := func(ctx context.Context, msg Message[testEvent]) error {
+		if msg.Data.Kind == "bad" {
+			return context.DeadlineExceeded
+		}
+		return nil
+	}
+	got := newReceived[testEvent]()
+	subscribe(t, mr, b, topic, func(ctx context.Context, msg Message[testEvent]) error {
+		err := failing(ctx, msg)
+		got.handle(ctx, msg)
+		return err
+	})
+
+	published, received, failed := publishedTotal.Value(topic.Name), receivedTotal.Value(topic.Name), handlerErrorsTotal.Value(topic.Name)
+	for _, kind := range []string{"good", "bad", "good"} {
+		if err := Publish(context.Background(), b, topic, testEvent{Kind: kind}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got.wait(t, 3)
+
+	if diff := publishedTotal.Value(topic.Name) - published; diff != 3 {
+		t.Errorf("eventbus_published_total grew by %v, want 3", diff)
+	}
+	if diff := receivedTotal.Value(topic.Name) - received; diff != 3 {
+		t.Errorf("eventbus_received_total grew by %v, want 3", diff)
+	}
+	if diff := handlerErrorsTotal.Value(topic.Name) - failed; diff != 1 {
+		t.Errorf("eventbus_handler_errors_total grew by %v, want 1", diff)
+	}
+
+	// A publish that fails is counted as such
+	errorsBefore := publishErrorsTotal.Value(topic.Name)
+	mr.Close()
+	if err := Publish(context.Background(), b, topic, testEvent{Kind: "lost"}); err == nil {
+		t.Fatal("published with Redis down")
+	}
+	if diff := publishErrorsTotal.Value(topic.Name) - errorsBefore; diff != 1 {
+		t.Errorf("eventbus_publish_errors_total grew by %v, want 1", diff)
+	}
+}
+
+var streamTopic = Topic[testEvent]{Name: "test:audit", Version: 1, Stream: &StreamOptions{MaxLen: 100}}
+
+// consume runs Consume as consumer of group until the returned function or the end
+// of the test cancels it. It does not wait for Consume to return, which happens when
+// its blocking read ends.
+func consume(t *testing.T, b *Bus, group, consumer string, handler func(context.Context, Message[testEvent]) error) (cancel func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	go Consume(ctx, b, streamTopic, group, consumer, handler)
+	t.Cleanup(cancel)
+	return cancel
+}
+
+// waitGroup waits until group exists on the stream of streamTopic, since Consume
+// creates it at the end of the stream and events published before are not read
+func waitGroup(t *testing.T, client *redis.Client, group string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		groups, _ := client.XInfoGroups(context.Background(), streamTopic.streamKey()).Result()
+		for _, g := range groups {
+			if g.Name == group {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("group %s was not created", group)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConsumeAcknowledgesHandledEvents(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b, client := newTestBus(t, mr)
+	got := newReceived[testEvent]()
+	consume(t, b, "auditors", "auditor-1", got.handle)
+	waitGroup(t, client, "auditors")
+
+	for n := 1; n <= 3; n++ {
+		if err := Publish(context.Background(), b, streamTopic, testEvent{Kind: "audit", N: n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, msg := range got.wait(t, 3) {
+		if msg.Data.N != i+1 || msg.Source != "tester" {
+			t.Errorf("event %d = %+v from %q, want n %d from tester", i, msg.Data, msg.Source, i+1)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pending, err := client.XPending(context.Background(), streamTopic.streamKey(), "auditors").Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pending.Count == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d events left unacknowledged", pending.Count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConsumeRedeliversFailedEvents(t *testing.T) {
+	mr := miniredis.RunT(t)
+	now := time.Now()
+	mr.SetTime(now)
+	b, client := newTestBus(t, mr)
+
+	failed := make(chan Message[testEvent], 1)
+	cancel := consume(t, b, "auditors", "auditor-1", func(ctx context.Context, msg Message[testEvent]) error {
+		failed <- msg
+		return context.DeadlineExceeded
+	})
+	waitGroup(t, client, "auditors")
+	if err := Publish(context.Background(), b, streamTopic, testEvent{Kind: "audit", N: 7}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-failed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event was not delivered")
+	}
+	cancel()
+
+	// Another consumer of the group claims the event once it has been pending long enough
+	mr.SetTime(now.Add(claimMinIdle + time.Second))
+	got := newReceived[testEvent]()
+	consume(t, b, "auditors", "auditor-2", got.handle)
+	if msg := got.wait(t, 1)[0]; msg.Data.N != 7 {
+		t.Errorf("redelivered %+v, want the failed event", msg.Data)
+	}
+}
+
+func TestConsumeNeedsStream(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b, _ := newTestBus(t, mr)
+	err := Consume(context.Background(), b, testTopic, "group", "consumer", func(context.Context, Message[testEvent]) error { return nil })
+	if err == nil {
+		t.Error("consumed a topic without a stream")
+	}
+}
@@ -0,0 +1,107 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"chorus/pkg/metrics"
+	"chorus/pkg/tracing"
+)
+
+// Event bus metrics exposed on /metrics
+var (
+	publishedTotal = metrics.Default.Counter(
+		"eventbus_published_total",
+		"Events published, by topic",
+		"topic",
+	)
+	publishErrorsTotal = metrics.Default.Counter(
+		"eventbus_publish_errors_total",
+		"Events that could not be published, by topic",
+		"topic",
+	)
+	receivedTotal = metrics.Default.Counter(
+		"eventbus_received_total",
+		"Events received, by topic",
+		"topic",
+	)
+	handlerErrorsTotal = metrics.Default.Counter(
+		"eventbus_handler_errors_total",
+		"Events whose handler failed, by topic",
+		"topic",
+	)
+)
+
+// Metrics counts the events published and received, and the failures, by topic
+func Metrics() Middleware {
+	return Middleware{
+		Publish: func(next Handler) Handler {
+			return func(ctx context.Context, topic string, payload []byte) error {
+				err := next(ctx, topic, payload)
+				if err != nil {
+					publishErrorsTotal.Inc(topic)
+				} else {
+					publishedTotal.Inc(topic)
+				}
+				return err
+			}
+		},
+		Receive: func(next Handler) Handler {
+			return func(ctx context.Context, topic string, payload []byte) error {
+				receivedTotal.Inc(topic)
+				err := next(ctx, topic, payload)
+				if err != nil {
+					handlerErrorsTotal.Inc(topic)
+				}
+				return err
+			}
+		},
+	}
+}
+
+// Tracing publishes every event in a producer span, whose trace context the event
+// carries as traceparent, and handles every event in a consumer span continuing it
+func Tracing() Middleware {
+	return Middleware{
+		Publish: func(next Handler) Handler {
+			return func(ctx context.Context, topic string, payload []byte) error {
+				ctx, span := tracing.Start(ctx, "publish "+topic, trace.WithSpanKind(trace.SpanKindProducer), spanAttributes(topic))
+				defer span.End()
+
+				err := next(ctx, topic, payload)
+				if err != nil {
+					tracing.Fail(span, err)
+				}
+				return err
+			}
+		},
+		Receive: func(next Handler) Handler {
+			return func(ctx context.Context, topic string, payload []byte) error {
+				var envelope Envelope
+				json.Unmarshal(payload, &envelope)
+				ctx = tracing.WithTraceParent(ctx, envelope.TraceParent)
+				ctx, span := tracing.Start(ctx, "receive "+topic, trace.WithSpanKind(trace.SpanKindConsumer), spanAttributes(topic))
+				defer span.End()
+				if envelope.EventID != "" {
+					span.SetAttributes(attribute.String("messaging.message.id", envelope.EventID))
+				}
+
+				err := next(ctx, topic, payload)
+				if err != nil {
+					tracing.Fail(span, err)
+				}
+				return err
+			}
+		},
+	}
+}
+
+func spanAttributes(topic string) trace.SpanStartEventOption {
+	return trace.WithAttributes(
+		attribute.String("messaging.system", "redis"),
+		attribute.String("messaging.destination.name", topic),
+	)
+}
@@ -0,0 +1,177 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Field of a stream entry holding the event
+const streamField = "event"
+
+// Longest wait before subscribing or reading again after losing Redis
+const maxBackoff = 30 * time.Second
+
+// How Consume reads streams
+const (
+	consumeBatch  = 10
+	consumeBlock  = 5 * time.Second
+	claimMinIdle  = time.Minute // entries left unacknowledged this long are delivered again
+	claimInterval = 30 * time.Second
+)
+
+// Subscribe calls handler with every event published on topic until ctx is
+// cancelled. The subscription survives Redis restarts, subscribing again with
+// backoff; events published meanwhile are lost. Handler errors are logged.
+func Subscribe[T any](ctx context.Context, b *Bus, topic Topic[T], handler func(context.Context, Message[T]) error) {
+	receive := b.wrapReceive(receiver(b, topic, handler))
+
+	pubsub := b.client.Subscribe(ctx, topic.Name)
+	defer pubsub.Close()
+
+	// Receive blocks on the connection regardless of ctx, so closing unblocks it
+	go func() {
+		<-ctx.Done()
+		pubsub.Close()
+	}()
+
+	backoff := time.Second
+	lost := false
+	for {
+		msg, err := pubsub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// The next Receive reconnects and resubscribes
+			b.logger.Warn("Event subscription lost, retrying", "topic", topic.Name, "retry_in", backoff.String(), "error", err)
+			lost = true
+			if !sleep(ctx, backoff) {
+				return
+			}
+			backoff = min(backoff*2, maxBackoff)
+			continue
+		}
+
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if msg.Kind == "subscribe" {
+				backoff = time.Second
+				if lost {
+					b.logger.Info("Event subscription restored", "topic", topic.Name)
+					lost = false
+				}
+			}
+		case *redis.Message:
+			if err := receive(ctx, msg.Channel, []byte(msg.Payload)); err != nil {
+				b.logger.ErrorContext(ctx, "Failed to handle event", "topic", topic.Name, "error", err)
+			}
+		}
+	}
+}
+
+// Consume calls handler with every event appended to the stream of topic, as
+// consumer of group, until ctx is cancelled. Each event goes to one consumer of the
+// group and is acknowledged once handler returns nil; events whose handler failed,
+// or whose consumer died, are delivered again after a minute. The group is created
+// at the end of the stream if it does not exist.
+func Consume[T any](ctx context.Context, b *Bus, topic Topic[T], group, consumer string, handler func(context.Context, Message[T]) error) error {
+	if topic.Stream == nil {
+		return fmt.Errorf("topic %s has no stream", topic.Name)
+	}
+	key := topic.streamKey()
+	receive := b.wrapReceive(receiver(b, topic, handler))
+
+	handle := func(entries []redis.XMessage) {
+		for _, entry := range entries {
+			payload, _ := entry.Values[streamField].(string)
+			if err := receive(ctx, topic.Name, []byte(payload)); err != nil {
+				b.logger.ErrorContext(ctx, "Failed to handle event, leaving it for redelivery", "topic", topic.Name, "stream_id", entry.ID, "error", err)
+				continue
+			}
+			if err := b.client.XAck(ctx, key, group, entry.ID).Err(); err != nil && ctx.Err() == nil {
+				b.logger.Warn("Failed to acknowledge event", "topic", topic.Name, "stream_id", entry.ID, "error", err)
+			}
+		}
+	}
+
+	backoff := time.Second
+	groupReady := false
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		err := func() error {
+			if !groupReady {
+				err := b.client.XGroupCreateMkStream(ctx, key, group, "$").Err()
+				if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+					return err
+				}
+				groupReady = true
+			}
+
+			if time.Since(lastClaim) >= claimInterval {
+				claimed, _, err := b.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+					Stream:   key,
+					Group:    group,
+					Consumer: consumer,
+					MinIdle:  claimMinIdle,
+					Start:    "0-0",
+					Count:    consumeBatch,
+				}).Result()
+				if err != nil {
+					return err
+				}
+				lastClaim = time.Now()
+				handle(claimed)
+			}
+
+			streams, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    group,
+				Consumer: consumer,
+				Streams:  []string{key, ">"},
+				Count:    consumeBatch,
+				Block:    consumeBlock,
+			}).Result()
+			if errors.Is(err, redis.Nil) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			for _, stream := range streams {
+				handle(stream.Messages)
+			}
+			return nil
+		}()
+		if err == nil {
+			backoff = time.Second
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if strings.HasPrefix(err.Error(), "NOGROUP") {
+			// The stream was deleted, by a Redis restart without persistence for one
+			groupReady = false
+		}
+		b.logger.Warn("Event stream read failed, retrying", "topic", topic.Name, "group", group, "retry_in", backoff.String(), "error", err)
+		if !sleep(ctx, backoff) {
+			break
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+	return nil
+}
+
+// sleep waits for d, returning false if ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
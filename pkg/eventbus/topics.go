@@ -0,0 +1,86 @@
+package eventbus
+
+import "time"
+
+// WorkflowEvents carries the step, instance and engine-wide events of the workflow
+// engine
+var WorkflowEvents = Topic[WorkflowEvent]{Name: "workflow:events", Version: 1}
+
+// PresenceEvents carries the status changes and typing events of the presence
+// service
+var PresenceEvents = Topic[PresenceEvent]{Name: "presence:events", Version: 1}
+
+// StepAuditEvents carries the audit records of the workflow engine's steps, kept in
+// a stream for the security pipeline to consume
+var StepAuditEvents = Topic[StepAuditEvent]{
+	Name:    "workflow:audit",
+	Version: 1,
+	Stream:  &StreamOptions{MaxLen: 100000},
+}
+
+// WorkflowEvent is an event of the workflow engine: its type, the instance it
+// concerns unless it is engine-wide, and fields depending on the type
+type WorkflowEvent map[string]interface{}
+
+// Type of the event, such as step_completed or queue_latency_alert
+func (e WorkflowEvent) Type() string {
+	eventType, _ := e["type"].(string)
+	return eventType
+}
+
+// InstanceID of the instance the event concerns, "" for engine-wide events
+func (e WorkflowEvent) InstanceID() string {
+	instanceID, _ := e["instance_id"].(string)
+	return instanceID
+}
+
+// StepAuditEvent records one execution of an audited step, once it finished or was
+// vetoed
+type StepAuditEvent struct {
+	InstanceID string `json:"instance_id"`
+	TemplateID string `json:"template_id"`
+	StepID     string `json:"step_id"`
+	Action     string `json:"action"`
+	Attempt    int    `json:"attempt"`
+	CreatedBy  string `json:"created_by,omitempty"` // of the instance
+	IsTest     bool   `json:"is_test"`
+
+	// http_request steps only
+	Method      string `json:"method,omitempty"`
+	URL         string `json:"url,omitempty"` // credentials and secret placeholders masked
+	Destination string `json:"destination,omitempty"`
+	StatusCode  int    `json:"status_code,omitempty"`
+
+	// The step's resolved config, secrets masked; left out for encrypted instances
+	Inputs map[string]interface{} `json:"inputs,omitempty"`
+
+	Outcome    string    `json:"outcome"` // succeeded, failed or vetoed
+	Error      string    `json:"error,omitempty"`
+	Policy     string    `json:"policy,omitempty"` // hook that vetoed the step
+	DurationMs int64     `json:"duration_ms"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// PresenceEvent is a status change of a user, or a typing event, which has no
+// new_status
+type PresenceEvent struct {
+	UserID    string    `json:"user_id"`
+	OldStatus string    `json:"old_status"` // empty if unknown
+	NewStatus string    `json:"new_status"`
+	Device    string    `json:"device,omitempty"`
+	Reason    string    `json:"reason"`
+	Timestamp time.Time `json:"timestamp"`
+
+	StatusMessage string `json:"status_message,omitempty"`
+	StatusEmoji   string `json:"status_emoji,omitempty"`
+
+	// Typing events only
+	ConversationID string     `json:"conversation_id,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+}
+
+// StatusChange reports whether the event is a status change rather than a typing
+// event
+func (e PresenceEvent) StatusChange() bool {
+	return e.UserID != "" && e.NewStatus != ""
+}
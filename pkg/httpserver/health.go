@@ -0,0 +1,59 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// HealthResponse is the answer of GET /health
+type HealthResponse struct {
+	Status    string    `json:"status"`
+	Service   string    `json:"service"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ReadinessFunc checks what a service needs to serve, returning the breakdown served
+// by GET /health/ready and whether every check passed
+type ReadinessFunc func(ctx context.Context) (interface{}, bool)
+
+// RegisterHealth serves GET /health on mux, a liveness probe that touches nothing,
+// and GET /health/ready when ready is not nil, which answers 503 with the breakdown
+// when a check fails
+func RegisterHealth(mux *http.ServeMux, service string, ready ReadinessFunc) {
+	mux.HandleFunc("/health", Health(service))
+	if ready != nil {
+		mux.HandleFunc("/health/ready", Ready(ready))
+	}
+}
+
+// Health serves the liveness probe of service
+func Health(service string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, HealthResponse{
+			Status:    "healthy",
+			Service:   service,
+			Timestamp: time.Now(),
+		})
+	}
+}
+
+// Ready serves the readiness probe checked by ready
+func Ready(ready ReadinessFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response, ok := ready(r.Context())
+
+		status := http.StatusOK
+		if !ok {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, response)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,88 @@
+// Package httpserver is the net/http scaffolding shared by the presence service and
+// the websocket gateway: the middleware every request goes through (request ID,
+// tracing, access log, panic recovery), the health routes, servers with sensible
+// timeouts, and graceful shutdown.
+package httpserver
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"chorus/pkg/logging"
+)
+
+// Timeouts of servers made by New unless Options sets them
+const (
+	DefaultReadHeaderTimeout = 5 * time.Second
+	DefaultReadTimeout       = 15 * time.Second
+	DefaultWriteTimeout      = 15 * time.Second
+	DefaultIdleTimeout       = 60 * time.Second
+)
+
+// ShutdownTimeout is how long a service waits for its requests to finish at shutdown
+const ShutdownTimeout = 30 * time.Second
+
+// Options of a server; zero timeouts take the defaults
+type Options struct {
+	Addr              string
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+}
+
+// New returns a server of handler, which it serves through Wrap, with the timeouts
+// of opts
+func New(opts Options, logger *logging.Logger, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              opts.Addr,
+		Handler:           Wrap(logger, handler),
+		ReadHeaderTimeout: orDefault(opts.ReadHeaderTimeout, DefaultReadHeaderTimeout),
+		ReadTimeout:       orDefault(opts.ReadTimeout, DefaultReadTimeout),
+		WriteTimeout:      orDefault(opts.WriteTimeout, DefaultWriteTimeout),
+		IdleTimeout:       orDefault(opts.IdleTimeout, DefaultIdleTimeout),
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	}
+}
+
+func orDefault(value, fallback time.Duration) time.Duration {
+	if value > 0 {
+		return value
+	}
+	return fallback
+}
+
+// Serve starts serving srv in the background, over TLS when srv.TLSConfig is set,
+// logging msg and args first. The process exits if the server cannot start.
+func Serve(srv *http.Server, logger *logging.Logger, msg string, args ...interface{}) {
+	go func() {
+		logger.Info(msg, args...)
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logger.Fatal("Failed to start server", "addr", srv.Addr, "error", err)
+		}
+	}()
+}
+
+// WaitForSignal blocks until the process is asked to stop with SIGINT or SIGTERM
+func WaitForSignal() os.Signal {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(quit)
+	return <-quit
+}
+
+// ShutdownContext bounds the shutdown of a service to ShutdownTimeout
+func ShutdownContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), ShutdownTimeout)
+}
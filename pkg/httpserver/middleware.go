@@ -0,0 +1,128 @@
+package httpserver
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+	"chorus/pkg/metrics"
+	"chorus/pkg/tracing"
+)
+
+var panicsTotal = metrics.Default.Counter(
+	"http_handler_panics_total",
+	"Panics in HTTP handlers recovered with a 500",
+)
+
+// Wrap serves handler with the middleware every request goes through: the request ID
+// and the span come first, so the access log and a recovered panic are logged with
+// them, and the access log sees the 500 answered for a panic
+func Wrap(logger *logging.Logger, handler http.Handler) http.Handler {
+	return logging.RequestIDMiddleware(tracing.Middleware(AccessLog(logger, Recover(logger, handler))))
+}
+
+// Recover answers 500 for a request whose handler panicked, unless the response was
+// already started, and logs the panic with its stack. http.ErrAbortHandler is passed
+// on, as net/http uses it to abort a response on purpose.
+func Recover(logger *logging.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := newRecorder(w)
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			panicsTotal.Inc()
+			logger.ErrorContext(r.Context(), "Handler panicked",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", fmt.Sprint(recovered),
+				"stack", string(debug.Stack()),
+			)
+			if !recorder.started {
+				apierror.Write(recorder, r, http.StatusInternalServerError, "Internal server error", nil)
+			}
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// AccessLog logs every request once it is served, with the request ID that
+// logging.RequestIDMiddleware put into its context. Upgraded WebSocket connections
+// are logged with status 101 when the handler returns.
+func AccessLog(logger *logging.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := newRecorder(w)
+
+		next.ServeHTTP(recorder, r)
+
+		logger.InfoContext(
+			r.Context(),
+			"Request served",
+			"remote", r.RemoteAddr,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
+
+// recorder notes the status and size of a response, and whether it was started
+type recorder struct {
+	http.ResponseWriter
+	status  int
+	bytes   int64
+	started bool
+}
+
+func newRecorder(w http.ResponseWriter) *recorder {
+	return &recorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rw *recorder) WriteHeader(code int) {
+	if !rw.started {
+		rw.status = code
+		// Informational responses leave the final one to come
+		rw.started = code >= http.StatusOK
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recorder) Write(b []byte) (int, error) {
+	rw.started = true
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streamed responses through
+func (rw *recorder) Flush() {
+	rw.started = true
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Hijack hands the connection over to a WebSocket upgrade
+func (rw *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil {
+		rw.status = http.StatusSwitchingProtocols
+		rw.started = true
+	}
+	return conn, buf, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *recorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
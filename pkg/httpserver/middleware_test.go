@@ -0,0 +1,272 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+)
+
+// logBuffer collects the JSON lines of a logger, from any goroutine
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records returns the lines logged with msg
+func (b *logBuffer) records(t *testing.T, msg string) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		if record["msg"] == msg {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// waitRecord waits for the single line logged with msg, which the server may write
+// after the client has its response
+func (b *logBuffer) waitRecord(t *testing.T, msg string) map[string]any {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		records := b.records(t, msg)
+		if len(records) == 1 {
+			return records[0]
+		}
+		if len(records) > 1 || time.Now().After(deadline) {
+			t.Fatalf("logged %q %d times, want once", msg, len(records))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTestLogger() (*logging.Logger, *logBuffer) {
+	logs := &logBuffer{}
+	return &logging.Logger{Logger: slog.New(slog.NewJSONHandler(logs, nil))}, logs
+}
+
+func TestRecoverAnswers500WithRequestID(t *testing.T) {
+	logger, logs := newTestLogger()
+	handler := Wrap(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	before := panicsTotal.Value()
+	req := httptest.NewRequest(http.MethodGet, "/items", nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(logging.RequestIDHeader, "req-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+		t.Errorf("Content-Type = %q, want JSON", got)
+	}
+	var body apierror.Error
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %s: %v", rec.Body, err)
+	}
+	want := apierror.Error{Code: apierror.CodeInternal, Message: "Internal server error", RequestID: "req-123"}
+	if body.Code != want.Code || body.Message != want.Message || body.RequestID != want.RequestID {
+		t.Errorf("body = %+v, want %+v", body, want)
+	}
+	if strings.Contains(rec.Body.String(), "boom") {
+		t.Error("the panic value leaked into the response")
+	}
+	if got := panicsTotal.Value() - before; got != 1 {
+		t.Errorf("http_handler_panics_total grew by %v, want 1", got)
+	}
+
+	panicked := logs.waitRecord(t, "Handler panicked")
+	if panicked["panic"] != "boom" || !strings.Contains(panicked["stack"].(string), "middleware_test.go") {
+		t.Errorf("panic logged as %v", panicked)
+	}
+	// The access log sits outside Recover, so it sees the 500
+	if served := logs.waitRecord(t, "Request served"); served["status"] != float64(http.StatusInternalServerError) {
+		t.Errorf("access log status = %v, want 500", served["status"])
+	}
+}
+
+// headerRecorder notes every code written to the client's writer
+type headerRecorder struct {
+	*httptest.ResponseRecorder
+	codes []int
+}
+
+func (w *headerRecorder) WriteHeader(code int) {
+	w.codes = append(w.codes, code)
+	w.ResponseRecorder.WriteHeader(code)
+}
+
+func TestRecoverAfterResponseStarted(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w http.ResponseWriter)
+		want  int
+	}{
+		{"header written", func(w http.ResponseWriter) { w.WriteHeader(http.StatusAccepted) }, http.StatusAccepted},
+		{"body written", func(w http.ResponseWriter) { io.WriteString(w, "partial") }, http.StatusOK},
+		{"flushed", func(w http.ResponseWriter) { w.(http.Flusher).Flush() }, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newTestLogger()
+			handler := Recover(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tt.write(w)
+				panic("midway")
+			}))
+
+			w := &headerRecorder{ResponseRecorder: httptest.NewRecorder()}
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stream", nil))
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if len(w.codes) > 1 {
+				t.Errorf("wrote the codes %v, want a single header", w.codes)
+			}
+			if strings.Contains(w.Body.String(), "Internal server error") {
+				t.Errorf("error envelope appended to the started response: %q", w.Body)
+			}
+			logs.waitRecord(t, "Handler panicked")
+		})
+	}
+}
+
+func TestRecoverInformationalHeaderDoesNotStartResponse(t *testing.T) {
+	logger, _ := newTestLogger()
+	handler := Recover(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusEarlyHints)
+		panic("after hints")
+	}))
+
+	w := &headerRecorder{ResponseRecorder: httptest.NewRecorder()}
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !slices.Equal(w.codes, []int{http.StatusEarlyHints, http.StatusInternalServerError}) || !strings.Contains(w.Body.String(), "Internal server error") {
+		t.Errorf("after an informational header: codes %v, body %q, want 103 then the 500 envelope", w.codes, w.Body)
+	}
+}
+
+func TestRecoverRepanicsAbortHandler(t *testing.T) {
+	logger, logs := newTestLogger()
+	handler := Recover(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	before := panicsTotal.Value()
+	rec := httptest.NewRecorder()
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want http.ErrAbortHandler passed on", recovered)
+			}
+		}()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+
+	if rec.Body.Len() != 0 {
+		t.Errorf("aborted response has a body: %q", rec.Body)
+	}
+	if got := panicsTotal.Value() - before; got != 0 {
+		t.Errorf("http_handler_panics_total grew by %v for an abort", got)
+	}
+	if records := logs.records(t, "Handler panicked"); len(records) != 0 {
+		t.Errorf("abort logged as a panic: %v", records)
+	}
+}
+
+func TestAccessLogHijackedConnection(t *testing.T) {
+	logger, logs := newTestLogger()
+	srv := httptest.NewServer(AccessLog(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack through the access log: %v", err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		buf.Flush()
+	})))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := rawRequest(srv.Listener.Addr().String(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+
+	served := logs.waitRecord(t, "Request served")
+	if served["status"] != float64(http.StatusSwitchingProtocols) {
+		t.Errorf("access log status = %v, want 101", served["status"])
+	}
+	if served["path"] != "/ws" {
+		t.Errorf("access log path = %v, want /ws", served["path"])
+	}
+}
+
+func TestAccessLogStatusAndBytes(t *testing.T) {
+	logger, logs := newTestLogger()
+	handler := AccessLog(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "created")
+		// A later code does not replace the one sent
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/items", nil))
+
+	served := logs.waitRecord(t, "Request served")
+	if served["status"] != float64(http.StatusCreated) || served["bytes"] != float64(len("created")) {
+		t.Errorf("access log = %v, want status 201 and 7 bytes", served)
+	}
+}
+
+// rawRequest sends req over a connection of its own and reads the response head,
+// which http.Client does not hand back for a 101
+func rawRequest(addr string, req *http.Request) (*http.Response, error) {
+	conn, err := (&net.Dialer{Timeout: 2 * time.Second}).Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	return http.ReadResponse(bufio.NewReader(conn), req)
+}
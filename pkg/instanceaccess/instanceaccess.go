@@ -0,0 +1,178 @@
+// Package instanceaccess asks the workflow engine whether a user may see a workflow
+// instance, so that services pushing instance events, such as the WebSocket gateway,
+// leave that decision to the engine's GET /api/v1/instances/:id/can-view.
+//
+// Decisions are cached per token and instance for a short TTL, denials included, so
+// a client joining and leaving an instance's channel does not reach the engine every
+// time. A user who loses access keeps it for at most the TTL.
+package instanceaccess
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reasons of a Decision the engine does not give
+const (
+	ReasonNotFound        = "not_found"       // the instance does not exist, or its ID is invalid
+	ReasonUnauthenticated = "unauthenticated" // the engine refused the token
+)
+
+// Decision is the engine's answer for one token and instance
+type Decision struct {
+	InstanceID string `json:"instance_id"`
+	Allowed    bool   `json:"allowed"`
+	// Why: "creator", "org" or "role" when allowed, otherwise "denied" or one of the
+	// reasons above
+	Reason string `json:"reason"`
+}
+
+// Config describes how to reach the engine and how long to reuse its answers
+type Config struct {
+	// Base URL of the workflow engine
+	EngineURL string
+	// How long a decision is reused, 0 for not at all
+	CacheTTL time.Duration
+	// Most decisions cached at once; 0 means 10000
+	MaxEntries int
+	// Client sending the requests; nil uses http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// Client answers can-view questions through the engine, caching the answers
+type Client struct {
+	cfg Config
+
+	mu    sync.Mutex
+	cache map[cacheKey]cached
+}
+
+// cacheKey identifies a decision; the token is hashed so the cache does not hold it
+type cacheKey struct {
+	token      [sha256.Size]byte
+	instanceID string
+}
+
+type cached struct {
+	decision  Decision
+	expiresAt time.Time
+}
+
+// NewClient makes a client of the engine at cfg.EngineURL
+func NewClient(cfg Config) *Client {
+	cfg.EngineURL = strings.TrimSuffix(cfg.EngineURL, "/")
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = 10000
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	return &Client{
+		cfg:   cfg,
+		cache: make(map[cacheKey]cached),
+	}
+}
+
+// CanView asks whether the user of token may see the instance. Denials, including
+// unknown instances and refused tokens, are decisions rather than errors; an error
+// means the engine could not be asked, and is not cached.
+func (c *Client) CanView(ctx context.Context, instanceID, token string) (Decision, error) {
+	if token == "" {
+		return Decision{InstanceID: instanceID, Reason: ReasonUnauthenticated}, nil
+	}
+	key := cacheKey{token: sha256.Sum256([]byte(token)), instanceID: instanceID}
+	if decision, ok := c.lookup(key); ok {
+		return decision, nil
+	}
+
+	decision, err := c.ask(ctx, instanceID, token)
+	if err != nil {
+		return Decision{}, err
+	}
+	c.store(key, decision)
+	return decision, nil
+}
+
+// ask sends the request to the engine
+func (c *Client) ask(ctx context.Context, instanceID, token string) (Decision, error) {
+	endpoint := c.cfg.EngineURL + "/api/v1/instances/" + url.PathEscape(instanceID) + "/can-view"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Decision{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return Decision{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusBadRequest:
+		io.Copy(io.Discard, resp.Body)
+		return Decision{InstanceID: instanceID, Reason: ReasonNotFound}, nil
+	case http.StatusUnauthorized:
+		io.Copy(io.Discard, resp.Body)
+		return Decision{InstanceID: instanceID, Reason: ReasonUnauthenticated}, nil
+	default:
+		return Decision{}, fmt.Errorf("workflow engine answered %s", resp.Status)
+	}
+
+	var decision Decision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return Decision{}, fmt.Errorf("failed to decode can-view answer: %w", err)
+	}
+	if decision.InstanceID == "" {
+		return Decision{}, errors.New("workflow engine answered can-view without an instance")
+	}
+	return decision, nil
+}
+
+// lookup returns the cached decision for key unless it expired
+func (c *Client) lookup(key cacheKey) (Decision, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.cache[key]
+	if !ok {
+		return Decision{}, false
+	}
+	if !time.Now().Before(entry.expiresAt) {
+		delete(c.cache, key)
+		return Decision{}, false
+	}
+	return entry.decision, true
+}
+
+// store caches decision for key. A full cache first drops its expired entries and,
+// if that is not enough, all of them.
+func (c *Client) store(key cacheKey, decision Decision) {
+	if c.cfg.CacheTTL <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.cache) >= c.cfg.MaxEntries {
+		for k, entry := range c.cache {
+			if !now.Before(entry.expiresAt) {
+				delete(c.cache, k)
+			}
+		}
+		if len(c.cache) >= c.cfg.MaxEntries {
+			clear(c.cache)
+		}
+	}
+	c.cache[key] = cached{decision: decision, expiresAt: now.Add(c.cfg.CacheTTL)}
+}
@@ -0,0 +1,247 @@
+package instanceaccess
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEngine answers can-view with the status and reason set for each token,
+// counting the requests it gets
+type fakeEngine struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	answers  map[string]answer // by token
+	requests int
+}
+
+type answer struct {
+	status int
+	reason string // of a 200, "" for a denial
+}
+
+func newFakeEngine(t *testing.T) *fakeEngine {
+	t.Helper()
+	f := &fakeEngine{answers: make(map[string]answer)}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		instanceID, prefixed := strings.CutPrefix(r.URL.Path, "/api/v1/instances/")
+		instanceID, suffixed := strings.CutSuffix(instanceID, "/can-view")
+		if !prefixed || !suffixed || r.Method != http.MethodGet {
+			http.NotFound(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		f.mu.Lock()
+		f.requests++
+		a, ok := f.answers[token]
+		f.mu.Unlock()
+		if !ok {
+			a = answer{status: http.StatusUnauthorized}
+		}
+		if a.status != http.StatusOK {
+			http.Error(w, http.StatusText(a.status), a.status)
+			return
+		}
+		decision := Decision{InstanceID: instanceID, Allowed: a.reason != "", Reason: a.reason}
+		if !decision.Allowed {
+			decision.Reason = "denied"
+		}
+		json.NewEncoder(w).Encode(decision)
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// answer sets how the engine answers token from now on
+func (f *fakeEngine) answer(token string, a answer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.answers[token] = a
+}
+
+func (f *fakeEngine) requestCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+// canView asks c and fails the test on an error
+func canView(t *testing.T, c *Client, instanceID, token string) Decision {
+	t.Helper()
+	decision, err := c.CanView(context.Background(), instanceID, token)
+	if err != nil {
+		t.Fatalf("CanView(%s): %v", instanceID, err)
+	}
+	return decision
+}
+
+func TestCanViewDecisions(t *testing.T) {
+	engine := newFakeEngine(t)
+	engine.answer("creator", answer{status: http.StatusOK, reason: "creator"})
+	engine.answer("stranger", answer{status: http.StatusOK})
+	engine.answer("missing", answer{status: http.StatusNotFound})
+	engine.answer("malformed", answer{status: http.StatusBadRequest})
+	c := NewClient(Config{EngineURL: engine.server.URL + "/"})
+
+	tests := []struct {
+		token   string
+		allowed bool
+		reason  string
+	}{
+		{"creator", true, "creator"},
+		{"stranger", false, "denied"},
+		{"missing", false, ReasonNotFound},
+		{"malformed", false, ReasonNotFound},
+		{"expired", false, ReasonUnauthenticated},
+	}
+	for _, tt := range tests {
+		decision := canView(t, c, "instance-1", tt.token)
+		if decision.Allowed != tt.allowed || decision.Reason != tt.reason || decision.InstanceID != "instance-1" {
+			t.Errorf("CanView with %s = %+v, want allowed %v for %q", tt.token, decision, tt.allowed, tt.reason)
+		}
+	}
+}
+
+func TestCanViewWithoutTokenAsksNothing(t *testing.T) {
+	engine := newFakeEngine(t)
+	c := NewClient(Config{EngineURL: engine.server.URL, CacheTTL: time.Minute})
+
+	if decision := canView(t, c, "instance-1", ""); decision.Allowed || decision.Reason != ReasonUnauthenticated {
+		t.Errorf("CanView without a token = %+v, want unauthenticated", decision)
+	}
+	if n := engine.requestCount(); n != 0 {
+		t.Errorf("engine got %d requests, want none", n)
+	}
+}
+
+// Decisions, denials included, are reused until the TTL passes, so a change of
+// the engine's answer reaches the client only then
+func TestCanViewCacheExpiry(t *testing.T) {
+	const ttl = 100 * time.Millisecond
+	tests := []struct {
+		name          string
+		before, after answer
+		wantAllowed   bool // before the TTL, then the opposite
+	}{
+		{
+			name:        "a revoked access is denied once the TTL passes",
+			before:      answer{status: http.StatusOK, reason: "org"},
+			after:       answer{status: http.StatusOK},
+			wantAllowed: true,
+		},
+		{
+			name:        "a denial holds until the TTL passes",
+			before:      answer{status: http.StatusOK},
+			after:       answer{status: http.StatusOK, reason: "role"},
+			wantAllowed: false,
+		},
+		{
+			name:        "an instance not found holds until the TTL passes",
+			before:      answer{status: http.StatusNotFound},
+			after:       answer{status: http.StatusOK, reason: "creator"},
+			wantAllowed: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := newFakeEngine(t)
+			engine.answer("token", tt.before)
+			c := NewClient(Config{EngineURL: engine.server.URL, CacheTTL: ttl})
+
+			if got := canView(t, c, "instance-1", "token").Allowed; got != tt.wantAllowed {
+				t.Fatalf("first decision allowed %v, want %v", got, tt.wantAllowed)
+			}
+			engine.answer("token", tt.after)
+			if got := canView(t, c, "instance-1", "token").Allowed; got != tt.wantAllowed {
+				t.Errorf("decision within the TTL allowed %v, want the cached %v", got, tt.wantAllowed)
+			}
+			if n := engine.requestCount(); n != 1 {
+				t.Errorf("engine got %d requests within the TTL, want 1", n)
+			}
+
+			time.Sleep(ttl)
+			if got := canView(t, c, "instance-1", "token").Allowed; got == tt.wantAllowed {
+				t.Errorf("decision after the TTL allowed %v, want the engine's new answer", got)
+			}
+			if n := engine.requestCount(); n != 2 {
+				t.Errorf("engine got %d requests, want 2", n)
+			}
+		})
+	}
+}
+
+// The cache keeps a decision per token and instance
+func TestCanViewCachesPerTokenAndInstance(t *testing.T) {
+	engine := newFakeEngine(t)
+	engine.answer("alice", answer{status: http.StatusOK, reason: "creator"})
+	engine.answer("bob", answer{status: http.StatusOK})
+	c := NewClient(Config{EngineURL: engine.server.URL, CacheTTL: time.Minute})
+
+	canView(t, c, "instance-1", "alice")
+	if canView(t, c, "instance-1", "bob").Allowed {
+		t.Error("bob was given alice's cached decision")
+	}
+	canView(t, c, "instance-2", "alice")
+	canView(t, c, "instance-1", "alice")
+	if n := engine.requestCount(); n != 3 {
+		t.Errorf("engine got %d requests, want 3", n)
+	}
+}
+
+func TestCanViewWithoutTTLAlwaysAsks(t *testing.T) {
+	engine := newFakeEngine(t)
+	engine.answer("token", answer{status: http.StatusOK, reason: "creator"})
+	c := NewClient(Config{EngineURL: engine.server.URL})
+
+	for range 3 {
+		canView(t, c, "instance-1", "token")
+	}
+	if n := engine.requestCount(); n != 3 {
+		t.Errorf("engine got %d requests, want 3", n)
+	}
+}
+
+// An engine failing is an error, not a denial, and is asked again next time
+func TestCanViewDoesNotCacheErrors(t *testing.T) {
+	engine := newFakeEngine(t)
+	engine.answer("token", answer{status: http.StatusServiceUnavailable})
+	c := NewClient(Config{EngineURL: engine.server.URL, CacheTTL: time.Minute})
+
+	if _, err := c.CanView(context.Background(), "instance-1", "token"); err == nil {
+		t.Fatal("CanView succeeded while the engine fails")
+	}
+	engine.answer("token", answer{status: http.StatusOK, reason: "creator"})
+	if !canView(t, c, "instance-1", "token").Allowed {
+		t.Error("the engine's failure was cached")
+	}
+}
+
+// A full cache makes room for a new decision
+func TestCanViewBoundsCache(t *testing.T) {
+	engine := newFakeEngine(t)
+	engine.answer("token", answer{status: http.StatusOK, reason: "creator"})
+	c := NewClient(Config{EngineURL: engine.server.URL, CacheTTL: time.Minute, MaxEntries: 2})
+
+	for _, instanceID := range []string{"instance-1", "instance-2", "instance-3"} {
+		canView(t, c, instanceID, "token")
+	}
+	c.mu.Lock()
+	entries := len(c.cache)
+	c.mu.Unlock()
+	if entries > 2 {
+		t.Errorf("cache holds %d decisions, want at most 2", entries)
+	}
+	if !canView(t, c, "instance-3", "token").Allowed {
+		t.Error("the newest decision was dropped")
+	}
+	if n := engine.requestCount(); n != 3 {
+		t.Errorf("engine got %d requests, want 3", n)
+	}
+}
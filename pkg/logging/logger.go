@@ -0,0 +1,104 @@
+// Package logging is the slog-based structured logger shared by the Chorus Go
+// services, with request IDs and trace IDs carried in the context into every log
+// line.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Logger wraps slog.Logger for structured logging
+type Logger struct {
+	*slog.Logger
+}
+
+// NewLogger creates a new structured logger writing JSON to stdout at the level
+// named by LOG_LEVEL (debug, info, warn or error; default info)
+func NewLogger() *Logger {
+	// Create a JSON handler for structured logging
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: ParseLevel(os.Getenv("LOG_LEVEL")),
+	})
+
+	logger := slog.New(contextHandler{handler})
+
+	return &Logger{
+		Logger: logger,
+	}
+}
+
+// ParseLevel returns the level named by value, or info if it names none
+func ParseLevel(value string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// Info logs an info level message with optional key-value pairs
+func (l *Logger) Info(msg string, args ...interface{}) {
+	l.Logger.Info(msg, args...)
+}
+
+// Error logs an error level message with optional key-value pairs
+func (l *Logger) Error(msg string, args ...interface{}) {
+	l.Logger.Error(msg, args...)
+}
+
+// Warn logs a warning level message with optional key-value pairs
+func (l *Logger) Warn(msg string, args ...interface{}) {
+	l.Logger.Warn(msg, args...)
+}
+
+// Debug logs a debug level message with optional key-value pairs
+func (l *Logger) Debug(msg string, args ...interface{}) {
+	l.Logger.Debug(msg, args...)
+}
+
+// Fatal logs a fatal level message and exits the program
+func (l *Logger) Fatal(msg string, args ...interface{}) {
+	l.Logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// With returns a new logger with the given key-value pairs added to the context
+func (l *Logger) With(args ...interface{}) *Logger {
+	return &Logger{
+		Logger: l.Logger.With(args...),
+	}
+}
+
+// contextHandler adds the request ID and the trace of the context to records logged
+// with the *Context methods
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
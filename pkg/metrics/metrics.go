@@ -0,0 +1,327 @@
+// Package metrics is a small registry of counters, gauges and histograms rendered in
+// the Prometheus text format, shared by the Chorus services. Each service serves
+// Default on its /metrics endpoint.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry holds a set of metric families and renders them in the Prometheus text format
+type Registry struct {
+	mu       sync.RWMutex
+	families map[string]family
+}
+
+type family interface {
+	write(sb *strings.Builder)
+}
+
+// Default is the registry exposed on the /metrics endpoint
+var Default = NewRegistry()
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]family),
+	}
+}
+
+// Counter registers (or returns the existing) counter family with the given label names
+func (r *Registry) Counter(name, help string, labelNames ...string) *CounterVec {
+	return register(r, name, func() *CounterVec {
+		return &CounterVec{vec: newVec(name, help, "counter", labelNames)}
+	})
+}
+
+// Gauge registers (or returns the existing) gauge family with the given label names
+func (r *Registry) Gauge(name, help string, labelNames ...string) *GaugeVec {
+	return register(r, name, func() *GaugeVec {
+		return &GaugeVec{vec: newVec(name, help, "gauge", labelNames)}
+	})
+}
+
+// Histogram registers (or returns the existing) histogram family with the given buckets
+func (r *Registry) Histogram(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return register(r, name, func() *HistogramVec {
+		sorted := append([]float64(nil), buckets...)
+		sort.Float64s(sorted)
+		return &HistogramVec{
+			name:       name,
+			help:       help,
+			labelNames: labelNames,
+			buckets:    sorted,
+			series:     make(map[string]*histogramSeries),
+		}
+	})
+}
+
+func register[T family](r *Registry, name string, create func() T) T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.families[name]; ok {
+		if typed, ok := existing.(T); ok {
+			return typed
+		}
+		panic(fmt.Sprintf("metric %s already registered with a different type", name))
+	}
+
+	f := create()
+	r.families[name] = f
+	return f
+}
+
+// Handler serves the registry in the Prometheus text exposition format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write([]byte(r.Render()))
+	})
+}
+
+// Render returns the current value of every metric in the Prometheus text format
+func (r *Registry) Render() string {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		r.families[name].write(&sb)
+	}
+	r.mu.RUnlock()
+
+	return sb.String()
+}
+
+// Handler serves the default registry
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// vec is a family of float values keyed by label values
+type vec struct {
+	mu         sync.Mutex
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	values     map[string]float64
+	labels     map[string][]string
+}
+
+func newVec(name, help, kind string, labelNames []string) *vec {
+	return &vec{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		values:     make(map[string]float64),
+		labels:     make(map[string][]string),
+	}
+}
+
+func (v *vec) update(labelValues []string, fn func(float64) float64) {
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, ok := v.labels[key]; !ok {
+		v.labels[key] = append([]string(nil), labelValues...)
+	}
+	v.values[key] = fn(v.values[key])
+}
+
+func (v *vec) get(labelValues []string) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.values[strings.Join(labelValues, "\xff")]
+}
+
+func (v *vec) write(sb *strings.Builder) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(sb, "%s%s %s\n", v.name, formatLabels(v.labelNames, v.labels[key], "", ""), formatValue(v.values[key]))
+	}
+}
+
+// CounterVec is a monotonically increasing metric partitioned by labels
+type CounterVec struct {
+	vec *vec
+}
+
+// Inc increments the counter for the given label values by one
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter for the given label values by delta
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.vec.update(labelValues, func(current float64) float64 { return current + delta })
+}
+
+// Value returns the current value for the given label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	return c.vec.get(labelValues)
+}
+
+func (c *CounterVec) write(sb *strings.Builder) {
+	c.vec.write(sb)
+}
+
+// GaugeVec is a metric that can go up and down, partitioned by labels
+type GaugeVec struct {
+	vec *vec
+}
+
+// Set sets the gauge for the given label values
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.vec.update(labelValues, func(float64) float64 { return value })
+}
+
+// Add adds delta (which may be negative) to the gauge for the given label values
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.vec.update(labelValues, func(current float64) float64 { return current + delta })
+}
+
+// Value returns the current value for the given label values
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	return g.vec.get(labelValues)
+}
+
+func (g *GaugeVec) write(sb *strings.Builder) {
+	g.vec.write(sb)
+}
+
+// HistogramVec samples observations into cumulative buckets, partitioned by labels
+type HistogramVec struct {
+	mu         sync.Mutex
+	name       string
+	help       string
+	labelNames []string
+	buckets    []float64
+	series     map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records a single observation for the given label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			labels: append([]string(nil), labelValues...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+// Stats returns how many observations were recorded for the given label values and
+// their sum
+func (h *HistogramVec) Stats(labelValues ...string) (uint64, float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[strings.Join(labelValues, "\xff")]
+	if !ok {
+		return 0, 0
+	}
+	return s.count, s.sum
+}
+
+func (h *HistogramVec) write(sb *strings.Builder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(sb, "%s_bucket%s %d\n", h.name, formatLabels(h.labelNames, s.labels, "le", formatValue(bound)), s.counts[i])
+		}
+		fmt.Fprintf(sb, "%s_bucket%s %d\n", h.name, formatLabels(h.labelNames, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(sb, "%s_sum%s %s\n", h.name, formatLabels(h.labelNames, s.labels, "", ""), formatValue(s.sum))
+		fmt.Fprintf(sb, "%s_count%s %d\n", h.name, formatLabels(h.labelNames, s.labels, "", ""), s.count)
+	}
+}
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, value))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", extraName, extraValue))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case value == math.Trunc(value) && math.Abs(value) < 1e15:
+		return fmt.Sprintf("%d", int64(value))
+	default:
+		return fmt.Sprintf("%g", value)
+	}
+}
@@ -0,0 +1,136 @@
+// Package presence is the client of the presence service's gRPC interface, for
+// services that check presence without going through HTTP and JSON.
+//
+// The protocol is defined in presencepb/presence.proto. Calls authenticate with a
+// service API key or a service token sent as call metadata, matching what the
+// presence service accepts on its HTTP routes.
+package presence
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"chorus/pkg/env"
+	"chorus/pkg/presence/presencepb"
+	"chorus/pkg/tracing"
+)
+
+// Metadata keys carrying the caller's credentials
+const (
+	APIKeyMetadata        = "x-api-key"
+	AuthorizationMetadata = "authorization"
+)
+
+// Config describes how to reach the presence gRPC server
+type Config struct {
+	// host:port of the presence service's gRPC listener
+	Address string
+	// PEM bundle used to verify the server; empty dials without TLS
+	CAFile string
+	// Service credentials; APIKey wins when both are set
+	APIKey string
+	Token  string
+}
+
+// ConfigFromEnv reads PRESENCE_GRPC_ADDR, PRESENCE_GRPC_CA_FILE, PRESENCE_GRPC_API_KEY
+// and PRESENCE_GRPC_TOKEN
+func ConfigFromEnv() Config {
+	return Config{
+		Address: env.String("PRESENCE_GRPC_ADDR", "presence-service:9081"),
+		CAFile:  env.String("PRESENCE_GRPC_CA_FILE", ""),
+		APIKey:  env.String("PRESENCE_GRPC_API_KEY", ""),
+		Token:   env.String("PRESENCE_GRPC_TOKEN", ""),
+	}
+}
+
+// Client is a connection to the presence service. The embedded client exposes
+// every RPC; Close releases the connection.
+type Client struct {
+	presencepb.PresenceServiceClient
+	conn *grpc.ClientConn
+}
+
+// NewClient connects lazily to cfg.Address; the first call dials. Extra options are
+// appended to the ones derived from cfg.
+func NewClient(cfg Config, opts ...grpc.DialOption) (*Client, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("presence gRPC address is required")
+	}
+
+	transport := insecure.NewCredentials()
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read presence CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		transport = credentials.NewTLS(&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})
+	}
+
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(transport),
+		grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(tracing.StreamClientInterceptor()),
+	}
+	if cfg.APIKey != "" || cfg.Token != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(serviceCredentials{
+			apiKey: cfg.APIKey,
+			token:  cfg.Token,
+			secure: cfg.CAFile != "",
+		}))
+	}
+
+	conn, err := grpc.NewClient(cfg.Address, append(dialOpts, opts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create presence client: %w", err)
+	}
+	return &Client{
+		PresenceServiceClient: presencepb.NewPresenceServiceClient(conn),
+		conn:                  conn,
+	}, nil
+}
+
+// Close closes the underlying connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Online reports whether the user has a live session, a shorthand for IsOnline
+func (c *Client) Online(ctx context.Context, userID string) (bool, error) {
+	resp, err := c.IsOnline(ctx, &presencepb.IsOnlineRequest{UserId: userID})
+	if err != nil {
+		return false, err
+	}
+	return resp.GetOnline(), nil
+}
+
+// serviceCredentials attaches the API key or token to every call
+type serviceCredentials struct {
+	apiKey string
+	token  string
+	secure bool
+}
+
+func (c serviceCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	if c.apiKey != "" {
+		return map[string]string{APIKeyMetadata: c.apiKey}, nil
+	}
+	return map[string]string{AuthorizationMetadata: "Bearer " + c.token}, nil
+}
+
+// RequireTransportSecurity lets credentials travel in plaintext only when the
+// client was configured without TLS, as inside a private network
+func (c serviceCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
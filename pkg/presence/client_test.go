@@ -0,0 +1,46 @@
+package presence
+
+import "testing"
+
+func TestConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]string
+		want Config
+	}{
+		{
+			name: "unset",
+			want: Config{Address: "presence-service:9081"},
+		},
+		{
+			name: "blank",
+			vars: map[string]string{
+				"PRESENCE_GRPC_ADDR":    "  ",
+				"PRESENCE_GRPC_CA_FILE": "",
+				"PRESENCE_GRPC_API_KEY": "\t",
+				"PRESENCE_GRPC_TOKEN":   " \n",
+			},
+			want: Config{Address: "presence-service:9081"},
+		},
+		{
+			name: "set with surrounding whitespace",
+			vars: map[string]string{
+				"PRESENCE_GRPC_ADDR":    " presence:19081 ",
+				"PRESENCE_GRPC_CA_FILE": " /etc/chorus/ca.pem",
+				"PRESENCE_GRPC_API_KEY": "key\n",
+				"PRESENCE_GRPC_TOKEN":   " token ",
+			},
+			want: Config{Address: "presence:19081", CAFile: "/etc/chorus/ca.pem", APIKey: "key", Token: "token"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"PRESENCE_GRPC_ADDR", "PRESENCE_GRPC_CA_FILE", "PRESENCE_GRPC_API_KEY", "PRESENCE_GRPC_TOKEN"} {
+				t.Setenv(key, tt.vars[key])
+			}
+			if got := ConfigFromEnv(); got != tt.want {
+				t.Errorf("ConfigFromEnv = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+// Package tracing sets up OpenTelemetry tracing for the Chorus Go services and
+// propagates the W3C trace context (traceparent) on their HTTP and gRPC calls, so a
+// user action is a single trace across the gateway, the workflow engine and the
+// presence service.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"chorus/pkg/env"
+)
+
+// Name of the tracer every span is started with
+const instrumentationName = "chorus"
+
+// Header and queue field carrying the trace context
+const traceParentKey = "traceparent"
+
+// Setup installs the tracer provider of service and the W3C trace context
+// propagator. Spans are exported over OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT;
+// without it they are still made, so trace IDs reach the logs and the services
+// called, but are dropped. Sampling follows OTEL_TRACES_SAMPLER. The function
+// returned flushes the spans left at shutdown.
+func Setup(service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", service)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the service: %w", err)
+	}
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+
+	if endpoint := env.URL("OTEL_EXPORTER_OTLP_ENDPOINT", ""); endpoint != "" {
+		exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(strings.TrimSuffix(endpoint, "/")+"/v1/traces"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the OTLP exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span, as a child of the span of ctx if it has one
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// Fail records err on span and marks the span failed
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// TraceParent returns the traceparent of the span of ctx, or "" without one, to carry
+// the trace through a queue or a database row
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier[traceParentKey]
+}
+
+// WithTraceParent returns a copy of ctx continuing the trace of a traceparent saved by
+// TraceParent; spans started from it are children of the span that saved it
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{traceParentKey: traceParent})
+}
@@ -0,0 +1,203 @@
+// Package app assembles the presence service: its Redis client, background work,
+// HTTP routes and gRPC server. main runs it as a process, and the integration tests
+// run it in theirs.
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"chorus/pkg/auth"
+	"chorus/pkg/cors"
+	"chorus/pkg/httpserver"
+	"chorus/pkg/logging"
+	"chorus/pkg/metrics"
+	"chorus/pkg/presence/presencepb"
+	"chorus/pkg/tracing"
+	"chorus/presence-service/config"
+	"chorus/presence-service/handlers"
+	"chorus/presence-service/rpc"
+	"chorus/presence-service/services"
+)
+
+const (
+	// WriteTimeout is the write timeout of the HTTP server, which long polls answer within
+	WriteTimeout = 15 * time.Second
+
+	// Left between a long poll's answer and the write timeout, for the reads around it
+	watchTimeoutMargin = 3 * time.Second
+)
+
+// App is a configured presence service, its background work not yet started
+type App struct {
+	// Handler serves the HTTP API, health checks and metrics
+	Handler http.Handler
+	// GRPC serves the gRPC API
+	GRPC *grpc.Server
+
+	cfg             *config.Config
+	redis           *redis.Client
+	presenceService *services.PresenceService
+	stop            context.CancelFunc
+	background      sync.WaitGroup
+}
+
+// New validates cfg, connects to Redis and builds the service's routes
+func New(cfg *config.Config, logger *logging.Logger) (*App, error) {
+	if err := cfg.CORS.Validate(cfg.Environment == "production"); err != nil {
+		return nil, fmt.Errorf("invalid CORS configuration: %w", err)
+	}
+
+	if cfg.AuthEnabled {
+		if err := cfg.JWT.Validate(cfg.Environment == "production"); err != nil {
+			return nil, fmt.Errorf("invalid JWT configuration: %w", err)
+		}
+	} else if cfg.Environment == "production" {
+		return nil, errors.New("PRESENCE_AUTH_ENABLED=false is not allowed in production")
+	} else {
+		logger.Warn("Authentication is disabled; user_id is taken from requests as is")
+	}
+	if cfg.SnapshotInterval > 0 && cfg.SnapshotDir == "" && cfg.SnapshotWebhookURL == "" {
+		return nil, errors.New("PRESENCE_SNAPSHOT_INTERVAL_MINUTES needs PRESENCE_SNAPSHOT_DIR or PRESENCE_SNAPSHOT_WEBHOOK_URL")
+	}
+
+	// Initialize Redis client
+	redisClient := services.NewRedisClient(cfg, logger)
+
+	// Initialize presence service
+	presenceService := services.NewPresenceService(redisClient, logger)
+	presenceService.SetPresenceTTL(cfg.PresenceTTL)
+	presenceService.SetTTLBounds(cfg.MinPresenceTTL, cfg.MaxPresenceTTL)
+	presenceService.SetMaxRoomsPerUser(cfg.MaxRoomsPerUser)
+	presenceService.SetRecentRetention(cfg.RecentRetention)
+	presenceService.SetHistoryLength(cfg.HistoryLength)
+	presenceService.SetAwayAfter(cfg.AwayAfter)
+	presenceService.SetTypingTTL(cfg.TypingTTL)
+	presenceService.SetHeartbeatLimits(cfg.HeartbeatInterval, cfg.HeartbeatMuteThreshold, cfg.HeartbeatMuteDuration)
+	presenceService.SetSweepBatchSize(cfg.JanitorBatchSize)
+	presenceService.SetSuppressDuration(cfg.SuppressDuration)
+
+	// Create handlers
+	// Long polls must answer before the server's write timeout cuts them off
+	if cfg.WatchTimeout > WriteTimeout-watchTimeoutMargin {
+		return nil, fmt.Errorf("PRESENCE_WATCH_TIMEOUT_SECONDS is too long, the most is %d", int((WriteTimeout - watchTimeoutMargin).Seconds()))
+	}
+	presenceHandler := handlers.NewPresenceHandler(presenceService, logger, cfg.MaxOnlineUsers, cfg.MaxBatchSize, cfg.WatchTimeout)
+
+	// Setup routes
+	presenceMux := http.NewServeMux()
+	presenceMux.HandleFunc("/presence/heartbeat", presenceHandler.Heartbeat)
+	presenceMux.HandleFunc("/presence/heartbeat/batch", presenceHandler.HeartbeatBatch)
+	presenceMux.HandleFunc("/presence/status", presenceHandler.GetStatus)
+	presenceMux.HandleFunc("/presence/online", presenceHandler.GetOnlineUsers)
+	presenceMux.HandleFunc("/presence/online/count", presenceHandler.CountOnlineUsers)
+	presenceMux.HandleFunc("/presence/recent", presenceHandler.GetRecentUsers)
+	presenceMux.HandleFunc("/presence/history", presenceHandler.GetHistory)
+	presenceMux.HandleFunc("/presence/export", presenceHandler.Export)
+	presenceMux.HandleFunc("/presence/watch", presenceHandler.Watch)
+	presenceMux.HandleFunc("/presence/users/{user_id}", presenceHandler.ForceOffline)
+	presenceMux.HandleFunc("/presence/admin/audit", presenceHandler.GetAdminAudit)
+	presenceMux.HandleFunc("/presence/status-message", presenceHandler.SetStatusMessage)
+	presenceMux.HandleFunc("/presence/dnd", presenceHandler.SetDND)
+	presenceMux.HandleFunc("/presence/override", presenceHandler.SetStatusOverride)
+	presenceMux.HandleFunc("/presence/typing", presenceHandler.Typing)
+	presenceMux.HandleFunc("/presence/rooms/{room_id}/join", presenceHandler.JoinRoom)
+	presenceMux.HandleFunc("/presence/rooms/{room_id}/leave", presenceHandler.LeaveRoom)
+	presenceMux.HandleFunc("/presence/rooms/{room_id}/online", presenceHandler.GetRoomOnlineUsers)
+
+	var presenceRoutes http.Handler = presenceMux
+	// The span and the request ID come first, so authentication failures are logged with them
+	unaryInterceptors := []grpc.UnaryServerInterceptor{tracing.UnaryServerInterceptor(), rpc.UnaryRequestID()}
+	streamInterceptors := []grpc.StreamServerInterceptor{tracing.StreamServerInterceptor(), rpc.StreamRequestID()}
+	var grpcOpts []grpc.ServerOption
+	if cfg.AuthEnabled {
+		validator := auth.NewValidator(cfg.JWT)
+		presenceRoutes = handlers.JWTAuth(validator, cfg.ServiceRole, cfg.ServiceAPIKeys, logger, presenceMux)
+
+		authenticator := rpc.NewAuthenticator(validator, cfg.ServiceRole, cfg.ServiceAPIKeys, logger)
+		unaryInterceptors = append(unaryInterceptors, authenticator.Unary())
+		streamInterceptors = append(streamInterceptors, authenticator.Stream())
+	}
+	grpcOpts = append(grpcOpts, grpc.ChainUnaryInterceptor(unaryInterceptors...), grpc.ChainStreamInterceptor(streamInterceptors...))
+	if cfg.GRPCTLSCertFile != "" || cfg.GRPCTLSKeyFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(cfg.GRPCTLSCertFile, cfg.GRPCTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load gRPC TLS certificate: %w", err)
+		}
+		grpcOpts = append(grpcOpts, grpc.Creds(creds))
+	} else if cfg.Environment == "production" {
+		logger.Warn("gRPC is served without TLS; set GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE unless the network is private")
+	}
+
+	grpcServer := grpc.NewServer(grpcOpts...)
+	presencepb.RegisterPresenceServiceServer(grpcServer, rpc.NewServer(presenceService, logger, cfg.MaxBatchSize))
+
+	mux := http.NewServeMux()
+	httpserver.RegisterHealth(mux, "presence-service", func(ctx context.Context) (interface{}, bool) {
+		return presenceService.Readiness(ctx)
+	})
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/presence/", presenceRoutes)
+
+	return &App{
+		Handler:         cors.New(cfg.CORS).Middleware(mux),
+		GRPC:            grpcServer,
+		cfg:             cfg,
+		redis:           redisClient,
+		presenceService: presenceService,
+		stop:            func() {},
+	}, nil
+}
+
+// Start starts the background work: receiving presence events, marking users
+// offline as their presence expires, and the snapshots if configured
+func (a *App) Start() {
+	ctx, stop := context.WithCancel(context.Background())
+	a.stop = stop
+	runInBackground := func(fn func()) {
+		a.background.Add(1)
+		go func() {
+			defer a.background.Done()
+			fn()
+		}()
+	}
+
+	// Hand presence events to long polls and gRPC watches
+	runInBackground(func() { a.presenceService.RunEvents(ctx) })
+
+	// Mark users offline as soon as their presence expires
+	runInBackground(func() { a.presenceService.WatchExpirations(ctx, a.cfg.ConfigureKeyspaceEvents) })
+
+	// And sweep those whose expiry notification was missed
+	runInBackground(func() { a.presenceService.RunJanitor(ctx, a.cfg.JanitorInterval) })
+
+	if a.cfg.SnapshotInterval > 0 {
+		runInBackground(func() {
+			a.presenceService.RunSnapshots(ctx, services.SnapshotConfig{
+				Interval:   a.cfg.SnapshotInterval,
+				Dir:        a.cfg.SnapshotDir,
+				WebhookURL: a.cfg.SnapshotWebhookURL,
+				Keep:       a.cfg.SnapshotKeep,
+			})
+		})
+	}
+}
+
+// Stop stops the background work and waits for it, before Redis is closed
+func (a *App) Stop() {
+	a.stop()
+	a.background.Wait()
+}
+
+// Close closes the Redis client
+func (a *App) Close() error {
+	return a.redis.Close()
+}
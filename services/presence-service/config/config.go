@@ -1,33 +1,165 @@
 package config
 
 import (
-	"os"
+	"errors"
+	"fmt"
 	"strconv"
 	"time"
+
+	"chorus/pkg/auth"
+	"chorus/pkg/cors"
+	"chorus/pkg/env"
 )
 
 type Config struct {
-	Port         string
-	RedisURL     string
-	RedisDB      int
-	PresenceTTL  time.Duration
+	Port                    string
+	GRPCPort                string
+	GRPCTLSCertFile         string // serve gRPC over TLS when set with GRPCTLSKeyFile
+	GRPCTLSKeyFile          string
+	Environment             string
+	RedisURL                string
+	RedisDB                 int
+	PresenceTTL             time.Duration // default lifetime of a session without heartbeats
+	MinPresenceTTL          time.Duration // bounds of the ttl_seconds a heartbeat may request
+	MaxPresenceTTL          time.Duration
+	ConfigureKeyspaceEvents bool          // enable Redis expiry notifications at startup
+	JanitorInterval         time.Duration // how often the online set is swept for expired users
+	JanitorBatchSize        int           // online set members read at a time when sweeping
+	MaxRoomsPerUser         int
+	MaxOnlineUsers          int           // most users GET /presence/online returns without pagination
+	MaxBatchSize            int           // most heartbeats in one POST /presence/heartbeat/batch
+	WatchTimeout            time.Duration // longest POST /presence/watch waits for a change
+	RecentRetention         time.Duration // how long users stay in the recently active set
+	HistoryLength           int           // status transitions kept per user
+	SnapshotInterval        time.Duration // how often presence snapshots are taken, 0 disables them
+	SnapshotDir             string        // directory snapshots are written to
+	SnapshotWebhookURL      string        // URL snapshots are POSTed to
+	SnapshotKeep            int           // snapshots kept in SnapshotDir, 0 keeps all
+	TypingTTL               time.Duration // lifetime of a typing indicator without refresh
+	AwayAfter               time.Duration // online sessions without interaction for this long count as away, 0 disables
+	HeartbeatInterval       time.Duration // shortest interval between heartbeats of a session, 0 for no limit
+	HeartbeatMuteThreshold  int           // rate limited heartbeats within a minute that mute a user
+	HeartbeatMuteDuration   time.Duration
+	SuppressDuration        time.Duration // how long heartbeats of a user forced offline are refused
+	AuthEnabled             bool          // require JWTs on /presence routes
+	ServiceRole             string        // role claim of tokens that may act for any user
+	ServiceAPIKeys          []string      // X-API-Key values of services that may act for any user
+	JWT                     auth.Config
+	CORS                    cors.Config
+
+	err error // from reading the environment
 }
 
 func LoadConfig() *Config {
-	presenceTTL, _ := strconv.Atoi(getEnv("PRESENCE_TTL_SECONDS", "120"))
-	redisDB, _ := strconv.Atoi(getEnv("REDIS_DB", "0"))
-	
-	return &Config{
-		Port:        getEnv("PORT", "8081"),
-		RedisURL:    getEnv("REDIS_URL", "redis://localhost:6379"),
-		RedisDB:     redisDB,
-		PresenceTTL: time.Duration(presenceTTL) * time.Second,
+	cfg := &Config{
+		Port:                    env.String("PORT", "8081"),
+		GRPCPort:                env.String("GRPC_PORT", "9081"),
+		GRPCTLSCertFile:         env.String("GRPC_TLS_CERT_FILE", ""),
+		GRPCTLSKeyFile:          env.String("GRPC_TLS_KEY_FILE", ""),
+		Environment:             env.String("ENVIRONMENT", "development"),
+		RedisURL:                env.URL("REDIS_URL", "redis://localhost:6379"),
+		RedisDB:                 env.Int("REDIS_DB", 0),
+		PresenceTTL:             env.Duration("PRESENCE_TTL_SECONDS", 120*time.Second, time.Second),
+		MinPresenceTTL:          env.Duration("PRESENCE_MIN_TTL_SECONDS", 30*time.Second, time.Second),
+		MaxPresenceTTL:          env.Duration("PRESENCE_MAX_TTL_SECONDS", 900*time.Second, time.Second),
+		ConfigureKeyspaceEvents: env.Bool("REDIS_CONFIGURE_KEYSPACE_EVENTS", true),
+		JanitorInterval:         env.Duration("PRESENCE_JANITOR_INTERVAL_SECONDS", 30*time.Second, time.Second),
+		JanitorBatchSize:        env.Int("PRESENCE_JANITOR_BATCH_SIZE", 500),
+		MaxRoomsPerUser:         env.Int("PRESENCE_MAX_ROOMS_PER_USER", 50),
+		MaxOnlineUsers:          env.Int("PRESENCE_MAX_ONLINE_USERS", 1000),
+		MaxBatchSize:            env.Int("PRESENCE_MAX_BATCH_SIZE", 1000),
+		WatchTimeout:            env.Duration("PRESENCE_WATCH_TIMEOUT_SECONDS", 10*time.Second, time.Second),
+		RecentRetention:         env.Duration("PRESENCE_RECENT_RETENTION_HOURS", 24*time.Hour, time.Hour),
+		HistoryLength:           env.Int("PRESENCE_HISTORY_LENGTH", 50),
+		SnapshotInterval:        env.Duration("PRESENCE_SNAPSHOT_INTERVAL_MINUTES", 0, time.Minute),
+		SnapshotDir:             env.String("PRESENCE_SNAPSHOT_DIR", ""),
+		SnapshotWebhookURL:      env.URL("PRESENCE_SNAPSHOT_WEBHOOK_URL", ""),
+		SnapshotKeep:            env.Int("PRESENCE_SNAPSHOT_KEEP", 48),
+		TypingTTL:               env.Duration("PRESENCE_TYPING_TTL_SECONDS", 6*time.Second, time.Second),
+		AwayAfter:               env.Duration("PRESENCE_AWAY_AFTER_MINUTES", 5*time.Minute, time.Minute),
+		HeartbeatInterval:       env.Duration("PRESENCE_HEARTBEAT_MIN_INTERVAL_SECONDS", 5*time.Second, time.Second),
+		HeartbeatMuteThreshold:  env.Int("PRESENCE_HEARTBEAT_MUTE_THRESHOLD", 30),
+		HeartbeatMuteDuration:   env.Duration("PRESENCE_HEARTBEAT_MUTE_MINUTES", 10*time.Minute, time.Minute),
+		SuppressDuration:        env.Duration("PRESENCE_SUPPRESS_SECONDS", 300*time.Second, time.Second),
+		AuthEnabled:             env.Bool("PRESENCE_AUTH_ENABLED", true),
+		ServiceRole:             env.String("PRESENCE_SERVICE_ROLE", "service"),
+		ServiceAPIKeys:          env.StringSlice("PRESENCE_SERVICE_API_KEYS", nil),
+		JWT:                     auth.ConfigFromEnv(),
+		CORS:                    cors.ConfigFromEnv(),
 	}
+	cfg.err = env.Err()
+
+	return cfg
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// Validate rejects values that could not be parsed and settings out of range
+func (c *Config) Validate() error {
+	if c.err != nil {
+		return c.err
+	}
+	for _, port := range []struct{ name, value string }{{"PORT", c.Port}, {"GRPC_PORT", c.GRPCPort}} {
+		if n, err := strconv.Atoi(port.value); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("%s must be a port number, not %q", port.name, port.value)
+		}
+	}
+	if (c.GRPCTLSCertFile == "") != (c.GRPCTLSKeyFile == "") {
+		return errors.New("GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE must be set together")
+	}
+
+	positive := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"PRESENCE_TTL_SECONDS", c.PresenceTTL},
+		{"PRESENCE_MIN_TTL_SECONDS", c.MinPresenceTTL},
+		{"PRESENCE_JANITOR_INTERVAL_SECONDS", c.JanitorInterval},
+		{"PRESENCE_WATCH_TIMEOUT_SECONDS", c.WatchTimeout},
+		{"PRESENCE_RECENT_RETENTION_HOURS", c.RecentRetention},
+		{"PRESENCE_TYPING_TTL_SECONDS", c.TypingTTL},
+		{"PRESENCE_HEARTBEAT_MUTE_MINUTES", c.HeartbeatMuteDuration},
+	}
+	for _, setting := range positive {
+		if setting.value <= 0 {
+			return fmt.Errorf("%s must be positive, not %s", setting.name, setting.value)
+		}
+	}
+	nonNegative := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"PRESENCE_SNAPSHOT_INTERVAL_MINUTES", c.SnapshotInterval},
+		{"PRESENCE_AWAY_AFTER_MINUTES", c.AwayAfter},
+		{"PRESENCE_HEARTBEAT_MIN_INTERVAL_SECONDS", c.HeartbeatInterval},
+		{"PRESENCE_SUPPRESS_SECONDS", c.SuppressDuration},
+	}
+	for _, setting := range nonNegative {
+		if setting.value < 0 {
+			return fmt.Errorf("%s must not be negative, not %s", setting.name, setting.value)
+		}
+	}
+	if c.MaxPresenceTTL < c.MinPresenceTTL {
+		return errors.New("PRESENCE_MAX_TTL_SECONDS must not be below PRESENCE_MIN_TTL_SECONDS")
+	}
+
+	counts := []struct {
+		name  string
+		value int
+		min   int
+	}{
+		{"REDIS_DB", c.RedisDB, 0},
+		{"PRESENCE_JANITOR_BATCH_SIZE", c.JanitorBatchSize, 1},
+		{"PRESENCE_MAX_ROOMS_PER_USER", c.MaxRoomsPerUser, 0},
+		{"PRESENCE_MAX_ONLINE_USERS", c.MaxOnlineUsers, 1},
+		{"PRESENCE_MAX_BATCH_SIZE", c.MaxBatchSize, 1},
+		{"PRESENCE_HISTORY_LENGTH", c.HistoryLength, 0},
+		{"PRESENCE_SNAPSHOT_KEEP", c.SnapshotKeep, 0},
+		{"PRESENCE_HEARTBEAT_MUTE_THRESHOLD", c.HeartbeatMuteThreshold, 0},
+	}
+	for _, setting := range counts {
+		if setting.value < setting.min {
+			return fmt.Errorf("%s must be at least %d, not %d", setting.name, setting.min, setting.value)
+		}
 	}
-	return defaultValue
-}
\ No newline at end of file
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/auth"
+	"chorus/pkg/logging"
+)
+
+type principalKey struct{}
+
+// principal is the caller identified by a validated token
+type principal struct {
+	userID  string
+	service bool // may act on behalf of other users
+}
+
+// JWTAuth requires a valid bearer token, or one of apiKeys as X-API-Key, and stores
+// the caller in the request context. Tokens whose role claim equals serviceRole, and
+// API keys, are service callers that may name another user_id.
+func JWTAuth(validator *auth.Validator, serviceRole string, apiKeys []string, logger *logging.Logger, next http.Handler) http.Handler {
+	users := auth.Middleware(validator, logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := auth.FromContext(r.Context())
+		caller := principal{
+			userID:  claims.UserID,
+			service: claims.HasRole(serviceRole),
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, caller)))
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if presented := r.Header.Get("X-API-Key"); presented != "" {
+			if !auth.ValidAPIKey(presented, apiKeys) {
+				logger.WarnContext(r.Context(), "Authentication failed", "reason", "invalid_api_key", "path", r.URL.Path, "remote", r.RemoteAddr)
+				apierror.Write(w, r, http.StatusUnauthorized, "Invalid API key", nil)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal{service: true})))
+			return
+		}
+		users.ServeHTTP(w, r)
+	})
+}
+
+// actingUserID returns the user a request acts for. With authentication, a user
+// token acts for its own user_id and rejects any other, while a service token may
+// name any user. Without authentication the requested user_id is taken as is.
+func actingUserID(w http.ResponseWriter, r *http.Request, requested string) (string, bool) {
+	caller, authenticated := r.Context().Value(principalKey{}).(principal)
+	switch {
+	case !authenticated:
+		if requested == "" {
+			apierror.Write(w, r, http.StatusBadRequest, "user_id is required", nil)
+			return "", false
+		}
+		return requested, true
+	case requested == "" && caller.userID == "":
+		// API keys act for no user of their own
+		apierror.Write(w, r, http.StatusBadRequest, "user_id is required", nil)
+		return "", false
+	case requested == "":
+		return caller.userID, true
+	case requested != caller.userID && !caller.service:
+		apierror.Write(w, r, http.StatusForbidden, "user_id does not match the token", nil)
+		return "", false
+	default:
+		return requested, true
+	}
+}
+
+// requireService rejects callers with a user token, leaving administrative queries
+// and batch heartbeats to service tokens and API keys. Without authentication every
+// caller passes.
+func requireService(w http.ResponseWriter, r *http.Request) bool {
+	caller, authenticated := r.Context().Value(principalKey{}).(principal)
+	if authenticated && !caller.service {
+		apierror.Write(w, r, http.StatusForbidden, "This request requires a service token or API key", nil)
+		return false
+	}
+	return true
+}
+
+// actorOf names the caller of an administrative request for the audit list
+func actorOf(r *http.Request) string {
+	caller, authenticated := r.Context().Value(principalKey{}).(principal)
+	switch {
+	case !authenticated:
+		return "unauthenticated"
+	case caller.userID == "":
+		return "api_key"
+	default:
+		return caller.userID
+	}
+}
@@ -1,39 +0,0 @@
-package handlers
-
-import (
-	"log"
-	"net/http"
-	"time"
-)
-
-type responseWriter struct {
-	http.ResponseWriter
-	status int
-}
-
-func (rw *responseWriter) WriteHeader(code int) {
-	rw.status = code
-	rw.ResponseWriter.WriteHeader(code)
-}
-
-func LoggingMiddleware(logger *log.Logger, next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		
-		wrapped := &responseWriter{
-			ResponseWriter: w,
-			status:         http.StatusOK,
-		}
-		
-		next.ServeHTTP(wrapped, r)
-		
-		logger.Printf(
-			"%s %s %s %d %s",
-			r.RemoteAddr,
-			r.Method,
-			r.URL.Path,
-			wrapped.status,
-			time.Since(start),
-		)
-	})
-}
\ No newline at end of file
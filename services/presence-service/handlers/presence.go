@@ -1,51 +1,124 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
 	"chorus/presence-service/models"
 	"chorus/presence-service/services"
 )
 
+// Page size of GET /presence/online when only a cursor is given
+const defaultOnlineUsersPage = 100
+
+// GET /presence/export flushes after this many users
+const exportFlushEvery = 500
+
+// Entries GET /presence/admin/audit returns without a limit
+const defaultAdminAuditPage = 100
+
 type PresenceHandler struct {
-	service *services.PresenceService
-	logger  *log.Logger
+	service        *services.PresenceService
+	logger         *logging.Logger
+	maxOnlineUsers int // most users returned by one GET /presence/online
+	maxBatchSize   int // most heartbeats in one POST /presence/heartbeat/batch, and users in one POST /presence/watch
+	watchTimeout   time.Duration // longest POST /presence/watch waits for a change
 }
 
-func NewPresenceHandler(service *services.PresenceService, logger *log.Logger) *PresenceHandler {
+func NewPresenceHandler(service *services.PresenceService, logger *logging.Logger, maxOnlineUsers, maxBatchSize int, watchTimeout time.Duration) *PresenceHandler {
 	return &PresenceHandler{
-		service: service,
-		logger:  logger,
+		service:        service,
+		logger:         logger,
+		maxOnlineUsers: maxOnlineUsers,
+		maxBatchSize:   maxBatchSize,
+		watchTimeout:   watchTimeout,
 	}
 }
 
 func (ph *PresenceHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
 
 	var req models.HeartbeatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid JSON payload", nil)
 		return
 	}
 
-	if req.UserID == "" {
-		http.Error(w, "user_id is required", http.StatusBadRequest)
+	userID, ok := actingUserID(w, r, req.UserID)
+	if !ok {
 		return
 	}
+	req.UserID = userID
 
 	if req.Status == "" {
 		req.Status = "online"
 	}
+	if err := services.ValidateHeartbeat(req.Status, req.Device, req.DeviceID); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if err := ph.service.ValidateTTL(req.TTLSeconds); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	if remaining := ph.service.CheckSuppressed(r.Context(), req.UserID); remaining > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+		apierror.Write(w, r, http.StatusLocked, "User is suppressed", nil)
+		return
+	}
 
-	err := ph.service.UpdatePresence(r.Context(), req.UserID, req.Status, req.Device)
+	decision, retryAfter := ph.service.CheckHeartbeat(r.Context(), req.UserID, req.Device, req.DeviceID)
+	switch decision {
+	case services.HeartbeatRateLimited:
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		apierror.Write(w, r, http.StatusTooManyRequests, "Too many heartbeats", nil)
+		return
+	case services.HeartbeatMuted:
+		// Looks accepted, so the client has no reason to retry
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": "success",
+			"message": "Presence updated",
+		})
+		return
+	}
+
+	if req.StatusMessage != nil || req.StatusEmoji != nil {
+		message := models.StatusMessage{ExpiresAt: req.ExpiresAt}
+		if req.StatusMessage != nil {
+			message.Message = *req.StatusMessage
+		}
+		if req.StatusEmoji != nil {
+			message.Emoji = *req.StatusEmoji
+		}
+		if err := services.ValidateStatusMessage(message); err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		if err := ph.service.SetStatusMessage(r.Context(), req.UserID, message); err != nil {
+			ph.logger.ErrorContext(r.Context(), "Failed to set status message", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+			return
+		}
+	}
+
+	err := ph.service.UpdatePresence(r.Context(), req.UserID, req.Status, req.Device, req.DeviceID, req.Active == nil || *req.Active, req.TTLSeconds)
 	if err != nil {
-		ph.logger.Printf("Failed to update presence: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		ph.logger.ErrorContext(r.Context(), "Failed to update presence", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
 		return
 	}
 
@@ -57,22 +130,64 @@ func (ph *PresenceHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// HeartbeatBatch handles POST /presence/heartbeat/batch. It asserts presence for any
+// user, so only service callers may use it.
+func (ph *PresenceHandler) HeartbeatBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	if !requireService(w, r) {
+		return
+	}
+
+	var req models.BatchHeartbeatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid JSON payload", nil)
+		return
+	}
+
+	if len(req.Heartbeats) == 0 {
+		apierror.Write(w, r, http.StatusBadRequest, "heartbeats is required", nil)
+		return
+	}
+	if len(req.Heartbeats) > ph.maxBatchSize {
+		apierror.Write(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d heartbeats per batch", ph.maxBatchSize), nil)
+		return
+	}
+
+	failed := ph.service.UpdatePresenceBatch(r.Context(), req.Heartbeats)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.BatchHeartbeatResponse{
+		Accepted: len(req.Heartbeats) - len(failed),
+		Failed:   failed,
+	})
+}
+
 func (ph *PresenceHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
 
 	userID := r.URL.Query().Get("user_id")
 	if userID == "" {
-		http.Error(w, "user_id parameter is required", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, "user_id parameter is required", nil)
 		return
 	}
 
-	presence, err := ph.service.GetPresence(r.Context(), userID)
+	raw, ok := rawRequested(w, r)
+	if !ok {
+		return
+	}
+
+	presence, err := ph.service.GetPresence(r.Context(), userID, raw)
 	if err != nil {
-		ph.logger.Printf("Failed to get presence: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		ph.logger.ErrorContext(r.Context(), "Failed to get presence", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
 		return
 	}
 
@@ -83,6 +198,137 @@ func (ph *PresenceHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
 		Status:   presence.Status,
 		LastSeen: presence.LastSeen,
 		IsOnline: isOnline,
+		Devices:  presence.Devices,
+		
+		StatusMessage:   presence.StatusMessage,
+		StatusEmoji:     presence.StatusEmoji,
+		StatusExpiresAt: presence.StatusExpiresAt,
+		
+		Override: presence.Override,
+	}
+	if response.Devices == nil {
+		response.Devices = []models.DevicePresence{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// SetStatusMessage handles PUT /presence/status-message
+func (ph *PresenceHandler) SetStatusMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	var req models.StatusMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid JSON payload", nil)
+		return
+	}
+
+	userID, ok := actingUserID(w, r, req.UserID)
+	if !ok {
+		return
+	}
+	req.UserID = userID
+
+	if err := services.ValidateStatusMessage(req.StatusMessage); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	if err := ph.service.SetStatusMessage(r.Context(), req.UserID, req.StatusMessage); err != nil {
+		ph.logger.ErrorContext(r.Context(), "Failed to set status message", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "success",
+		"message": "Status message updated",
+	})
+}
+
+// SetDND handles PUT /presence/dnd
+func (ph *PresenceHandler) SetDND(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	var req models.DNDRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid JSON payload", nil)
+		return
+	}
+
+	userID, ok := actingUserID(w, r, req.UserID)
+	if !ok {
+		return
+	}
+	req.UserID = userID
+
+	if err := services.ValidateDND(&req.DNDSchedule); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	active, err := ph.service.SetDND(r.Context(), req.UserID, req.DNDSchedule)
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "Failed to set do-not-disturb", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.DNDResponse{
+		UserID:      req.UserID,
+		DNDSchedule: req.DNDSchedule,
+		Active:      active,
+	})
+}
+
+// SetStatusOverride handles PUT /presence/override, which only services may call
+func (ph *PresenceHandler) SetStatusOverride(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	if !requireService(w, r) {
+		return
+	}
+
+	var req models.StatusOverrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid JSON payload", nil)
+		return
+	}
+	if req.UserID == "" {
+		apierror.Write(w, r, http.StatusBadRequest, "user_id is required", nil)
+		return
+	}
+
+	if err := services.ValidateStatusOverride(req); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	var response *models.StatusOverrideResponse
+	var err error
+	if req.Status == "" {
+		response, err = ph.service.ClearStatusOverride(r.Context(), req.UserID, req.Source)
+	} else {
+		response, err = ph.service.SetStatusOverride(r.Context(), req.UserID, req)
+	}
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "Failed to update status override", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -90,22 +336,585 @@ func (ph *PresenceHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// rawRequested parses ?raw=true, which asks for the status reported by sessions
+// rather than dnd, and is limited to service callers
+func rawRequested(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	value := r.URL.Query().Get("raw")
+	if value == "" {
+		return false, true
+	}
+	raw, err := strconv.ParseBool(value)
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "raw must be true or false", nil)
+		return false, false
+	}
+	if raw && !requireService(w, r) {
+		return false, false
+	}
+	return raw, true
+}
+
+// GetOnlineUsers handles GET /presence/online. With limit or cursor it returns a page
+// and next_cursor; without them it returns everyone, unless there are more than
+// the configured maximum, in which case it returns the first page and marks the
+// response truncated.
 func (ph *PresenceHandler) GetOnlineUsers(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
 		return
 	}
 
-	users, err := ph.service.GetOnlineUsers(r.Context())
+	query := r.URL.Query()
+	status := query.Get("status")
+	paginated := query.Has("limit") || query.Has("cursor")
+
+	raw, ok := rawRequested(w, r)
+	if !ok {
+		return
+	}
+
+	limit := defaultOnlineUsersPage
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > ph.maxOnlineUsers {
+			apierror.Write(w, r, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", ph.maxOnlineUsers), nil)
+			return
+		}
+		limit = n
+	}
+
+	var cursor uint64
+	if value := query.Get("cursor"); value != "" {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, "Invalid cursor", nil)
+			return
+		}
+		cursor = n
+	}
+
+	var response models.OnlineUsersResponse
+	if !paginated {
+		total, err := ph.service.CountOnlineUsers(r.Context())
+		if err != nil {
+			ph.logger.ErrorContext(r.Context(), "Failed to count online users", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+			return
+		}
+		if total > int64(ph.maxOnlineUsers) {
+			// Too many to return at once; hand out the first page instead
+			paginated = true
+			limit = ph.maxOnlineUsers
+			response.Truncated = true
+			response.Total = total
+			response.Message = "Too many online users to return at once; request further pages with ?limit and ?cursor=next_cursor, or use /presence/online/count for the number"
+		}
+	}
+
+	if paginated {
+		users, next, err := ph.service.ListOnlineUsers(r.Context(), cursor, limit, status, raw)
+		if err != nil {
+			ph.logger.ErrorContext(r.Context(), "Failed to list online users", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+			return
+		}
+		response.Users = users
+		response.NextCursor = strconv.FormatUint(next, 10)
+	} else {
+		users, err := ph.service.GetOnlineUsers(r.Context(), raw)
+		if err != nil {
+			ph.logger.ErrorContext(r.Context(), "Failed to get online users", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+			return
+		}
+		if status != "" {
+			filtered := make([]models.UserPresence, 0, len(users))
+			for _, user := range users {
+				if user.Status == status {
+					filtered = append(filtered, user)
+				}
+			}
+			users = filtered
+		}
+		response.Users = users
+	}
+	response.Count = len(response.Users)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// CountOnlineUsers handles GET /presence/online/count
+func (ph *PresenceHandler) CountOnlineUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	count, err := ph.service.CountOnlineUsers(r.Context())
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "Failed to count online users", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.OnlineCountResponse{Count: count})
+}
+
+// GetRecentUsers handles GET /presence/recent?since=15m&limit=100&offset=0
+func (ph *PresenceHandler) GetRecentUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	retention := ph.service.RecentRetention()
+
+	window := 15 * time.Minute
+	if value := query.Get("since"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 || d > retention {
+			apierror.Write(w, r, http.StatusBadRequest, fmt.Sprintf("since must be a duration such as 15m, at most %s", retention), nil)
+			return
+		}
+		window = d
+	}
+
+	limit := defaultOnlineUsersPage
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > ph.maxOnlineUsers {
+			apierror.Write(w, r, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", ph.maxOnlineUsers), nil)
+			return
+		}
+		limit = n
+	}
+
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			apierror.Write(w, r, http.StatusBadRequest, "offset must be a non-negative integer", nil)
+			return
+		}
+		offset = n
+	}
+
+	since := time.Now().Add(-window)
+	users, total, err := ph.service.GetRecentUsers(r.Context(), since, offset, limit)
 	if err != nil {
-		ph.logger.Printf("Failed to get online users: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		ph.logger.ErrorContext(r.Context(), "Failed to get recent users", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
 		return
 	}
 
-	response := models.OnlineUsersResponse{
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.RecentUsersResponse{
+		Since: since,
 		Count: len(users),
+		Total: total,
 		Users: users,
+	})
+}
+
+// GetHistory handles GET /presence/history. Users may read their own history; other
+// users' history needs a service token.
+func (ph *PresenceHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	userID, ok := actingUserID(w, r, query.Get("user_id"))
+	if !ok {
+		return
+	}
+
+	length := ph.service.HistoryLength()
+	limit := length
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > length {
+			apierror.Write(w, r, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", length), nil)
+			return
+		}
+		limit = n
+	}
+
+	presence, err := ph.service.GetPresence(r.Context(), userID, false)
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "Failed to get presence", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+		return
+	}
+
+	transitions := []models.PresenceTransition{}
+	if limit > 0 {
+		transitions, err = ph.service.GetHistory(r.Context(), userID, limit)
+		if err != nil {
+			ph.logger.ErrorContext(r.Context(), "Failed to get presence history", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.PresenceHistoryResponse{
+		UserID:      userID,
+		Status:      presence.Status,
+		LastSeen:    presence.LastSeen,
+		Transitions: transitions,
+	})
+}
+
+// ForceOffline handles DELETE /presence/users/{user_id}, which takes the user offline
+// at once and, unless suppress=false, refuses their heartbeats for a while. Only
+// service callers may use it.
+func (ph *PresenceHandler) ForceOffline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	if !requireService(w, r) {
+		return
+	}
+
+	suppress := true
+	if value := r.URL.Query().Get("suppress"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, "suppress must be true or false", nil)
+			return
+		}
+		suppress = parsed
+	}
+
+	entry, err := ph.service.ForceOffline(r.Context(), r.PathValue("user_id"), actorOf(r), suppress)
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "Failed to force user offline", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// GetAdminAudit handles GET /presence/admin/audit, the latest administrative
+// actions, newest first. Only service callers may use it.
+func (ph *PresenceHandler) GetAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	if !requireService(w, r) {
+		return
+	}
+
+	limit := defaultAdminAuditPage
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > services.MaxAdminAuditEntries {
+			apierror.Write(w, r, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", services.MaxAdminAuditEntries), nil)
+			return
+		}
+		limit = n
+	}
+
+	entries, err := ph.service.AdminAudit(r.Context(), limit)
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "Failed to get admin audit", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.AdminAuditResponse{Entries: entries})
+}
+
+// Watch handles POST /presence/watch, a long poll for status transitions of a set of
+// users. Without since it answers right away with their current presence and a
+// token; with since it waits up to the watch timeout for transitions after it and
+// answers with them and the token to send next, or with none and the same token.
+func (ph *PresenceHandler) Watch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	if !requireService(w, r) {
+		return
+	}
+
+	var req models.WatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid JSON payload", nil)
+		return
+	}
+
+	if len(req.UserIDs) == 0 {
+		apierror.Write(w, r, http.StatusBadRequest, "user_ids is required", nil)
+		return
+	}
+	if len(req.UserIDs) > ph.maxBatchSize {
+		apierror.Write(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d user_ids per watch", ph.maxBatchSize), nil)
+		return
+	}
+
+	response := models.WatchResponse{Changes: []models.PresenceEvent{}}
+	if req.Since == "" {
+		// Transitions from now on are not reflected in what is read next
+		now := time.Now()
+		users, err := ph.service.BulkGetPresence(r.Context(), req.UserIDs, false)
+		if err != nil {
+			ph.logger.ErrorContext(r.Context(), "Failed to get presence", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+			return
+		}
+		response.Users = users
+		response.Token = watchToken(now)
+	} else {
+		nanos, err := strconv.ParseInt(req.Since, 10, 64)
+		if err != nil || nanos <= 0 {
+			apierror.Write(w, r, http.StatusBadRequest, "since must be a token returned by a previous watch", nil)
+			return
+		}
+
+		changes, next, err := ph.service.PollPresence(r.Context(), req.UserIDs, time.Unix(0, nanos), ph.watchTimeout)
+		if err != nil {
+			if r.Context().Err() != nil {
+				// The client went away
+				return
+			}
+			ph.logger.ErrorContext(r.Context(), "Failed to watch presence", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+			return
+		}
+		response.Changes = changes
+		response.Token = watchToken(next)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// watchToken encodes the time a watch continues from
+func watchToken(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// Export handles GET /presence/export, streaming the presence of every online user
+// as NDJSON. With ?since= (a duration such as 1h, or an RFC 3339 time) it streams
+// every user seen since then instead, including those who went offline.
+func (ph *PresenceHandler) Export(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	if !requireService(w, r) {
+		return
+	}
+
+	var since time.Time
+	if value := r.URL.Query().Get("since"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, value); err == nil {
+			since = t
+		} else {
+			apierror.Write(w, r, http.StatusBadRequest, "since must be a duration such as 1h or an RFC 3339 time", nil)
+			return
+		}
+	}
+
+	// An export may take longer than the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	written := 0
+	exported, err := ph.service.ExportPresence(r.Context(), since, func(record models.PresenceExportRecord) error {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+		if written++; written%exportFlushEvery == 0 {
+			rc.Flush()
+		}
+		return nil
+	})
+	rc.Flush()
+	if err != nil {
+		// The status is already sent, so the client only sees a truncated stream
+		ph.logger.ErrorContext(r.Context(), "Presence export failed", "exported", exported, "error", err)
+		return
+	}
+	ph.logger.InfoContext(r.Context(), "Exported presence", "exported", exported)
+}
+
+// Typing handles POST /presence/typing, which sets or clears a typing indicator, and
+// GET /presence/typing?conversation_id=..., which lists who is typing
+func (ph *PresenceHandler) Typing(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		ph.setTyping(w, r)
+	case http.MethodGet:
+		ph.getTyping(w, r)
+	default:
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+func (ph *PresenceHandler) setTyping(w http.ResponseWriter, r *http.Request) {
+	var req models.TypingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid JSON payload", nil)
+		return
+	}
+
+	userID, ok := actingUserID(w, r, req.UserID)
+	if !ok {
+		return
+	}
+
+	if req.ConversationID == "" {
+		apierror.Write(w, r, http.StatusBadRequest, "conversation_id is required", nil)
+		return
+	}
+
+	event, err := ph.service.SetTyping(r.Context(), userID, req.ConversationID, req.Stopped)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidConversationID) {
+			apierror.Write(w, r, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		ph.logger.ErrorContext(r.Context(), "Failed to set typing indicator", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(event)
+}
+
+func (ph *PresenceHandler) getTyping(w http.ResponseWriter, r *http.Request) {
+	conversationID := r.URL.Query().Get("conversation_id")
+	if conversationID == "" {
+		apierror.Write(w, r, http.StatusBadRequest, "conversation_id parameter is required", nil)
+		return
+	}
+
+	users, err := ph.service.GetTypingUsers(r.Context(), conversationID)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidConversationID) {
+			apierror.Write(w, r, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		ph.logger.ErrorContext(r.Context(), "Failed to get typing users", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.TypingResponse{
+		ConversationID: conversationID,
+		Users:          users,
+	})
+}
+
+// JoinRoom handles POST /presence/rooms/{room_id}/join
+func (ph *PresenceHandler) JoinRoom(w http.ResponseWriter, r *http.Request) {
+	ph.changeRoom(w, r, ph.service.JoinRoom)
+}
+
+// LeaveRoom handles POST /presence/rooms/{room_id}/leave
+func (ph *PresenceHandler) LeaveRoom(w http.ResponseWriter, r *http.Request) {
+	ph.changeRoom(w, r, ph.service.LeaveRoom)
+}
+
+func (ph *PresenceHandler) changeRoom(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, userID, roomID string) (int64, error)) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	var req models.RoomRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, "Invalid JSON payload", nil)
+			return
+		}
+	}
+
+	userID, ok := actingUserID(w, r, req.UserID)
+	if !ok {
+		return
+	}
+	req.UserID = userID
+
+	roomID := r.PathValue("room_id")
+	count, err := change(r.Context(), req.UserID, roomID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidRoomID):
+			apierror.Write(w, r, http.StatusBadRequest, err.Error(), nil)
+		case errors.Is(err, services.ErrTooManyRooms):
+			apierror.Write(w, r, http.StatusConflict, err.Error(), nil)
+		default:
+			ph.logger.ErrorContext(r.Context(), "Failed to update room membership", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.RoomResponse{
+		RoomID: roomID,
+		UserID: req.UserID,
+		Count:  count,
+	})
+}
+
+// GetRoomOnlineUsers handles GET /presence/rooms/{room_id}/online
+func (ph *PresenceHandler) GetRoomOnlineUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	roomID := r.PathValue("room_id")
+	users, err := ph.service.GetRoomOnlineUsers(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRoomID) {
+			apierror.Write(w, r, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		ph.logger.ErrorContext(r.Context(), "Failed to get room online users", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Internal server error", nil)
+		return
+	}
+
+	response := models.RoomOnlineResponse{
+		RoomID: roomID,
+		Count:  len(users),
+		Users:  users,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
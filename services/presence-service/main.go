@@ -1,74 +1,89 @@
 package main
 
 import (
-	"context"
-	"log"
-	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
-	"time"
+	"net"
+	_ "time/tzdata" // do-not-disturb time zones, the runtime image has no zoneinfo
 
+	"chorus/pkg/env"
+	"chorus/pkg/httpserver"
+	"chorus/pkg/logging"
+	"chorus/pkg/tracing"
+	"chorus/presence-service/app"
 	"chorus/presence-service/config"
-	"chorus/presence-service/handlers"
-	"chorus/presence-service/services"
 )
 
 func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
 	
-	// Setup logger
-	logger := log.New(os.Stdout, "[Presence-Service] ", log.LstdFlags|log.Lshortfile)
+	// Setup logger, at the level named by LOG_LEVEL
+	logger := logging.NewLogger().With("service", "presence-service")
 	
-	// Initialize Redis client
-	redisClient := services.NewRedisClient(cfg)
-	defer redisClient.Close()
-	
-	// Initialize presence service
-	presenceService := services.NewPresenceService(redisClient, logger)
-	
-	// Create handlers
-	presenceHandler := handlers.NewPresenceHandler(presenceService, logger)
+	if err := cfg.Validate(); err != nil {
+		logger.Fatal("Invalid configuration", "error", err)
+	}
+	shutdownTracing, err := tracing.Setup("presence-service")
+	if err != nil {
+		logger.Fatal("Failed to set up tracing", "error", err)
+	}
+	logger.Info("Configuration loaded", "settings", env.Dump())
+	application, err := app.New(cfg, logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize", "error", err)
+	}
+	defer application.Close()
 	
-	// Setup routes
-	mux := http.NewServeMux()
-	mux.HandleFunc("/health", handlers.HealthCheck)
-	mux.HandleFunc("/presence/heartbeat", presenceHandler.Heartbeat)
-	mux.HandleFunc("/presence/status", presenceHandler.GetStatus)
-	mux.HandleFunc("/presence/online", presenceHandler.GetOnlineUsers)
+	// Background work runs until shutdown, before Redis is closed
+	application.Start()
 	
 	// Create HTTP server
-	srv := &http.Server{
+	srv := httpserver.New(httpserver.Options{
 		Addr:         ":" + cfg.Port,
-		Handler:      handlers.LoggingMiddleware(logger, mux),
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
-	}
+		WriteTimeout: app.WriteTimeout,
+	}, logger, application.Handler)
+	httpserver.Serve(srv, logger, "Starting Presence Service", "port", cfg.Port)
 	
-	// Start server in goroutine
+	grpcListener, err := net.Listen("tcp", ":"+cfg.GRPCPort)
+	if err != nil {
+		logger.Fatal("Failed to listen for gRPC", "error", err)
+	}
 	go func() {
-		logger.Printf("Starting Presence Service on port %s", cfg.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatalf("Failed to start server: %v", err)
+		logger.Info("Starting Presence gRPC server", "port", cfg.GRPCPort)
+		if err := application.GRPC.Serve(grpcListener); err != nil {
+			logger.Fatal("Failed to start gRPC server", "error", err)
 		}
 	}()
 	
 	// Wait for interrupt signal to gracefully shutdown the server
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	httpserver.WaitForSignal()
 	
-	logger.Println("Shutting down server...")
+	logger.Info("Shutting down server...")
+	application.Stop()
 	
 	// Graceful shutdown with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := httpserver.ShutdownContext()
 	defer cancel()
 	
+	// Watch streams only end when their callers leave, so stop them at the deadline
+	grpcStopped := make(chan struct{})
+	go func() {
+		application.GRPC.GracefulStop()
+		close(grpcStopped)
+	}()
+	
 	if err := srv.Shutdown(ctx); err != nil {
-		logger.Fatalf("Server forced to shutdown: %v", err)
+		logger.Fatal("Server forced to shutdown", "error", err)
+	}
+	
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		application.GRPC.Stop()
+	}
+	
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("Failed to flush spans", "error", err)
 	}
 	
-	logger.Println("Server exited")
+	logger.Info("Server exited")
 }
\ No newline at end of file
@@ -2,27 +2,351 @@ package models
 
 import "time"
 
+// UserPresence is the user-level presence merged from their sessions
 type UserPresence struct {
 	UserID    string    `json:"user_id"`
-	Status    string    `json:"status"` // online, away, busy, offline
+	Status    string    `json:"status"` // online, away, busy, offline, dnd while do-not-disturb is active, or the override's
 	LastSeen  time.Time `json:"last_seen"`
-	Device    string    `json:"device,omitempty"`
+	Device    string    `json:"device,omitempty"` // device of the session the status comes from
+	Devices   []DevicePresence `json:"devices,omitempty"`
+	
+	StatusMessage   string     `json:"status_message,omitempty"`
+	StatusEmoji     string     `json:"status_emoji,omitempty"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty"` // the message clears after this, the status stays
+	
+	Override *StatusOverride `json:"override,omitempty"` // set while a service overrides the status
+}
+
+// StatusMessage is a custom message shown next to a user's status. It is stored
+// apart from the presence so that it outlives heartbeat expiry.
+type StatusMessage struct {
+	Message   string     `json:"status_message,omitempty"`
+	Emoji     string     `json:"status_emoji,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
+// StatusOverride is a status a service set for a user, such as busy while a workflow
+// has them on a task, as opposed to the one their clients report with heartbeats.
+// While it lasts a present user's status reads Status; do-not-disturb still wins.
+type StatusOverride struct {
+	Status        string     `json:"status"`
+	StatusMessage string     `json:"status_message,omitempty"` // why, such as the task; the user's own message stays
+	Source        string     `json:"source,omitempty"`         // who set it, such as a workflow instance
+	SetAt         time.Time  `json:"set_at"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+// DNDSchedule is a user's do-not-disturb setting. Within the daily window from Start
+// to End in Timezone, on Days if given, and until EnabledUntil, a present user's
+// status reads dnd whatever their sessions report.
+type DNDSchedule struct {
+	Start        string     `json:"start,omitempty"`    // HH:MM; a window ending before it crosses midnight
+	End          string     `json:"end,omitempty"`      // HH:MM
+	Timezone     string     `json:"timezone,omitempty"` // IANA name, defaults to UTC
+	Days         []string   `json:"days,omitempty"`     // mon..sun the window starts on; empty means every day
+	EnabledUntil *time.Time `json:"enabled_until,omitempty"` // manual do-not-disturb, on top of the schedule
+}
+
+// Limits on status messages, in characters
+const (
+	MaxStatusMessageLength = 100
+	MaxStatusEmojiLength   = 16
+)
+
+// DevicePresence is one session of a user, which expires on its own
+type DevicePresence struct {
+	DeviceID   string    `json:"device_id"`
+	Device     string    `json:"device,omitempty"`
+	Status     string    `json:"status"` // as reported; the user-level status may be away after inactivity
+	LastSeen   time.Time `json:"last_seen"`
+	LastActive time.Time `json:"last_active,omitempty"` // last interactive heartbeat
+	TTLSeconds int       `json:"ttl_seconds,omitempty"` // how long the session lives after last_seen
+}
+
+// HeartbeatRequest, StatusMessageRequest and RoomRequest take user_id from the
+// token when authentication is enabled; naming another user needs a service token
 type HeartbeatRequest struct {
+	UserID   string `json:"user_id"`
+	Status   string `json:"status"`
+	Device   string `json:"device,omitempty"`
+	DeviceID string `json:"device_id,omitempty"` // identifies the session, defaults to device
+	
+	// Whether the user interacted with the client since the last heartbeat; omitted
+	// means true. Background heartbeats send false so the session can turn away.
+	Active *bool `json:"active,omitempty"`
+	
+	// How long the session stays online without heartbeats, within the server's
+	// bounds; omitted means PRESENCE_TTL_SECONDS
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+	
+	// Optional; when status_message or status_emoji is present the status message is
+	// replaced, as with PUT /presence/status-message
+	StatusMessage *string    `json:"status_message,omitempty"`
+	StatusEmoji   *string    `json:"status_emoji,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+// BatchHeartbeatRequest carries heartbeats a service reports on behalf of users
+type BatchHeartbeatRequest struct {
+	Heartbeats []BatchHeartbeat `json:"heartbeats"`
+}
+
+// BatchHeartbeat is one heartbeat of a batch, as HeartbeatRequest without the status
+// message fields
+type BatchHeartbeat struct {
+	UserID   string `json:"user_id"`
+	Status   string `json:"status"`
+	Device   string `json:"device,omitempty"`
+	DeviceID string `json:"device_id,omitempty"`
+	Active     *bool  `json:"active,omitempty"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty"`
+}
+
+// BatchHeartbeatResponse lists only the heartbeats that were not applied
+type BatchHeartbeatResponse struct {
+	Accepted int                     `json:"accepted"`
+	Failed   []BatchHeartbeatFailure `json:"failed,omitempty"`
+}
+
+type BatchHeartbeatFailure struct {
+	Index  int    `json:"index"` // position in the request's heartbeats
+	UserID string `json:"user_id,omitempty"`
+	Error  string `json:"error"`
+}
+
+// StatusMessageRequest sets a user's status message; an empty message and emoji
+// clear it
+type StatusMessageRequest struct {
 	UserID string `json:"user_id"`
-	Status string `json:"status"`
-	Device string `json:"device,omitempty"`
+	StatusMessage
+}
+
+// DNDRequest replaces a user's do-not-disturb setting; one with neither a schedule
+// nor enabled_until clears it
+type DNDRequest struct {
+	UserID string `json:"user_id"`
+	DNDSchedule
+}
+
+// StatusOverrideRequest sets a user's status override, lapsing after ttl_seconds if
+// given; one without a status clears it, only if it came from source when that is
+// set. Only services may send it.
+type StatusOverrideRequest struct {
+	UserID        string `json:"user_id"`
+	Status        string `json:"status,omitempty"`
+	StatusMessage string `json:"status_message,omitempty"`
+	Source        string `json:"source,omitempty"`
+	TTLSeconds    int    `json:"ttl_seconds,omitempty"`
+}
+
+// StatusOverrideResponse answers a StatusOverrideRequest with the user's effective
+// status and the override in place, if any
+type StatusOverrideResponse struct {
+	UserID   string          `json:"user_id"`
+	Status   string          `json:"status"`
+	Override *StatusOverride `json:"override"`
+	Cleared  bool            `json:"cleared,omitempty"` // an override was removed
+}
+
+type DNDResponse struct {
+	UserID string `json:"user_id"`
+	DNDSchedule
+	Active bool `json:"active"`
 }
 
 type StatusResponse struct {
-	UserID   string    `json:"user_id"`
-	Status   string    `json:"status"`
-	LastSeen time.Time `json:"last_seen"`
-	IsOnline bool      `json:"is_online"`
+	UserID   string           `json:"user_id"`
+	Status   string           `json:"status"`
+	LastSeen time.Time        `json:"last_seen"`
+	IsOnline bool             `json:"is_online"`
+	Devices  []DevicePresence `json:"devices"`
+	
+	StatusMessage   string     `json:"status_message,omitempty"`
+	StatusEmoji     string     `json:"status_emoji,omitempty"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty"`
+	
+	Override *StatusOverride `json:"override,omitempty"`
 }
 
 type OnlineUsersResponse struct {
 	Count int            `json:"count"`
 	Users []UserPresence `json:"users"`
+	
+	// Set when paginating; "0" once the last page was returned
+	NextCursor string `json:"next_cursor,omitempty"`
+	
+	// Set when there were too many users to return without pagination
+	Truncated bool   `json:"truncated,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+type OnlineCountResponse struct {
+	Count int64 `json:"count"`
+}
+
+// RecentUsersResponse lists users by last_seen, most recent first
+type RecentUsersResponse struct {
+	Since time.Time      `json:"since"`
+	Count int            `json:"count"` // users in this page
+	Total int64          `json:"total"` // users seen since Since
+	Users []UserPresence `json:"users"`
+}
+
+type RoomRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// RoomResponse answers a join or leave with the room's occupancy
+type RoomResponse struct {
+	RoomID string `json:"room_id"`
+	UserID string `json:"user_id"`
+	Count  int64  `json:"count"`
+}
+
+type RoomOnlineResponse struct {
+	RoomID string         `json:"room_id"`
+	Count  int            `json:"count"`
+	Users  []UserPresence `json:"users"`
+}
+
+// Reasons carried by a PresenceEvent
+const (
+	PresenceChangeUpdate  = "update"  // a heartbeat set a different status
+	PresenceChangeRemoved = "removed" // the presence was deleted
+	PresenceChangeExpired = "expired" // heartbeats stopped and the presence timed out
+	PresenceChangeMessage = "status_message" // the status message of a present user changed
+	PresenceChangeDND     = "dnd"            // a do-not-disturb change altered the status of a present user
+	PresenceChangeOverride = "override"      // a service set or cleared a status override of a present user
+	PresenceChangeAdministrative = "administrative" // the user was forced offline
+	
+	// Reasons of a TypingEvent
+	PresenceChangeTyping        = "typing"         // a typing indicator was set or refreshed
+	PresenceChangeTypingStopped = "typing_stopped" // a typing indicator was cleared
+)
+
+// PresenceEvent is published on eventbus.PresenceEvents when a user's effective
+// status or, while they are present, status message changes.
+// Heartbeats that repeat the current status do not produce events.
+type PresenceEvent struct {
+	UserID    string    `json:"user_id"`
+	OldStatus string    `json:"old_status"` // empty if the user's last status is unknown
+	NewStatus string    `json:"new_status"`
+	Device    string    `json:"device,omitempty"`
+	Reason    string    `json:"reason"`
+	Timestamp time.Time `json:"timestamp"`
+	
+	StatusMessage string `json:"status_message,omitempty"`
+	StatusEmoji   string `json:"status_emoji,omitempty"`
+}
+
+// WatchRequest is the body of POST /presence/watch. An empty since asks for the
+// users' current presence and a token to watch from.
+type WatchRequest struct {
+	UserIDs []string `json:"user_ids"`
+	Since   string   `json:"since,omitempty"`
+}
+
+type WatchResponse struct {
+	Token   string          `json:"token"` // since for the next request
+	Changes []PresenceEvent `json:"changes"`
+	Users   []UserPresence  `json:"users,omitempty"` // only without since
+}
+
+// PresenceTransition is one entry of a user's presence history
+type PresenceTransition struct {
+	OldStatus string    `json:"old_status"`
+	NewStatus string    `json:"new_status"`
+	Reason    string    `json:"reason"`
+	Device    string    `json:"device,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// PresenceHistoryResponse pairs a user's current presence with their latest
+// transitions, newest first
+type PresenceHistoryResponse struct {
+	UserID      string               `json:"user_id"`
+	Status      string               `json:"status"`
+	LastSeen    time.Time            `json:"last_seen"`
+	Transitions []PresenceTransition `json:"transitions"`
+}
+
+// ReadinessCheck is the outcome of one check of GET /health/ready
+type ReadinessCheck struct {
+	Status      string     `json:"status"` // ok, degraded or down; only down fails readiness
+	Error       string     `json:"error,omitempty"`
+	LatencyMs   float64    `json:"latency_ms,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+// RedisPoolStats mirrors the Redis client's connection pool counters
+type RedisPoolStats struct {
+	Hits       uint32 `json:"hits"`
+	Misses     uint32 `json:"misses"`
+	Timeouts   uint32 `json:"timeouts"`
+	TotalConns uint32 `json:"total_conns"`
+	IdleConns  uint32 `json:"idle_conns"`
+	StaleConns uint32 `json:"stale_conns"`
+}
+
+type ReadinessResponse struct {
+	Status    string                    `json:"status"` // ready or not_ready
+	Service   string                    `json:"service"`
+	Timestamp time.Time                 `json:"timestamp"`
+	Checks    map[string]ReadinessCheck `json:"checks"`
+	RedisPool RedisPoolStats            `json:"redis_pool"`
+}
+
+// Actions recorded in the admin audit list
+const (
+	AdminActionForceOffline = "force_offline"
+)
+
+// AdminAuditEntry records an administrative action, as answered by
+// DELETE /presence/users/{user_id} and listed by GET /presence/admin/audit
+type AdminAuditEntry struct {
+	Action          string     `json:"action"`
+	UserID          string     `json:"user_id"`
+	Actor           string     `json:"actor"` // user_id of the caller's token, or api_key
+	PreviousStatus  string     `json:"previous_status"`
+	SuppressedUntil *time.Time `json:"suppressed_until,omitempty"` // heartbeats are refused until then
+	Timestamp       time.Time  `json:"timestamp"`
+}
+
+type AdminAuditResponse struct {
+	Entries []AdminAuditEntry `json:"entries"`
+}
+
+// PresenceExportRecord is one line of GET /presence/export and of presence snapshots
+type PresenceExportRecord struct {
+	UserID   string    `json:"user_id"`
+	Status   string    `json:"status"`
+	LastSeen time.Time `json:"last_seen"`
+	Device   string    `json:"device,omitempty"`
+}
+
+// TypingEvent is published on eventbus.PresenceEvents next to PresenceEvent;
+// subscribers tell them apart by reason
+type TypingEvent struct {
+	UserID         string     `json:"user_id"`
+	ConversationID string     `json:"conversation_id"`
+	Reason         string     `json:"reason"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"` // when the indicator lapses unless refreshed
+	Timestamp      time.Time  `json:"timestamp"`
+}
+
+type TypingRequest struct {
+	UserID         string `json:"user_id"`
+	ConversationID string `json:"conversation_id"`
+	Stopped        bool   `json:"stopped"`
+}
+
+type TypingUser struct {
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type TypingResponse struct {
+	ConversationID string       `json:"conversation_id"`
+	Users          []TypingUser `json:"users"`
 }
\ No newline at end of file
@@ -0,0 +1,78 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"chorus/pkg/auth"
+	"chorus/pkg/logging"
+	"chorus/pkg/presence"
+)
+
+// Authenticator admits service callers only, since every RPC may name any user:
+// either one of the configured API keys or a token whose role claim is the service
+// role
+type Authenticator struct {
+	validator   *auth.Validator
+	serviceRole string
+	apiKeys     []string
+	logger      *logging.Logger
+}
+
+func NewAuthenticator(validator *auth.Validator, serviceRole string, apiKeys []string, logger *logging.Logger) *Authenticator {
+	return &Authenticator{
+		validator:   validator,
+		serviceRole: serviceRole,
+		apiKeys:     apiKeys,
+		logger:      logger,
+	}
+}
+
+func (a *Authenticator) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := a.authenticate(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (a *Authenticator) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := a.authenticate(stream.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+func (a *Authenticator) authenticate(ctx context.Context, method string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if keys := md.Get(presence.APIKeyMetadata); len(keys) > 0 {
+		if auth.ValidAPIKey(keys[0], a.apiKeys) {
+			return nil
+		}
+		a.logger.WarnContext(ctx, "gRPC authentication failed", "reason", "invalid_api_key", "method", method)
+		return status.Error(codes.Unauthenticated, "invalid API key")
+	}
+
+	values := md.Get(presence.AuthorizationMetadata)
+	if len(values) == 0 || !strings.HasPrefix(values[0], "Bearer ") {
+		return status.Error(codes.Unauthenticated, "missing credentials")
+	}
+	claims, err := a.validator.Validate(strings.TrimPrefix(values[0], "Bearer "))
+	if err != nil {
+		a.logger.WarnContext(ctx, "gRPC authentication failed", "reason", auth.Reason(err), "method", method)
+		return status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if !claims.HasRole(a.serviceRole) {
+		return status.Error(codes.PermissionDenied, "a service token or API key is required")
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// Package rpc serves the presence service over gRPC, as defined in
+// chorus/pkg/presence/presencepb, next to the HTTP API.
+package rpc
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"chorus/pkg/logging"
+	"chorus/pkg/presence/presencepb"
+	"chorus/presence-service/models"
+	"chorus/presence-service/services"
+)
+
+type Server struct {
+	presencepb.UnimplementedPresenceServiceServer
+	service *services.PresenceService
+	logger  *logging.Logger
+	maxBulk int // most users in one BulkGetPresence or WatchPresence
+}
+
+func NewServer(service *services.PresenceService, logger *logging.Logger, maxBulk int) *Server {
+	return &Server{
+		service: service,
+		logger:  logger,
+		maxBulk: maxBulk,
+	}
+}
+
+func (s *Server) UpdatePresence(ctx context.Context, req *presencepb.UpdatePresenceRequest) (*presencepb.UpdatePresenceResponse, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	presenceStatus := req.GetStatus()
+	if presenceStatus == "" {
+		presenceStatus = "online"
+	}
+	if err := services.ValidateHeartbeat(presenceStatus, req.GetDevice(), req.GetDeviceId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := s.service.ValidateTTL(int(req.GetTtlSeconds())); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if s.service.CheckSuppressed(ctx, req.GetUserId()) > 0 {
+		return nil, status.Error(codes.FailedPrecondition, "user is suppressed")
+	}
+	if err := s.service.UpdatePresence(ctx, req.GetUserId(), presenceStatus, req.GetDevice(), req.GetDeviceId(), req.Active == nil || req.GetActive(), int(req.GetTtlSeconds())); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to update presence", "error", err)
+		return nil, status.Error(codes.Internal, "failed to update presence")
+	}
+	return &presencepb.UpdatePresenceResponse{}, nil
+}
+
+func (s *Server) GetPresence(ctx context.Context, req *presencepb.GetPresenceRequest) (*presencepb.Presence, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	presence, err := s.service.GetPresence(ctx, req.GetUserId(), req.GetRaw())
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to get presence", "error", err)
+		return nil, status.Error(codes.Internal, "failed to get presence")
+	}
+	return presenceToProto(*presence), nil
+}
+
+func (s *Server) BulkGetPresence(ctx context.Context, req *presencepb.BulkGetPresenceRequest) (*presencepb.BulkGetPresenceResponse, error) {
+	if err := s.checkUserIDs(req.GetUserIds()); err != nil {
+		return nil, err
+	}
+
+	users, err := s.service.BulkGetPresence(ctx, req.GetUserIds(), req.GetRaw())
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to get presence", "error", err)
+		return nil, status.Error(codes.Internal, "failed to get presence")
+	}
+
+	resp := &presencepb.BulkGetPresenceResponse{Presences: make([]*presencepb.Presence, len(users))}
+	for i, user := range users {
+		resp.Presences[i] = presenceToProto(user)
+	}
+	return resp, nil
+}
+
+func (s *Server) IsOnline(ctx context.Context, req *presencepb.IsOnlineRequest) (*presencepb.IsOnlineResponse, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	online, err := s.service.IsOnline(ctx, req.GetUserId())
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to check presence", "error", err)
+		return nil, status.Error(codes.Internal, "failed to check presence")
+	}
+	return &presencepb.IsOnlineResponse{Online: online}, nil
+}
+
+func (s *Server) WatchPresence(req *presencepb.WatchPresenceRequest, stream presencepb.PresenceService_WatchPresenceServer) error {
+	if err := s.checkUserIDs(req.GetUserIds()); err != nil {
+		return err
+	}
+
+	err := s.service.WatchPresence(stream.Context(), req.GetUserIds(), func(event models.PresenceEvent) error {
+		return stream.Send(eventToProto(event))
+	})
+	if err != nil {
+		s.logger.WarnContext(stream.Context(), "Presence watch ended", "error", err)
+		return status.Error(codes.Unavailable, "presence events are unavailable")
+	}
+	return nil
+}
+
+func (s *Server) checkUserIDs(userIDs []string) error {
+	if len(userIDs) == 0 {
+		return status.Error(codes.InvalidArgument, "user_ids is required")
+	}
+	if len(userIDs) > s.maxBulk {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("at most %d user_ids per call", s.maxBulk))
+	}
+	return nil
+}
+
+func presenceToProto(presence models.UserPresence) *presencepb.Presence {
+	msg := &presencepb.Presence{
+		UserId:        presence.UserID,
+		Status:        presence.Status,
+		Device:        presence.Device,
+		StatusMessage: presence.StatusMessage,
+		StatusEmoji:   presence.StatusEmoji,
+	}
+	if !presence.LastSeen.IsZero() {
+		msg.LastSeen = timestamppb.New(presence.LastSeen)
+	}
+	if presence.StatusExpiresAt != nil {
+		msg.StatusExpiresAt = timestamppb.New(*presence.StatusExpiresAt)
+	}
+	for _, device := range presence.Devices {
+		session := &presencepb.DevicePresence{
+			DeviceId: device.DeviceID,
+			Device:   device.Device,
+			Status:   device.Status,
+			LastSeen: timestamppb.New(device.LastSeen),
+		}
+		if !device.LastActive.IsZero() {
+			session.LastActive = timestamppb.New(device.LastActive)
+		}
+		msg.Devices = append(msg.Devices, session)
+	}
+	return msg
+}
+
+func eventToProto(event models.PresenceEvent) *presencepb.PresenceEvent {
+	return &presencepb.PresenceEvent{
+		UserId:        event.UserID,
+		OldStatus:     event.OldStatus,
+		NewStatus:     event.NewStatus,
+		Device:        event.Device,
+		Reason:        event.Reason,
+		Timestamp:     timestamppb.New(event.Timestamp),
+		StatusMessage: event.StatusMessage,
+		StatusEmoji:   event.StatusEmoji,
+	}
+}
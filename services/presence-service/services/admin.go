@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"chorus/presence-service/models"
+)
+
+const (
+	// Set per user while their heartbeats are refused after being forced offline
+	suppressedKeyPrefix = "presence_suppressed:"
+
+	// List of models.AdminAuditEntry, newest first, never expires
+	adminAuditKey = "presence_admin_audit"
+
+	// Entries kept in adminAuditKey
+	MaxAdminAuditEntries = 1000
+)
+
+// SetSuppressDuration sets how long heartbeats of a user forced offline are refused,
+// 0 never refuses them
+func (ps *PresenceService) SetSuppressDuration(d time.Duration) {
+	ps.suppressFor = d
+}
+
+// ForceOffline removes every session of the user at once, instead of waiting for
+// them to expire, along with their room memberships and heartbeat rate limits, and
+// announces them offline with reason administrative. With suppress, and a suppress
+// duration set, their heartbeats are refused for that long so that a client still
+// running cannot bring them back. The action is recorded in the audit list under
+// actor, in the same transaction.
+func (ps *PresenceService) ForceOffline(ctx context.Context, userID, actor string, suppress bool) (*models.AdminAuditEntry, error) {
+	key := presenceKeyPrefix + userID
+	roomsKey := userRoomsKeyPrefix + userID
+	now := time.Now()
+
+	entry := models.AdminAuditEntry{
+		Action:    models.AdminActionForceOffline,
+		UserID:    userID,
+		Actor:     actor,
+		Timestamp: now,
+	}
+	if suppress && ps.suppressFor > 0 {
+		until := now.Add(ps.suppressFor)
+		entry.SuppressedUntil = &until
+	}
+
+	var device string
+	err := ps.updateSessions(ctx, key, func(tx *redis.Tx) error {
+		current, stored, err := ps.loadPresence(ctx, tx, key, now)
+		if err != nil {
+			return err
+		}
+		entry.PreviousStatus = stored
+		device = ""
+		if len(current.Devices) > 0 {
+			device = current.Devices[0].Device
+		}
+
+		roomIDs, err := tx.SMembers(ctx, roomsKey).Result()
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit entry: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key, roomsKey)
+			pipe.SRem(ctx, onlineSetKey, userID)
+			for _, roomID := range roomIDs {
+				pipe.ZRem(ctx, roomMembersKeyPrefix+roomID, userID)
+			}
+			for _, session := range current.Devices {
+				pipe.Del(ctx, heartbeatLimitKeyPrefix+userID+":"+session.DeviceID)
+			}
+			if len(current.Devices) > 0 {
+				pipe.ZAdd(ctx, recentKey, recentMember(userID, now))
+			}
+			if entry.SuppressedUntil != nil {
+				pipe.Set(ctx, suppressedKeyPrefix+userID, actor, ps.suppressFor)
+			}
+			pipe.LPush(ctx, adminAuditKey, data)
+			pipe.LTrim(ctx, adminAuditKey, 0, MaxAdminAuditEntries-1)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to force user offline: %w", err)
+	}
+	forcedOfflineTotal.Inc()
+
+	if entry.PreviousStatus != "offline" {
+		ps.saveLastKnown(ctx, models.UserPresence{
+			UserID:   userID,
+			Status:   "offline",
+			LastSeen: now,
+			Device:   device,
+		})
+		ps.publishChange(ctx, models.PresenceEvent{
+			UserID:    userID,
+			OldStatus: entry.PreviousStatus,
+			NewStatus: "offline",
+			Device:    device,
+			Reason:    models.PresenceChangeAdministrative,
+			Timestamp: now,
+		})
+	}
+
+	ps.logger.WarnContext(ctx, "Forced user offline", "user_id", userID, "actor", actor, "suppressed", entry.SuppressedUntil != nil)
+	return &entry, nil
+}
+
+// CheckSuppressed returns how much longer the user's heartbeats are refused after
+// they were forced offline, or 0 if they are not. When Redis cannot be reached,
+// heartbeats are allowed.
+func (ps *PresenceService) CheckSuppressed(ctx context.Context, userID string) time.Duration {
+	remaining, err := ps.redis.PTTL(ctx, suppressedKeyPrefix+userID).Result()
+	if err != nil {
+		ps.logger.ErrorContext(ctx, "Error checking heartbeat suppression", "op", "PTTL", "key", suppressedKeyPrefix, "user_id", userID, "error", err)
+		return 0
+	}
+	// Missing keys report negative values
+	if remaining <= 0 {
+		return 0
+	}
+	heartbeatsSuppressedTotal.Inc()
+	return remaining
+}
+
+// AdminAudit returns up to limit of the latest administrative actions, newest first
+func (ps *PresenceService) AdminAudit(ctx context.Context, limit int) ([]models.AdminAuditEntry, error) {
+	entries, err := ps.redis.LRange(ctx, adminAuditKey, 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get admin audit: %w", err)
+	}
+
+	audit := make([]models.AdminAuditEntry, 0, len(entries))
+	for _, data := range entries {
+		var entry models.AdminAuditEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			ps.logger.ErrorContext(ctx, "Error unmarshaling admin audit entry", "key", adminAuditKey, "error", err)
+			continue
+		}
+		audit = append(audit, entry)
+	}
+	return audit, nil
+}
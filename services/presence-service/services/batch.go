@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"chorus/presence-service/models"
+)
+
+// batchReads are the keys read for one user of a heartbeat batch
+type batchReads struct {
+	presence   *redis.StringCmd
+	message    *redis.StringCmd
+	override   *redis.StringCmd
+	dnd        *redis.StringCmd
+	rooms      *redis.StringSliceCmd
+	suppressed *redis.DurationCmd
+}
+
+// UpdatePresenceBatch records many heartbeats, as sent by a gateway for all of its
+// connections, with one pipeline reading the users' presence and one writing it
+// instead of a watched transaction per heartbeat. A heartbeat from elsewhere for
+// the same user landing between the two can be overwritten; that session shows up
+// again with its next heartbeat. It returns the heartbeats that failed, by index.
+func (ps *PresenceService) UpdatePresenceBatch(ctx context.Context, heartbeats []models.BatchHeartbeat) []models.BatchHeartbeatFailure {
+	var failures []models.BatchHeartbeatFailure
+	fail := func(indexes []int, message string) {
+		for _, i := range indexes {
+			failures = append(failures, models.BatchHeartbeatFailure{
+				Index:  i,
+				UserID: heartbeats[i].UserID,
+				Error:  message,
+			})
+		}
+	}
+	
+	// Heartbeats of each user, applied in order
+	var userIDs []string
+	byUser := make(map[string][]int)
+	for i, heartbeat := range heartbeats {
+		if heartbeat.UserID == "" {
+			fail([]int{i}, "user_id is required")
+			continue
+		}
+		if heartbeat.Status == "" {
+			heartbeats[i].Status = "online"
+		}
+		if err := ValidateHeartbeat(heartbeats[i].Status, heartbeat.Device, heartbeat.DeviceID); err != nil {
+			fail([]int{i}, err.Error())
+			continue
+		}
+		if err := ps.ValidateTTL(heartbeat.TTLSeconds); err != nil {
+			fail([]int{i}, err.Error())
+			continue
+		}
+		if _, ok := byUser[heartbeat.UserID]; !ok {
+			userIDs = append(userIDs, heartbeat.UserID)
+		}
+		byUser[heartbeat.UserID] = append(byUser[heartbeat.UserID], i)
+	}
+	if len(userIDs) == 0 {
+		return failures
+	}
+	
+	pipe := ps.redis.Pipeline()
+	reads := make([]batchReads, len(userIDs))
+	for i, userID := range userIDs {
+		reads[i] = batchReads{
+			presence:   pipe.Get(ctx, presenceKeyPrefix+userID),
+			message:    pipe.Get(ctx, statusMessageKeyPrefix+userID),
+			override:   pipe.Get(ctx, overrideKeyPrefix+userID),
+			dnd:        pipe.Get(ctx, dndKeyPrefix+userID),
+			rooms:      pipe.SMembers(ctx, userRoomsKeyPrefix+userID),
+			suppressed: pipe.PTTL(ctx, suppressedKeyPrefix+userID),
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		ps.logger.ErrorContext(ctx, "Error reading presence for heartbeat batch", "op", "GET", "key", presenceKeyPrefix, "users", len(userIDs), "error", err)
+		for _, userID := range userIDs {
+			fail(byUser[userID], "failed to read presence")
+		}
+		return failures
+	}
+	
+	now := time.Now()
+	var events []models.PresenceEvent
+	var present []string // users whose presence is written
+	pipe = ps.redis.Pipeline()
+	writes := make([]*redis.StatusCmd, len(userIDs))
+	for i, userID := range userIDs {
+		// Forced offline users stay offline until their cool-down ends
+		if reads[i].suppressed.Val() > 0 {
+			heartbeatsSuppressedTotal.Add(float64(len(byUser[userID])))
+			fail(byUser[userID], "user is suppressed")
+			continue
+		}
+		
+		current, stored := &models.UserPresence{Status: "offline"}, "offline"
+		if data, err := reads[i].presence.Result(); err == nil {
+			current, stored = ps.decodePresence(data, now)
+		}
+		
+		var message *models.StatusMessage
+		if data, err := reads[i].message.Result(); err == nil {
+			message = &models.StatusMessage{}
+			if err := json.Unmarshal([]byte(data), message); err != nil {
+				ps.logger.ErrorContext(ctx, "Error unmarshaling status message", "key", statusMessageKeyPrefix, "user_id", userID, "error", err)
+				message = nil
+			}
+		}
+		var override *models.StatusOverride
+		if data, err := reads[i].override.Result(); err == nil {
+			override = &models.StatusOverride{}
+			if err := json.Unmarshal([]byte(data), override); err != nil {
+				ps.logger.ErrorContext(ctx, "Error unmarshaling status override", "key", overrideKeyPrefix, "user_id", userID, "error", err)
+				override = nil
+			}
+		}
+		var dnd *models.DNDSchedule
+		if data, err := reads[i].dnd.Result(); err == nil {
+			dnd = &models.DNDSchedule{}
+			if err := json.Unmarshal([]byte(data), dnd); err != nil {
+				ps.logger.ErrorContext(ctx, "Error unmarshaling do-not-disturb", "key", dndKeyPrefix, "user_id", userID, "error", err)
+				dnd = nil
+			}
+		}
+		
+		presence := *current
+		var device string
+		for _, index := range byUser[userID] {
+			heartbeat := heartbeats[index]
+			device = heartbeat.Device
+			presence = ps.heartbeatPresence(userID, &presence, models.DevicePresence{
+				DeviceID: sessionID(heartbeat.Device, heartbeat.DeviceID),
+				Device:   heartbeat.Device,
+				Status:     heartbeat.Status,
+				LastSeen:   now,
+				TTLSeconds: int(ps.requestedTTL(heartbeat.TTLSeconds).Seconds()),
+			}, heartbeat.Active == nil || *heartbeat.Active, message, override, dnd, now)
+		}
+		expiry := ps.presenceExpiry(presence)
+		
+		data, err := json.Marshal(presence)
+		if err != nil {
+			fail(byUser[userID], "failed to marshal presence data")
+			continue
+		}
+		writes[i] = pipe.Set(ctx, presenceKeyPrefix+userID, data, expiry.Sub(now))
+		present = append(present, userID)
+		pipe.HSet(ctx, lastKnownKey, userID, data)
+		pipe.ZAdd(ctx, recentKey, recentMember(userID, now))
+		
+		// Refresh joined rooms, as touchRooms does for single heartbeats
+		if roomIDs := reads[i].rooms.Val(); len(roomIDs) > 0 {
+			for _, roomID := range roomIDs {
+				membersKey := roomMembersKeyPrefix + roomID
+				pipe.ZAdd(ctx, membersKey, redis.Z{Score: float64(expiry.Unix()), Member: userID})
+				pipe.Expire(ctx, membersKey, userRoomsTTL)
+			}
+			pipe.Expire(ctx, userRoomsKeyPrefix+userID, userRoomsTTL)
+		}
+		
+		if stored != presence.Status {
+			events = append(events, models.PresenceEvent{
+				UserID:        userID,
+				OldStatus:     stored,
+				NewStatus:     presence.Status,
+				Device:        device,
+				Reason:        models.PresenceChangeUpdate,
+				Timestamp:     now,
+				StatusMessage: presence.StatusMessage,
+				StatusEmoji:   presence.StatusEmoji,
+			})
+		}
+	}
+	var online *redis.IntCmd
+	if len(present) > 0 {
+		online = pipe.SAdd(ctx, onlineSetKey, present)
+		pipe.Expire(ctx, onlineSetKey, ps.longestTTL()*2)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		ps.logger.ErrorContext(ctx, "Error writing presence for heartbeat batch", "op", "SET", "key", presenceKeyPrefix, "users", len(userIDs), "error", err)
+	}
+	
+	written := make(map[string]bool, len(userIDs))
+	for i, userID := range userIDs {
+		switch {
+		case writes[i] == nil:
+			// Already failed
+		case writes[i].Err() != nil:
+			fail(byUser[userID], "failed to update presence")
+		case online.Err() != nil:
+			fail(byUser[userID], "failed to mark user online")
+		default:
+			written[userID] = true
+		}
+	}
+	
+	// Only announce changes that were stored
+	pipe = ps.redis.Pipeline()
+	var stored []models.PresenceEvent
+	for _, event := range events {
+		if written[event.UserID] {
+			ps.recordTransition(ctx, pipe, event)
+			stored = append(stored, event)
+		}
+	}
+	if pipe.Len() > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			ps.logger.ErrorContext(ctx, "Error recording presence transitions for heartbeat batch", "op", "LPUSH", "key", historyKeyPrefix, "events", len(stored), "error", err)
+		}
+	}
+	for _, event := range stored {
+		ps.publishEvent(ctx, toBusEvent(event))
+	}
+	
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Index < failures[j].Index
+	})
+	ps.logger.DebugContext(ctx, "Updated presence from a heartbeat batch", "users", len(written), "heartbeats", len(heartbeats), "failed", len(failures))
+	return failures
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"chorus/presence-service/models"
+)
+
+// Heartbeats per round, one per user, and per batch in BenchmarkHeartbeatBatch
+const (
+	benchUsers     = 1000
+	benchBatchSize = 500
+)
+
+func benchHeartbeats() []models.BatchHeartbeat {
+	heartbeats := make([]models.BatchHeartbeat, benchUsers)
+	for i := range heartbeats {
+		heartbeats[i] = models.BatchHeartbeat{
+			UserID: fmt.Sprintf("bench-%d", i),
+			Status: "online",
+			Device: "web",
+		}
+	}
+	return heartbeats
+}
+
+// BenchmarkHeartbeatBatch records a round of heartbeats through
+// UpdatePresenceBatch; compare it with BenchmarkHeartbeatIndividual
+func BenchmarkHeartbeatBatch(b *testing.B) {
+	ps, _ := newTestService(b)
+	ctx := context.Background()
+	heartbeats := benchHeartbeats()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for start := 0; start < len(heartbeats); start += benchBatchSize {
+			end := min(start+benchBatchSize, len(heartbeats))
+			if failed := ps.UpdatePresenceBatch(ctx, heartbeats[start:end]); len(failed) > 0 {
+				b.Fatalf("%d heartbeats failed, first: %s", len(failed), failed[0].Error)
+			}
+		}
+	}
+}
+
+// BenchmarkHeartbeatIndividual records a round of heartbeats one UpdatePresence
+// at a time
+func BenchmarkHeartbeatIndividual(b *testing.B) {
+	ps, _ := newTestService(b)
+	ctx := context.Background()
+	heartbeats := benchHeartbeats()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, heartbeat := range heartbeats {
+			if err := ps.UpdatePresence(ctx, heartbeat.UserID, heartbeat.Status, heartbeat.Device, heartbeat.DeviceID, true, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
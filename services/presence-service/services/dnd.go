@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"chorus/presence-service/models"
+)
+
+const (
+	// Do-not-disturb settings live under their own key and never expire
+	dndKeyPrefix = "dnd:"
+	
+	// Status reported while a user's do-not-disturb is active
+	StatusDND = "dnd"
+	
+	dndClockLayout = "15:04"
+)
+
+var (
+	dndDays = map[string]time.Weekday{
+		"sun": time.Sunday,
+		"mon": time.Monday,
+		"tue": time.Tuesday,
+		"wed": time.Wednesday,
+		"thu": time.Thursday,
+		"fri": time.Friday,
+		"sat": time.Saturday,
+	}
+	
+	// Loaded time zones by name, as schedules are evaluated on every read
+	dndLocations sync.Map
+)
+
+// ValidateDND checks a do-not-disturb setting and normalizes its timezone and days
+func ValidateDND(dnd *models.DNDSchedule) error {
+	if (dnd.Start == "") != (dnd.End == "") {
+		return fmt.Errorf("start and end must be set together")
+	}
+	if dnd.Start != "" {
+		start, err := time.Parse(dndClockLayout, dnd.Start)
+		if err != nil {
+			return fmt.Errorf("start must be a time of day as HH:MM")
+		}
+		end, err := time.Parse(dndClockLayout, dnd.End)
+		if err != nil {
+			return fmt.Errorf("end must be a time of day as HH:MM")
+		}
+		if start.Equal(end) {
+			return fmt.Errorf("start and end must differ")
+		}
+		if dnd.Timezone == "" {
+			dnd.Timezone = "UTC"
+		}
+		if _, err := dndLocation(dnd.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", dnd.Timezone)
+		}
+	} else if len(dnd.Days) > 0 {
+		return fmt.Errorf("days need start and end")
+	}
+	for i, day := range dnd.Days {
+		day = strings.ToLower(day)
+		if _, ok := dndDays[day]; !ok {
+			return fmt.Errorf("days must be among mon, tue, wed, thu, fri, sat and sun")
+		}
+		dnd.Days[i] = day
+	}
+	if dnd.EnabledUntil != nil && !dnd.EnabledUntil.After(time.Now()) {
+		return fmt.Errorf("enabled_until must be in the future")
+	}
+	return nil
+}
+
+// SetDND replaces the user's do-not-disturb setting, or clears it when it has
+// neither a schedule nor enabled_until, and returns whether it is active now. While
+// the user is present their status is updated and, if it changes, a dnd event is
+// published.
+func (ps *PresenceService) SetDND(ctx context.Context, userID string, dnd models.DNDSchedule) (bool, error) {
+	if err := ValidateDND(&dnd); err != nil {
+		return false, err
+	}
+	
+	clear := dnd.Start == "" && dnd.EnabledUntil == nil
+	data, err := json.Marshal(dnd)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal do-not-disturb: %w", err)
+	}
+	
+	key := presenceKeyPrefix + userID
+	dndKey := dndKeyPrefix + userID
+	now := time.Now()
+	
+	var oldStatus string
+	var presence *models.UserPresence
+	err = ps.updateSessions(ctx, key, func(tx *redis.Tx) error {
+		current, stored, err := ps.loadPresence(ctx, tx, key, now)
+		if err != nil {
+			return err
+		}
+		oldStatus = stored
+		presence = current
+		presence.UserID = userID
+		override, err := ps.loadOverride(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		applyOverride(presence, override, now)
+		if !clear {
+			applyDND(presence, &dnd, now)
+		}
+		
+		blob, err := json.Marshal(presence)
+		if err != nil {
+			return fmt.Errorf("failed to marshal presence data: %w", err)
+		}
+		
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if clear {
+				pipe.Del(ctx, dndKey)
+			} else {
+				pipe.Set(ctx, dndKey, data, 0)
+			}
+			if len(presence.Devices) > 0 && presence.Status != oldStatus {
+				pipe.Set(ctx, key, blob, redis.KeepTTL)
+				pipe.HSet(ctx, lastKnownKey, userID, blob)
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to set do-not-disturb: %w", err)
+	}
+	
+	if len(presence.Devices) > 0 && presence.Status != oldStatus {
+		ps.publishChange(ctx, models.PresenceEvent{
+			UserID:        userID,
+			OldStatus:     oldStatus,
+			NewStatus:     presence.Status,
+			Device:        presence.Device,
+			Reason:        models.PresenceChangeDND,
+			Timestamp:     now,
+			StatusMessage: presence.StatusMessage,
+			StatusEmoji:   presence.StatusEmoji,
+		})
+	}
+	
+	if clear {
+		ps.logger.InfoContext(ctx, "Cleared do-not-disturb", "user_id", userID)
+		return false, nil
+	}
+	ps.logger.InfoContext(ctx, "Set do-not-disturb", "user_id", userID)
+	return dndActive(&dnd, now), nil
+}
+
+// loadDND returns the user's do-not-disturb setting, or nil if they have none
+func (ps *PresenceService) loadDND(ctx context.Context, client redis.Cmdable, userID string) (*models.DNDSchedule, error) {
+	data, err := client.Get(ctx, dndKeyPrefix+userID).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get do-not-disturb: %w", err)
+	}
+	
+	var dnd models.DNDSchedule
+	if err := json.Unmarshal([]byte(data), &dnd); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal do-not-disturb: %w", err)
+	}
+	return &dnd, nil
+}
+
+// applyDND replaces the status of a present user with dnd while dnd is active. The
+// sessions keep their reported status, so merging them again gives the raw status.
+func applyDND(presence *models.UserPresence, dnd *models.DNDSchedule, now time.Time) {
+	if dnd == nil || presence.Status == "offline" {
+		return
+	}
+	if dndActive(dnd, now) {
+		presence.Status = StatusDND
+	}
+}
+
+// dndActive reports whether dnd is in effect at now. A manual enabled_until wins
+// over the schedule. A window that crosses midnight belongs to the day it starts.
+func dndActive(dnd *models.DNDSchedule, now time.Time) bool {
+	if dnd.EnabledUntil != nil && now.Before(*dnd.EnabledUntil) {
+		return true
+	}
+	if dnd.Start == "" {
+		return false
+	}
+	
+	start, err := time.Parse(dndClockLayout, dnd.Start)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse(dndClockLayout, dnd.End)
+	if err != nil {
+		return false
+	}
+	loc, err := dndLocation(dnd.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+	
+	switch {
+	case startMinute < endMinute:
+		return minute >= startMinute && minute < endMinute && dndOnDay(dnd, local.Weekday())
+	case minute >= startMinute:
+		return dndOnDay(dnd, local.Weekday())
+	case minute < endMinute:
+		// Still in the window that started the day before
+		return dndOnDay(dnd, (local.Weekday()+6)%7)
+	default:
+		return false
+	}
+}
+
+// dndOnDay reports whether the schedule starts on day; no days means every day
+func dndOnDay(dnd *models.DNDSchedule, day time.Weekday) bool {
+	if len(dnd.Days) == 0 {
+		return true
+	}
+	for _, name := range dnd.Days {
+		if dndDays[name] == day {
+			return true
+		}
+	}
+	return false
+}
+
+func dndLocation(name string) (*time.Location, error) {
+	if loc, ok := dndLocations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	dndLocations.Store(name, loc)
+	return loc, nil
+}
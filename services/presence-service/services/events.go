@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"sync"
+
+	"chorus/pkg/eventbus"
+	"chorus/presence-service/models"
+)
+
+// Status changes buffered for a watcher that has not taken them yet; beyond this
+// they are dropped
+const watcherBuffer = 64
+
+// presenceWatcher is a watch or long poll waiting for the status changes of users
+type presenceWatcher struct {
+	users  map[string]bool
+	events chan models.PresenceEvent
+}
+
+// presenceWatchers are the watchers of this instance, which RunEvents hands the
+// status changes of their users to
+type presenceWatchers struct {
+	mu  sync.Mutex
+	set map[*presenceWatcher]struct{}
+}
+
+// watch registers a watcher of userIDs, which gets every status change RunEvents
+// receives from then on until stop is called
+func (w *presenceWatchers) watch(userIDs []string) (watcher *presenceWatcher, stop func()) {
+	watcher = &presenceWatcher{
+		users:  make(map[string]bool, len(userIDs)),
+		events: make(chan models.PresenceEvent, watcherBuffer),
+	}
+	for _, userID := range userIDs {
+		watcher.users[userID] = true
+	}
+
+	w.mu.Lock()
+	if w.set == nil {
+		w.set = make(map[*presenceWatcher]struct{})
+	}
+	w.set[watcher] = struct{}{}
+	w.mu.Unlock()
+
+	return watcher, func() {
+		w.mu.Lock()
+		delete(w.set, watcher)
+		w.mu.Unlock()
+	}
+}
+
+// dispatch hands event to the watchers of its user, and reports how many had no
+// room left for it
+func (w *presenceWatchers) dispatch(event models.PresenceEvent) (dropped int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for watcher := range w.set {
+		if !watcher.users[event.UserID] {
+			continue
+		}
+		select {
+		case watcher.events <- event:
+		default:
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// RunEvents subscribes to eventbus.PresenceEvents and hands the status changes every
+// instance publishes to the watches and long polls of this one. It blocks until ctx
+// is cancelled, resubscribing after connection loss; events published while
+// unsubscribed are lost.
+func (ps *PresenceService) RunEvents(ctx context.Context) {
+	eventbus.Subscribe(ctx, ps.events, eventbus.PresenceEvents, func(ctx context.Context, msg eventbus.Message[eventbus.PresenceEvent]) error {
+		if !msg.Data.StatusChange() {
+			return nil
+		}
+		if dropped := ps.watchers.dispatch(fromBusEvent(msg.Data)); dropped > 0 {
+			ps.logger.WarnContext(ctx, "Dropped a presence event for slow watchers", "user_id", msg.Data.UserID, "watchers", dropped)
+		}
+		return nil
+	})
+}
+
+// publishEvent publishes event on eventbus.PresenceEvents, logging a failure
+func (ps *PresenceService) publishEvent(ctx context.Context, event eventbus.PresenceEvent) {
+	if err := eventbus.Publish(ctx, ps.events, eventbus.PresenceEvents, event); err != nil {
+		ps.logger.ErrorContext(ctx, "Error publishing presence event", "op", "PUBLISH", "key", eventbus.PresenceEvents.Name, "user_id", event.UserID, "reason", event.Reason, "error", err)
+	}
+}
+
+func toBusEvent(event models.PresenceEvent) eventbus.PresenceEvent {
+	return eventbus.PresenceEvent{
+		UserID:        event.UserID,
+		OldStatus:     event.OldStatus,
+		NewStatus:     event.NewStatus,
+		Device:        event.Device,
+		Reason:        event.Reason,
+		Timestamp:     event.Timestamp,
+		StatusMessage: event.StatusMessage,
+		StatusEmoji:   event.StatusEmoji,
+	}
+}
+
+func fromBusEvent(event eventbus.PresenceEvent) models.PresenceEvent {
+	return models.PresenceEvent{
+		UserID:        event.UserID,
+		OldStatus:     event.OldStatus,
+		NewStatus:     event.NewStatus,
+		Device:        event.Device,
+		Reason:        event.Reason,
+		Timestamp:     event.Timestamp,
+		StatusMessage: event.StatusMessage,
+		StatusEmoji:   event.StatusEmoji,
+	}
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"chorus/pkg/eventbus"
+	"chorus/presence-service/models"
+)
+
+// Status changes go out on the event bus, and reach the watchers of their user
+// through the instance's one subscription; typing events do not
+func TestWatchPresenceThroughEventBus(t *testing.T) {
+	ps, mr := newTestService(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	raw := client.Subscribe(ctx, eventbus.PresenceEvents.Name)
+	defer raw.Close()
+	if _, err := raw.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	watched := make(chan models.PresenceEvent, 10)
+	go ps.WatchPresence(ctx, []string{"alice"}, func(event models.PresenceEvent) error {
+		watched <- event
+		return nil
+	})
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		ps.watchers.mu.Lock()
+		n := len(ps.watchers.set)
+		ps.watchers.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the watch did not start")
+		}
+	}
+
+	if _, err := ps.SetTyping(ctx, "alice", "room-1", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.UpdatePresence(ctx, "bob", "online", "web", "", true, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.UpdatePresence(ctx, "alice", "online", "web", "", true, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-watched:
+		if event.UserID != "alice" || event.NewStatus != "online" || event.Reason != models.PresenceChangeUpdate {
+			t.Errorf("watched %+v, want alice coming online", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watch got no event")
+	}
+	select {
+	case event := <-watched:
+		t.Errorf("watched %+v, want only alice's status change", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Every event carries the bus's envelope, typing events included
+	for range 3 {
+		msg, err := raw.ReceiveMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var envelope eventbus.Envelope
+		if err := json.Unmarshal([]byte(msg.Payload), &envelope); err != nil {
+			t.Fatal(err)
+		}
+		if envelope.EventID == "" || envelope.Version != eventbus.PresenceEvents.Version || envelope.Source != "presence-service" {
+			t.Errorf("event %s has envelope %+v, want one from presence-service", msg.Payload, envelope)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Keyspace notification classes needed for expiry events: E (keyevent channels)
+// and x (expired events)
+const expiryNotificationFlags = "Ex"
+
+// WatchExpirations subscribes to Redis expiry notifications and marks users offline
+// as soon as their presence key expires, instead of waiting for the next online
+// users listing. It blocks until ctx is cancelled, resubscribing after connection
+// loss. When configure is true, keyspace notifications are enabled on the server
+// at every (re)subscription, since a restarted Redis loses runtime CONFIG changes.
+func (ps *PresenceService) WatchExpirations(ctx context.Context, configure bool) {
+	channel := fmt.Sprintf("__keyevent@%d__:expired", ps.redis.Options().DB)
+	pubsub := ps.redis.Subscribe(ctx, channel)
+	defer pubsub.Close()
+	
+	// Receive blocks on the connection regardless of ctx, so closing unblocks it
+	go func() {
+		<-ctx.Done()
+		pubsub.Close()
+	}()
+
+	backoff := time.Second
+	for {
+		msg, err := pubsub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// The next Receive reconnects and resubscribes
+			ps.listenerLost(err, time.Now())
+			ps.logger.WarnContext(ctx, "Expiry notification subscription lost, retrying", "op", "SUBSCRIBE", "channel", channel, "retry_in", backoff.String(), "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+			continue
+		}
+
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if msg.Kind != "subscribe" {
+				continue
+			}
+			backoff = time.Second
+			ps.listenerSubscribed(time.Now())
+			ps.logger.InfoContext(ctx, "Subscribed to expiry notifications", "channel", msg.Channel)
+			if configure {
+				if err := ps.enableExpiryNotifications(ctx); err != nil {
+					ps.logger.WarnContext(ctx, "Failed to enable Redis keyspace notifications, offline detection stays lazy", "op", "CONFIG SET", "error", err)
+				}
+			}
+			// Keys may have expired while we were not subscribed
+			if _, _, err := ps.sweepOnline(ctx); err != nil {
+				ps.logger.ErrorContext(ctx, "Error sweeping expired presence", "error", err)
+			}
+		case *redis.Message:
+			ps.listenerNotified(time.Now())
+			if !strings.HasPrefix(msg.Payload, presenceKeyPrefix) {
+				continue
+			}
+			ps.expireUsers(ctx, []string{strings.TrimPrefix(msg.Payload, presenceKeyPrefix)})
+		}
+	}
+}
+
+// enableExpiryNotifications adds the expired event flags to the server's
+// notify-keyspace-events setting, keeping any flags already set
+func (ps *PresenceService) enableExpiryNotifications(ctx context.Context) error {
+	current, err := ps.redis.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		return err
+	}
+
+	flags := current["notify-keyspace-events"]
+	updated := flags
+	for _, flag := range expiryNotificationFlags {
+		// A is an alias for all event classes, including x
+		if !strings.ContainsRune(updated, flag) && !(flag == 'x' && strings.Contains(updated, "A")) {
+			updated += string(flag)
+		}
+	}
+	if updated == flags {
+		return nil
+	}
+
+	if err := ps.redis.ConfigSet(ctx, "notify-keyspace-events", updated).Err(); err != nil {
+		return err
+	}
+	ps.logger.InfoContext(ctx, "Enabled Redis keyspace notifications", "flags", updated)
+	return nil
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"chorus/presence-service/models"
+)
+
+// Users read from Redis per SSCAN or sorted set page while exporting
+const exportPageSize = 500
+
+// ExportPresence calls fn with the presence of every online user, or with a zero
+// since, of every user last seen after since, including those who went offline.
+// Users are read a page at a time with SSCAN or ZRANGEBYSCORE and one pipeline per
+// page, so the export never blocks Redis; a user whose presence changes meanwhile
+// may be skipped or exported twice. It stops at the first error of fn and returns
+// the number of users exported.
+func (ps *PresenceService) ExportPresence(ctx context.Context, since time.Time, fn func(models.PresenceExportRecord) error) (int, error) {
+	if since.IsZero() {
+		return ps.exportOnline(ctx, fn)
+	}
+	return ps.exportRecent(ctx, since, fn)
+}
+
+func (ps *PresenceService) exportOnline(ctx context.Context, fn func(models.PresenceExportRecord) error) (int, error) {
+	exported := 0
+	var cursor uint64
+	for {
+		userIDs, next, err := ps.redis.SScan(ctx, onlineSetKey, cursor, "", exportPageSize).Result()
+		if err != nil {
+			return exported, fmt.Errorf("failed to scan online users: %w", err)
+		}
+		cursor = next
+
+		if len(userIDs) > 0 {
+			users, err := ps.hydrateOnlineUsers(ctx, userIDs, "", false)
+			if err != nil {
+				return exported, err
+			}
+			n, err := exportUsers(users, fn)
+			exported += n
+			if err != nil {
+				return exported, err
+			}
+		}
+
+		if cursor == 0 {
+			return exported, nil
+		}
+	}
+}
+
+func (ps *PresenceService) exportRecent(ctx context.Context, since time.Time, fn func(models.PresenceExportRecord) error) (int, error) {
+	// Page by score rather than offset, so users moving to the end of the set on a
+	// heartbeat do not shift the pages still to come. Users sharing the score a
+	// page ended on are read again and skipped.
+	min := "(" + formatScore(since)
+	var seen map[string]bool
+	exported := 0
+	for {
+		page, err := ps.redis.ZRangeByScoreWithScores(ctx, recentKey, &redis.ZRangeBy{
+			Min:   min,
+			Max:   "+inf",
+			Count: exportPageSize,
+		}).Result()
+		if err != nil {
+			return exported, fmt.Errorf("failed to get recent users: %w", err)
+		}
+
+		userIDs := make([]string, 0, len(page))
+		for _, z := range page {
+			if userID, ok := z.Member.(string); ok && !seen[userID] {
+				userIDs = append(userIDs, userID)
+			}
+		}
+		if len(userIDs) == 0 {
+			return exported, nil
+		}
+
+		users, err := ps.hydrateRecentUsers(ctx, userIDs)
+		if err != nil {
+			return exported, err
+		}
+		n, err := exportUsers(users, fn)
+		exported += n
+		if err != nil {
+			return exported, err
+		}
+
+		if len(page) < exportPageSize {
+			return exported, nil
+		}
+		last := page[len(page)-1].Score
+		seen = map[string]bool{}
+		for _, z := range page {
+			if z.Score == last {
+				seen[z.Member.(string)] = true
+			}
+		}
+		min = strconv.FormatFloat(last, 'f', 3, 64)
+	}
+}
+
+func exportUsers(users []models.UserPresence, fn func(models.PresenceExportRecord) error) (int, error) {
+	for i, presence := range users {
+		record := models.PresenceExportRecord{
+			UserID:   presence.UserID,
+			Status:   presence.Status,
+			LastSeen: presence.LastSeen,
+			Device:   presence.Device,
+		}
+		if err := fn(record); err != nil {
+			return i, err
+		}
+	}
+	return len(users), nil
+}
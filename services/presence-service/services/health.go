@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"chorus/presence-service/models"
+)
+
+const (
+	// How long the readiness Redis ping may take
+	readinessPingTimeout = time.Second
+
+	// The expiry listener counts as degraded once it has been down for this long,
+	// past its reconnect backoff
+	listenerDownGrace = time.Minute
+
+	// The cleanup counts as degraded after the janitor missed this many sweeps
+	missedSweeps = 3
+)
+
+// Readiness check states
+const (
+	CheckOK       = "ok"
+	CheckDegraded = "degraded" // reported without failing readiness
+	CheckDown     = "down"
+)
+
+// healthState records the background work readiness reports on
+type healthState struct {
+	mu sync.Mutex
+
+	listenerUp       bool
+	listenerChanged  time.Time // when the listener last (un)subscribed
+	listenerActivity time.Time // last subscription or notification
+	listenerErr      string
+
+	sweptAt       time.Time     // last successful online set cleanup
+	sweepErr      string        // error of the last cleanup, if it failed
+	sweepInterval time.Duration // janitor interval, 0 while the janitor is not running
+}
+
+// Readiness pings Redis and reports it along with the expiry listener, the online
+// set cleanup and the Redis connection pool. It is ready unless a check is down.
+func (ps *PresenceService) Readiness(ctx context.Context) (models.ReadinessResponse, bool) {
+	now := time.Now()
+	checks := make(map[string]models.ReadinessCheck)
+
+	pingCtx, cancel := context.WithTimeout(ctx, readinessPingTimeout)
+	defer cancel()
+	start := time.Now()
+	if err := ps.redis.Ping(pingCtx).Err(); err != nil {
+		checks["redis"] = models.ReadinessCheck{Status: CheckDown, Error: err.Error()}
+	} else {
+		checks["redis"] = models.ReadinessCheck{
+			Status:    CheckOK,
+			LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		}
+	}
+
+	ps.health.mu.Lock()
+	listener := models.ReadinessCheck{Status: CheckOK, LastSuccess: timeOrNil(ps.health.listenerActivity)}
+	if !ps.health.listenerUp {
+		listener.Error = ps.health.listenerErr
+		// Never subscribed, or down for longer than reconnecting takes
+		if ps.health.listenerChanged.IsZero() || now.Sub(ps.health.listenerChanged) > listenerDownGrace {
+			listener.Status = CheckDegraded
+		}
+	}
+	cleanup := models.ReadinessCheck{Status: CheckOK, LastSuccess: timeOrNil(ps.health.sweptAt)}
+	if ps.health.sweepErr != "" {
+		cleanup.Status, cleanup.Error = CheckDegraded, ps.health.sweepErr
+	} else if ps.health.sweepInterval > 0 && now.Sub(ps.health.sweptAt) > missedSweeps*ps.health.sweepInterval {
+		cleanup.Status, cleanup.Error = CheckDegraded, "no sweep completed recently"
+	}
+	ps.health.mu.Unlock()
+	checks["expiry_listener"] = listener
+	checks["cleanup"] = cleanup
+
+	stats := ps.redis.PoolStats()
+	response := models.ReadinessResponse{
+		Status:    "ready",
+		Service:   "presence-service",
+		Timestamp: now,
+		Checks:    checks,
+		RedisPool: models.RedisPoolStats{
+			Hits:       stats.Hits,
+			Misses:     stats.Misses,
+			Timeouts:   stats.Timeouts,
+			TotalConns: stats.TotalConns,
+			IdleConns:  stats.IdleConns,
+			StaleConns: stats.StaleConns,
+		},
+	}
+	for _, check := range checks {
+		if check.Status == CheckDown {
+			response.Status = "not_ready"
+			return response, false
+		}
+	}
+	return response, true
+}
+
+// listenerSubscribed records that the expiry listener is subscribed
+func (ps *PresenceService) listenerSubscribed(now time.Time) {
+	ps.health.mu.Lock()
+	defer ps.health.mu.Unlock()
+	ps.health.listenerUp = true
+	ps.health.listenerChanged = now
+	ps.health.listenerActivity = now
+	ps.health.listenerErr = ""
+}
+
+// listenerNotified records an expiry notification
+func (ps *PresenceService) listenerNotified(now time.Time) {
+	ps.health.mu.Lock()
+	defer ps.health.mu.Unlock()
+	ps.health.listenerActivity = now
+}
+
+// listenerLost records that the expiry listener lost its subscription
+func (ps *PresenceService) listenerLost(err error, now time.Time) {
+	ps.health.mu.Lock()
+	defer ps.health.mu.Unlock()
+	if ps.health.listenerUp {
+		ps.health.listenerUp = false
+		ps.health.listenerChanged = now
+	}
+	ps.health.listenerErr = err.Error()
+}
+
+// swept records the outcome of an online set cleanup
+func (ps *PresenceService) swept(err error, now time.Time) {
+	ps.health.mu.Lock()
+	defer ps.health.mu.Unlock()
+	if err != nil {
+		ps.health.sweepErr = err.Error()
+		return
+	}
+	ps.health.sweptAt = now
+	ps.health.sweepErr = ""
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
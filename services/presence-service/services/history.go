@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+	"chorus/presence-service/models"
+)
+
+// List per user of their latest status transitions, newest first, capped at the
+// history length and kept without expiry like the last known presence
+const historyKeyPrefix = "presence_history:"
+
+// SetHistoryLength sets how many status transitions are kept per user; 0 keeps none
+func (ps *PresenceService) SetHistoryLength(length int) {
+	ps.historyLength = length
+}
+
+// HistoryLength returns how many status transitions are kept per user
+func (ps *PresenceService) HistoryLength() int {
+	return ps.historyLength
+}
+
+// GetHistory returns up to limit of the user's latest status transitions, newest
+// first
+func (ps *PresenceService) GetHistory(ctx context.Context, userID string, limit int) ([]models.PresenceTransition, error) {
+	entries, err := ps.redis.LRange(ctx, historyKeyPrefix+userID, 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get presence history: %w", err)
+	}
+	
+	transitions := make([]models.PresenceTransition, 0, len(entries))
+	for _, entry := range entries {
+		var transition models.PresenceTransition
+		if err := json.Unmarshal([]byte(entry), &transition); err != nil {
+			ps.logger.ErrorContext(ctx, "Error unmarshaling presence history", "key", historyKeyPrefix, "user_id", userID, "error", err)
+			continue
+		}
+		transitions = append(transitions, transition)
+	}
+	return transitions, nil
+}
+
+// recordTransition queues appending event to its user's history on pipe, if it
+// changed their status. Status message updates are not transitions.
+func (ps *PresenceService) recordTransition(ctx context.Context, pipe redis.Pipeliner, event models.PresenceEvent) {
+	if ps.historyLength <= 0 || event.OldStatus == event.NewStatus {
+		return
+	}
+	
+	data, err := json.Marshal(models.PresenceTransition{
+		OldStatus: event.OldStatus,
+		NewStatus: event.NewStatus,
+		Reason:    event.Reason,
+		Device:    event.Device,
+		Timestamp: event.Timestamp,
+	})
+	if err != nil {
+		return
+	}
+	
+	key := historyKeyPrefix + event.UserID
+	pipe.LPush(ctx, key, data)
+	pipe.LTrim(ctx, key, 0, int64(ps.historyLength-1))
+}
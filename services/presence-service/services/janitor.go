@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SetSweepBatchSize sets how many online set members a sweep reads at a time
+func (ps *PresenceService) SetSweepBatchSize(size int) {
+	ps.sweepBatchSize = size
+}
+
+// RunJanitor sweeps the online set every interval until ctx is cancelled, so users
+// whose presence expired leave it even when nobody lists online users and no expiry
+// notification arrives
+func (ps *PresenceService) RunJanitor(ctx context.Context, interval time.Duration) {
+	ps.health.mu.Lock()
+	ps.health.sweepInterval = interval
+	ps.health.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, _, err := ps.sweepOnline(ctx); err != nil && ctx.Err() == nil {
+			ps.logger.ErrorContext(ctx, "Error sweeping online users", "error", err)
+		}
+	}
+}
+
+// sweepOnline scans the online set a batch at a time, checks which members still
+// have a presence key with one pipelined EXISTS per member, and expires the rest.
+// It also prunes the recently active set. It returns how many members were scanned
+// and removed.
+func (ps *PresenceService) sweepOnline(ctx context.Context) (int, int, error) {
+	start := time.Now()
+	defer func() {
+		janitorDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	scanned, removed := 0, 0
+	var cursor uint64
+	for {
+		userIDs, next, err := ps.redis.SScan(ctx, onlineSetKey, cursor, "", int64(ps.sweepBatchSize)).Result()
+		if err != nil {
+			err = fmt.Errorf("failed to scan online users: %w", err)
+			ps.swept(err, time.Now())
+			return scanned, removed, err
+		}
+		cursor = next
+		scanned += len(userIDs)
+		janitorScannedTotal.Add(float64(len(userIDs)))
+
+		if len(userIDs) > 0 {
+			pipe := ps.redis.Pipeline()
+			exists := make([]*redis.IntCmd, len(userIDs))
+			for i, userID := range userIDs {
+				exists[i] = pipe.Exists(ctx, presenceKeyPrefix+userID)
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				err = fmt.Errorf("failed to check presence keys: %w", err)
+				ps.swept(err, time.Now())
+				return scanned, removed, err
+			}
+
+			var expired []string
+			for i, userID := range userIDs {
+				if exists[i].Val() == 0 {
+					expired = append(expired, userID)
+				}
+			}
+			if len(expired) > 0 {
+				n := ps.expireUsers(ctx, expired)
+				removed += n
+				janitorRemovedTotal.Add(float64(n))
+			}
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	ps.pruneRecent(ctx, time.Now())
+	ps.swept(nil, time.Now())
+	if removed > 0 {
+		ps.logger.InfoContext(ctx, "Swept online users", "scanned", scanned, "removed", removed, "duration", time.Since(start).String())
+	}
+	return scanned, removed, nil
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"chorus/presence-service/models"
+)
+
+// PollPresence returns the status transitions of userIDs after since, waiting up to
+// timeout for one if there is none yet, along with the time to poll from next.
+// Transitions are first looked up in the users' histories, which cover the gap
+// between two polls, and otherwise awaited from RunEvents. Without history,
+// transitions published between two polls are lost. On timeout it returns no
+// transitions and since unchanged.
+func (ps *PresenceService) PollPresence(ctx context.Context, userIDs []string, since time.Time, timeout time.Duration) ([]models.PresenceEvent, time.Time, error) {
+	// Watch before reading the histories, so a transition landing in between is
+	// not missed
+	watcher, stop := ps.watchers.watch(userIDs)
+	defer stop()
+
+	events, err := ps.transitionsSince(ctx, userIDs, since)
+	if err != nil {
+		return nil, since, err
+	}
+	if len(events) > 0 {
+		return events, events[len(events)-1].Timestamp, nil
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, since, ctx.Err()
+		case <-timer.C:
+			return []models.PresenceEvent{}, since, nil
+		case event := <-watcher.events:
+			if polled(event, since) {
+				events = append(events, event)
+			}
+		}
+
+		// Return with the first transition and any others already received
+		if len(events) == 0 {
+			continue
+		}
+		for drained := false; !drained; {
+			select {
+			case event := <-watcher.events:
+				if polled(event, since) {
+					events = append(events, event)
+				}
+			default:
+				drained = true
+			}
+		}
+		sortEvents(events)
+		return events, events[len(events)-1].Timestamp, nil
+	}
+}
+
+// transitionsSince reads the histories of userIDs in one pipeline and returns their
+// transitions after since, oldest first
+func (ps *PresenceService) transitionsSince(ctx context.Context, userIDs []string, since time.Time) ([]models.PresenceEvent, error) {
+	if ps.historyLength <= 0 {
+		return nil, nil
+	}
+
+	pipe := ps.redis.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, len(userIDs))
+	for i, userID := range userIDs {
+		cmds[i] = pipe.LRange(ctx, historyKeyPrefix+userID, 0, int64(ps.historyLength-1))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get presence history: %w", err)
+	}
+
+	var events []models.PresenceEvent
+	for i, userID := range userIDs {
+		// Newest first, so stop at the first one already seen
+		for _, entry := range cmds[i].Val() {
+			var transition models.PresenceTransition
+			if err := json.Unmarshal([]byte(entry), &transition); err != nil {
+				continue
+			}
+			if !transition.Timestamp.After(since) {
+				break
+			}
+			events = append(events, models.PresenceEvent{
+				UserID:    userID,
+				OldStatus: transition.OldStatus,
+				NewStatus: transition.NewStatus,
+				Device:    transition.Device,
+				Reason:    transition.Reason,
+				Timestamp: transition.Timestamp,
+			})
+		}
+	}
+	sortEvents(events)
+	return events, nil
+}
+
+// polled reports whether a watched event is a transition after since. Status
+// message updates change no status, so they are not.
+func polled(event models.PresenceEvent, since time.Time) bool {
+	return event.OldStatus != event.NewStatus && event.Timestamp.After(since)
+}
+
+func sortEvents(events []models.PresenceEvent) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+}
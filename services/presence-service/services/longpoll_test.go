@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPollPresenceTimesOutWithoutChange(t *testing.T) {
+	ps, _ := newTestService(t)
+	ctx := context.Background()
+	since := time.Now()
+
+	// A transition of a user nobody watches does not end the poll
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		ps.UpdatePresence(ctx, "bob", "online", "web", "", true, 0)
+	}()
+
+	const timeout = 150 * time.Millisecond
+	start := time.Now()
+	events, next, err := ps.PollPresence(ctx, []string{"alice"}, since, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < timeout {
+		t.Errorf("poll returned after %s, before its %s timeout", elapsed, timeout)
+	}
+	if events == nil || len(events) != 0 {
+		t.Errorf("events = %#v, want an empty batch", events)
+	}
+	if !next.Equal(since) {
+		t.Errorf("next token = %s, want since unchanged (%s)", next, since)
+	}
+
+	// The empty batch encodes as a list, not null
+	data, err := json.Marshal(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[]" {
+		t.Errorf("events encode as %s, want []", data)
+	}
+}
+
+func TestPollPresenceReturnsWatchedTransition(t *testing.T) {
+	ps, _ := newTestService(t)
+	ctx := context.Background()
+	since := time.Now()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		ps.UpdatePresence(ctx, "alice", "online", "web", "", true, 0)
+	}()
+
+	events, next, err := ps.PollPresence(ctx, []string{"alice"}, since, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].UserID != "alice" || events[0].NewStatus != "online" {
+		t.Fatalf("events = %+v, want alice going online", events)
+	}
+	if !next.Equal(events[0].Timestamp) {
+		t.Errorf("next token = %s, want the transition's time %s", next, events[0].Timestamp)
+	}
+
+	// Polling again from the new token finds nothing
+	events, _, err = ps.PollPresence(ctx, []string{"alice"}, next, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("second poll returned %+v, want nothing", events)
+	}
+}
+
+func TestPollPresenceReadsHistorySince(t *testing.T) {
+	ps, _ := newTestService(t)
+	ctx := context.Background()
+	since := time.Now()
+
+	if err := ps.UpdatePresence(ctx, "alice", "online", "web", "", true, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// A transition between two polls is returned at once
+	start := time.Now()
+	events, _, err := ps.PollPresence(ctx, []string{"alice"}, since, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("poll waited %s although history had a transition", elapsed)
+	}
+	if len(events) != 1 || events[0].NewStatus != "online" {
+		t.Fatalf("events = %+v, want alice going online", events)
+	}
+}
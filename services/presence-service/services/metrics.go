@@ -0,0 +1,58 @@
+package services
+
+import "chorus/pkg/metrics"
+
+// Presence metrics exposed on /metrics
+var (
+	heartbeatsRateLimitedTotal = metrics.Default.Counter(
+		"presence_heartbeats_rate_limited_total",
+		"Heartbeats rejected with 429 for arriving sooner than the minimum interval",
+	)
+	heartbeatsMutedTotal = metrics.Default.Counter(
+		"presence_heartbeats_muted_total",
+		"Heartbeats accepted but not written because their user is muted",
+	)
+	heartbeatMutesTotal = metrics.Default.Counter(
+		"presence_heartbeat_mutes_total",
+		"Users muted for exceeding the heartbeat rate limit too often",
+	)
+	heartbeatsSuppressedTotal = metrics.Default.Counter(
+		"presence_heartbeats_suppressed_total",
+		"Heartbeats refused with 423 because their user was forced offline",
+	)
+	forcedOfflineTotal = metrics.Default.Counter(
+		"presence_forced_offline_total",
+		"Users forced offline through DELETE /presence/users/{user_id}",
+	)
+	janitorScannedTotal = metrics.Default.Counter(
+		"presence_janitor_scanned_total",
+		"Online set members checked by the janitor",
+	)
+	janitorRemovedTotal = metrics.Default.Counter(
+		"presence_janitor_removed_total",
+		"Users the janitor removed from the online set after their presence expired",
+	)
+	janitorDuration = metrics.Default.Histogram(
+		"presence_janitor_duration_seconds",
+		"Time taken by one sweep of the online set",
+		[]float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30},
+	)
+	snapshotDuration = metrics.Default.Histogram(
+		"presence_snapshot_duration_seconds",
+		"Time taken to export and deliver a presence snapshot",
+		[]float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	)
+	snapshotBytes = metrics.Default.Gauge(
+		"presence_snapshot_bytes",
+		"Size of the latest presence snapshot",
+	)
+	snapshotUsers = metrics.Default.Gauge(
+		"presence_snapshot_users",
+		"Users in the latest presence snapshot",
+	)
+	snapshotsTotal = metrics.Default.Counter(
+		"presence_snapshots_total",
+		"Presence snapshots taken, by result",
+		"result",
+	)
+)
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+	"chorus/presence-service/models"
+)
+
+// Status overrides live under their own key, which expires with the override
+const overrideKeyPrefix = "status_override:"
+
+// Statuses a service may set as an override
+var overrideStatuses = map[string]bool{
+	"online": true,
+	"away":   true,
+	"busy":   true,
+}
+
+// ValidateStatusOverride checks a status override request. An empty status clears
+// the override and needs nothing else.
+func ValidateStatusOverride(req models.StatusOverrideRequest) error {
+	if req.Status == "" {
+		return nil
+	}
+	if !overrideStatuses[req.Status] {
+		return fmt.Errorf("status must be online, away or busy")
+	}
+	if utf8.RuneCountInString(req.StatusMessage) > models.MaxStatusMessageLength {
+		return fmt.Errorf("status_message must be at most %d characters", models.MaxStatusMessageLength)
+	}
+	if req.TTLSeconds < 0 {
+		return fmt.Errorf("ttl_seconds must not be negative")
+	}
+	return nil
+}
+
+// SetStatusOverride replaces the user's status override, which lapses after ttl
+// unless ttl is 0. While the user is present their status is updated and, if it
+// changes, an override event is published.
+func (ps *PresenceService) SetStatusOverride(ctx context.Context, userID string, req models.StatusOverrideRequest) (*models.StatusOverrideResponse, error) {
+	if err := ValidateStatusOverride(req); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	override := &models.StatusOverride{
+		Status:        req.Status,
+		StatusMessage: req.StatusMessage,
+		Source:        req.Source,
+		SetAt:         now,
+	}
+	if req.TTLSeconds > 0 {
+		expiresAt := now.Add(time.Duration(req.TTLSeconds) * time.Second)
+		override.ExpiresAt = &expiresAt
+	}
+
+	response, err := ps.updateOverride(ctx, userID, override, "", now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set status override: %w", err)
+	}
+	ps.logger.InfoContext(ctx, "Set status override", "user_id", userID, "status", req.Status, "source", req.Source)
+	return response, nil
+}
+
+// ClearStatusOverride removes the user's status override. With a source, only an
+// override set by that source is removed, so a caller undoing its own override
+// leaves a later one alone.
+func (ps *PresenceService) ClearStatusOverride(ctx context.Context, userID, source string) (*models.StatusOverrideResponse, error) {
+	response, err := ps.updateOverride(ctx, userID, nil, source, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to clear status override: %w", err)
+	}
+	if response.Cleared {
+		ps.logger.InfoContext(ctx, "Cleared status override", "user_id", userID, "source", source)
+	}
+	return response, nil
+}
+
+// updateOverride stores override, or removes the current one when it is nil and its
+// source matches, and updates the presence of a present user to match
+func (ps *PresenceService) updateOverride(ctx context.Context, userID string, override *models.StatusOverride, source string, now time.Time) (*models.StatusOverrideResponse, error) {
+	key := presenceKeyPrefix + userID
+	overrideKey := overrideKeyPrefix + userID
+
+	var data []byte
+	if override != nil {
+		var err error
+		if data, err = json.Marshal(override); err != nil {
+			return nil, fmt.Errorf("failed to marshal status override: %w", err)
+		}
+	}
+
+	var oldStatus string
+	var presence *models.UserPresence
+	var response models.StatusOverrideResponse
+	err := ps.updateSessions(ctx, key, func(tx *redis.Tx) error {
+		current, stored, err := ps.loadPresence(ctx, tx, key, now)
+		if err != nil {
+			return err
+		}
+		oldStatus = stored
+		presence = current
+		presence.UserID = userID
+
+		existing, err := ps.loadOverride(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		response = models.StatusOverrideResponse{UserID: userID, Override: override}
+		if override == nil {
+			if existing == nil || (source != "" && existing.Source != source) {
+				// Nothing of the caller's to clear
+				response.Override = existing
+				presence.Status = oldStatus
+				return nil
+			}
+			response.Cleared = true
+		}
+
+		applyOverride(presence, override, now)
+		dnd, err := ps.loadDND(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		applyDND(presence, dnd, now)
+
+		blob, err := json.Marshal(presence)
+		if err != nil {
+			return fmt.Errorf("failed to marshal presence data: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if override == nil {
+				pipe.Del(ctx, overrideKey)
+			} else {
+				// Without expires_at the override stays until cleared
+				var ttl time.Duration
+				if override.ExpiresAt != nil {
+					ttl = override.ExpiresAt.Sub(now)
+				}
+				pipe.Set(ctx, overrideKey, data, ttl)
+			}
+			if len(presence.Devices) > 0 {
+				pipe.Set(ctx, key, blob, redis.KeepTTL)
+				pipe.HSet(ctx, lastKnownKey, userID, blob)
+			}
+			return nil
+		})
+		return err
+	}, overrideKey)
+	if err != nil {
+		return nil, err
+	}
+	response.Status = presence.Status
+
+	if len(presence.Devices) > 0 && presence.Status != oldStatus {
+		ps.publishChange(ctx, models.PresenceEvent{
+			UserID:        userID,
+			OldStatus:     oldStatus,
+			NewStatus:     presence.Status,
+			Device:        presence.Device,
+			Reason:        models.PresenceChangeOverride,
+			Timestamp:     now,
+			StatusMessage: presence.StatusMessage,
+			StatusEmoji:   presence.StatusEmoji,
+		})
+	}
+	return &response, nil
+}
+
+// loadOverride returns the user's status override, or nil if they have none
+func (ps *PresenceService) loadOverride(ctx context.Context, client redis.Cmdable, userID string) (*models.StatusOverride, error) {
+	data, err := client.Get(ctx, overrideKeyPrefix+userID).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get status override: %w", err)
+	}
+
+	var override models.StatusOverride
+	if err := json.Unmarshal([]byte(data), &override); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal status override: %w", err)
+	}
+	return &override, nil
+}
+
+// applyOverride replaces the status of a present user with that of override until
+// it expires. Like do-not-disturb it leaves the sessions alone, and merging them
+// again drops it; do-not-disturb applies after it and wins.
+func applyOverride(presence *models.UserPresence, override *models.StatusOverride, now time.Time) {
+	if override == nil || presence.Status == "offline" {
+		return
+	}
+	if override.ExpiresAt != nil && !now.Before(*override.ExpiresAt) {
+		return
+	}
+	presence.Status = override.Status
+	presence.Override = override
+}
+
+// applyEffectiveStatuses applies status overrides and then do-not-disturb to users,
+// reading both for all of them at once. Settings that cannot be read leave the
+// status as it is.
+func (ps *PresenceService) applyEffectiveStatuses(ctx context.Context, users []models.UserPresence, now time.Time) {
+	if len(users) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, 2*len(users))
+	for _, user := range users {
+		keys = append(keys, overrideKeyPrefix+user.UserID, dndKeyPrefix+user.UserID)
+	}
+	values, err := ps.redis.MGet(ctx, keys...).Result()
+	if err != nil {
+		ps.logger.ErrorContext(ctx, "Error getting status overrides and do-not-disturb settings", "op", "MGET", "key", dndKeyPrefix, "users", len(users), "error", err)
+		return
+	}
+
+	for i := range users {
+		if data, ok := values[2*i].(string); ok {
+			var override models.StatusOverride
+			if err := json.Unmarshal([]byte(data), &override); err != nil {
+				ps.logger.ErrorContext(ctx, "Error unmarshaling status override", "key", overrideKeyPrefix, "user_id", users[i].UserID, "error", err)
+			} else {
+				applyOverride(&users[i], &override, now)
+			}
+		}
+		if data, ok := values[2*i+1].(string); ok {
+			var dnd models.DNDSchedule
+			if err := json.Unmarshal([]byte(data), &dnd); err != nil {
+				ps.logger.ErrorContext(ctx, "Error unmarshaling do-not-disturb", "key", dndKeyPrefix, "user_id", users[i].UserID, "error", err)
+			} else {
+				applyDND(&users[i], &dnd, now)
+			}
+		}
+	}
+}
@@ -4,30 +4,68 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"chorus/pkg/eventbus"
+	"chorus/pkg/logging"
 	"chorus/presence-service/models"
 )
 
 const (
 	presenceKeyPrefix = "presence:"
 	onlineSetKey     = "online_users"
+	lastKnownKey     = "last_known_presence" // hash of user ID to their last stored presence, never expires
+	
+	// Session ID used when a heartbeat names neither device_id nor device
+	defaultDeviceID = "default"
+	
+	// Attempts at a presence update that keeps racing with other heartbeats
+	maxSessionUpdateAttempts = 5
+	
+	// A page of online users scans at most this many times its limit
+	maxScanPerPage = 10
 )
 
 type PresenceService struct {
-	redis  *redis.Client
-	logger *log.Logger
-	ttl    time.Duration
+	redis           *redis.Client
+	logger          *logging.Logger
+	ttl             time.Duration // default lifetime of a session without heartbeats
+	minTTL          time.Duration // bounds of the TTL a heartbeat may request
+	maxTTL          time.Duration
+	maxRooms        int           // rooms a user can be in at once, 0 for no limit
+	recentRetention time.Duration // how long users stay in the recently active set
+	typingTTL       time.Duration // lifetime of a typing indicator without refresh
+	
+	historyLength     int           // status transitions kept per user
+	awayAfter         time.Duration // online sessions without interaction for this long count as away
+	heartbeatInterval time.Duration // shortest interval between heartbeats of a session, 0 for no limit
+	muteThreshold     int           // rate limited heartbeats within a minute that mute a user, 0 never mutes
+	muteDuration      time.Duration
+	suppressFor       time.Duration // how long heartbeats of a user forced offline are refused
+	sweepBatchSize    int           // online set members read at a time when sweeping
+	
+	health healthState // background work reported by Readiness
+	
+	events   *eventbus.Bus    // publishes and receives eventbus.PresenceEvents
+	watchers presenceWatchers // watches and long polls waiting for events
 }
 
-func NewPresenceService(redisClient *redis.Client, logger *log.Logger) *PresenceService {
+func NewPresenceService(redisClient *redis.Client, logger *logging.Logger) *PresenceService {
 	return &PresenceService{
-		redis:  redisClient,
-		logger: logger,
-		ttl:    120 * time.Second, // Default 2 minutes
+		redis:           redisClient,
+		logger:          logger,
+		events:          eventbus.New(redisClient, "presence-service", logger, eventbus.Metrics(), eventbus.Tracing()),
+		ttl:             120 * time.Second, // Default 2 minutes
+		minTTL:          30 * time.Second,
+		maxTTL:          15 * time.Minute,
+		recentRetention: 24 * time.Hour,
+		typingTTL:       6 * time.Second,
+		historyLength:   50,
+		awayAfter:       5 * time.Minute,
+		sweepBatchSize:  500,
+		suppressFor:     5 * time.Minute,
 	}
 }
 
@@ -35,52 +73,117 @@ func (ps *PresenceService) SetPresenceTTL(ttl time.Duration) {
 	ps.ttl = ttl
 }
 
-func (ps *PresenceService) UpdatePresence(ctx context.Context, userID, status, device string) error {
-	presence := models.UserPresence{
-		UserID:   userID,
-		Status:   status,
-		LastSeen: time.Now(),
-		Device:   device,
-	}
-	
-	data, err := json.Marshal(presence)
-	if err != nil {
-		return fmt.Errorf("failed to marshal presence data: %w", err)
-	}
+// UpdatePresence records a heartbeat from one of the user's sessions. deviceID
+// identifies the session and defaults to device; each session expires on its own,
+// ttlSeconds after this heartbeat or after the default TTL for 0, and the
+// user-level status is merged from the live ones.
+func (ps *PresenceService) UpdatePresence(ctx context.Context, userID, status, device, deviceID string, active bool, ttlSeconds int) error {
+	deviceID = sessionID(device, deviceID)
 	
 	key := presenceKeyPrefix + userID
+	now := time.Now()
 	
-	// Use pipeline for atomic operations
-	pipe := ps.redis.Pipeline()
-	
-	// Set presence data with TTL
-	pipe.Set(ctx, key, data, ps.ttl)
-	
-	// Add user to online set with TTL
-	pipe.SAdd(ctx, onlineSetKey, userID)
-	pipe.Expire(ctx, onlineSetKey, ps.ttl*2) // Keep online set alive longer
-	
-	_, err = pipe.Exec(ctx)
+	var oldStatus string
+	var presence models.UserPresence
+	var expiry time.Time
+	err := ps.updateSessions(ctx, key, func(tx *redis.Tx) error {
+		current, stored, err := ps.loadPresence(ctx, tx, key, now)
+		if err != nil {
+			return err
+		}
+		oldStatus = stored
+		
+		// The status message comes back with the user
+		message, err := ps.loadStatusMessage(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		override, err := ps.loadOverride(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		dnd, err := ps.loadDND(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		presence = ps.heartbeatPresence(userID, current, models.DevicePresence{
+			DeviceID: deviceID,
+			Device:   device,
+			Status:     status,
+			LastSeen:   now,
+			TTLSeconds: int(ps.requestedTTL(ttlSeconds).Seconds()),
+		}, active, message, override, dnd, now)
+		expiry = ps.presenceExpiry(presence)
+		
+		data, err := json.Marshal(presence)
+		if err != nil {
+			return fmt.Errorf("failed to marshal presence data: %w", err)
+		}
+		
+		// Use a transaction for atomic operations
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			// The key lives as long as the longest-lived session
+			pipe.Set(ctx, key, data, expiry.Sub(now))
+			
+			// Add user to online set with TTL
+			pipe.SAdd(ctx, onlineSetKey, userID)
+			pipe.Expire(ctx, onlineSetKey, ps.longestTTL()*2) // Keep online set alive longer
+			
+			// Keep the presence after the key expires, for last_seen
+			pipe.HSet(ctx, lastKnownKey, userID, data)
+			
+			// Order users by last_seen for recently active queries
+			pipe.ZAdd(ctx, recentKey, recentMember(userID, now))
+			return nil
+		})
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to update presence: %w", err)
 	}
 	
-	ps.logger.Printf("Updated presence for user %s: %s", userID, status)
+	if oldStatus != presence.Status {
+		ps.publishChange(ctx, models.PresenceEvent{
+			UserID:        userID,
+			OldStatus:     oldStatus,
+			NewStatus:     presence.Status,
+			Device:        device,
+			Reason:        models.PresenceChangeUpdate,
+			Timestamp:     now,
+			StatusMessage: presence.StatusMessage,
+			StatusEmoji:   presence.StatusEmoji,
+		})
+	}
+	
+	ps.touchRooms(ctx, userID, expiry)
+	
+	ps.logger.DebugContext(ctx, "Updated presence", "user_id", userID, "device", device, "device_id", deviceID, "status", status, "merged_status", presence.Status)
 	return nil
 }
 
-func (ps *PresenceService) GetPresence(ctx context.Context, userID string) (*models.UserPresence, error) {
+// GetPresence returns the user's presence. Unless raw is set, the status is that of
+// the user's status override while there is one, and dnd while their do-not-disturb
+// is active.
+func (ps *PresenceService) GetPresence(ctx context.Context, userID string, raw bool) (*models.UserPresence, error) {
 	key := presenceKeyPrefix + userID
 	
 	data, err := ps.redis.Get(ctx, key).Result()
 	if err != nil {
 		if err == redis.Nil {
-			// User not found or expired, return offline status
-			return &models.UserPresence{
-				UserID:   userID,
-				Status:   "offline",
-				LastSeen: time.Time{},
-			}, nil
+			// User not found or expired, return offline status with the last known last_seen
+			presence := models.UserPresence{
+				UserID: userID,
+				Status: "offline",
+			}
+			if last, err := ps.lastKnown(ctx, userID); err == nil && last != nil {
+				presence.LastSeen = last.LastSeen
+				presence.Device = last.Device
+			}
+			if message, err := ps.loadStatusMessage(ctx, ps.redis, userID); err == nil {
+				applyStatusMessage(&presence, message)
+				ps.mergePresence(&presence, time.Now())
+			}
+			return &presence, nil
 		}
 		return nil, fmt.Errorf("failed to get presence: %w", err)
 	}
@@ -90,15 +193,79 @@ func (ps *PresenceService) GetPresence(ctx context.Context, userID string) (*mod
 		return nil, fmt.Errorf("failed to unmarshal presence data: %w", err)
 	}
 	
-	// Check if the presence is still valid based on TTL
-	if time.Since(presence.LastSeen) > ps.ttl {
-		presence.Status = "offline"
+	// Drop sessions that are past the TTL and merge the rest
+	now := time.Now()
+	ps.mergePresence(&presence, now)
+	
+	if !raw {
+		override, err := ps.loadOverride(ctx, ps.redis, userID)
+		if err != nil {
+			return nil, err
+		}
+		applyOverride(&presence, override, now)
+		dnd, err := ps.loadDND(ctx, ps.redis, userID)
+		if err != nil {
+			return nil, err
+		}
+		applyDND(&presence, dnd, now)
 	}
 	
 	return &presence, nil
 }
 
-func (ps *PresenceService) GetOnlineUsers(ctx context.Context) ([]models.UserPresence, error) {
+// BulkGetPresence returns the presence of each user in userIDs, in the same order,
+// reading them all in one pipeline. Users without a live session are offline with
+// their last known last_seen, as in GetPresence.
+func (ps *PresenceService) BulkGetPresence(ctx context.Context, userIDs []string, raw bool) ([]models.UserPresence, error) {
+	if len(userIDs) == 0 {
+		return []models.UserPresence{}, nil
+	}
+	
+	pipe := ps.redis.Pipeline()
+	current := make([]*redis.StringCmd, len(userIDs))
+	messages := make([]*redis.StringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		current[i] = pipe.Get(ctx, presenceKeyPrefix+userID)
+		messages[i] = pipe.Get(ctx, statusMessageKeyPrefix+userID)
+	}
+	last := pipe.HMGet(ctx, lastKnownKey, userIDs...)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get presence data: %w", err)
+	}
+	
+	now := time.Now()
+	users := make([]models.UserPresence, len(userIDs))
+	for i, userID := range userIDs {
+		presence := models.UserPresence{UserID: userID, Status: "offline"}
+		if data, err := current[i].Result(); err == nil {
+			if err := json.Unmarshal([]byte(data), &presence); err != nil {
+				ps.logger.ErrorContext(ctx, "Error unmarshaling presence", "key", presenceKeyPrefix, "user_id", userID, "error", err)
+			}
+		} else {
+			var known models.UserPresence
+			if data, ok := last.Val()[i].(string); ok && json.Unmarshal([]byte(data), &known) == nil {
+				presence.LastSeen = known.LastSeen
+				presence.Device = known.Device
+			}
+			var message models.StatusMessage
+			if data, err := messages[i].Result(); err == nil && json.Unmarshal([]byte(data), &message) == nil {
+				applyStatusMessage(&presence, &message)
+			}
+		}
+		presence.UserID = userID
+		ps.mergePresence(&presence, now)
+		users[i] = presence
+	}
+	
+	if !raw {
+		ps.applyEffectiveStatuses(ctx, users, now)
+	}
+	return users, nil
+}
+
+// GetOnlineUsers returns every online user, with their status overrides and
+// do-not-disturb applied unless raw is set
+func (ps *PresenceService) GetOnlineUsers(ctx context.Context, raw bool) ([]models.UserPresence, error) {
 	// Get all user IDs from the online set
 	userIDs, err := ps.redis.SMembers(ctx, onlineSetKey).Result()
 	if err != nil {
@@ -109,96 +276,479 @@ func (ps *PresenceService) GetOnlineUsers(ctx context.Context) ([]models.UserPre
 		return []models.UserPresence{}, nil
 	}
 	
-	// Build keys for pipeline get
-	keys := make([]string, len(userIDs))
-	for i, userID := range userIDs {
-		keys[i] = presenceKeyPrefix + userID
+	return ps.hydrateOnlineUsers(ctx, userIDs, "", raw)
+}
+
+// ListOnlineUsers returns one page of online users, scanning the online set from
+// cursor. limit is passed to SSCAN as a hint, so a page may hold somewhat more or
+// fewer users; the scan is done when the returned cursor is 0. A non-empty status
+// keeps only users with that status, which is the raw merged one if raw is set and
+// otherwise dnd for users whose do-not-disturb is active.
+func (ps *PresenceService) ListOnlineUsers(ctx context.Context, cursor uint64, limit int, status string, raw bool) ([]models.UserPresence, uint64, error) {
+	users := []models.UserPresence{}
+	scanned := 0
+	for {
+		userIDs, next, err := ps.redis.SScan(ctx, onlineSetKey, cursor, "", int64(limit)).Result()
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan online users: %w", err)
+		}
+		cursor = next
+		scanned += len(userIDs)
+		
+		if len(userIDs) > 0 {
+			page, err := ps.hydrateOnlineUsers(ctx, userIDs, status, raw)
+			if err != nil {
+				return nil, 0, err
+			}
+			users = append(users, page...)
+		}
+		
+		// Keep scanning past expired and filtered out users, up to a bound per page
+		if cursor == 0 || len(users) >= limit || scanned >= maxScanPerPage*limit {
+			return users, cursor, nil
+		}
 	}
-	
+}
+
+// CountOnlineUsers returns the size of the online set without loading anyone's
+// presence. Users whose presence expired count until they are swept out of the set.
+func (ps *PresenceService) CountOnlineUsers(ctx context.Context) (int64, error) {
+	count, err := ps.redis.SCard(ctx, onlineSetKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count online users: %w", err)
+	}
+	return count, nil
+}
+
+// hydrateOnlineUsers loads the presence of userIDs in one pipeline and returns
+// those still online, optionally only with the given status. Unless raw is set,
+// status overrides and do-not-disturb apply before filtering. Users whose presence expired are removed
+// from the online set.
+func (ps *PresenceService) hydrateOnlineUsers(ctx context.Context, userIDs []string, status string, raw bool) ([]models.UserPresence, error) {
 	// Get all presence data in one pipeline
 	pipe := ps.redis.Pipeline()
-	cmds := make([]*redis.StringCmd, len(keys))
-	for i, key := range keys {
-		cmds[i] = pipe.Get(ctx, key)
+	cmds := make([]*redis.StringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		cmds[i] = pipe.Get(ctx, presenceKeyPrefix+userID)
 	}
 	
-	_, err = pipe.Exec(ctx)
+	_, err := pipe.Exec(ctx)
 	if err != nil && err != redis.Nil {
 		return nil, fmt.Errorf("failed to get presence data: %w", err)
 	}
 	
-	var onlineUsers []models.UserPresence
-	validUsers := make([]string, 0, len(userIDs))
+	now := time.Now()
+	onlineUsers := make([]models.UserPresence, 0, len(userIDs))
+	var expiredUsers []string
 	
 	for i, cmd := range cmds {
 		data, err := cmd.Result()
 		if err != nil {
 			if err == redis.Nil {
 				// User presence expired, remove from online set
+				expiredUsers = append(expiredUsers, userIDs[i])
 				continue
 			}
-			ps.logger.Printf("Error getting presence for user %s: %v", userIDs[i], err)
+			ps.logger.ErrorContext(ctx, "Error getting presence", "op", "GET", "key", presenceKeyPrefix, "user_id", userIDs[i], "error", err)
 			continue
 		}
 		
 		var presence models.UserPresence
 		if err := json.Unmarshal([]byte(data), &presence); err != nil {
-			ps.logger.Printf("Error unmarshaling presence for user %s: %v", userIDs[i], err)
+			ps.logger.ErrorContext(ctx, "Error unmarshaling presence", "key", presenceKeyPrefix, "user_id", userIDs[i], "error", err)
+			expiredUsers = append(expiredUsers, userIDs[i])
 			continue
 		}
 		
-		// Check if still online based on TTL
-		if time.Since(presence.LastSeen) <= ps.ttl {
-			onlineUsers = append(onlineUsers, presence)
-			validUsers = append(validUsers, presence.UserID)
+		// Still online while any session is within the TTL
+		ps.mergePresence(&presence, now)
+		if len(presence.Devices) == 0 {
+			expiredUsers = append(expiredUsers, userIDs[i])
+			continue
 		}
+		onlineUsers = append(onlineUsers, presence)
 	}
 	
 	// Clean up online set - remove expired users
-	if len(validUsers) != len(userIDs) {
-		expiredUsers := make([]string, 0)
-		for _, userID := range userIDs {
-			found := false
-			for _, validUser := range validUsers {
-				if userID == validUser {
-					found = true
-					break
+	if len(expiredUsers) > 0 {
+		ps.expireUsers(ctx, expiredUsers)
+	}
+	
+	if !raw {
+		ps.applyEffectiveStatuses(ctx, onlineUsers, now)
+	}
+	if status == "" {
+		return onlineUsers, nil
+	}
+	
+	filtered := onlineUsers[:0]
+	for _, presence := range onlineUsers {
+		if presence.Status == status {
+			filtered = append(filtered, presence)
+		}
+	}
+	return filtered, nil
+}
+
+// RemovePresence disconnects one session of the user, or all of them when deviceID
+// is empty
+func (ps *PresenceService) RemovePresence(ctx context.Context, userID, deviceID string) error {
+	key := presenceKeyPrefix + userID
+	now := time.Now()
+	
+	var oldStatus, device string
+	var presence models.UserPresence
+	err := ps.updateSessions(ctx, key, func(tx *redis.Tx) error {
+		current, stored, err := ps.loadPresence(ctx, tx, key, now)
+		if err != nil {
+			return err
+		}
+		oldStatus = stored
+		
+		presence = models.UserPresence{UserID: userID}
+		applyStatusMessage(&presence, statusMessageOf(current))
+		device = ""
+		for _, session := range current.Devices {
+			if deviceID == "" || session.DeviceID == deviceID {
+				device = session.Device
+				continue
+			}
+			presence.Devices = append(presence.Devices, session)
+		}
+		ps.mergePresence(&presence, now)
+		
+		override, err := ps.loadOverride(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		applyOverride(&presence, override, now)
+		dnd, err := ps.loadDND(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		applyDND(&presence, dnd, now)
+		
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if len(presence.Devices) == 0 {
+				pipe.Del(ctx, key)
+				pipe.SRem(ctx, onlineSetKey, userID)
+				if len(current.Devices) > 0 {
+					// Seen now, as the last known presence will say
+					pipe.ZAdd(ctx, recentKey, recentMember(userID, now))
 				}
+				return nil
 			}
-			if !found {
-				expiredUsers = append(expiredUsers, userID)
+			
+			data, err := json.Marshal(presence)
+			if err != nil {
+				return fmt.Errorf("failed to marshal presence data: %w", err)
 			}
+			// Expire with the longest-lived remaining session
+			pipe.Set(ctx, key, data, ps.presenceExpiry(presence).Sub(now))
+			pipe.HSet(ctx, lastKnownKey, userID, data)
+			pipe.ZAdd(ctx, recentKey, recentMember(userID, presence.LastSeen))
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove presence: %w", err)
+	}
+	
+	if len(presence.Devices) == 0 && oldStatus != "offline" {
+		ps.saveLastKnown(ctx, models.UserPresence{
+			UserID:   userID,
+			Status:   "offline",
+			LastSeen: now,
+			Device:   device,
+		})
+	}
+	if oldStatus != presence.Status {
+		ps.publishChange(ctx, models.PresenceEvent{
+			UserID:    userID,
+			OldStatus: oldStatus,
+			NewStatus: presence.Status,
+			Device:    device,
+			Reason:    models.PresenceChangeRemoved,
+			Timestamp: now,
+		})
+	}
+	
+	if deviceID != "" {
+		ps.logger.InfoContext(ctx, "Removed presence", "user_id", userID, "device_id", deviceID)
+	} else {
+		ps.logger.InfoContext(ctx, "Removed presence", "user_id", userID)
+	}
+	return nil
+}
+
+func (ps *PresenceService) IsOnline(ctx context.Context, userID string) (bool, error) {
+	presence, err := ps.GetPresence(ctx, userID, true)
+	if err != nil {
+		return false, err
+	}
+	
+	// Sessions past their TTL were dropped when merging
+	return presence.Status != "offline", nil
+}
+
+// sessionID identifies a heartbeat's session: its device_id, else its device
+func sessionID(device, deviceID string) string {
+	if deviceID != "" {
+		return deviceID
+	}
+	if device != "" {
+		return device
+	}
+	return defaultDeviceID
+}
+
+// updateSessions runs fn in a transaction watching the user's presence key and any
+// other keys given, retrying when a concurrent heartbeat changed them first
+func (ps *PresenceService) updateSessions(ctx context.Context, key string, fn func(tx *redis.Tx) error, keys ...string) error {
+	keys = append([]string{key}, keys...)
+	for attempt := 0; attempt < maxSessionUpdateAttempts; attempt++ {
+		err := ps.redis.Watch(ctx, fn, keys...)
+		if err != redis.TxFailedErr {
+			return err
 		}
-		
-		if len(expiredUsers) > 0 {
-			ps.redis.SRem(ctx, onlineSetKey, expiredUsers)
+	}
+	return fmt.Errorf("presence of %s changed concurrently %d times", strings.TrimPrefix(key, presenceKeyPrefix), maxSessionUpdateAttempts)
+}
+
+// loadPresence reads the stored presence and merges its live sessions. It also
+// returns the stored status, which is the last one announced: a session that timed
+// out may have changed the merged status since. A missing key yields an offline
+// presence without sessions.
+func (ps *PresenceService) loadPresence(ctx context.Context, tx *redis.Tx, key string, now time.Time) (*models.UserPresence, string, error) {
+	data, err := tx.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return &models.UserPresence{Status: "offline"}, "offline", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	
+	presence, stored := ps.decodePresence(data, now)
+	return presence, stored, nil
+}
+
+// decodePresence parses a stored presence and merges its live sessions, returning
+// it along with the stored status. Unreadable data counts as offline and is
+// overwritten by the caller.
+func (ps *PresenceService) decodePresence(data string, now time.Time) (*models.UserPresence, string) {
+	var presence models.UserPresence
+	if err := json.Unmarshal([]byte(data), &presence); err != nil {
+		return &models.UserPresence{Status: "offline"}, "offline"
+	}
+	
+	stored := presence.Status
+	ps.mergePresence(&presence, now)
+	return &presence, stored
+}
+
+// heartbeatPresence adds or replaces session in the user's current sessions and
+// returns the merged presence with their status message, status override and
+// do-not-disturb applied.
+// An active heartbeat marks the session as interacted with now; otherwise it keeps
+// its last interaction, and a new session counts as interacted with when it starts.
+func (ps *PresenceService) heartbeatPresence(userID string, current *models.UserPresence, session models.DevicePresence, active bool, message *models.StatusMessage, override *models.StatusOverride, dnd *models.DNDSchedule, now time.Time) models.UserPresence {
+	presence := models.UserPresence{UserID: userID}
+	session.LastActive = now
+	for _, existing := range current.Devices {
+		if existing.DeviceID != session.DeviceID {
+			presence.Devices = append(presence.Devices, existing)
+		} else if !active && !existing.LastActive.IsZero() {
+			session.LastActive = existing.LastActive
 		}
 	}
+	presence.Devices = append(presence.Devices, session)
 	
-	return onlineUsers, nil
+	applyStatusMessage(&presence, message)
+	ps.mergePresence(&presence, now)
+	
+	// Store and announce the effective status
+	applyOverride(&presence, override, now)
+	applyDND(&presence, dnd, now)
+	return presence
 }
 
-func (ps *PresenceService) RemovePresence(ctx context.Context, userID string) error {
-	key := presenceKeyPrefix + userID
+// mergePresence drops sessions seen longer than their TTL ago and derives the
+// user-level status, device and last_seen: the status is the most present one among
+// live sessions (online > away > busy > offline), ties going to the latest
+// heartbeat. An online session without interaction for the away threshold counts as
+// away, while still reporting online itself.
+func (ps *PresenceService) mergePresence(presence *models.UserPresence, now time.Time) {
+	// Presence stored before sessions were tracked is a single session
+	if len(presence.Devices) == 0 && !presence.LastSeen.IsZero() && presence.Status != "offline" {
+		deviceID := presence.Device
+		if deviceID == "" {
+			deviceID = defaultDeviceID
+		}
+		presence.Devices = []models.DevicePresence{{
+			DeviceID: deviceID,
+			Device:   presence.Device,
+			Status:   presence.Status,
+			LastSeen: presence.LastSeen,
+		}}
+	}
 	
+	live := make([]models.DevicePresence, 0, len(presence.Devices))
+	var best *models.DevicePresence
+	for _, session := range presence.Devices {
+		if session.LastSeen.After(presence.LastSeen) {
+			presence.LastSeen = session.LastSeen
+		}
+		if now.Sub(session.LastSeen) > ps.sessionTTL(session) {
+			continue
+		}
+		live = append(live, session)
+	}
+	var bestStatus string
+	for i := range live {
+		session := &live[i]
+		status := ps.sessionStatus(session, now)
+		if best == nil || statusRank(status) > statusRank(bestStatus) ||
+			(statusRank(status) == statusRank(bestStatus) && session.LastSeen.After(best.LastSeen)) {
+			best, bestStatus = session, status
+		}
+	}
+	
+	presence.Devices = live
+	presence.Override = nil // applied again by readers that want it
+	if presence.StatusExpiresAt != nil && !now.Before(*presence.StatusExpiresAt) {
+		applyStatusMessage(presence, nil)
+	}
+	if best == nil {
+		presence.Status = "offline"
+		return
+	}
+	presence.Status = bestStatus
+	presence.Device = best.Device
+}
+
+// sessionStatus is the status a live session contributes: its reported status, or
+// away when it reports online without interaction for awayAfter. Sessions stored
+// before interaction was tracked are taken as reported.
+func (ps *PresenceService) sessionStatus(session *models.DevicePresence, now time.Time) string {
+	if ps.awayAfter > 0 && session.Status == "online" && !session.LastActive.IsZero() && now.Sub(session.LastActive) >= ps.awayAfter {
+		return "away"
+	}
+	return session.Status
+}
+
+// SetAwayAfter sets how long an online session may go without an interactive
+// heartbeat before it counts as away; 0 disables the transition
+func (ps *PresenceService) SetAwayAfter(awayAfter time.Duration) {
+	ps.awayAfter = awayAfter
+}
+
+// statusRank orders statuses by how present they are. Unknown statuses rank just
+// above offline.
+func statusRank(status string) int {
+	switch status {
+	case "online":
+		return 4
+	case "away":
+		return 3
+	case "busy":
+		return 2
+	case "offline":
+		return 0
+	default:
+		return 1
+	}
+}
+
+// expireUsers removes users whose presence expired from the online set, marks their
+// last known presence offline and publishes an offline event for each one. Only the
+// caller whose SREM removed a user publishes, so concurrent cleanups in several
+// instances do not announce the same user twice. It returns how many users this
+// call removed.
+func (ps *PresenceService) expireUsers(ctx context.Context, userIDs []string) int {
 	pipe := ps.redis.Pipeline()
-	pipe.Del(ctx, key)
-	pipe.SRem(ctx, onlineSetKey, userID)
+	removed := make([]*redis.IntCmd, len(userIDs))
+	for i, userID := range userIDs {
+		removed[i] = pipe.SRem(ctx, onlineSetKey, userID)
+	}
+	stored := pipe.HMGet(ctx, lastKnownKey, userIDs...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		ps.logger.ErrorContext(ctx, "Error removing expired users from online set", "op", "SREM", "key", onlineSetKey, "users", len(userIDs), "error", err)
+		return 0
+	}
 	
-	_, err := pipe.Exec(ctx)
+	now := time.Now()
+	count := 0
+	for i, userID := range userIDs {
+		if removed[i].Val() == 0 {
+			continue
+		}
+		count++
+		
+		var last models.UserPresence
+		if data, ok := stored.Val()[i].(string); ok {
+			json.Unmarshal([]byte(data), &last)
+		}
+		if last.Status == "offline" {
+			continue
+		}
+		
+		ps.publishChange(ctx, models.PresenceEvent{
+			UserID:    userID,
+			OldStatus: last.Status,
+			NewStatus: "offline",
+			Device:    last.Device,
+			Reason:    models.PresenceChangeExpired,
+			Timestamp: now,
+		})
+		
+		if last.UserID != "" {
+			last.Status = "offline"
+			ps.saveLastKnown(ctx, last)
+		}
+	}
+	return count
+}
+
+// saveLastKnown stores presence as the user's last known presence
+func (ps *PresenceService) saveLastKnown(ctx context.Context, presence models.UserPresence) {
+	data, err := json.Marshal(presence)
 	if err != nil {
-		return fmt.Errorf("failed to remove presence: %w", err)
+		return
+	}
+	if err := ps.redis.HSet(ctx, lastKnownKey, presence.UserID, data).Err(); err != nil {
+		ps.logger.ErrorContext(ctx, "Error saving last known presence", "op", "HSET", "key", lastKnownKey, "user_id", presence.UserID, "error", err)
 	}
-	
-	ps.logger.Printf("Removed presence for user %s", userID)
-	return nil
 }
 
-func (ps *PresenceService) IsOnline(ctx context.Context, userID string) (bool, error) {
-	presence, err := ps.GetPresence(ctx, userID)
+// publishChange records a status change in the user's history and announces it on
+// eventbus.PresenceEvents. Failures are logged; the presence update itself has
+// already succeeded.
+func (ps *PresenceService) publishChange(ctx context.Context, event models.PresenceEvent) {
+	pipe := ps.redis.Pipeline()
+	ps.recordTransition(ctx, pipe, event)
+	if pipe.Len() > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			ps.logger.ErrorContext(ctx, "Error recording presence transition", "op", "LPUSH", "key", historyKeyPrefix+event.UserID, "user_id", event.UserID, "error", err)
+		}
+	}
+	ps.publishEvent(ctx, toBusEvent(event))
+}
+
+// lastKnown returns the presence stored by the user's most recent heartbeat, or nil
+// if they never sent one
+func (ps *PresenceService) lastKnown(ctx context.Context, userID string) (*models.UserPresence, error) {
+	data, err := ps.redis.HGet(ctx, lastKnownKey, userID).Result()
 	if err != nil {
-		return false, err
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get last known presence: %w", err)
 	}
 	
-	return presence.Status != "offline" && time.Since(presence.LastSeen) <= ps.ttl, nil
+	var presence models.UserPresence
+	if err := json.Unmarshal([]byte(data), &presence); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal last known presence: %w", err)
+	}
+	return &presence, nil
 }
\ No newline at end of file
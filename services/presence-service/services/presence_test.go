@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"chorus/pkg/eventbus"
+	"chorus/pkg/logging"
+	"chorus/presence-service/models"
+)
+
+// newTestService returns a presence service on an in-memory Redis, receiving
+// presence events until the test ends
+func newTestService(t testing.TB) (*PresenceService, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	logger := &logging.Logger{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	ps := NewPresenceService(client, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ps.RunEvents(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	channel := eventbus.PresenceEvents.Name
+	for deadline := time.Now().Add(5 * time.Second); mr.PubSubNumSub(channel)[channel] == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("presence events were not subscribed to")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return ps, mr
+}
+
+func TestSessionStatusAwayThreshold(t *testing.T) {
+	const awayAfter = 5 * time.Minute
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		status    string
+		idle      time.Duration // since the last interactive heartbeat
+		untracked bool          // stored before interaction was tracked
+		awayAfter time.Duration
+		want      string
+	}{
+		{name: "just active", status: "online", idle: 0, awayAfter: awayAfter, want: "online"},
+		{name: "a second short", status: "online", idle: awayAfter - time.Second, awayAfter: awayAfter, want: "online"},
+		{name: "a nanosecond short", status: "online", idle: awayAfter - time.Nanosecond, awayAfter: awayAfter, want: "online"},
+		{name: "at the threshold", status: "online", idle: awayAfter, awayAfter: awayAfter, want: "away"},
+		{name: "past the threshold", status: "online", idle: awayAfter + time.Second, awayAfter: awayAfter, want: "away"},
+		{name: "busy stays busy", status: "busy", idle: time.Hour, awayAfter: awayAfter, want: "busy"},
+		{name: "untracked interaction", status: "online", untracked: true, awayAfter: awayAfter, want: "online"},
+		{name: "transition disabled", status: "online", idle: time.Hour, awayAfter: 0, want: "online"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := &PresenceService{awayAfter: tt.awayAfter}
+			session := models.DevicePresence{Status: tt.status, LastSeen: now}
+			if !tt.untracked {
+				session.LastActive = now.Add(-tt.idle)
+			}
+			if got := ps.sessionStatus(&session, now); got != tt.want {
+				t.Errorf("sessionStatus = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergePresenceAwayAtThreshold(t *testing.T) {
+	ps, _ := newTestService(t)
+	now := time.Now()
+
+	presence := models.UserPresence{
+		UserID: "alice",
+		Devices: []models.DevicePresence{
+			{DeviceID: "web", Status: "online", LastSeen: now, LastActive: now.Add(-ps.awayAfter)},
+		},
+	}
+	ps.mergePresence(&presence, now)
+	if presence.Status != "away" {
+		t.Errorf("merged status = %s, want away", presence.Status)
+	}
+	if presence.Devices[0].Status != "online" {
+		t.Errorf("session status = %s, want it still reported online", presence.Devices[0].Status)
+	}
+
+	// Any session with a recent interaction keeps the user online
+	presence.Devices = append(presence.Devices, models.DevicePresence{
+		DeviceID: "mobile", Status: "online", LastSeen: now.Add(-time.Second), LastActive: now.Add(-ps.awayAfter + time.Second),
+	})
+	ps.mergePresence(&presence, now)
+	if presence.Status != "online" {
+		t.Errorf("merged status with an active session = %s, want online", presence.Status)
+	}
+}
+
+func TestPassiveHeartbeatsGoAway(t *testing.T) {
+	ps, mr := newTestService(t)
+	ctx := context.Background()
+
+	// A session whose last interaction is exactly the threshold ago
+	now := time.Now()
+	stored, err := json.Marshal(models.UserPresence{
+		UserID:   "alice",
+		Status:   "online",
+		LastSeen: now,
+		Devices: []models.DevicePresence{
+			{DeviceID: "web", Device: "web", Status: "online", LastSeen: now, LastActive: now.Add(-ps.awayAfter)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.Set(presenceKeyPrefix+"alice", string(stored))
+
+	// A background tab keeps reporting online without interaction
+	if err := ps.UpdatePresence(ctx, "alice", "online", "web", "web", false, 0); err != nil {
+		t.Fatal(err)
+	}
+	presence, err := ps.GetPresence(ctx, "alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if presence.Status != "away" {
+		t.Fatalf("status after a passive heartbeat = %s, want away", presence.Status)
+	}
+
+	// An interactive one brings the user back
+	if err := ps.UpdatePresence(ctx, "alice", "online", "web", "web", true, 0); err != nil {
+		t.Fatal(err)
+	}
+	if presence, err = ps.GetPresence(ctx, "alice", false); err != nil {
+		t.Fatal(err)
+	}
+	if presence.Status != "online" {
+		t.Fatalf("status after an active heartbeat = %s, want online", presence.Status)
+	}
+}
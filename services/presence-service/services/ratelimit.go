@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Set per session while its next heartbeat would come too early
+	heartbeatLimitKeyPrefix = "heartbeat_limit:"
+	
+	// Counter per user of rate limited heartbeats within heartbeatViolationWindow
+	heartbeatViolationsKeyPrefix = "heartbeat_violations:"
+	
+	// Set per user while their heartbeats are dropped
+	heartbeatMutedKeyPrefix = "heartbeat_muted:"
+	
+	heartbeatViolationWindow = time.Minute
+	
+	// Longest device and device_id accepted, in characters
+	MaxDeviceLength = 64
+)
+
+// Statuses a heartbeat may report
+var heartbeatStatuses = map[string]bool{
+	"online":  true,
+	"away":    true,
+	"busy":    true,
+	"offline": true,
+}
+
+// HeartbeatDecision says what to do with a heartbeat
+type HeartbeatDecision int
+
+const (
+	HeartbeatAllowed HeartbeatDecision = iota
+	HeartbeatRateLimited // reject with 429
+	HeartbeatMuted       // accept without writing it
+)
+
+// ValidateHeartbeat checks the status and the length of the device fields
+func ValidateHeartbeat(status, device, deviceID string) error {
+	if !heartbeatStatuses[status] {
+		return fmt.Errorf("status must be one of online, away, busy or offline")
+	}
+	if utf8.RuneCountInString(device) > MaxDeviceLength {
+		return fmt.Errorf("device must be at most %d characters", MaxDeviceLength)
+	}
+	if utf8.RuneCountInString(deviceID) > MaxDeviceLength {
+		return fmt.Errorf("device_id must be at most %d characters", MaxDeviceLength)
+	}
+	return nil
+}
+
+// SetHeartbeatLimits sets the shortest interval between heartbeats of one session
+// (0 disables the limit), how many rate limited heartbeats within a minute get a
+// user muted (0 never mutes), and for how long
+func (ps *PresenceService) SetHeartbeatLimits(interval time.Duration, muteThreshold int, muteDuration time.Duration) {
+	ps.heartbeatInterval = interval
+	ps.muteThreshold = muteThreshold
+	ps.muteDuration = muteDuration
+}
+
+// CheckHeartbeat applies the heartbeat rate limit to a session of the user. A
+// rate limited heartbeat comes with how long to wait before the next one. Users
+// that keep hitting the limit are muted for a while: their heartbeats are dropped
+// without an error so that a misbehaving client does not retry faster. When Redis
+// cannot be reached, heartbeats are allowed.
+func (ps *PresenceService) CheckHeartbeat(ctx context.Context, userID, device, deviceID string) (HeartbeatDecision, time.Duration) {
+	if ps.heartbeatInterval <= 0 {
+		return HeartbeatAllowed, 0
+	}
+	
+	limitKey := heartbeatLimitKeyPrefix + userID + ":" + sessionID(device, deviceID)
+	pipe := ps.redis.Pipeline()
+	muted := pipe.Exists(ctx, heartbeatMutedKeyPrefix+userID)
+	first := pipe.SetNX(ctx, limitKey, 1, ps.heartbeatInterval)
+	if _, err := pipe.Exec(ctx); err != nil {
+		ps.logger.ErrorContext(ctx, "Error checking heartbeat rate limit", "op", "SETNX", "key", heartbeatLimitKeyPrefix, "user_id", userID, "error", err)
+		return HeartbeatAllowed, 0
+	}
+	
+	if muted.Val() > 0 {
+		heartbeatsMutedTotal.Inc()
+		return HeartbeatMuted, 0
+	}
+	if first.Val() {
+		return HeartbeatAllowed, 0
+	}
+	
+	heartbeatsRateLimitedTotal.Inc()
+	pipe = ps.redis.Pipeline()
+	retryAfter := pipe.PTTL(ctx, limitKey)
+	violations := pipe.Incr(ctx, heartbeatViolationsKeyPrefix+userID)
+	pipe.ExpireNX(ctx, heartbeatViolationsKeyPrefix+userID, heartbeatViolationWindow)
+	if _, err := pipe.Exec(ctx); err != nil {
+		ps.logger.ErrorContext(ctx, "Error recording heartbeat rate limit violation", "op", "INCR", "key", heartbeatViolationsKeyPrefix, "user_id", userID, "error", err)
+	}
+	
+	if ps.muteThreshold > 0 && violations.Val() == int64(ps.muteThreshold) {
+		if err := ps.redis.Set(ctx, heartbeatMutedKeyPrefix+userID, 1, ps.muteDuration).Err(); err != nil {
+			ps.logger.ErrorContext(ctx, "Error muting user", "op", "SET", "key", heartbeatMutedKeyPrefix, "user_id", userID, "error", err)
+		} else {
+			heartbeatMutesTotal.Inc()
+			ps.logger.WarnContext(ctx, "Muted heartbeats of user",
+				"user_id", userID, "device", device, "device_id", deviceID,
+				"duration", ps.muteDuration.String(), "violations", violations.Val(), "window", heartbeatViolationWindow.String())
+		}
+	}
+	
+	wait := retryAfter.Val()
+	if wait <= 0 {
+		wait = ps.heartbeatInterval
+	}
+	return HeartbeatRateLimited, wait
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"chorus/presence-service/models"
+)
+
+// Sorted set of user ID scored by last_seen, in unix seconds with millisecond precision
+const recentKey = "recent_presence"
+
+// SetRecentRetention sets how long users stay in the recently active set after
+// they were last seen
+func (ps *PresenceService) SetRecentRetention(retention time.Duration) {
+	ps.recentRetention = retention
+}
+
+// RecentRetention returns how far back GetRecentUsers can look
+func (ps *PresenceService) RecentRetention() time.Duration {
+	return ps.recentRetention
+}
+
+// GetRecentUsers returns users last seen after since, most recent first, skipping
+// offset users and returning at most limit. Only that page is hydrated; users who
+// went offline since are returned from their last known presence. The second result
+// is the number of users seen after since.
+func (ps *PresenceService) GetRecentUsers(ctx context.Context, since time.Time, offset, limit int) ([]models.UserPresence, int64, error) {
+	min := formatScore(since)
+
+	pipe := ps.redis.Pipeline()
+	page := pipe.ZRevRangeByScore(ctx, recentKey, &redis.ZRangeBy{
+		Min:    "(" + min,
+		Max:    "+inf",
+		Offset: int64(offset),
+		Count:  int64(limit),
+	})
+	total := pipe.ZCount(ctx, recentKey, "("+min, "+inf")
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, 0, fmt.Errorf("failed to get recent users: %w", err)
+	}
+
+	users, err := ps.hydrateRecentUsers(ctx, page.Val())
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total.Val(), nil
+}
+
+// hydrateRecentUsers loads the presence of userIDs in one pipeline, falling back to
+// the last known presence of those who went offline
+func (ps *PresenceService) hydrateRecentUsers(ctx context.Context, userIDs []string) ([]models.UserPresence, error) {
+	if len(userIDs) == 0 {
+		return []models.UserPresence{}, nil
+	}
+
+	pipe := ps.redis.Pipeline()
+	current := make([]*redis.StringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		current[i] = pipe.Get(ctx, presenceKeyPrefix+userID)
+	}
+	last := pipe.HMGet(ctx, lastKnownKey, userIDs...)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get presence data: %w", err)
+	}
+
+	now := time.Now()
+	users := make([]models.UserPresence, 0, len(userIDs))
+	for i, userID := range userIDs {
+		var presence models.UserPresence
+		if data, err := current[i].Result(); err == nil && json.Unmarshal([]byte(data), &presence) == nil {
+			ps.mergePresence(&presence, now)
+		} else if data, ok := last.Val()[i].(string); ok && json.Unmarshal([]byte(data), &presence) == nil {
+			presence.Status = "offline"
+			presence.Devices = nil
+			ps.mergePresence(&presence, now)
+		} else {
+			presence = models.UserPresence{Status: "offline"}
+		}
+		presence.UserID = userID
+		users = append(users, presence)
+	}
+
+	ps.applyEffectiveStatuses(ctx, users, now)
+	return users, nil
+}
+
+// pruneRecent drops users last seen before the retention window from the recently
+// active set
+func (ps *PresenceService) pruneRecent(ctx context.Context, now time.Time) {
+	cutoff := formatScore(now.Add(-ps.recentRetention))
+	if err := ps.redis.ZRemRangeByScore(ctx, recentKey, "-inf", "("+cutoff).Err(); err != nil {
+		ps.logger.ErrorContext(ctx, "Error pruning recently active users", "op", "ZREMRANGEBYSCORE", "key", recentKey, "error", err)
+	}
+}
+
+func recentMember(userID string, lastSeen time.Time) redis.Z {
+	return redis.Z{Score: float64(lastSeen.UnixMilli()) / 1000, Member: userID}
+}
+
+func formatScore(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixMilli())/1000, 'f', 3, 64)
+}
@@ -2,28 +2,80 @@ package services
 
 import (
 	"context"
-	"log"
+	"errors"
 
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"chorus/pkg/logging"
+	"chorus/pkg/tracing"
 	"chorus/presence-service/config"
 )
 
-func NewRedisClient(cfg *config.Config) *redis.Client {
+func NewRedisClient(cfg *config.Config, logger *logging.Logger) *redis.Client {
 	opt, err := redis.ParseURL(cfg.RedisURL)
 	if err != nil {
-		log.Fatalf("Failed to parse Redis URL: %v", err)
+		logger.Fatal("Failed to parse Redis URL", "error", err)
 	}
 	
 	opt.DB = cfg.RedisDB
 	
 	client := redis.NewClient(opt)
+	client.AddHook(redisTracing{})
 	
 	// Test connection
 	ctx := context.Background()
 	if err := client.Ping(ctx).Err(); err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		logger.Fatal("Failed to connect to Redis", "op", "PING", "error", err)
 	}
 	
-	log.Println("Connected to Redis successfully")
+	logger.Info("Connected to Redis successfully", "db", opt.DB)
 	return client
+}
+
+// redisTracing records the Redis commands of traced requests as client spans.
+// Commands of background work without a span are not traced.
+type redisTracing struct{}
+
+func (redisTracing) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (redisTracing) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			return next(ctx, cmd)
+		}
+		ctx, span := tracing.Start(ctx, "redis "+cmd.Name(), trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("db.operation.name", cmd.Name()),
+		))
+		defer span.End()
+
+		err := next(ctx, cmd)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			tracing.Fail(span, err)
+		}
+		return err
+	}
+}
+
+func (redisTracing) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			return next(ctx, cmds)
+		}
+		ctx, span := tracing.Start(ctx, "redis pipeline", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.Int("db.operation.batch.size", len(cmds)),
+		))
+		defer span.End()
+
+		err := next(ctx, cmds)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			tracing.Fail(span, err)
+		}
+		return err
+	}
 }
\ No newline at end of file
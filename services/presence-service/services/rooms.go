@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"chorus/presence-service/models"
+)
+
+const (
+	// Sorted set per room of user ID scored by when their presence expires (unix
+	// seconds), as of their last heartbeat
+	roomMembersKeyPrefix = "room_members:"
+
+	// Set per user of the rooms they joined, so heartbeats can refresh them
+	userRoomsKeyPrefix = "user_rooms:"
+
+	// How long a user's joined rooms are remembered without heartbeats
+	userRoomsTTL = 24 * time.Hour
+)
+
+var (
+	ErrInvalidRoomID = errors.New("room_id must be 1-128 letters, digits, '.', '_', ':' or '-'")
+	ErrTooManyRooms  = errors.New("user has joined the maximum number of rooms")
+
+	roomIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+)
+
+// SetMaxRoomsPerUser limits how many rooms a user can be in at once; 0 means no limit
+func (ps *PresenceService) SetMaxRoomsPerUser(max int) {
+	ps.maxRooms = max
+}
+
+// JoinRoom adds the user to a room and returns the room's occupancy. Heartbeats keep
+// the membership alive; members that stop heartbeating age out with their presence.
+func (ps *PresenceService) JoinRoom(ctx context.Context, userID, roomID string) (int64, error) {
+	if !roomIDPattern.MatchString(roomID) {
+		return 0, ErrInvalidRoomID
+	}
+
+	roomsKey := userRoomsKeyPrefix + userID
+	if ps.maxRooms > 0 {
+		pipe := ps.redis.Pipeline()
+		joined := pipe.SIsMember(ctx, roomsKey, roomID)
+		count := pipe.SCard(ctx, roomsKey)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return 0, fmt.Errorf("failed to get joined rooms: %w", err)
+		}
+		if !joined.Val() && count.Val() >= int64(ps.maxRooms) {
+			return 0, ErrTooManyRooms
+		}
+	}
+
+	now := time.Now()
+	membersKey := roomMembersKeyPrefix + roomID
+
+	pipe := ps.redis.TxPipeline()
+	// The next heartbeat extends this to the user's own TTL
+	pipe.ZAdd(ctx, membersKey, redis.Z{Score: float64(now.Add(ps.ttl).Unix()), Member: userID})
+	pipe.Expire(ctx, membersKey, userRoomsTTL)
+	pipe.SAdd(ctx, roomsKey, roomID)
+	pipe.Expire(ctx, roomsKey, userRoomsTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to join room: %w", err)
+	}
+
+	ps.logger.InfoContext(ctx, "User joined room", "user_id", userID, "room_id", roomID)
+	return ps.roomOccupancy(ctx, roomID, now)
+}
+
+// LeaveRoom removes the user from a room and returns the room's occupancy
+func (ps *PresenceService) LeaveRoom(ctx context.Context, userID, roomID string) (int64, error) {
+	if !roomIDPattern.MatchString(roomID) {
+		return 0, ErrInvalidRoomID
+	}
+
+	pipe := ps.redis.TxPipeline()
+	pipe.ZRem(ctx, roomMembersKeyPrefix+roomID, userID)
+	pipe.SRem(ctx, userRoomsKeyPrefix+userID, roomID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to leave room: %w", err)
+	}
+
+	ps.logger.InfoContext(ctx, "User left room", "user_id", userID, "room_id", roomID)
+	return ps.roomOccupancy(ctx, roomID, time.Now())
+}
+
+// GetRoomOnlineUsers returns the members of a room whose presence has not expired
+// and who still have a live session
+func (ps *PresenceService) GetRoomOnlineUsers(ctx context.Context, roomID string) ([]models.UserPresence, error) {
+	if !roomIDPattern.MatchString(roomID) {
+		return nil, ErrInvalidRoomID
+	}
+
+	now := time.Now()
+	if err := ps.pruneRoom(ctx, roomID, now); err != nil {
+		return nil, err
+	}
+
+	userIDs, err := ps.redis.ZRange(ctx, roomMembersKeyPrefix+roomID, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room members: %w", err)
+	}
+	if len(userIDs) == 0 {
+		return []models.UserPresence{}, nil
+	}
+
+	pipe := ps.redis.Pipeline()
+	cmds := make([]*redis.StringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		cmds[i] = pipe.Get(ctx, presenceKeyPrefix+userID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get presence data: %w", err)
+	}
+
+	users := make([]models.UserPresence, 0, len(userIDs))
+	for i, cmd := range cmds {
+		data, err := cmd.Result()
+		if err != nil {
+			continue
+		}
+
+		var presence models.UserPresence
+		if err := json.Unmarshal([]byte(data), &presence); err != nil {
+			ps.logger.ErrorContext(ctx, "Error unmarshaling presence", "key", presenceKeyPrefix, "user_id", userIDs[i], "error", err)
+			continue
+		}
+
+		ps.mergePresence(&presence, now)
+		if len(presence.Devices) > 0 {
+			users = append(users, presence)
+		}
+	}
+
+	ps.applyEffectiveStatuses(ctx, users, now)
+	return users, nil
+}
+
+// touchRooms refreshes the user's membership in every room they joined until their
+// presence expires
+func (ps *PresenceService) touchRooms(ctx context.Context, userID string, expiry time.Time) {
+	roomsKey := userRoomsKeyPrefix + userID
+
+	roomIDs, err := ps.redis.SMembers(ctx, roomsKey).Result()
+	if err != nil {
+		ps.logger.ErrorContext(ctx, "Error getting rooms of user", "op", "SMEMBERS", "key", userRoomsKeyPrefix, "user_id", userID, "error", err)
+		return
+	}
+	if len(roomIDs) == 0 {
+		return
+	}
+
+	pipe := ps.redis.Pipeline()
+	for _, roomID := range roomIDs {
+		membersKey := roomMembersKeyPrefix + roomID
+		pipe.ZAdd(ctx, membersKey, redis.Z{Score: float64(expiry.Unix()), Member: userID})
+		pipe.Expire(ctx, membersKey, userRoomsTTL)
+	}
+	pipe.Expire(ctx, roomsKey, userRoomsTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		ps.logger.ErrorContext(ctx, "Error refreshing rooms of user", "op", "ZADD", "key", roomMembersKeyPrefix, "user_id", userID, "error", err)
+	}
+}
+
+// roomOccupancy returns how many members of the room have not expired
+func (ps *PresenceService) roomOccupancy(ctx context.Context, roomID string, now time.Time) (int64, error) {
+	if err := ps.pruneRoom(ctx, roomID, now); err != nil {
+		return 0, err
+	}
+
+	count, err := ps.redis.ZCard(ctx, roomMembersKeyPrefix+roomID).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count room members: %w", err)
+	}
+	return count, nil
+}
+
+// pruneRoom drops members whose presence expired. They stay in their own room list,
+// so a later heartbeat puts them back.
+func (ps *PresenceService) pruneRoom(ctx context.Context, roomID string, now time.Time) error {
+	cutoff := strconv.FormatInt(now.Unix(), 10)
+	if err := ps.redis.ZRemRangeByScore(ctx, roomMembersKeyPrefix+roomID, "-inf", "("+cutoff).Err(); err != nil {
+		return fmt.Errorf("failed to prune room members: %w", err)
+	}
+	return nil
+}
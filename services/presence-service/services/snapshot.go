@@ -0,0 +1,178 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"chorus/pkg/tracing"
+	"chorus/presence-service/models"
+)
+
+const (
+	// Held by the instance taking the current snapshot, so only one of them does
+	snapshotLockKey = "presence_snapshot_lock"
+
+	snapshotFilePrefix = "presence-"
+	snapshotFileSuffix = ".ndjson"
+)
+
+// SnapshotConfig configures the periodic presence snapshots taken by RunSnapshots
+type SnapshotConfig struct {
+	Interval   time.Duration
+	Dir        string // directory the snapshots are written to, if set
+	WebhookURL string // URL each snapshot is POSTed to as NDJSON, if set
+	Keep       int    // snapshots kept in Dir, 0 keeps all
+}
+
+// RunSnapshots exports the presence of every online user each cfg.Interval, writing
+// it to cfg.Dir and/or posting it to cfg.WebhookURL. A Redis lock makes sure only
+// one instance takes each snapshot. It blocks until ctx is cancelled.
+func (ps *PresenceService) RunSnapshots(ctx context.Context, cfg SnapshotConfig) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	client := &http.Client{Transport: tracing.Transport(nil), Timeout: time.Minute}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// The lock outlives the snapshot, so instances whose tickers fire a little
+		// later skip this round too
+		locked, err := ps.redis.SetNX(ctx, snapshotLockKey, "1", cfg.Interval/2).Result()
+		if err != nil {
+			ps.logger.ErrorContext(ctx, "Error taking presence snapshot lock", "op", "SETNX", "key", snapshotLockKey, "error", err)
+			continue
+		}
+		if !locked {
+			continue
+		}
+
+		start := time.Now()
+		users, size, err := ps.takeSnapshot(ctx, cfg, client, start)
+		snapshotDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			snapshotsTotal.Inc("error")
+			ps.logger.ErrorContext(ctx, "Error taking presence snapshot", "error", err)
+			continue
+		}
+		snapshotsTotal.Inc("success")
+		snapshotUsers.Set(float64(users))
+		snapshotBytes.Set(float64(size))
+		ps.logger.InfoContext(ctx, "Presence snapshot taken", "users", users, "bytes", size, "duration", time.Since(start).String())
+	}
+}
+
+// takeSnapshot exports to a temporary file, which is then delivered, so a snapshot
+// of any size is never held in memory
+func (ps *PresenceService) takeSnapshot(ctx context.Context, cfg SnapshotConfig, client *http.Client, now time.Time) (int, int64, error) {
+	dir := cfg.Dir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	file, err := os.CreateTemp(dir, ".presence-snapshot-*")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	users, err := ps.ExportPresence(ctx, time.Time{}, func(record models.PresenceExportRecord) error {
+		return encoder.Encode(record)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := w.Flush(); err != nil {
+		return 0, 0, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	if cfg.WebhookURL != "" {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return 0, 0, fmt.Errorf("failed to read snapshot: %w", err)
+		}
+		if err := postSnapshot(ctx, client, cfg.WebhookURL, file, size, now); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if cfg.Dir != "" {
+		if err := file.Close(); err != nil {
+			return 0, 0, fmt.Errorf("failed to write snapshot: %w", err)
+		}
+		name := snapshotFilePrefix + now.UTC().Format("20060102T150405Z") + snapshotFileSuffix
+		if err := os.Rename(file.Name(), filepath.Join(cfg.Dir, name)); err != nil {
+			return 0, 0, fmt.Errorf("failed to save snapshot: %w", err)
+		}
+		ps.pruneSnapshots(cfg.Dir, cfg.Keep)
+	}
+	return users, size, nil
+}
+
+func postSnapshot(ctx context.Context, client *http.Client, url string, file *os.File, size int64, now time.Time) error {
+	// The client closes the body, and the file is still needed afterwards
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, io.NopCloser(file))
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot request: %w", err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("X-Snapshot-Time", now.UTC().Format(time.RFC3339))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("snapshot webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// pruneSnapshots removes all but the newest keep snapshots from dir
+func (ps *PresenceService) pruneSnapshots(dir string, keep int) {
+	if keep <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		ps.logger.Error("Error listing presence snapshots", "dir", dir, "error", err)
+		return
+	}
+	var names []string
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasPrefix(name, snapshotFilePrefix) && strings.HasSuffix(name, snapshotFileSuffix) {
+			names = append(names, name)
+		}
+	}
+	if len(names) <= keep {
+		return
+	}
+
+	// Timestamps in the names sort chronologically
+	sort.Strings(names)
+	for _, name := range names[:len(names)-keep] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			ps.logger.Error("Error removing presence snapshot", "file", name, "error", err)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+	"chorus/presence-service/models"
+)
+
+// Status messages live under their own key so they outlive the presence TTL
+const statusMessageKeyPrefix = "status_message:"
+
+// ValidateStatusMessage checks the length of a status message and that it does not
+// expire in the past
+func ValidateStatusMessage(message models.StatusMessage) error {
+	if utf8.RuneCountInString(message.Message) > models.MaxStatusMessageLength {
+		return fmt.Errorf("status_message must be at most %d characters", models.MaxStatusMessageLength)
+	}
+	if utf8.RuneCountInString(message.Emoji) > models.MaxStatusEmojiLength {
+		return fmt.Errorf("status_emoji must be at most %d characters", models.MaxStatusEmojiLength)
+	}
+	if message.ExpiresAt != nil && !message.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("expires_at must be in the future")
+	}
+	return nil
+}
+
+// SetStatusMessage sets the user's status message, or clears it when message and
+// emoji are both empty. While the user is present their presence is updated too
+// and a status_message event is published; otherwise the message shows up with
+// their next heartbeat.
+func (ps *PresenceService) SetStatusMessage(ctx context.Context, userID string, message models.StatusMessage) error {
+	if err := ValidateStatusMessage(message); err != nil {
+		return err
+	}
+	
+	clear := message.Message == "" && message.Emoji == ""
+	if clear {
+		message.ExpiresAt = nil
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal status message: %w", err)
+	}
+	
+	key := presenceKeyPrefix + userID
+	messageKey := statusMessageKeyPrefix + userID
+	now := time.Now()
+	
+	var present, changed bool
+	var presence *models.UserPresence
+	err = ps.updateSessions(ctx, key, func(tx *redis.Tx) error {
+		current, _, err := ps.loadPresence(ctx, tx, key, now)
+		if err != nil {
+			return err
+		}
+		presence = current
+		presence.UserID = userID
+		present = len(presence.Devices) > 0
+		
+		previousMessage, previousEmoji := presence.StatusMessage, presence.StatusEmoji
+		if clear {
+			applyStatusMessage(presence, nil)
+		} else {
+			applyStatusMessage(presence, &message)
+		}
+		changed = previousMessage != presence.StatusMessage || previousEmoji != presence.StatusEmoji
+		
+		override, err := ps.loadOverride(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		applyOverride(presence, override, now)
+		dnd, err := ps.loadDND(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		applyDND(presence, dnd, now)
+		
+		blob, err := json.Marshal(presence)
+		if err != nil {
+			return fmt.Errorf("failed to marshal presence data: %w", err)
+		}
+		
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if clear {
+				pipe.Del(ctx, messageKey)
+			} else {
+				// Without expires_at the message stays until cleared
+				var ttl time.Duration
+				if message.ExpiresAt != nil {
+					ttl = message.ExpiresAt.Sub(now)
+				}
+				pipe.Set(ctx, messageKey, data, ttl)
+			}
+			if present {
+				pipe.Set(ctx, key, blob, redis.KeepTTL)
+				pipe.HSet(ctx, lastKnownKey, userID, blob)
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set status message: %w", err)
+	}
+	
+	if present && changed {
+		ps.publishChange(ctx, models.PresenceEvent{
+			UserID:        userID,
+			OldStatus:     presence.Status,
+			NewStatus:     presence.Status,
+			Device:        presence.Device,
+			Reason:        models.PresenceChangeMessage,
+			Timestamp:     now,
+			StatusMessage: presence.StatusMessage,
+			StatusEmoji:   presence.StatusEmoji,
+		})
+	}
+	
+	if clear {
+		ps.logger.InfoContext(ctx, "Cleared status message", "user_id", userID)
+	} else {
+		ps.logger.InfoContext(ctx, "Set status message", "user_id", userID)
+	}
+	return nil
+}
+
+// loadStatusMessage returns the user's status message, or nil if they have none
+func (ps *PresenceService) loadStatusMessage(ctx context.Context, client redis.Cmdable, userID string) (*models.StatusMessage, error) {
+	data, err := client.Get(ctx, statusMessageKeyPrefix+userID).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get status message: %w", err)
+	}
+	
+	var message models.StatusMessage
+	if err := json.Unmarshal([]byte(data), &message); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal status message: %w", err)
+	}
+	return &message, nil
+}
+
+// applyStatusMessage copies message onto presence, clearing it when message is nil
+func applyStatusMessage(presence *models.UserPresence, message *models.StatusMessage) {
+	if message == nil {
+		message = &models.StatusMessage{}
+	}
+	presence.StatusMessage = message.Message
+	presence.StatusEmoji = message.Emoji
+	presence.StatusExpiresAt = message.ExpiresAt
+}
+
+// statusMessageOf returns the status message carried by presence, or nil
+func statusMessageOf(presence *models.UserPresence) *models.StatusMessage {
+	if presence.StatusMessage == "" && presence.StatusEmoji == "" {
+		return nil
+	}
+	return &models.StatusMessage{
+		Message:   presence.StatusMessage,
+		Emoji:     presence.StatusEmoji,
+		ExpiresAt: presence.StatusExpiresAt,
+	}
+}
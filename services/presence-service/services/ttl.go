@@ -0,0 +1,67 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"chorus/presence-service/models"
+)
+
+// SetTTLBounds sets the shortest and longest TTL a heartbeat may request with
+// ttl_seconds. The default TTL is always allowed.
+func (ps *PresenceService) SetTTLBounds(min, max time.Duration) {
+	ps.minTTL = min
+	ps.maxTTL = max
+}
+
+// ValidateTTL checks a TTL requested by a heartbeat; 0 asks for the default
+func (ps *PresenceService) ValidateTTL(seconds int) error {
+	if seconds == 0 {
+		return nil
+	}
+	ttl := time.Duration(seconds) * time.Second
+	if ttl == ps.ttl {
+		return nil
+	}
+	if seconds < 0 || ttl < ps.minTTL || ttl > ps.longestTTL() {
+		return fmt.Errorf("ttl_seconds must be between %d and %d", int(ps.minTTL.Seconds()), int(ps.longestTTL().Seconds()))
+	}
+	return nil
+}
+
+// requestedTTL is the TTL a session gets from a heartbeat's validated ttl_seconds
+func (ps *PresenceService) requestedTTL(seconds int) time.Duration {
+	if seconds == 0 {
+		return ps.ttl
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// longestTTL bounds how long any session can live
+func (ps *PresenceService) longestTTL() time.Duration {
+	if ps.maxTTL > ps.ttl {
+		return ps.maxTTL
+	}
+	return ps.ttl
+}
+
+// sessionTTL is how long a session lives after its last heartbeat. Sessions stored
+// before the TTL was recorded use the default.
+func (ps *PresenceService) sessionTTL(session models.DevicePresence) time.Duration {
+	if session.TTLSeconds > 0 {
+		return time.Duration(session.TTLSeconds) * time.Second
+	}
+	return ps.ttl
+}
+
+// presenceExpiry is when the longest-lived session of presence expires, and with it
+// the presence key
+func (ps *PresenceService) presenceExpiry(presence models.UserPresence) time.Time {
+	var expiry time.Time
+	for _, session := range presence.Devices {
+		if at := session.LastSeen.Add(ps.sessionTTL(session)); at.After(expiry) {
+			expiry = at
+		}
+	}
+	return expiry
+}
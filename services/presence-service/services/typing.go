@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"chorus/pkg/eventbus"
+	"chorus/presence-service/models"
+)
+
+// Sorted set per conversation of typing user IDs scored by when their indicator
+// expires, in unix milliseconds
+const typingKeyPrefix = "typing:"
+
+var ErrInvalidConversationID = errors.New("conversation_id must be 1-128 letters, digits, '.', '_', ':' or '-'")
+
+// SetTypingTTL sets how long a typing indicator lasts without being refreshed
+func (ps *PresenceService) SetTypingTTL(ttl time.Duration) {
+	ps.typingTTL = ttl
+}
+
+// SetTyping starts or refreshes the user's typing indicator in a conversation, or
+// clears it when stopped is true, and publishes a typing event. Clearing an
+// indicator that is not set publishes nothing.
+func (ps *PresenceService) SetTyping(ctx context.Context, userID, conversationID string, stopped bool) (*models.TypingEvent, error) {
+	if !roomIDPattern.MatchString(conversationID) {
+		return nil, ErrInvalidConversationID
+	}
+
+	key := typingKeyPrefix + conversationID
+	now := time.Now()
+	event := models.TypingEvent{
+		UserID:         userID,
+		ConversationID: conversationID,
+		Timestamp:      now,
+	}
+
+	if stopped {
+		removed, err := ps.redis.ZRem(ctx, key, userID).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to clear typing indicator: %w", err)
+		}
+		event.Reason = models.PresenceChangeTypingStopped
+		if removed > 0 {
+			ps.publishTyping(ctx, event)
+		}
+		return &event, nil
+	}
+
+	expiresAt := now.Add(ps.typingTTL)
+	pipe := ps.redis.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(expiresAt.UnixMilli()), Member: userID})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(now.UnixMilli(), 10))
+	// The set outlives its longest indicator only briefly
+	pipe.PExpire(ctx, key, ps.typingTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to set typing indicator: %w", err)
+	}
+
+	event.Reason = models.PresenceChangeTyping
+	event.ExpiresAt = &expiresAt
+	ps.publishTyping(ctx, event)
+	return &event, nil
+}
+
+// GetTypingUsers returns the users whose typing indicator in a conversation has
+// not expired
+func (ps *PresenceService) GetTypingUsers(ctx context.Context, conversationID string) ([]models.TypingUser, error) {
+	if !roomIDPattern.MatchString(conversationID) {
+		return nil, ErrInvalidConversationID
+	}
+
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	members, err := ps.redis.ZRangeByScoreWithScores(ctx, typingKeyPrefix+conversationID, &redis.ZRangeBy{
+		Min: now,
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get typing users: %w", err)
+	}
+
+	users := make([]models.TypingUser, 0, len(members))
+	for _, member := range members {
+		users = append(users, models.TypingUser{
+			UserID:    member.Member.(string),
+			ExpiresAt: time.UnixMilli(int64(member.Score)).UTC(),
+		})
+	}
+	return users, nil
+}
+
+// publishTyping announces a typing event on eventbus.PresenceEvents
+func (ps *PresenceService) publishTyping(ctx context.Context, event models.TypingEvent) {
+	ps.publishEvent(ctx, eventbus.PresenceEvent{
+		UserID:         event.UserID,
+		Reason:         event.Reason,
+		Timestamp:      event.Timestamp,
+		ConversationID: event.ConversationID,
+		ExpiresAt:      event.ExpiresAt,
+	})
+}
@@ -0,0 +1,27 @@
+package services
+
+import (
+	"context"
+
+	"chorus/presence-service/models"
+)
+
+// WatchPresence calls fn with every status change of userIDs published on
+// eventbus.PresenceEvents, by this or any other instance, until ctx is cancelled or
+// fn returns an error. Typing events are not status changes and are skipped. Events
+// come from RunEvents, so none arrive while it is not subscribed.
+func (ps *PresenceService) WatchPresence(ctx context.Context, userIDs []string, fn func(models.PresenceEvent) error) error {
+	watcher, stop := ps.watchers.watch(userIDs)
+	defer stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-watcher.events:
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+	}
+}
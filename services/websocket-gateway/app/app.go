@@ -0,0 +1,260 @@
+// Package app assembles the WebSocket gateway: its Redis client, hub and HTTP
+// routes. main serves it, over TLS when configured, and the integration tests run
+// it in their process.
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"chorus/pkg/auth"
+	"chorus/pkg/cors"
+	"chorus/pkg/httpserver"
+	"chorus/pkg/logging"
+	"chorus/pkg/metrics"
+	"chorus/websocket-gateway/config"
+	"chorus/websocket-gateway/handlers"
+	"chorus/websocket-gateway/hub"
+	"chorus/websocket-gateway/middleware"
+)
+
+// App is a configured gateway, its hub not yet running
+type App struct {
+	// Handler serves the WebSocket endpoint and the HTTP API
+	Handler http.Handler
+
+	cfg         *config.Config
+	redis       *redis.Client
+	connections *hub.Hub
+	stop        context.CancelFunc
+	background  sync.WaitGroup
+}
+
+// New validates cfg, connects to Redis and builds the hub and the routes
+func New(cfg *config.Config, logger *logging.Logger) (*App, error) {
+	if err := cfg.CORS.Validate(cfg.Environment == "production"); err != nil {
+		return nil, fmt.Errorf("invalid CORS configuration: %w", err)
+	}
+	if err := cfg.JWT.Validate(cfg.Environment == "production"); err != nil {
+		return nil, fmt.Errorf("invalid JWT configuration: %w", err)
+	}
+	if err := cfg.Upgrade.Validate(cfg.Environment == "production"); err != nil {
+		return nil, fmt.Errorf("invalid WebSocket upgrade configuration: %w", err)
+	}
+	if err := cfg.ChannelAuth.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid channel rules: %w", err)
+	}
+
+	// Initialize Redis client, which relays messages between instances
+	redisClient, err := newRedisClient(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	validator := auth.NewValidator(cfg.JWT)
+
+	// The hub tracks connections and their channels
+	connections := hub.NewHub(redisClient, cfg.InstanceID, cfg.MaxChannelsPerConnection, logger)
+	connections.SetRegistryTTL(cfg.RegistryTTL)
+	connections.SetKeepalive(hub.Keepalive{
+		PingInterval: cfg.PingInterval,
+		PongWait:     cfg.PongTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	})
+	connections.SetLimits(hub.Limits{
+		MaxMessageSize:        cfg.MaxMessageBytes,
+		ConnectionRate:        cfg.ConnectionMessageRate,
+		ConnectionBurst:       cfg.ConnectionMessageBurst,
+		UserRate:              cfg.UserMessageRate,
+		UserBurst:             cfg.UserMessageBurst,
+		MaxConnectionsPerUser: cfg.MaxConnectionsPerUser,
+		CloseOldest:           cfg.ConnectionLimitPolicy == config.ConnectionLimitCloseOldest,
+	})
+	queueRules := make([]hub.QueueRule, 0, len(cfg.ChannelQueueRules))
+	for _, rule := range cfg.ChannelQueueRules {
+		queueRules = append(queueRules, hub.QueueRule{
+			Pattern: rule.Pattern,
+			Policy:  rule.Policy,
+			Buffer:  rule.Buffer,
+		})
+	}
+	connections.SetSendQueue(cfg.SendQueueSize, queueRules)
+	connections.SetAck(hub.Ack{
+		Timeout:    cfg.AckTimeout,
+		MaxRetries: cfg.AckMaxRetries,
+		PendingTTL: cfg.PendingTTL,
+	})
+	connections.SetReplay(hub.Replay{
+		Size:   cfg.ReplayBufferSize,
+		MaxAge: cfg.ReplayBufferAge,
+	})
+	connections.SetOffline(hub.Offline{
+		Size: cfg.OfflineQueueSize,
+		TTL:  cfg.OfflineQueueTTL,
+	})
+	channelRules := make([]hub.ChannelRule, 0, len(cfg.ChannelAuth.Rules))
+	for _, rule := range cfg.ChannelAuth.Rules {
+		channelRules = append(channelRules, hub.ChannelRule{
+			Pattern: rule.Pattern,
+			Roles:   rule.Roles,
+		})
+	}
+	connections.SetChannelRules(channelRules)
+	connections.SetWorkflows(hub.Workflows{
+		EngineURL:     cfg.WorkflowEngineURL,
+		APIKey:        cfg.WorkflowAPIKey,
+		OperatorRoles: cfg.WorkflowOperatorRoles,
+		AccessTTL:     cfg.WorkflowAccessCacheTTL,
+		TriggerRate:   cfg.WorkflowTriggerRate / 60,
+		TriggerBurst:  cfg.WorkflowTriggerBurst,
+	})
+	connections.SetPresence(hub.Presence{
+		URL:      cfg.PresenceURL,
+		APIKey:   cfg.PresenceAPIKey,
+		Interval: cfg.PresenceInterval,
+
+		WatchMax:        cfg.PresenceWatchMax,
+		AuthorizeURL:    cfg.PresenceAuthorizeURL,
+		AuthorizeAPIKey: cfg.PresenceAuthorizeAPIKey,
+	})
+	connections.SetDrain(hub.Drain{
+		Duration: cfg.DrainDuration,
+		Shutdown: cfg.ShutdownDrain,
+	})
+	connections.SetTokens(hub.Tokens{
+		Validator:  validator,
+		Enforce:    cfg.TokenExpiryEnforce,
+		WarnBefore: cfg.TokenExpiryWarning,
+	})
+
+	channelHandler := handlers.NewChannelHandler(connections, logger)
+	userHandler := handlers.NewUserHandler(connections, logger)
+	adminHandler := handlers.NewAdminHandler(connections, logger)
+
+	// Create HTTP mux
+	mux := http.NewServeMux()
+
+	// Health check endpoint
+	httpserver.RegisterHealth(mux, "websocket-gateway", nil)
+
+	// Prometheus metrics
+	mux.Handle("/metrics", metrics.Handler())
+
+	// The same numbers as JSON, for services and operators
+	mux.Handle("/stats", middleware.ServiceAuth(validator, cfg.ServiceRole, cfg.ServiceAPIKeys, logger, handlers.Stats(connections)))
+
+	// WebSocket endpoint with JWT authentication
+	mux.Handle("/ws", middleware.JWTAuth(validator, logger, handlers.NewWebSocketHandler(connections, logger, handlers.UpgradeOptions{
+		AllowedOrigins:       cfg.Upgrade.AllowedOrigins,
+		Compression:          cfg.Upgrade.Compression,
+		CompressionThreshold: cfg.Upgrade.CompressionThreshold,
+	})))
+
+	// Broadcasts from backend services, and from users to the channels they may use
+	mux.Handle("/channels/{name}/broadcast", middleware.ServiceOrUserAuth(validator, cfg.ServiceRole, cfg.ServiceAPIKeys, logger, http.HandlerFunc(channelHandler.Broadcast)))
+	mux.Handle("/users/{user_id}/send", middleware.ServiceAuth(validator, cfg.ServiceRole, cfg.ServiceAPIKeys, logger, http.HandlerFunc(userHandler.Send)))
+	mux.Handle("/users/{user_id}/pending", middleware.ServiceAuth(validator, cfg.ServiceRole, cfg.ServiceAPIKeys, logger, http.HandlerFunc(userHandler.Pending)))
+	mux.Handle("/users/{user_id}/offline", middleware.ServiceAuth(validator, cfg.ServiceRole, cfg.ServiceAPIKeys, logger, http.HandlerFunc(userHandler.Offline)))
+
+	// Support tooling: inspect and disconnect the connections of any instance, over
+	// mutual TLS when client CAs are configured
+	adminAuth := func(handler http.HandlerFunc) http.Handler {
+		authenticated := middleware.AdminAuth(validator, cfg.AdminRoles, cfg.AdminAPIKeys, logger, handler)
+		if cfg.TLS.ClientCAFile != "" {
+			return middleware.ClientCertAuth(logger, authenticated)
+		}
+		return authenticated
+	}
+	mux.Handle("/admin/connections", adminAuth(adminHandler.Connections))
+	mux.Handle("/admin/connections/{id}", adminAuth(adminHandler.DisconnectConnection))
+	mux.Handle("/admin/users/{user_id}/connections", adminAuth(adminHandler.DisconnectUser))
+
+	// Rolling deploys: move this instance's connections to the others gradually
+	mux.Handle("/admin/drain", adminAuth(adminHandler.Drain))
+	mux.Handle("/admin/drain/status", adminAuth(adminHandler.DrainStatus))
+
+	return &App{
+		Handler:     cors.New(cfg.CORS).Middleware(mux),
+		cfg:         cfg,
+		redis:       redisClient,
+		connections: connections,
+		stop:        func() {},
+	}, nil
+}
+
+// Start runs the hub and its background work
+func (a *App) Start() {
+	go a.connections.Run()
+
+	ctx, stop := context.WithCancel(context.Background())
+	a.stop = stop
+	runInBackground := func(fn func()) {
+		a.background.Add(1)
+		go func() {
+			defer a.background.Done()
+			fn()
+		}()
+	}
+
+	// Deliver messages published on other instances
+	runInBackground(func() { a.connections.RunRelay(ctx) })
+
+	// Tell other instances which users are connected here
+	runInBackground(func() { a.connections.RunRegistry(ctx) })
+
+	// Park unacknowledged messages and replay them as users reconnect
+	runInBackground(func() { a.connections.RunPending(ctx) })
+
+	// Forward workflow engine events to the channels of their instances
+	runInBackground(func() { a.connections.RunWorkflowEvents(ctx) })
+
+	// Forward presence status changes to the channels of their users
+	runInBackground(func() { a.connections.RunPresenceEvents(ctx) })
+
+	// Report connected users and their devices to the presence service
+	runInBackground(func() { a.connections.RunPresence(ctx) })
+}
+
+// Shutdown closes the WebSocket connections, answering upgrades with 503 meanwhile
+// so the load balancer moves on; the HTTP server does not track hijacked connections
+func (a *App) Shutdown(ctx context.Context) error {
+	return a.connections.Shutdown(ctx, a.cfg.ShutdownGrace)
+}
+
+// Stop stops the background work and waits for it, which removes this instance from
+// the user registry and parks the messages of the closed connections
+func (a *App) Stop() {
+	a.stop()
+	a.background.Wait()
+}
+
+// Close closes the Redis client
+func (a *App) Close() error {
+	return a.redis.Close()
+}
+
+func newRedisClient(cfg *config.Config, logger *logging.Logger) (*redis.Client, error) {
+	opt, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse Redis URL: %w", err)
+	}
+	opt.DB = cfg.RedisDB
+
+	client := redis.NewClient(opt)
+
+	// Test connection
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connect to Redis: %w", err)
+	}
+
+	logger.Info("Connected to Redis successfully")
+	return client, nil
+}
@@ -1,24 +1,452 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"chorus/pkg/auth"
+	"chorus/pkg/cors"
+	"chorus/pkg/env"
+)
+
+// Policies for a connection over the per-user connection limit
+const (
+	ConnectionLimitRejectNewest = "reject_newest" // close the new connection
+	ConnectionLimitCloseOldest  = "close_oldest"  // close the user's oldest connection to make room
 )
 
+// ChannelQueueRule is one entry of GATEWAY_CHANNEL_QUEUE_RULES, such as
+// "typing:*=lossy:8"
+type ChannelQueueRule struct {
+	Pattern string // path.Match pattern over channel names
+	Policy  string // disconnect or lossy
+	Buffer  int    // frames of one channel queued per connection, 0 for the whole queue
+}
+
+// ChannelRule is one entry of GATEWAY_CHANNEL_RULES, such as
+// {"pattern":"admin:*","roles":["admin"]}
+type ChannelRule struct {
+	Pattern string   `json:"pattern"`         // ':'-separated segments, "{claim}" matching that claim, a final "*" any rest
+	Roles   []string `json:"roles,omitempty"` // role claims allowed, any if empty
+}
+
+// Channels users may join and broadcast to unless GATEWAY_CHANNEL_RULES says
+// otherwise: their own, their organization's, admin channels for admins, and any
+// other channel
+var defaultChannelRules = []ChannelRule{
+	{Pattern: "user:{user_id}:*"},
+	{Pattern: "org:{org_id}:*"},
+	{Pattern: "admin:*", Roles: []string{"admin"}},
+	{Pattern: "*"},
+}
+
+// Upgrade configures how connections are upgraded to WebSocket
+type Upgrade struct {
+	AllowedOrigins       []string // origins browsers may connect from, any if empty outside production
+	Compression          bool     // negotiate permessage-deflate
+	CompressionThreshold int      // smallest frame compressed, in bytes
+}
+
+// TLS configures serving HTTPS and wss directly, instead of behind a proxy
+// terminating TLS
+type TLS struct {
+	CertFile     string // PEM certificate chain, TLS is off if empty
+	KeyFile      string // PEM private key
+	ClientCAFile string // PEM CAs issuing the client certificates the admin API requires, none if empty
+	RedirectPort string // plain HTTP port redirecting to HTTPS, none if empty
+}
+
+// ChannelAuth is who may join and broadcast to which channels, the first rule
+// matching a channel deciding
+type ChannelAuth struct {
+	Rules []ChannelRule
+	err   error // from parsing GATEWAY_CHANNEL_RULES
+}
+
 type Config struct {
-	Port      string
-	JWTSecret string
+	Port                     string
+	Environment              string
+	InstanceID               string // identifies this instance to the others
+	RedisURL                 string
+	RedisDB                  int
+	MaxChannelsPerConnection int           // channels one connection may join, 0 for no limit
+	RegistryTTL              time.Duration // lifetime of this instance's user registry entries without refresh
+	PingInterval             time.Duration // how often connections are pinged
+	PongTimeout              time.Duration // how long a connection may go without a pong before it is closed
+	IdleTimeout              time.Duration // how long a client may send nothing before it is closed, 0 never
+	ShutdownGrace            time.Duration // how long clients get to answer the close frame at shutdown
+	ShutdownDrain            time.Duration // how long closing the connections at shutdown may take, 0 closing them at once
+	DrainDuration            time.Duration // how long a drain started through the admin API takes by default
+	MaxMessageBytes          int64         // largest message a client may send
+	ConnectionMessageRate    float64       // messages per second one connection may send, 0 for no limit
+	ConnectionMessageBurst   float64
+	UserMessageRate          float64 // messages per second all of a user's connections may send, 0 for no limit
+	UserMessageBurst         float64
+	MaxConnectionsPerUser    int                // connections per user to this instance, 0 for no limit
+	ConnectionLimitPolicy    string             // reject_newest or close_oldest, for a connection over MaxConnectionsPerUser
+	SendQueueSize            int                // frames queued per connection before it counts as slow
+	ChannelQueueRules        []ChannelQueueRule // how frames of matching channels are queued, first match wins
+	AckTimeout               time.Duration      // how long clients have to ack a message before it is sent again
+	AckMaxRetries            int                // times a message is sent again before it is parked
+	PendingTTL               time.Duration      // how long parked messages are kept
+	ReplayBufferSize         int                // recent messages kept per channel and user for resuming clients, 0 disables
+	ReplayBufferAge          time.Duration      // how long those messages are kept
+	OfflineQueueSize         int                // persist_if_offline messages kept per offline user, 0 disables
+	OfflineQueueTTL          time.Duration      // how long those messages are kept
+	ServiceRole              string             // role claim of tokens that may broadcast
+	ServiceAPIKeys           []string           // X-API-Key values of services that may broadcast
+	AdminRoles               []string           // role claims of tokens that may use the admin API
+	AdminAPIKeys             []string           // X-API-Key values accepted by the admin API
+	PresenceURL              string             // of the presence service connections are reported to, "" disables reporting
+	PresenceAPIKey           string             // X-API-Key presented to the presence service
+	PresenceInterval         time.Duration      // how often open connections are reported
+	PresenceWatchMax         int                // users whose presence channels one connection may join, 0 for no limit
+	PresenceAuthorizeURL     string             // asked which users a client may watch, "" leaving it to the channel rules
+	PresenceAuthorizeAPIKey  string             // X-API-Key presented to PresenceAuthorizeURL
+	TokenExpiryEnforce       bool               // close connections whose token expired without a refresh
+	TokenExpiryWarning       time.Duration      // how long before its token expires a connection is warned, 0 never
+	WorkflowEngineURL        string             // of the workflow engine, checked for who may follow an instance's events
+	WorkflowAPIKey           string             // X-API-Key presented to the workflow engine
+	WorkflowOperatorRoles    []string           // role claims that may follow the events of any workflow instance without an engine
+	WorkflowAccessCacheTTL   time.Duration      // how long the engine's answer whether a token may follow an instance is reused
+	WorkflowTriggerRate      float64            // workflow.trigger frames per minute per user, 0 for no limit
+	WorkflowTriggerBurst     float64
+	ChannelAuth              ChannelAuth
+	JWT                      auth.Config
+	CORS                     cors.Config
+	Upgrade                  Upgrade
+	TLS                      TLS
+
+	err error // from reading the environment
 }
 
 func LoadConfig() *Config {
-	return &Config{
-		Port:      getEnv("PORT", "8080"),
-		JWTSecret: getEnv("JWT_SECRET", "your-secret-key"),
+	cfg := &Config{
+		Port:                     env.String("PORT", "8080"),
+		Environment:              env.String("ENVIRONMENT", "development"),
+		InstanceID:               env.String("GATEWAY_INSTANCE_ID", defaultInstanceID()),
+		RedisURL:                 env.URL("REDIS_URL", "redis://localhost:6379"),
+		RedisDB:                  env.Int("REDIS_DB", 0),
+		MaxChannelsPerConnection: env.Int("GATEWAY_MAX_CHANNELS_PER_CONNECTION", 50),
+		RegistryTTL:              env.Duration("GATEWAY_REGISTRY_TTL_SECONDS", 60*time.Second, time.Second),
+		PingInterval:             env.Duration("GATEWAY_PING_INTERVAL_SECONDS", 25*time.Second, time.Second),
+		PongTimeout:              env.Duration("GATEWAY_PONG_TIMEOUT_SECONDS", 60*time.Second, time.Second),
+		IdleTimeout:              env.Duration("GATEWAY_IDLE_TIMEOUT_SECONDS", 0, time.Second),
+		ShutdownGrace:            env.Duration("GATEWAY_SHUTDOWN_GRACE_SECONDS", 5*time.Second, time.Second),
+		ShutdownDrain:            env.Duration("GATEWAY_SHUTDOWN_DRAIN_SECONDS", 10*time.Second, time.Second),
+		DrainDuration:            env.Duration("GATEWAY_DRAIN_SECONDS", 300*time.Second, time.Second),
+		MaxMessageBytes:          int64(env.Int("GATEWAY_MAX_MESSAGE_BYTES", 4096)),
+		ConnectionMessageRate:    env.Float("GATEWAY_CONNECTION_MESSAGES_PER_SECOND", 10),
+		ConnectionMessageBurst:   env.Float("GATEWAY_CONNECTION_MESSAGE_BURST", 20),
+		UserMessageRate:          env.Float("GATEWAY_USER_MESSAGES_PER_SECOND", 20),
+		UserMessageBurst:         env.Float("GATEWAY_USER_MESSAGE_BURST", 40),
+		MaxConnectionsPerUser:    env.Int("GATEWAY_MAX_CONNECTIONS_PER_USER", 10),
+		ConnectionLimitPolicy:    env.String("GATEWAY_CONNECTION_LIMIT_POLICY", ConnectionLimitRejectNewest),
+		SendQueueSize:            env.Int("GATEWAY_SEND_QUEUE_SIZE", 256),
+		ChannelQueueRules:        parseQueueRules(env.String("GATEWAY_CHANNEL_QUEUE_RULES", "")),
+		AckTimeout:               env.Duration("GATEWAY_ACK_TIMEOUT_SECONDS", 10*time.Second, time.Second),
+		AckMaxRetries:            env.Int("GATEWAY_ACK_MAX_RETRIES", 3),
+		PendingTTL:               env.Duration("GATEWAY_PENDING_TTL_SECONDS", 24*time.Hour, time.Second),
+		ReplayBufferSize:         env.Int("GATEWAY_REPLAY_BUFFER_SIZE", 100),
+		ReplayBufferAge:          env.Duration("GATEWAY_REPLAY_BUFFER_AGE_SECONDS", 300*time.Second, time.Second),
+		OfflineQueueSize:         env.Int("GATEWAY_OFFLINE_QUEUE_SIZE", 100),
+		OfflineQueueTTL:          env.Duration("GATEWAY_OFFLINE_QUEUE_TTL_SECONDS", 7*24*time.Hour, time.Second),
+		ServiceRole:              env.String("GATEWAY_SERVICE_ROLE", "service"),
+		ServiceAPIKeys:           env.StringSlice("GATEWAY_SERVICE_API_KEYS", nil),
+		AdminRoles:               env.StringSlice("GATEWAY_ADMIN_ROLES", []string{"admin"}),
+		AdminAPIKeys:             env.StringSlice("GATEWAY_ADMIN_API_KEYS", nil),
+		PresenceURL:              env.URL("GATEWAY_PRESENCE_URL", ""),
+		PresenceAPIKey:           env.String("GATEWAY_PRESENCE_API_KEY", ""),
+		PresenceInterval:         env.Duration("GATEWAY_PRESENCE_INTERVAL_SECONDS", 20*time.Second, time.Second),
+		PresenceWatchMax:         env.Int("GATEWAY_PRESENCE_WATCH_MAX", 200),
+		PresenceAuthorizeURL:     env.URL("GATEWAY_PRESENCE_AUTHZ_URL", ""),
+		PresenceAuthorizeAPIKey:  env.String("GATEWAY_PRESENCE_AUTHZ_API_KEY", ""),
+		TokenExpiryEnforce:       env.Bool("GATEWAY_TOKEN_EXPIRY_ENFORCE", true),
+		TokenExpiryWarning:       env.Duration("GATEWAY_TOKEN_EXPIRY_WARNING_SECONDS", 60*time.Second, time.Second),
+		WorkflowEngineURL:        env.URL("GATEWAY_WORKFLOW_ENGINE_URL", ""),
+		WorkflowAPIKey:           env.String("GATEWAY_WORKFLOW_API_KEY", ""),
+		WorkflowOperatorRoles:    env.StringSlice("GATEWAY_WORKFLOW_OPERATOR_ROLES", []string{"admin", "operator"}),
+		WorkflowAccessCacheTTL:   env.Duration("GATEWAY_WORKFLOW_ACCESS_CACHE_SECONDS", 30*time.Second, time.Second),
+		WorkflowTriggerRate:      env.Float("GATEWAY_WORKFLOW_TRIGGERS_PER_MINUTE", 10),
+		WorkflowTriggerBurst:     env.Float("GATEWAY_WORKFLOW_TRIGGER_BURST", 3),
+		ChannelAuth:              channelAuthFromEnv(),
+		JWT:                      auth.ConfigFromEnv(),
+		CORS:                     cors.ConfigFromEnv(),
+
+		// The WebSocket origins default to those of CORS
+		Upgrade: Upgrade{
+			AllowedOrigins:       env.StringSlice("GATEWAY_ALLOWED_ORIGINS", env.StringSlice("CORS_ALLOWED_ORIGINS", nil)),
+			Compression:          env.Bool("GATEWAY_COMPRESSION", false),
+			CompressionThreshold: env.Int("GATEWAY_COMPRESSION_THRESHOLD_BYTES", 512),
+		},
+		TLS: TLS{
+			CertFile:     env.String("GATEWAY_TLS_CERT_FILE", ""),
+			KeyFile:      env.String("GATEWAY_TLS_KEY_FILE", ""),
+			ClientCAFile: env.String("GATEWAY_TLS_CLIENT_CA_FILE", ""),
+			RedirectPort: env.String("GATEWAY_TLS_REDIRECT_PORT", ""),
+		},
 	}
+	cfg.err = env.Err()
+
+	return cfg
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// Validate rejects values that could not be parsed and settings out of range
+func (c *Config) Validate() error {
+	if c.err != nil {
+		return c.err
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("PORT must be a port number, not %q", c.Port)
+	}
+
+	positive := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"GATEWAY_PING_INTERVAL_SECONDS", c.PingInterval},
+		{"GATEWAY_DRAIN_SECONDS", c.DrainDuration},
+		{"GATEWAY_ACK_TIMEOUT_SECONDS", c.AckTimeout},
+		{"GATEWAY_PENDING_TTL_SECONDS", c.PendingTTL},
+		{"GATEWAY_REPLAY_BUFFER_AGE_SECONDS", c.ReplayBufferAge},
+		{"GATEWAY_OFFLINE_QUEUE_TTL_SECONDS", c.OfflineQueueTTL},
+	}
+	for _, setting := range positive {
+		if setting.value <= 0 {
+			return fmt.Errorf("%s must be positive, not %s", setting.name, setting.value)
+		}
+	}
+	nonNegative := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"GATEWAY_IDLE_TIMEOUT_SECONDS", c.IdleTimeout},
+		{"GATEWAY_SHUTDOWN_GRACE_SECONDS", c.ShutdownGrace},
+		{"GATEWAY_SHUTDOWN_DRAIN_SECONDS", c.ShutdownDrain},
+		{"GATEWAY_TOKEN_EXPIRY_WARNING_SECONDS", c.TokenExpiryWarning},
+		{"GATEWAY_WORKFLOW_ACCESS_CACHE_SECONDS", c.WorkflowAccessCacheTTL},
+	}
+	for _, setting := range nonNegative {
+		if setting.value < 0 {
+			return fmt.Errorf("%s must not be negative, not %s", setting.name, setting.value)
+		}
+	}
+	switch {
+	case c.RegistryTTL < 3*time.Second:
+		return fmt.Errorf("GATEWAY_REGISTRY_TTL_SECONDS must be at least 3, not %s", c.RegistryTTL)
+	case c.PongTimeout <= c.PingInterval:
+		return errors.New("GATEWAY_PONG_TIMEOUT_SECONDS must be longer than GATEWAY_PING_INTERVAL_SECONDS")
+	case c.PresenceInterval < 10*time.Second || c.PresenceInterval > 300*time.Second:
+		return fmt.Errorf("GATEWAY_PRESENCE_INTERVAL_SECONDS must be between 10 and 300, not %s", c.PresenceInterval)
+	case c.ConnectionLimitPolicy != ConnectionLimitRejectNewest && c.ConnectionLimitPolicy != ConnectionLimitCloseOldest:
+		return fmt.Errorf("GATEWAY_CONNECTION_LIMIT_POLICY must be %s or %s, not %q", ConnectionLimitRejectNewest, ConnectionLimitCloseOldest, c.ConnectionLimitPolicy)
+	}
+
+	counts := []struct {
+		name  string
+		value int
+		min   int
+	}{
+		{"REDIS_DB", c.RedisDB, 0},
+		{"GATEWAY_MAX_CHANNELS_PER_CONNECTION", c.MaxChannelsPerConnection, 0},
+		{"GATEWAY_PRESENCE_WATCH_MAX", c.PresenceWatchMax, 0},
+		{"GATEWAY_MAX_MESSAGE_BYTES", int(c.MaxMessageBytes), 1},
+		{"GATEWAY_MAX_CONNECTIONS_PER_USER", c.MaxConnectionsPerUser, 0},
+		{"GATEWAY_SEND_QUEUE_SIZE", c.SendQueueSize, 1},
+		{"GATEWAY_ACK_MAX_RETRIES", c.AckMaxRetries, 0},
+		{"GATEWAY_REPLAY_BUFFER_SIZE", c.ReplayBufferSize, 0},
+		{"GATEWAY_OFFLINE_QUEUE_SIZE", c.OfflineQueueSize, 0},
+		{"GATEWAY_COMPRESSION_THRESHOLD_BYTES", c.Upgrade.CompressionThreshold, 0},
+	}
+	for _, setting := range counts {
+		if setting.value < setting.min {
+			return fmt.Errorf("%s must be at least %d, not %d", setting.name, setting.min, setting.value)
+		}
+	}
+
+	rates := []struct {
+		name  string
+		value float64
+	}{
+		{"GATEWAY_CONNECTION_MESSAGES_PER_SECOND", c.ConnectionMessageRate},
+		{"GATEWAY_CONNECTION_MESSAGE_BURST", c.ConnectionMessageBurst},
+		{"GATEWAY_USER_MESSAGES_PER_SECOND", c.UserMessageRate},
+		{"GATEWAY_USER_MESSAGE_BURST", c.UserMessageBurst},
+		{"GATEWAY_WORKFLOW_TRIGGERS_PER_MINUTE", c.WorkflowTriggerRate},
+		{"GATEWAY_WORKFLOW_TRIGGER_BURST", c.WorkflowTriggerBurst},
 	}
-	return defaultValue
-}
\ No newline at end of file
+	for _, setting := range rates {
+		if setting.value < 0 {
+			return fmt.Errorf("%s must not be negative, not %g", setting.name, setting.value)
+		}
+	}
+
+	return nil
+}
+
+// parseQueueRules parses comma-separated pattern=policy[:buffer] entries, skipping
+// malformed ones
+func parseQueueRules(value string) []ChannelQueueRule {
+	var rules []ChannelQueueRule
+	for _, entry := range splitList(value) {
+		pattern, setting, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			continue
+		}
+		policy, buffer, _ := strings.Cut(setting, ":")
+		if policy != "disconnect" && policy != "lossy" {
+			continue
+		}
+		rule := ChannelQueueRule{Pattern: pattern, Policy: policy}
+		if buffer != "" {
+			size, err := strconv.Atoi(buffer)
+			if err != nil || size < 0 {
+				continue
+			}
+			rule.Buffer = size
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// channelAuthFromEnv reads GATEWAY_CHANNEL_RULES, a JSON array of ChannelRule
+func channelAuthFromEnv() ChannelAuth {
+	value := env.String("GATEWAY_CHANNEL_RULES", "")
+	if value == "" {
+		return ChannelAuth{Rules: defaultChannelRules}
+	}
+	var rules []ChannelRule
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return ChannelAuth{err: fmt.Errorf("GATEWAY_CHANNEL_RULES must be a JSON array of rules: %w", err)}
+	}
+	return ChannelAuth{Rules: rules}
+}
+
+// Validate reports rules that could not be read or whose patterns are malformed
+func (c ChannelAuth) Validate() error {
+	if c.err != nil {
+		return c.err
+	}
+	for _, rule := range c.Rules {
+		if rule.Pattern == "" {
+			return errors.New("channel rule pattern must not be empty")
+		}
+		segments := strings.Split(rule.Pattern, ":")
+		for i, segment := range segments {
+			switch {
+			case segment == "":
+				return fmt.Errorf("channel rule %q has an empty segment", rule.Pattern)
+			case strings.Contains(segment, "*") && (segment != "*" || i != len(segments)-1):
+				return fmt.Errorf("channel rule %q may only end with a * segment", rule.Pattern)
+			case strings.ContainsAny(segment, "{}") && (len(segment) < 3 || segment[0] != '{' || segment[len(segment)-1] != '}' || strings.ContainsAny(segment[1:len(segment)-1], "{}")):
+				return fmt.Errorf("channel rule %q has a malformed {claim} segment %q", rule.Pattern, segment)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks the allowed origins. Production needs them listed, without "*";
+// elsewhere an empty list allows any origin.
+func (u Upgrade) Validate(production bool) error {
+	if len(u.AllowedOrigins) == 0 {
+		if production {
+			return errors.New("GATEWAY_ALLOWED_ORIGINS must be set in production")
+		}
+		return nil
+	}
+	for _, origin := range u.AllowedOrigins {
+		if origin == "*" {
+			if production {
+				return errors.New("GATEWAY_ALLOWED_ORIGINS must not contain \"*\" in production")
+			}
+			continue
+		}
+		scheme, host, ok := strings.Cut(origin, "://")
+		if !ok || scheme == "" || host == "" || strings.Contains(host, "/") {
+			return fmt.Errorf("invalid origin %q: expected scheme://host[:port]", origin)
+		}
+		if strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+			return fmt.Errorf("invalid origin %q: wildcards are only supported as the leftmost subdomain", origin)
+		}
+	}
+	return nil
+}
+
+// Enabled reports whether the server serves TLS itself
+func (t TLS) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// Validate checks that the certificate and key come together, that the client CA
+// and the redirect are only set with them, and that the files can be read
+func (t TLS) Validate(port string) error {
+	if !t.Enabled() {
+		switch {
+		case t.KeyFile != "":
+			return errors.New("GATEWAY_TLS_KEY_FILE is set without GATEWAY_TLS_CERT_FILE")
+		case t.ClientCAFile != "":
+			return errors.New("GATEWAY_TLS_CLIENT_CA_FILE needs GATEWAY_TLS_CERT_FILE and GATEWAY_TLS_KEY_FILE")
+		case t.RedirectPort != "":
+			return errors.New("GATEWAY_TLS_REDIRECT_PORT needs GATEWAY_TLS_CERT_FILE and GATEWAY_TLS_KEY_FILE")
+		}
+		return nil
+	}
+	if t.KeyFile == "" {
+		return errors.New("GATEWAY_TLS_CERT_FILE is set without GATEWAY_TLS_KEY_FILE")
+	}
+	if t.RedirectPort == port {
+		return fmt.Errorf("GATEWAY_TLS_REDIRECT_PORT must differ from PORT %s", port)
+	}
+	files := []struct{ name, path string }{
+		{"GATEWAY_TLS_CERT_FILE", t.CertFile},
+		{"GATEWAY_TLS_KEY_FILE", t.KeyFile},
+		{"GATEWAY_TLS_CLIENT_CA_FILE", t.ClientCAFile},
+	}
+	for _, file := range files {
+		if file.path == "" {
+			continue
+		}
+		f, err := os.Open(file.path)
+		if err != nil {
+			return fmt.Errorf("%s cannot be read: %w", file.name, err)
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// defaultInstanceID is the host name with a random suffix, unique even when
+// replicas share a host name
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "gateway"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// splitList splits a comma-separated value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,28 @@
+package config
+
+import "testing"
+
+func TestUpgradeValidateOrigins(t *testing.T) {
+	tests := []struct {
+		origins    []string
+		production bool
+		valid      bool
+	}{
+		{nil, false, true}, // any origin in development
+		{nil, true, false},
+		{[]string{"*"}, false, true},
+		{[]string{"*"}, true, false},
+		{[]string{"https://app.example.com", "https://*.example.com:8443"}, true, true},
+		{[]string{"http://localhost:3000"}, false, true},
+		{[]string{"app.example.com"}, true, false},
+		{[]string{"https://app.example.com/"}, true, false},
+		{[]string{"https://app.*.example.com"}, true, false},
+		{[]string{"https://*.*.example.com"}, true, false},
+	}
+	for _, tt := range tests {
+		err := Upgrade{AllowedOrigins: tt.origins}.Validate(tt.production)
+		if (err == nil) != tt.valid {
+			t.Errorf("Validate(%q, production %v) = %v, want valid %v", tt.origins, tt.production, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+	"chorus/websocket-gateway/hub"
+)
+
+const (
+	// Reason of the close frame of disconnects that give none
+	defaultDisconnectReason = "disconnected by an administrator"
+
+	// Longest drain that may be asked for
+	maxDrainDuration = 24 * time.Hour
+)
+
+type AdminHandler struct {
+	hub    *hub.Hub
+	logger *logging.Logger
+}
+
+type ConnectionsResponse struct {
+	UserID      string               `json:"user_id"`
+	Connections []hub.ConnectionInfo `json:"connections"`
+	Unanswered  []string             `json:"unanswered_instances"` // listed by the registry, but silent; their connections are missing
+}
+
+type DisconnectResponse struct {
+	Closed     []hub.ConnectionInfo `json:"closed"`
+	Unanswered []string             `json:"unanswered_instances"` // may still hold connections that were not closed
+}
+
+type DrainResponse struct {
+	hub.DrainStatus
+	Error string `json:"error,omitempty"` // why the request was refused
+}
+
+func NewAdminHandler(h *hub.Hub, logger *logging.Logger) *AdminHandler {
+	return &AdminHandler{
+		hub:    h,
+		logger: logger,
+	}
+}
+
+// Connections handles GET /admin/connections?user_id=..., listing the user's
+// connections on every instance
+func (ah *AdminHandler) Connections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		apierror.Write(w, r, http.StatusBadRequest, "Missing user_id parameter", nil)
+		return
+	}
+
+	result, err := ah.hub.Connections(r.Context(), userID)
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "Failed to list connections", "user_id", userID, "error", err)
+		apierror.Write(w, r, http.StatusBadGateway, "Failed to list connections", nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ConnectionsResponse{
+		UserID:      userID,
+		Connections: nonNil(result.Connections),
+		Unanswered:  nonNil(result.Unanswered),
+	})
+}
+
+// DisconnectConnection handles DELETE /admin/connections/{id}, closing the connection
+// with ?reason=... as the reason of its close frame
+func (ah *AdminHandler) DisconnectConnection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	reason, ok := disconnectReason(w, r)
+	if !ok {
+		return
+	}
+	connectionID := r.PathValue("id")
+	actor, _ := r.Context().Value("actor").(string)
+	ah.logger.InfoContext(r.Context(), "Admin disconnect requested", "actor", actor, "connection_id", connectionID, "reason", reason)
+
+	result, err := ah.hub.Disconnect(r.Context(), connectionID, reason, actor)
+	switch {
+	case errors.Is(err, hub.ErrConnectionNotFound):
+		apierror.Write(w, r, http.StatusNotFound, "Connection not found", nil)
+		return
+	case err != nil:
+		ah.logger.ErrorContext(r.Context(), "Failed to disconnect connection", "connection_id", connectionID, "error", err)
+		apierror.Write(w, r, http.StatusBadGateway, "Failed to disconnect", nil)
+		return
+	case len(result.Connections) == 0:
+		apierror.Write(w, r, http.StatusGatewayTimeout, "The instance holding the connection did not answer", nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, DisconnectResponse{
+		Closed:     result.Connections,
+		Unanswered: nonNil(result.Unanswered),
+	})
+}
+
+// DisconnectUser handles DELETE /admin/users/{user_id}/connections, closing every
+// connection of the user as DisconnectConnection does
+func (ah *AdminHandler) DisconnectUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	reason, ok := disconnectReason(w, r)
+	if !ok {
+		return
+	}
+	userID := r.PathValue("user_id")
+	actor, _ := r.Context().Value("actor").(string)
+	ah.logger.InfoContext(r.Context(), "Admin disconnect requested", "actor", actor, "user_id", userID, "reason", reason)
+
+	result, err := ah.hub.DisconnectUser(r.Context(), userID, reason, actor)
+	if err != nil {
+		ah.logger.ErrorContext(r.Context(), "Failed to disconnect user", "user_id", userID, "error", err)
+		if len(result.Connections) == 0 {
+			apierror.Write(w, r, http.StatusBadGateway, "Failed to disconnect", nil)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, DisconnectResponse{
+		Closed:     nonNil(result.Connections),
+		Unanswered: nonNil(result.Unanswered),
+	})
+}
+
+// Drain handles POST /admin/drain, which starts draining this instance's connections
+// over ?duration_seconds=..., or the configured duration, and DELETE /admin/drain,
+// which aborts the drain. Both answer with the drain's status.
+func (ah *AdminHandler) Drain(w http.ResponseWriter, r *http.Request) {
+	actor, _ := r.Context().Value("actor").(string)
+	switch r.Method {
+	case http.MethodPost:
+		var duration time.Duration
+		if value := r.URL.Query().Get("duration_seconds"); value != "" {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > maxDrainDuration {
+				apierror.Write(w, r, http.StatusBadRequest, "Invalid duration_seconds parameter", nil)
+				return
+			}
+			duration = time.Duration(seconds) * time.Second
+		}
+		ah.logger.InfoContext(r.Context(), "Admin drain requested", "actor", actor, "duration", duration.String())
+
+		status, err := ah.hub.StartDrain(duration)
+		if err != nil {
+			writeJSON(w, http.StatusConflict, DrainResponse{DrainStatus: status, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusAccepted, DrainResponse{DrainStatus: status})
+
+	case http.MethodDelete:
+		ah.logger.InfoContext(r.Context(), "Admin drain abort requested", "actor", actor)
+
+		status, err := ah.hub.AbortDrain()
+		if err != nil {
+			writeJSON(w, http.StatusConflict, DrainResponse{DrainStatus: status, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, DrainResponse{DrainStatus: status})
+
+	default:
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
+
+// DrainStatus handles GET /admin/drain/status, reporting the progress of this
+// instance's current or last drain
+func (ah *AdminHandler) DrainStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, DrainResponse{DrainStatus: ah.hub.DrainStatus()})
+}
+
+// disconnectReason reads the reason parameter, answering 400 if it does not fit in a
+// close frame
+func disconnectReason(w http.ResponseWriter, r *http.Request) (string, bool) {
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		return defaultDisconnectReason, true
+	}
+	if len(reason) > hub.MaxDisconnectReasonLength || !utf8.ValidString(reason) {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid reason parameter", nil)
+		return "", false
+	}
+	return reason, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// nonNil encodes a nil slice as [] rather than null
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
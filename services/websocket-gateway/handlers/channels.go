@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+	"chorus/websocket-gateway/hub"
+)
+
+// Largest body accepted by POST /channels/{name}/broadcast
+const maxBroadcastSize = 64 << 10
+
+type ChannelHandler struct {
+	hub    *hub.Hub
+	logger *logging.Logger
+}
+
+type BroadcastResponse struct {
+	Channel    string       `json:"channel"`
+	Recipients int          `json:"recipients"` // connections the message was queued for, on the instances in the delivery
+	Relayed    bool         `json:"relayed"`    // whether it was passed on to the other instances
+	Stored     bool         `json:"stored"`     // whether it was kept for the channel's user, who is offline
+	Delivery   hub.Delivery `json:"delivery"`
+}
+
+func NewChannelHandler(h *hub.Hub, logger *logging.Logger) *ChannelHandler {
+	return &ChannelHandler{
+		hub:    h,
+		logger: logger,
+	}
+}
+
+// Broadcast handles POST /channels/{name}/broadcast. The body, any JSON value, is
+// sent to every connection that joined the channel as the data of a message frame.
+// Users, unlike services, may only broadcast to channels the channel rules allow them.
+// With ?persist_if_offline=true, a message for the channel of a user who has no
+// connection is kept for them until they connect. With ?wait_ms=, the response
+// waits that long, up to hub.MaxDeliveryWait, for the other instances to report
+// their delivery.
+func (ch *ChannelHandler) Broadcast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	channel := r.PathValue("name")
+	if !hub.ValidChannel(channel) {
+		apierror.Write(w, r, http.StatusBadRequest, hub.ErrInvalidChannel.Error(), nil)
+		return
+	}
+	if claims, ok := r.Context().Value("claims").(map[string]any); ok {
+		// Events of workflow instances come from the engine only, status changes from
+		// the presence service
+		reserved := ""
+		if hub.WorkflowChannel(channel) {
+			reserved = "workflow"
+		} else if hub.PresenceChannel(channel) {
+			reserved = "presence"
+		}
+		if reserved != "" {
+			ch.logger.InfoContext(r.Context(), "Channel broadcast denied", "user_id", r.Context().Value("userID"), "channel", channel, "rule", reserved)
+			apierror.Write(w, r, http.StatusForbidden, hub.ErrChannelForbidden.Error(), nil)
+			return
+		}
+		if rule, err := ch.hub.AuthorizeChannel(channel, claims); err != nil {
+			ch.logger.InfoContext(r.Context(), "Channel broadcast denied", "user_id", r.Context().Value("userID"), "channel", channel, "rule", rule)
+			apierror.Write(w, r, http.StatusForbidden, err.Error(), nil)
+			return
+		}
+	}
+
+	persist, err := strconv.ParseBool(r.URL.Query().Get("persist_if_offline"))
+	if err != nil && r.URL.Query().Has("persist_if_offline") {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid persist_if_offline parameter", nil)
+		return
+	}
+	wait, ok := parseWait(r)
+	if !ok {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid wait_ms parameter", nil)
+		return
+	}
+	owner := ch.hub.ChannelUser(channel)
+	if persist && owner == "" {
+		apierror.Write(w, r, http.StatusBadRequest, "persist_if_offline needs the channel of a user, such as user:{user_id}:...", nil)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBroadcastSize))
+	if err != nil {
+		apierror.Write(w, r, http.StatusRequestEntityTooLarge, "Request body too large", nil)
+		return
+	}
+	if !json.Valid(body) {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid JSON payload", nil)
+		return
+	}
+
+	delivery, err := ch.hub.Publish(r.Context(), channel, body, wait)
+	if err != nil {
+		ch.logger.ErrorContext(r.Context(), "Failed to relay broadcast", "channel", channel, "error", err)
+	}
+	stored := false
+	if persist && delivery.Recipients() == 0 {
+		stored = ch.storeIfOffline(r, owner, channel, body)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(BroadcastResponse{
+		Channel:    channel,
+		Recipients: delivery.Recipients(),
+		Relayed:    err == nil,
+		Stored:     stored,
+		Delivery:   delivery,
+	})
+}
+
+// parseWait returns the ?wait_ms= of a broadcast, 0 if absent, reporting false if it
+// is not a number of milliseconds
+func parseWait(r *http.Request) (time.Duration, bool) {
+	if !r.URL.Query().Has("wait_ms") {
+		return 0, true
+	}
+	ms, err := strconv.Atoi(r.URL.Query().Get("wait_ms"))
+	if err != nil || ms < 0 {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// storeIfOffline keeps a broadcast for the channel's user if the registry lists no
+// connection of theirs, reporting whether it did
+func (ch *ChannelHandler) storeIfOffline(r *http.Request, userID, channel string, body []byte) bool {
+	online, err := ch.hub.UserOnline(r.Context(), userID)
+	if err != nil {
+		ch.logger.ErrorContext(r.Context(), "Failed to look up channel user", "channel", channel, "user_id", userID, "error", err)
+		return false
+	}
+	if online {
+		return false
+	}
+	stored, err := ch.hub.StoreOffline(r.Context(), userID, channel, body)
+	if err != nil {
+		ch.logger.ErrorContext(r.Context(), "Failed to store broadcast for offline user", "channel", channel, "user_id", userID, "error", err)
+	}
+	return stored
+}
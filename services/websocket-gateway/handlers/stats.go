@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"chorus/pkg/apierror"
+	"chorus/websocket-gateway/hub"
+)
+
+// Stats returns the handler of GET /stats, which summarizes this instance's metrics
+// as JSON for a quick look without Prometheus
+func Stats(h *hub.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(h.Stats())
+	}
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+	"chorus/websocket-gateway/hub"
+)
+
+type UserHandler struct {
+	hub    *hub.Hub
+	logger *logging.Logger
+}
+
+type SendResponse struct {
+	UserID           string   `json:"user_id"`
+	MessageID        string   `json:"message_id,omitempty"` // set when the message requires an ack
+	Online           bool     `json:"online"`               // whether the user had any live connection
+	LocalConnections int      `json:"local_connections"`    // connections on this instance the message was queued for
+	Instances        []string `json:"instances"`            // other instances the message was relayed to
+	Parked           bool     `json:"parked"`               // whether the message was parked for the offline user
+	Stored           bool     `json:"stored"`               // whether the message was kept in the offline user's queue
+}
+
+type PendingResponse struct {
+	UserID   string               `json:"user_id"`
+	Messages []hub.PendingMessage `json:"messages"`
+}
+
+type OfflineResponse struct {
+	UserID   string               `json:"user_id"`
+	Messages []hub.OfflineMessage `json:"messages"`
+}
+
+type PurgeResponse struct {
+	UserID string `json:"user_id"`
+	Purged int64  `json:"purged"` // messages removed from the queue
+}
+
+func NewUserHandler(h *hub.Hub, logger *logging.Logger) *UserHandler {
+	return &UserHandler{
+		hub:    h,
+		logger: logger,
+	}
+}
+
+// Send handles POST /users/{user_id}/send. The body, any JSON value, is sent to every
+// connection of the user, on any instance, as the data of a direct frame. With
+// ?requires_ack=true the frame carries an ID for the client to ack, and the message
+// is retried, then parked for the user, until it does. With ?persist_if_offline=true a
+// message that reached no connection, and was not parked, is kept in the user's
+// offline queue until they connect.
+func (uh *UserHandler) Send(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID := r.PathValue("user_id")
+	requiresAck, err := strconv.ParseBool(r.URL.Query().Get("requires_ack"))
+	if err != nil && r.URL.Query().Has("requires_ack") {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid requires_ack parameter", nil)
+		return
+	}
+	persist, err := strconv.ParseBool(r.URL.Query().Get("persist_if_offline"))
+	if err != nil && r.URL.Query().Has("persist_if_offline") {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid persist_if_offline parameter", nil)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBroadcastSize))
+	if err != nil {
+		apierror.Write(w, r, http.StatusRequestEntityTooLarge, "Request body too large", nil)
+		return
+	}
+	if !json.Valid(body) {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid JSON payload", nil)
+		return
+	}
+
+	result, err := uh.hub.SendToUser(r.Context(), userID, body, requiresAck)
+	if err != nil {
+		uh.logger.ErrorContext(r.Context(), "Failed to route message", "user_id", userID, "error", err)
+		if result.LocalConnections == 0 {
+			apierror.Write(w, r, http.StatusBadGateway, "Failed to route message", nil)
+			return
+		}
+	}
+	stored := false
+	if persist && err == nil && !result.Online() && !result.Parked {
+		stored, err = uh.hub.StoreOffline(r.Context(), userID, "", body)
+		if err != nil {
+			uh.logger.ErrorContext(r.Context(), "Failed to store message for offline user", "user_id", userID, "error", err)
+		}
+	}
+
+	instances := result.Instances
+	if instances == nil {
+		instances = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SendResponse{
+		UserID:           userID,
+		MessageID:        result.MessageID,
+		Online:           result.Online(),
+		LocalConnections: result.LocalConnections,
+		Instances:        instances,
+		Parked:           result.Parked,
+		Stored:           stored,
+	})
+}
+
+// Pending handles GET /users/{user_id}/pending, listing the messages parked for the
+// user, oldest first, for debugging
+func (uh *UserHandler) Pending(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+		return
+	}
+
+	userID := r.PathValue("user_id")
+	messages, err := uh.hub.PendingMessages(r.Context(), userID)
+	if err != nil {
+		uh.logger.ErrorContext(r.Context(), "Failed to get pending messages", "user_id", userID, "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to get pending messages", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(PendingResponse{
+		UserID:   userID,
+		Messages: messages,
+	})
+}
+
+// Offline handles GET /users/{user_id}/offline, listing the user's offline queue,
+// oldest first, and DELETE /users/{user_id}/offline, emptying it
+func (uh *UserHandler) Offline(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("user_id")
+	switch r.Method {
+	case http.MethodGet:
+		messages, err := uh.hub.OfflineMessages(r.Context(), userID)
+		if err != nil {
+			uh.logger.ErrorContext(r.Context(), "Failed to get offline messages", "user_id", userID, "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Failed to get offline messages", nil)
+			return
+		}
+		writeJSON(w, http.StatusOK, OfflineResponse{
+			UserID:   userID,
+			Messages: messages,
+		})
+
+	case http.MethodDelete:
+		purged, err := uh.hub.PurgeOffline(r.Context(), userID)
+		if err != nil {
+			uh.logger.ErrorContext(r.Context(), "Failed to purge offline messages", "user_id", userID, "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Failed to purge offline messages", nil)
+			return
+		}
+		uh.logger.InfoContext(r.Context(), "Offline messages purged", "user_id", userID, "count", purged)
+		writeJSON(w, http.StatusOK, PurgeResponse{
+			UserID: userID,
+			Purged: purged,
+		})
+
+	default:
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed", nil)
+	}
+}
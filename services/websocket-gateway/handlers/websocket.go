@@ -1,183 +1,125 @@
 package handlers
 
 import (
-	"log"
 	"net/http"
-	"time"
+	"strconv"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
+	"chorus/pkg/apierror"
+	"chorus/pkg/auth"
+	"chorus/pkg/cors"
+	"chorus/pkg/logging"
+	"chorus/websocket-gateway/hub"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// TODO: Implement proper origin checking in production
-		return true
-	},
+// UpgradeOptions configures how connections are upgraded to WebSocket
+type UpgradeOptions struct {
+	AllowedOrigins       []string // "scheme://host[:port]" or "scheme://*.domain[:port]", none allowing any origin
+	Compression          bool     // negotiate permessage-deflate with clients offering it
+	CompressionThreshold int      // smallest frame compressed, in bytes
 }
 
-const (
-	// Time allowed to write a message to the peer
-	writeWait = 10 * time.Second
+// Longest device name or ID reported to the presence service, as it accepts
+const maxDeviceLength = 64
 
-	// Time allowed to read the next pong message from the peer
-	pongWait = 60 * time.Second
-
-	// Send pings to peer with this period. Must be less than pongWait
-	pingPeriod = (pongWait * 9) / 10
-
-	// Maximum message size allowed from peer
-	maxMessageSize = 512
-)
-
-type Client struct {
-	conn   *websocket.Conn
-	send   chan []byte
-	userID string
+type WebSocketHandler struct {
+	hub                  *hub.Hub
+	logger               *logging.Logger
+	origins              *cors.Policy
+	upgrader             websocket.Upgrader
+	compressionThreshold int
 }
 
-type Hub struct {
-	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
-}
-
-var hub = &Hub{
-	clients:    make(map[*Client]bool),
-	broadcast:  make(chan []byte),
-	register:   make(chan *Client),
-	unregister: make(chan *Client),
-}
-
-func init() {
-	go hub.run()
-}
-
-func (h *Hub) run() {
-	for {
-		select {
-		case client := <-h.register:
-			h.clients[client] = true
-			log.Printf("Client registered: %s", client.userID)
-
-		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-				log.Printf("Client unregistered: %s", client.userID)
-			}
-
-		case message := <-h.broadcast:
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					delete(h.clients, client)
-					close(client.send)
-				}
-			}
-		}
+func NewWebSocketHandler(h *hub.Hub, logger *logging.Logger, options UpgradeOptions) *WebSocketHandler {
+	return &WebSocketHandler{
+		hub:     h,
+		logger:  logger,
+		origins: cors.New(cors.Config{AllowedOrigins: options.AllowedOrigins}),
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:    1024,
+			WriteBufferSize:   1024,
+			EnableCompression: options.Compression,
+			Subprotocols:      hub.Subprotocols,
+			// The origin was checked before upgrading, to answer with 403
+			CheckOrigin: func(r *http.Request) bool { return true },
+			Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+				apierror.Write(w, r, status, reason.Error(), nil)
+			},
+		},
+		compressionThreshold: options.CompressionThreshold,
 	}
 }
 
-func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+func (wh *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context (set by JWT middleware)
 	userID, ok := r.Context().Value("userID").(string)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		apierror.Write(w, r, http.StatusUnauthorized, "Unauthorized", nil)
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("Failed to upgrade connection: %v", err)
+	// Browsers always send their page's origin; other clients need not send any
+	if origin := r.Header.Get("Origin"); origin != "" && !wh.origins.AllowsOrigin(origin) {
+		wh.logger.WarnContext(r.Context(), "Refused WebSocket upgrade", "reason", "origin_not_allowed", "origin", origin, "user_id", userID, "remote", r.RemoteAddr)
+		apierror.Write(w, r, http.StatusForbidden, "Origin not allowed", nil)
 		return
 	}
 
-	client := &Client{
-		conn:   conn,
-		send:   make(chan []byte, 256),
-		userID: userID,
+	// Send clients to the other instances while draining or shutting down
+	if wh.hub.Draining() {
+		w.Header().Set("Connection", "close")
+		apierror.Write(w, r, http.StatusServiceUnavailable, "Server is draining connections", nil)
+		return
 	}
 
-	hub.register <- client
-
-	// Start goroutines for reading and writing
-	go client.writePump()
-	go client.readPump()
-}
-
-func (c *Client) readPump() {
-	defer func() {
-		hub.unregister <- c
-		c.conn.Close()
-	}()
-
-	c.conn.SetReadLimit(maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
-		return nil
-	})
-
-	for {
-		_, message, err := c.conn.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
-			}
-			break
+	// Sequence number of the last direct message the client saw before reconnecting
+	var resume int64 = -1
+	if value := r.URL.Query().Get("resume"); value != "" {
+		seq, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seq < 0 {
+			apierror.Write(w, r, http.StatusBadRequest, "Invalid resume parameter", nil)
+			return
 		}
-
-		// Process message
-		log.Printf("Message from %s: %s", c.userID, message)
-		
-		// Broadcast message to all clients (you can implement room-based logic here)
-		hub.broadcast <- message
+		resume = seq
 	}
-}
 
-func (c *Client) writePump() {
-	ticker := time.NewTicker(pingPeriod)
-	defer func() {
-		ticker.Stop()
-		c.conn.Close()
-	}()
-
-	for {
-		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// The hub closed the channel
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			// Add queued messages to the current websocket message
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.send)
-			}
+	// The device connecting, as a session of the presence service; the token's
+	// device_id claim takes precedence over the query
+	claims, _ := r.Context().Value("claims").(map[string]any)
+	device := r.URL.Query().Get("device")
+	deviceID, _ := claims["device_id"].(string)
+	if deviceID == "" {
+		deviceID = r.URL.Query().Get("device_id")
+	}
+	if utf8.RuneCountInString(device) > maxDeviceLength || utf8.RuneCountInString(deviceID) > maxDeviceLength {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid device parameter", nil)
+		return
+	}
 
-			if err := w.Close(); err != nil {
-				return
-			}
+	conn, err := wh.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		wh.logger.WarnContext(r.Context(), "Failed to upgrade connection", "user_id", userID, "error", err)
+		return
+	}
 
-		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				return
-			}
-		}
+	client := hub.NewClient(wh.hub, conn, userID, auth.BearerToken(r), claims)
+	// The connection's ID correlates its log lines from here on with this request's
+	wh.logger.InfoContext(r.Context(), "Connection opened",
+		"connection_id", client.ID(),
+		"user_id", userID,
+		"device", device,
+		"device_id", deviceID,
+		"origin", r.Header.Get("Origin"),
+		"remote", r.RemoteAddr,
+		"subprotocol", conn.Subprotocol(),
+	)
+	if resume >= 0 {
+		client.ResumeFrom(resume)
 	}
-}
\ No newline at end of file
+	client.SetDevice(device, deviceID)
+	if wh.upgrader.EnableCompression {
+		client.CompressAbove(wh.compressionThreshold)
+	}
+	client.Serve()
+}
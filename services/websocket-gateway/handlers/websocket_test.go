@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+
+	"chorus/pkg/logging"
+	"chorus/websocket-gateway/hub"
+)
+
+func testLogger() *logging.Logger {
+	return &logging.Logger{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+}
+
+// newTestHub runs a hub on an in-memory Redis
+func newTestHub(t *testing.T) *hub.Hub {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	h := hub.NewHub(client, "gateway-test", 0, testLogger())
+	go h.Run()
+	return h
+}
+
+// upgradeRequest is a WebSocket upgrade of user, as authenticated by JWTAuth
+func upgradeRequest(user, origin string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	return req.WithContext(context.WithValue(req.Context(), "userID", user))
+}
+
+func TestUpgradeRefusedWhileShuttingDown(t *testing.T) {
+	h := newTestHub(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := h.Shutdown(ctx, 0); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	NewWebSocketHandler(h, testLogger(), UpgradeOptions{}).ServeHTTP(rec, upgradeRequest("alice", ""))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("upgrade while shutting down: got %d, want 503", rec.Code)
+	}
+	if got := rec.Header().Get("Connection"); got != "close" {
+		t.Errorf("Connection = %q, want close", got)
+	}
+}
+
+func TestUpgradeOriginMatching(t *testing.T) {
+	h := newTestHub(t)
+	handler := NewWebSocketHandler(h, testLogger(), UpgradeOptions{AllowedOrigins: []string{
+		"https://app.example.com",
+		"http://localhost:3000",
+		"https://*.example.org",
+		"https://*.example.net:8443",
+	}})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "userID", "alice")))
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"", true}, // not a browser
+		{"https://app.example.com", true},
+		{"HTTPS://App.Example.com", true},
+		{"http://app.example.com", false},          // other scheme
+		{"https://app.example.com:443", false},     // explicit port, even the default one
+		{"https://app.example.com:8443", false},    // other port
+		{"https://app.example.com.evil.io", false}, // suffix of another host
+		{"https://example.com", false},
+		{"http://localhost:3000", true},
+		{"http://localhost", false},
+		{"http://localhost:3001", false},
+		{"https://localhost:3000", false},
+		{"https://a.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false}, // the wildcard needs a subdomain
+		{"http://a.example.org", false},
+		{"https://a.example.org:8443", false},
+		{"https://evil.io/.example.org", false},
+		{"https://a.example.net:8443", true},
+		{"https://a.example.net", false},
+		{"https://a.example.net:9443", false},
+		{"wss://a.example.net:8443", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			header := http.Header{}
+			if tt.origin != "" {
+				header.Set("Origin", tt.origin)
+			}
+			conn, resp, err := websocket.DefaultDialer.Dial(wsURL, header)
+			if conn != nil {
+				conn.Close()
+			}
+			switch {
+			case tt.allowed && err != nil:
+				t.Errorf("upgrade refused: %v", err)
+			case !tt.allowed && (resp == nil || resp.StatusCode != http.StatusForbidden):
+				t.Errorf("upgrade not refused with 403: %v", err)
+			}
+		})
+	}
+}
+
+func TestBroadcastToChannelWithoutMembers(t *testing.T) {
+	h := newTestHub(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/channels/{name}/broadcast", NewChannelHandler(h, testLogger()).Broadcast)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/channels/room.1/broadcast?wait_ms=200", strings.NewReader(`{"text":"anyone?"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("broadcast: got %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	var resp BroadcastResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := BroadcastResponse{
+		Channel: "room.1",
+		Relayed: true,
+		Delivery: hub.Delivery{
+			Instances: 1,
+			Complete:  true,
+			NoMembers: true,
+		},
+	}
+	if resp != want {
+		t.Errorf("response = %+v, want %+v", resp, want)
+	}
+	// The report is part of the schema callers rely on
+	for _, field := range []string{`"no_members":true`, `"recipients":0`, `"complete":true`} {
+		if !strings.Contains(rec.Body.String(), field) {
+			t.Errorf("response %s lacks %s", rec.Body, field)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package hub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// Hash per user of the direct messages they did not acknowledge in time, by
+	// message ID, as JSON PendingMessage
+	pendingKeyPrefix = "gateway_pending:"
+
+	// Operations on the pending lists queued before they are dropped
+	pendingOpBuffer = 1024
+)
+
+// Events of messages requiring an ack, as labeled in gateway_ack_messages_total
+const (
+	ackEventAcked    = "acked"
+	ackEventRetried  = "retried"
+	ackEventParked   = "parked"
+	ackEventReplayed = "replayed"
+)
+
+// Ack configures the delivery of messages requiring an ack
+type Ack struct {
+	Timeout    time.Duration // how long a client has to ack before the message is sent again
+	MaxRetries int           // times a message is sent again before it is parked
+	PendingTTL time.Duration // how long parked messages are kept for the user to reconnect
+}
+
+// PendingMessage is a direct message parked until its user reconnects
+type PendingMessage struct {
+	ID       string          `json:"id"`
+	Data     json.RawMessage `json:"data"`
+	ParkedAt time.Time       `json:"parked_at"`
+}
+
+// unackedMessage is a message requiring an ack sent to the local connections of a
+// user, owned by the hub goroutine
+type unackedMessage struct {
+	data     []byte // the message, to park it
+	frame    []byte // the direct frame carrying it, to send it again
+	attempts int
+	deadline time.Time
+	parkedAt time.Time // set if replayed from the pending list, so removed from it once acked
+}
+
+// Operations on the pending lists, run by RunPending off the hub goroutine
+const (
+	pendingPark = iota
+	pendingRemove
+	pendingReplay
+)
+
+type pendingOp struct {
+	kind    int
+	userID  string
+	message PendingMessage
+}
+
+// SetAck sets how messages requiring an ack are retried and parked
+func (h *Hub) SetAck(ack Ack) {
+	h.ack = ack
+}
+
+// newMessageID returns a random ID for a message requiring an ack
+func newMessageID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// track starts waiting for an ack of a message just sent to the user's connections,
+// from the hub goroutine. It reports false for a replayed message already waiting,
+// which should not be sent twice.
+func (h *Hub) track(userID, id string, data, frame []byte, parkedAt time.Time) bool {
+	messages, ok := h.unacked[userID]
+	if !ok {
+		messages = make(map[string]*unackedMessage)
+		h.unacked[userID] = messages
+	}
+	if _, ok := messages[id]; ok && !parkedAt.IsZero() {
+		return false
+	}
+	messages[id] = &unackedMessage{
+		data:     data,
+		frame:    frame,
+		attempts: 1,
+		deadline: time.Now().Add(h.ack.Timeout),
+		parkedAt: parkedAt,
+	}
+	return true
+}
+
+// acknowledge stops waiting for the message the client acked, from the hub goroutine
+func (h *Hub) acknowledge(client *Client, id string) {
+	messages := h.unacked[client.userID]
+	message, ok := messages[id]
+	if !ok {
+		// Acked by another connection of the user already, or unknown
+		return
+	}
+	delete(messages, id)
+	if len(messages) == 0 {
+		delete(h.unacked, client.userID)
+	}
+	ackMessagesTotal.Inc(ackEventAcked)
+	if !message.parkedAt.IsZero() {
+		h.queuePending(pendingOp{kind: pendingRemove, userID: client.userID, message: PendingMessage{ID: id}})
+	}
+}
+
+// retryUnacked sends the messages whose ack is overdue again, or parks those out of
+// retries, from the hub goroutine
+func (h *Hub) retryUnacked(now time.Time) {
+	for userID, messages := range h.unacked {
+		for id, message := range messages {
+			if now.Before(message.deadline) {
+				continue
+			}
+			if message.attempts > h.ack.MaxRetries {
+				h.logger.Info("Parking unacknowledged message", "user_id", userID, "message_id", id, "attempts", message.attempts)
+				h.park(userID, id, message)
+				delete(messages, id)
+				continue
+			}
+
+			message.attempts++
+			message.deadline = now.Add(h.ack.Timeout)
+			ackMessagesTotal.Inc(ackEventRetried)
+			rule := QueueRule{Policy: QueueDisconnect}
+			for client := range h.users[userID] {
+				h.enqueue(client, outbound{data: message.frame}, rule)
+			}
+		}
+		if len(messages) == 0 {
+			delete(h.unacked, userID)
+		}
+	}
+}
+
+// parkAll parks every message still awaiting an ack from the user, whose last
+// connection to this instance closed, from the hub goroutine
+func (h *Hub) parkAll(userID string) {
+	for id, message := range h.unacked[userID] {
+		h.park(userID, id, message)
+	}
+	delete(h.unacked, userID)
+}
+
+// park queues the message for the user's pending list. A message parked before keeps
+// its original time, so it still expires after the pending TTL.
+func (h *Hub) park(userID, id string, message *unackedMessage) {
+	parkedAt := message.parkedAt
+	if parkedAt.IsZero() {
+		parkedAt = time.Now()
+	}
+	ackMessagesTotal.Inc(ackEventParked)
+	h.queuePending(pendingOp{
+		kind:   pendingPark,
+		userID: userID,
+		message: PendingMessage{
+			ID:       id,
+			Data:     message.data,
+			ParkedAt: parkedAt,
+		},
+	})
+}
+
+// queuePending queues an operation on the pending lists without blocking the hub
+// goroutine
+func (h *Hub) queuePending(op pendingOp) {
+	select {
+	case h.pendingOps <- op:
+	default:
+		h.logger.Warn("Pending list falling behind, dropped update", "user_id", op.userID)
+	}
+}
+
+// ParkMessage adds a message requiring an ack to the user's pending list, to be
+// replayed when they connect
+func (h *Hub) ParkMessage(ctx context.Context, userID string, message PendingMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	key := pendingKeyPrefix + userID
+	pipe := h.redis.TxPipeline()
+	pipe.HSet(ctx, key, message.ID, data)
+	pipe.Expire(ctx, key, h.ack.PendingTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to park message: %w", err)
+	}
+	return nil
+}
+
+// PendingMessages returns the user's parked messages, oldest first
+func (h *Hub) PendingMessages(ctx context.Context, userID string) ([]PendingMessage, error) {
+	entries, err := h.redis.HGetAll(ctx, pendingKeyPrefix+userID).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending messages: %w", err)
+	}
+
+	// The key's TTL is refreshed by each park, so older entries may outlive theirs
+	cutoff := time.Now().Add(-h.ack.PendingTTL)
+	messages := make([]PendingMessage, 0, len(entries))
+	for id, data := range entries {
+		var message PendingMessage
+		if err := json.Unmarshal([]byte(data), &message); err != nil {
+			h.logger.Error("Failed to unmarshal pending message", "user_id", userID, "message_id", id, "error", err)
+			continue
+		}
+		if message.ParkedAt.Before(cutoff) {
+			continue
+		}
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ParkedAt.Before(messages[j].ParkedAt)
+	})
+	return messages, nil
+}
+
+// RunPending parks unacknowledged messages, removes acknowledged ones and replays
+// the pending list of users as they connect. It blocks until ctx is cancelled,
+// then parks what is still queued, such as the messages of connections closed at
+// shutdown.
+func (h *Hub) RunPending(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			drainCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for {
+				select {
+				case op := <-h.pendingOps:
+					if op.kind != pendingReplay {
+						h.applyPending(drainCtx, op)
+					}
+				default:
+					return
+				}
+			}
+
+		case op := <-h.pendingOps:
+			h.applyPending(ctx, op)
+		}
+	}
+}
+
+func (h *Hub) applyPending(ctx context.Context, op pendingOp) {
+	switch op.kind {
+	case pendingPark:
+		if err := h.ParkMessage(ctx, op.userID, op.message); err != nil {
+			h.logger.Error("Failed to park message", "user_id", op.userID, "message_id", op.message.ID, "error", err)
+		}
+
+	case pendingRemove:
+		if err := h.redis.HDel(ctx, pendingKeyPrefix+op.userID, op.message.ID).Err(); err != nil {
+			h.logger.Error("Failed to remove pending message", "user_id", op.userID, "message_id", op.message.ID, "error", err)
+		}
+
+	case pendingReplay:
+		messages, err := h.PendingMessages(ctx, op.userID)
+		if err != nil {
+			h.logger.Error("Failed to replay pending messages", "user_id", op.userID, "error", err)
+			return
+		}
+		// Replayed messages stay pending until acked
+		for _, message := range messages {
+			if h.deliverDirect(publication{
+				userID:   op.userID,
+				message:  message.Data,
+				id:       message.ID,
+				parkedAt: message.ParkedAt,
+			}) > 0 {
+				ackMessagesTotal.Inc(ackEventReplayed)
+			}
+		}
+	}
+}
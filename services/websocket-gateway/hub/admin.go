@@ -0,0 +1,301 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"chorus/pkg/eventbus"
+)
+
+const (
+	// Redis channel carrying adminRequest between gateway instances
+	AdminChannel = "gateway:admin"
+
+	// Lists instances push their adminReply to, followed by a request ID
+	adminReplyKeyPrefix = "gateway_admin_reply:"
+
+	// Time allowed for other instances to answer an admin request
+	adminTimeout = 3 * time.Second
+
+	// Close code of connections closed through the admin API
+	CloseDisconnected = 4000
+
+	// Longest reason of a disconnect, so the close frame stays within 125 bytes
+	MaxDisconnectReasonLength = 64
+)
+
+// adminEvents is the event bus topic of AdminChannel
+var adminEvents = eventbus.Topic[adminRequest]{Name: AdminChannel, Version: 1}
+
+var ErrConnectionNotFound = errors.New("connection not found")
+
+// Admin operations
+const (
+	adminList       = "list"
+	adminDisconnect = "disconnect"
+)
+
+// ConnectionInfo describes an open connection for the admin API
+type ConnectionInfo struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	Instance    string    `json:"instance"`
+	ConnectedAt time.Time `json:"connected_at"`
+	RemoteAddr  string    `json:"remote_addr"`
+	Device      string    `json:"device,omitempty"`
+	Channels    []string  `json:"channels"`
+	QueueDepth  int       `json:"queue_depth"` // frames waiting to be written
+}
+
+// AdminResult is what an admin operation found on the instances it reached
+type AdminResult struct {
+	Connections []ConnectionInfo // listed, or closed
+	Unanswered  []string         // instances that did not answer in time
+}
+
+// adminRequest is an admin operation relayed to the instances holding the
+// connections it targets, which push their adminReply to ReplyTo
+type adminRequest struct {
+	Origin       string   `json:"origin"`
+	Instances    []string `json:"instances"` // expected to answer
+	ReplyTo      string   `json:"reply_to"`
+	Op           string   `json:"op"`
+	UserID       string   `json:"user_id,omitempty"`
+	ConnectionID string   `json:"connection_id,omitempty"`
+	Reason       string   `json:"reason,omitempty"`
+	Actor        string   `json:"actor,omitempty"` // who disconnects, for the log
+}
+
+type adminReply struct {
+	Instance    string           `json:"instance"`
+	Connections []ConnectionInfo `json:"connections"`
+}
+
+// adminOp is an admin request for the hub goroutine
+type adminOp struct {
+	request adminRequest
+	reply   chan []ConnectionInfo
+}
+
+// Connections lists the connections of the user on every instance the registry
+// lists for them
+func (h *Hub) Connections(ctx context.Context, userID string) (AdminResult, error) {
+	return h.administer(ctx, adminRequest{Op: adminList, UserID: userID})
+}
+
+// Disconnect closes a connection, on whichever instance holds it, with a
+// CloseDisconnected frame carrying reason, and logs actor as who closed it. It
+// returns ErrConnectionNotFound if the instance answered without it.
+func (h *Hub) Disconnect(ctx context.Context, connectionID, reason, actor string) (AdminResult, error) {
+	result, err := h.administer(ctx, adminRequest{Op: adminDisconnect, ConnectionID: connectionID, Reason: reason, Actor: actor})
+	if err == nil && len(result.Connections) == 0 && len(result.Unanswered) == 0 {
+		return result, ErrConnectionNotFound
+	}
+	return result, err
+}
+
+// DisconnectUser closes every connection of the user, as Disconnect does
+func (h *Hub) DisconnectUser(ctx context.Context, userID, reason, actor string) (AdminResult, error) {
+	return h.administer(ctx, adminRequest{Op: adminDisconnect, UserID: userID, Reason: reason, Actor: actor})
+}
+
+// administer applies req here and relays it to the other instances it targets:
+// the instance in the connection ID, or those the registry lists for the user
+func (h *Hub) administer(ctx context.Context, req adminRequest) (AdminResult, error) {
+	var result AdminResult
+	var instances []string
+	if req.ConnectionID != "" {
+		instance, ok := connectionInstance(req.ConnectionID)
+		if !ok {
+			return result, ErrConnectionNotFound
+		}
+		instances = []string{instance}
+	} else {
+		registered, err := h.userInstances(ctx, req.UserID)
+		if err != nil {
+			return result, err
+		}
+		// The registry may lag behind this instance's own connections
+		instances = append(registered, h.instanceID)
+	}
+
+	local := slices.Contains(instances, h.instanceID)
+	var remote []string
+	for _, instance := range instances {
+		if instance != h.instanceID && !slices.Contains(remote, instance) {
+			remote = append(remote, instance)
+		}
+	}
+	if len(remote) > 0 {
+		req.Origin = h.instanceID
+		req.Instances = remote
+		req.ReplyTo = adminReplyKeyPrefix + newMessageID()
+		if err := eventbus.Publish(ctx, h.events, adminEvents, req); err != nil {
+			return result, fmt.Errorf("failed to relay admin request: %w", err)
+		}
+	}
+
+	if local {
+		result.Connections = h.administerLocal(req)
+	}
+	if len(remote) > 0 {
+		connections, unanswered, err := h.adminReplies(ctx, req.ReplyTo, remote)
+		result.Connections = append(result.Connections, connections...)
+		result.Unanswered = unanswered
+		if err != nil {
+			return result, err
+		}
+	}
+	sort.Slice(result.Connections, func(i, j int) bool {
+		return result.Connections[i].ConnectedAt.Before(result.Connections[j].ConnectedAt)
+	})
+	return result, nil
+}
+
+// adminReplies collects the connections the instances push to key, until all of them
+// answered or adminTimeout passed, and returns those that did not answer
+func (h *Hub) adminReplies(ctx context.Context, key string, instances []string) ([]ConnectionInfo, []string, error) {
+	defer h.redis.Del(context.WithoutCancel(ctx), key)
+
+	pending := make(map[string]bool, len(instances))
+	for _, instance := range instances {
+		pending[instance] = true
+	}
+	var connections []ConnectionInfo
+	deadline := time.Now().Add(adminTimeout)
+	for len(pending) > 0 {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			break
+		}
+		// Redis blocks for whole seconds
+		values, err := h.redis.BLPop(ctx, max(wait.Truncate(time.Second), time.Second), key).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				break
+			}
+			return connections, sortedKeys(pending), fmt.Errorf("failed to read admin replies: %w", err)
+		}
+
+		var reply adminReply
+		if err := json.Unmarshal([]byte(values[1]), &reply); err != nil {
+			h.logger.Error("Failed to unmarshal admin reply", "error", err)
+			continue
+		}
+		if pending[reply.Instance] {
+			delete(pending, reply.Instance)
+			connections = append(connections, reply.Connections...)
+		}
+	}
+	return connections, sortedKeys(pending), nil
+}
+
+// answerAdmin applies an admin request relayed by another instance and pushes the
+// reply, off the relay goroutine
+func (h *Hub) answerAdmin(req adminRequest) {
+	if req.Origin == h.instanceID || !slices.Contains(req.Instances, h.instanceID) {
+		return
+	}
+
+	data, err := json.Marshal(adminReply{Instance: h.instanceID, Connections: h.administerLocal(req)})
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), adminTimeout)
+	defer cancel()
+	pipe := h.redis.Pipeline()
+	pipe.RPush(ctx, req.ReplyTo, data)
+	// The requester deletes the list, unless it gave up waiting
+	pipe.Expire(ctx, req.ReplyTo, 2*adminTimeout)
+	if _, err := pipe.Exec(ctx); err != nil {
+		h.logger.Error("Failed to answer admin request", "origin", req.Origin, "error", err)
+	}
+}
+
+// administerLocal applies req to this instance's connections on the hub goroutine
+func (h *Hub) administerLocal(req adminRequest) []ConnectionInfo {
+	op := adminOp{request: req, reply: make(chan []ConnectionInfo, 1)}
+	h.admin <- op
+	return <-op.reply
+}
+
+// applyAdmin lists the local connections an admin request targets, closing them for
+// a disconnect, from the hub goroutine
+func (h *Hub) applyAdmin(req adminRequest) []ConnectionInfo {
+	var targets []*Client
+	if req.ConnectionID != "" {
+		for client := range h.clients {
+			if client.id == req.ConnectionID {
+				targets = append(targets, client)
+			}
+		}
+	} else {
+		for client := range h.users[req.UserID] {
+			targets = append(targets, client)
+		}
+	}
+
+	connections := make([]ConnectionInfo, 0, len(targets))
+	for _, client := range targets {
+		connections = append(connections, client.info())
+		if req.Op != adminDisconnect {
+			continue
+		}
+		client.logger.Info("Connection disconnected by admin", "actor", req.Actor, "reason", req.Reason)
+		client.closedFor(closeDisconnected)
+		client.closeWith(CloseDisconnected, req.Reason, policyCloseWait)
+		h.remove(client)
+	}
+	return connections
+}
+
+// info describes the client, from the hub goroutine
+func (c *Client) info() ConnectionInfo {
+	channels := make([]string, 0, len(c.channels))
+	for channel := range c.channels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return ConnectionInfo{
+		ID:          c.id,
+		UserID:      c.userID,
+		Instance:    c.hub.instanceID,
+		ConnectedAt: c.connectedAt,
+		RemoteAddr:  c.conn.RemoteAddr().String(),
+		Device:      c.device,
+		Channels:    channels,
+		QueueDepth:  c.queue.len(),
+	}
+}
+
+// newConnectionID returns an ID naming the instance holding the connection, so admin
+// requests for it go there only
+func (h *Hub) newConnectionID() string {
+	return h.instanceID + ":" + newMessageID()
+}
+
+// connectionInstance returns the instance in a connection ID
+func connectionInstance(connectionID string) (string, bool) {
+	i := strings.LastIndex(connectionID, ":")
+	if i <= 0 || i == len(connectionID)-1 {
+		return "", false
+	}
+	return connectionID[:i], true
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,115 @@
+package hub
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+var ErrChannelForbidden = errors.New("not allowed to use this channel")
+
+// ChannelRule allows the channels matching Pattern to users whose claims satisfy it.
+// Pattern segments are separated by ':'. A segment "{claim}" matches only the value
+// of that JWT claim, such as "user:{user_id}:*", and a final "*" matches one or more
+// segments. With Roles, the role claim must also be one of them.
+type ChannelRule struct {
+	Pattern string
+	Roles   []string
+}
+
+// channelRule is a ChannelRule split into segments
+type channelRule struct {
+	ChannelRule
+	segments []string
+}
+
+// SetChannelRules sets who may join and broadcast to which channels; the first rule
+// whose pattern matches a channel decides, and channels matching none are refused.
+// Without rules every channel is allowed.
+func (h *Hub) SetChannelRules(rules []ChannelRule) {
+	h.channelRules = make([]channelRule, 0, len(rules))
+	for _, rule := range rules {
+		h.channelRules = append(h.channelRules, channelRule{
+			ChannelRule: rule,
+			segments:    strings.Split(rule.Pattern, ":"),
+		})
+	}
+}
+
+// AuthorizeChannel returns ErrChannelForbidden unless the rules allow the channel to
+// a user with claims. It returns the pattern of the rule that decided, "" if none did.
+func (h *Hub) AuthorizeChannel(channel string, claims map[string]any) (string, error) {
+	if h.channelRules == nil {
+		return "", nil
+	}
+
+	segments := strings.Split(channel, ":")
+	for _, rule := range h.channelRules {
+		if !rule.matches(segments) {
+			continue
+		}
+		if !rule.satisfiedBy(segments, claims) {
+			return rule.Pattern, ErrChannelForbidden
+		}
+		return rule.Pattern, nil
+	}
+	return "", ErrChannelForbidden
+}
+
+// ChannelUser returns the user a channel belongs to: its segment in place of
+// "{user_id}" in the first rule matching it, "" if that rule has none
+func (h *Hub) ChannelUser(channel string) string {
+	segments := strings.Split(channel, ":")
+	for _, rule := range h.channelRules {
+		if !rule.matches(segments) {
+			continue
+		}
+		for i, pattern := range rule.segments {
+			if pattern == "{user_id}" {
+				return segments[i]
+			}
+		}
+		return ""
+	}
+	return ""
+}
+
+// matches reports whether the channel's segments fit the pattern, placeholders
+// matching any value
+func (r channelRule) matches(segments []string) bool {
+	for i, pattern := range r.segments {
+		if pattern == "*" && i == len(r.segments)-1 {
+			return len(segments) > i
+		}
+		if i >= len(segments) {
+			return false
+		}
+		if !isPlaceholder(pattern) && pattern != segments[i] {
+			return false
+		}
+	}
+	return len(segments) == len(r.segments)
+}
+
+// satisfiedBy reports whether claims fill the placeholders with the channel's values
+// and carry one of the rule's roles
+func (r channelRule) satisfiedBy(segments []string, claims map[string]any) bool {
+	for i, pattern := range r.segments {
+		if !isPlaceholder(pattern) {
+			continue
+		}
+		value, _ := claims[pattern[1:len(pattern)-1]].(string)
+		if value == "" || value != segments[i] {
+			return false
+		}
+	}
+	if len(r.Roles) > 0 {
+		role, _ := claims["role"].(string)
+		return slices.Contains(r.Roles, role)
+	}
+	return true
+}
+
+func isPlaceholder(segment string) bool {
+	return len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
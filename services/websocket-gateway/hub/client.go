@@ -0,0 +1,437 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chorus/pkg/logging"
+	"chorus/pkg/tracing"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// Time allowed to write a message to the peer
+	writeWait = 10 * time.Second
+
+	// Maximum message size allowed from peer, unless set by SetLimits
+	maxMessageSize = 512
+
+	// Frames queued for a client before it counts as too slow, unless set by
+	// SetSendQueue
+	sendBufferSize = 256
+)
+
+// Types of the frames a client may send
+const (
+	ActionJoin    = "join"
+	ActionLeave   = "leave"
+	ActionPublish = "publish" // sends data to the members of a joined channel
+	ActionAck     = "ack"     // acknowledges a direct message requiring it
+	ActionPing    = "ping"
+
+	ActionRefreshToken    = "refresh_token"    // replaces the connection's token before it expires
+	ActionWorkflowTrigger = "workflow.trigger" // creates a workflow instance as the user
+	ActionPresenceWatch   = "presence.watch"   // joins the presence channels of several users
+)
+
+// Types of the frames sent to clients
+const (
+	FrameJoined    = "joined"
+	FrameLeft      = "left"
+	FrameMessage   = "message"
+	FrameDirect    = "direct"   // a message sent to the user rather than a channel
+	FrameResync    = "resync"   // the messages a client resumed from are gone, so it must reload its state
+	FrameSnapshot  = "snapshot" // the current status of a workflow instance or the presence of a user joined
+	FramePublished = "published"
+	FramePong      = "pong"
+	FrameError     = "error"
+
+	FrameTokenExpiring  = "token_expiring" // the connection's token expires soon and should be refreshed
+	FrameTokenRefreshed = "token_refreshed"
+
+	FrameWorkflowTriggered = "workflow.triggered" // the instance a workflow.trigger created
+	FramePresenceWatching  = "presence.watching"  // the users a presence.watch follows, with their presence
+)
+
+// ClientFrame is a frame received from a client, validated by ParseFrame
+type ClientFrame struct {
+	Type      string
+	ID        string          // the client's ID for the frame
+	Channel   string          // of join, leave and publish
+	Resume    *int64          // of join
+	Snapshot  bool            // of join, asking for the status of a workflow instance
+	Data      json.RawMessage // of publish
+	MessageID string          // of ack
+	Token     string          // of refresh_token
+
+	// Of workflow.trigger
+	TemplateID string
+	Name       string
+	Variables  json.RawMessage
+	Subscribe  bool
+
+	UserIDs []string // of presence.watch
+
+	snapshot  json.RawMessage // status of the workflow instance or presence of the user joined, fetched for the client
+	watch     *presenceWatch  // users of presence.watch the client may watch, worked out for the client
+	refreshed map[string]any  // claims of the token of refresh_token, validated for the client
+	ctx       context.Context // of the frame's span, for the calls made on its behalf
+}
+
+// ServerFrame is a frame sent to a client: the outcome of one of its actions, or a
+// message published to one of its channels
+type ServerFrame struct {
+	V            int             `json:"v"`
+	Type         string          `json:"type"`
+	Channel      string          `json:"channel,omitempty"`
+	Seq          int64           `json:"seq,omitempty"`          // number of the message in its channel's or user's stream
+	ID           string          `json:"id,omitempty"`           // of a direct message requiring an ack, or of the client frame a reply answers
+	RequiresAck  bool            `json:"requires_ack,omitempty"` // the client must answer with an ack frame
+	Data         json.RawMessage `json:"data,omitempty"`
+	Code         string          `json:"code,omitempty"` // of an error
+	Error        string          `json:"error,omitempty"`
+	ExpiresAt    int64           `json:"expires_at,omitempty"`    // of the connection's token, in unix seconds, of token_expiring and token_refreshed
+	ConnectionID string          `json:"connection_id,omitempty"` // of error frames, for users to quote in bug reports
+	Offline      bool            `json:"offline,omitempty"`       // replayed from the user's offline queue
+	StoredAt     int64           `json:"stored_at,omitempty"`     // when an offline message was stored, in unix seconds
+}
+
+// Reasons connections are reaped, as labeled in gateway_connections_reaped_total
+const (
+	reapPongTimeout = "pong_timeout"
+	reapIdle        = "idle"
+)
+
+// Keepalive configures how connections are kept alive and reaped
+type Keepalive struct {
+	PingInterval time.Duration // how often the gateway pings
+	PongWait     time.Duration // how long without a pong, or any frame, before the connection counts as dead
+	IdleTimeout  time.Duration // how long without a frame from the client before it is closed, 0 never
+}
+
+// Client is one WebSocket connection of a user
+type Client struct {
+	hub    *Hub
+	conn   *websocket.Conn
+	queue  *sendQueue
+	id     string // names the instance, for the admin API, and correlates the connection's logs
+	userID string
+	logger *logging.Logger // the hub's, with the connection's ID and user
+
+	encoding string // of the frames sent to the client, negotiated as a subprotocol
+
+	tokenMu sync.Mutex
+	token   string         // the user's token, replaced by refresh_token
+	claims  map[string]any // of the user's token, for the channel rules
+
+	device   string // reported to the presence service, set by SetDevice
+	deviceID string
+
+	channels map[string]bool // joined channels, owned by the hub
+
+	resumeFrom *int64                      // sequence number of the last direct message seen, set by ResumeFrom
+	resuming   map[string][]sequencedFrame // live frames held back per stream being resumed, owned by the hub
+
+	replayingOffline bool        // while the user's offline queue is taken, owned by the hub
+	offlineHeld      []heldFrame // live frames held back meanwhile
+
+	lastFrame atomic.Int64 // when the client last sent a frame, in unix nanoseconds
+	reaped    atomic.Bool  // closed by the write pump, for being idle or its token expiring
+
+	expiresAt    atomic.Int64  // when the token expires, in unix seconds, 0 never
+	tokenChanged chan struct{} // signaled when the token is refreshed
+
+	closeReason atomic.Value // why the gateway closed the connection, set by closedFor
+	closeCode   atomic.Int64 // of the first close frame sent or received, set by closedWith
+
+	compressAbove int // smallest frame compressed if the client negotiated compression, 0 for all, set by CompressAbove
+
+	connectedAt time.Time
+	limiter     *tokenBucket // inbound messages of this connection
+	userLimiter *tokenBucket // inbound messages of all of the user's connections, set by Serve
+
+	triggerLimiter *tokenBucket // workflow.trigger frames of all of the user's connections, set by Serve
+
+	closeMessage []byte        // close frame to send when the hub closes the queue, set by the hub
+	closeWait    time.Duration // how long the client gets to answer closeMessage, the hub's close grace if 0
+	done         chan struct{} // closed when the read pump ends
+}
+
+func NewClient(hub *Hub, conn *websocket.Conn, userID, token string, claims map[string]any) *Client {
+	client := &Client{
+		hub:      hub,
+		conn:     conn,
+		token:    token,
+		claims:   claims,
+		queue:    newSendQueue(hub.sendQueueSize),
+		id:       hub.newConnectionID(),
+		encoding: encodingOf(conn.Subprotocol()),
+		userID:   userID,
+		channels: make(map[string]bool),
+
+		connectedAt: time.Now(),
+		limiter:     newTokenBucket(hub.limits.ConnectionRate, hub.limits.ConnectionBurst),
+
+		done:         make(chan struct{}),
+		tokenChanged: make(chan struct{}, 1),
+	}
+	client.logger = hub.logger.With("connection_id", client.id, "user_id", userID)
+	client.expiresAt.Store(tokenExpiry(claims))
+	return client
+}
+
+// ID returns the connection's ID, which its log lines and error frames carry
+func (c *Client) ID() string {
+	return c.id
+}
+
+// Serve registers the client with its hub and pumps frames until the connection
+// closes, after which the client leaves all of its channels
+func (c *Client) Serve() {
+	connectionsOpenedTotal.Inc()
+	c.hub.connections.Add(1)
+	shared := c.hub.acquireUserBucket(c.userID)
+	c.userLimiter, c.triggerLimiter = shared.bucket, shared.triggers
+	c.hub.register <- c
+
+	go c.writePump()
+	go c.readPump()
+}
+
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.unregister <- c
+		c.conn.Close()
+		c.closedFor(closeClient)
+		// No close frame either way means the connection dropped
+		c.closedWith(websocket.CloseAbnormalClosure)
+		reason := c.closeReason.Load().(string)
+		connectionsClosedTotal.Inc(reason)
+		c.logger.Info("Connection closed",
+			"reason", reason,
+			"close_code", c.closeCode.Load(),
+			"duration_ms", time.Since(c.connectedAt).Milliseconds(),
+		)
+		c.hub.releaseUserBucket(c.userID)
+		close(c.done)
+		c.hub.connections.Done()
+	}()
+
+	keepalive := c.hub.keepalive
+	c.lastFrame.Store(time.Now().UnixNano())
+	c.conn.SetReadLimit(c.hub.limits.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(keepalive.PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(keepalive.PongWait))
+		return nil
+	})
+
+	for {
+		messageType, message, err := c.conn.ReadMessage()
+		if err != nil {
+			var netErr net.Error
+			switch {
+			case c.reaped.Load():
+				// Closed for being idle
+			case errors.Is(err, websocket.ErrReadLimit):
+				// The connection already sent the 1009 close frame
+				c.closedFor(closeMessageTooLarge)
+				c.closedWith(websocket.CloseMessageTooBig)
+				limitViolationsTotal.Inc(limitMessageSize)
+				c.logger.Warn("Limit exceeded", "limit", limitMessageSize, "max_bytes", c.hub.limits.MaxMessageSize, "action", "close")
+			case errors.As(err, &netErr) && netErr.Timeout():
+				c.closedFor(closePongTimeout)
+				connectionsReapedTotal.Inc(reapPongTimeout)
+				c.logger.Info("Reaping connection: no pong", "pong_wait", keepalive.PongWait.String())
+			case websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.ClosePolicyViolation):
+				c.logger.Warn("WebSocket error", "error", err)
+			}
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				c.closedWith(closeErr.Code)
+			}
+			break
+		}
+		messagesReceivedTotal.Inc()
+		bytesReceivedTotal.Add(float64(len(message)))
+
+		// Any frame also shows the connection is alive
+		c.lastFrame.Store(time.Now().UnixNano())
+		c.conn.SetReadDeadline(time.Now().Add(keepalive.PongWait))
+
+		// Each frame is a trace of its own, ended by the hub once handled
+		ctx, span := tracing.Start(context.Background(), "ws frame", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("connection_id", c.id),
+			attribute.String("user_id", c.userID),
+		))
+
+		if !c.allowMessage(time.Now()) {
+			c.hub.commands <- command{client: c, err: &ProtocolError{Code: CodeRateLimited, Message: ErrRateLimited.Error()}, span: span}
+			continue
+		}
+
+		// Binary frames are MessagePack, whatever the client negotiated
+		var frame ClientFrame
+		var frameErr *ProtocolError
+		if messageType == websocket.BinaryMessage {
+			message, frameErr = msgPackToJSON(message)
+		}
+		if frameErr == nil {
+			frame, frameErr = ParseFrame(message)
+		}
+		if frameErr == nil {
+			span.SetName("ws " + frame.Type)
+		}
+		frame.ctx = ctx
+		if frameErr == nil && frame.Type == ActionJoin && WorkflowChannel(frame.Channel) {
+			// Waits on the workflow engine, holding up this connection's frames only
+			snapshot, err := c.hub.authorizeWorkflow(ctx, c, frame.Channel, frame.Snapshot)
+			if errors.Is(err, ErrChannelForbidden) {
+				c.logger.Info("Channel join denied", "channel", frame.Channel, "rule", "workflow")
+			}
+			if err != nil {
+				frameErr = frameErrorOf(err)
+			}
+			frame.snapshot = snapshot
+		}
+		if frameErr == nil && frame.Type == ActionJoin && PresenceChannel(frame.Channel) {
+			// Waits on the presence service and the authorization URL
+			userID := frame.Channel[len(PresenceChannelPrefix):]
+			watch, err := c.hub.authorizePresence(ctx, c, []string{userID})
+			switch {
+			case err != nil:
+				frameErr = frameErrorOf(err)
+			case len(watch.allowed) == 0:
+				frameErr = frameErrorOf(ErrChannelForbidden)
+			default:
+				frame.snapshot = watch.users[userID]
+			}
+		}
+		if frameErr == nil && frame.Type == ActionPresenceWatch {
+			watch, err := c.hub.authorizePresence(ctx, c, frame.UserIDs)
+			if err != nil {
+				frameErr = frameErrorOf(err)
+			}
+			frame.watch = watch
+		}
+		if frameErr == nil && frame.Type == ActionRefreshToken {
+			frame.refreshed, frameErr = c.hub.validateRefresh(c, frame.Token)
+		}
+		if frameErr == nil {
+			// Every frame, so only at debug
+			c.logger.DebugContext(ctx, "Frame received", "type", frame.Type, "id", frame.ID, "channel", frame.Channel, "bytes", len(message))
+		}
+		c.hub.commands <- command{client: c, frame: frame, err: frameErr, span: span}
+	}
+}
+
+// CompressAbove makes the client, before it is served, compress only frames of at
+// least size bytes, when it negotiated compression
+func (c *Client) CompressAbove(size int) {
+	c.compressAbove = size
+}
+
+func (c *Client) writePump() {
+	keepalive := c.hub.keepalive
+	ticker := time.NewTicker(keepalive.PingInterval)
+
+	// Fires when the token is about to expire, and when it expires
+	warned := false
+	token := time.NewTimer(0)
+	token.Stop()
+	resetToken := func() {
+		token.Stop()
+		if wait, ok := c.nextTokenEvent(warned); ok {
+			token.Reset(wait)
+		}
+	}
+	resetToken()
+
+	defer func() {
+		ticker.Stop()
+		token.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case <-c.queue.ready:
+			for {
+				frame, ok, closed := c.queue.pop()
+				if closed {
+					// The hub closed the queue
+					c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+					if c.closeMessage != nil {
+						c.closeGracefully()
+						return
+					}
+					c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+					return
+				}
+				if !ok {
+					break
+				}
+
+				// One frame per message, so clients can parse each on its own
+				messageType, message := websocket.TextMessage, frame.data
+				if c.encoding == EncodingMsgPack {
+					packed, err := frame.msgpack()
+					if err != nil {
+						c.logger.Error("Failed to encode frame as MessagePack", "error", err)
+						continue
+					}
+					messageType, message = websocket.BinaryMessage, packed
+				}
+
+				// Compressing small frames costs more than it saves
+				c.conn.EnableWriteCompression(len(message) >= c.compressAbove)
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := c.conn.WriteMessage(messageType, message); err != nil {
+					return
+				}
+				messagesSentTotal.Inc()
+				bytesSentTotal.Add(float64(len(message)))
+			}
+
+		case <-c.tokenChanged:
+			warned = false
+			resetToken()
+
+		case <-token.C:
+			if c.tokenEvent(&warned) {
+				return
+			}
+			if time.Now().Unix() < c.expiresAt.Load() {
+				resetToken()
+			}
+
+		case <-ticker.C:
+			idle := time.Since(time.Unix(0, c.lastFrame.Load()))
+			if keepalive.IdleTimeout > 0 && idle >= keepalive.IdleTimeout {
+				c.reaped.Store(true)
+				c.closedFor(closeIdle)
+				c.closedWith(websocket.CloseNormalClosure)
+				connectionsReapedTotal.Inc(reapIdle)
+				c.logger.Info("Reaping connection: idle", "idle", idle.Round(time.Second).String())
+				c.conn.WriteControl(
+					websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, "idle timeout"),
+					time.Now().Add(writeWait),
+				)
+				return
+			}
+
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Lists instances push their deliveryReply to, followed by a message ID
+	deliveryReplyKeyPrefix = "gateway_delivery_reply:"
+
+	// Longest a publisher waits for the other instances to report a broadcast's
+	// delivery, below the Redis client's read timeout
+	MaxDeliveryWait = 2 * time.Second
+)
+
+// Delivery reports what became of a message published to a channel on the instances
+// that reported it. Each member connection is counted once, as delivered, queued or
+// dropped.
+type Delivery struct {
+	Delivered  int  `json:"delivered"`  // connections it was queued for with nothing ahead of it
+	Queued     int  `json:"queued"`     // connections it was queued for behind other frames, or held back while they resume
+	Dropped    int  `json:"dropped"`    // connections that missed it under their slow consumer policy
+	Members    int  `json:"members"`    // connections that had joined the channel
+	NoMembers  bool `json:"no_members"` // whether every instance reported and none had a member
+	Instances  int  `json:"instances"`  // instances that reported, this one included
+	Unanswered int  `json:"unanswered"` // instances relayed to that did not report in time
+	Complete   bool `json:"complete"`   // whether every instance relayed to reported
+}
+
+// deliveryReply is an instance's Delivery of a relayed message, pushed to its ReplyTo
+type deliveryReply struct {
+	Instance string   `json:"instance"`
+	Delivery Delivery `json:"delivery"`
+}
+
+// Recipients returns how many connections the message was queued for
+func (d Delivery) Recipients() int {
+	return d.Delivered + d.Queued
+}
+
+// add counts the connections of another instance's delivery
+func (d *Delivery) add(other Delivery) {
+	d.Delivered += other.Delivered
+	d.Queued += other.Queued
+	d.Dropped += other.Dropped
+	d.Members += other.Members
+	d.Instances++
+}
+
+// deliverCounted queues a live frame of stream for the client, as deliverLive does,
+// counting in d what became of it
+func (h *Hub) deliverCounted(d *Delivery, client *Client, stream string, seq int64, frame outbound, rule QueueRule) {
+	_, resuming := client.resuming[stream]
+	waiting := client.replayingOffline || resuming || client.queue.len() > 0
+
+	d.Members++
+	switch {
+	case !h.deliverLive(client, stream, seq, frame, rule):
+		d.Dropped++
+	case waiting:
+		d.Queued++
+	default:
+		d.Delivered++
+	}
+}
+
+// deliveryReplies adds to d the deliveries the instances push to key, until
+// expected of them reported or wait passed, and counts the others as unanswered
+func (h *Hub) deliveryReplies(ctx context.Context, d *Delivery, key string, expected int, wait time.Duration) error {
+	defer h.redis.Del(context.WithoutCancel(ctx), key)
+
+	reported := make(map[string]bool, expected)
+	defer func() { d.Unanswered = expected - len(reported) }()
+	deadline := time.Now().Add(wait)
+	for len(reported) < expected {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			break
+		}
+		// BLPop of the client blocks for whole seconds, Redis itself to the millisecond
+		timeout := strconv.FormatFloat(max(wait.Seconds(), 0.001), 'f', 3, 64)
+		values, err := h.redis.Do(ctx, "blpop", key, timeout).StringSlice()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				break
+			}
+			return fmt.Errorf("failed to read delivery reports: %w", err)
+		}
+
+		var reply deliveryReply
+		if err := json.Unmarshal([]byte(values[1]), &reply); err != nil {
+			h.logger.Error("Failed to unmarshal delivery report", "error", err)
+			continue
+		}
+		if !reported[reply.Instance] {
+			reported[reply.Instance] = true
+			d.add(reply.Delivery)
+		}
+	}
+	return nil
+}
+
+// reportDelivery pushes this instance's delivery of a relayed message to the list
+// its publisher waits on, off the relay goroutine
+func (h *Hub) reportDelivery(key string, delivery Delivery) {
+	data, err := json.Marshal(deliveryReply{Instance: h.instanceID, Delivery: delivery})
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), MaxDeliveryWait)
+	defer cancel()
+	pipe := h.redis.Pipeline()
+	pipe.RPush(ctx, key, data)
+	// The publisher deletes the list, unless it gave up waiting
+	pipe.Expire(ctx, key, 2*MaxDeliveryWait)
+	if _, err := pipe.Exec(ctx); err != nil {
+		h.logger.Error("Failed to report delivery", "error", err)
+	}
+}
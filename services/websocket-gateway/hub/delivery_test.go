@@ -0,0 +1,60 @@
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeliveryReportsNoMembers(t *testing.T) {
+	mr := newTestRedis(t)
+
+	t.Run("single instance", func(t *testing.T) {
+		g := newTestGateway(t, mr.Addr(), "gateway-solo")
+		waitForRelays(t, g)
+		// A connection on another channel is no member
+		g.dial(t, "alice").join("room.2")
+
+		delivery, err := g.hub.Publish(context.Background(), "room.1", []byte(`{}`), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Delivery{Instances: 1, Complete: true, NoMembers: true}
+		if delivery != want {
+			t.Errorf("delivery = %+v, want %+v", delivery, want)
+		}
+	})
+}
+
+func TestDeliveryReportsAcrossInstances(t *testing.T) {
+	mr := newTestRedis(t)
+	a := newTestGateway(t, mr.Addr(), "gateway-a")
+	b := newTestGateway(t, mr.Addr(), "gateway-b")
+	waitForRelays(t, a, b)
+
+	publish := func(channel string, wait time.Duration) Delivery {
+		t.Helper()
+		delivery, err := a.hub.Publish(context.Background(), channel, []byte(`{}`), wait)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return delivery
+	}
+
+	// Nobody on either instance, and both reported
+	if got, want := publish("room.1", time.Second), (Delivery{Instances: 2, Complete: true, NoMembers: true}); got != want {
+		t.Errorf("no members anywhere: delivery = %+v, want %+v", got, want)
+	}
+
+	// Without waiting, the other instance may have members, so zero is not reported
+	if got, want := publish("room.1", 0), (Delivery{Instances: 1, Unanswered: 1}); got != want {
+		t.Errorf("no members, not waiting: delivery = %+v, want %+v", got, want)
+	}
+
+	// A member on the other instance only
+	b.dial(t, "bob").join("room.1")
+	got := publish("room.1", time.Second)
+	if got.NoMembers || got.Members != 1 || got.Recipients() != 1 || got.Instances != 2 || !got.Complete {
+		t.Errorf("remote member: delivery = %+v, want one recipient over two instances", got)
+	}
+}
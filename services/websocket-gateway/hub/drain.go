@@ -0,0 +1,227 @@
+package hub
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// States of a drain, as reported by DrainStatus
+const (
+	DrainIdle     = "idle"     // no drain was started
+	DrainDraining = "draining" // closing connections in batches, refusing new ones
+	DrainDrained  = "drained"  // every connection closed, still refusing new ones
+	DrainAborted  = "aborted"  // stopped early, accepting connections again
+)
+
+// Why connections are drained, as sent in the reason of their close frame
+const (
+	drainReasonDrain    = "drain"
+	drainReasonShutdown = "shutdown"
+)
+
+var (
+	ErrDraining     = errors.New("connections are already being drained")
+	ErrNotDraining  = errors.New("connections are not being drained")
+	ErrShuttingDown = errors.New("the gateway is shutting down")
+)
+
+// Drain configures how connections are moved off this instance
+type Drain struct {
+	Duration time.Duration // of a drain started without one
+	Shutdown time.Duration // of the drain at shutdown, 0 closing every connection at once
+}
+
+// DrainStatus is the progress of a drain
+type DrainStatus struct {
+	Instance  string     `json:"instance"`
+	State     string     `json:"state"`
+	Reason    string     `json:"reason,omitempty"` // drain, or shutdown
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	Deadline  *time.Time `json:"deadline,omitempty"` // by when every connection is closed
+	Total     int        `json:"total"`              // connections open when it started
+	Closed    int        `json:"closed"`             // connections it closed
+	Remaining int        `json:"remaining"`          // connections still open
+}
+
+// drainState is the current or last drain, owned by the hub goroutine
+type drainState struct {
+	state     string
+	reason    string
+	startedAt time.Time
+	deadline  time.Time
+	total     int
+	closed    int
+
+	// Connections open when the deadline was last set, and when, which the batches
+	// are spread from
+	plannedAt time.Time
+	planned   int
+}
+
+// Drain operations
+const (
+	drainStart = iota
+	drainAbort
+	drainStatus
+)
+
+// drainOp is a drain request for the hub goroutine
+type drainOp struct {
+	kind     int
+	duration time.Duration
+	reply    chan drainReply
+}
+
+type drainReply struct {
+	status DrainStatus
+	err    error
+}
+
+// SetDrain sets how long drains take
+func (h *Hub) SetDrain(drain Drain) {
+	h.drainConfig = drain
+}
+
+// StartDrain refuses new connections and closes the open ones in random batches over
+// duration, or the configured one if 0, each with a 1001 close frame carrying a
+// reconnect hint. It returns ErrDraining if a drain is running already.
+func (h *Hub) StartDrain(duration time.Duration) (DrainStatus, error) {
+	if duration <= 0 {
+		duration = h.drainConfig.Duration
+	}
+	return h.applyDrainOp(drainOp{kind: drainStart, duration: duration})
+}
+
+// AbortDrain stops the running drain, leaving the connections it did not close yet
+// open, and accepts new connections again. It returns ErrNotDraining without a drain
+// to stop, and ErrShuttingDown at shutdown.
+func (h *Hub) AbortDrain() (DrainStatus, error) {
+	return h.applyDrainOp(drainOp{kind: drainAbort})
+}
+
+// DrainStatus reports the progress of the current or last drain
+func (h *Hub) DrainStatus() DrainStatus {
+	status, _ := h.applyDrainOp(drainOp{kind: drainStatus})
+	return status
+}
+
+func (h *Hub) applyDrainOp(op drainOp) (DrainStatus, error) {
+	op.reply = make(chan drainReply, 1)
+	h.drains <- op
+	reply := <-op.reply
+	return reply.status, reply.err
+}
+
+// handleDrain applies a drain request, from the hub goroutine
+func (h *Hub) handleDrain(op drainOp) drainReply {
+	switch op.kind {
+	case drainStart:
+		if h.stopping.Load() {
+			return drainReply{h.drainStatus(), ErrShuttingDown}
+		}
+		if h.drain.state == DrainDraining || h.drain.state == DrainDrained {
+			return drainReply{h.drainStatus(), ErrDraining}
+		}
+		h.beginDrain(drainReasonDrain, time.Now().Add(op.duration))
+
+	case drainAbort:
+		if h.stopping.Load() {
+			return drainReply{h.drainStatus(), ErrShuttingDown}
+		}
+		if h.drain.state != DrainDraining && h.drain.state != DrainDrained {
+			return drainReply{h.drainStatus(), ErrNotDraining}
+		}
+		h.drain.state = DrainAborted
+		h.draining.Store(false)
+		h.logger.Info("Drain aborted", "closed", h.drain.closed, "remaining", len(h.clients))
+	}
+	return drainReply{h.drainStatus(), nil}
+}
+
+// beginDrain starts refusing new connections and closing the open ones by deadline,
+// from the hub goroutine. A drain already running keeps its start and count, and
+// its deadline if that is earlier.
+func (h *Hub) beginDrain(reason string, deadline time.Time) {
+	h.draining.Store(true)
+	now := time.Now()
+	if h.drain.state == DrainDraining {
+		h.drain.reason = reason
+		if deadline.Before(h.drain.deadline) {
+			h.drain.deadline = deadline
+		}
+	} else {
+		h.drain = drainState{
+			state:     DrainDraining,
+			reason:    reason,
+			startedAt: now,
+			deadline:  deadline,
+			total:     len(h.clients),
+		}
+	}
+	h.drain.plannedAt, h.drain.planned = now, len(h.clients)
+	h.logger.Info("Drain started", "reason", reason, "deadline", h.drain.deadline, "connections", len(h.clients))
+	h.drainStep(now)
+}
+
+// drainStep closes the next batch of the running drain, from the hub goroutine. The
+// connections are closed evenly over the time to the deadline, leaving open the
+// share of them that is left of that time, so none are left by the deadline.
+func (h *Hub) drainStep(now time.Time) {
+	if h.drain.state != DrainDraining {
+		return
+	}
+	remaining := len(h.clients)
+	if remaining == 0 {
+		h.drain.state = DrainDrained
+		h.logger.Info("Drain complete", "reason", h.drain.reason, "closed", h.drain.closed)
+		return
+	}
+
+	keep := 0
+	if left, span := h.drain.deadline.Sub(now), h.drain.deadline.Sub(h.drain.plannedAt); left > 0 && span > 0 {
+		keep = int(float64(h.drain.planned) * float64(left) / float64(span))
+	}
+	batch := remaining - keep
+	if batch <= 0 {
+		return
+	}
+	clients := make([]*Client, 0, remaining)
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	rand.Shuffle(len(clients), func(i, j int) {
+		clients[i], clients[j] = clients[j], clients[i]
+	})
+
+	reason, wait := closeDrained, policyCloseWait
+	if h.drain.reason == drainReasonShutdown {
+		// Clients get the shutdown's grace
+		reason, wait = closeShutdown, 0
+	}
+	for _, client := range clients[:batch] {
+		h.goAway(client, reason, h.drain.reason, wait)
+		h.remove(client)
+	}
+	h.drain.closed += batch
+	h.logger.Debug("Drained connections", "batch", batch, "remaining", remaining-batch)
+}
+
+// drainStatus describes the current or last drain, from the hub goroutine
+func (h *Hub) drainStatus() DrainStatus {
+	status := DrainStatus{
+		Instance:  h.instanceID,
+		State:     h.drain.state,
+		Reason:    h.drain.reason,
+		Total:     h.drain.total,
+		Closed:    h.drain.closed,
+		Remaining: len(h.clients),
+	}
+	if status.State == "" {
+		status.State = DrainIdle
+		return status
+	}
+	startedAt, deadline := h.drain.startedAt, h.drain.deadline
+	status.StartedAt, status.Deadline = &startedAt, &deadline
+	return status
+}
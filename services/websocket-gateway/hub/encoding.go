@@ -0,0 +1,103 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Subprotocols clients choose the encoding of their frames with in
+// Sec-WebSocket-Protocol. Connections that ask for neither speak JSON.
+const (
+	SubprotocolJSON    = "chorus.json.v1"
+	SubprotocolMsgPack = "chorus.msgpack.v1"
+)
+
+// Subprotocols lists the subprotocols the gateway accepts, preferred first
+var Subprotocols = []string{SubprotocolJSON, SubprotocolMsgPack}
+
+// Encodings of the frames of a connection
+const (
+	EncodingJSON    = "json"    // text frames
+	EncodingMsgPack = "msgpack" // binary frames of MessagePack
+)
+
+// encodingOf returns the encoding of a connection that negotiated subprotocol
+func encodingOf(subprotocol string) string {
+	if subprotocol == SubprotocolMsgPack {
+		return EncodingMsgPack
+	}
+	return EncodingJSON
+}
+
+// packedFrame is a frame in MessagePack, encoded once for all the connections that
+// need it by the first write pump to send it
+type packedFrame struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// msgpack returns the frame in MessagePack. The hub works with JSON throughout, so
+// frames are only transcoded as they are written.
+func (o outbound) msgpack() ([]byte, error) {
+	if o.packed == nil {
+		return jsonToMsgPack(o.data)
+	}
+	o.packed.once.Do(func() {
+		o.packed.data, o.packed.err = jsonToMsgPack(o.data)
+	})
+	return o.packed.data, o.packed.err
+}
+
+// jsonToMsgPack transcodes a JSON value to MessagePack, keeping integers integers
+func jsonToMsgPack(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(withNumbers(value))
+}
+
+// withNumbers replaces the json.Number values in value, which MessagePack would
+// encode as strings, by integers or floats
+func withNumbers(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, item := range v {
+			v[key] = withNumbers(item)
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = withNumbers(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// msgPackToJSON transcodes a client frame in MessagePack to JSON, for ParseFrame.
+// Binary values become base64 strings, and timestamps RFC 3339 strings.
+func msgPackToJSON(data []byte) ([]byte, *ProtocolError) {
+	var value any
+	if err := msgpack.Unmarshal(data, &value); err != nil {
+		return nil, &ProtocolError{Code: CodeInvalidMsgPack, Message: "frame must be a MessagePack map with string keys"}
+	}
+	frame, err := json.Marshal(value)
+	if err != nil {
+		return nil, &ProtocolError{Code: CodeInvalidMsgPack, Message: "frame must only hold values JSON can represent"}
+	}
+	return frame, nil
+}
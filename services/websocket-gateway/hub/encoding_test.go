@@ -0,0 +1,271 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// clientFrames holds a valid frame of every type a client may send
+var clientFrames = map[string]map[string]any{
+	ActionJoin:            {"v": 1, "type": ActionJoin, "id": "c1", "payload": map[string]any{"channel": "room.1", "resume": 7, "snapshot": true}},
+	ActionLeave:           {"v": 1, "type": ActionLeave, "id": "c2", "payload": map[string]any{"channel": "room.1"}},
+	ActionPublish:         {"v": 1, "type": ActionPublish, "id": "c3", "payload": map[string]any{"channel": "room.1", "data": map[string]any{"x": 12, "y": -3.5, "tags": []any{"a", "b"}}}},
+	ActionAck:             {"v": 1, "type": ActionAck, "id": "c4", "payload": map[string]any{"message_id": "m1"}},
+	ActionPing:            {"v": 1, "type": ActionPing, "id": "c5"},
+	ActionRefreshToken:    {"v": 1, "type": ActionRefreshToken, "id": "c6", "payload": map[string]any{"token": "eyJ.e30.sig"}},
+	ActionWorkflowTrigger: {"v": 1, "type": ActionWorkflowTrigger, "id": "c7", "payload": map[string]any{"template_id": "6f1c3c1e-8f0e-4d4b-9a57-1d2b5c7e9f00", "name": "run", "variables": map[string]any{"count": 3}, "subscribe": true}},
+	ActionPresenceWatch:   {"v": 1, "type": ActionPresenceWatch, "id": "c8", "payload": map[string]any{"user_ids": []any{"alice", "bob"}}},
+}
+
+// serverFrames holds a frame of every type the gateway sends
+var serverFrames = []ServerFrame{
+	{Type: FrameJoined, ID: "c1", Channel: "room.1"},
+	{Type: FrameLeft, ID: "c2", Channel: "room.1"},
+	{Type: FrameMessage, Channel: "room.1", Seq: 1 << 40, Data: json.RawMessage(`{"x":12,"y":-3.5,"tags":["a","b"]}`)},
+	{Type: FrameDirect, Seq: 3, ID: "m1", RequiresAck: true, Data: json.RawMessage(`{"text":"hi"}`)},
+	{Type: FrameDirect, Data: json.RawMessage(`"stored"`), Offline: true, StoredAt: 1767225600},
+	{Type: FrameResync, Channel: "room.1"},
+	{Type: FrameSnapshot, ID: "c1", Channel: "workflow.instance.1", Data: json.RawMessage(`{"status":"running","progress":0.5}`)},
+	{Type: FramePublished, ID: "c3", Channel: "room.1"},
+	{Type: FramePong, ID: "c5"},
+	{Type: FrameError, ID: "c9", Code: CodeInvalidFrame, Error: "payload is required", ConnectionID: "conn-1"},
+	{Type: FrameTokenExpiring, ExpiresAt: 1767225600},
+	{Type: FrameTokenRefreshed, ID: "c6", ExpiresAt: 1767229200},
+	{Type: FrameWorkflowTriggered, ID: "c7", Data: json.RawMessage(`{"instance_id":"6f1c3c1e-8f0e-4d4b-9a57-1d2b5c7e9f00"}`)},
+	{Type: FramePresenceWatching, ID: "c8", Data: json.RawMessage(`{"alice":{"status":"online"},"bob":null}`)},
+}
+
+// Each client frame parses the same whether sent as JSON text or MessagePack binary
+func TestClientFrameEncodings(t *testing.T) {
+	for frameType, frame := range clientFrames {
+		t.Run(frameType, func(t *testing.T) {
+			text, err := json.Marshal(frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			binary, err := msgpack.Marshal(frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fromJSON, perr := ParseFrame(text)
+			if perr != nil {
+				t.Fatalf("JSON frame refused: %v", perr)
+			}
+			transcoded, perr := msgPackToJSON(binary)
+			if perr != nil {
+				t.Fatalf("MessagePack frame refused: %v", perr)
+			}
+			fromMsgPack, perr := ParseFrame(transcoded)
+			if perr != nil {
+				t.Fatalf("transcoded MessagePack frame refused: %v", perr)
+			}
+
+			if fromJSON.Type != frameType {
+				t.Errorf("parsed type = %s", fromJSON.Type)
+			}
+			if !reflect.DeepEqual(fromJSON, fromMsgPack) {
+				t.Errorf("frames differ:\nJSON:        %+v\nMessagePack: %+v", fromJSON, fromMsgPack)
+			}
+		})
+	}
+}
+
+// Each server frame carries the same values in MessagePack as in JSON, integers
+// staying integers
+func TestServerFrameEncodings(t *testing.T) {
+	for _, frame := range serverFrames {
+		t.Run(frame.Type, func(t *testing.T) {
+			text, err := marshalFrame(frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			binary, err := jsonToMsgPack(text)
+			if err != nil {
+				t.Fatalf("transcode: %v", err)
+			}
+
+			var fromJSON, fromMsgPack map[string]any
+			decoder := json.NewDecoder(strings.NewReader(string(text)))
+			decoder.UseNumber()
+			if err := decoder.Decode(&fromJSON); err != nil {
+				t.Fatal(err)
+			}
+			if err := msgpack.Unmarshal(binary, &fromMsgPack); err != nil {
+				t.Fatalf("decode MessagePack: %v", err)
+			}
+			if !reflect.DeepEqual(normalize(fromJSON), normalize(fromMsgPack)) {
+				t.Errorf("values differ:\nJSON:        %v\nMessagePack: %v", fromJSON, fromMsgPack)
+			}
+
+			// Round trip back to the frame
+			back, err := json.Marshal(fromMsgPack)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded ServerFrame
+			if err := json.Unmarshal(back, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			want := frame
+			want.V = ProtocolVersion
+			if !reflect.DeepEqual(compactData(decoded), compactData(want)) {
+				t.Errorf("round trip = %+v, want %+v", decoded, want)
+			}
+		})
+	}
+}
+
+// normalize maps the numbers of a decoded frame to int64 or float64, failing
+// nothing: a JSON integer becoming a MessagePack float shows up as a difference
+func normalize(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case int8, int16, int32, int64:
+		return reflect.ValueOf(v).Int()
+	case uint8, uint16, uint32, uint64:
+		return int64(reflect.ValueOf(v).Uint())
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, item := range v {
+			out[key] = normalize(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = normalize(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// compactData re-encodes a frame's data, whose key order MessagePack does not keep
+func compactData(frame ServerFrame) ServerFrame {
+	if frame.Data != nil {
+		var value any
+		json.Unmarshal(frame.Data, &value)
+		frame.Data, _ = json.Marshal(value)
+	}
+	return frame
+}
+
+// A channel with a JSON and a MessagePack member delivers to each in its encoding
+func TestMixedEncodingChannel(t *testing.T) {
+	mr := newTestRedis(t)
+	g := newTestGateway(t, mr.Addr(), "gateway-a")
+
+	jsonConn := g.dial(t, "alice")
+	jsonConn.join("room.1")
+
+	dialer := websocket.Dialer{Subprotocols: []string{SubprotocolMsgPack}}
+	conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(g.server.URL, "http")+"/?"+url.Values{"user": {"bob"}}.Encode(), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != SubprotocolMsgPack {
+		t.Fatalf("negotiated subprotocol %q, want %s", got, SubprotocolMsgPack)
+	}
+	readPacked := func() map[string]any {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if messageType != websocket.BinaryMessage {
+			t.Fatalf("got a text frame %s, want binary", data)
+		}
+		var frame map[string]any
+		if err := msgpack.Unmarshal(data, &frame); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return frame
+	}
+
+	join, _ := msgpack.Marshal(map[string]any{"v": 1, "type": ActionJoin, "id": "c1", "payload": map[string]any{"channel": "room.1"}})
+	if err := conn.WriteMessage(websocket.BinaryMessage, join); err != nil {
+		t.Fatal(err)
+	}
+	if frame := readPacked(); frame["type"] != FrameJoined || frame["channel"] != "room.1" {
+		t.Fatalf("join answered %v", frame)
+	}
+
+	// Published in MessagePack, received in both
+	publish, _ := msgpack.Marshal(clientFrames[ActionPublish])
+	if err := conn.WriteMessage(websocket.BinaryMessage, publish); err != nil {
+		t.Fatal(err)
+	}
+	frame := jsonConn.expect(FrameMessage)
+	if got := string(compactData(frame).Data); got != `{"tags":["a","b"],"x":12,"y":-3.5}` {
+		t.Errorf("JSON member got data %s", got)
+	}
+	for received := 0; received < 2; received++ {
+		frame := readPacked()
+		switch frame["type"] {
+		case FrameMessage:
+			data, _ := frame["data"].(map[string]any)
+			if normalize(data["x"]) != int64(12) || data["y"] != -3.5 {
+				t.Errorf("MessagePack member got data %v", data)
+			}
+		case FramePublished:
+		default:
+			t.Errorf("unexpected frame %v", frame)
+		}
+	}
+}
+
+func BenchmarkEncodeFrame(b *testing.B) {
+	frame := ServerFrame{Type: FrameMessage, Channel: "game.42", Seq: 1234, Data: json.RawMessage(`{"x":12,"y":-3,"vx":0.25,"vy":-0.5,"id":"p1"}`)}
+	b.Run("json", func(b *testing.B) {
+		var size int
+		for i := 0; i < b.N; i++ {
+			data, _ := marshalFrame(frame)
+			size = len(data)
+		}
+		b.ReportMetric(float64(size), "bytes/frame")
+	})
+	b.Run("msgpack", func(b *testing.B) {
+		var size int
+		for i := 0; i < b.N; i++ {
+			data, _ := marshalFrame(frame)
+			packed, _ := jsonToMsgPack(data)
+			size = len(packed)
+		}
+		b.ReportMetric(float64(size), "bytes/frame")
+	})
+}
+
+func BenchmarkDecodeFrame(b *testing.B) {
+	frame := map[string]any{"v": 1, "type": ActionPublish, "id": "c3", "payload": map[string]any{"channel": "game.42", "data": map[string]any{"x": 12, "y": -3, "vx": 0.25, "vy": -0.5}}}
+	text, _ := json.Marshal(frame)
+	binary, _ := msgpack.Marshal(frame)
+	b.Run("json", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ParseFrame(text)
+		}
+		b.ReportMetric(float64(len(text)), "bytes/frame")
+	})
+	b.Run("msgpack", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			transcoded, _ := msgPackToJSON(binary)
+			ParseFrame(transcoded)
+		}
+		b.ReportMetric(float64(len(binary)), "bytes/frame")
+	})
+}
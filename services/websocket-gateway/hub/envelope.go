@@ -0,0 +1,314 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+)
+
+// ProtocolVersion is the envelope version clients send as "v" and frames carry
+const ProtocolVersion = 1
+
+// Longest ID a client may give its frames
+const maxFrameIDLength = 128
+
+// Longest name a client may give the workflow instances it triggers
+const maxTriggerNameLength = 255
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Codes of the error frames answering client frames the gateway cannot apply
+const (
+	CodeInvalidJSON        = "invalid_json"        // not a JSON object
+	CodeInvalidMsgPack     = "invalid_msgpack"     // a binary frame that is not a MessagePack map
+	CodeUnsupportedVersion = "unsupported_version" // "v" other than ProtocolVersion
+	CodeUnsupportedType    = "unsupported_type"    // "type" the gateway does not know
+	CodeInvalidFrame       = "invalid_frame"       // envelope or payload does not match the type's schema
+	CodeInvalidChannel     = "invalid_channel"
+	CodeTooManyChannels    = "too_many_channels"
+	CodeNotJoined          = "not_joined"     // published to a channel the connection has not joined
+	CodeForbidden          = "forbidden"      // joined a channel the channel rules do not allow the user
+	CodeUnavailable        = "unavailable"    // a service needed to answer could not be reached
+	CodeRateLimited        = "rate_limited"   // dropped by a rate limit
+	CodePublishFailed      = "publish_failed" // reached local members only, or none
+	CodeInvalidToken       = "invalid_token"  // refresh_token with a token that is invalid or of another user
+	CodeNotFound           = "not_found"      // workflow.trigger of a template the user cannot see
+)
+
+// Envelope is the versioned form of every client frame, such as
+// {"v":1,"type":"join","id":"c1","payload":{"channel":"room:42"}}
+type Envelope struct {
+	V       int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"` // chosen by the client, echoed in the replies to the frame
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Payloads of each frame type; ping has none
+type (
+	JoinPayload struct {
+		Channel  string `json:"channel"`
+		Resume   *int64 `json:"resume,omitempty"`   // sequence number of the last message seen, to replay the channel from
+		Snapshot bool   `json:"snapshot,omitempty"` // of a workflow instance channel, to receive the instance's status; presence channels always get one
+	}
+	LeavePayload struct {
+		Channel string `json:"channel"`
+	}
+	PublishPayload struct {
+		Channel string          `json:"channel"`
+		Data    json.RawMessage `json:"data"`
+	}
+	AckPayload struct {
+		MessageID string `json:"message_id"` // ID of the direct message acknowledged
+	}
+	RefreshTokenPayload struct {
+		Token string `json:"token"` // a new JWT of the same user
+	}
+	PresenceWatchPayload struct {
+		UserIDs []string `json:"user_ids"` // whose presence channels to join
+	}
+	WorkflowTriggerPayload struct {
+		TemplateID string          `json:"template_id"`
+		Name       string          `json:"name,omitempty"` // of the instance
+		Variables  json.RawMessage `json:"variables,omitempty"`
+		Subscribe  bool            `json:"subscribe,omitempty"` // to the instance's channel
+	}
+)
+
+// ProtocolError explains why a client frame was refused
+type ProtocolError struct {
+	Code    string
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func invalidFrame(format string, args ...any) *ProtocolError {
+	return &ProtocolError{Code: CodeInvalidFrame, Message: fmt.Sprintf(format, args...)}
+}
+
+// legacyFrame is the unversioned frame clients sent before the envelope, such as
+// {"action":"join","channel":"room:42"}, still accepted for now
+type legacyFrame struct {
+	Action  string `json:"action"`
+	Channel string `json:"channel"`
+	ID      string `json:"id"` // of the direct message acknowledged
+	Resume  *int64 `json:"resume"`
+}
+
+// ParseFrame validates a client frame against the schema of its type. The frame is
+// returned with its ID even when invalid, so the error can name it.
+func ParseFrame(data []byte) (ClientFrame, *ProtocolError) {
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return ClientFrame{}, invalidFrame("%s must be a %s", typeErr.Field, jsonType(typeErr))
+		}
+		return ClientFrame{}, &ProtocolError{Code: CodeInvalidJSON, Message: "frame must be a JSON object"}
+	}
+	if envelope.V == 0 {
+		var legacy legacyFrame
+		if err := json.Unmarshal(data, &legacy); err == nil && legacy.Action != "" {
+			return parseLegacy(legacy)
+		}
+	}
+
+	frame := ClientFrame{Type: envelope.Type, ID: envelope.ID}
+	if len(frame.ID) > maxFrameIDLength {
+		frame.ID = ""
+		return frame, invalidFrame("id must be at most %d characters", maxFrameIDLength)
+	}
+	if envelope.V != ProtocolVersion {
+		return frame, &ProtocolError{Code: CodeUnsupportedVersion, Message: fmt.Sprintf("v must be %d", ProtocolVersion)}
+	}
+	if envelope.Type == "" {
+		return frame, invalidFrame("type is required")
+	}
+
+	switch envelope.Type {
+	case ActionJoin:
+		var payload JoinPayload
+		if err := decodePayload(envelope.Payload, &payload); err != nil {
+			return frame, err
+		}
+		if payload.Resume != nil && *payload.Resume < 0 {
+			return frame, invalidFrame("payload.resume must not be negative")
+		}
+		frame.Channel, frame.Resume, frame.Snapshot = payload.Channel, payload.Resume, payload.Snapshot
+		return frame, validateChannel(frame.Channel)
+
+	case ActionLeave:
+		var payload LeavePayload
+		if err := decodePayload(envelope.Payload, &payload); err != nil {
+			return frame, err
+		}
+		frame.Channel = payload.Channel
+		return frame, validateChannel(frame.Channel)
+
+	case ActionPublish:
+		var payload PublishPayload
+		if err := decodePayload(envelope.Payload, &payload); err != nil {
+			return frame, err
+		}
+		if len(payload.Data) == 0 || bytes.Equal(payload.Data, []byte("null")) {
+			return frame, invalidFrame("payload.data is required")
+		}
+		frame.Channel, frame.Data = payload.Channel, payload.Data
+		return frame, validateChannel(frame.Channel)
+
+	case ActionAck:
+		var payload AckPayload
+		if err := decodePayload(envelope.Payload, &payload); err != nil {
+			return frame, err
+		}
+		if payload.MessageID == "" {
+			return frame, invalidFrame("payload.message_id is required")
+		}
+		frame.MessageID = payload.MessageID
+		return frame, nil
+
+	case ActionRefreshToken:
+		var payload RefreshTokenPayload
+		if err := decodePayload(envelope.Payload, &payload); err != nil {
+			return frame, err
+		}
+		if payload.Token == "" {
+			return frame, invalidFrame("payload.token is required")
+		}
+		frame.Token = payload.Token
+		return frame, nil
+
+	case ActionWorkflowTrigger:
+		var payload WorkflowTriggerPayload
+		if err := decodePayload(envelope.Payload, &payload); err != nil {
+			return frame, err
+		}
+		if !uuidPattern.MatchString(payload.TemplateID) {
+			return frame, invalidFrame("payload.template_id must be a UUID")
+		}
+		if len(payload.Name) > maxTriggerNameLength {
+			return frame, invalidFrame("payload.name must be at most %d characters", maxTriggerNameLength)
+		}
+		if len(payload.Variables) > 0 && payload.Variables[0] != '{' && !bytes.Equal(payload.Variables, []byte("null")) {
+			return frame, invalidFrame("payload.variables must be an object")
+		}
+		frame.TemplateID, frame.Name, frame.Variables, frame.Subscribe = payload.TemplateID, payload.Name, payload.Variables, payload.Subscribe
+		return frame, nil
+
+	case ActionPresenceWatch:
+		var payload PresenceWatchPayload
+		if err := decodePayload(envelope.Payload, &payload); err != nil {
+			return frame, err
+		}
+		if len(payload.UserIDs) == 0 {
+			return frame, invalidFrame("payload.user_ids is required")
+		}
+		seen := make(map[string]bool, len(payload.UserIDs))
+		for _, userID := range payload.UserIDs {
+			if userID == "" || !ValidChannel(PresenceChannelPrefix+userID) {
+				return frame, invalidFrame("payload.user_ids must hold user IDs of letters, digits, '.', '_', ':' or '-'")
+			}
+			if !seen[userID] {
+				seen[userID] = true
+				frame.UserIDs = append(frame.UserIDs, userID)
+			}
+		}
+		return frame, nil
+
+	case ActionPing:
+		if len(envelope.Payload) > 0 && !bytes.Equal(envelope.Payload, []byte("null")) && !bytes.Equal(envelope.Payload, []byte("{}")) {
+			return frame, invalidFrame("ping takes no payload")
+		}
+		return frame, nil
+
+	default:
+		return frame, &ProtocolError{Code: CodeUnsupportedType, Message: fmt.Sprintf("type %q is not supported", envelope.Type)}
+	}
+}
+
+// decodePayload decodes a payload object, refusing fields its type does not have
+func decodePayload(payload json.RawMessage, v any) *ProtocolError {
+	if len(payload) == 0 {
+		return invalidFrame("payload is required")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return invalidFrame("payload.%s must be a %s", typeErr.Field, jsonType(typeErr))
+		}
+		return invalidFrame("payload does not match the schema: %v", err)
+	}
+	return nil
+}
+
+// jsonType names the JSON type a field expected
+func jsonType(err *json.UnmarshalTypeError) string {
+	switch err.Type.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int64:
+		return "number"
+	default:
+		return "JSON value"
+	}
+}
+
+func validateChannel(channel string) *ProtocolError {
+	if channel == "" {
+		return invalidFrame("payload.channel is required")
+	}
+	if !ValidChannel(channel) {
+		return &ProtocolError{Code: CodeInvalidChannel, Message: ErrInvalidChannel.Error()}
+	}
+	return nil
+}
+
+// parseLegacy converts an unversioned frame
+func parseLegacy(legacy legacyFrame) (ClientFrame, *ProtocolError) {
+	frame := ClientFrame{Type: legacy.Action}
+	switch legacy.Action {
+	case ActionJoin, ActionLeave:
+		frame.Channel, frame.Resume = legacy.Channel, legacy.Resume
+		return frame, validateChannel(frame.Channel)
+	case ActionAck:
+		frame.MessageID = legacy.ID
+		if frame.MessageID == "" {
+			return frame, invalidFrame("id is required")
+		}
+		return frame, nil
+	default:
+		return frame, &ProtocolError{Code: CodeUnsupportedType, Message: fmt.Sprintf("action %q is not supported", legacy.Action)}
+	}
+}
+
+// frameErrorOf gives the code of an error of the hub's own checks
+func frameErrorOf(err error) *ProtocolError {
+	switch {
+	case errors.Is(err, ErrInvalidChannel):
+		return &ProtocolError{Code: CodeInvalidChannel, Message: err.Error()}
+	case errors.Is(err, ErrTooManyChannels), errors.Is(err, ErrTooManyWatched), errors.Is(err, ErrPresenceWatchTooLong):
+		return &ProtocolError{Code: CodeTooManyChannels, Message: err.Error()}
+	case errors.Is(err, ErrNotJoined):
+		return &ProtocolError{Code: CodeNotJoined, Message: err.Error()}
+	case errors.Is(err, ErrChannelForbidden):
+		return &ProtocolError{Code: CodeForbidden, Message: err.Error()}
+	case errors.Is(err, ErrWorkflowUnavailable), errors.Is(err, ErrPresenceUnavailable):
+		return &ProtocolError{Code: CodeUnavailable, Message: err.Error()}
+	default:
+		return &ProtocolError{Code: CodeInvalidFrame, Message: err.Error()}
+	}
+}
+
+// marshalFrame encodes a frame for a client, stamped with the protocol version
+func marshalFrame(frame ServerFrame) ([]byte, error) {
+	frame.V = ProtocolVersion
+	return json.Marshal(frame)
+}
@@ -0,0 +1,179 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFrame(t *testing.T) {
+	resume := int64(7)
+	tests := []struct {
+		name  string
+		frame string
+		want  ClientFrame
+		code  string // of the error, "" for a valid frame
+	}{
+		{"join", `{"v":1,"type":"join","id":"c1","payload":{"channel":"room.1"}}`, ClientFrame{Type: ActionJoin, ID: "c1", Channel: "room.1"}, ""},
+		{"join resuming", `{"v":1,"type":"join","id":"c1","payload":{"channel":"room.1","resume":7,"snapshot":true}}`, ClientFrame{Type: ActionJoin, ID: "c1", Channel: "room.1", Resume: &resume, Snapshot: true}, ""},
+		{"join without payload", `{"v":1,"type":"join","id":"c1"}`, ClientFrame{Type: ActionJoin, ID: "c1"}, CodeInvalidFrame},
+		{"join without channel", `{"v":1,"type":"join","id":"c1","payload":{}}`, ClientFrame{Type: ActionJoin, ID: "c1"}, CodeInvalidFrame},
+		{"join of an invalid channel", `{"v":1,"type":"join","id":"c1","payload":{"channel":"room 1"}}`, ClientFrame{Type: ActionJoin, ID: "c1", Channel: "room 1"}, CodeInvalidChannel},
+		{"join with a negative resume", `{"v":1,"type":"join","id":"c1","payload":{"channel":"room.1","resume":-1}}`, ClientFrame{Type: ActionJoin, ID: "c1"}, CodeInvalidFrame},
+		{"join with an unknown field", `{"v":1,"type":"join","id":"c1","payload":{"channel":"room.1","from":3}}`, ClientFrame{Type: ActionJoin, ID: "c1"}, CodeInvalidFrame},
+		{"join with a mistyped channel", `{"v":1,"type":"join","id":"c1","payload":{"channel":42}}`, ClientFrame{Type: ActionJoin, ID: "c1"}, CodeInvalidFrame},
+
+		{"leave", `{"v":1,"type":"leave","id":"c2","payload":{"channel":"room.1"}}`, ClientFrame{Type: ActionLeave, ID: "c2", Channel: "room.1"}, ""},
+		{"leave without channel", `{"v":1,"type":"leave","id":"c2","payload":{}}`, ClientFrame{Type: ActionLeave, ID: "c2"}, CodeInvalidFrame},
+
+		{"publish", `{"v":1,"type":"publish","id":"c3","payload":{"channel":"room.1","data":{"text":"hi"}}}`, ClientFrame{Type: ActionPublish, ID: "c3", Channel: "room.1", Data: json.RawMessage(`{"text":"hi"}`)}, ""},
+		{"publish without data", `{"v":1,"type":"publish","id":"c3","payload":{"channel":"room.1"}}`, ClientFrame{Type: ActionPublish, ID: "c3"}, CodeInvalidFrame},
+		{"publish of null data", `{"v":1,"type":"publish","id":"c3","payload":{"channel":"room.1","data":null}}`, ClientFrame{Type: ActionPublish, ID: "c3"}, CodeInvalidFrame},
+
+		{"ack", `{"v":1,"type":"ack","id":"c4","payload":{"message_id":"m1"}}`, ClientFrame{Type: ActionAck, ID: "c4", MessageID: "m1"}, ""},
+		{"ack without message_id", `{"v":1,"type":"ack","id":"c4","payload":{}}`, ClientFrame{Type: ActionAck, ID: "c4"}, CodeInvalidFrame},
+
+		{"ping", `{"v":1,"type":"ping","id":"c5"}`, ClientFrame{Type: ActionPing, ID: "c5"}, ""},
+		{"ping with an empty payload", `{"v":1,"type":"ping","id":"c5","payload":{}}`, ClientFrame{Type: ActionPing, ID: "c5"}, ""},
+		{"ping with a payload", `{"v":1,"type":"ping","id":"c5","payload":{"at":1}}`, ClientFrame{Type: ActionPing, ID: "c5"}, CodeInvalidFrame},
+
+		{"not JSON", `join room.1`, ClientFrame{}, CodeInvalidJSON},
+		{"not an object", `["join"]`, ClientFrame{}, CodeInvalidJSON},
+		{"mistyped envelope", `{"v":"1","type":"ping"}`, ClientFrame{}, CodeInvalidFrame},
+		{"other version", `{"v":2,"type":"ping","id":"c6"}`, ClientFrame{Type: ActionPing, ID: "c6"}, CodeUnsupportedVersion},
+		{"missing type", `{"v":1,"id":"c7"}`, ClientFrame{ID: "c7"}, CodeInvalidFrame},
+		{"unknown type", `{"v":1,"type":"subscribe","id":"c8"}`, ClientFrame{Type: "subscribe", ID: "c8"}, CodeUnsupportedType},
+		{"overlong id", `{"v":1,"type":"ping","id":"` + strings.Repeat("x", maxFrameIDLength+1) + `"}`, ClientFrame{Type: ActionPing}, CodeInvalidFrame},
+
+		{"legacy join", `{"action":"join","channel":"room.1"}`, ClientFrame{Type: ActionJoin, Channel: "room.1"}, ""},
+		{"legacy ack", `{"action":"ack","id":"m1"}`, ClientFrame{Type: ActionAck, MessageID: "m1"}, ""},
+		{"legacy unknown action", `{"action":"publish","channel":"room.1"}`, ClientFrame{Type: ActionPublish}, CodeUnsupportedType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFrame([]byte(tt.frame))
+			switch {
+			case tt.code == "" && err != nil:
+				t.Fatalf("ParseFrame refused a valid frame: %v", err)
+			case tt.code != "" && err == nil:
+				t.Fatalf("ParseFrame accepted the frame, want %s", tt.code)
+			case err != nil && err.Code != tt.code:
+				t.Fatalf("error code = %s (%s), want %s", err.Code, err.Message, tt.code)
+			}
+			if got.ID != tt.want.ID || got.Type != tt.want.Type {
+				t.Errorf("frame type and id = %q %q, want %q %q", got.Type, got.ID, tt.want.Type, tt.want.ID)
+			}
+			if tt.code == "" && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFrame = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Each frame type over a live connection: valid frames get their reply, invalid ones
+// an error frame naming the frame and the connection
+func TestFrameConformance(t *testing.T) {
+	mr := newTestRedis(t)
+	g := newTestGateway(t, mr.Addr(), "gateway-a", func(h *Hub) {
+		h.SetAck(Ack{Timeout: time.Minute, MaxRetries: 1, PendingTTL: time.Hour})
+	})
+	conn := g.dial(t, "alice")
+
+	expectError := func(id, code string) {
+		t.Helper()
+		frame := conn.expect(FrameError)
+		if frame.ID != id || frame.Code != code || frame.Error == "" || frame.ConnectionID == "" {
+			t.Errorf("error frame = %+v, want code %s for %q with a message and the connection", frame, code, id)
+		}
+		if frame.V != ProtocolVersion {
+			t.Errorf("error frame v = %d, want %d", frame.V, ProtocolVersion)
+		}
+	}
+
+	t.Run("join", func(t *testing.T) {
+		conn.send(`{"v":1,"type":"join","id":"j1","payload":{"channel":"room.1"}}`)
+		if frame := conn.expect(FrameJoined); frame.ID != "j1" || frame.Channel != "room.1" {
+			t.Errorf("joined frame = %+v", frame)
+		}
+		conn.send(`{"v":1,"type":"join","id":"j2","payload":{"channel":"room 1"}}`)
+		expectError("j2", CodeInvalidChannel)
+	})
+
+	t.Run("publish", func(t *testing.T) {
+		conn.send(`{"v":1,"type":"publish","id":"p1","payload":{"channel":"room.1","data":{"text":"hi"}}}`)
+		// The member who published gets the message too, and the reply
+		var message, published bool
+		for i := 0; i < 2; i++ {
+			switch frame := conn.next(); frame.Type {
+			case FrameMessage:
+				message = string(frame.Data) == `{"text":"hi"}`
+			case FramePublished:
+				published = frame.ID == "p1"
+			}
+		}
+		if !message || !published {
+			t.Errorf("publish: got message %v and published reply %v, want both", message, published)
+		}
+		conn.send(`{"v":1,"type":"publish","id":"p2","payload":{"channel":"room.2","data":{"text":"hi"}}}`)
+		expectError("p2", CodeNotJoined)
+		conn.send(`{"v":1,"type":"publish","id":"p3","payload":{"channel":"room.1"}}`)
+		expectError("p3", CodeInvalidFrame)
+	})
+
+	t.Run("leave", func(t *testing.T) {
+		conn.send(`{"v":1,"type":"leave","id":"l1","payload":{"channel":"room.1"}}`)
+		if frame := conn.expect(FrameLeft); frame.ID != "l1" || frame.Channel != "room.1" {
+			t.Errorf("left frame = %+v", frame)
+		}
+		conn.send(`{"v":1,"type":"leave","id":"l2","payload":{"room":"room.1"}}`)
+		expectError("l2", CodeInvalidFrame)
+	})
+
+	t.Run("ack", func(t *testing.T) {
+		result, err := g.hub.SendToUser(context.Background(), "alice", []byte(`{"text":"ack me"}`), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame := conn.expect(FrameDirect)
+		if !frame.RequiresAck || frame.ID != result.MessageID {
+			t.Fatalf("direct frame = %+v, want it to require an ack of %s", frame, result.MessageID)
+		}
+
+		acked := ackMessagesTotal.Value(ackEventAcked)
+		conn.send(`{"v":1,"type":"ack","id":"a1","payload":{"message_id":"` + result.MessageID + `"}}`)
+		// Acks have no reply; a ping after it is answered once the hub handled both
+		conn.send(`{"v":1,"type":"ping","id":"after-ack"}`)
+		conn.expect(FramePong)
+		if got := ackMessagesTotal.Value(ackEventAcked) - acked; got != 1 {
+			t.Errorf("acked messages grew by %v, want 1", got)
+		}
+
+		conn.send(`{"v":1,"type":"ack","id":"a2","payload":{"message_id":""}}`)
+		expectError("a2", CodeInvalidFrame)
+	})
+
+	t.Run("ping", func(t *testing.T) {
+		conn.send(`{"v":1,"type":"ping","id":"g1"}`)
+		if frame := conn.expect(FramePong); frame.ID != "g1" {
+			t.Errorf("pong frame = %+v", frame)
+		}
+		conn.send(`{"v":1,"type":"ping","id":"g2","payload":"now"}`)
+		expectError("g2", CodeInvalidFrame)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		conn.send(`{"v":1,"type":"subscribe","id":"u1","payload":{"channel":"room.1"}}`)
+		expectError("u1", CodeUnsupportedType)
+		conn.send(`{"v":3,"type":"ping","id":"u2"}`)
+		expectError("u2", CodeUnsupportedVersion)
+		conn.send(`not json`)
+		expectError("", CodeInvalidJSON)
+	})
+
+	// Invalid frames leave the connection open
+	conn.send(`{"v":1,"type":"ping","id":"last"}`)
+	conn.expect(FramePong)
+}
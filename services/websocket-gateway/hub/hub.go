@@ -0,0 +1,515 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chorus/pkg/eventbus"
+	"chorus/pkg/instanceaccess"
+	"chorus/pkg/logging"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrInvalidChannel  = errors.New("channel must be 1-128 letters, digits, '.', '_', ':' or '-'")
+	ErrTooManyChannels = errors.New("connection has joined the maximum number of channels")
+	ErrRateLimited     = errors.New("rate limit exceeded, frame dropped")
+	ErrNotJoined       = errors.New("connection has not joined the channel")
+
+	channelPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+)
+
+// ValidChannel reports whether name is an acceptable channel name
+func ValidChannel(name string) bool {
+	return channelPattern.MatchString(name)
+}
+
+// Hub tracks the open connections and the channels they joined. All of its state is
+// owned by the Run goroutine; everything else talks to it over channels.
+type Hub struct {
+	clients     map[*Client]bool
+	users       map[string]map[*Client]bool // connections of each user
+	channels    map[string]map[*Client]bool // members of each channel
+	maxChannels int                         // channels one connection may join, 0 for no limit
+	redis       *redis.Client               // relays messages between instances
+	events      *eventbus.Bus               // of the workflow engine and the presence service
+	instanceID  string                      // tells this instance's relayed messages apart
+	registryTTL time.Duration               // lifetime of the user registry entries of this instance
+	keepalive   Keepalive
+	limits      Limits
+
+	sendQueueSize int         // frames queued per connection
+	queueRules    []QueueRule // how frames of each channel are queued
+
+	ack     Ack
+	unacked map[string]map[string]*unackedMessage // messages awaiting an ack, by user and message ID
+	replay  Replay
+	offline Offline
+
+	channelRules []channelRule // who may use which channels, nil allowing all
+	logger       *logging.Logger
+
+	workflows      Workflows
+	workflowAccess *instanceaccess.Client // asks the engine who may follow an instance, nil without an engine
+	tokens         Tokens
+
+	presence         Presence
+	presenceMu       sync.Mutex
+	presenceSessions map[presenceSession]int  // open connections of each session
+	presenceChanged  map[presenceSession]bool // sessions to report, online or offline, not yet reported
+	presenceWake     chan struct{}            // signaled when presenceChanged gains a session
+
+	userBucketsMu sync.Mutex
+	userBuckets   map[string]*userBucket // message rate limits of the connected users
+
+	draining    atomic.Bool    // set while draining and by Shutdown, refusing new connections
+	stopping    atomic.Bool    // set by Shutdown
+	closeGrace  time.Duration  // how long clients get to answer the close frame at shutdown
+	connections sync.WaitGroup // open connections
+	drainConfig Drain
+	drain       drainState // the current or last drain
+
+	register     chan *Client
+	unregister   chan *Client
+	commands     chan command
+	publish      chan publication
+	userEvents   chan userEvent     // users whose first connection opened or last one closed
+	snapshots    chan chan []string // requests for the locally connected users
+	stats        chan chan Stats    // requests for Stats
+	admin        chan adminOp       // admin requests for this instance's connections
+	pendingOps   chan pendingOp     // changes to the pending lists in Redis
+	resumed      chan resumeResult  // missed messages read for resuming clients
+	offlineTaken chan offlineResult // offline queues taken for clients that just connected
+	triggered    chan triggerResult // workflow instances created for clients
+	drains       chan drainOp       // drain requests
+	shutdown     chan time.Time     // by when to have closed every connection
+}
+
+// command is a frame received from a client, handled by the hub
+type command struct {
+	client *Client
+	frame  ClientFrame
+	err    *ProtocolError // why the frame is refused, only to be answered with an error
+	span   trace.Span     // of the frame, ended once the hub has handled it
+}
+
+// publication is a message for the members of a channel, or for the connections of
+// a user
+type publication struct {
+	channel   string
+	userID    string
+	message   []byte
+	seq       int64         // number of the message in its stream, 0 if unnumbered
+	id        string        // set for a direct message requiring an ack
+	parkedAt  time.Time     // when a message replayed from the user's pending list was parked
+	delivered chan Delivery // receives what became of the message on this instance
+}
+
+func NewHub(redisClient *redis.Client, instanceID string, maxChannels int, logger *logging.Logger) *Hub {
+	return &Hub{
+		clients:     make(map[*Client]bool),
+		users:       make(map[string]map[*Client]bool),
+		channels:    make(map[string]map[*Client]bool),
+		maxChannels: maxChannels,
+		redis:       redisClient,
+		events:      eventbus.New(redisClient, "websocket-gateway", logger, eventbus.Metrics(), eventbus.Tracing()),
+		instanceID:  instanceID,
+		registryTTL: time.Minute,
+		keepalive: Keepalive{
+			PingInterval: 54 * time.Second,
+			PongWait:     60 * time.Second,
+		},
+		limits:        Limits{MaxMessageSize: maxMessageSize},
+		sendQueueSize: sendBufferSize,
+		ack: Ack{
+			Timeout:    10 * time.Second,
+			MaxRetries: 3,
+			PendingTTL: 24 * time.Hour,
+		},
+		unacked:      make(map[string]map[string]*unackedMessage),
+		userBuckets:  make(map[string]*userBucket),
+		logger:       logger,
+		register:     make(chan *Client),
+		unregister:   make(chan *Client),
+		commands:     make(chan command),
+		publish:      make(chan publication),
+		userEvents:   make(chan userEvent, userEventBuffer),
+		snapshots:    make(chan chan []string),
+		stats:        make(chan chan Stats),
+		admin:        make(chan adminOp),
+		pendingOps:   make(chan pendingOp, pendingOpBuffer),
+		resumed:      make(chan resumeResult),
+		offlineTaken: make(chan offlineResult),
+		triggered:    make(chan triggerResult),
+		drains:       make(chan drainOp),
+		shutdown:     make(chan time.Time),
+
+		presenceSessions: make(map[presenceSession]int),
+		presenceChanged:  make(map[presenceSession]bool),
+		presenceWake:     make(chan struct{}, 1),
+	}
+}
+
+// Run serves the hub; it never returns
+func (h *Hub) Run() {
+	acks := time.NewTicker(time.Second)
+	defer acks.Stop()
+
+	for {
+		select {
+		case client := <-h.register:
+			if h.draining.Load() {
+				// Upgraded just before the drain or the shutdown
+				if h.stopping.Load() {
+					h.goAway(client, closeShutdown, drainReasonShutdown, 0)
+				} else {
+					h.goAway(client, closeDrained, drainReasonDrain, policyCloseWait)
+				}
+				client.queue.close(false)
+				continue
+			}
+			if !h.admit(client) {
+				continue
+			}
+			h.clients[client] = true
+			connections, ok := h.users[client.userID]
+			if !ok {
+				connections = make(map[*Client]bool)
+				h.users[client.userID] = connections
+				h.userChanged(client.userID, true)
+			}
+			connections[client] = true
+			connectionsGauge.Add(1, client.authLabel())
+			h.sessionChanged(client, true)
+			client.logger.Debug("Connection registered")
+			// Messages stored while the user was offline go before live ones
+			h.startOffline(client)
+			if client.resumeFrom != nil {
+				h.startResume(client, userStream(client.userID), "", *client.resumeFrom)
+			}
+			// Messages parked while the user was away
+			h.queuePending(pendingOp{kind: pendingReplay, userID: client.userID})
+
+		case client := <-h.unregister:
+			if _, ok := h.clients[client]; ok {
+				h.remove(client)
+				client.logger.Debug("Connection unregistered")
+			}
+
+		case cmd := <-h.commands:
+			h.command(cmd)
+
+		case pub := <-h.publish:
+			if pub.userID != "" {
+				pub.delivered <- h.direct(pub)
+			} else {
+				pub.delivered <- h.fanout(pub)
+			}
+
+		case result := <-h.resumed:
+			h.finishResume(result)
+
+		case result := <-h.offlineTaken:
+			h.finishOffline(result)
+
+		case result := <-h.triggered:
+			h.finishTrigger(result)
+
+		case now := <-acks.C:
+			h.retryUnacked(now)
+			h.drainStep(now)
+
+		case deadline := <-h.shutdown:
+			h.startShutdown(deadline)
+
+		case op := <-h.drains:
+			op.reply <- h.handleDrain(op)
+
+		case reply := <-h.snapshots:
+			userIDs := make([]string, 0, len(h.users))
+			for userID := range h.users {
+				userIDs = append(userIDs, userID)
+			}
+			reply <- userIDs
+
+		case reply := <-h.stats:
+			reply <- h.liveStats()
+
+		case op := <-h.admin:
+			op.reply <- h.applyAdmin(op.request)
+		}
+	}
+}
+
+// SetKeepalive sets how connections opened from now on are kept alive and reaped
+func (h *Hub) SetKeepalive(keepalive Keepalive) {
+	h.keepalive = keepalive
+}
+
+// deliver sends message, numbered seq in the channel's stream, to every member of
+// channel connected to this instance and returns what became of it
+func (h *Hub) deliver(channel string, seq int64, message []byte) Delivery {
+	defer observeFanout(fanoutChannel, time.Now())
+	pub := publication{
+		channel:   channel,
+		seq:       seq,
+		message:   message,
+		delivered: make(chan Delivery, 1),
+	}
+	h.publish <- pub
+	return <-pub.delivered
+}
+
+// deliverDirect sends a publication for a user to each of their connections on
+// this instance and returns how many it was queued for
+func (h *Hub) deliverDirect(pub publication) int {
+	defer observeFanout(fanoutDirect, time.Now())
+	pub.delivered = make(chan Delivery, 1)
+	h.publish <- pub
+	return (<-pub.delivered).Recipients()
+}
+
+// command handles or refuses a frame a client sent, then ends the frame's span
+func (h *Hub) command(cmd command) {
+	defer cmd.span.End()
+	if _, ok := h.clients[cmd.client]; !ok {
+		return
+	}
+	if cmd.err != nil {
+		cmd.span.SetStatus(codes.Error, cmd.err.Message)
+		h.refuse(cmd.client, cmd.frame, cmd.err)
+		return
+	}
+	h.handle(cmd.client, cmd.frame)
+}
+
+// handle applies a frame ParseFrame validated
+func (h *Hub) handle(client *Client, frame ClientFrame) {
+	switch frame.Type {
+	case ActionJoin:
+		rejoined := client.channels[frame.Channel]
+		if err := h.join(client, frame.Channel); err != nil {
+			h.refuse(client, frame, frameErrorOf(err))
+			return
+		}
+		if !rejoined {
+			client.logger.Info("Channel joined", "channel", frame.Channel)
+		}
+		h.reply(client, ServerFrame{Type: FrameJoined, ID: frame.ID, Channel: frame.Channel})
+		if frame.snapshot != nil {
+			h.reply(client, ServerFrame{Type: FrameSnapshot, ID: frame.ID, Channel: frame.Channel, Data: frame.snapshot})
+		}
+		if frame.Resume != nil && !rejoined {
+			h.startResume(client, channelStream(frame.Channel), frame.Channel, *frame.Resume)
+		}
+
+	case ActionLeave:
+		if client.channels[frame.Channel] {
+			client.logger.Info("Channel left", "channel", frame.Channel)
+		}
+		h.leave(client, frame.Channel)
+		h.reply(client, ServerFrame{Type: FrameLeft, ID: frame.ID, Channel: frame.Channel})
+
+	case ActionPublish:
+		if !client.channels[frame.Channel] {
+			h.refuse(client, frame, frameErrorOf(ErrNotJoined))
+			return
+		}
+		if WorkflowChannel(frame.Channel) || PresenceChannel(frame.Channel) {
+			h.refuse(client, frame, frameErrorOf(ErrChannelForbidden))
+			return
+		}
+		// Publishing waits on Redis and the hub itself
+		go h.publishFor(client, frame)
+
+	case ActionAck:
+		h.acknowledge(client, frame.MessageID)
+
+	case ActionPing:
+		h.reply(client, ServerFrame{Type: FramePong, ID: frame.ID})
+
+	case ActionRefreshToken:
+		h.refreshToken(client, frame)
+
+	case ActionWorkflowTrigger:
+		h.triggerWorkflow(client, frame)
+
+	case ActionPresenceWatch:
+		h.watchPresence(client, frame)
+	}
+}
+
+// refuse answers a frame the gateway cannot apply with an error frame naming it, and
+// the connection, for users to quote
+func (h *Hub) refuse(client *Client, frame ClientFrame, err *ProtocolError) {
+	invalidFramesTotal.Inc(err.Code)
+	client.logger.Warn("Refused frame", "type", frame.Type, "id", frame.ID, "channel", frame.Channel, "code", err.Code, "error", err.Message)
+	h.reply(client, ServerFrame{
+		Type:         FrameError,
+		ID:           frame.ID,
+		Channel:      frame.Channel,
+		Code:         err.Code,
+		Error:        err.Message,
+		ConnectionID: client.id,
+	})
+}
+
+// publishFor publishes a client's frame to its channel and answers it, off the hub
+// goroutine
+func (h *Hub) publishFor(client *Client, frame ClientFrame) {
+	ctx, cancel := context.WithTimeout(context.Background(), writeWait)
+	defer cancel()
+
+	delivery, err := h.Publish(ctx, frame.Channel, frame.Data, 0)
+	if err != nil {
+		recipients := delivery.Recipients()
+		client.logger.Error("Failed to publish frame", "channel", frame.Channel, "recipients", recipients, "error", err)
+		invalidFramesTotal.Inc(CodePublishFailed)
+		h.reply(client, ServerFrame{
+			Type:         FrameError,
+			ID:           frame.ID,
+			Channel:      frame.Channel,
+			Code:         CodePublishFailed,
+			Error:        fmt.Sprintf("reached %d connections on this instance only", recipients),
+			ConnectionID: client.id,
+		})
+		return
+	}
+	h.reply(client, ServerFrame{Type: FramePublished, ID: frame.ID, Channel: frame.Channel})
+}
+
+func (h *Hub) join(client *Client, channel string) error {
+	if !ValidChannel(channel) {
+		return ErrInvalidChannel
+	}
+	if client.channels[channel] {
+		return nil
+	}
+	if rule, err := h.AuthorizeChannel(channel, client.tokenClaims()); err != nil {
+		client.logger.Info("Channel join denied", "channel", channel, "rule", rule)
+		return err
+	}
+	if PresenceChannel(channel) {
+		if h.presence.WatchMax > 0 && client.presenceChannels() >= h.presence.WatchMax {
+			return ErrTooManyWatched
+		}
+	} else if h.maxChannels > 0 && len(client.channels)-client.presenceChannels() >= h.maxChannels {
+		return ErrTooManyChannels
+	}
+
+	members, ok := h.channels[channel]
+	if !ok {
+		members = make(map[*Client]bool)
+		h.channels[channel] = members
+	}
+	members[client] = true
+	client.channels[channel] = true
+	h.channelResized(len(members)-1, len(members))
+	return nil
+}
+
+func (h *Hub) leave(client *Client, channel string) {
+	if !client.channels[channel] {
+		return
+	}
+	delete(client.channels, channel)
+	delete(client.resuming, channelStream(channel))
+
+	members := h.channels[channel]
+	delete(members, client)
+	if len(members) == 0 {
+		delete(h.channels, channel)
+	}
+	h.channelResized(len(members)+1, len(members))
+}
+
+// fanout queues message for every member of channel under the channel's queue rule.
+// Members that cannot keep up are disconnected or miss frames, per the rule, rather
+// than holding up the others.
+func (h *Hub) fanout(pub publication) Delivery {
+	var delivery Delivery
+	channel := pub.channel
+	frame, err := marshalFrame(ServerFrame{Type: FrameMessage, Channel: channel, Seq: pub.seq, Data: pub.message})
+	if err != nil {
+		h.logger.Error("Failed to marshal message", "channel", channel, "error", err)
+		return delivery
+	}
+
+	rule, stream := h.queueRule(channel), channelStream(channel)
+	packed := &packedFrame{}
+	for client := range h.channels[channel] {
+		h.deliverCounted(&delivery, client, stream, pub.seq, outbound{channel: channel, data: frame, packed: packed}, rule)
+	}
+	return delivery
+}
+
+// direct queues a message for every connection of the user, disconnecting those that
+// cannot keep up. A message requiring an ack is then tracked until one of them acks
+// it.
+func (h *Hub) direct(pub publication) Delivery {
+	var delivery Delivery
+	userID := pub.userID
+	if len(h.users[userID]) == 0 {
+		return delivery
+	}
+
+	frame, err := marshalFrame(ServerFrame{
+		Type:        FrameDirect,
+		Seq:         pub.seq,
+		ID:          pub.id,
+		RequiresAck: pub.id != "",
+		Data:        pub.message,
+	})
+	if err != nil {
+		h.logger.Error("Failed to marshal message", "user_id", userID, "error", err)
+		return delivery
+	}
+	if pub.id != "" && !h.track(userID, pub.id, pub.message, frame, pub.parkedAt) {
+		return delivery
+	}
+
+	rule, stream := h.queueRule(""), userStream(userID)
+	packed := &packedFrame{}
+	for client := range h.users[userID] {
+		h.deliverCounted(&delivery, client, stream, pub.seq, outbound{data: frame, packed: packed}, rule)
+	}
+	return delivery
+}
+
+// reply queues a frame for one client, dropping it if the client's queue is full.
+// The queue is safe to use outside the hub goroutine.
+func (h *Hub) reply(client *Client, frame ServerFrame) {
+	data, err := marshalFrame(frame)
+	if err != nil {
+		return
+	}
+	client.queue.offer(outbound{data: data})
+}
+
+// remove forgets the client and its memberships and closes its send queue, which
+// ends its write pump once the queue is sent
+func (h *Hub) remove(client *Client) {
+	for channel := range client.channels {
+		h.leave(client, channel)
+	}
+	delete(h.clients, client)
+	connectionsGauge.Add(-1, client.authLabel())
+	h.sessionChanged(client, false)
+	if connections := h.users[client.userID]; connections != nil {
+		delete(connections, client)
+		if len(connections) == 0 {
+			delete(h.users, client.userID)
+			h.userChanged(client.userID, false)
+			// Nobody here is left to ack them
+			h.parkAll(client.userID)
+		}
+	}
+	client.queue.close(false)
+}
@@ -0,0 +1,154 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+
+	"chorus/pkg/logging"
+)
+
+func testLogger() *logging.Logger {
+	return &logging.Logger{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+}
+
+// testGateway is a hub serving WebSocket connections of the user named by the
+// ?user= query with the ?token= and from the ?device= and ?device_id= given, as the
+// upgrade handler does after authentication
+type testGateway struct {
+	hub    *Hub
+	server *httptest.Server
+}
+
+// newTestGateway starts a hub of instanceID on the Redis at redisAddr, with its
+// relay, registry and presence reports running until the test ends. configure, if given, sets it up
+// before it runs.
+func newTestGateway(t *testing.T, redisAddr, instanceID string, configure ...func(*Hub)) *testGateway {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: redisAddr})
+	t.Cleanup(func() { client.Close() })
+
+	h := NewHub(client, instanceID, 0, testLogger())
+	for _, fn := range configure {
+		fn(h)
+	}
+	go h.Run()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var background sync.WaitGroup
+	for _, run := range []func(context.Context){h.RunRelay, h.RunRegistry, h.RunPresence} {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			run(ctx)
+		}()
+	}
+	t.Cleanup(func() {
+		cancel()
+		background.Wait()
+	})
+
+	upgrader := websocket.Upgrader{Subprotocols: Subprotocols}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		query := r.URL.Query()
+		client := NewClient(h, conn, query.Get("user"), query.Get("token"), nil)
+		client.SetDevice(query.Get("device"), query.Get("device_id"))
+		client.Serve()
+	}))
+	t.Cleanup(server.Close)
+	return &testGateway{hub: h, server: server}
+}
+
+// testConn is a client connection to a test gateway
+type testConn struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func (g *testGateway) dial(t *testing.T, userID string) *testConn {
+	t.Helper()
+	return g.dialQuery(t, url.Values{"user": {userID}})
+}
+
+// dialQuery connects with query, which names the user and their device
+func (g *testGateway) dialQuery(t *testing.T, query url.Values) *testConn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(g.server.URL, "http") + "/?" + query.Encode()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial gateway: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testConn{t: t, conn: conn}
+}
+
+// send writes a client frame
+func (c *testConn) send(frame string) {
+	c.t.Helper()
+	if err := c.conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		c.t.Fatalf("send frame: %v", err)
+	}
+}
+
+// next reads the next server frame, failing the test after two seconds
+func (c *testConn) next() ServerFrame {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := c.conn.ReadMessage()
+	if err != nil {
+		c.t.Fatalf("read frame: %v", err)
+	}
+	var frame ServerFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		c.t.Fatalf("decode frame %s: %v", data, err)
+	}
+	return frame
+}
+
+// expect reads the next server frame and fails the test unless it is of type
+func (c *testConn) expect(frameType string) ServerFrame {
+	c.t.Helper()
+	frame := c.next()
+	if frame.Type != frameType {
+		c.t.Fatalf("got a %s frame (%+v), want %s", frame.Type, frame, frameType)
+	}
+	return frame
+}
+
+// quiet fails the test if a frame arrives within wait
+func (c *testConn) quiet(wait time.Duration) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(wait))
+	if _, data, err := c.conn.ReadMessage(); err == nil {
+		c.t.Fatalf("got an unexpected frame %s", data)
+	}
+}
+
+// join joins channel and waits for the hub to confirm it
+func (c *testConn) join(channel string) {
+	c.t.Helper()
+	c.send(`{"v":1,"type":"join","id":"join-` + channel + `","payload":{"channel":"` + channel + `"}}`)
+	c.expect(FrameJoined)
+}
+
+// newTestRedis starts an in-memory Redis for the test
+func newTestRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	return miniredis.RunT(t)
+}
@@ -0,0 +1,176 @@
+package hub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Limits violated, as labeled in gateway_limit_violations_total
+const (
+	limitMessageSize        = "message_size"
+	limitConnectionRate     = "connection_rate"
+	limitUserRate           = "user_rate"
+	limitConnectionsPerUser = "connections_per_user"
+	limitWorkflowTriggers   = "workflow_triggers"
+	limitPresenceWatch      = "presence_watch"
+)
+
+// How long a client closed for breaking a limit or policy gets to answer the close
+// frame
+const policyCloseWait = time.Second
+
+// Limits bounds what one client may send, all per instance
+type Limits struct {
+	MaxMessageSize        int64   // largest inbound message in bytes; larger ones close the connection with 1009
+	ConnectionRate        float64 // inbound messages per second per connection, 0 for no limit
+	ConnectionBurst       float64
+	UserRate              float64 // inbound messages per second across a user's connections, 0 for no limit
+	UserBurst             float64
+	MaxConnectionsPerUser int  // 0 for no limit
+	CloseOldest           bool // make room for a new connection over the limit by closing the user's oldest one, instead of refusing the new one
+}
+
+// tokenBucket allows rate events per second on average and burst at once
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket, or nil, which allows everything, for a zero rate
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// allow takes a token if there is one
+func (b *tokenBucket) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// userBucket is the rate limit shared by a user's connections
+type userBucket struct {
+	bucket      *tokenBucket
+	triggers    *tokenBucket // workflow.trigger frames
+	connections int
+}
+
+// SetLimits sets the limits of connections opened from now on
+func (h *Hub) SetLimits(limits Limits) {
+	h.limits = limits
+}
+
+// acquireUserBucket returns the rate limits of the user's connections, creating them
+// for their first one
+func (h *Hub) acquireUserBucket(userID string) *userBucket {
+	h.userBucketsMu.Lock()
+	defer h.userBucketsMu.Unlock()
+
+	shared, ok := h.userBuckets[userID]
+	if !ok {
+		shared = &userBucket{
+			bucket:   newTokenBucket(h.limits.UserRate, h.limits.UserBurst),
+			triggers: newTokenBucket(h.workflows.TriggerRate, h.workflows.TriggerBurst),
+		}
+		h.userBuckets[userID] = shared
+	}
+	shared.connections++
+	return shared
+}
+
+// releaseUserBucket drops the user's rate limit once their last connection closed
+func (h *Hub) releaseUserBucket(userID string) {
+	h.userBucketsMu.Lock()
+	defer h.userBucketsMu.Unlock()
+
+	if shared, ok := h.userBuckets[userID]; ok {
+		shared.connections--
+		if shared.connections <= 0 {
+			delete(h.userBuckets, userID)
+		}
+	}
+}
+
+// admit applies the per-user connection limit to a new client, from the hub
+// goroutine. It returns false if the client is refused; otherwise the user's oldest
+// connection may have been closed to make room.
+func (h *Hub) admit(client *Client) bool {
+	max := h.limits.MaxConnectionsPerUser
+	connections := h.users[client.userID]
+	if max <= 0 || len(connections) < max {
+		return true
+	}
+
+	limitViolationsTotal.Inc(limitConnectionsPerUser)
+	if !h.limits.CloseOldest {
+		client.logger.Warn("Limit exceeded", "limit", limitConnectionsPerUser, "connections", len(connections), "action", "refuse_new")
+		client.closedFor(closeConnectionLimit)
+		client.closeWith(websocket.ClosePolicyViolation, "too many connections", policyCloseWait)
+		client.queue.close(false)
+		return false
+	}
+
+	var oldest *Client
+	for other := range connections {
+		if oldest == nil || other.connectedAt.Before(oldest.connectedAt) {
+			oldest = other
+		}
+	}
+	oldest.logger.Warn("Limit exceeded", "limit", limitConnectionsPerUser, "connections", len(connections), "action", "close_oldest")
+	oldest.closedFor(closeReplaced)
+	oldest.closeWith(websocket.ClosePolicyViolation, "replaced by a newer connection", policyCloseWait)
+	h.remove(oldest)
+	return true
+}
+
+// closeWith sets the close frame the client's write pump sends once its queue is
+// empty, and how long the client gets to answer it
+func (c *Client) closeWith(code int, reason string, wait time.Duration) {
+	c.closeMessage = websocket.FormatCloseMessage(code, reason)
+	c.closeWait = wait
+	c.closedWith(code)
+}
+
+// allowMessage applies the connection's and the user's message rate limits
+func (c *Client) allowMessage(now time.Time) bool {
+	if !c.limiter.allow(now) {
+		limitViolationsTotal.Inc(limitConnectionRate)
+		c.logger.Warn("Limit exceeded", "limit", limitConnectionRate, "action", "drop_message")
+		return false
+	}
+	if !c.userLimiter.allow(now) {
+		limitViolationsTotal.Inc(limitUserRate)
+		c.logger.Warn("Limit exceeded", "limit", limitUserRate, "action", "drop_message")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package hub
+
+import "chorus/pkg/metrics"
+
+// Gateway metrics exposed on /metrics
+var (
+	connectionsGauge = metrics.Default.Gauge(
+		"gateway_connections",
+		"Open WebSocket connections, by auth: authenticated or anonymous",
+		"auth",
+	)
+	connectionsOpenedTotal = metrics.Default.Counter(
+		"gateway_connections_opened_total",
+		"WebSocket connections upgraded",
+	)
+	connectionsClosedTotal = metrics.Default.Counter(
+		"gateway_connections_closed_total",
+		"WebSocket connections closed, by reason",
+		"reason",
+	)
+	channelsGauge = metrics.Default.Gauge(
+		"gateway_channels",
+		"Channels with at least one member connected to this instance",
+	)
+	channelsByMembers = metrics.Default.Gauge(
+		"gateway_channels_by_members",
+		"Channels by how many members are connected to this instance, bucketed",
+		"members",
+	)
+	messagesReceivedTotal = metrics.Default.Counter(
+		"gateway_messages_received_total",
+		"Messages received from clients",
+	)
+	bytesReceivedTotal = metrics.Default.Counter(
+		"gateway_received_bytes_total",
+		"Bytes of the messages received from clients",
+	)
+	messagesSentTotal = metrics.Default.Counter(
+		"gateway_messages_sent_total",
+		"Frames written to clients",
+	)
+	bytesSentTotal = metrics.Default.Counter(
+		"gateway_sent_bytes_total",
+		"Bytes of the frames written to clients",
+	)
+	fanoutSeconds = metrics.Default.Histogram(
+		"gateway_fanout_seconds",
+		"Time from handing a message to the hub until it is queued for every local recipient, by kind: channel or direct",
+		[]float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1},
+		"kind",
+	)
+	connectionsReapedTotal = metrics.Default.Counter(
+		"gateway_connections_reaped_total",
+		"Connections closed by the gateway for missing pongs or staying idle, by reason",
+		"reason",
+	)
+	limitViolationsTotal = metrics.Default.Counter(
+		"gateway_limit_violations_total",
+		"Messages and connections refused by the gateway for exceeding a limit, by limit",
+		"limit",
+	)
+	sendQueueDepth = metrics.Default.Histogram(
+		"gateway_send_queue_depth",
+		"Frames queued for a connection, observed as each frame is queued",
+		[]float64{1, 4, 16, 64, 128, 256, 512, 1024},
+	)
+	sendQueueDroppedTotal = metrics.Default.Counter(
+		"gateway_send_queue_dropped_total",
+		"Frames of lossy channels dropped for connections that could not keep up",
+	)
+	ackMessagesTotal = metrics.Default.Counter(
+		"gateway_ack_messages_total",
+		"Direct messages requiring an ack, by event: acked, retried, parked or replayed",
+		"event",
+	)
+	offlineMessagesTotal = metrics.Default.Counter(
+		"gateway_offline_messages_total",
+		"Messages kept for users who were not connected, by event: stored, replayed, expired or dropped",
+		"event",
+	)
+	resumesTotal = metrics.Default.Counter(
+		"gateway_resumes_total",
+		"Streams clients resumed, by outcome: replayed, resync or failed",
+		"outcome",
+	)
+	invalidFramesTotal = metrics.Default.Counter(
+		"gateway_invalid_frames_total",
+		"Client frames answered with an error frame, by code",
+		"code",
+	)
+	presenceReportsTotal = metrics.Default.Counter(
+		"gateway_presence_reports_total",
+		"Session heartbeats sent to the presence service, by outcome: reported or failed",
+		"outcome",
+	)
+	workflowEventsTotal = metrics.Default.Counter(
+		"gateway_workflow_events_total",
+		"Workflow engine events forwarded to the channels of their instances",
+	)
+	presenceEventsTotal = metrics.Default.Counter(
+		"gateway_presence_events_total",
+		"Presence status changes forwarded to the presence channels of their users",
+	)
+	workflowTriggersTotal = metrics.Default.Counter(
+		"gateway_workflow_triggers_total",
+		"workflow.trigger frames, by outcome: created, refused, failed or rate_limited",
+		"outcome",
+	)
+	slowConsumersTotal = metrics.Default.Counter(
+		"gateway_slow_consumers_total",
+		"Connections closed for not keeping up with their frames",
+	)
+)
@@ -0,0 +1,244 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// List per user of the OfflineMessage stored while they had no connection, oldest
+// first, as JSON
+const offlineKeyPrefix = "gateway_offline:"
+
+// Events of offline messages, as labeled in gateway_offline_messages_total
+const (
+	offlineStored   = "stored"
+	offlineReplayed = "replayed"
+	offlineExpired  = "expired" // older than the TTL when the user connected
+	offlineDropped  = "dropped" // trimmed off a full queue
+)
+
+// Offline configures the queues of messages kept for users who are not connected
+type Offline struct {
+	Size int           // messages kept per user, the oldest dropped first, 0 disables the queues
+	TTL  time.Duration // how long a message is kept
+}
+
+// OfflineMessage is a message sent with persist_if_offline while its user had no
+// connection, replayed when they next connect
+type OfflineMessage struct {
+	Channel  string          `json:"channel,omitempty"` // "" for a direct message
+	Data     json.RawMessage `json:"data"`
+	StoredAt time.Time       `json:"stored_at"`
+}
+
+// offlineResult is a user's offline queue, taken off the hub goroutine for the
+// connection that just opened
+type offlineResult struct {
+	client   *Client
+	messages []OfflineMessage
+	err      error
+}
+
+// heldFrame is a live frame held back while its client replays the offline queue
+type heldFrame struct {
+	stream string
+	seq    int64
+	frame  outbound
+	rule   QueueRule
+}
+
+// SetOffline sets how many messages are kept for users who are not connected, and
+// for how long
+func (h *Hub) SetOffline(offline Offline) {
+	h.offline = offline
+}
+
+// UserOnline reports whether the registry lists any instance the user is connected to
+func (h *Hub) UserOnline(ctx context.Context, userID string) (bool, error) {
+	instances, err := h.userInstances(ctx, userID)
+	return len(instances) > 0, err
+}
+
+// StoreOffline adds a message to the user's offline queue, to be replayed when they
+// connect. It reports false, storing nothing, when the queues are disabled.
+func (h *Hub) StoreOffline(ctx context.Context, userID, channel string, message []byte) (bool, error) {
+	if h.offline.Size <= 0 {
+		return false, nil
+	}
+	data, err := json.Marshal(OfflineMessage{Channel: channel, Data: message, StoredAt: time.Now()})
+	if err != nil {
+		return false, err
+	}
+
+	key := offlineKeyPrefix + userID
+	pipe := h.redis.TxPipeline()
+	length := pipe.RPush(ctx, key, data)
+	pipe.LTrim(ctx, key, int64(-h.offline.Size), -1)
+	pipe.Expire(ctx, key, h.offline.TTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("failed to store offline message: %w", err)
+	}
+	offlineMessagesTotal.Inc(offlineStored)
+	if dropped := length.Val() - int64(h.offline.Size); dropped > 0 {
+		offlineMessagesTotal.Add(float64(dropped), offlineDropped)
+	}
+	return true, nil
+}
+
+// OfflineMessages returns the user's offline queue, oldest first, leaving it in place
+func (h *Hub) OfflineMessages(ctx context.Context, userID string) ([]OfflineMessage, error) {
+	entries, err := h.redis.LRange(ctx, offlineKeyPrefix+userID, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get offline messages: %w", err)
+	}
+	messages, _ := h.decodeOffline(userID, entries)
+	return messages, nil
+}
+
+// PurgeOffline empties the user's offline queue, returning how many messages it held
+func (h *Hub) PurgeOffline(ctx context.Context, userID string) (int64, error) {
+	key := offlineKeyPrefix + userID
+	pipe := h.redis.TxPipeline()
+	length := pipe.LLen(ctx, key)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to purge offline messages: %w", err)
+	}
+	return length.Val(), nil
+}
+
+// decodeOffline parses the entries of a user's offline queue, skipping those past the
+// TTL, which outlive it as each store refreshes the key's, and returns how many it
+// skipped for that
+func (h *Hub) decodeOffline(userID string, entries []string) ([]OfflineMessage, int) {
+	cutoff := time.Now().Add(-h.offline.TTL)
+	messages := make([]OfflineMessage, 0, len(entries))
+	expired := 0
+	for _, data := range entries {
+		var message OfflineMessage
+		if err := json.Unmarshal([]byte(data), &message); err != nil {
+			h.logger.Error("Failed to unmarshal offline message", "user_id", userID, "error", err)
+			continue
+		}
+		if message.StoredAt.Before(cutoff) {
+			expired++
+			continue
+		}
+		messages = append(messages, message)
+	}
+	return messages, expired
+}
+
+// startOffline holds back live frames for a connection that just opened while its
+// user's offline queue is taken, from the hub goroutine
+func (h *Hub) startOffline(client *Client) {
+	if h.offline.Size <= 0 {
+		return
+	}
+
+	client.replayingOffline = true
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), resumeTimeout)
+		defer cancel()
+
+		// Taken at once, so only one of several connections opening together gets it
+		key := offlineKeyPrefix + client.userID
+		pipe := h.redis.TxPipeline()
+		entries := pipe.LRange(ctx, key, 0, -1)
+		pipe.Del(ctx, key)
+		_, err := pipe.Exec(ctx)
+
+		var messages []OfflineMessage
+		if err == nil {
+			var expired int
+			messages, expired = h.decodeOffline(client.userID, entries.Val())
+			offlineMessagesTotal.Add(float64(expired), offlineExpired)
+		}
+		h.offlineTaken <- offlineResult{client: client, messages: messages, err: err}
+	}()
+}
+
+// finishOffline sends a connection its user's offline messages, marked offline, and
+// then the live frames held back meanwhile, from the hub goroutine. Messages it could
+// not take go back to the queue.
+func (h *Hub) finishOffline(result offlineResult) {
+	client := result.client
+	if _, ok := h.clients[client]; !ok {
+		h.restoreOffline(client.userID, result.messages)
+		return
+	}
+	held := client.offlineHeld
+	client.replayingOffline, client.offlineHeld = false, nil
+	if result.err != nil {
+		client.logger.Error("Failed to replay offline messages", "error", result.err)
+	}
+	if len(result.messages) > 0 {
+		client.logger.Info("Replaying offline messages", "count", len(result.messages))
+	}
+
+	for i, message := range result.messages {
+		frameType := FrameDirect
+		if message.Channel != "" {
+			frameType = FrameMessage
+		}
+		frame, err := marshalFrame(ServerFrame{
+			Type:     frameType,
+			Channel:  message.Channel,
+			Data:     message.Data,
+			Offline:  true,
+			StoredAt: message.StoredAt.Unix(),
+		})
+		if err != nil {
+			continue
+		}
+		if !h.enqueue(client, outbound{channel: message.Channel, data: frame}, h.queueRule(message.Channel)) {
+			if _, ok := h.clients[client]; !ok {
+				h.restoreOffline(client.userID, result.messages[i:])
+				return
+			}
+			continue
+		}
+		offlineMessagesTotal.Inc(offlineReplayed)
+	}
+
+	for _, frame := range held {
+		if !h.deliverLive(client, frame.stream, frame.seq, frame.frame, frame.rule) {
+			if _, ok := h.clients[client]; !ok {
+				return
+			}
+		}
+	}
+}
+
+// restoreOffline puts messages taken for a connection that closed before getting
+// them back at the head of the user's offline queue, without blocking the hub
+// goroutine
+func (h *Hub) restoreOffline(userID string, messages []OfflineMessage) {
+	if len(messages) == 0 {
+		return
+	}
+	// LPUSH prepends one value at a time, so the newest goes first
+	entries := make([]any, 0, len(messages))
+	for i := len(messages) - 1; i >= 0; i-- {
+		data, err := json.Marshal(messages[i])
+		if err != nil {
+			continue
+		}
+		entries = append(entries, data)
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), resumeTimeout)
+		defer cancel()
+		key := offlineKeyPrefix + userID
+		pipe := h.redis.TxPipeline()
+		pipe.LPush(ctx, key, entries...)
+		pipe.LTrim(ctx, key, int64(-h.offline.Size), -1)
+		pipe.Expire(ctx, key, h.offline.TTL)
+		if _, err := pipe.Exec(ctx); err != nil {
+			h.logger.Error("Failed to restore offline messages", "user_id", userID, "count", len(entries), "error", err)
+		}
+	}()
+}
@@ -0,0 +1,235 @@
+package hub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"chorus/pkg/tracing"
+)
+
+const (
+	// Device ID of connections that name none
+	defaultPresenceDevice = "websocket"
+
+	// Heartbeats sent to the presence service per request, within its batch limit
+	presenceBatchSize = 500
+
+	// Time allowed for one request to the presence service
+	presenceTimeout = 5 * time.Second
+)
+
+// Outcomes of reports to the presence service, as labeled in gateway_presence_reports_total
+const (
+	presenceReported = "reported"
+	presenceFailed   = "failed"
+)
+
+// Presence configures how connections are reported to the presence service, and
+// how presence channels are authorized and snapshotted
+type Presence struct {
+	URL      string        // of the presence service, "" disables reporting
+	APIKey   string        // sent as X-API-Key
+	Interval time.Duration // how often the sessions of open connections are refreshed
+
+	// Of presence channels
+	WatchMax        int    // users one connection may watch, 0 for no limit
+	AuthorizeURL    string // asked which users a client may watch, "" leaving it to the channel rules
+	AuthorizeAPIKey string // sent to AuthorizeURL as X-API-Key
+}
+
+// presenceSession is a device of a user with connections to this instance, reported
+// to the presence service as one session
+type presenceSession struct {
+	userID   string
+	deviceID string
+	device   string
+}
+
+// presenceHeartbeat is one heartbeat of POST /presence/heartbeat/batch
+type presenceHeartbeat struct {
+	UserID     string `json:"user_id"`
+	Status     string `json:"status"`
+	Device     string `json:"device,omitempty"`
+	DeviceID   string `json:"device_id"`
+	TTLSeconds int    `json:"ttl_seconds"`
+}
+
+type presenceBatchResponse struct {
+	Accepted int `json:"accepted"`
+	Failed   []struct {
+		UserID string `json:"user_id"`
+		Error  string `json:"error"`
+	} `json:"failed"`
+}
+
+// SetPresence sets where and how often connections are reported to the presence service
+func (h *Hub) SetPresence(presence Presence) {
+	presence.URL = strings.TrimSuffix(presence.URL, "/")
+	h.presence = presence
+}
+
+// SetDevice names the device the client connects from, to the presence service,
+// before it is served. Connections of a user from the same device ID are one session.
+func (c *Client) SetDevice(device, deviceID string) {
+	c.device, c.deviceID = device, deviceID
+}
+
+// session returns the presence session of the client
+func (c *Client) session() presenceSession {
+	deviceID := c.deviceID
+	if deviceID == "" {
+		deviceID = c.device
+	}
+	if deviceID == "" {
+		deviceID = defaultPresenceDevice
+	}
+	return presenceSession{userID: c.userID, deviceID: deviceID, device: c.device}
+}
+
+// sessionChanged counts a connection of the client's session opening or closing, and
+// wakes RunPresence to report sessions that came online or went offline
+func (h *Hub) sessionChanged(client *Client, opened bool) {
+	if h.presence.URL == "" {
+		return
+	}
+
+	session := client.session()
+	h.presenceMu.Lock()
+	defer h.presenceMu.Unlock()
+	count := h.presenceSessions[session]
+	switch {
+	case opened && count == 0:
+		h.presenceChanged[session] = true
+	case !opened && count == 1:
+		h.presenceChanged[session] = false
+	}
+	if opened {
+		h.presenceSessions[session] = count + 1
+	} else if count > 1 {
+		h.presenceSessions[session] = count - 1
+	} else {
+		delete(h.presenceSessions, session)
+	}
+
+	select {
+	case h.presenceWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunPresence reports users online to the presence service as their connections
+// open, refreshes their sessions every interval while connections stay open, and
+// reports them offline as the last connection of a session closes. Reports that
+// fail are not retried; the next refresh makes up for them, and sessions of a dead
+// instance expire on their own. It blocks until ctx is cancelled, then reports the
+// sessions closed meanwhile, such as at shutdown.
+func (h *Hub) RunPresence(ctx context.Context) {
+	if h.presence.URL == "" {
+		return
+	}
+
+	// Reports under way when ctx is cancelled are completed, each request being
+	// bounded by presenceTimeout, so the changes they carry are not lost
+	reportCtx := context.WithoutCancel(ctx)
+	ticker := time.NewTicker(h.presence.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			h.reportPresence(reportCtx, h.presenceHeartbeats(false))
+			return
+
+		case <-h.presenceWake:
+			h.reportPresence(reportCtx, h.presenceHeartbeats(false))
+
+		case <-ticker.C:
+			h.reportPresence(reportCtx, h.presenceHeartbeats(true))
+		}
+	}
+}
+
+// presenceHeartbeats returns the heartbeats of the sessions that changed since the
+// last call, and of every open session with refresh
+func (h *Hub) presenceHeartbeats(refresh bool) []presenceHeartbeat {
+	ttl := int((3 * h.presence.Interval).Seconds())
+	heartbeat := func(session presenceSession, status string) presenceHeartbeat {
+		return presenceHeartbeat{
+			UserID:     session.userID,
+			Status:     status,
+			Device:     session.device,
+			DeviceID:   session.deviceID,
+			TTLSeconds: ttl,
+		}
+	}
+
+	h.presenceMu.Lock()
+	defer h.presenceMu.Unlock()
+	var heartbeats []presenceHeartbeat
+	for session, online := range h.presenceChanged {
+		if !online {
+			heartbeats = append(heartbeats, heartbeat(session, "offline"))
+		} else if !refresh {
+			heartbeats = append(heartbeats, heartbeat(session, "online"))
+		}
+	}
+	clear(h.presenceChanged)
+	if refresh {
+		for session := range h.presenceSessions {
+			heartbeats = append(heartbeats, heartbeat(session, "online"))
+		}
+	}
+	return heartbeats
+}
+
+// reportPresence sends heartbeats to the presence service in batches
+func (h *Hub) reportPresence(ctx context.Context, heartbeats []presenceHeartbeat) {
+	for start := 0; start < len(heartbeats); start += presenceBatchSize {
+		batch := heartbeats[start:min(start+presenceBatchSize, len(heartbeats))]
+		if err := h.sendPresence(ctx, batch); err != nil {
+			presenceReportsTotal.Add(float64(len(batch)), presenceFailed)
+			h.logger.Error("Failed to report presence sessions", "sessions", len(batch), "error", err)
+			continue
+		}
+		presenceReportsTotal.Add(float64(len(batch)), presenceReported)
+	}
+}
+
+func (h *Hub) sendPresence(ctx context.Context, batch []presenceHeartbeat) error {
+	body, err := json.Marshal(map[string][]presenceHeartbeat{"heartbeats": batch})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, presenceTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.presence.URL+"/presence/heartbeat/batch", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.presence.APIKey != "" {
+		req.Header.Set("X-API-Key", h.presence.APIKey)
+	}
+
+	resp, err := tracing.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("presence service answered %s", resp.Status)
+	}
+
+	var result presenceBatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+		for _, failure := range result.Failed {
+			h.logger.Warn("Presence service refused heartbeat", "user_id", failure.UserID, "error", failure.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakePresence is a presence service recording the heartbeat batches it is sent
+type fakePresence struct {
+	server  *httptest.Server
+	batches chan []presenceHeartbeat
+	status  atomic.Int32 // answered to every batch
+}
+
+func newFakePresence(t *testing.T, apiKey string) *fakePresence {
+	t.Helper()
+	fake := &fakePresence{batches: make(chan []presenceHeartbeat, 100)}
+	fake.status.Store(http.StatusOK)
+	fake.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/presence/heartbeat/batch" {
+			t.Errorf("presence service got %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.Header.Get("X-API-Key"); got != apiKey {
+			t.Errorf("X-API-Key = %q, want %q", got, apiKey)
+		}
+		var body struct {
+			Heartbeats []presenceHeartbeat `json:"heartbeats"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode heartbeat batch: %v", err)
+		}
+		status := int(fake.status.Load())
+		if status == http.StatusOK {
+			fake.batches <- body.Heartbeats
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]any{"accepted": len(body.Heartbeats)})
+	}))
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+// next returns the next heartbeat reported, failing the test after wait
+func (f *fakePresence) next(t *testing.T, wait time.Duration) presenceHeartbeat {
+	t.Helper()
+	select {
+	case batch := <-f.batches:
+		if len(batch) != 1 {
+			t.Fatalf("batch = %+v, want one heartbeat", batch)
+		}
+		return batch[0]
+	case <-time.After(wait):
+		t.Fatalf("no heartbeat reported within %s", wait)
+		return presenceHeartbeat{}
+	}
+}
+
+func TestPresenceReportsConnectRefreshAndDisconnect(t *testing.T) {
+	fake := newFakePresence(t, "gateway-key")
+	mr := newTestRedis(t)
+	g := newTestGateway(t, mr.Addr(), "gateway-a", func(h *Hub) {
+		h.SetPresence(Presence{URL: fake.server.URL + "/", APIKey: "gateway-key", Interval: time.Second})
+	})
+
+	conn := g.dialQuery(t, url.Values{"user": {"alice"}, "device": {"web"}, "device_id": {"laptop"}})
+	want := presenceHeartbeat{UserID: "alice", Status: "online", Device: "web", DeviceID: "laptop", TTLSeconds: 3}
+	if got := fake.next(t, time.Second); got != want {
+		t.Errorf("connect reported %+v, want %+v", got, want)
+	}
+
+	// A second connection of the session is not reported again
+	second := g.dialQuery(t, url.Values{"user": {"alice"}, "device": {"web"}, "device_id": {"laptop"}})
+	second.send(`{"v":1,"type":"ping"}`)
+	second.expect(FramePong)
+
+	// The open session is refreshed every interval
+	if got := fake.next(t, 2*time.Second); got != want {
+		t.Errorf("refresh reported %+v, want %+v", got, want)
+	}
+
+	// and reported offline once its last connection closes
+	second.conn.Close()
+	conn.conn.Close()
+	want.Status = "offline"
+	if got := fake.next(t, time.Second); got != want {
+		t.Errorf("disconnect reported %+v, want %+v", got, want)
+	}
+}
+
+func TestPresenceOutageLeavesConnectionsAlone(t *testing.T) {
+	fake := newFakePresence(t, "")
+	fake.status.Store(http.StatusServiceUnavailable)
+	mr := newTestRedis(t)
+	g := newTestGateway(t, mr.Addr(), "gateway-a", func(h *Hub) {
+		h.SetPresence(Presence{URL: fake.server.URL, Interval: time.Second})
+	})
+
+	failed := presenceReportsTotal.Value(presenceFailed)
+	conn := g.dial(t, "alice")
+	conn.join("room.1")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for presenceReportsTotal.Value(presenceFailed) == failed {
+		if time.Now().After(deadline) {
+			t.Fatal("the failed report was not counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The connection keeps working through the outage
+	conn.send(`{"v":1,"type":"ping","id":"p1"}`)
+	conn.expect(FramePong)
+
+	// and its session is reported by the first refresh after the service is back
+	fake.status.Store(http.StatusOK)
+	if got := fake.next(t, 2*time.Second); got.UserID != "alice" || got.Status != "online" || got.DeviceID != defaultPresenceDevice {
+		t.Errorf("refresh after the outage reported %+v", got)
+	}
+}
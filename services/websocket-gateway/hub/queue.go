@@ -0,0 +1,197 @@
+package hub
+
+import (
+	"path"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Policies for the frames of a channel a connection cannot keep up with
+const (
+	QueueDisconnect = "disconnect" // close the connection with 1008 "slow consumer"
+	QueueLossy      = "lossy"      // drop the oldest queued frame of the channel
+)
+
+// QueueRule sets how the frames of channels matching Pattern are queued for each
+// connection
+type QueueRule struct {
+	Pattern string // path.Match pattern over channel names, such as "typing:*"
+	Policy  string // QueueDisconnect or QueueLossy
+	Buffer  int    // frames of one such channel queued per connection, 0 for the whole send queue
+}
+
+// outbound is a frame queued for a client
+type outbound struct {
+	channel string       // the channel it was published to, "" for replies and direct messages
+	data    []byte       // in JSON
+	packed  *packedFrame // shared by the recipients of a fanout, nil to transcode alone
+}
+
+// sendQueue is the bounded queue of frames for a client's write pump. The hub
+// pushes and closes it; the write pump pops it.
+type sendQueue struct {
+	mu     sync.Mutex
+	frames []outbound
+	counts map[string]int // queued frames of each channel
+	size   int
+	closed bool
+	ready  chan struct{} // signalled when frames are pushed or the queue is closed
+}
+
+func newSendQueue(size int) *sendQueue {
+	return &sendQueue{
+		counts: make(map[string]int),
+		size:   size,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// push queues frame under rule, reporting whether it did. When the queue, or the
+// channel's share of it, is full, a lossy channel makes room by dropping its oldest
+// frame, or the new one if none of its frames is queued; otherwise push reports
+// overflow and the connection should be closed as a slow consumer.
+func (q *sendQueue) push(frame outbound, rule QueueRule) (queued, overflow bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false, false
+	}
+
+	limit := q.size
+	if rule.Buffer > 0 && rule.Buffer < limit {
+		limit = rule.Buffer
+	}
+	if len(q.frames) >= q.size || q.counts[frame.channel] >= limit {
+		if rule.Policy != QueueLossy {
+			return false, true
+		}
+		sendQueueDroppedTotal.Inc()
+		if !q.evict(frame.channel) {
+			return false, false
+		}
+	}
+
+	q.frames = append(q.frames, frame)
+	q.counts[frame.channel]++
+	sendQueueDepth.Observe(float64(len(q.frames)))
+	q.signal()
+	return true, false
+}
+
+// offer queues frame only if there is room, reporting whether it did
+func (q *sendQueue) offer(frame outbound) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed || len(q.frames) >= q.size {
+		return false
+	}
+	q.frames = append(q.frames, frame)
+	q.counts[frame.channel]++
+	q.signal()
+	return true
+}
+
+// evict drops the oldest queued frame of channel, reporting whether there was one
+func (q *sendQueue) evict(channel string) bool {
+	for i, frame := range q.frames {
+		if frame.channel == channel {
+			q.frames = append(q.frames[:i], q.frames[i+1:]...)
+			q.counts[channel]--
+			if q.counts[channel] == 0 {
+				delete(q.counts, channel)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// pop takes the oldest frame. Once the queue is empty it reports whether the queue
+// was closed, so no frame queued before close is missed.
+func (q *sendQueue) pop() (frame outbound, ok, closed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.frames) == 0 {
+		return outbound{}, false, q.closed
+	}
+	frame = q.frames[0]
+	q.frames[0] = outbound{}
+	q.frames = q.frames[1:]
+	q.counts[frame.channel]--
+	if q.counts[frame.channel] == 0 {
+		delete(q.counts, frame.channel)
+	}
+	return frame, true, false
+}
+
+// close ends the queue once the frames already in it are sent; with discard they
+// are dropped instead
+func (q *sendQueue) close(discard bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	if discard {
+		q.frames = nil
+		q.counts = make(map[string]int)
+	}
+	q.signal()
+}
+
+// len returns how many frames are queued
+func (q *sendQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.frames)
+}
+
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// SetSendQueue sets how many frames may be queued for each connection opened from
+// now on, and the rules for the channels they join; the first rule matching a
+// channel applies, and channels matching none, like direct messages, disconnect
+func (h *Hub) SetSendQueue(size int, rules []QueueRule) {
+	h.sendQueueSize = size
+	h.queueRules = rules
+}
+
+// queueRule returns the rule for the frames of channel, or of direct messages and
+// replies for ""
+func (h *Hub) queueRule(channel string) QueueRule {
+	if channel == "" {
+		return QueueRule{Policy: QueueDisconnect}
+	}
+	for _, rule := range h.queueRules {
+		if matched, _ := path.Match(rule.Pattern, channel); matched {
+			return rule
+		}
+	}
+	return QueueRule{Policy: QueueDisconnect}
+}
+
+// enqueue queues frame for the client, from the hub goroutine, closing the client
+// as a slow consumer if it cannot take it. It reports whether the frame was queued.
+func (h *Hub) enqueue(client *Client, frame outbound, rule QueueRule) bool {
+	queued, overflow := client.queue.push(frame, rule)
+	if !overflow {
+		return queued
+	}
+
+	slowConsumersTotal.Inc()
+	client.logger.Warn("Disconnecting slow consumer", "channel", frame.channel, "queue_size", client.queue.size)
+	client.closedFor(closeSlowConsumer)
+	client.closeWith(websocket.ClosePolicyViolation, "slow consumer", policyCloseWait)
+	// Frames it could not read so far are not worth waiting for
+	client.queue.close(true)
+	h.remove(client)
+	return false
+}
@@ -0,0 +1,227 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"chorus/pkg/eventbus"
+)
+
+const (
+	// Sorted set per user of the gateway instances they are connected to, scored by
+	// when the entry expires (unix seconds) unless refreshed
+	userInstancesKeyPrefix = "gateway_user_instances:"
+
+	// Connects and disconnects queued for the registry before they are dropped, to be
+	// reconciled by the next refresh
+	userEventBuffer = 1024
+)
+
+// userEvent says that a user's first connection to this instance opened, or their
+// last one closed
+type userEvent struct {
+	userID    string
+	connected bool
+}
+
+// SendResult reports where a direct message went
+type SendResult struct {
+	MessageID        string   // ID of a message requiring an ack
+	LocalConnections int      // connections on this instance the message was queued for
+	Instances        []string // other instances it was relayed to
+	Parked           bool     // whether the message was parked for the user, who is offline
+}
+
+// Online reports whether the user had any live connection
+func (r SendResult) Online() bool {
+	return r.LocalConnections > 0 || len(r.Instances) > 0
+}
+
+// SetRegistryTTL sets how long the registry entries of this instance last without
+// being refreshed, which happens every third of it
+func (h *Hub) SetRegistryTTL(ttl time.Duration) {
+	h.registryTTL = ttl
+}
+
+// SendToUser sends message to every connection of the user: those on this instance
+// directly, and those on other instances, as found in the registry, over the relay.
+// With requiresAck the message gets an ID, and each instance sends it again until
+// the user acks it, then parks it; it is parked at once if the user is offline.
+func (h *Hub) SendToUser(ctx context.Context, userID string, message []byte, requiresAck bool) (SendResult, error) {
+	var result SendResult
+	if requiresAck {
+		result.MessageID = newMessageID()
+	}
+	seq := h.record(ctx, userStream(userID), message)
+	result.LocalConnections = h.deliverDirect(publication{userID: userID, seq: seq, message: message, id: result.MessageID})
+
+	instances, err := h.userInstances(ctx, userID)
+	if err != nil {
+		return result, err
+	}
+	for _, instance := range instances {
+		if instance != h.instanceID {
+			result.Instances = append(result.Instances, instance)
+		}
+	}
+	if len(result.Instances) == 0 {
+		if requiresAck && result.LocalConnections == 0 {
+			ackMessagesTotal.Inc(ackEventParked)
+			err := h.ParkMessage(ctx, userID, PendingMessage{
+				ID:       result.MessageID,
+				Data:     message,
+				ParkedAt: time.Now(),
+			})
+			result.Parked = err == nil
+			return result, err
+		}
+		return result, nil
+	}
+
+	err = eventbus.Publish(ctx, h.events, relayEvents, relayMessage{
+		Origin: h.instanceID,
+		UserID: userID,
+		Seq:    seq,
+		ID:     result.MessageID,
+		Data:   message,
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to relay message: %w", err)
+	}
+	return result, nil
+}
+
+// userInstances returns the instances the registry lists for the user
+func (h *Hub) userInstances(ctx context.Context, userID string) ([]string, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	instances, err := h.redis.ZRangeByScore(ctx, userInstancesKeyPrefix+userID, &redis.ZRangeBy{
+		Min: now,
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user instances: %w", err)
+	}
+	return instances, nil
+}
+
+// RunRegistry keeps this instance's entries in the Redis user registry: added when a
+// user's first connection opens, removed when their last one closes, and refreshed
+// periodically so the entries of an instance that died expire. It blocks until ctx
+// is cancelled, then removes all of this instance's entries.
+func (h *Hub) RunRegistry(ctx context.Context) {
+	ticker := time.NewTicker(h.registryTTL / 3)
+	defer ticker.Stop()
+
+	// Users registered by this instance, to remove those gone from the hub
+	registered := make(map[string]bool)
+	for {
+		select {
+		case <-ctx.Done():
+			// Connects still queued were never registered, but may have been by a
+			// refresh
+			for drained := false; !drained; {
+				select {
+				case event := <-h.userEvents:
+					registered[event.userID] = true
+				default:
+					drained = true
+				}
+			}
+			h.unregisterUsers(registered)
+			return
+
+		case event := <-h.userEvents:
+			if event.connected {
+				h.registerUsers(ctx, []string{event.userID})
+				registered[event.userID] = true
+			} else {
+				h.removeUsers(ctx, []string{event.userID})
+				delete(registered, event.userID)
+			}
+
+		case <-ticker.C:
+			reply := make(chan []string, 1)
+			select {
+			case h.snapshots <- reply:
+			case <-ctx.Done():
+				continue
+			}
+			userIDs := <-reply
+
+			connected := make(map[string]bool, len(userIDs))
+			for _, userID := range userIDs {
+				connected[userID] = true
+			}
+			var gone []string
+			for userID := range registered {
+				if !connected[userID] {
+					gone = append(gone, userID)
+				}
+			}
+			h.removeUsers(ctx, gone)
+			h.registerUsers(ctx, userIDs)
+			registered = connected
+		}
+	}
+}
+
+// userChanged queues a registry update from the hub goroutine without blocking it
+func (h *Hub) userChanged(userID string, connected bool) {
+	select {
+	case h.userEvents <- userEvent{userID: userID, connected: connected}:
+	default:
+		h.logger.Warn("User registry falling behind, dropped update", "user_id", userID)
+	}
+}
+
+// registerUsers adds or refreshes this instance in the registry entries of userIDs
+func (h *Hub) registerUsers(ctx context.Context, userIDs []string) {
+	if len(userIDs) == 0 {
+		return
+	}
+
+	expiry := time.Now().Add(h.registryTTL)
+	pipe := h.redis.Pipeline()
+	for _, userID := range userIDs {
+		key := userInstancesKeyPrefix + userID
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(expiry.Unix()), Member: h.instanceID})
+		// Drop instances that stopped refreshing
+		pipe.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(time.Now().Unix(), 10))
+		pipe.Expire(ctx, key, h.registryTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		h.logger.Error("Failed to register users", "users", len(userIDs), "error", err)
+	}
+}
+
+// removeUsers removes this instance from the registry entries of userIDs
+func (h *Hub) removeUsers(ctx context.Context, userIDs []string) {
+	if len(userIDs) == 0 {
+		return
+	}
+
+	pipe := h.redis.Pipeline()
+	for _, userID := range userIDs {
+		pipe.ZRem(ctx, userInstancesKeyPrefix+userID, h.instanceID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		h.logger.Error("Failed to unregister users", "users", len(userIDs), "error", err)
+	}
+}
+
+// unregisterUsers removes all of this instance's entries at shutdown, when the
+// registry's context is already cancelled
+func (h *Hub) unregisterUsers(registered map[string]bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	userIDs := make([]string, 0, len(registered))
+	for userID := range registered {
+		userIDs = append(userIDs, userID)
+	}
+	h.removeUsers(ctx, userIDs)
+}
@@ -0,0 +1,101 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"chorus/pkg/eventbus"
+)
+
+// Redis channel carrying relayMessage between gateway instances
+const RelayChannel = "gateway:fanout"
+
+// relayEvents is the event bus topic of RelayChannel
+var relayEvents = eventbus.Topic[relayMessage]{Name: RelayChannel, Version: 1}
+
+// relayMessage is a message published by one gateway instance for the connections
+// of all the others, addressed to either a channel or a user
+type relayMessage struct {
+	Origin  string          `json:"origin"` // instance ID of the publisher, which delivered it already
+	Channel string          `json:"channel,omitempty"`
+	UserID  string          `json:"user_id,omitempty"`
+	Seq     int64           `json:"seq,omitempty"`      // number of the message in its stream
+	ID      string          `json:"id,omitempty"`       // of a direct message requiring an ack
+	ReplyTo string          `json:"reply_to,omitempty"` // list to push each instance's Delivery to
+	Data    json.RawMessage `json:"data"`
+}
+
+// Publish numbers message in the channel's stream and sends it to every member of
+// channel on this instance, and relays it over Redis to the members connected to
+// other instances. It waits up to wait, capped at MaxDeliveryWait, for those
+// instances to report their delivery; without waiting the Delivery covers this
+// instance only. A relay error means only local members got it.
+func (h *Hub) Publish(ctx context.Context, channel string, message []byte, wait time.Duration) (Delivery, error) {
+	seq := h.record(ctx, channelStream(channel), message)
+	delivery := h.deliver(channel, seq, message)
+	delivery.Instances = 1
+
+	wait = min(wait, MaxDeliveryWait)
+	var replyTo string
+	if wait > 0 {
+		replyTo = deliveryReplyKeyPrefix + newMessageID()
+	}
+	receivers, err := eventbus.PublishCounted(ctx, h.events, relayEvents, relayMessage{
+		Origin:  h.instanceID,
+		Channel: channel,
+		Seq:     seq,
+		ReplyTo: replyTo,
+		Data:    message,
+	})
+	if err != nil {
+		return delivery, fmt.Errorf("failed to relay message: %w", err)
+	}
+
+	// Every instance subscribes to the relay channel, this one included
+	others := max(int(receivers)-1, 0)
+	delivery.Unanswered = others
+	if others > 0 && wait > 0 {
+		if err := h.deliveryReplies(ctx, &delivery, replyTo, others, wait); err != nil {
+			return delivery, err
+		}
+	}
+	delivery.Complete = delivery.Unanswered == 0
+	delivery.NoMembers = delivery.Complete && delivery.Members == 0
+	return delivery, nil
+}
+
+// RunRelay delivers the messages other instances publish on RelayChannel to the
+// local members of their channels, and answers the admin requests they publish on
+// AdminChannel. It blocks until ctx is cancelled, resubscribing after connection
+// loss; messages published while unsubscribed are lost.
+func (h *Hub) RunRelay(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		eventbus.Subscribe(ctx, h.events, adminEvents, func(ctx context.Context, msg eventbus.Message[adminRequest]) error {
+			// Answering waits on the hub and Redis
+			go h.answerAdmin(msg.Data)
+			return nil
+		})
+	}()
+
+	eventbus.Subscribe(ctx, h.events, relayEvents, func(ctx context.Context, msg eventbus.Message[relayMessage]) error {
+		relayed := msg.Data
+		switch {
+		case relayed.Origin == h.instanceID:
+		case relayed.UserID != "":
+			h.deliverDirect(publication{userID: relayed.UserID, seq: relayed.Seq, message: relayed.Data, id: relayed.ID})
+		case ValidChannel(relayed.Channel):
+			delivery := h.deliver(relayed.Channel, relayed.Seq, relayed.Data)
+			if relayed.ReplyTo != "" {
+				go h.reportDelivery(relayed.ReplyTo, delivery)
+			}
+		}
+		return nil
+	})
+	wg.Wait()
+}
@@ -0,0 +1,110 @@
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waitForRelays waits until the relays of gateways subscribe to the relay and
+// admin channels
+func waitForRelays(t *testing.T, gateways ...*testGateway) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		counts, err := gateways[0].hub.redis.PubSubNumSub(context.Background(), RelayChannel, AdminChannel).Result()
+		if err == nil && counts[RelayChannel] == int64(len(gateways)) && counts[AdminChannel] == int64(len(gateways)) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("relays of %d instances did not subscribe", len(gateways))
+}
+
+func TestPublishReachesMembersOnOtherInstance(t *testing.T) {
+	mr := newTestRedis(t)
+	a := newTestGateway(t, mr.Addr(), "gateway-a")
+	b := newTestGateway(t, mr.Addr(), "gateway-b")
+	waitForRelays(t, a, b)
+
+	onA := a.dial(t, "alice")
+	onA.join("room.1")
+	onB := b.dial(t, "bob")
+	onB.join("room.1")
+
+	delivery, err := a.hub.Publish(context.Background(), "room.1", []byte(`{"text":"hello"}`), time.Second)
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	for name, conn := range map[string]*testConn{"local": onA, "remote": onB} {
+		frame := conn.expect(FrameMessage)
+		if frame.Channel != "room.1" || string(frame.Data) != `{"text":"hello"}` {
+			t.Errorf("%s member got %+v", name, frame)
+		}
+	}
+	// The publishing instance does not deliver its own relayed copy again
+	onA.quiet(100 * time.Millisecond)
+
+	if delivery.Instances != 2 || delivery.Members != 2 || !delivery.Complete {
+		t.Errorf("delivery = %+v, want 2 members over 2 instances, complete", delivery)
+	}
+}
+
+func TestSendToUserReachesConnectionsOnOtherInstance(t *testing.T) {
+	mr := newTestRedis(t)
+	a := newTestGateway(t, mr.Addr(), "gateway-a")
+	b := newTestGateway(t, mr.Addr(), "gateway-b")
+	waitForRelays(t, a, b)
+
+	onB := b.dial(t, "bob")
+	// The registry learns of the connection asynchronously
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		instances, err := a.hub.userInstances(context.Background(), "bob")
+		if err == nil && len(instances) == 1 && instances[0] == "gateway-b" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("registry lists bob on %v, want gateway-b", instances)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	result, err := a.hub.SendToUser(context.Background(), "bob", []byte(`{"text":"hi bob"}`), false)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if result.LocalConnections != 0 || len(result.Instances) != 1 || result.Instances[0] != "gateway-b" {
+		t.Errorf("send result = %+v, want it relayed to gateway-b only", result)
+	}
+
+	frame := onB.expect(FrameDirect)
+	if string(frame.Data) != `{"text":"hi bob"}` {
+		t.Errorf("direct frame data = %s", frame.Data)
+	}
+}
+
+func TestRelayResubscribesAfterRedisRestart(t *testing.T) {
+	mr := newTestRedis(t)
+	a := newTestGateway(t, mr.Addr(), "gateway-a")
+	b := newTestGateway(t, mr.Addr(), "gateway-b")
+	waitForRelays(t, a, b)
+
+	onB := b.dial(t, "bob")
+	onB.join("room.1")
+
+	// Dropping every connection makes both relays resubscribe after their backoff
+	mr.Close()
+	if err := mr.Restart(); err != nil {
+		t.Fatalf("restart Redis: %v", err)
+	}
+	waitForRelays(t, a, b)
+
+	if _, err := a.hub.Publish(context.Background(), "room.1", []byte(`{"text":"back"}`), 0); err != nil {
+		t.Fatalf("publish after the restart: %v", err)
+	}
+	if frame := onB.expect(FrameMessage); string(frame.Data) != `{"text":"back"}` {
+		t.Errorf("remote member got %+v", frame)
+	}
+}
@@ -0,0 +1,262 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Counter per stream handing out its sequence numbers
+	streamSeqKeyPrefix = "gateway_seq:"
+
+	// Sorted set per stream of its latest bufferedMessage, scored by sequence number
+	streamBufferKeyPrefix = "gateway_buffer:"
+
+	// How long a stream's counter outlives its last message. A stream restarting at 1
+	// after that makes resuming clients resync.
+	streamSeqTTL = 7 * 24 * time.Hour
+
+	// Time allowed to read a stream's buffer when a client resumes
+	resumeTimeout = 5 * time.Second
+)
+
+// Outcomes of resuming a stream, as labeled in gateway_resumes_total
+const (
+	resumeReplayed = "replayed"
+	resumeResync   = "resync"
+	resumeFailed   = "failed"
+)
+
+// Replay configures the buffers of recent messages clients resume from
+type Replay struct {
+	Size   int           // messages kept per stream, 0 disables sequence numbers and resuming
+	MaxAge time.Duration // how long a message is kept
+}
+
+// bufferedMessage is a message kept in a stream's buffer
+type bufferedMessage struct {
+	Seq  int64           `json:"seq"`
+	Data json.RawMessage `json:"data"`
+	At   time.Time       `json:"at"`
+}
+
+// sequencedFrame is a live frame held back while its client resumes the stream
+type sequencedFrame struct {
+	seq   int64
+	frame outbound
+}
+
+// resumeResult is the part of a stream a client missed, read off the hub goroutine
+type resumeResult struct {
+	client   *Client
+	stream   string
+	channel  string // "" for the user's stream
+	after    int64  // the last sequence number the client had
+	messages []bufferedMessage
+	latest   int64 // the stream's latest sequence number
+	complete bool  // whether messages are all the client missed
+	err      error
+}
+
+// Streams are numbered separately: one per channel and one per user
+func channelStream(channel string) string { return "channel:" + channel }
+func userStream(userID string) string     { return "user:" + userID }
+
+// SetReplay sets how many recent messages of each stream are kept for resuming clients
+func (h *Hub) SetReplay(replay Replay) {
+	h.replay = replay
+}
+
+// record gives message the next sequence number of stream, shared by all instances,
+// and keeps it in the stream's buffer. It returns 0, leaving the message unnumbered,
+// when replay is disabled or Redis cannot be reached.
+func (h *Hub) record(ctx context.Context, stream string, message []byte) int64 {
+	if h.replay.Size <= 0 {
+		return 0
+	}
+
+	seqKey := streamSeqKeyPrefix + stream
+	seq, err := h.redis.Incr(ctx, seqKey).Result()
+	if err != nil {
+		h.logger.Error("Failed to number message", "stream", stream, "error", err)
+		return 0
+	}
+
+	data, err := json.Marshal(bufferedMessage{Seq: seq, Data: message, At: time.Now()})
+	if err != nil {
+		return seq
+	}
+	bufferKey := streamBufferKeyPrefix + stream
+	pipe := h.redis.Pipeline()
+	pipe.Expire(ctx, seqKey, streamSeqTTL)
+	pipe.ZAdd(ctx, bufferKey, redis.Z{Score: float64(seq), Member: data})
+	pipe.ZRemRangeByRank(ctx, bufferKey, 0, int64(-h.replay.Size-1))
+	pipe.Expire(ctx, bufferKey, h.replay.MaxAge)
+	if _, err := pipe.Exec(ctx); err != nil {
+		h.logger.Error("Failed to buffer message", "stream", stream, "seq", seq, "error", err)
+	}
+	return seq
+}
+
+// missed returns the buffered messages of stream after sequence number after, oldest
+// first, and the stream's latest sequence number. It reports incomplete when the
+// buffer no longer reaches back to after, or when after is ahead of the stream.
+func (h *Hub) missed(ctx context.Context, stream string, after int64) ([]bufferedMessage, int64, bool, error) {
+	pipe := h.redis.Pipeline()
+	latestCmd := pipe.Get(ctx, streamSeqKeyPrefix+stream)
+	entriesCmd := pipe.ZRangeByScore(ctx, streamBufferKeyPrefix+stream, &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(after, 10),
+		Max: "+inf",
+	})
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, false, err
+	}
+	latest, err := latestCmd.Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, false, err
+	}
+	if after > latest {
+		// The stream restarted since
+		return nil, latest, false, nil
+	}
+
+	cutoff := time.Now().Add(-h.replay.MaxAge)
+	messages := make([]bufferedMessage, 0, len(entriesCmd.Val()))
+	for _, data := range entriesCmd.Val() {
+		var message bufferedMessage
+		if err := json.Unmarshal([]byte(data), &message); err != nil || message.At.Before(cutoff) {
+			continue
+		}
+		messages = append(messages, message)
+	}
+
+	// Any gap means messages were trimmed or expired. Messages numbered but not yet
+	// buffered, past the last one, are still on their way live.
+	if len(messages) == 0 && latest > after {
+		return nil, latest, false, nil
+	}
+	for i, message := range messages {
+		if message.Seq != after+int64(i)+1 {
+			return nil, latest, false, nil
+		}
+	}
+	return messages, latest, true, nil
+}
+
+// ResumeFrom makes the client, before it is served, replay the direct messages after
+// sequence number seq before live ones
+func (c *Client) ResumeFrom(seq int64) {
+	c.resumeFrom = &seq
+}
+
+// startResume holds back live frames of stream for the client while the messages it
+// missed after sequence number after are read, from the hub goroutine
+func (h *Hub) startResume(client *Client, stream, channel string, after int64) {
+	if h.replay.Size <= 0 {
+		resumesTotal.Inc(resumeResync)
+		h.reply(client, ServerFrame{Type: FrameResync, Channel: channel})
+		return
+	}
+
+	if client.resuming == nil {
+		client.resuming = make(map[string][]sequencedFrame)
+	}
+	client.resuming[stream] = nil
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), resumeTimeout)
+		defer cancel()
+		messages, latest, complete, err := h.missed(ctx, stream, after)
+		h.resumed <- resumeResult{
+			client:   client,
+			stream:   stream,
+			channel:  channel,
+			after:    after,
+			messages: messages,
+			latest:   latest,
+			complete: complete,
+			err:      err,
+		}
+	}()
+}
+
+// finishResume sends a client the messages it missed on a stream, or a resync frame
+// if they are gone, followed by the live frames held back meanwhile, from the hub
+// goroutine
+func (h *Hub) finishResume(result resumeResult) {
+	client := result.client
+	held, ok := client.resuming[result.stream]
+	if _, registered := h.clients[client]; !registered || !ok {
+		return
+	}
+	delete(client.resuming, result.stream)
+	if result.channel != "" && !client.channels[result.channel] {
+		// Left meanwhile
+		return
+	}
+
+	frameType, rule := FrameDirect, h.queueRule(result.channel)
+	if result.channel != "" {
+		frameType = FrameMessage
+	}
+
+	// Live frames up to this sequence number were replayed or covered by the resync
+	last := result.latest
+	switch {
+	case result.err != nil:
+		resumesTotal.Inc(resumeFailed)
+		client.logger.Error("Failed to resume stream", "stream", result.stream, "error", result.err)
+		h.reply(client, ServerFrame{Type: FrameResync, Channel: result.channel})
+		last = 0
+
+	case !result.complete:
+		resumesTotal.Inc(resumeResync)
+		h.reply(client, ServerFrame{Type: FrameResync, Channel: result.channel, Seq: result.latest})
+
+	default:
+		resumesTotal.Inc(resumeReplayed)
+		last = result.after
+		for _, message := range result.messages {
+			frame, err := marshalFrame(ServerFrame{
+				Type:    frameType,
+				Channel: result.channel,
+				Seq:     message.Seq,
+				Data:    message.Data,
+			})
+			if err != nil {
+				continue
+			}
+			if !h.enqueue(client, outbound{channel: result.channel, data: frame}, rule) {
+				return
+			}
+			last = message.Seq
+		}
+	}
+
+	for _, frame := range held {
+		if frame.seq != 0 && frame.seq <= last {
+			continue
+		}
+		if !h.enqueue(client, frame.frame, rule) {
+			return
+		}
+	}
+}
+
+// deliverLive queues a live frame of stream for the client, or holds it back while
+// the client replays its offline queue or resumes the stream
+func (h *Hub) deliverLive(client *Client, stream string, seq int64, frame outbound, rule QueueRule) bool {
+	if client.replayingOffline {
+		client.offlineHeld = append(client.offlineHeld, heldFrame{stream: stream, seq: seq, frame: frame, rule: rule})
+		return true
+	}
+	if held, ok := client.resuming[stream]; ok {
+		client.resuming[stream] = append(held, sequencedFrame{seq: seq, frame: frame})
+		return true
+	}
+	return h.enqueue(client, frame, rule)
+}
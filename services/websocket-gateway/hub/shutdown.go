@@ -0,0 +1,108 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Clients are told to reconnect within this long, each at a random point of it, so
+// they do not all land on the remaining instances at once
+const reconnectSpread = 5 * time.Second
+
+// closeHint is the reason of the close frame sent at shutdown
+type closeHint struct {
+	Reason       string `json:"reason"`
+	Reconnect    bool   `json:"reconnect"`
+	RetryAfterMs int64  `json:"retry_after_ms"`
+}
+
+// Draining reports whether the hub is draining or shutting down and refuses new
+// connections
+func (h *Hub) Draining() bool {
+	return h.draining.Load()
+}
+
+// Shutdown refuses new connections and closes every open one with a 1001 close frame
+// carrying a reconnect hint, in batches over the configured shutdown drain, which
+// ends grace before ctx's deadline; a drain already running is hurried along to
+// end by then too. Messages already queued are sent first, and clients get grace to
+// answer the close frame before their connection is closed. It returns once all
+// connections are closed, or with ctx's error when ctx ends first.
+func (h *Hub) Shutdown(ctx context.Context, grace time.Duration) error {
+	if h.stopping.Swap(true) {
+		return nil
+	}
+	h.draining.Store(true)
+	h.closeGrace = grace
+	deadline := time.Now().Add(h.drainConfig.Shutdown)
+	// Batches go out on the hub's one second ticker, so the last one may be a second late
+	if end, ok := ctx.Deadline(); ok && end.Add(-grace-time.Second).Before(deadline) {
+		deadline = end.Add(-grace - time.Second)
+	}
+	h.shutdown <- deadline
+
+	closed := make(chan struct{})
+	go func() {
+		h.connections.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startShutdown drains the connections by deadline, or closes them all at once if it
+// has passed, from the hub goroutine
+func (h *Hub) startShutdown(deadline time.Time) {
+	if time.Now().Before(deadline) {
+		h.beginDrain(drainReasonShutdown, deadline)
+		return
+	}
+	h.closeAll()
+}
+
+// closeAll removes every client with a going away close frame, from the hub goroutine
+func (h *Hub) closeAll() {
+	for client := range h.clients {
+		h.goAway(client, closeShutdown, drainReasonShutdown, 0)
+		h.remove(client)
+	}
+	h.logger.Info("Closing all connections for shutdown")
+}
+
+// goAway sets the close frame the client's write pump sends once its queue is empty,
+// recording reason as why it closed and telling the client hint, and the wait for
+// the client to answer, 0 for the shutdown's grace
+func (h *Hub) goAway(client *Client, reason, hint string, wait time.Duration) {
+	client.closedFor(reason)
+	message, _ := json.Marshal(closeHint{
+		Reason:       hint,
+		Reconnect:    true,
+		RetryAfterMs: rand.Int63n(reconnectSpread.Milliseconds()),
+	})
+	client.closeWith(websocket.CloseGoingAway, string(message), wait)
+}
+
+// closeGracefully sends the client's close frame and waits up to its close wait, or
+// the hub's close grace, for the client to answer it before the connection is closed
+func (c *Client) closeGracefully() {
+	err := c.conn.WriteControl(websocket.CloseMessage, c.closeMessage, time.Now().Add(writeWait))
+	if err != nil {
+		return
+	}
+	wait := c.closeWait
+	if wait == 0 {
+		wait = c.hub.closeGrace
+	}
+	select {
+	case <-c.done:
+	case <-time.After(wait):
+	}
+}
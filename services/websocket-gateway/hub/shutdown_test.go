@@ -0,0 +1,74 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// expectClose reads until the connection closes and returns the close frame's hint,
+// failing the test unless its code is code
+func (c *testConn) expectClose(code int) closeHint {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := c.conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			c.t.Fatalf("connection ended without a close frame: %v", err)
+		}
+		if closeErr.Code != code {
+			c.t.Fatalf("close code = %d, want %d", closeErr.Code, code)
+		}
+		var hint closeHint
+		if err := json.Unmarshal([]byte(closeErr.Text), &hint); err != nil {
+			c.t.Fatalf("close reason %q is not a reconnect hint: %v", closeErr.Text, err)
+		}
+		return hint
+	}
+}
+
+func TestShutdownSendsGoingAway(t *testing.T) {
+	mr := newTestRedis(t)
+	g := newTestGateway(t, mr.Addr(), "gateway-a")
+
+	conns := []*testConn{g.dial(t, "alice"), g.dial(t, "bob")}
+	conns[0].join("room.1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- g.hub.Shutdown(ctx, 100*time.Millisecond) }()
+
+	for _, conn := range conns {
+		hint := conn.expectClose(websocket.CloseGoingAway)
+		if hint.Reason != drainReasonShutdown || !hint.Reconnect {
+			t.Errorf("close hint = %+v, want a shutdown reconnect hint", hint)
+		}
+		if hint.RetryAfterMs < 0 || hint.RetryAfterMs >= reconnectSpread.Milliseconds() {
+			t.Errorf("retry_after_ms = %d, want within %s", hint.RetryAfterMs, reconnectSpread)
+		}
+		// Answer the close frame, as clients do
+		conn.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	}
+
+	if err := <-shutdown; err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if !g.hub.Draining() {
+		t.Error("hub does not report draining after shutdown")
+	}
+
+	// A connection upgraded as the shutdown began is turned away too
+	late := g.dial(t, "carol")
+	if hint := late.expectClose(websocket.CloseGoingAway); hint.Reason != drainReasonShutdown {
+		t.Errorf("late connection close hint = %+v, want a shutdown hint", hint)
+	}
+}
@@ -0,0 +1,236 @@
+package hub
+
+import (
+	"sort"
+	"time"
+)
+
+// Why connections closed, as labeled in gateway_connections_closed_total
+const (
+	closeClient          = "client" // closed by the client, or the connection dropped
+	closePongTimeout     = reapPongTimeout
+	closeIdle            = reapIdle
+	closeMessageTooLarge = "message_too_large"
+	closeSlowConsumer    = "slow_consumer"
+	closeConnectionLimit = "connection_limit"
+	closeReplaced        = "replaced" // by a newer connection of the user, over the connection limit
+	closeShutdown        = "shutdown"
+	closeDrained         = "drained"      // through the admin API, for a deploy
+	closeDisconnected    = "disconnected" // through the admin API
+	closeTokenExpired    = "token_expired"
+)
+
+var closeReasons = []string{
+	closeClient,
+	closePongTimeout,
+	closeIdle,
+	closeMessageTooLarge,
+	closeSlowConsumer,
+	closeConnectionLimit,
+	closeReplaced,
+	closeShutdown,
+	closeDrained,
+	closeDisconnected,
+	closeTokenExpired,
+}
+
+// Whether connections carry a token, as labeled in gateway_connections
+const (
+	authAuthenticated = "authenticated"
+	authAnonymous     = "anonymous"
+)
+
+// Buckets of channel sizes, as labeled in gateway_channels_by_members, instead of a
+// series per channel
+var memberBuckets = []struct {
+	label string
+	max   int
+}{
+	{"1", 1},
+	{"2-10", 10},
+	{"11-100", 100},
+	{"101-1000", 1000},
+	{"1000+", int(^uint(0) >> 1)},
+}
+
+// Kinds of fanout, as labeled in gateway_fanout_seconds
+const (
+	fanoutChannel = "channel"
+	fanoutDirect  = "direct"
+)
+
+// Channels listed by Stats, the largest first
+const statsTopChannels = 10
+
+// Stats summarizes the gateway metrics of this instance, as served on GET /stats
+type Stats struct {
+	InstanceID  string          `json:"instance_id"`
+	Connections ConnectionStats `json:"connections"`
+	Users       int             `json:"users"` // with at least one connection
+	Channels    ChannelStats    `json:"channels"`
+	Messages    MessageStats    `json:"messages"`
+	Fanout      FanoutStats     `json:"fanout"`
+	SendQueue   SendQueueStats  `json:"send_queue"`
+}
+
+type ConnectionStats struct {
+	Open          int                `json:"open"`
+	Authenticated int                `json:"authenticated"`
+	Anonymous     int                `json:"anonymous"`
+	Opened        float64            `json:"opened"`
+	Closed        map[string]float64 `json:"closed"` // by reason
+}
+
+type ChannelStats struct {
+	Count     int                 `json:"count"`      // with at least one member here
+	ByMembers map[string]int      `json:"by_members"` // channels per bucket of member counts
+	Top       []ChannelMembership `json:"top"`
+}
+
+type ChannelMembership struct {
+	Channel string `json:"channel"`
+	Members int    `json:"members"`
+}
+
+type MessageStats struct {
+	Received      float64 `json:"received"`
+	ReceivedBytes float64 `json:"received_bytes"`
+	Sent          float64 `json:"sent"`
+	SentBytes     float64 `json:"sent_bytes"`
+}
+
+// FanoutStats times handing messages to the hub until they are queued for every
+// local recipient, by kind: channel or direct
+type FanoutStats struct {
+	Channel FanoutLatency `json:"channel"`
+	Direct  FanoutLatency `json:"direct"`
+}
+
+type FanoutLatency struct {
+	Count     uint64  `json:"count"`
+	AverageMs float64 `json:"average_ms"`
+}
+
+type SendQueueStats struct {
+	Dropped       float64 `json:"dropped"`        // frames of lossy channels
+	SlowConsumers float64 `json:"slow_consumers"` // connections closed
+}
+
+// Stats returns the metrics of this instance, asking the hub goroutine for what it
+// tracks itself
+func (h *Hub) Stats() Stats {
+	reply := make(chan Stats, 1)
+	h.stats <- reply
+	stats := <-reply
+
+	stats.InstanceID = h.instanceID
+	stats.Connections.Opened = connectionsOpenedTotal.Value()
+	stats.Connections.Closed = make(map[string]float64, len(closeReasons))
+	for _, reason := range closeReasons {
+		stats.Connections.Closed[reason] = connectionsClosedTotal.Value(reason)
+	}
+	stats.Messages = MessageStats{
+		Received:      messagesReceivedTotal.Value(),
+		ReceivedBytes: bytesReceivedTotal.Value(),
+		Sent:          messagesSentTotal.Value(),
+		SentBytes:     bytesSentTotal.Value(),
+	}
+	stats.Fanout = FanoutStats{
+		Channel: fanoutLatency(fanoutChannel),
+		Direct:  fanoutLatency(fanoutDirect),
+	}
+	stats.SendQueue = SendQueueStats{
+		Dropped:       sendQueueDroppedTotal.Value(),
+		SlowConsumers: slowConsumersTotal.Value(),
+	}
+	return stats
+}
+
+func fanoutLatency(kind string) FanoutLatency {
+	count, sum := fanoutSeconds.Stats(kind)
+	latency := FanoutLatency{Count: count}
+	if count > 0 {
+		latency.AverageMs = sum / float64(count) * 1000
+	}
+	return latency
+}
+
+// liveStats fills in what the hub goroutine tracks: connections, users and channels
+func (h *Hub) liveStats() Stats {
+	var stats Stats
+	stats.Connections.Open = len(h.clients)
+	for client := range h.clients {
+		if client.authLabel() == authAuthenticated {
+			stats.Connections.Authenticated++
+		} else {
+			stats.Connections.Anonymous++
+		}
+	}
+	stats.Users = len(h.users)
+
+	stats.Channels.Count = len(h.channels)
+	stats.Channels.ByMembers = make(map[string]int, len(memberBuckets))
+	for _, bucket := range memberBuckets {
+		stats.Channels.ByMembers[bucket.label] = 0
+	}
+	top := make([]ChannelMembership, 0, len(h.channels))
+	for channel, members := range h.channels {
+		stats.Channels.ByMembers[memberBucket(len(members))]++
+		top = append(top, ChannelMembership{Channel: channel, Members: len(members)})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Members != top[j].Members {
+			return top[i].Members > top[j].Members
+		}
+		return top[i].Channel < top[j].Channel
+	})
+	stats.Channels.Top = top[:min(len(top), statsTopChannels)]
+	return stats
+}
+
+// memberBucket names the bucket of a channel with members members
+func memberBucket(members int) string {
+	for _, bucket := range memberBuckets {
+		if members <= bucket.max {
+			return bucket.label
+		}
+	}
+	return memberBuckets[len(memberBuckets)-1].label
+}
+
+// channelResized moves a channel between the buckets of gateway_channels_by_members
+// as its local members go from before to after, from the hub goroutine
+func (h *Hub) channelResized(before, after int) {
+	if before > 0 && (after == 0 || memberBucket(before) != memberBucket(after)) {
+		channelsByMembers.Add(-1, memberBucket(before))
+	}
+	if after > 0 && (before == 0 || memberBucket(before) != memberBucket(after)) {
+		channelsByMembers.Add(1, memberBucket(after))
+	}
+	channelsGauge.Set(float64(len(h.channels)))
+}
+
+// authLabel tells connections with a token apart, for gateway_connections
+func (c *Client) authLabel() string {
+	if c.tokenClaims() != nil {
+		return authAuthenticated
+	}
+	return authAnonymous
+}
+
+// closedFor records why the gateway closes the client, unless a reason was recorded
+// already, for gateway_connections_closed_total
+func (c *Client) closedFor(reason string) {
+	c.closeReason.CompareAndSwap(nil, reason)
+}
+
+// closedWith records the code of the connection's close frame, keeping the first one
+// sent or received
+func (c *Client) closedWith(code int) {
+	c.closeCode.CompareAndSwap(0, int64(code))
+}
+
+// observeFanout records how long handing a message to the hub took, since start
+func observeFanout(kind string, start time.Time) {
+	fanoutSeconds.Observe(time.Since(start).Seconds(), kind)
+}
@@ -0,0 +1,140 @@
+package hub
+
+import (
+	"encoding/json"
+	"time"
+
+	"chorus/pkg/auth"
+	"github.com/gorilla/websocket"
+)
+
+// Close code of connections whose token expired without being refreshed
+const CloseTokenExpired = 4001
+
+// Tokens configures how the expiry of the connections' tokens is handled
+type Tokens struct {
+	Validator  *auth.Validator // checks the tokens of refresh_token frames, nil refusing them
+	Enforce    bool            // close connections whose token expired, rather than only warning them
+	WarnBefore time.Duration   // how long before its token expires a connection gets token_expiring, 0 for no warning
+}
+
+// SetTokens sets how the expiry of the tokens of connections opened from now on is
+// handled
+func (h *Hub) SetTokens(tokens Tokens) {
+	h.tokens = tokens
+}
+
+// tokenExpiry returns the exp claim, in unix seconds, 0 for a token that never expires
+func tokenExpiry(claims map[string]any) int64 {
+	switch exp := claims["exp"].(type) {
+	case float64:
+		return int64(exp)
+	case json.Number:
+		seconds, _ := exp.Int64()
+		return seconds
+	default:
+		return 0
+	}
+}
+
+// tokenClaims returns the claims of the client's current token
+func (c *Client) tokenClaims() map[string]any {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	return c.claims
+}
+
+// bearerToken returns the client's current token, for asking other services about
+// its user
+func (c *Client) bearerToken() string {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	return c.token
+}
+
+// setToken sets the client's token and its claims and tells its write pump when the
+// token now expires
+func (c *Client) setToken(token string, claims map[string]any) {
+	c.tokenMu.Lock()
+	c.token = token
+	c.claims = claims
+	c.tokenMu.Unlock()
+	c.expiresAt.Store(tokenExpiry(claims))
+	select {
+	case c.tokenChanged <- struct{}{}:
+	default:
+	}
+}
+
+// validateRefresh checks the token of a refresh_token frame, which must be of the
+// client's user, and returns its claims. Validating may fetch signing keys, so it
+// runs off the hub goroutine.
+func (h *Hub) validateRefresh(client *Client, token string) (map[string]any, *ProtocolError) {
+	if h.tokens.Validator == nil {
+		return nil, &ProtocolError{Code: CodeInvalidToken, Message: "token refresh is not supported"}
+	}
+	claims, err := h.tokens.Validator.Validate(token)
+	if err != nil {
+		client.logger.Warn("Token refresh refused", "reason", auth.Reason(err))
+		return nil, &ProtocolError{Code: CodeInvalidToken, Message: "token is invalid: " + auth.Reason(err)}
+	}
+	if claims.UserID != client.userID {
+		client.logger.Warn("Token refresh refused", "reason", "other_user", "token_user_id", claims.UserID)
+		return nil, &ProtocolError{Code: CodeInvalidToken, Message: "token is of another user"}
+	}
+	return claims.Raw, nil
+}
+
+// refreshToken replaces the client's token with the one of a refresh_token frame,
+// from the hub goroutine. Channels joined stay joined.
+func (h *Hub) refreshToken(client *Client, frame ClientFrame) {
+	client.setToken(frame.Token, frame.refreshed)
+	expiresAt := client.expiresAt.Load()
+	client.logger.Info("Token refreshed", "expires_at", expiresAt)
+	h.reply(client, ServerFrame{Type: FrameTokenRefreshed, ID: frame.ID, ExpiresAt: expiresAt})
+}
+
+// nextTokenEvent returns how long until the client is to be warned that its token is
+// about to expire, or once warned until it expires, and false if it never expires
+func (c *Client) nextTokenEvent(warned bool) (time.Duration, bool) {
+	expiresAt := c.expiresAt.Load()
+	if expiresAt == 0 {
+		return 0, false
+	}
+	until := time.Until(time.Unix(expiresAt, 0))
+	if !warned && c.hub.tokens.WarnBefore > 0 {
+		return max(until-c.hub.tokens.WarnBefore, 0), true
+	}
+	return max(until, 0), true
+}
+
+// tokenEvent warns the client that its token is about to expire, or closes it with
+// CloseTokenExpired once it has, from the write pump. It reports whether the
+// connection was closed.
+func (c *Client) tokenEvent(warned *bool) bool {
+	expiresAt := c.expiresAt.Load()
+	if expiresAt == 0 {
+		return false
+	}
+	if time.Now().Unix() < expiresAt {
+		if !*warned && c.hub.tokens.WarnBefore > 0 {
+			*warned = true
+			c.hub.reply(c, ServerFrame{Type: FrameTokenExpiring, ExpiresAt: expiresAt})
+		}
+		return false
+	}
+	if !c.hub.tokens.Enforce {
+		return false
+	}
+
+	c.reaped.Store(true)
+	c.closedFor(closeTokenExpired)
+	c.closedWith(CloseTokenExpired)
+	c.logger.Info("Closing connection: token expired", "expires_at", expiresAt)
+	c.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(CloseTokenExpired, "token expired"),
+		time.Now().Add(writeWait),
+	)
+	return true
+}
@@ -0,0 +1,170 @@
+package hub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/tracing"
+)
+
+// Outcomes of workflow.trigger frames, as labeled in gateway_workflow_triggers_total
+const (
+	triggerCreated     = "created"
+	triggerRefused     = "refused"      // by the engine, such as for an unknown template
+	triggerFailed      = "failed"       // the engine could not be reached or failed
+	triggerRateLimited = "rate_limited" // by the gateway
+)
+
+// Name of the instances triggered without one
+const defaultTriggerName = "Triggered over WebSocket"
+
+// triggerResult is the outcome of creating a workflow instance for a client's
+// workflow.trigger frame, handed back to the hub goroutine
+type triggerResult struct {
+	client   *Client
+	frame    ClientFrame
+	instance json.RawMessage // instance_id, name and status of the instance created
+	err      *ProtocolError
+}
+
+// createdInstance is the part of the engine's created instance the gateway reads
+type createdInstance struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// triggerWorkflow applies a client's workflow.trigger frame, from the hub goroutine:
+// it applies the user's trigger rate limit, then creates the instance off the hub
+// goroutine
+func (h *Hub) triggerWorkflow(client *Client, frame ClientFrame) {
+	if h.workflows.EngineURL == "" {
+		h.refuse(client, frame, &ProtocolError{Code: CodeUnavailable, Message: "workflows cannot be triggered here"})
+		return
+	}
+	if !client.triggerLimiter.allow(time.Now()) {
+		workflowTriggersTotal.Inc(triggerRateLimited)
+		limitViolationsTotal.Inc(limitWorkflowTriggers)
+		client.logger.Warn("Limit exceeded", "limit", limitWorkflowTriggers, "action", "drop_message")
+		h.refuse(client, frame, &ProtocolError{Code: CodeRateLimited, Message: "workflow trigger rate limit exceeded, frame dropped"})
+		return
+	}
+
+	go func() {
+		instance, err := h.createInstance(client, frame)
+		h.triggered <- triggerResult{client: client, frame: frame, instance: instance, err: err}
+	}()
+}
+
+// finishTrigger answers a workflow.trigger frame once the instance is created, first
+// joining the client to the instance's channel if it asked to subscribe
+func (h *Hub) finishTrigger(result triggerResult) {
+	client, frame := result.client, result.frame
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+	if result.err != nil {
+		h.refuse(client, frame, result.err)
+		return
+	}
+
+	var instance struct {
+		ID string `json:"instance_id"`
+	}
+	json.Unmarshal(result.instance, &instance)
+	client.logger.Info("Workflow triggered", "template_id", frame.TemplateID, "instance_id", instance.ID)
+
+	channel := ""
+	if frame.Subscribe {
+		// The user created the instance, so may follow it
+		channel = WorkflowChannelPrefix + instance.ID
+		if err := h.join(client, channel); err != nil {
+			h.refuse(client, ClientFrame{Type: ActionJoin, ID: frame.ID, Channel: channel}, frameErrorOf(err))
+			channel = ""
+		}
+	}
+	h.reply(client, ServerFrame{Type: FrameWorkflowTriggered, ID: frame.ID, Channel: channel, Data: result.instance})
+}
+
+// createInstance creates an instance of the frame's template in the workflow engine
+// with the gateway's API key, on behalf of the client's user, who becomes its
+// creator
+func (h *Hub) createInstance(client *Client, frame ClientFrame) (json.RawMessage, *ProtocolError) {
+	name := frame.Name
+	if name == "" {
+		name = defaultTriggerName
+	}
+	body, err := json.Marshal(map[string]any{
+		"template_id": frame.TemplateID,
+		"name":        name,
+		"variables":   frame.Variables,
+		"context":     map[string]string{"source": "websocket", "connection_id": client.id},
+	})
+	if err != nil {
+		return nil, invalidFrame("payload.variables must be an object")
+	}
+
+	ctx, cancel := context.WithTimeout(frame.ctx, workflowTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.workflows.EngineURL+"/api/v1/instances", bytes.NewReader(body))
+	if err != nil {
+		return nil, h.triggerFailed(client, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", h.workflows.APIKey)
+	req.Header.Set("X-On-Behalf-Of", client.userID)
+	if team, _ := client.tokenClaims()["team"].(string); team != "" {
+		req.Header.Set("X-On-Behalf-Of-Team", team)
+	}
+
+	resp, err := tracing.Client.Do(req)
+	if err != nil {
+		return nil, h.triggerFailed(client, err)
+	}
+	defer resp.Body.Close()
+
+	var refusal apierror.Error
+	switch resp.StatusCode {
+	case http.StatusCreated:
+	case http.StatusBadRequest:
+		workflowTriggersTotal.Inc(triggerRefused)
+		json.NewDecoder(resp.Body).Decode(&refusal)
+		if details, ok := refusal.Details.(string); ok {
+			return nil, invalidFrame("workflow engine refused the payload: %s", details)
+		}
+		return nil, invalidFrame("workflow engine refused the payload: %s", refusal.Message)
+	case http.StatusNotFound:
+		workflowTriggersTotal.Inc(triggerRefused)
+		return nil, &ProtocolError{Code: CodeNotFound, Message: "template not found, inactive or not visible to the user"}
+	case http.StatusTooManyRequests:
+		workflowTriggersTotal.Inc(triggerRefused)
+		return nil, &ProtocolError{Code: CodeRateLimited, Message: "workflow engine rate limit exceeded, retry later"}
+	default:
+		return nil, h.triggerFailed(client, fmt.Errorf("workflow engine answered %s", resp.Status))
+	}
+
+	var created createdInstance
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil || created.ID == "" {
+		return nil, h.triggerFailed(client, fmt.Errorf("failed to decode created instance: %v", err))
+	}
+	workflowTriggersTotal.Inc(triggerCreated)
+	instance, _ := json.Marshal(map[string]string{
+		"instance_id": created.ID,
+		"name":        created.Name,
+		"status":      created.Status,
+	})
+	return instance, nil
+}
+
+// triggerFailed logs why an instance could not be created, answering the client it
+// may try again
+func (h *Hub) triggerFailed(client *Client, err error) *ProtocolError {
+	workflowTriggersTotal.Inc(triggerFailed)
+	client.logger.Error("Failed to trigger workflow", "error", err)
+	return &ProtocolError{Code: CodeUnavailable, Message: ErrWorkflowUnavailable.Error()}
+}
@@ -0,0 +1,248 @@
+package hub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"chorus/pkg/eventbus"
+	"chorus/pkg/tracing"
+)
+
+// Channels carrying the status changes of one user, followed by their ID
+const PresenceChannelPrefix = "presence:user:"
+
+var (
+	ErrTooManyWatched       = errors.New("connection watches the maximum number of users")
+	ErrPresenceUnavailable  = errors.New("presence could not be authorized, try again")
+	ErrPresenceWatchTooLong = errors.New("presence.watch names more users than a connection may watch")
+)
+
+// presenceWatch is what a client may see of the users a join or presence.watch frame
+// names, worked out off the hub goroutine
+type presenceWatch struct {
+	allowed []string
+	denied  []string
+	users   map[string]json.RawMessage // current presence of the allowed users, by ID, when the presence service answered
+}
+
+// presenceAuthzRequest is the body of the request asking PresenceAuthorizeURL which
+// users the watcher may see
+type presenceAuthzRequest struct {
+	UserID  string         `json:"user_id"`
+	Claims  map[string]any `json:"claims"`
+	UserIDs []string       `json:"user_ids"`
+}
+
+type presenceAuthzResponse struct {
+	Allowed []string `json:"allowed"`
+}
+
+// PresenceChannel reports whether channel carries the status changes of a user,
+// which only the presence service publishes to
+func PresenceChannel(channel string) bool {
+	return strings.HasPrefix(channel, PresenceChannelPrefix)
+}
+
+// presenceChannels counts the presence channels the client joined, which count
+// toward Presence.WatchMax rather than the channel limit
+func (c *Client) presenceChannels() int {
+	count := 0
+	for channel := range c.channels {
+		if PresenceChannel(channel) {
+			count++
+		}
+	}
+	return count
+}
+
+// authorizePresence works out which of userIDs the client may watch: those whose
+// channels the channel rules allow, and then, with an authorization URL, those it
+// answers with. It fetches their current presence as a snapshot. It waits on other
+// services, so it runs off the hub goroutine.
+func (h *Hub) authorizePresence(ctx context.Context, client *Client, userIDs []string) (*presenceWatch, error) {
+	if h.presence.WatchMax > 0 && len(userIDs) > h.presence.WatchMax {
+		return nil, ErrPresenceWatchTooLong
+	}
+
+	watch := &presenceWatch{}
+	claims := client.tokenClaims()
+	var candidates []string
+	for _, userID := range userIDs {
+		channel := PresenceChannelPrefix + userID
+		if rule, err := h.AuthorizeChannel(channel, claims); err != nil {
+			client.logger.Info("Channel join denied", "channel", channel, "rule", rule)
+			watch.denied = append(watch.denied, userID)
+			continue
+		}
+		candidates = append(candidates, userID)
+	}
+
+	if h.presence.AuthorizeURL != "" && len(candidates) > 0 {
+		allowed, err := h.presenceAllowed(ctx, client, candidates, claims)
+		if err != nil {
+			client.logger.Error("Failed to authorize presence watch", "users", len(candidates), "error", err)
+			return nil, ErrPresenceUnavailable
+		}
+		for _, userID := range candidates {
+			if slices.Contains(allowed, userID) {
+				watch.allowed = append(watch.allowed, userID)
+				continue
+			}
+			client.logger.Info("Channel join denied", "channel", PresenceChannelPrefix+userID, "rule", "presence")
+			watch.denied = append(watch.denied, userID)
+		}
+	} else {
+		watch.allowed = candidates
+	}
+
+	if h.presence.URL != "" && len(watch.allowed) > 0 {
+		// The snapshot is optional; events still arrive without it
+		users, err := h.presenceSnapshot(ctx, watch.allowed)
+		if err != nil {
+			client.logger.Error("Failed to fetch presence snapshot", "users", len(watch.allowed), "error", err)
+		}
+		watch.users = users
+	}
+	return watch, nil
+}
+
+// presenceAllowed asks the authorization URL which of userIDs the client may watch
+func (h *Hub) presenceAllowed(ctx context.Context, client *Client, userIDs []string, claims map[string]any) ([]string, error) {
+	body, err := json.Marshal(presenceAuthzRequest{UserID: client.userID, Claims: claims, UserIDs: userIDs})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, presenceTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.presence.AuthorizeURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.presence.AuthorizeAPIKey != "" {
+		req.Header.Set("X-API-Key", h.presence.AuthorizeAPIKey)
+	}
+
+	resp, err := tracing.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("presence authorization answered %s", resp.Status)
+	}
+
+	var result presenceAuthzResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode presence authorization: %w", err)
+	}
+	return result.Allowed, nil
+}
+
+// presenceSnapshot fetches the current presence of users from the presence service's
+// POST /presence/watch, which answers right away when not given a token
+func (h *Hub) presenceSnapshot(ctx context.Context, userIDs []string) (map[string]json.RawMessage, error) {
+	body, err := json.Marshal(map[string][]string{"user_ids": userIDs})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, presenceTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.presence.URL+"/presence/watch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.presence.APIKey != "" {
+		req.Header.Set("X-API-Key", h.presence.APIKey)
+	}
+
+	resp, err := tracing.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("presence service answered %s", resp.Status)
+	}
+
+	var result struct {
+		Users []json.RawMessage `json:"users"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode presence snapshot: %w", err)
+	}
+	users := make(map[string]json.RawMessage, len(result.Users))
+	for _, user := range result.Users {
+		var presence struct {
+			UserID string `json:"user_id"`
+		}
+		if json.Unmarshal(user, &presence) == nil && presence.UserID != "" {
+			users[presence.UserID] = user
+		}
+	}
+	return users, nil
+}
+
+// watchPresence applies a client's presence.watch frame, from the hub goroutine: it
+// joins the channels of the users the client may watch and answers with those it
+// watches, those it may not and their current presence
+func (h *Hub) watchPresence(client *Client, frame ClientFrame) {
+	watch := frame.watch
+	watching := []string{}
+	denied := append([]string{}, watch.denied...)
+	users := []json.RawMessage{}
+	limited := false
+	for _, userID := range watch.allowed {
+		if err := h.join(client, PresenceChannelPrefix+userID); err != nil {
+			limited = limited || errors.Is(err, ErrTooManyWatched)
+			denied = append(denied, userID)
+			continue
+		}
+		watching = append(watching, userID)
+		if user, ok := watch.users[userID]; ok {
+			users = append(users, user)
+		}
+	}
+	if limited {
+		client.logger.Warn("Limit exceeded", "limit", limitPresenceWatch, "action", "deny_watch")
+		limitViolationsTotal.Inc(limitPresenceWatch)
+	}
+	client.logger.Info("Presence watched", "users", len(watching), "denied", len(denied))
+
+	data, err := json.Marshal(map[string]any{
+		"watching": watching,
+		"denied":   denied,
+		"users":    users,
+	})
+	if err != nil {
+		return
+	}
+	h.reply(client, ServerFrame{Type: FramePresenceWatching, ID: frame.ID, Data: data})
+}
+
+// RunPresenceEvents forwards the status changes the presence service publishes on
+// eventbus.PresenceEvents to the local members of the user's presence channel, as
+// message frames. Typing events are not forwarded. Like RunWorkflowEvents, every
+// instance subscribes on its own, so events are neither relayed nor numbered; it
+// blocks until ctx is cancelled.
+func (h *Hub) RunPresenceEvents(ctx context.Context) {
+	eventbus.Subscribe(ctx, h.events, eventbus.PresenceEvents, func(ctx context.Context, msg eventbus.Message[eventbus.PresenceEvent]) error {
+		if !msg.Data.StatusChange() {
+			return nil
+		}
+		if channel := PresenceChannelPrefix + msg.Data.UserID; ValidChannel(channel) {
+			presenceEventsTotal.Inc()
+			h.deliver(channel, 0, msg.Payload)
+		}
+		return nil
+	})
+}
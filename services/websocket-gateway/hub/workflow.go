@@ -0,0 +1,152 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"chorus/pkg/eventbus"
+	"chorus/pkg/instanceaccess"
+	"chorus/pkg/tracing"
+)
+
+const (
+	// Channels carrying the events of one workflow instance, followed by its ID
+	WorkflowChannelPrefix = "workflow:instance:"
+
+	// Time allowed to look up an instance in the workflow engine
+	workflowTimeout = 5 * time.Second
+)
+
+var ErrWorkflowUnavailable = errors.New("workflow engine could not be reached, try again")
+
+// Workflows configures the channels forwarding workflow engine events
+type Workflows struct {
+	EngineURL     string        // of the workflow engine, "" allowing only OperatorRoles to join, and no snapshots or triggers
+	APIKey        string        // sent to the engine as X-API-Key, with its delegate role to trigger workflows
+	OperatorRoles []string      // role claims allowed to follow any instance without an engine, which decides otherwise
+	AccessTTL     time.Duration // how long the engine's answer whether a token may follow an instance is reused
+	TriggerRate   float64       // workflow.trigger frames per second per user, 0 for no limit
+	TriggerBurst  float64
+}
+
+// SetWorkflows sets how workflow channels are authorized and snapshotted
+func (h *Hub) SetWorkflows(workflows Workflows) {
+	workflows.EngineURL = strings.TrimSuffix(workflows.EngineURL, "/")
+	h.workflows = workflows
+	h.workflowAccess = nil
+	if workflows.EngineURL != "" {
+		h.workflowAccess = instanceaccess.NewClient(instanceaccess.Config{
+			EngineURL:  workflows.EngineURL,
+			CacheTTL:   workflows.AccessTTL,
+			HTTPClient: tracing.Client,
+		})
+	}
+}
+
+// WorkflowChannel reports whether channel carries workflow engine events, which only
+// the engine publishes to
+func WorkflowChannel(channel string) bool {
+	return strings.HasPrefix(channel, WorkflowChannelPrefix)
+}
+
+// authorizeWorkflow lets the client follow a workflow instance if the engine's
+// can-view allows it to the client's token, returning the instance's status as a
+// snapshot when asked for one. Without an engine only operator roles may follow
+// instances. It waits on the engine, so it runs off the hub goroutine.
+func (h *Hub) authorizeWorkflow(ctx context.Context, client *Client, channel string, snapshot bool) (json.RawMessage, error) {
+	instanceID := strings.TrimPrefix(channel, WorkflowChannelPrefix)
+	if h.workflowAccess == nil {
+		role, _ := client.tokenClaims()["role"].(string)
+		if !slices.Contains(h.workflows.OperatorRoles, role) {
+			return nil, ErrChannelForbidden
+		}
+		return nil, nil
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, workflowTimeout)
+	decision, err := h.workflowAccess.CanView(lookupCtx, instanceID, client.bearerToken())
+	cancel()
+	if err != nil {
+		client.logger.Error("Failed to ask whether a workflow instance may be followed", "instance_id", instanceID, "error", err)
+		return nil, ErrWorkflowUnavailable
+	}
+	if !decision.Allowed {
+		client.logger.Debug("Workflow channel refused", "instance_id", instanceID, "reason", decision.Reason)
+		return nil, ErrChannelForbidden
+	}
+	if !snapshot {
+		return nil, nil
+	}
+
+	status, err := h.workflowStatus(ctx, instanceID, client.bearerToken())
+	if err != nil {
+		if errors.Is(err, ErrChannelForbidden) {
+			return nil, err
+		}
+		// The snapshot is optional
+		client.logger.Error("Failed to look up workflow instance", "instance_id", instanceID, "error", err)
+		return nil, nil
+	}
+	return status, nil
+}
+
+// workflowStatus fetches an instance's status from the engine with the client's
+// token, which the engine only shows it to when can-view allows it. An instance that
+// does not exist is forbidden, like one of someone else.
+func (h *Hub) workflowStatus(ctx context.Context, instanceID, token string) (json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, workflowTimeout)
+	defer cancel()
+
+	endpoint := h.workflows.EngineURL + "/api/v1/instances/" + url.PathEscape(instanceID) + "/status"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := tracing.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusBadRequest:
+		return nil, ErrChannelForbidden
+	default:
+		return nil, fmt.Errorf("workflow engine answered %s", resp.Status)
+	}
+
+	var status json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to decode instance status: %w", err)
+	}
+	return status, nil
+}
+
+// RunWorkflowEvents forwards the events the workflow engine publishes on
+// eventbus.WorkflowEvents to the local members of their instance's channel, as
+// message frames. Every instance subscribes on its own, so events are neither relayed
+// nor numbered. It blocks until ctx is cancelled, resubscribing after connection
+// loss; events published while unsubscribed are lost.
+func (h *Hub) RunWorkflowEvents(ctx context.Context) {
+	eventbus.Subscribe(ctx, h.events, eventbus.WorkflowEvents, func(ctx context.Context, msg eventbus.Message[eventbus.WorkflowEvent]) error {
+		// Engine-wide events, such as queue alerts, have no instance
+		instanceID := msg.Data.InstanceID()
+		if instanceID == "" {
+			return nil
+		}
+		if channel := WorkflowChannelPrefix + instanceID; ValidChannel(channel) {
+			workflowEventsTotal.Inc()
+			h.deliver(channel, 0, msg.Payload)
+		}
+		return nil
+	})
+}
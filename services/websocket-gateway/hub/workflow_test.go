@@ -0,0 +1,173 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"chorus/pkg/instanceaccess"
+)
+
+// fakeCanView is a workflow engine answering can-view for the tokens it allows,
+// denying the others, and showing them the status of instances
+type fakeCanView struct {
+	server *httptest.Server
+
+	mu      sync.Mutex
+	allowed map[string]bool
+	down    bool // answers 503
+}
+
+func newFakeCanView(t *testing.T) *fakeCanView {
+	t.Helper()
+	f := &fakeCanView{allowed: make(map[string]bool)}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		instanceID := strings.TrimPrefix(r.URL.Path, "/api/v1/instances/")
+		instanceID, status := strings.CutSuffix(instanceID, "/status")
+		instanceID = strings.TrimSuffix(instanceID, "/can-view")
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		f.mu.Lock()
+		down, allowed := f.down, f.allowed[token]
+		f.mu.Unlock()
+		if down {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if status {
+			if !allowed {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"id": instanceID, "status": "running"})
+			return
+		}
+		decision := instanceaccess.Decision{InstanceID: instanceID, Allowed: allowed, Reason: "denied"}
+		if allowed {
+			decision.Reason = "creator"
+		}
+		json.NewEncoder(w).Encode(decision)
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeCanView) allow(token string, allowed bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.allowed[token] = allowed
+}
+
+func (f *fakeCanView) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+// joinWorkflow asks to join channel and returns the hub's answer
+func (c *testConn) joinWorkflow(channel string) ServerFrame {
+	c.t.Helper()
+	c.send(`{"v":1,"type":"join","id":"join","payload":{"channel":"` + channel + `"}}`)
+	return c.next()
+}
+
+// leave leaves channel and waits for the hub to confirm it
+func (c *testConn) leave(channel string) {
+	c.t.Helper()
+	c.send(`{"v":1,"type":"leave","id":"leave","payload":{"channel":"` + channel + `"}}`)
+	c.expect(FrameLeft)
+}
+
+// Joins of an instance's channel follow the engine's can-view, a revoked access
+// being refused once the cached decision expires
+func TestWorkflowChannelFollowsEngineDecision(t *testing.T) {
+	const ttl = 100 * time.Millisecond
+	engine := newFakeCanView(t)
+	engine.allow("alice-token", true)
+	gateway := newTestGateway(t, newTestRedis(t).Addr(), "gw-1", func(h *Hub) {
+		h.SetWorkflows(Workflows{EngineURL: engine.server.URL, AccessTTL: ttl})
+	})
+	channel := WorkflowChannelPrefix + "instance-1"
+
+	alice := gateway.dialQuery(t, url.Values{"user": {"alice"}, "token": {"alice-token"}})
+	if frame := alice.joinWorkflow(channel); frame.Type != FrameJoined {
+		t.Fatalf("alice got %+v, want joined", frame)
+	}
+	bob := gateway.dialQuery(t, url.Values{"user": {"bob"}, "token": {"bob-token"}})
+	if frame := bob.joinWorkflow(channel); frame.Type != FrameError || frame.Code != CodeForbidden {
+		t.Fatalf("bob got %+v, want a forbidden error", frame)
+	}
+
+	// The engine's answer is reused within the TTL
+	engine.allow("alice-token", false)
+	alice.leave(channel)
+	if frame := alice.joinWorkflow(channel); frame.Type != FrameJoined {
+		t.Fatalf("alice got %+v within the TTL, want the cached joined", frame)
+	}
+
+	time.Sleep(ttl)
+	alice.leave(channel)
+	if frame := alice.joinWorkflow(channel); frame.Type != FrameError || frame.Code != CodeForbidden {
+		t.Fatalf("alice got %+v after the access was revoked, want a forbidden error", frame)
+	}
+}
+
+// An engine that cannot answer is reported as unavailable rather than forbidden,
+// and is asked again on the next join
+func TestWorkflowChannelEngineUnavailable(t *testing.T) {
+	engine := newFakeCanView(t)
+	engine.allow("alice-token", true)
+	engine.setDown(true)
+	gateway := newTestGateway(t, newTestRedis(t).Addr(), "gw-1", func(h *Hub) {
+		h.SetWorkflows(Workflows{EngineURL: engine.server.URL, AccessTTL: time.Minute})
+	})
+	channel := WorkflowChannelPrefix + "instance-1"
+
+	alice := gateway.dialQuery(t, url.Values{"user": {"alice"}, "token": {"alice-token"}})
+	if frame := alice.joinWorkflow(channel); frame.Type != FrameError || frame.Code != CodeUnavailable {
+		t.Fatalf("alice got %+v, want an unavailable error", frame)
+	}
+	engine.setDown(false)
+	if frame := alice.joinWorkflow(channel); frame.Type != FrameJoined {
+		t.Fatalf("alice got %+v once the engine is back, want joined", frame)
+	}
+}
+
+// The snapshot of a join is read with the user's token, which the engine shows the
+// status to
+func TestWorkflowChannelSnapshotUsesToken(t *testing.T) {
+	engine := newFakeCanView(t)
+	engine.allow("alice-token", true)
+	gateway := newTestGateway(t, newTestRedis(t).Addr(), "gw-1", func(h *Hub) {
+		h.SetWorkflows(Workflows{EngineURL: engine.server.URL, APIKey: "gateway-key"})
+	})
+	channel := WorkflowChannelPrefix + "instance-1"
+
+	alice := gateway.dialQuery(t, url.Values{"user": {"alice"}, "token": {"alice-token"}})
+	alice.send(`{"v":1,"type":"join","id":"join","payload":{"channel":"` + channel + `","snapshot":true}}`)
+	alice.expect(FrameJoined)
+	frame := alice.expect(FrameSnapshot)
+	var status map[string]string
+	if err := json.Unmarshal(frame.Data, &status); err != nil || status["id"] != "instance-1" || status["status"] != "running" {
+		t.Errorf("snapshot %s, want the status of instance-1", frame.Data)
+	}
+}
+
+// Without an engine only the operator roles may follow instances
+func TestWorkflowChannelWithoutEngine(t *testing.T) {
+	gateway := newTestGateway(t, newTestRedis(t).Addr(), "gw-1", func(h *Hub) {
+		h.SetWorkflows(Workflows{OperatorRoles: []string{"admin"}})
+	})
+	channel := WorkflowChannelPrefix + "instance-1"
+
+	alice := gateway.dialQuery(t, url.Values{"user": {"alice"}, "token": {"alice-token"}})
+	if frame := alice.joinWorkflow(channel); frame.Type != FrameError || frame.Code != CodeForbidden {
+		t.Fatalf("alice got %+v, want a forbidden error", frame)
+	}
+}
@@ -1,66 +1,125 @@
 package main
 
 import (
-	"context"
-	"log"
+	"crypto/tls"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
+	"chorus/pkg/env"
+	"chorus/pkg/httpserver"
+	"chorus/pkg/logging"
+	"chorus/pkg/tracing"
+	"chorus/websocket-gateway/app"
 	"chorus/websocket-gateway/config"
 	"chorus/websocket-gateway/handlers"
-	"chorus/websocket-gateway/middleware"
+	"chorus/websocket-gateway/tlsconfig"
 )
 
 func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
 	
-	// Setup logger
-	logger := log.New(os.Stdout, "[WebSocket-Gateway] ", log.LstdFlags|log.Lshortfile)
+	// Setup logger, at the level named by LOG_LEVEL
+	logger := logging.NewLogger().With("service", "websocket-gateway", "instance_id", cfg.InstanceID)
 	
-	// Create HTTP mux
-	mux := http.NewServeMux()
+	if err := cfg.Validate(); err != nil {
+		logger.Fatal("Invalid configuration", "error", err)
+	}
+	shutdownTracing, err := tracing.Setup("websocket-gateway")
+	if err != nil {
+		logger.Fatal("Failed to set up tracing", "error", err)
+	}
+	logger.Info("Configuration loaded", "settings", env.Dump())
+	if err := cfg.TLS.Validate(cfg.Port); err != nil {
+		logger.Fatal("Invalid TLS configuration", "error", err)
+	}
 	
-	// Health check endpoint
-	mux.HandleFunc("/health", handlers.HealthCheck)
+	// Serve HTTPS and wss directly when a certificate is configured
+	var certificate *tlsconfig.Certificate
+	var tlsConfig *tls.Config
+	if cfg.TLS.Enabled() {
+		var err error
+		certificate, err = tlsconfig.Load(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			logger.Fatal("Invalid TLS certificate", "error", err)
+		}
+		tlsConfig, err = tlsconfig.Server(certificate, cfg.TLS.ClientCAFile)
+		if err != nil {
+			logger.Fatal("Invalid TLS client CA", "error", err)
+		}
+	}
+	
+	application, err := app.New(cfg, logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize", "error", err)
+	}
+	defer application.Close()
 	
-	// WebSocket endpoint with JWT authentication
-	mux.Handle("/ws", middleware.JWTAuth(cfg.JWTSecret, http.HandlerFunc(handlers.WebSocketHandler)))
+	// Background work runs until shutdown, before Redis is closed
+	application.Start()
 	
 	// Create HTTP server
-	srv := &http.Server{
-		Addr:         ":" + cfg.Port,
-		Handler:      middleware.Logging(logger, mux),
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	srv := httpserver.New(httpserver.Options{Addr: ":" + cfg.Port}, logger, application.Handler)
+	if tlsConfig != nil {
+		srv.TLSConfig = tlsConfig
+		// WebSocket upgrades hijack the connection, which HTTP/2 does not allow
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		httpserver.Serve(srv, logger, "Starting WebSocket Gateway", "port", cfg.Port, "tls", true, "certificate_expires_at", certificate.NotAfter())
+	} else {
+		httpserver.Serve(srv, logger, "Starting WebSocket Gateway", "port", cfg.Port)
 	}
 	
-	// Start server in goroutine
-	go func() {
-		logger.Printf("Starting WebSocket Gateway on port %s", cfg.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatalf("Failed to start server: %v", err)
-		}
-	}()
+	// Send plain HTTP clients to HTTPS
+	var redirectSrv *http.Server
+	if cfg.TLS.RedirectPort != "" {
+		redirectSrv = httpserver.New(httpserver.Options{Addr: ":" + cfg.TLS.RedirectPort}, logger, handlers.RedirectToHTTPS(cfg.Port))
+		httpserver.Serve(redirectSrv, logger, "Redirecting HTTP to HTTPS", "port", cfg.TLS.RedirectPort)
+	}
+	
+	// Reload the certificate on SIGHUP, for renewals; open connections keep the
+	// one they were made with
+	if certificate != nil {
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		go func() {
+			for range reload {
+				if err := certificate.Reload(); err != nil {
+					logger.Error("Failed to reload TLS certificate, keeping the current one", "error", err)
+					continue
+				}
+				logger.Info("Reloaded TLS certificate", "certificate_expires_at", certificate.NotAfter())
+			}
+		}()
+	}
 	
 	// Wait for interrupt signal to gracefully shutdown the server
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	httpserver.WaitForSignal()
 	
-	logger.Println("Shutting down server...")
+	logger.Info("Shutting down server...")
 	
 	// Graceful shutdown with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := httpserver.ShutdownContext()
 	defer cancel()
 	
+	// Close WebSocket connections first; the server does not track hijacked connections
+	if err := application.Shutdown(ctx); err != nil {
+		logger.Warn("WebSocket connections still open at the shutdown deadline", "error", err)
+	}
+	
+	if redirectSrv != nil {
+		redirectSrv.Shutdown(ctx)
+	}
 	if err := srv.Shutdown(ctx); err != nil {
-		logger.Fatalf("Server forced to shutdown: %v", err)
+		logger.Fatal("Server forced to shutdown", "error", err)
+	}
+	
+	application.Stop()
+	
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("Failed to flush spans", "error", err)
 	}
 	
-	logger.Println("Server exited")
-}
\ No newline at end of file
+	logger.Info("Server exited")
+}
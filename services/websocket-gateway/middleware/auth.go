@@ -2,56 +2,141 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
-	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
+	"chorus/pkg/apierror"
+	"chorus/pkg/auth"
+	"chorus/pkg/logging"
 )
 
-func JWTAuth(secret string, next http.Handler) http.Handler {
+func JWTAuth(validator *auth.Validator, logger *logging.Logger, next http.Handler) http.Handler {
+	authenticated := auth.Middleware(validator, logger, withUser(next))
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Extract token from Authorization header or query parameter (for WebSocket)
-		tokenString := extractToken(r)
-		if tokenString == "" {
-			http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+		// WebSocket clients may pass the token as a query parameter instead
+		if auth.BearerToken(r) == "" {
+			if token := r.URL.Query().Get("token"); token != "" {
+				r = r.Clone(r.Context())
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		authenticated.ServeHTTP(w, r)
+	})
+}
+
+// withUser adds the user ID and claims, for the channel rules, of the token
+// validated by auth.Middleware to the context
+func withUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := auth.FromContext(r.Context())
+		ctx := context.WithValue(r.Context(), "userID", claims.UserID)
+		ctx = context.WithValue(ctx, "claims", claims.Raw)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ServiceAuth admits backend services only: callers presenting one of apiKeys as
+// X-API-Key, or a bearer token whose role claim is serviceRole
+func ServiceAuth(validator *auth.Validator, serviceRole string, apiKeys []string, logger *logging.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if presented := r.Header.Get("X-API-Key"); presented != "" {
+			if !auth.ValidAPIKey(presented, apiKeys) {
+				logger.WarnContext(r.Context(), "Service authentication failed", "reason", "invalid_api_key", "path", r.URL.Path, "remote", r.RemoteAddr)
+				apierror.Write(w, r, http.StatusUnauthorized, "Invalid API key", nil)
+				return
+			}
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Parse and validate token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate the alg is what we expect
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
+		claims, err := validator.Authenticate(r)
+		if err != nil {
+			if !errors.Is(err, auth.ErrMissingToken) {
+				logger.WarnContext(r.Context(), "Service authentication failed", "reason", auth.Reason(err), "path", r.URL.Path, "remote", r.RemoteAddr)
 			}
-			return []byte(secret), nil
-		})
+			apierror.Write(w, r, http.StatusUnauthorized, auth.Message(err), nil)
+			return
+		}
+		if !claims.HasRole(serviceRole) {
+			apierror.Write(w, r, http.StatusForbidden, "This request requires a service token or API key", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+// ServiceOrUserAuth admits backend services as ServiceAuth does, and users with a
+// valid bearer token, whose claims it adds to the context for the handler to check.
+// Requests carrying a user's token through a service are treated as the user's.
+func ServiceOrUserAuth(validator *auth.Validator, serviceRole string, apiKeys []string, logger *logging.Logger, next http.Handler) http.Handler {
+	services := ServiceAuth(validator, serviceRole, apiKeys, logger, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "" || auth.BearerToken(r) == "" {
+			services.ServeHTTP(w, r)
 			return
 		}
 
-		// Extract claims
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			// Add user ID to context
-			if userID, ok := claims["user_id"].(string); ok {
-				ctx := context.WithValue(r.Context(), "userID", userID)
-				next.ServeHTTP(w, r.WithContext(ctx))
+		claims, err := validator.Authenticate(r)
+		if err != nil {
+			logger.WarnContext(r.Context(), "Authentication failed", "reason", auth.Reason(err), "path", r.URL.Path, "remote", r.RemoteAddr)
+			apierror.Write(w, r, http.StatusUnauthorized, auth.Message(err), nil)
+			return
+		}
+		if claims.HasRole(serviceRole) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		withUser(next).ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), claims)))
+	})
+}
+
+// AdminAuth admits operators only: callers presenting one of apiKeys as X-API-Key,
+// or a bearer token whose role claim is one of adminRoles. It adds who they are to
+// the context as "actor", for the handler to log: the token's user, or "api_key"
+// followed by the X-Admin-Actor header a support tool may name its user in.
+func AdminAuth(validator *auth.Validator, adminRoles []string, apiKeys []string, logger *logging.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if presented := r.Header.Get("X-API-Key"); presented != "" {
+			if !auth.ValidAPIKey(presented, apiKeys) {
+				logger.WarnContext(r.Context(), "Admin authentication failed", "reason", "invalid_api_key", "path", r.URL.Path, "remote", r.RemoteAddr)
+				apierror.Write(w, r, http.StatusUnauthorized, "Invalid API key", nil)
 				return
 			}
+			actor := "api_key"
+			if name := r.Header.Get("X-Admin-Actor"); name != "" {
+				actor += ":" + name
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "actor", actor)))
+			return
 		}
 
-		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		claims, err := validator.Authenticate(r)
+		if err != nil {
+			if !errors.Is(err, auth.ErrMissingToken) {
+				logger.WarnContext(r.Context(), "Admin authentication failed", "reason", auth.Reason(err), "path", r.URL.Path, "remote", r.RemoteAddr)
+			}
+			apierror.Write(w, r, http.StatusUnauthorized, auth.Message(err), nil)
+			return
+		}
+		if !claims.HasRole(adminRoles...) {
+			logger.WarnContext(r.Context(), "Admin authorization failed", "user_id", claims.UserID, "roles", claims.Roles, "path", r.URL.Path)
+			apierror.Write(w, r, http.StatusForbidden, "This request requires an admin token or API key", nil)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "actor", "user:"+claims.UserID)))
 	})
 }
 
-func extractToken(r *http.Request) string {
-	// Try Authorization header first
-	bearerToken := r.Header.Get("Authorization")
-	if strings.HasPrefix(bearerToken, "Bearer ") {
-		return strings.TrimPrefix(bearerToken, "Bearer ")
-	}
-
-	// For WebSocket connections, check query parameter
-	return r.URL.Query().Get("token")
-}
\ No newline at end of file
+// ClientCertAuth admits callers that presented a client certificate the server
+// verified against its client CAs, as the admin API does over mutual TLS
+func ClientCertAuth(logger *logging.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			logger.WarnContext(r.Context(), "Client certificate authentication failed", "path", r.URL.Path, "remote", r.RemoteAddr)
+			apierror.Write(w, r, http.StatusUnauthorized, "This request requires a client certificate", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,79 @@
+// Package tlsconfig serves the gateway over TLS with a certificate that can be
+// reloaded while running
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Certificate is the server certificate, read again from its files by Reload.
+// Handshakes after a reload use the new one; connections already open keep theirs.
+type Certificate struct {
+	certFile string
+	keyFile  string
+	current  atomic.Pointer[tls.Certificate]
+}
+
+// Load reads the PEM certificate chain and private key
+func Load(certFile, keyFile string) (*Certificate, error) {
+	c := &Certificate{certFile: certFile, keyFile: keyFile}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads the certificate files again, keeping the current certificate if they
+// cannot be loaded
+func (c *Certificate) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s and key %s: %w", c.certFile, c.keyFile, err)
+	}
+	c.current.Store(&cert)
+	return nil
+}
+
+// NotAfter returns when the current certificate expires
+func (c *Certificate) NotAfter() time.Time {
+	cert := c.current.Load()
+	if cert.Leaf == nil {
+		return time.Time{}
+	}
+	return cert.Leaf.NotAfter
+}
+
+func (c *Certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.current.Load(), nil
+}
+
+// Server returns the TLS settings of the server presenting cert. With clientCAFile,
+// clients may present a certificate issued by one of its PEM CAs, which is verified
+// for the handlers requiring one; without, none is asked for.
+func Server(cert *Certificate, clientCAFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cert.get,
+	}
+	if clientCAFile == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("client CA file contains no certificates")
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	return config, nil
+}
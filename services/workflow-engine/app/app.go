@@ -0,0 +1,265 @@
+// Package app assembles the workflow engine: its database, engine and HTTP routes.
+// main runs it as a process, and the integration tests run it in theirs.
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/auth"
+	"chorus/pkg/cors"
+	"chorus/pkg/logging"
+	"chorus/pkg/metrics"
+	"chorus/pkg/tracing"
+	"chorus/workflow-engine/config"
+	"chorus/workflow-engine/db"
+	"chorus/workflow-engine/encryption"
+	"chorus/workflow-engine/handlers"
+	"chorus/workflow-engine/middleware"
+	"chorus/workflow-engine/models"
+	"chorus/workflow-engine/services"
+	"chorus/workflow-engine/utils"
+)
+
+// App is a configured workflow engine, not yet started
+type App struct {
+	// Handler serves the engine's HTTP API
+	Handler http.Handler
+
+	engine *services.Engine
+}
+
+// New validates cfg, connects to the database, retrying while it comes up, and
+// builds the engine and its routes
+func New(cfg *config.Config, logger *logging.Logger) (*App, error) {
+	if err := cfg.CORS.Validate(cfg.Environment == "production"); err != nil {
+		return nil, fmt.Errorf("invalid CORS configuration: %w", err)
+	}
+	if err := cfg.JWT.Validate(cfg.Environment == "production"); err != nil {
+		return nil, fmt.Errorf("invalid JWT configuration: %w", err)
+	}
+	if err := cfg.Outbound.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid outbound HTTP configuration: %w", err)
+	}
+	keys, err := cfg.Encryption.KeyProvider()
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption configuration: %w", err)
+	}
+	if keys != nil {
+		models.SetCipher(encryption.NewCipher(keys))
+		logger.Info("Encryption at rest enabled", "active_key", keys.ActiveKeyID())
+	}
+
+	// Connect to database, retrying while it comes up
+	var database *gorm.DB
+	err = utils.Retry(context.Background(), cfg.StartupMaxAttempts, func() error {
+		var err error
+		database, err = db.Connect(cfg)
+		return err
+	}, func(attempt int, err error, wait time.Duration) {
+		logger.Warn("Database not ready, retrying", "attempt", attempt, "retry_in", wait.String(), "error", err)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("connect to database: %w", err)
+	}
+
+	// Initialize services
+	engine := services.NewEngine(database, cfg, logger)
+
+	// Initialize handlers
+	templateHandler := handlers.NewTemplateHandler(database, engine, cfg.Pagination, cfg.TemplateCategoriesFreeText, logger)
+	categoryHandler := handlers.NewCategoryHandler(database, logger)
+	templateWebhookHandler := handlers.NewTemplateWebhookHandler(database, logger)
+	instanceService := services.NewInstanceService(database, engine, logger)
+	instanceHandler := handlers.NewInstanceHandler(database, engine, instanceService, cfg.InstanceViewerRoles, cfg.Pagination, logger)
+	triggerHandler := handlers.NewTriggerHandler(database, engine, logger)
+	engineHandler := handlers.NewEngineHandler(engine, logger)
+	snippetHandler := handlers.NewSnippetHandler(database, logger)
+	commentHandler := handlers.NewCommentHandler(database, logger)
+	taskHandler := handlers.NewTaskHandler(database, engine, cfg.InstanceViewerRoles, logger)
+	apiKeyHandler := handlers.NewAPIKeyHandler(database, cfg.APIKeyDefaultRole, logger)
+	encryptionHandler := handlers.NewEncryptionHandler(engine, logger)
+
+	// Initialize rate limiter
+	rateLimiter := middleware.NewRateLimiter(engine.Redis(), cfg.RateLimits, logger)
+
+	// Setup Gin router
+	if cfg.Environment == "production" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	router := gin.New()
+	// Handlers' contexts carry the request's values, such as the request ID errors report
+	router.ContextWithFallback = true
+	router.Use(gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		apierror.Abort(c, http.StatusInternalServerError, "Internal server error", nil)
+	}))
+	router.Use(middleware.Logger(logger))
+	router.Use(middleware.CORS(cors.New(cfg.CORS)))
+	router.Use(middleware.Compression(cfg.CompressionMinSize))
+
+	// Health check endpoint
+	router.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "healthy",
+			"service": "workflow-engine",
+			"version": "1.0.0",
+		})
+	})
+
+	// Readiness endpoint; fails until the engine has started and while the DB or Redis is down
+	router.GET("/ready", func(c *gin.Context) {
+		if err := engine.Readiness(); err != nil {
+			apierror.Abort(c, http.StatusServiceUnavailable, err.Error(), gin.H{
+				"status": "not_ready",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"status": "ready",
+		})
+	})
+
+	// Metrics endpoint
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	// Unknown routes answer in the error format of the others
+	router.NoRoute(func(c *gin.Context) {
+		apierror.Abort(c, http.StatusNotFound, "Not found", nil)
+	})
+
+	// API routes
+	v1 := router.Group("/api/v1")
+	v1.Use(middleware.Auth(auth.NewValidator(cfg.JWT), middleware.NewAPIKeyAuthenticator(database, cfg.APIKeyDelegateRole, logger), logger))
+	v1.Use(rateLimiter.Default())
+	// Creating instances, directly or through a webhook, takes its tokens from the
+	// stricter instance_create bucket instead of the write one
+	for _, path := range []string{"/instances", "/instances/:id/rerun", "/triggers/webhook/:template_id", "/triggers/webhook/by-slug/:slug"} {
+		rateLimiter.Override(http.MethodPost, v1.BasePath()+path, "instance_create", cfg.RateLimits.InstanceCreate)
+	}
+	{
+		// Template routes
+		templates := v1.Group("/templates")
+		{
+			templates.GET("", templateHandler.ListTemplates)
+			templates.POST("", templateHandler.CreateTemplate)
+			templates.GET("/:id", templateHandler.GetTemplate)
+			templates.PUT("/:id", templateHandler.UpdateTemplate)
+			templates.DELETE("/:id", templateHandler.DeleteTemplate)
+			templates.GET("/:id/stats", templateHandler.GetTemplateStats)
+			templates.GET("/:id/export", templateHandler.ExportTemplate)
+			templates.GET("/:id/launch-form", templateHandler.GetLaunchForm)
+			templates.GET("/:id/webhooks", templateWebhookHandler.ListWebhooks)
+			templates.POST("/:id/webhooks", templateWebhookHandler.CreateWebhook)
+			templates.PUT("/:id/webhooks/:webhook_id", templateWebhookHandler.UpdateWebhook)
+			templates.DELETE("/:id/webhooks/:webhook_id", templateWebhookHandler.DeleteWebhook)
+			templates.GET("/:id/webhooks/:webhook_id/deliveries", templateWebhookHandler.ListDeliveries)
+		}
+
+		// Template category routes; managing them is for admin users
+		categories := v1.Group("/categories")
+		{
+			categories.GET("", categoryHandler.ListCategories)
+			categories.POST("", middleware.RequireAuthType(middleware.AuthTypeUser), middleware.RequireRole("admin"), categoryHandler.CreateCategory)
+			categories.POST("/:slug/rename", middleware.RequireAuthType(middleware.AuthTypeUser), middleware.RequireRole("admin"), categoryHandler.RenameCategory)
+			categories.DELETE("/:slug", middleware.RequireAuthType(middleware.AuthTypeUser), middleware.RequireRole("admin"), categoryHandler.DeleteCategory)
+		}
+
+		// Instance routes
+		instances := v1.Group("/instances")
+		{
+			instances.GET("", instanceHandler.ListInstances)
+			instances.POST("", instanceHandler.CreateInstance)
+			instances.GET("/summary", instanceHandler.GetInstanceSummary)
+			instances.GET("/:id", instanceHandler.GetInstance)
+			instances.GET("/:id/status", instanceHandler.GetInstanceStatus)
+			instances.GET("/:id/can-view", instanceHandler.CanViewInstance)
+			instances.POST("/:id/rerun", instanceHandler.RerunInstance)
+			instances.PUT("/:id/start", instanceHandler.StartInstance)
+			instances.PUT("/:id/pause", instanceHandler.PauseInstance)
+			instances.PUT("/:id/resume", instanceHandler.ResumeInstance)
+			instances.PUT("/:id/cancel", instanceHandler.CancelInstance)
+			instances.PUT("/:id/retry", instanceHandler.RetryInstance)
+			instances.GET("/:id/steps", instanceHandler.GetInstanceSteps)
+			instances.GET("/:id/steps/:step_id/output", instanceHandler.GetStepOutput)
+			instances.GET("/:id/variables", instanceHandler.GetInstanceVariables)
+			instances.GET("/:id/report", instanceHandler.GetInstanceReport)
+			instances.GET("/:id/comments", commentHandler.ListComments)
+			instances.POST("/:id/comments", middleware.RequireAuthType(middleware.AuthTypeUser), commentHandler.CreateComment)
+			instances.DELETE("/:id/comments/:comment_id", middleware.RequireAuthType(middleware.AuthTypeUser), commentHandler.DeleteComment)
+		}
+
+		// Task inbox routes
+		tasks := v1.Group("/tasks")
+		{
+			tasks.GET("", taskHandler.ListTasks)
+			tasks.GET("/:id", taskHandler.GetTask)
+			tasks.POST("/:id/approve", middleware.RequireAuthType(middleware.AuthTypeUser), taskHandler.ApproveTask)
+			tasks.POST("/:id/reject", middleware.RequireAuthType(middleware.AuthTypeUser), taskHandler.RejectTask)
+			tasks.POST("/:id/complete", middleware.RequireAuthType(middleware.AuthTypeUser), taskHandler.CompleteTask)
+			tasks.PUT("/:id/assignee", middleware.RequireAuthType(middleware.AuthTypeUser), taskHandler.ReassignTask)
+		}
+
+		// Snippet routes
+		snippets := v1.Group("/snippets")
+		{
+			snippets.GET("", snippetHandler.ListSnippets)
+			snippets.POST("", snippetHandler.CreateSnippet)
+			snippets.GET("/:id", snippetHandler.GetSnippet)
+			snippets.PUT("/:id", snippetHandler.UpdateSnippet)
+			snippets.DELETE("/:id", snippetHandler.DeleteSnippet)
+		}
+
+		// Trigger routes
+		triggers := v1.Group("/triggers")
+		{
+			triggers.POST("/webhook/:template_id", instanceHandler.TriggerWebhook)
+			triggers.POST("/webhook/by-slug/:slug", instanceHandler.TriggerWebhookBySlug)
+			triggers.PUT("/:id", triggerHandler.UpdateTrigger)
+			triggers.GET("/:id/evaluations", triggerHandler.GetTriggerEvaluations)
+		}
+
+		// Engine routes
+		v1.GET("/engine/backlog", engineHandler.GetBacklog)
+		v1.POST("/engine/events/replay", middleware.RequireAuthType(middleware.AuthTypeUser), middleware.RequireRole("admin"), engineHandler.ReplayEvents)
+		v1.GET("/engine/events/replays/:id", middleware.RequireAuthType(middleware.AuthTypeUser), middleware.RequireRole("admin"), engineHandler.GetEventReplay)
+
+		// API key management (admin users only)
+		apiKeys := v1.Group("/api-keys")
+		apiKeys.Use(middleware.RequireAuthType(middleware.AuthTypeUser), middleware.RequireRole("admin"))
+		{
+			apiKeys.GET("", apiKeyHandler.ListAPIKeys)
+			apiKeys.POST("", apiKeyHandler.CreateAPIKey)
+			apiKeys.DELETE("/:id", apiKeyHandler.RevokeAPIKey)
+		}
+
+		// Key rotation (admin users only)
+		admin := v1.Group("/admin")
+		admin.Use(middleware.RequireAuthType(middleware.AuthTypeUser), middleware.RequireRole("admin"))
+		{
+			admin.GET("/encryption/reencrypt", encryptionHandler.GetReencryption)
+			admin.POST("/encryption/reencrypt", encryptionHandler.StartReencryption)
+		}
+	}
+
+	return &App{
+		Handler: logging.RequestIDMiddleware(tracing.Middleware(router)),
+		engine:  engine,
+	}, nil
+}
+
+// Start starts the engine once its dependencies respond; it blocks until they do
+func (a *App) Start() error {
+	return a.engine.Start()
+}
+
+// Stop stops the engine and waits for the instances it is running
+func (a *App) Stop() {
+	a.engine.Stop()
+}
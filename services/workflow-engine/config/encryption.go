@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"chorus/pkg/env"
+	"chorus/workflow-engine/encryption"
+)
+
+// EncryptionConfig holds the master keys the data of templates with
+// encrypt_variables is encrypted under
+type EncryptionConfig struct {
+	MasterKeys string // comma-separated id:base64 pairs, "" disables encryption
+	ActiveKey  string // ID of the key new data is encrypted under; may be omitted with one key
+}
+
+func loadEncryptionConfig() EncryptionConfig {
+	return EncryptionConfig{
+		MasterKeys: env.Secret("ENCRYPTION_MASTER_KEYS", ""),
+		ActiveKey:  env.String("ENCRYPTION_ACTIVE_KEY", ""),
+	}
+}
+
+// KeyProvider returns the configured master keys, nil when encryption is disabled
+func (c EncryptionConfig) KeyProvider() (encryption.KeyProvider, error) {
+	if strings.TrimSpace(c.MasterKeys) == "" {
+		if c.ActiveKey != "" {
+			return nil, fmt.Errorf("ENCRYPTION_ACTIVE_KEY is set but ENCRYPTION_MASTER_KEYS is not")
+		}
+		return nil, nil
+	}
+	active := c.ActiveKey
+	if active == "" {
+		entries := strings.Split(strings.Trim(c.MasterKeys, ", "), ",")
+		if len(entries) > 1 {
+			return nil, fmt.Errorf("ENCRYPTION_ACTIVE_KEY must name one of several ENCRYPTION_MASTER_KEYS")
+		}
+		active, _, _ = strings.Cut(strings.TrimSpace(entries[0]), ":")
+	}
+	keys, err := encryption.ParseStaticKeys(c.MasterKeys, active)
+	if err != nil {
+		return nil, fmt.Errorf("ENCRYPTION_MASTER_KEYS: %w", err)
+	}
+	return keys, nil
+}
+
+// Validate rejects malformed master keys and an unknown active key
+func (c EncryptionConfig) Validate() error {
+	_, err := c.KeyProvider()
+	return err
+}
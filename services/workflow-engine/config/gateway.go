@@ -0,0 +1,32 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"chorus/pkg/env"
+)
+
+// GatewayConfig describes how user notifications reach the websocket gateway
+type GatewayConfig struct {
+	URL     string        // of the gateway, "" disables notifications
+	APIKey  string        // sent as X-API-Key, one of the gateway's service keys
+	Timeout time.Duration // of one request to the gateway
+}
+
+func loadGatewayConfig() GatewayConfig {
+	return GatewayConfig{
+		URL:     strings.TrimSuffix(env.URL("GATEWAY_URL", ""), "/"),
+		APIKey:  env.String("GATEWAY_API_KEY", ""),
+		Timeout: env.Duration("GATEWAY_TIMEOUT_SECONDS", 5*time.Second, time.Second),
+	}
+}
+
+// Validate rejects a timeout that is not positive
+func (c GatewayConfig) Validate() error {
+	if c.Timeout <= 0 {
+		return errors.New("GATEWAY_TIMEOUT_SECONDS must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"chorus/pkg/env"
+)
+
+// Built-in step hooks STEP_HOOKS may enable
+const (
+	StepHookAudit          = "audit"           // publishes the steps of audited actions to the audit stream
+	StepHookCircuitBreaker = "circuit_breaker" // fails http_request steps to a destination that keeps failing
+)
+
+// StepHooksConfig enables the built-in hooks that run around every step, and
+// configures them
+type StepHooksConfig struct {
+	// Built-in hooks in the order they run, before any registered in code
+	Enabled []string
+
+	// Actions whose steps the audit hook publishes
+	AuditActions []string
+	// Entries kept in the audit stream, approximately; 0 keeps them all
+	AuditStreamMaxLen int
+
+	// Consecutive failures of a destination that open its circuit
+	BreakerFailureThreshold int
+	// How long an open circuit fails steps before one is let through as a trial
+	BreakerCooldown time.Duration
+}
+
+func loadStepHooksConfig() StepHooksConfig {
+	enabled := env.StringSlice("STEP_HOOKS", nil)
+	for i, name := range enabled {
+		enabled[i] = strings.ToLower(name)
+	}
+	return StepHooksConfig{
+		Enabled:                 enabled,
+		AuditActions:            env.StringSlice("STEP_AUDIT_ACTIONS", []string{"http_request"}),
+		AuditStreamMaxLen:       env.Int("STEP_AUDIT_STREAM_MAXLEN", 100000),
+		BreakerFailureThreshold: env.Int("CIRCUIT_BREAKER_FAILURE_THRESHOLD", 5),
+		BreakerCooldown:         env.Duration("CIRCUIT_BREAKER_COOLDOWN_SECONDS", 30*time.Second, time.Second),
+	}
+}
+
+// Validate rejects unknown or repeated hooks, and breaker settings out of range
+func (c StepHooksConfig) Validate() error {
+	seen := make(map[string]bool, len(c.Enabled))
+	for _, name := range c.Enabled {
+		switch name {
+		case StepHookAudit, StepHookCircuitBreaker:
+		default:
+			return fmt.Errorf("STEP_HOOKS: unknown hook %q, expected audit or circuit_breaker", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("STEP_HOOKS: %q is listed twice", name)
+		}
+		seen[name] = true
+	}
+	if c.AuditStreamMaxLen < 0 {
+		return errors.New("STEP_AUDIT_STREAM_MAXLEN must not be negative")
+	}
+	if c.BreakerFailureThreshold < 1 {
+		return errors.New("CIRCUIT_BREAKER_FAILURE_THRESHOLD must be at least 1")
+	}
+	if c.BreakerCooldown <= 0 {
+		return errors.New("CIRCUIT_BREAKER_COOLDOWN_SECONDS must be positive")
+	}
+	return nil
+}
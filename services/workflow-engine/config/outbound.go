@@ -0,0 +1,96 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"chorus/pkg/env"
+)
+
+// OutboundHTTPConfig controls the shared transport used by http_request actions
+type OutboundHTTPConfig struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int // 0 means unlimited
+	IdleConnTimeout     time.Duration
+	ConnectTimeout      time.Duration
+	ReadTimeout         time.Duration // covers the whole request, including the response body
+	CABundlePath        string        // PEM file added to the system roots
+	ProxyURL            string        // falls back to HTTP_PROXY/HTTPS_PROXY when empty
+
+	// Named profiles referenced by steps as "destination"
+	Destinations map[string]HTTPDestination
+
+	destinationsErr error
+}
+
+// HTTPDestination groups the connection settings of one outbound API so templates
+// can reference it by name instead of repeating them in every step
+type HTTPDestination struct {
+	BaseURL               string            `json:"base_url"`
+	Headers               map[string]string `json:"headers,omitempty"`
+	CABundlePath          string            `json:"ca_bundle_path,omitempty"`
+	InsecureSkipVerify    bool              `json:"insecure_skip_verify,omitempty"`
+	ProxyURL              string            `json:"proxy_url,omitempty"`
+	ConnectTimeoutSeconds int               `json:"connect_timeout_seconds,omitempty"`
+	ReadTimeoutSeconds    int               `json:"read_timeout_seconds,omitempty"`
+}
+
+func loadOutboundHTTPConfig() OutboundHTTPConfig {
+	cfg := OutboundHTTPConfig{
+		MaxIdleConns:        env.Int("HTTP_MAX_IDLE_CONNS", 100),
+		MaxIdleConnsPerHost: env.Int("HTTP_MAX_IDLE_CONNS_PER_HOST", 10),
+		MaxConnsPerHost:     env.Int("HTTP_MAX_CONNS_PER_HOST", 0),
+		IdleConnTimeout:     env.Duration("HTTP_IDLE_CONN_TIMEOUT_SECONDS", 90*time.Second, time.Second),
+		ConnectTimeout:      env.Duration("HTTP_CONNECT_TIMEOUT_SECONDS", 10*time.Second, time.Second),
+		ReadTimeout:         env.Duration("HTTP_READ_TIMEOUT_SECONDS", 30*time.Second, time.Second),
+		CABundlePath:        env.String("HTTP_CA_BUNDLE_PATH", ""),
+		ProxyURL:            env.String("HTTP_PROXY_URL", ""),
+		Destinations:        make(map[string]HTTPDestination),
+	}
+
+	// HTTP_DESTINATIONS is a JSON object of profile name to HTTPDestination
+	if raw := env.Secret("HTTP_DESTINATIONS", ""); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &cfg.Destinations); err != nil {
+			cfg.destinationsErr = fmt.Errorf("HTTP_DESTINATIONS is not valid JSON: %w", err)
+		}
+	}
+
+	return cfg
+}
+
+// Validate checks the outbound HTTP configuration at startup
+func (c OutboundHTTPConfig) Validate() error {
+	if c.destinationsErr != nil {
+		return c.destinationsErr
+	}
+	if c.IdleConnTimeout < 0 || c.ConnectTimeout < 0 || c.ReadTimeout < 0 {
+		return errors.New("HTTP_IDLE_CONN_TIMEOUT_SECONDS, HTTP_CONNECT_TIMEOUT_SECONDS and HTTP_READ_TIMEOUT_SECONDS must not be negative")
+	}
+	if c.ProxyURL != "" {
+		if _, err := url.Parse(c.ProxyURL); err != nil {
+			return fmt.Errorf("HTTP_PROXY_URL is invalid: %w", err)
+		}
+	}
+
+	for name, dest := range c.Destinations {
+		if dest.BaseURL != "" {
+			if u, err := url.Parse(dest.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("destination %q: base_url must be an absolute URL", name)
+			}
+		}
+		if dest.ProxyURL != "" {
+			if _, err := url.Parse(dest.ProxyURL); err != nil {
+				return fmt.Errorf("destination %q: proxy_url is invalid: %w", name, err)
+			}
+		}
+		if dest.ConnectTimeoutSeconds < 0 || dest.ReadTimeoutSeconds < 0 {
+			return fmt.Errorf("destination %q: timeouts must not be negative", name)
+		}
+	}
+
+	return nil
+}
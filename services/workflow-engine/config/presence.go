@@ -0,0 +1,49 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"chorus/pkg/env"
+)
+
+// What presence steps do when the presence service cannot be reached
+const (
+	PresenceUnavailableFail          = "fail"           // fail the step
+	PresenceUnavailableAssumeOffline = "assume_offline" // carry on as if the users were offline
+)
+
+// PresenceConfig describes how check_presence, wait_for_presence and set_presence
+// steps reach the presence service
+type PresenceConfig struct {
+	URL     string        // of the presence service, "" disables the presence steps
+	APIKey  string        // sent as X-API-Key
+	Timeout time.Duration // of one request to the presence service
+
+	// Default of the steps' on_unavailable: fail or assume_offline
+	OnUnavailable string
+}
+
+func loadPresenceConfig() PresenceConfig {
+	return PresenceConfig{
+		URL:           strings.TrimSuffix(env.URL("PRESENCE_URL", ""), "/"),
+		APIKey:        env.String("PRESENCE_API_KEY", ""),
+		Timeout:       env.Duration("PRESENCE_TIMEOUT_SECONDS", 5*time.Second, time.Second),
+		OnUnavailable: strings.ToLower(env.String("PRESENCE_ON_UNAVAILABLE", PresenceUnavailableFail)),
+	}
+}
+
+// Validate rejects an unknown fallback and a timeout that is not positive
+func (c PresenceConfig) Validate() error {
+	switch c.OnUnavailable {
+	case PresenceUnavailableFail, PresenceUnavailableAssumeOffline:
+	default:
+		return fmt.Errorf("PRESENCE_ON_UNAVAILABLE must be fail or assume_offline, not %q", c.OnUnavailable)
+	}
+	if c.Timeout <= 0 {
+		return errors.New("PRESENCE_TIMEOUT_SECONDS must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"chorus/pkg/env"
+)
+
+// Redis deployment modes
+const (
+	RedisModeStandalone = "standalone"
+	RedisModeSentinel   = "sentinel"
+	RedisModeCluster    = "cluster"
+)
+
+// RedisConfig describes how to connect to Redis
+type RedisConfig struct {
+	// redis:// or rediss:// URL; the address in standalone mode, and the seed node
+	// in cluster mode when Addrs is empty
+	URL  string
+	Mode string // standalone, sentinel or cluster
+
+	// Sentinel or cluster node addresses (host:port)
+	Addrs            []string
+	MasterName       string // sentinel only
+	Username         string // sentinel/cluster; standalone takes credentials from URL
+	Password         string
+	SentinelPassword string
+	DB               int // overrides the URL's database when >= 0; ignored in cluster mode
+
+	// Pool sizing; 0 keeps the go-redis defaults
+	PoolSize     int
+	MinIdleConns int
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// TLS is implied by a rediss:// URL
+	TLS                   bool
+	TLSCAPath             string
+	TLSInsecureSkipVerify bool
+}
+
+func loadRedisConfig() RedisConfig {
+	cfg := RedisConfig{
+		URL:                   env.String("REDIS_URL", "redis://localhost:6379"),
+		Mode:                  strings.ToLower(env.String("REDIS_MODE", RedisModeStandalone)),
+		MasterName:            env.String("REDIS_MASTER_NAME", ""),
+		Username:              env.String("REDIS_USERNAME", ""),
+		Password:              env.String("REDIS_PASSWORD", ""),
+		SentinelPassword:      env.String("REDIS_SENTINEL_PASSWORD", ""),
+		DB:                    env.Int("REDIS_DB", -1),
+		PoolSize:              env.Int("REDIS_POOL_SIZE", 0),
+		MinIdleConns:          env.Int("REDIS_MIN_IDLE_CONNS", 0),
+		DialTimeout:           env.Duration("REDIS_DIAL_TIMEOUT_SECONDS", 5*time.Second, time.Second),
+		ReadTimeout:           env.Duration("REDIS_READ_TIMEOUT_SECONDS", 3*time.Second, time.Second),
+		WriteTimeout:          env.Duration("REDIS_WRITE_TIMEOUT_SECONDS", 3*time.Second, time.Second),
+		TLS:                   env.Bool("REDIS_TLS", false),
+		TLSCAPath:             env.String("REDIS_TLS_CA_PATH", ""),
+		TLSInsecureSkipVerify: env.Bool("REDIS_TLS_INSECURE_SKIP_VERIFY", false),
+	}
+
+	cfg.Addrs = env.StringSlice("REDIS_ADDRS", nil)
+
+	return cfg
+}
+
+// Validate rejects an unknown mode and settings out of range
+func (c RedisConfig) Validate() error {
+	switch c.Mode {
+	case RedisModeStandalone, RedisModeSentinel, RedisModeCluster:
+	default:
+		return fmt.Errorf("REDIS_MODE must be standalone, sentinel or cluster, not %q", c.Mode)
+	}
+	switch {
+	case c.DB < -1:
+		return fmt.Errorf("REDIS_DB must not be negative, not %d", c.DB)
+	case c.PoolSize < 0 || c.MinIdleConns < 0:
+		return errors.New("REDIS_POOL_SIZE and REDIS_MIN_IDLE_CONNS must not be negative")
+	case c.DialTimeout <= 0 || c.ReadTimeout <= 0 || c.WriteTimeout <= 0:
+		return errors.New("REDIS_DIAL_TIMEOUT_SECONDS, REDIS_READ_TIMEOUT_SECONDS and REDIS_WRITE_TIMEOUT_SECONDS must be positive")
+	}
+	return nil
+}
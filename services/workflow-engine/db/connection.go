@@ -2,6 +2,7 @@ package db
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"gorm.io/driver/postgres"
@@ -64,19 +65,76 @@ func autoMigrate(db *gorm.DB) error {
 	}
 
 	// Auto-migrate all models
-	models := []interface{}{
+	for _, model := range Models() {
+		if err := db.AutoMigrate(model); err != nil {
+			return fmt.Errorf("failed to migrate %T: %w", model, err)
+		}
+	}
+
+	// Webhook slugs live in trigger_config, which AutoMigrate cannot index
+	if err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_workflow_triggers_webhook_slug
+		ON workflow.triggers ((trigger_config->>'slug')) WHERE trigger_type = 'webhook'`).Error; err != nil {
+		return fmt.Errorf("failed to create webhook slug index: %w", err)
+	}
+
+	// Databases created by init.sql only allow the values from before these were added
+	if err := widenCheck(db, "workflow.steps", "check_step_status", "status",
+		"pending", "running", "completed", "failed", "skipped", "waiting"); err != nil {
+		return err
+	}
+	if err := widenCheck(db, "workflow.triggers", "check_trigger_type", "trigger_type",
+		"manual", "schedule", "event", "webhook", "condition", "presence"); err != nil {
+		return err
+	}
+
+	// Presence events look up the steps waiting for a user
+	if err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_workflow_steps_presence_wait
+		ON workflow.steps ((output_data->'presence_wait'->>'user_id')) WHERE status = 'waiting'`).Error; err != nil {
+		return fmt.Errorf("failed to create presence wait index: %w", err)
+	}
+
+	return nil
+}
+
+// Models are the models whose tables autoMigrate creates
+func Models() []interface{} {
+	return []interface{}{
 		&models.WorkflowTemplate{},
 		&models.WorkflowInstance{},
 		&models.WorkflowStep{},
 		&models.WorkflowTrigger{},
+		&models.InstanceComment{},
+		&models.StepPayload{},
+		&models.APIKey{},
+		&models.WebhookSlugRedirect{},
+		&models.WorkflowSnippet{},
+		&models.TemplateWebhook{},
+		&models.TemplateWebhookDelivery{},
+		&models.Task{},
+		&models.TaskEvent{},
+		&models.TemplateCategory{},
+		&models.EngineEvent{},
+		&models.EventReplay{},
 	}
+}
 
-	for _, model := range models {
-		if err := db.AutoMigrate(model); err != nil {
-			return fmt.Errorf("failed to migrate %T: %w", model, err)
-		}
+// widenCheck replaces the CHECK constraint of a table limiting column to a list of
+// values by one allowing values, unless it allows the last of them already
+func widenCheck(db *gorm.DB, table, constraint, column string, values ...string) error {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = "'" + value + "'"
+	}
+	err := db.Exec(fmt.Sprintf(`DO $$ BEGIN
+		IF NOT EXISTS (SELECT 1 FROM pg_constraint
+			WHERE conname = '%[2]s' AND pg_get_constraintdef(oid) LIKE '%%''%[4]s''%%') THEN
+			ALTER TABLE %[1]s DROP CONSTRAINT IF EXISTS %[2]s;
+			ALTER TABLE %[1]s ADD CONSTRAINT %[2]s CHECK (%[3]s IN (%[5]s)) NOT VALID;
+		END IF;
+	END $$`, table, constraint, column, values[len(values)-1], strings.Join(quoted, ", "))).Error
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", constraint, err)
 	}
-
 	return nil
 }
 
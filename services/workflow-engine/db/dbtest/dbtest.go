@@ -0,0 +1,259 @@
+// Package dbtest opens SQLite databases with the engine's tables for tests, in place
+// of the Postgres the engine runs against. The workflow schema is an attached
+// database, so the models' workflow.* table names resolve as they do in Postgres,
+// and the jsonb containment the permission checks query with is rewritten into a
+// function.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+
+	"chorus/workflow-engine/db"
+)
+
+const driverName = "sqlite3_chorus"
+
+var registerDriver sync.Once
+
+// New opens an empty database in a temporary directory with the tables of
+// db.Models, closed when the test ends
+func New(t testing.TB) *gorm.DB {
+	t.Helper()
+	registerDriver.Do(func() {
+		sql.Register(driverName, sqliteDriver{&sqlite3.SQLiteDriver{ConnectHook: connect}})
+	})
+
+	path := filepath.Join(t.TempDir(), "chorus.db")
+	dsn := "file:" + path + "?_busy_timeout=5000&_txlock=immediate&_journal_mode=WAL"
+	gdb, err := gorm.Open(dialector{sqlite.Dialector{DriverName: driverName, DSN: dsn}}, &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+		// SQLite cannot reference a table in another schema
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	sqlDB, err := gdb.DB()
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := migrate(gdb, db.Models()); err != nil {
+		t.Fatalf("migrate test database: %v", err)
+	}
+	return gdb
+}
+
+// connect attaches the workflow schema next to the main database of a connection
+// and adds the Postgres functions the models' defaults call
+func connect(conn *sqlite3.SQLiteConn) error {
+	if err := conn.RegisterFunc("uuid_generate_v4", func() string { return uuid.NewString() }, false); err != nil {
+		return err
+	}
+	if err := conn.RegisterFunc("jsonb_contains", jsonbContains, true); err != nil {
+		return err
+	}
+
+	rows, err := conn.Query("PRAGMA database_list", nil)
+	if err != nil {
+		return err
+	}
+	var mainFile string
+	row := make([]driver.Value, 3)
+	for rows.Next(row) == nil {
+		if fmt.Sprintf("%s", row[1]) == "main" {
+			mainFile = fmt.Sprintf("%s", row[2])
+		}
+	}
+	rows.Close()
+	if mainFile == "" {
+		return fmt.Errorf("test database has no file")
+	}
+
+	if _, err := conn.Exec("ATTACH DATABASE ? AS workflow", []driver.Value{mainFile + ".workflow"}); err != nil {
+		return err
+	}
+	_, err = conn.Exec("PRAGMA workflow.journal_mode = WAL", nil)
+	return err
+}
+
+// jsonbContains is Postgres' @> on the JSON documents doc and sub
+func jsonbContains(doc, sub interface{}) (bool, error) {
+	var docValue, subValue interface{}
+	if err := unmarshalValue(doc, &docValue); err != nil {
+		return false, err
+	}
+	if err := unmarshalValue(sub, &subValue); err != nil {
+		return false, err
+	}
+	return contains(docValue, subValue), nil
+}
+
+func unmarshalValue(value interface{}, dest *interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, dest)
+	case string:
+		return json.Unmarshal([]byte(v), dest)
+	default:
+		return fmt.Errorf("jsonb_contains: unexpected %T", value)
+	}
+}
+
+// contains reports whether doc contains sub: objects hold sub's keys with values
+// containing sub's, arrays hold an element containing each of sub's, and scalars
+// are equal
+func contains(doc, sub interface{}) bool {
+	switch s := sub.(type) {
+	case map[string]interface{}:
+		d, ok := doc.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range s {
+			if !contains(d[key], value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		d, ok := doc.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, value := range s {
+			found := false
+			for _, element := range d {
+				if contains(element, value) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(doc, sub)
+	}
+}
+
+// jsonbContainment matches the Postgres containment of a column and a parameter
+var jsonbContainment = regexp.MustCompile(`([\w.]+) @> \?::jsonb`)
+
+func rewrite(query string) string {
+	return jsonbContainment.ReplaceAllString(query, "jsonb_contains($1, ?)")
+}
+
+// sqliteDriver opens connections that rewrite their queries
+type sqliteDriver struct {
+	*sqlite3.SQLiteDriver
+}
+
+func (d sqliteDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return sqliteConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+type sqliteConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c sqliteConn) Prepare(query string) (driver.Stmt, error) {
+	return c.SQLiteConn.Prepare(rewrite(query))
+}
+
+func (c sqliteConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.SQLiteConn.PrepareContext(ctx, rewrite(query))
+}
+
+func (c sqliteConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.SQLiteConn.ExecContext(ctx, rewrite(query), args)
+}
+
+func (c sqliteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.SQLiteConn.QueryContext(ctx, rewrite(query), args)
+}
+
+// migrate creates the tables of models at once, as the migrator cannot tell a table
+// in an attached schema exists. Column defaults calling functions are wrapped in
+// parentheses, which SQLite requires of expressions.
+func migrate(gdb *gorm.DB, models []interface{}) error {
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: gdb}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		for _, field := range stmt.Schema.Fields {
+			if strings.HasSuffix(field.DefaultValue, ")") && !strings.HasPrefix(field.DefaultValue, "(") {
+				field.DefaultValue = "(" + field.DefaultValue + ")"
+			}
+		}
+	}
+	return gdb.AutoMigrate(models...)
+}
+
+// dialector is SQLite whose migrator creates the indexes of tables in an attached
+// schema, which SQLite names on the index rather than on the table
+type dialector struct {
+	sqlite.Dialector
+}
+
+func (d dialector) Migrator(gdb *gorm.DB) gorm.Migrator {
+	return migrator{d.Dialector.Migrator(gdb).(sqlite.Migrator)}
+}
+
+type migrator struct {
+	sqlite.Migrator
+}
+
+func (m migrator) CreateIndex(value interface{}, name string) error {
+	return m.RunWithValue(value, func(stmt *gorm.Statement) error {
+		idx := stmt.Schema.LookIndex(name)
+		if idx == nil {
+			return m.Migrator.CreateIndex(value, name)
+		}
+		schemaName, table, qualified := strings.Cut(stmt.Schema.Table, ".")
+		if !qualified {
+			return m.Migrator.CreateIndex(value, name)
+		}
+
+		createIndexSQL := "CREATE "
+		if idx.Class != "" {
+			createIndexSQL += idx.Class + " "
+		}
+		createIndexSQL += "INDEX ? ON ??"
+		if idx.Where != "" {
+			createIndexSQL += " WHERE " + idx.Where
+		}
+		return m.DB.Exec(createIndexSQL,
+			clause.Table{Name: schemaName + "." + idx.Name},
+			clause.Table{Name: table},
+			m.BuildIndexOptions(idx.Fields, stmt),
+		).Error
+	})
+}
@@ -0,0 +1,123 @@
+// Package encryption encrypts workflow data at rest with AES-256-GCM, under data
+// keys derived from master keys a KeyProvider supplies.
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+)
+
+// Version of the envelope format and key derivation
+const envelopeVersion = 1
+
+// Info of the derivation of data keys from master keys, so a master key used
+// elsewhere does not yield the same key
+const dataKeyInfo = "chorus workflow-engine data key v1"
+
+// Envelope is a sealed value with what opening it takes, besides the master key
+type Envelope struct {
+	Version int    `json:"v"`
+	KeyID   string `json:"kid"` // of the master key the data key was derived from
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"` // ciphertext and GCM tag
+}
+
+// Cipher seals and opens envelopes. The AEADs of the keys it used are kept, so the
+// provider is asked for each master key once.
+type Cipher struct {
+	provider KeyProvider
+
+	mu    sync.Mutex
+	aeads map[string]cipher.AEAD
+}
+
+func NewCipher(provider KeyProvider) *Cipher {
+	return &Cipher{
+		provider: provider,
+		aeads:    make(map[string]cipher.AEAD),
+	}
+}
+
+// ActiveKeyID names the master key Seal encrypts under
+func (c *Cipher) ActiveKeyID() string {
+	return c.provider.ActiveKeyID()
+}
+
+// Seal encrypts plaintext under the active key. additionalData is authenticated
+// but not encrypted, and must be passed again to Open.
+func (c *Cipher) Seal(ctx context.Context, plaintext, additionalData []byte) (*Envelope, error) {
+	keyID := c.provider.ActiveKeyID()
+	aead, err := c.aead(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return &Envelope{
+		Version: envelopeVersion,
+		KeyID:   keyID,
+		Nonce:   nonce,
+		Data:    aead.Seal(nil, nonce, plaintext, additionalData),
+	}, nil
+}
+
+// Open decrypts an envelope sealed with the same additionalData
+func (c *Cipher) Open(ctx context.Context, envelope *Envelope, additionalData []byte) ([]byte, error) {
+	if envelope.Version != envelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version %d", envelope.Version)
+	}
+	aead, err := c.aead(ctx, envelope.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(envelope.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+	plaintext, err := aead.Open(nil, envelope.Nonce, envelope.Data, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt with key %q: %w", envelope.KeyID, err)
+	}
+	return plaintext, nil
+}
+
+func (c *Cipher) aead(ctx context.Context, keyID string) (cipher.AEAD, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if aead, ok := c.aeads[keyID]; ok {
+		return aead, nil
+	}
+
+	master, err := c.provider.MasterKey(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(deriveDataKey(master))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c.aeads[keyID] = aead
+	return aead, nil
+}
+
+// deriveDataKey derives the 32-byte data key of a master key with HKDF-SHA256
+// (RFC 5869), without salt; one block of output is the whole key
+func deriveDataKey(master []byte) []byte {
+	extract := hmac.New(sha256.New, make([]byte, sha256.Size))
+	extract.Write(master)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(dataKeyInfo))
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
@@ -0,0 +1,79 @@
+package encryption
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Bytes of a master key
+const MasterKeySize = 32
+
+// KeyProvider supplies the master keys data keys are derived from. StaticKeys reads
+// them from the configuration; a provider backed by a KMS can unwrap them instead.
+type KeyProvider interface {
+	// ActiveKeyID names the master key new data is encrypted under
+	ActiveKeyID() string
+	// MasterKey returns the master key of an ID, which may be retired but must still
+	// decrypt the data encrypted under it
+	MasterKey(ctx context.Context, id string) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider holding its master keys in memory
+type StaticKeys struct {
+	active string
+	keys   map[string][]byte
+}
+
+// ParseStaticKeys reads master keys from a comma-separated list of id:base64 pairs,
+// such as ENCRYPTION_MASTER_KEYS, with active naming the one to encrypt under. Each
+// key must decode to MasterKeySize bytes.
+func ParseStaticKeys(list, active string) (*StaticKeys, error) {
+	keys := make(map[string][]byte)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("master key %q must be id:base64", redactEntry(entry))
+		}
+		if _, exists := keys[id]; exists {
+			return nil, fmt.Errorf("master key %q is listed twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != MasterKeySize {
+			return nil, fmt.Errorf("master key %q must be %d base64-encoded bytes", id, MasterKeySize)
+		}
+		keys[id] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no master keys")
+	}
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("active key %q is not one of the master keys", active)
+	}
+	return &StaticKeys{active: active, keys: keys}, nil
+}
+
+func (k *StaticKeys) ActiveKeyID() string {
+	return k.active
+}
+
+func (k *StaticKeys) MasterKey(_ context.Context, id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %q", id)
+	}
+	return key, nil
+}
+
+// redactEntry keeps the ID of a malformed entry for error messages, not its key
+func redactEntry(entry string) string {
+	if id, _, ok := strings.Cut(entry, ":"); ok {
+		return id + ":***"
+	}
+	return "***"
+}
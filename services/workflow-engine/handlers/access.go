@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+	"chorus/workflow-engine/models"
+)
+
+// Role that bypasses template permissions
+const adminRole = "admin"
+
+// principal is the caller as seen by template and instance permission checks
+type principal struct {
+	userID string
+	team   string
+	org    string
+	roles  []string
+	admin  bool
+}
+
+func principalFrom(c *gin.Context) principal {
+	return principal{
+		userID: c.GetString("userID"),
+		team:   c.GetString("team"),
+		org:    c.GetString("tenantID"),
+		roles:  c.GetStringSlice("roles"),
+		admin:  c.GetString("role") == adminRole,
+	}
+}
+
+// isOwner reports whether p owns t. The creator counts as an owner so templates
+// created before owners existed stay editable by them.
+func (p principal) isOwner(t *models.WorkflowTemplate) bool {
+	return p.userID != "" && (t.Owners.Contains(p.userID) || t.CreatedBy == p.userID)
+}
+
+func (p principal) inTeam(t *models.WorkflowTemplate) bool {
+	return p.team != "" && t.Team == p.team
+}
+
+// canView reports whether p may see t and launch instances from it
+func (p principal) canView(t *models.WorkflowTemplate) bool {
+	if p.admin || p.isOwner(t) {
+		return true
+	}
+	switch t.Visibility {
+	case models.TemplateVisibilityPublic:
+		return true
+	case models.TemplateVisibilityTeam:
+		return p.inTeam(t)
+	default:
+		return false
+	}
+}
+
+// canEdit reports whether p may update or delete t. Team members may edit team
+// templates; public templates are only edited by their owners.
+func (p principal) canEdit(t *models.WorkflowTemplate) bool {
+	if p.admin || p.isOwner(t) {
+		return true
+	}
+	return t.Visibility == models.TemplateVisibilityTeam && p.inTeam(t)
+}
+
+// canShare reports whether p may change who can see t
+func (p principal) canShare(t *models.WorkflowTemplate) bool {
+	return p.admin || p.isOwner(t)
+}
+
+// canViewInstance reports whether p may see instance and follow its events, and
+// why: p created it, is of its org, or has one of viewerRoles
+func (p principal) canViewInstance(instance *models.WorkflowInstance, viewerRoles []string) (bool, string) {
+	switch {
+	case p.hasRole(viewerRoles):
+		return true, models.InstanceAccessRole
+	case p.userID != "" && instance.CreatedBy == p.userID:
+		return true, models.InstanceAccessCreator
+	case p.org != "" && instance.OrgID == p.org:
+		return true, models.InstanceAccessOrg
+	default:
+		return false, models.InstanceAccessDenied
+	}
+}
+
+// hasRole reports whether p is an admin or has one of roles
+func (p principal) hasRole(roles []string) bool {
+	return p.admin || slices.ContainsFunc(p.roles, func(role string) bool { return slices.Contains(roles, role) })
+}
+
+// canHandleTask reports whether p may act on task: its assignee may, and so may
+// admins and holders of managerRoles, who also see and reassign everyone's tasks
+func (p principal) canHandleTask(task *models.Task, managerRoles []string) bool {
+	return (p.userID != "" && task.Assignee == p.userID) || p.hasRole(managerRoles)
+}
+
+// scopeTemplates restricts a template query to the templates p can see
+func (p principal) scopeTemplates(query *gorm.DB) *gorm.DB {
+	if p.admin {
+		return query
+	}
+
+	owner, _ := json.Marshal([]string{p.userID})
+	return query.Where(
+		"visibility = ? OR owners @> ?::jsonb OR created_by = ? OR (visibility = ? AND team = ? AND team <> '')",
+		models.TemplateVisibilityPublic, string(owner), p.userID, models.TemplateVisibilityTeam, p.team,
+	)
+}
+
+// validateVisibility checks a visibility value from a request
+func validateVisibility(visibility string) error {
+	switch visibility {
+	case models.TemplateVisibilityPrivate, models.TemplateVisibilityTeam, models.TemplateVisibilityPublic:
+		return nil
+	default:
+		return fmt.Errorf("visibility must be one of private, team or public")
+	}
+}
+
+// visibleInstance reports whether an instance exists and its template is one the
+// caller can see, answering 404 otherwise
+func visibleInstance(c *gin.Context, db *gorm.DB, logger *logging.Logger, instanceID uuid.UUID) bool {
+	var instance models.WorkflowInstance
+	err := db.Select("id", "template_id").
+		Preload("Template", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id", "visibility", "owners", "team", "created_by")
+		}).
+		First(&instance, instanceID).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apierror.Abort(c, http.StatusNotFound, "Instance not found", nil)
+			return false
+		}
+		logger.Error("Failed to fetch instance", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch instance", nil)
+		return false
+	}
+	if !principalFrom(c).canView(&instance.Template) {
+		apierror.Abort(c, http.StatusNotFound, "Instance not found", nil)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"chorus/pkg/logging"
+	"chorus/workflow-engine/config"
+	"chorus/workflow-engine/db/dbtest"
+	"chorus/workflow-engine/models"
+	"chorus/workflow-engine/services"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// caller is who a test request comes from, set on the context as the auth
+// middleware would
+type caller struct {
+	userID string
+	team   string
+	org    string
+	role   string
+}
+
+var (
+	author    = caller{userID: "author", team: "payments"}
+	teammate  = caller{userID: "teammate", team: "payments"}
+	outsider  = caller{userID: "outsider", team: "support"}
+	admin     = caller{userID: "root", role: adminRole}
+	coauthor  = caller{userID: "coauthor", team: "support"}
+	orgmate   = caller{userID: "orgmate", team: "support", org: "acme"}
+	callerKey = "X-Test-Caller"
+)
+
+// newAccessRouter serves the template and instance endpoints on a SQLite database,
+// taking the caller from the X-Test-Caller header
+func newAccessRouter(t *testing.T) (*gin.Engine, *gorm.DB) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	t.Setenv("REDIS_URL", "redis://"+mr.Addr())
+	cfg := config.LoadConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("test config: %v", err)
+	}
+	logger := &logging.Logger{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	db := dbtest.New(t)
+	engine := services.NewEngine(db, cfg, logger)
+	t.Cleanup(engine.Stop)
+	templateHandler := NewTemplateHandler(db, engine, cfg.Pagination, false, logger)
+	instanceHandler := NewInstanceHandler(db, engine, services.NewInstanceService(db, engine, logger), nil, cfg.Pagination, logger)
+	commentHandler := NewCommentHandler(db, logger)
+
+	callers := map[string]caller{}
+	for _, c := range []caller{author, teammate, outsider, admin, coauthor, orgmate} {
+		callers[c.userID] = c
+	}
+	router := gin.New()
+	v1 := router.Group("/api/v1", func(c *gin.Context) {
+		who := callers[c.GetHeader(callerKey)]
+		c.Set("userID", who.userID)
+		c.Set("team", who.team)
+		c.Set("tenantID", who.org)
+		c.Set("role", who.role)
+	})
+	v1.GET("/templates", templateHandler.ListTemplates)
+	v1.GET("/templates/:id", templateHandler.GetTemplate)
+	v1.PUT("/templates/:id", templateHandler.UpdateTemplate)
+	v1.DELETE("/templates/:id", templateHandler.DeleteTemplate)
+	v1.GET("/instances", instanceHandler.ListInstances)
+	v1.POST("/instances", instanceHandler.CreateInstance)
+	v1.GET("/instances/:id", instanceHandler.GetInstance)
+	v1.GET("/instances/:id/steps", instanceHandler.GetInstanceSteps)
+	v1.GET("/instances/:id/status", instanceHandler.GetInstanceStatus)
+	v1.GET("/instances/:id/comments", commentHandler.ListComments)
+	v1.POST("/instances/:id/comments", commentHandler.CreateComment)
+	v1.DELETE("/instances/:id/comments/:comment_id", commentHandler.DeleteComment)
+	return router, db
+}
+
+// createTemplate stores an active template of author with visibility, shared with
+// coauthor
+func createTemplate(t *testing.T, db *gorm.DB, visibility string) models.WorkflowTemplate {
+	t.Helper()
+	template := models.WorkflowTemplate{
+		Name:       visibility + "-" + uuid.NewString()[:8],
+		Schema:     models.JSONB{"steps": []interface{}{}},
+		IsActive:   true,
+		Visibility: visibility,
+		Owners:     models.StringList{coauthor.userID},
+		Team:       author.team,
+		CreatedBy:  author.userID,
+	}
+	if err := db.Create(&template).Error; err != nil {
+		t.Fatalf("create template: %v", err)
+	}
+	return template
+}
+
+func createInstance(t *testing.T, db *gorm.DB, template models.WorkflowTemplate) models.WorkflowInstance {
+	t.Helper()
+	instance := models.WorkflowInstance{
+		TemplateID: template.ID,
+		Name:       "instance",
+		Status:     models.WorkflowStatusCompleted,
+		Variables:  models.JSONB{},
+		Context:    models.JSONB{},
+		CreatedBy:  author.userID,
+	}
+	if err := db.Create(&instance).Error; err != nil {
+		t.Fatalf("create instance: %v", err)
+	}
+	return instance
+}
+
+func serve(router http.Handler, who caller, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(callerKey, who.userID)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// listedIDs returns the ids in the data of a list response
+func listedIDs(t *testing.T, rec *httptest.ResponseRecorder) map[uuid.UUID]bool {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: got %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Data []struct {
+			ID uuid.UUID `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode list: %v", err)
+	}
+	ids := map[uuid.UUID]bool{}
+	for _, item := range resp.Data {
+		ids[item.ID] = true
+	}
+	return ids
+}
+
+func TestTemplateVisibility(t *testing.T) {
+	router, db := newAccessRouter(t)
+	templates := map[string]models.WorkflowTemplate{}
+	for _, visibility := range []string{models.TemplateVisibilityPrivate, models.TemplateVisibilityTeam, models.TemplateVisibilityPublic} {
+		templates[visibility] = createTemplate(t, db, visibility)
+	}
+
+	tests := []struct {
+		caller  caller
+		visible map[string]bool
+		edit    map[string]bool
+	}{
+		{author, map[string]bool{"private": true, "team": true, "public": true}, map[string]bool{"private": true, "team": true, "public": true}},
+		{coauthor, map[string]bool{"private": true, "team": true, "public": true}, map[string]bool{"private": true, "team": true, "public": true}},
+		{teammate, map[string]bool{"team": true, "public": true}, map[string]bool{"team": true}},
+		{outsider, map[string]bool{"public": true}, map[string]bool{}},
+		{admin, map[string]bool{"private": true, "team": true, "public": true}, map[string]bool{"private": true, "team": true, "public": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.caller.userID, func(t *testing.T) {
+			listed := listedIDs(t, serve(router, tt.caller, http.MethodGet, "/api/v1/templates", ""))
+			for visibility, template := range templates {
+				if listed[template.ID] != tt.visible[visibility] {
+					t.Errorf("%s template listed = %v, want %v", visibility, listed[template.ID], tt.visible[visibility])
+				}
+
+				rec := serve(router, tt.caller, http.MethodGet, "/api/v1/templates/"+template.ID.String(), "")
+				if want := statusFor(tt.visible[visibility], http.StatusOK); rec.Code != want {
+					t.Errorf("get %s template: got %d, want %d", visibility, rec.Code, want)
+				}
+
+				want := http.StatusOK
+				switch {
+				case !tt.visible[visibility]:
+					want = http.StatusNotFound
+				case !tt.edit[visibility]:
+					want = http.StatusForbidden
+				}
+				rec = serve(router, tt.caller, http.MethodPut, "/api/v1/templates/"+template.ID.String(), `{"description": "updated"}`)
+				if rec.Code != want {
+					t.Errorf("update %s template: got %d, want %d", visibility, rec.Code, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteTemplateVisibility(t *testing.T) {
+	router, db := newAccessRouter(t)
+
+	private := createTemplate(t, db, models.TemplateVisibilityPrivate)
+	if rec := serve(router, outsider, http.MethodDelete, "/api/v1/templates/"+private.ID.String(), ""); rec.Code != http.StatusNotFound {
+		t.Errorf("outsider deleting a private template: got %d, want 404", rec.Code)
+	}
+	public := createTemplate(t, db, models.TemplateVisibilityPublic)
+	if rec := serve(router, teammate, http.MethodDelete, "/api/v1/templates/"+public.ID.String(), ""); rec.Code != http.StatusForbidden {
+		t.Errorf("teammate deleting a public template: got %d, want 403", rec.Code)
+	}
+	if rec := serve(router, admin, http.MethodDelete, "/api/v1/templates/"+private.ID.String(), ""); rec.Code != http.StatusOK {
+		t.Errorf("admin deleting a private template: got %d, want 200", rec.Code)
+	}
+}
+
+func TestInstanceVisibility(t *testing.T) {
+	router, db := newAccessRouter(t)
+	templates := map[string]models.WorkflowTemplate{}
+	instances := map[string]models.WorkflowInstance{}
+	for _, visibility := range []string{models.TemplateVisibilityPrivate, models.TemplateVisibilityTeam, models.TemplateVisibilityPublic} {
+		templates[visibility] = createTemplate(t, db, visibility)
+		instances[visibility] = createInstance(t, db, templates[visibility])
+	}
+
+	tests := []struct {
+		caller  caller
+		visible map[string]bool
+	}{
+		{author, map[string]bool{"private": true, "team": true, "public": true}},
+		{coauthor, map[string]bool{"private": true, "team": true, "public": true}},
+		{teammate, map[string]bool{"team": true, "public": true}},
+		{outsider, map[string]bool{"public": true}},
+		{admin, map[string]bool{"private": true, "team": true, "public": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.caller.userID, func(t *testing.T) {
+			listed := listedIDs(t, serve(router, tt.caller, http.MethodGet, "/api/v1/instances", ""))
+			for visibility, instance := range instances {
+				if listed[instance.ID] != tt.visible[visibility] {
+					t.Errorf("instance of %s template listed = %v, want %v", visibility, listed[instance.ID], tt.visible[visibility])
+				}
+
+				want := statusFor(tt.visible[visibility], http.StatusOK)
+				if rec := serve(router, tt.caller, http.MethodGet, "/api/v1/instances/"+instance.ID.String(), ""); rec.Code != want {
+					t.Errorf("get instance of %s template: got %d, want %d", visibility, rec.Code, want)
+				}
+				if rec := serve(router, tt.caller, http.MethodGet, "/api/v1/instances/"+instance.ID.String()+"/steps", ""); rec.Code != want {
+					t.Errorf("get steps of instance of %s template: got %d, want %d", visibility, rec.Code, want)
+				}
+				if rec := serve(router, tt.caller, http.MethodGet, "/api/v1/instances/"+instance.ID.String()+"/status", ""); rec.Code != want {
+					t.Errorf("get status of instance of %s template: got %d, want %d", visibility, rec.Code, want)
+				}
+
+				body := `{"template_id": "` + templates[visibility].ID.String() + `", "name": "launched"}`
+				want = statusFor(tt.visible[visibility], http.StatusCreated)
+				if rec := serve(router, tt.caller, http.MethodPost, "/api/v1/instances", body); rec.Code != want {
+					t.Errorf("create instance of %s template: got %d, want %d: %s", visibility, rec.Code, want, rec.Body)
+				}
+			}
+		})
+	}
+}
+
+// The status of an instance is also shown to whoever may follow it, such as a
+// member of its org, and hidden from anyone else
+func TestInstanceStatusVisibility(t *testing.T) {
+	router, db := newAccessRouter(t)
+	instance := createInstance(t, db, createTemplate(t, db, models.TemplateVisibilityPrivate))
+	if err := db.Model(&instance).Update("org_id", orgmate.org).Error; err != nil {
+		t.Fatalf("set org: %v", err)
+	}
+	path := "/api/v1/instances/" + instance.ID.String() + "/status"
+
+	for _, tt := range []struct {
+		caller caller
+		want   int
+	}{
+		{author, http.StatusOK},
+		{orgmate, http.StatusOK},
+		{teammate, http.StatusNotFound},
+		{outsider, http.StatusNotFound},
+	} {
+		rec := serve(router, tt.caller, http.MethodGet, path, "")
+		if rec.Code != tt.want {
+			t.Errorf("%s getting the status: got %d, want %d: %s", tt.caller.userID, rec.Code, tt.want, rec.Body)
+			continue
+		}
+		if tt.want == http.StatusNotFound && strings.Contains(rec.Body.String(), instance.ID.String()) {
+			t.Errorf("%s was shown the instance: %s", tt.caller.userID, rec.Body)
+		}
+	}
+}
+
+// The comments of an instance are hidden, and closed, to those who cannot see it
+func TestCommentVisibility(t *testing.T) {
+	router, db := newAccessRouter(t)
+	instance := createInstance(t, db, createTemplate(t, db, models.TemplateVisibilityPrivate))
+	comments := "/api/v1/instances/" + instance.ID.String() + "/comments"
+
+	rec := serve(router, author, http.MethodPost, comments, `{"body": "looks good"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("author commenting: got %d, want 201: %s", rec.Code, rec.Body)
+	}
+	var comment models.InstanceComment
+	if err := json.Unmarshal(rec.Body.Bytes(), &comment); err != nil {
+		t.Fatalf("decode comment: %v", err)
+	}
+
+	for _, who := range []caller{teammate, outsider} {
+		if rec := serve(router, who, http.MethodGet, comments, ""); rec.Code != http.StatusNotFound || strings.Contains(rec.Body.String(), "looks good") {
+			t.Errorf("%s listing the comments: got %d, want 404: %s", who.userID, rec.Code, rec.Body)
+		}
+		if rec := serve(router, who, http.MethodPost, comments, `{"body": "hello"}`); rec.Code != http.StatusNotFound {
+			t.Errorf("%s commenting: got %d, want 404", who.userID, rec.Code)
+		}
+		if rec := serve(router, who, http.MethodDelete, comments+"/"+comment.ID.String(), ""); rec.Code != http.StatusNotFound {
+			t.Errorf("%s deleting a comment: got %d, want 404", who.userID, rec.Code)
+		}
+	}
+
+	var count int64
+	db.Model(&models.InstanceComment{}).Where("instance_id = ?", instance.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("instance has %d comments, want only the author's", count)
+	}
+	if rec := serve(router, coauthor, http.MethodGet, comments, ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "looks good") {
+		t.Errorf("coauthor listing the comments: got %d, want 200 with the comment: %s", rec.Code, rec.Body)
+	}
+}
+
+// statusFor is ok for a visible resource and 404 for one that is not
+func statusFor(visible bool, ok int) int {
+	if visible {
+		return ok
+	}
+	return http.StatusNotFound
+}
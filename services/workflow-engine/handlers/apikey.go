@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+	"chorus/workflow-engine/models"
+	"chorus/workflow-engine/utils"
+)
+
+type APIKeyHandler struct {
+	db          *gorm.DB
+	defaultRole string
+	logger      *logging.Logger
+}
+
+func NewAPIKeyHandler(db *gorm.DB, defaultRole string, logger *logging.Logger) *APIKeyHandler {
+	return &APIKeyHandler{
+		db:          db,
+		defaultRole: defaultRole,
+		logger:      logger,
+	}
+}
+
+// ListAPIKeys handles GET /api/v1/api-keys
+func (h *APIKeyHandler) ListAPIKeys(c *gin.Context) {
+	query := h.db.Order("created_at DESC")
+	if c.Query("include_revoked") != "true" {
+		query = query.Where("revoked_at IS NULL")
+	}
+
+	var keys []models.APIKey
+	if err := query.Find(&keys).Error; err != nil {
+		h.logger.Error("Failed to fetch API keys", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch API keys", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_keys": keys,
+	})
+}
+
+// CreateAPIKey handles POST /api/v1/api-keys
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" || strings.ContainsAny(name, " :") {
+		apierror.Abort(c, http.StatusBadRequest, "Key name must be non-empty and cannot contain spaces or colons", nil)
+		return
+	}
+
+	role := req.Role
+	if role == "" {
+		role = h.defaultRole
+	}
+
+	plaintext, prefix, hash, err := utils.GenerateAPIKey()
+	if err != nil {
+		h.logger.Error("Failed to generate API key", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to create API key", nil)
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	createdBy, _ := userID.(string)
+
+	key := models.APIKey{
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Role:      role,
+		CreatedBy: createdBy,
+	}
+
+	if err := h.db.Create(&key).Error; err != nil {
+		h.logger.Error("Failed to create API key", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to create API key", nil)
+		return
+	}
+
+	h.logger.Info("API key created", "key_id", key.ID, "name", key.Name, "role", key.Role, "created_by", createdBy)
+	c.JSON(http.StatusCreated, models.CreateAPIKeyResponse{
+		APIKey: key,
+		Key:    plaintext,
+	})
+}
+
+// RevokeAPIKey handles DELETE /api/v1/api-keys/:id
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	keyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid API key ID", nil)
+		return
+	}
+
+	var key models.APIKey
+	if err := h.db.First(&key, keyID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apierror.Abort(c, http.StatusNotFound, "API key not found", nil)
+			return
+		}
+		h.logger.Error("Failed to fetch API key", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch API key", nil)
+		return
+	}
+
+	if key.RevokedAt == nil {
+		now := time.Now()
+		key.RevokedAt = &now
+		if err := h.db.Model(&key).Update("revoked_at", now).Error; err != nil {
+			h.logger.Error("Failed to revoke API key", "error", err)
+			apierror.Abort(c, http.StatusInternalServerError, "Failed to revoke API key", nil)
+			return
+		}
+	}
+
+	userID, _ := c.Get("userID")
+	h.logger.Info("API key revoked", "key_id", key.ID, "name", key.Name, "revoked_by", userID)
+	c.JSON(http.StatusOK, key)
+}
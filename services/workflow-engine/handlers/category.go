@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+	"chorus/workflow-engine/models"
+)
+
+// Longest category name
+const maxCategoryNameLength = 255
+
+// categorySlugSQL is models.CategorySlug of a template's category in SQL, so the
+// templates filed before categories were managed, as "Billing " for instance, count
+// under the category they normalize to
+const categorySlugSQL = `TRIM(BOTH '-' FROM REGEXP_REPLACE(LOWER(category), '[^a-z0-9]+', '-', 'g'))`
+
+type CategoryHandler struct {
+	db     *gorm.DB
+	logger *logging.Logger
+}
+
+func NewCategoryHandler(db *gorm.DB, logger *logging.Logger) *CategoryHandler {
+	return &CategoryHandler{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// ListCategories handles GET /api/v1/categories, sorted by name, each with the
+// number of templates filed under it that the caller can see
+func (h *CategoryHandler) ListCategories(c *gin.Context) {
+	var categories []models.TemplateCategory
+	if err := h.db.Order("name ASC").Find(&categories).Error; err != nil {
+		h.logger.Error("Failed to fetch categories", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch categories", nil)
+		return
+	}
+
+	var counts []struct {
+		Slug  string
+		Count int64
+	}
+	if err := principalFrom(c).scopeTemplates(h.db.Model(&models.WorkflowTemplate{})).
+		Select(categorySlugSQL + " AS slug, COUNT(*) AS count").
+		Where("category <> ''").
+		Group(categorySlugSQL).
+		Scan(&counts).Error; err != nil {
+		h.logger.Error("Failed to count templates per category", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch categories", nil)
+		return
+	}
+	templateCounts := make(map[string]int64, len(counts))
+	for _, row := range counts {
+		templateCounts[row.Slug] = row.Count
+	}
+
+	responses := make([]models.TemplateCategoryResponse, len(categories))
+	for i, category := range categories {
+		responses[i] = models.TemplateCategoryResponse{
+			TemplateCategory: category,
+			TemplateCount:    templateCounts[category.Slug],
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"categories": responses,
+	})
+}
+
+// CreateCategory handles POST /api/v1/categories
+func (h *CategoryHandler) CreateCategory(c *gin.Context) {
+	var req models.CreateCategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+	name, slug, ok := categoryName(c, req.Name)
+	if !ok {
+		return
+	}
+
+	if taken, err := h.slugTaken(slug); err != nil {
+		h.logger.Error("Failed to check category slug", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to create category", nil)
+		return
+	} else if taken {
+		apierror.Abort(c, http.StatusConflict, fmt.Sprintf("Category %q already exists", slug), nil)
+		return
+	}
+
+	category := models.TemplateCategory{
+		Slug:      slug,
+		Name:      name,
+		CreatedBy: c.GetString("userID"),
+	}
+	if err := h.db.Create(&category).Error; err != nil {
+		h.logger.Error("Failed to create category", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to create category", nil)
+		return
+	}
+
+	h.logger.Info("Category created", "slug", category.Slug, "name", category.Name)
+	c.JSON(http.StatusCreated, category)
+}
+
+// RenameCategory handles POST /api/v1/categories/:slug/rename. The templates filed
+// under the category move to its new slug in the same transaction, with one UPDATE.
+func (h *CategoryHandler) RenameCategory(c *gin.Context) {
+	category, ok := h.loadCategory(c)
+	if !ok {
+		return
+	}
+
+	var req models.RenameCategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+	name, slug, ok := categoryName(c, req.Name)
+	if !ok {
+		return
+	}
+
+	if slug != category.Slug {
+		if taken, err := h.slugTaken(slug); err != nil {
+			h.logger.Error("Failed to check category slug", "error", err)
+			apierror.Abort(c, http.StatusInternalServerError, "Failed to rename category", nil)
+			return
+		} else if taken {
+			apierror.Abort(c, http.StatusConflict, fmt.Sprintf("Category %q already exists", slug), nil)
+			return
+		}
+	}
+
+	previous := category.Slug
+	var moved int64
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(category).Updates(map[string]interface{}{"slug": slug, "name": name}).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&models.WorkflowTemplate{}).
+			Where(categorySlugSQL+" = ?", previous).
+			Update("category", slug)
+		moved = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to rename category", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to rename category", nil)
+		return
+	}
+
+	category.Slug, category.Name = slug, name
+	h.logger.Info("Category renamed", "from", previous, "to", slug, "templates", moved, "by", c.GetString("userID"))
+	c.JSON(http.StatusOK, models.RenameCategoryResponse{
+		TemplateCategory: *category,
+		PreviousSlug:     previous,
+		TemplatesMoved:   moved,
+	})
+}
+
+// DeleteCategory handles DELETE /api/v1/categories/:slug. A category templates are
+// still filed under is not deleted.
+func (h *CategoryHandler) DeleteCategory(c *gin.Context) {
+	category, ok := h.loadCategory(c)
+	if !ok {
+		return
+	}
+
+	var inUse int64
+	if err := h.db.Model(&models.WorkflowTemplate{}).Where(categorySlugSQL+" = ?", category.Slug).Count(&inUse).Error; err != nil {
+		h.logger.Error("Failed to count templates of category", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to delete category", nil)
+		return
+	}
+	if inUse > 0 {
+		apierror.Abort(c, http.StatusConflict, "Category is used by templates", gin.H{
+			"template_count": inUse,
+		})
+		return
+	}
+
+	if err := h.db.Delete(category).Error; err != nil {
+		h.logger.Error("Failed to delete category", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to delete category", nil)
+		return
+	}
+
+	h.logger.Info("Category deleted", "slug", category.Slug, "by", c.GetString("userID"))
+	c.Status(http.StatusNoContent)
+}
+
+// loadCategory fetches the category of the request's :slug, which is normalized
+func (h *CategoryHandler) loadCategory(c *gin.Context) (*models.TemplateCategory, bool) {
+	var category models.TemplateCategory
+	if err := h.db.First(&category, "slug = ?", models.CategorySlug(c.Param("slug"))).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apierror.Abort(c, http.StatusNotFound, "Category not found", nil)
+			return nil, false
+		}
+		h.logger.Error("Failed to fetch category", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch category", nil)
+		return nil, false
+	}
+	return &category, true
+}
+
+func (h *CategoryHandler) slugTaken(slug string) (bool, error) {
+	var count int64
+	err := h.db.Model(&models.TemplateCategory{}).Where("slug = ?", slug).Count(&count).Error
+	return count > 0, err
+}
+
+// categoryName trims a category name from a request and returns it with its slug,
+// answering the request when either is empty or too long
+func categoryName(c *gin.Context, raw string) (string, string, bool) {
+	name := strings.TrimSpace(raw)
+	slug := models.CategorySlug(name)
+	switch {
+	case slug == "":
+		apierror.Abort(c, http.StatusBadRequest, "Category name must contain letters or digits", nil)
+		return "", "", false
+	case utf8.RuneCountInString(name) > maxCategoryNameLength || len(slug) > models.MaxCategorySlugLength:
+		apierror.Abort(c, http.StatusBadRequest, "Category name is too long", gin.H{
+			"max_length": maxCategoryNameLength,
+		})
+		return "", "", false
+	}
+	return name, slug, true
+}
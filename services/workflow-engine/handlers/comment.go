@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+	"chorus/workflow-engine/models"
+)
+
+// Maximum length of a comment body, in characters
+const maxCommentLength = 4000
+
+type CommentHandler struct {
+	db     *gorm.DB
+	logger *logging.Logger
+}
+
+func NewCommentHandler(db *gorm.DB, logger *logging.Logger) *CommentHandler {
+	return &CommentHandler{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// ListComments handles GET /api/v1/instances/:id/comments
+func (h *CommentHandler) ListComments(c *gin.Context) {
+	instanceID, ok := h.loadInstanceID(c)
+	if !ok {
+		return
+	}
+
+	var comments []models.InstanceComment
+	if err := h.db.Where("instance_id = ?", instanceID).Order("created_at ASC").Find(&comments).Error; err != nil {
+		h.logger.Error("Failed to fetch comments", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch comments", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"comments": comments,
+	})
+}
+
+// CreateComment handles POST /api/v1/instances/:id/comments
+func (h *CommentHandler) CreateComment(c *gin.Context) {
+	instanceID, ok := h.loadInstanceID(c)
+	if !ok {
+		return
+	}
+
+	var req models.CreateCommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	body := strings.TrimSpace(req.Body)
+	if body == "" {
+		apierror.Abort(c, http.StatusBadRequest, "Comment body cannot be empty", nil)
+		return
+	}
+	if utf8.RuneCountInString(body) > maxCommentLength {
+		apierror.Abort(c, http.StatusBadRequest, "Comment body is too long", gin.H{
+			"max_length": maxCommentLength,
+		})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	author, _ := userID.(string)
+	if author == "" {
+		apierror.Abort(c, http.StatusUnauthorized, "Comment author could not be determined", nil)
+		return
+	}
+
+	comment := models.InstanceComment{
+		InstanceID: instanceID,
+		Author:     author,
+		Body:       body,
+	}
+
+	if err := h.db.Create(&comment).Error; err != nil {
+		h.logger.Error("Failed to create comment", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to create comment", nil)
+		return
+	}
+
+	h.logger.Info("Comment added", "instance_id", instanceID, "comment_id", comment.ID, "author", author)
+	c.JSON(http.StatusCreated, comment)
+}
+
+// DeleteComment handles DELETE /api/v1/instances/:id/comments/:comment_id
+func (h *CommentHandler) DeleteComment(c *gin.Context) {
+	instanceID, ok := h.loadInstanceID(c)
+	if !ok {
+		return
+	}
+
+	commentID, err := uuid.Parse(c.Param("comment_id"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid comment ID", nil)
+		return
+	}
+
+	var comment models.InstanceComment
+	if err := h.db.Where("id = ? AND instance_id = ?", commentID, instanceID).First(&comment).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apierror.Abort(c, http.StatusNotFound, "Comment not found", nil)
+			return
+		}
+		h.logger.Error("Failed to fetch comment", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch comment", nil)
+		return
+	}
+
+	// Only the author or an admin may delete a comment
+	userID, _ := c.Get("userID")
+	role, _ := c.Get("role")
+	if userID != comment.Author && role != "admin" {
+		apierror.Abort(c, http.StatusForbidden, "Only the author or an admin can delete this comment", nil)
+		return
+	}
+
+	if err := h.db.Delete(&comment).Error; err != nil {
+		h.logger.Error("Failed to delete comment", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to delete comment", nil)
+		return
+	}
+
+	h.logger.Info("Comment deleted", "instance_id", instanceID, "comment_id", comment.ID, "deleted_by", userID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Comment deleted successfully",
+	})
+}
+
+// loadInstanceID parses the instance ID parameter and checks that the instance
+// exists and the caller can see it, answering 404 otherwise
+func (h *CommentHandler) loadInstanceID(c *gin.Context) (uuid.UUID, bool) {
+	instanceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid instance ID", nil)
+		return uuid.Nil, false
+	}
+	if !visibleInstance(c, h.db, h.logger, instanceID) {
+		return uuid.Nil, false
+	}
+	return instanceID, true
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+	"chorus/workflow-engine/services"
+)
+
+// Answered when a template opts into encryption without master keys configured
+const encryptionNotConfigured = "encrypt_variables requires ENCRYPTION_MASTER_KEYS to be configured"
+
+type EncryptionHandler struct {
+	engine *services.Engine
+	logger *logging.Logger
+}
+
+func NewEncryptionHandler(engine *services.Engine, logger *logging.Logger) *EncryptionHandler {
+	return &EncryptionHandler{
+		engine: engine,
+		logger: logger,
+	}
+}
+
+// StartReencryption handles POST /api/v1/admin/encryption/reencrypt. It starts
+// re-encrypting the data sealed under retired master keys with the active key, on
+// the replica serving the request, and answers 202 with the job's progress.
+func (h *EncryptionHandler) StartReencryption(c *gin.Context) {
+	status, err := h.engine.StartReencryption()
+	switch {
+	case errors.Is(err, services.ErrEncryptionNotConfigured):
+		apierror.Abort(c, http.StatusConflict, "Encryption is not configured", nil)
+		return
+	case errors.Is(err, services.ErrReencryptionRunning):
+		apierror.Abort(c, http.StatusConflict, "A re-encryption job is already running", status)
+		return
+	case err != nil:
+		h.logger.Error("Failed to start re-encryption", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to start re-encryption", nil)
+		return
+	}
+
+	h.logger.Info("Re-encryption requested", "key_id", status.KeyID, "by", c.GetString("userID"))
+	c.JSON(http.StatusAccepted, status)
+}
+
+// GetReencryption handles GET /api/v1/admin/encryption/reencrypt: the progress of
+// the latest re-encryption job of the replica serving the request, and the rows of
+// all replicas still to re-encrypt
+func (h *EncryptionHandler) GetReencryption(c *gin.Context) {
+	status, err := h.engine.ReencryptionStatus()
+	switch {
+	case errors.Is(err, services.ErrEncryptionNotConfigured):
+		apierror.Abort(c, http.StatusConflict, "Encryption is not configured", nil)
+		return
+	case err != nil:
+		h.logger.Error("Failed to read re-encryption status", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to read re-encryption status", nil)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+	"chorus/workflow-engine/models"
+	"chorus/workflow-engine/services"
+)
+
+type EngineHandler struct {
+	engine *services.Engine
+	logger *logging.Logger
+}
+
+func NewEngineHandler(engine *services.Engine, logger *logging.Logger) *EngineHandler {
+	return &EngineHandler{
+		engine: engine,
+		logger: logger,
+	}
+}
+
+// GetBacklog handles GET /api/v1/engine/backlog. It is meant for autoscalers, so
+// it stays cheap: no database queries, one Redis read.
+func (h *EngineHandler) GetBacklog(c *gin.Context) {
+	c.JSON(http.StatusOK, h.engine.Backlog())
+}
+
+// ReplayEvents handles POST /api/v1/engine/events/replay. It starts republishing
+// the kept events of a time range on workflow:events and answers 202 with the
+// replay, whose progress GetEventReplay reports; a dry run only counts them.
+func (h *EngineHandler) ReplayEvents(c *gin.Context) {
+	var req models.ReplayEventsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+	filter := models.EventReplayFilter{
+		From:        req.From,
+		To:          time.Now(),
+		EventTypes:  req.EventTypes,
+		TemplateIDs: req.TemplateIDs,
+	}
+	if req.To != nil {
+		filter.To = *req.To
+	}
+	if !filter.From.Before(filter.To) {
+		apierror.Abort(c, http.StatusBadRequest, "from must be before to", nil)
+		return
+	}
+
+	if req.DryRun {
+		matched, err := h.engine.CountReplayEvents(filter)
+		if errors.Is(err, services.ErrEventsNotKept) {
+			apierror.Abort(c, http.StatusConflict, "Events are not kept for replay", nil)
+			return
+		} else if err != nil {
+			h.logger.Error("Failed to count events to replay", "error", err)
+			apierror.Abort(c, http.StatusInternalServerError, "Failed to count events", nil)
+			return
+		}
+		c.JSON(http.StatusOK, models.ReplayEventsDryRunResponse{DryRun: true, Filter: filter, Matched: matched})
+		return
+	}
+
+	replay, err := h.engine.StartEventReplay(filter, c.GetString("userID"))
+	switch {
+	case errors.Is(err, services.ErrEventsNotKept):
+		apierror.Abort(c, http.StatusConflict, "Events are not kept for replay", nil)
+		return
+	case errors.Is(err, services.ErrEventReplayRunning):
+		apierror.Abort(c, http.StatusConflict, "An event replay is already running", nil)
+		return
+	case err != nil:
+		h.logger.Error("Failed to start event replay", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to start event replay", nil)
+		return
+	}
+
+	h.logger.Info("Event replay requested", "replay_id", replay.ID, "matched", replay.Matched, "by", c.GetString("userID"))
+	c.Header("Location", "/api/v1/engine/events/replays/"+replay.ID.String())
+	c.JSON(http.StatusAccepted, replay)
+}
+
+// GetEventReplay handles GET /api/v1/engine/events/replays/:id: a replay with its
+// progress, which any replica can answer
+func (h *EngineHandler) GetEventReplay(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid replay ID", nil)
+		return
+	}
+
+	replay, err := h.engine.EventReplay(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierror.Abort(c, http.StatusNotFound, "Event replay not found", nil)
+		return
+	} else if err != nil {
+		h.logger.Error("Failed to fetch event replay", "replay_id", id, "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch event replay", nil)
+		return
+	}
+	c.JSON(http.StatusOK, replay)
+}
@@ -1,54 +1,73 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
-	"strconv"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+	"chorus/pkg/tracing"
+	"chorus/workflow-engine/config"
+	"chorus/workflow-engine/jsonpath"
 	"chorus/workflow-engine/models"
 	"chorus/workflow-engine/services"
-	"chorus/workflow-engine/utils"
 )
 
 type InstanceHandler struct {
-	db     *gorm.DB
-	engine *services.Engine
-	logger *utils.Logger
+	db          *gorm.DB
+	engine      *services.Engine
+	instances   *services.InstanceService
+	viewerRoles []string // roles that may see every instance
+	pagination  config.PaginationConfig
+	logger      *logging.Logger
 }
 
-func NewInstanceHandler(db *gorm.DB, engine *services.Engine, logger *utils.Logger) *InstanceHandler {
+func NewInstanceHandler(db *gorm.DB, engine *services.Engine, instances *services.InstanceService, viewerRoles []string, pagination config.PaginationConfig, logger *logging.Logger) *InstanceHandler {
 	return &InstanceHandler{
-		db:     db,
-		engine: engine,
-		logger: logger,
+		db:          db,
+		engine:      engine,
+		instances:   instances,
+		viewerRoles: viewerRoles,
+		pagination:  pagination,
+		logger:      logger,
 	}
 }
 
-// ListInstances handles GET /api/v1/instances
+// ListInstances handles GET /api/v1/instances, listing the instances of templates
+// the caller can see. Encrypted instances are listed without their context and
+// variables, which are not decrypted, unless ?include_encrypted=true.
 func (h *InstanceHandler) ListInstances(c *gin.Context) {
 	// Parse query parameters
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	page, ok := parsePagination(c, h.pagination, 0)
+	if !ok {
+		return
+	}
 	status := c.Query("status")
 	templateID := c.Query("template_id")
-
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 20
-	}
+	includeTest := c.Query("include_test") == "true"
 
 	// Build query
-	query := h.db.Model(&models.WorkflowInstance{}).Preload("Template")
+	db := h.db
+	if c.Query("include_encrypted") != "true" {
+		db = models.WithoutDecryption(db)
+	}
+	query := db.Model(&models.WorkflowInstance{}).Preload("Template").
+		Where("template_id IN (?)", principalFrom(c).scopeTemplates(h.db.Model(&models.WorkflowTemplate{}).Select("id")))
 
 	if status != "" {
 		query = query.Where("status = ?", status)
 	}
+	if !includeTest {
+		query = query.Where("is_test = false")
+	}
 	if templateID != "" {
 		if tid, err := uuid.Parse(templateID); err == nil {
 			query = query.Where("template_id = ?", tid)
@@ -59,371 +78,446 @@ func (h *InstanceHandler) ListInstances(c *gin.Context) {
 	var total int64
 	if err := query.Count(&total).Error; err != nil {
 		h.logger.Error("Failed to count instances", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to count instances",
-		})
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to count instances", nil)
 		return
 	}
 
 	// Get instances with pagination
 	var instances []models.WorkflowInstance
-	offset := (page - 1) * pageSize
-	if err := query.Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&instances).Error; err != nil {
+	if err := page.apply(query, true).Find(&instances).Error; err != nil {
 		h.logger.Error("Failed to fetch instances", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch instances",
-		})
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch instances", nil)
 		return
 	}
 
-	totalPages := int((total + int64(pageSize) - 1) / int64(pageSize))
-
-	response := models.ListResponse[models.WorkflowInstance]{
-		Data:       instances,
-		Total:      total,
-		Page:       page,
-		PageSize:   pageSize,
-		TotalPages: totalPages,
-	}
-
-	c.JSON(http.StatusOK, response)
+	c.JSON(http.StatusOK, listResponse(page, instances, total, func(i *models.WorkflowInstance) pageCursor {
+		return pageCursor{CreatedAt: i.CreatedAt, ID: i.ID}
+	}))
 }
 
 // CreateInstance handles POST /api/v1/instances
 func (h *InstanceHandler) CreateInstance(c *gin.Context) {
 	var req models.CreateInstanceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request body",
-			"details": err.Error(),
-		})
+		apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
 		return
 	}
 
-	// Validate template exists and is active
-	var template models.WorkflowTemplate
-	if err := h.db.Where("id = ? AND is_active = true", req.TemplateID).First(&template).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "Template not found or inactive",
-			})
-			return
-		}
-		h.logger.Error("Failed to fetch template", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch template",
-		})
+	instance, err := h.instances.Create(c.Request.Context(), actorFrom(c), req)
+	if err != nil {
+		h.abortInstanceError(c, err, "Failed to create instance", nil)
 		return
 	}
+	c.JSON(http.StatusCreated, instance)
+}
 
-	// Get user ID from context
-	userID, _ := c.Get("userID")
-
-	instance := models.WorkflowInstance{
-		TemplateID: req.TemplateID,
-		Name:       req.Name,
-		Variables:  req.Variables,
-		Context:    req.Context,
-		Status:     models.WorkflowStatusPending,
-		CreatedBy:  userID.(string),
+// GetInstance handles GET /api/v1/instances/:id
+func (h *InstanceHandler) GetInstance(c *gin.Context) {
+	id := c.Param("id")
+	instanceID, err := uuid.Parse(id)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid instance ID", nil)
+		return
 	}
 
-	if instance.Variables == nil {
-		instance.Variables = make(models.JSONB)
-	}
-	if instance.Context == nil {
-		instance.Context = make(models.JSONB)
+	query := h.db.Preload("Template").Preload("Steps").Preload("Reruns", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "name", "status", "rerun_of", "created_at", "created_by").Order("created_at ASC")
+	})
+	if includes(c, "comments") {
+		query = query.Preload("Comments", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at ASC")
+		})
 	}
 
-	if err := h.db.Create(&instance).Error; err != nil {
-		h.logger.Error("Failed to create instance", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create instance",
-		})
+	var instance models.WorkflowInstance
+	if err := query.First(&instance, instanceID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apierror.Abort(c, http.StatusNotFound, "Instance not found", nil)
+			return
+		}
+		h.logger.Error("Failed to fetch instance", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch instance", nil)
+		return
+	}
+	if !principalFrom(c).canView(&instance.Template) {
+		apierror.Abort(c, http.StatusNotFound, "Instance not found", nil)
 		return
 	}
 
-	// Load the template for the response
-	instance.Template = template
-
-	h.logger.Info("Instance created", "id", instance.ID, "name", instance.Name, "template", template.Name)
-	c.JSON(http.StatusCreated, instance)
+	c.JSON(http.StatusOK, instance)
 }
 
-// GetInstance handles GET /api/v1/instances/:id
-func (h *InstanceHandler) GetInstance(c *gin.Context) {
-	id := c.Param("id")
-	instanceID, err := uuid.Parse(id)
+// GetInstanceStatus handles GET /api/v1/instances/:id/status, a light view of an
+// instance's progress and creator for callers polling or authorizing on it. Like the
+// instance itself, it is only shown to callers who can see its template, or who may
+// follow it as can-view tells, answering 404 to the others.
+func (h *InstanceHandler) GetInstanceStatus(c *gin.Context) {
+	instanceID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid instance ID",
-		})
+		apierror.Abort(c, http.StatusBadRequest, "Invalid instance ID", nil)
 		return
 	}
 
 	var instance models.WorkflowInstance
-	if err := h.db.Preload("Template").Preload("Steps").First(&instance, instanceID).Error; err != nil {
+	err = h.db.Select("id", "template_id", "name", "status", "current_step", "error_message", "created_by", "org_id", "started_at", "completed_at", "updated_at").
+		Preload("Template", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id", "visibility", "owners", "team", "created_by")
+		}).
+		First(&instance, instanceID).Error
+	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "Instance not found",
-			})
+			apierror.Abort(c, http.StatusNotFound, "Instance not found", nil)
 			return
 		}
 		h.logger.Error("Failed to fetch instance", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch instance",
-		})
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch instance", nil)
+		return
+	}
+	caller := principalFrom(c)
+	if allowed, _ := caller.canViewInstance(&instance, h.viewerRoles); !allowed && !caller.canView(&instance.Template) {
+		apierror.Abort(c, http.StatusNotFound, "Instance not found", nil)
 		return
 	}
 
-	c.JSON(http.StatusOK, instance)
+	c.JSON(http.StatusOK, models.InstanceStatusResponse{
+		ID:           instance.ID,
+		Name:         instance.Name,
+		Status:       instance.Status,
+		CurrentStep:  instance.CurrentStep,
+		ErrorMessage: instance.ErrorMessage,
+		CreatedBy:    instance.CreatedBy,
+		StartedAt:    instance.StartedAt,
+		CompletedAt:  instance.CompletedAt,
+		UpdatedAt:    instance.UpdatedAt,
+	})
 }
 
-// StartInstance handles PUT /api/v1/instances/:id/start
-func (h *InstanceHandler) StartInstance(c *gin.Context) {
-	id := c.Param("id")
-	instanceID, err := uuid.Parse(id)
+// CanViewInstance handles GET /api/v1/instances/:id/can-view, telling whether the
+// caller may see the instance and follow its events. It answers 200 either way, so
+// services such as the WebSocket gateway can ask with the token of their user and
+// keep the rules of who sees an instance here.
+func (h *InstanceHandler) CanViewInstance(c *gin.Context) {
+	instanceID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid instance ID",
-		})
+		apierror.Abort(c, http.StatusBadRequest, "Invalid instance ID", nil)
 		return
 	}
 
 	var instance models.WorkflowInstance
-	if err := h.db.First(&instance, instanceID).Error; err != nil {
+	if err := h.db.Select("id", "created_by", "org_id").First(&instance, instanceID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "Instance not found",
-			})
+			apierror.Abort(c, http.StatusNotFound, "Instance not found", nil)
 			return
 		}
 		h.logger.Error("Failed to fetch instance", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch instance",
-		})
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch instance", nil)
 		return
 	}
 
-	// Check if instance can be started
-	if instance.Status != models.WorkflowStatusPending && instance.Status != models.WorkflowStatusPaused {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Instance cannot be started in current status",
-			"current_status": instance.Status,
-		})
+	allowed, reason := principalFrom(c).canViewInstance(&instance, h.viewerRoles)
+	c.JSON(http.StatusOK, models.InstanceAccessResponse{
+		InstanceID: instance.ID,
+		Allowed:    allowed,
+		Reason:     reason,
+	})
+}
+
+// RerunInstance handles POST /api/v1/instances/:id/rerun
+func (h *InstanceHandler) RerunInstance(c *gin.Context) {
+	sourceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid instance ID", nil)
 		return
 	}
 
-	// Update instance status and started_at
-	now := time.Now()
-	instance.Status = models.WorkflowStatusRunning
-	instance.StartedAt = &now
+	// The body is optional
+	var req models.RerunInstanceRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+			return
+		}
+	}
 
-	if err := h.db.Save(&instance).Error; err != nil {
-		h.logger.Error("Failed to update instance", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to update instance",
-		})
+	instance, err := h.instances.Rerun(c.Request.Context(), actorFrom(c), sourceID, req)
+	if err != nil {
+		var details interface{}
+		if instance != nil {
+			details = gin.H{"instance_id": instance.ID}
+		}
+		h.abortInstanceError(c, err, "Failed to create instance", details)
 		return
 	}
 
-	// Queue instance for execution
-	if err := h.engine.QueueInstance(instanceID); err != nil {
-		h.logger.Error("Failed to queue instance", "error", err, "instance_id", instanceID)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to queue instance for execution",
-		})
+	c.JSON(http.StatusCreated, gin.H{
+		"source_instance_id": sourceID,
+		"instance_id":        instance.ID,
+		"instance":           instance,
+	})
+}
+
+// StartInstance handles PUT /api/v1/instances/:id/start
+func (h *InstanceHandler) StartInstance(c *gin.Context) {
+	h.transition(c, h.instances.Start)
+}
+
+// PauseInstance handles PUT /api/v1/instances/:id/pause
+func (h *InstanceHandler) PauseInstance(c *gin.Context) {
+	h.transition(c, h.instances.Pause)
+}
+
+// ResumeInstance handles PUT /api/v1/instances/:id/resume
+func (h *InstanceHandler) ResumeInstance(c *gin.Context) {
+	h.transition(c, h.instances.Resume)
+}
+
+// CancelInstance handles PUT /api/v1/instances/:id/cancel
+func (h *InstanceHandler) CancelInstance(c *gin.Context) {
+	h.transition(c, h.instances.Cancel)
+}
+
+// RetryInstance handles PUT /api/v1/instances/:id/retry
+func (h *InstanceHandler) RetryInstance(c *gin.Context) {
+	h.transition(c, h.instances.Retry)
+}
+
+// transition answers a request changing the status of the instance of its :id with
+// one of the InstanceService operations
+func (h *InstanceHandler) transition(c *gin.Context, operation func(context.Context, services.Actor, uuid.UUID) (*models.WorkflowInstance, error)) {
+	instanceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid instance ID", nil)
 		return
 	}
 
-	h.logger.Info("Instance started", "id", instance.ID, "name", instance.Name)
+	instance, err := operation(c.Request.Context(), actorFrom(c), instanceID)
+	if err != nil {
+		h.abortInstanceError(c, err, "Failed to update instance", nil)
+		return
+	}
 	c.JSON(http.StatusOK, instance)
 }
 
-// PauseInstance handles PUT /api/v1/instances/:id/pause
-func (h *InstanceHandler) PauseInstance(c *gin.Context) {
+// abortInstanceError answers a request whose InstanceService operation failed, with
+// failure for unexpected errors
+func (h *InstanceHandler) abortInstanceError(c *gin.Context, err error, failure string, details interface{}) {
+	var transitionErr *services.InstanceTransitionError
+	switch {
+	case errors.Is(err, services.ErrInstanceNotFound):
+		apierror.Abort(c, http.StatusNotFound, "Instance not found", nil)
+	case errors.Is(err, services.ErrTemplateNotFound):
+		apierror.Abort(c, http.StatusNotFound, "Template not found or inactive", nil)
+	case errors.Is(err, services.ErrTemplateInactive):
+		apierror.Abort(c, http.StatusConflict, "Template of the source instance is inactive", nil)
+	case errors.As(err, &transitionErr):
+		apierror.Abort(c, http.StatusBadRequest, "Instance cannot be "+transitionErr.Operation.PastTense()+" in current status", gin.H{
+			"current_status": transitionErr.Status,
+		})
+	case errors.Is(err, services.ErrInstanceNotQueued):
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to queue instance for execution", details)
+	default:
+		h.logger.Error(failure, "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, failure, details)
+	}
+}
+
+// actorFrom returns the caller of a request as the actor of instance operations
+func actorFrom(c *gin.Context) services.Actor {
+	p := principalFrom(c)
+	return services.Actor{
+		UserID:      p.userID,
+		OrgID:       p.org,
+		TraceParent: tracing.TraceParent(c.Request.Context()),
+		CanLaunch:   p.canView,
+	}
+}
+
+// GetInstanceSteps handles GET /api/v1/instances/:id/steps
+func (h *InstanceHandler) GetInstanceSteps(c *gin.Context) {
 	id := c.Param("id")
 	instanceID, err := uuid.Parse(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid instance ID",
-		})
+		apierror.Abort(c, http.StatusBadRequest, "Invalid instance ID", nil)
 		return
 	}
 
-	var instance models.WorkflowInstance
-	if err := h.db.First(&instance, instanceID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "Instance not found",
-			})
-			return
-		}
-		h.logger.Error("Failed to fetch instance", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch instance",
-		})
+	// Steps default to the largest page, which holds every step of most instances
+	page, ok := parsePagination(c, h.pagination, h.pagination.MaxPageSize)
+	if !ok {
 		return
 	}
-
-	// Check if instance can be paused
-	if instance.Status != models.WorkflowStatusRunning {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Instance cannot be paused in current status",
-			"current_status": instance.Status,
-		})
+	if !visibleInstance(c, h.db, h.logger, instanceID) {
 		return
 	}
 
-	// Update instance status
-	instance.Status = models.WorkflowStatusPaused
+	query := h.db.Model(&models.WorkflowStep{}).Where("instance_id = ?", instanceID)
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		h.logger.Error("Failed to count steps", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch steps", nil)
+		return
+	}
 
-	if err := h.db.Save(&instance).Error; err != nil {
-		h.logger.Error("Failed to update instance", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to update instance",
-		})
+	var steps []models.WorkflowStep
+	if err := page.apply(query, false).Find(&steps).Error; err != nil {
+		h.logger.Error("Failed to fetch steps", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch steps", nil)
 		return
 	}
 
-	h.logger.Info("Instance paused", "id", instance.ID, "name", instance.Name)
-	c.JSON(http.StatusOK, instance)
+	steps, next := pageOf(page, steps, func(s *models.WorkflowStep) pageCursor {
+		return pageCursor{CreatedAt: s.CreatedAt, ID: s.ID}
+	})
+	response := gin.H{
+		"steps":     steps,
+		"total":     total,
+		"page_size": page.PageSize,
+	}
+	if page.Page > 0 {
+		response["page"] = page.Page
+	}
+	if next != "" {
+		response["next_cursor"] = next
+	}
+	c.JSON(http.StatusOK, response)
 }
 
-// ResumeInstance handles PUT /api/v1/instances/:id/resume
-func (h *InstanceHandler) ResumeInstance(c *gin.Context) {
-	id := c.Param("id")
-	instanceID, err := uuid.Parse(id)
+// GetStepOutput handles GET /api/v1/instances/:id/steps/:step_id/output
+func (h *InstanceHandler) GetStepOutput(c *gin.Context) {
+	instanceID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid instance ID",
-		})
+		apierror.Abort(c, http.StatusBadRequest, "Invalid instance ID", nil)
+		return
+	}
+	if !visibleInstance(c, h.db, h.logger, instanceID) {
 		return
 	}
 
-	var instance models.WorkflowInstance
-	if err := h.db.First(&instance, instanceID).Error; err != nil {
+	var step models.WorkflowStep
+	if err := h.db.Where("instance_id = ? AND step_id = ?", instanceID, c.Param("step_id")).First(&step).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "Instance not found",
-			})
+			apierror.Abort(c, http.StatusNotFound, "Step not found", nil)
 			return
 		}
-		h.logger.Error("Failed to fetch instance", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch instance",
-		})
+		h.logger.Error("Failed to fetch step", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch step", nil)
 		return
 	}
 
-	// Check if instance can be resumed
-	if instance.Status != models.WorkflowStatusPaused {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Instance cannot be resumed in current status",
-			"current_status": instance.Status,
+	truncated, _ := step.OutputData["_truncated"].(bool)
+	pathExpr := c.Query("path")
+	if pathExpr != "" && !truncated {
+		if value, ok := respondPath(c, pathExpr, step.OutputData); ok {
+			c.JSON(http.StatusOK, gin.H{
+				"step_id": step.StepID,
+				"path":    pathExpr,
+				"result":  value,
+			})
+		}
+		return
+	}
+	if !truncated || (c.Query("full") != "true" && pathExpr == "") {
+		c.JSON(http.StatusOK, gin.H{
+			"step_id":   step.StepID,
+			"truncated": truncated,
+			"output":    step.OutputData,
 		})
 		return
 	}
 
-	// Update instance status
-	instance.Status = models.WorkflowStatusRunning
-
-	if err := h.db.Save(&instance).Error; err != nil {
-		h.logger.Error("Failed to update instance", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to update instance",
-		})
+	var payload models.StepPayload
+	if err := h.db.Where("step_id = ? AND kind = ?", step.ID, models.PayloadKindOutput).First(&payload).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apierror.Abort(c, http.StatusNotFound, "Full step output is no longer available", nil)
+			return
+		}
+		h.logger.Error("Failed to fetch step payload", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch step output", nil)
 		return
 	}
 
-	// Queue instance for execution
-	if err := h.engine.QueueInstance(instanceID); err != nil {
-		h.logger.Error("Failed to queue instance", "error", err, "instance_id", instanceID)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to queue instance for execution",
-		})
+	// Paths always apply to the full output, not the truncated preview
+	if pathExpr != "" {
+		if value, ok := respondPath(c, pathExpr, payload.Data); ok {
+			c.JSON(http.StatusOK, gin.H{
+				"step_id": step.StepID,
+				"path":    pathExpr,
+				"result":  value,
+			})
+		}
 		return
 	}
 
-	h.logger.Info("Instance resumed", "id", instance.ID, "name", instance.Name)
-	c.JSON(http.StatusOK, instance)
+	c.JSON(http.StatusOK, gin.H{
+		"step_id":    step.StepID,
+		"truncated":  false,
+		"size_bytes": payload.SizeBytes,
+		"output":     payload.Data,
+	})
 }
 
-// CancelInstance handles PUT /api/v1/instances/:id/cancel
-func (h *InstanceHandler) CancelInstance(c *gin.Context) {
-	id := c.Param("id")
-	instanceID, err := uuid.Parse(id)
+// GetInstanceVariables handles GET /api/v1/instances/:id/variables
+func (h *InstanceHandler) GetInstanceVariables(c *gin.Context) {
+	instanceID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid instance ID",
-		})
+		apierror.Abort(c, http.StatusBadRequest, "Invalid instance ID", nil)
 		return
 	}
 
 	var instance models.WorkflowInstance
-	if err := h.db.First(&instance, instanceID).Error; err != nil {
+	if err := h.db.Preload("Template").Select("id", "template_id", "variables").First(&instance, instanceID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "Instance not found",
-			})
+			apierror.Abort(c, http.StatusNotFound, "Instance not found", nil)
 			return
 		}
 		h.logger.Error("Failed to fetch instance", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch instance",
-		})
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch instance", nil)
 		return
 	}
-
-	// Check if instance can be cancelled
-	if instance.Status == models.WorkflowStatusCompleted || instance.Status == models.WorkflowStatusFailed || instance.Status == models.WorkflowStatusCancelled {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Instance cannot be cancelled in current status",
-			"current_status": instance.Status,
-		})
+	if !principalFrom(c).canView(&instance.Template) {
+		apierror.Abort(c, http.StatusNotFound, "Instance not found", nil)
 		return
 	}
 
-	// Update instance status
-	now := time.Now()
-	instance.Status = models.WorkflowStatusCancelled
-	instance.CompletedAt = &now
-
-	if err := h.db.Save(&instance).Error; err != nil {
-		h.logger.Error("Failed to update instance", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to update instance",
+	pathExpr := c.Query("path")
+	if pathExpr == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"instance_id": instance.ID,
+			"variables":   instance.Variables,
 		})
 		return
 	}
 
-	h.logger.Info("Instance cancelled", "id", instance.ID, "name", instance.Name)
-	c.JSON(http.StatusOK, instance)
+	if value, ok := respondPath(c, pathExpr, instance.Variables); ok {
+		c.JSON(http.StatusOK, gin.H{
+			"instance_id": instance.ID,
+			"path":        pathExpr,
+			"result":      value,
+		})
+	}
 }
 
-// GetInstanceSteps handles GET /api/v1/instances/:id/steps
-func (h *InstanceHandler) GetInstanceSteps(c *gin.Context) {
-	id := c.Param("id")
-	instanceID, err := uuid.Parse(id)
+// respondPath applies a JSONPath to data. On an invalid path or no match it writes
+// the error response and returns false. A path with wildcards yields a list.
+func respondPath(c *gin.Context, expr string, data models.JSONB) (interface{}, bool) {
+	path, err := jsonpath.Compile(expr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid instance ID",
-		})
-		return
+		apierror.Abort(c, http.StatusBadRequest, "Invalid path", err.Error())
+		return nil, false
 	}
 
-	var steps []models.WorkflowStep
-	if err := h.db.Where("instance_id = ?", instanceID).Order("created_at ASC").Find(&steps).Error; err != nil {
-		h.logger.Error("Failed to fetch steps", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch steps",
+	matches := path.Select(map[string]interface{}(data))
+	if len(matches) == 0 {
+		apierror.Abort(c, http.StatusNotFound, "Path matched nothing", gin.H{
+			"path": expr,
 		})
-		return
+		return nil, false
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"steps": steps,
-	})
+	if path.Definite() {
+		return matches[0], true
+	}
+	return matches, true
 }
 
 // TriggerWebhook handles POST /api/v1/triggers/webhook/:template_id
@@ -431,18 +525,13 @@ func (h *InstanceHandler) TriggerWebhook(c *gin.Context) {
 	templateIDStr := c.Param("template_id")
 	templateID, err := uuid.Parse(templateIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid template ID",
-		})
+		apierror.Abort(c, http.StatusBadRequest, "Invalid template ID", nil)
 		return
 	}
 
 	var req models.TriggerWebhookRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request body",
-			"details": err.Error(),
-		})
+		apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
 		return
 	}
 
@@ -450,15 +539,15 @@ func (h *InstanceHandler) TriggerWebhook(c *gin.Context) {
 	var template models.WorkflowTemplate
 	if err := h.db.Where("id = ? AND is_active = true", templateID).First(&template).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "Template not found or inactive",
-			})
+			apierror.Abort(c, http.StatusNotFound, "Template not found or inactive", nil)
 			return
 		}
 		h.logger.Error("Failed to fetch template", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch template",
-		})
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch template", nil)
+		return
+	}
+	if !principalFrom(c).canView(&template) {
+		apierror.Abort(c, http.StatusNotFound, "Template not found or inactive", nil)
 		return
 	}
 
@@ -466,26 +555,77 @@ func (h *InstanceHandler) TriggerWebhook(c *gin.Context) {
 	var trigger models.WorkflowTrigger
 	if err := h.db.Where("template_id = ? AND trigger_type = 'webhook' AND is_active = true", templateID).First(&trigger).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "No active webhook trigger found for template",
-			})
+			apierror.Abort(c, http.StatusNotFound, "No active webhook trigger found for template", nil)
 			return
 		}
 		h.logger.Error("Failed to fetch trigger", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch trigger",
-		})
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch trigger", nil)
 		return
 	}
 
+	h.startWebhookInstance(c, &template, &trigger, &req)
+}
+
+// TriggerWebhookBySlug handles POST /api/v1/triggers/webhook/by-slug/:slug. A slug
+// renamed within its grace period answers with a redirect to the current one.
+func (h *InstanceHandler) TriggerWebhookBySlug(c *gin.Context) {
+	slug := c.Param("slug")
+
+	var req models.TriggerWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	var trigger models.WorkflowTrigger
+	err := h.db.Preload("Template").
+		Where("trigger_type = 'webhook' AND trigger_config->>'slug' = ?", slug).
+		First(&trigger).Error
+	if err == gorm.ErrRecordNotFound {
+		var redirect models.WebhookSlugRedirect
+		if err := h.db.Where("slug = ? AND expires_at > ?", slug, time.Now()).First(&redirect).Error; err == nil {
+			var current models.WorkflowTrigger
+			if err := h.db.First(&current, redirect.TriggerID).Error; err == nil {
+				var cfg models.WebhookTriggerConfig
+				if decodeJSONB(current.TriggerConfig, &cfg) == nil && cfg.Slug != "" {
+					c.Redirect(http.StatusPermanentRedirect, "/api/v1/triggers/webhook/by-slug/"+url.PathEscape(cfg.Slug))
+					return
+				}
+			}
+		}
+	}
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apierror.Abort(c, http.StatusNotFound, "Webhook not found", nil)
+			return
+		}
+		h.logger.Error("Failed to fetch trigger", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch trigger", nil)
+		return
+	}
+
+	// Unknown, inactive and invisible webhooks all look the same to the caller
+	if !trigger.IsActive || !trigger.Template.IsActive || !principalFrom(c).canView(&trigger.Template) {
+		apierror.Abort(c, http.StatusNotFound, "Webhook not found", nil)
+		return
+	}
+
+	h.startWebhookInstance(c, &trigger.Template, &trigger, &req)
+}
+
+// startWebhookInstance creates and starts an instance for a webhook call
+func (h *InstanceHandler) startWebhookInstance(c *gin.Context, template *models.WorkflowTemplate, trigger *models.WorkflowTrigger, req *models.TriggerWebhookRequest) {
 	// Create workflow instance
 	instance := models.WorkflowInstance{
-		TemplateID: templateID,
-		Name:       template.Name + " (Webhook Triggered)",
-		Variables:  req.Variables,
-		Context:    req.Context,
-		Status:     models.WorkflowStatusPending,
-		CreatedBy:  "webhook",
+		TemplateID:  template.ID,
+		Name:        template.Name + " (Webhook Triggered)",
+		Variables:   req.Variables,
+		Context:     req.Context,
+		Status:      models.WorkflowStatusPending,
+		CreatedBy:   "webhook",
+		IsTest:      req.IsTest,
+		TraceParent: tracing.TraceParent(c.Request.Context()),
+		Encrypted:   template.EncryptVariables,
 	}
 
 	if instance.Variables == nil {
@@ -497,16 +637,14 @@ func (h *InstanceHandler) TriggerWebhook(c *gin.Context) {
 
 	if err := h.db.Create(&instance).Error; err != nil {
 		h.logger.Error("Failed to create instance", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create instance",
-		})
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to create instance", nil)
 		return
 	}
 
 	// Update trigger last triggered time
 	now := time.Now()
 	trigger.LastTriggeredAt = &now
-	h.db.Save(&trigger)
+	h.db.Save(trigger)
 
 	// Auto-start the instance
 	instance.Status = models.WorkflowStatusRunning
@@ -525,4 +663,14 @@ func (h *InstanceHandler) TriggerWebhook(c *gin.Context) {
 		"instance_id": instance.ID,
 		"message":     "Workflow instance created and started",
 	})
-}
\ No newline at end of file
+}
+
+// includes reports whether the comma-separated include query parameter lists the given relation
+func includes(c *gin.Context, relation string) bool {
+	for _, value := range strings.Split(c.Query("include"), ",") {
+		if strings.TrimSpace(value) == relation {
+			return true
+		}
+	}
+	return false
+}
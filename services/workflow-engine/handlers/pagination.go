@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"chorus/pkg/apierror"
+	"chorus/workflow-engine/config"
+	"chorus/workflow-engine/models"
+)
+
+// pagination is the page a list request asks for: ?page= and ?page_size=, or
+// ?cursor= and ?page_size= to continue after the last item of a previous page.
+// Cursors stay stable while items are added, where offsets shift.
+type pagination struct {
+	Page     int // 0 in cursor mode
+	PageSize int
+	cursor   *pageCursor
+}
+
+// pageCursor is the position after an item, encoded as an opaque token
+type pageCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+}
+
+func (c pageCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.ID == uuid.Nil || cursor.CreatedAt.IsZero() {
+		return nil, fmt.Errorf("incomplete cursor")
+	}
+	return &cursor, nil
+}
+
+// parsePagination reads the pagination of a request, with page_size defaulting to
+// defaultSize (cfg.DefaultPageSize when 0) and capped by cfg.MaxPageSize. Invalid
+// values are answered with 400 rather than replaced.
+func parsePagination(c *gin.Context, cfg config.PaginationConfig, defaultSize int) (pagination, bool) {
+	if defaultSize == 0 {
+		defaultSize = cfg.DefaultPageSize
+	}
+	p := pagination{Page: 1, PageSize: min(defaultSize, cfg.MaxPageSize)}
+
+	if value := c.Query("page_size"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 || size > cfg.MaxPageSize {
+			apierror.Abort(c, http.StatusBadRequest, fmt.Sprintf("page_size must be an integer between 1 and %d", cfg.MaxPageSize), gin.H{
+				"max_page_size": cfg.MaxPageSize,
+			})
+			return p, false
+		}
+		p.PageSize = size
+	}
+
+	page, cursor := c.Query("page"), c.Query("cursor")
+	switch {
+	case page != "" && cursor != "":
+		apierror.Abort(c, http.StatusBadRequest, "page and cursor cannot be combined", nil)
+		return p, false
+	case cursor != "":
+		decoded, err := decodeCursor(cursor)
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, "Invalid cursor", nil)
+			return p, false
+		}
+		p.Page, p.cursor = 0, decoded
+	case page != "":
+		number, err := strconv.Atoi(page)
+		if err != nil || number < 1 {
+			apierror.Abort(c, http.StatusBadRequest, "page must be a positive integer", nil)
+			return p, false
+		}
+		p.Page = number
+	}
+	return p, true
+}
+
+// apply orders query by created_at, newest first when descending, with the ID
+// breaking ties, and restricts it to the page. A cursor page fetches one item more,
+// which pageOf drops, to tell whether another page follows.
+func (p pagination) apply(query *gorm.DB, descending bool) *gorm.DB {
+	direction, compare := "ASC", ">"
+	if descending {
+		direction, compare = "DESC", "<"
+	}
+	query = query.Order("created_at " + direction).Order("id " + direction)
+	if p.cursor == nil {
+		return query.Offset((p.Page - 1) * p.PageSize).Limit(p.PageSize)
+	}
+	return query.Where("(created_at, id) "+compare+" (?, ?)", p.cursor.CreatedAt, p.cursor.ID).Limit(p.PageSize + 1)
+}
+
+// pageOf trims the items fetched by apply to the page and returns the cursor of the
+// page after it: in cursor mode, when there is one
+func pageOf[T any](p pagination, items []T, position func(*T) pageCursor) ([]T, string) {
+	if p.cursor == nil || len(items) <= p.PageSize {
+		return items, ""
+	}
+	items = items[:p.PageSize]
+	return items, position(&items[len(items)-1]).encode()
+}
+
+// listResponse pages items into the list response shape, echoing the pagination
+// applied
+func listResponse[T any](p pagination, items []T, total int64, position func(*T) pageCursor) models.ListResponse[T] {
+	items, next := pageOf(p, items, position)
+	return models.ListResponse[T]{
+		Data:       items,
+		Total:      total,
+		Page:       p.Page,
+		PageSize:   p.PageSize,
+		TotalPages: int((total + int64(p.PageSize) - 1) / int64(p.PageSize)),
+		NextCursor: next,
+	}
+}
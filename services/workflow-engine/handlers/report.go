@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"chorus/pkg/apierror"
+	"chorus/workflow-engine/models"
+)
+
+// Bytes of a step's input or output kept in a report
+const maxReportFieldSize = 1024
+
+// Columns of the step table in CSV reports
+var reportStepColumns = []string{
+	"sequence", "step_id", "name", "type", "status", "started_at", "completed_at",
+	"duration_seconds", "attempts", "input", "output", "error",
+}
+
+// GetInstanceReport handles GET /api/v1/instances/:id/report. Steps are read and
+// written one at a time, so large instances are streamed.
+func (h *InstanceHandler) GetInstanceReport(c *gin.Context) {
+	instanceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid instance ID", nil)
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		apierror.Abort(c, http.StatusBadRequest, "format must be csv or json", nil)
+		return
+	}
+
+	var instance models.WorkflowInstance
+	if err := h.db.Preload("Template").First(&instance, instanceID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apierror.Abort(c, http.StatusNotFound, "Instance not found", nil)
+			return
+		}
+		h.logger.Error("Failed to fetch instance", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch instance", nil)
+		return
+	}
+	if !principalFrom(c).canView(&instance.Template) {
+		apierror.Abort(c, http.StatusNotFound, "Instance not found", nil)
+		return
+	}
+
+	rows, err := h.db.Model(&models.WorkflowStep{}).
+		Where("instance_id = ?", instanceID).
+		Order("created_at ASC, id ASC").
+		Rows()
+	if err != nil {
+		h.logger.Error("Failed to fetch steps", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch steps", nil)
+		return
+	}
+	defer rows.Close()
+
+	stepNames := make(map[string]string)
+	for _, stepDef := range parseWorkflowSchema(instance.Template.Schema).Steps {
+		stepNames[stepDef.ID] = stepDef.Name
+	}
+
+	header := models.ReportInstance{
+		ReportVersion:   models.InstanceReportVersion,
+		GeneratedAt:     time.Now().UTC(),
+		InstanceID:      instance.ID,
+		Name:            instance.Name,
+		TemplateID:      instance.TemplateID,
+		TemplateName:    instance.Template.Name,
+		TemplateVersion: instance.Template.Version,
+		Status:          string(instance.Status),
+		CreatedBy:       instance.CreatedBy,
+		StartedAt:       instance.StartedAt,
+		CompletedAt:     instance.CompletedAt,
+		DurationSeconds: durationSeconds(instance.StartedAt, instance.CompletedAt),
+		ErrorMessage:    instance.ErrorMessage,
+		Variables:       instance.Variables,
+	}
+
+	// nextStep yields the report rows in execution order
+	sequence := 0
+	nextStep := func() (*models.ReportStep, error) {
+		if !rows.Next() {
+			return nil, rows.Err()
+		}
+		var step models.WorkflowStep
+		if err := h.db.ScanRows(rows, &step); err != nil {
+			return nil, err
+		}
+		sequence++
+		return reportStep(sequence, &step, stepNames[step.StepID]), nil
+	}
+
+	filename := fmt.Sprintf("instance-%s-report.%s", instance.ID, format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		err = writeCSVReport(c, &header, nextStep)
+	} else {
+		c.Header("Content-Type", "application/json; charset=utf-8")
+		err = writeJSONReport(c, &header, nextStep)
+	}
+	if err != nil {
+		// The status line is already sent; the client sees a cut-off report
+		h.logger.Error("Failed to write instance report", "instance_id", instance.ID, "error", err)
+	}
+}
+
+// writeJSONReport writes {"instance": ..., "steps": [...]} one step at a time
+func writeJSONReport(c *gin.Context, header *models.ReportInstance, nextStep func() (*models.ReportStep, error)) error {
+	c.Status(http.StatusOK)
+	w := c.Writer
+
+	instance, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, `{"instance":%s,"steps":[`, instance); err != nil {
+		return err
+	}
+
+	for first := true; ; first = false {
+		step, err := nextStep()
+		if err != nil {
+			return err
+		}
+		if step == nil {
+			break
+		}
+		data, err := json.Marshal(step)
+		if err != nil {
+			return err
+		}
+		if !first {
+			w.WriteString(",")
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		w.Flush()
+	}
+
+	_, err = w.WriteString("]}\n")
+	return err
+}
+
+// writeCSVReport writes the instance as field,value rows, a blank line, and then
+// one row per step under a header row
+func writeCSVReport(c *gin.Context, header *models.ReportInstance, nextStep func() (*models.ReportStep, error)) error {
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+
+	variables, _ := json.Marshal(header.Variables)
+	records := [][]string{
+		{"field", "value"},
+		{"report_version", strconv.Itoa(header.ReportVersion)},
+		{"generated_at", header.GeneratedAt.Format(time.RFC3339)},
+		{"instance_id", header.InstanceID.String()},
+		{"name", header.Name},
+		{"template_id", header.TemplateID.String()},
+		{"template_name", header.TemplateName},
+		{"template_version", header.TemplateVersion},
+		{"status", header.Status},
+		{"created_by", header.CreatedBy},
+		{"started_at", formatReportTime(header.StartedAt)},
+		{"completed_at", formatReportTime(header.CompletedAt)},
+		{"duration_seconds", formatReportDuration(header.DurationSeconds)},
+		{"error_message", header.ErrorMessage},
+		{"variables", string(variables)},
+		{},
+		reportStepColumns,
+	}
+	if err := w.WriteAll(records); err != nil {
+		return err
+	}
+
+	for {
+		step, err := nextStep()
+		if err != nil {
+			return err
+		}
+		if step == nil {
+			break
+		}
+		w.Write([]string{
+			strconv.Itoa(step.Sequence),
+			step.StepID,
+			step.Name,
+			step.Type,
+			step.Status,
+			formatReportTime(step.StartedAt),
+			formatReportTime(step.CompletedAt),
+			formatReportDuration(step.DurationSeconds),
+			strconv.Itoa(step.Attempts),
+			step.Input,
+			step.Output,
+			step.Error,
+		})
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+	}
+
+	return nil
+}
+
+func reportStep(sequence int, step *models.WorkflowStep, name string) *models.ReportStep {
+	attempts := len(step.Attempts)
+	if attempts == 0 && step.StartedAt != nil {
+		attempts = step.RetryCount + 1
+	}
+
+	var errorMessage string
+	if step.ErrorData != nil {
+		if msg, ok := step.ErrorData["error"].(string); ok {
+			errorMessage = msg
+		} else {
+			errorMessage = compactJSON(step.ErrorData)
+		}
+	}
+
+	return &models.ReportStep{
+		Sequence:        sequence,
+		StepID:          step.StepID,
+		Name:            name,
+		Type:            string(step.StepType),
+		Status:          string(step.Status),
+		StartedAt:       step.StartedAt,
+		CompletedAt:     step.CompletedAt,
+		DurationSeconds: durationSeconds(step.StartedAt, step.CompletedAt),
+		Attempts:        attempts,
+		Input:           compactJSON(step.InputData),
+		Output:          compactJSON(step.OutputData),
+		Error:           errorMessage,
+	}
+}
+
+// compactJSON encodes data and cuts it to maxReportFieldSize bytes
+func compactJSON(data models.JSONB) string {
+	if len(data) == 0 {
+		return ""
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return ""
+	}
+	if len(encoded) <= maxReportFieldSize {
+		return string(encoded)
+	}
+
+	cut := maxReportFieldSize
+	for cut > 0 && !utf8.RuneStart(encoded[cut]) {
+		cut--
+	}
+	return string(encoded[:cut]) + "...(truncated)"
+}
+
+func durationSeconds(start, end *time.Time) *float64 {
+	if start == nil || end == nil {
+		return nil
+	}
+	seconds := end.Sub(*start).Seconds()
+	return &seconds
+}
+
+func formatReportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func formatReportDuration(seconds *float64) string {
+	if seconds == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*seconds, 'f', 3, 64)
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+	"chorus/workflow-engine/models"
+)
+
+type SnippetHandler struct {
+	db     *gorm.DB
+	logger *logging.Logger
+}
+
+func NewSnippetHandler(db *gorm.DB, logger *logging.Logger) *SnippetHandler {
+	return &SnippetHandler{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// ListSnippets handles GET /api/v1/snippets
+func (h *SnippetHandler) ListSnippets(c *gin.Context) {
+	query := h.db.Order("name, created_at DESC")
+	if name := c.Query("name"); name != "" {
+		query = query.Where("name = ?", name)
+	}
+
+	var snippets []models.WorkflowSnippet
+	if err := query.Find(&snippets).Error; err != nil {
+		h.logger.Error("Failed to fetch snippets", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch snippets", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"snippets": snippets,
+	})
+}
+
+// CreateSnippet handles POST /api/v1/snippets
+func (h *SnippetHandler) CreateSnippet(c *gin.Context) {
+	var req models.CreateSnippetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	snippet := models.WorkflowSnippet{
+		Name:        req.Name,
+		Version:     req.Version,
+		Description: req.Description,
+		Parameters:  req.Parameters,
+		Steps:       req.Steps,
+		CreatedBy:   c.GetString("userID"),
+	}
+	if snippet.Version == "" {
+		snippet.Version = "1.0.0"
+	}
+
+	if err := h.validateSnippet(&snippet); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid snippet", err.Error())
+		return
+	}
+
+	var count int64
+	if err := h.db.Model(&models.WorkflowSnippet{}).Where("name = ? AND version = ?", snippet.Name, snippet.Version).Count(&count).Error; err != nil {
+		h.logger.Error("Failed to check snippet version", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to create snippet", nil)
+		return
+	}
+	if count > 0 {
+		apierror.Abort(c, http.StatusConflict, fmt.Sprintf("Snippet %s@%s already exists", snippet.Name, snippet.Version), nil)
+		return
+	}
+
+	if err := h.db.Create(&snippet).Error; err != nil {
+		h.logger.Error("Failed to create snippet", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to create snippet", nil)
+		return
+	}
+
+	h.logger.Info("Snippet created", "id", snippet.ID, "name", snippet.Name, "version", snippet.Version)
+	c.JSON(http.StatusCreated, snippet)
+}
+
+// GetSnippet handles GET /api/v1/snippets/:id
+func (h *SnippetHandler) GetSnippet(c *gin.Context) {
+	snippet, ok := h.loadSnippet(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, snippet)
+}
+
+// UpdateSnippet handles PUT /api/v1/snippets/:id. Templates that already use the
+// snippet keep their expanded steps until they are saved again.
+func (h *SnippetHandler) UpdateSnippet(c *gin.Context) {
+	snippet, ok := h.loadSnippet(c)
+	if !ok {
+		return
+	}
+	if !h.canModify(c, snippet) {
+		apierror.Abort(c, http.StatusForbidden, "You do not have permission to modify this snippet", nil)
+		return
+	}
+
+	var req models.UpdateSnippetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	if req.Description != nil {
+		snippet.Description = *req.Description
+	}
+	if req.Parameters != nil {
+		snippet.Parameters = *req.Parameters
+	}
+	if req.Steps != nil {
+		snippet.Steps = *req.Steps
+	}
+
+	if err := h.validateSnippet(snippet); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid snippet", err.Error())
+		return
+	}
+
+	if err := h.db.Save(snippet).Error; err != nil {
+		h.logger.Error("Failed to update snippet", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to update snippet", nil)
+		return
+	}
+
+	h.logger.Info("Snippet updated", "id", snippet.ID, "name", snippet.Name, "version", snippet.Version)
+	c.JSON(http.StatusOK, snippet)
+}
+
+// DeleteSnippet handles DELETE /api/v1/snippets/:id. Templates that used the
+// snippet are unaffected since its steps were copied into them.
+func (h *SnippetHandler) DeleteSnippet(c *gin.Context) {
+	snippet, ok := h.loadSnippet(c)
+	if !ok {
+		return
+	}
+	if !h.canModify(c, snippet) {
+		apierror.Abort(c, http.StatusForbidden, "You do not have permission to delete this snippet", nil)
+		return
+	}
+
+	if err := h.db.Delete(snippet).Error; err != nil {
+		h.logger.Error("Failed to delete snippet", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to delete snippet", nil)
+		return
+	}
+
+	h.logger.Info("Snippet deleted", "id", snippet.ID, "name", snippet.Name, "version", snippet.Version)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Snippet deleted successfully",
+	})
+}
+
+func (h *SnippetHandler) loadSnippet(c *gin.Context) (*models.WorkflowSnippet, bool) {
+	snippetID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid snippet ID", nil)
+		return nil, false
+	}
+
+	var snippet models.WorkflowSnippet
+	if err := h.db.First(&snippet, snippetID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apierror.Abort(c, http.StatusNotFound, "Snippet not found", nil)
+			return nil, false
+		}
+		h.logger.Error("Failed to fetch snippet", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch snippet", nil)
+		return nil, false
+	}
+
+	return &snippet, true
+}
+
+// canModify reports whether the caller created the snippet or is an admin
+func (h *SnippetHandler) canModify(c *gin.Context, snippet *models.WorkflowSnippet) bool {
+	caller := principalFrom(c)
+	return caller.admin || (caller.userID != "" && caller.userID == snippet.CreatedBy)
+}
+
+// validateSnippet checks the parameters and steps of a snippet, and that the
+// snippets it references resolve without cycles
+func (h *SnippetHandler) validateSnippet(snippet *models.WorkflowSnippet) error {
+	if len(snippet.Steps) == 0 {
+		return fmt.Errorf("steps must not be empty")
+	}
+
+	declared := make(map[string]bool, len(snippet.Parameters))
+	for _, param := range snippet.Parameters {
+		if param.Name == "" {
+			return fmt.Errorf("parameters must have a name")
+		}
+		if declared[param.Name] {
+			return fmt.Errorf("parameter %q is declared twice", param.Name)
+		}
+		declared[param.Name] = true
+	}
+
+	ids := make(map[string]bool, len(snippet.Steps))
+	for _, step := range snippet.Steps {
+		id, _ := step["id"].(string)
+		if id == "" {
+			return fmt.Errorf("every step needs an id")
+		}
+		if _, ok := step["type"].(string); !ok {
+			return fmt.Errorf("step %q needs a type", id)
+		}
+		if ids[id] {
+			return fmt.Errorf("step id %q is used more than once", id)
+		}
+		ids[id] = true
+		if err := validateStepAssertions(step); err != nil {
+			return fmt.Errorf("step %s: %w", id, err)
+		}
+	}
+
+	// Expand the snippet as a template would, with every parameter bound, so
+	// unknown placeholders, missing snippets and cycles surface now
+	with := make(map[string]interface{}, len(snippet.Parameters))
+	for _, param := range snippet.Parameters {
+		with[param.Name] = param.Default
+		if with[param.Name] == nil {
+			with[param.Name] = ""
+		}
+	}
+	expander := newSnippetExpander(h.db)
+	expander.snippets[snippet.Name+"@"+snippet.Version] = snippet
+	_, err := expander.expandReference(models.SnippetReference{
+		ID:      "validate",
+		Snippet: snippet.Name,
+		Version: snippet.Version,
+		With:    with,
+	}, nil, "")
+	return err
+}
@@ -0,0 +1,372 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"chorus/workflow-engine/models"
+)
+
+// How deeply snippets may reference other snippets
+const maxSnippetDepth = 8
+
+// Matches {{ params.name }} in snippet step configs
+var snippetParamPattern = regexp.MustCompile(`\{\{\s*params\.([A-Za-z0-9_]+)\s*\}\}`)
+
+// snippetExpander replaces snippet references in a step list with the snippet steps
+type snippetExpander struct {
+	db         *gorm.DB
+	snippets   map[string]*models.WorkflowSnippet // by name@version as referenced
+	provenance []models.SnippetProvenance
+}
+
+func newSnippetExpander(db *gorm.DB) *snippetExpander {
+	return &snippetExpander{db: db, snippets: make(map[string]*models.WorkflowSnippet)}
+}
+
+// expandSchema expands the snippet references in a template schema. It returns the
+// schema unchanged and no provenance when there are none.
+func (x *snippetExpander) expandSchema(schema models.JSONB) (models.JSONB, []models.SnippetProvenance, error) {
+	rawSteps, ok := schema["steps"].([]interface{})
+	if !ok || !hasSnippetReference(rawSteps) {
+		return schema, nil, nil
+	}
+
+	steps, err := x.expandSteps(rawSteps, nil, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := make(map[string]bool, len(steps))
+	expanded := make([]interface{}, len(steps))
+	for i, step := range steps {
+		id, _ := step["id"].(string)
+		if seen[id] {
+			return nil, nil, fmt.Errorf("step id %q is used more than once after expanding snippets", id)
+		}
+		seen[id] = true
+		expanded[i] = step
+	}
+
+	result := make(models.JSONB, len(schema))
+	for key, value := range schema {
+		result[key] = value
+	}
+	result["steps"] = expanded
+	return result, x.provenance, nil
+}
+
+// expandSteps expands references in steps. stack holds the snippets being expanded,
+// outermost first, and parent the reference step that contains steps.
+func (x *snippetExpander) expandSteps(rawSteps []interface{}, stack []string, parent string) ([]map[string]interface{}, error) {
+	var result []map[string]interface{}
+	entries := make(map[string]string) // reference id -> first expanded step id
+
+	for _, raw := range rawSteps {
+		step, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("steps must be objects")
+		}
+		if step["type"] != string(models.StepTypeSnippet) {
+			result = append(result, step)
+			continue
+		}
+
+		var ref models.SnippetReference
+		if err := decodeJSONB(step, &ref); err != nil {
+			return nil, fmt.Errorf("invalid snippet reference: %w", err)
+		}
+		expanded, err := x.expandReference(ref, stack, parent)
+		if err != nil {
+			return nil, err
+		}
+		entries[ref.ID] = expanded[0]["id"].(string)
+		result = append(result, expanded...)
+	}
+
+	// Steps that pointed at a reference now point at its first step
+	for _, step := range result {
+		next, ok := step["next_steps"].([]interface{})
+		if !ok {
+			continue
+		}
+		rewritten := make([]interface{}, len(next))
+		for i, id := range next {
+			if entry, ok := entries[fmt.Sprint(id)]; ok {
+				rewritten[i] = entry
+			} else {
+				rewritten[i] = id
+			}
+		}
+		step["next_steps"] = rewritten
+	}
+
+	return result, nil
+}
+
+// expandReference returns the steps of the referenced snippet, with parameters bound,
+// IDs prefixed by the reference ID and the last steps continuing to its next_steps
+func (x *snippetExpander) expandReference(ref models.SnippetReference, stack []string, parent string) ([]map[string]interface{}, error) {
+	if ref.ID == "" {
+		return nil, fmt.Errorf("snippet reference to %q has no id", ref.Snippet)
+	}
+	if ref.Snippet == "" {
+		return nil, fmt.Errorf("snippet reference %q does not name a snippet", ref.ID)
+	}
+	for _, name := range stack {
+		if name == ref.Snippet {
+			return nil, fmt.Errorf("snippet cycle: %s -> %s", strings.Join(stack, " -> "), ref.Snippet)
+		}
+	}
+	if len(stack) >= maxSnippetDepth {
+		return nil, fmt.Errorf("snippets are nested more than %d levels deep at %q", maxSnippetDepth, ref.ID)
+	}
+
+	snippet, err := x.resolve(ref.Snippet, ref.Version)
+	if err != nil {
+		return nil, err
+	}
+	params, err := bindSnippetParams(snippet, ref.With)
+	if err != nil {
+		return nil, fmt.Errorf("snippet reference %q: %w", ref.ID, err)
+	}
+
+	rawSteps := make([]interface{}, len(snippet.Steps))
+	for i, step := range snippet.Steps {
+		bound, err := substituteParams(step, params)
+		if err != nil {
+			return nil, fmt.Errorf("snippet %s@%s: %w", snippet.Name, snippet.Version, err)
+		}
+		rawSteps[i] = bound
+	}
+
+	nested := len(x.provenance)
+	steps, err := x.expandSteps(rawSteps, append(stack, ref.Snippet), ref.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("snippet %s@%s has no steps", snippet.Name, snippet.Version)
+	}
+
+	ids := make(map[string]string, len(steps))
+	for _, step := range steps {
+		id, _ := step["id"].(string)
+		ids[id] = ref.ID + "." + id
+	}
+
+	expandedIDs := make([]string, 0, len(steps))
+	for _, step := range steps {
+		step["id"] = ids[step["id"].(string)]
+		expandedIDs = append(expandedIDs, step["id"].(string))
+
+		next, _ := step["next_steps"].([]interface{})
+		if len(next) == 0 {
+			continuation := make([]interface{}, len(ref.NextSteps))
+			for i, id := range ref.NextSteps {
+				continuation[i] = id
+			}
+			if len(continuation) > 0 {
+				step["next_steps"] = continuation
+			}
+			if ref.Fork {
+				step["fork"] = true
+			}
+			continue
+		}
+		for i, id := range next {
+			if prefixed, ok := ids[fmt.Sprint(id)]; ok {
+				next[i] = prefixed
+			}
+		}
+	}
+
+	// References expanded inside this snippet get the same prefix
+	for i := nested; i < len(x.provenance); i++ {
+		entry := &x.provenance[i]
+		entry.StepID = ref.ID + "." + entry.StepID
+		if entry.Parent != ref.ID {
+			entry.Parent = ref.ID + "." + entry.Parent
+		}
+		for j, id := range entry.Steps {
+			entry.Steps[j] = ref.ID + "." + id
+		}
+	}
+
+	x.provenance = append(x.provenance, models.SnippetProvenance{
+		StepID:    ref.ID,
+		Snippet:   snippet.Name,
+		Version:   snippet.Version,
+		SnippetID: snippet.ID,
+		With:      ref.With,
+		Steps:     expandedIDs,
+		Parent:    parent,
+	})
+	return steps, nil
+}
+
+// resolve loads a snippet by name and version, or its most recent version
+func (x *snippetExpander) resolve(name, version string) (*models.WorkflowSnippet, error) {
+	key := name + "@" + version
+	if snippet, ok := x.snippets[key]; ok {
+		return snippet, nil
+	}
+
+	var snippet models.WorkflowSnippet
+	query := x.db.Where("name = ?", name)
+	if version != "" {
+		query = query.Where("version = ?", version)
+	}
+	if err := query.Order("created_at DESC").First(&snippet).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			if version != "" {
+				return nil, fmt.Errorf("snippet %s@%s not found", name, version)
+			}
+			return nil, fmt.Errorf("snippet %q not found", name)
+		}
+		return nil, err
+	}
+
+	x.snippets[key] = &snippet
+	return &snippet, nil
+}
+
+// bindSnippetParams checks the bindings of a reference against the declared
+// parameters and fills in defaults
+func bindSnippetParams(snippet *models.WorkflowSnippet, with map[string]interface{}) (map[string]interface{}, error) {
+	declared := make(map[string]bool, len(snippet.Parameters))
+	params := make(map[string]interface{}, len(snippet.Parameters))
+	for _, param := range snippet.Parameters {
+		declared[param.Name] = true
+		value, ok := with[param.Name]
+		switch {
+		case ok:
+			params[param.Name] = value
+		case param.Default != nil:
+			params[param.Name] = param.Default
+		case param.Required:
+			return nil, fmt.Errorf("missing required parameter %q", param.Name)
+		default:
+			params[param.Name] = nil
+		}
+	}
+	for name := range with {
+		if !declared[name] {
+			return nil, fmt.Errorf("snippet %s@%s has no parameter %q", snippet.Name, snippet.Version, name)
+		}
+	}
+	return params, nil
+}
+
+// substituteParams returns a deep copy of value with {{ params.name }} replaced.
+// A string that is a single placeholder takes the parameter's type.
+func substituteParams(value interface{}, params map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			bound, err := substituteParams(item, params)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = bound
+		}
+		return result, nil
+
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			bound, err := substituteParams(item, params)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = bound
+		}
+		return result, nil
+
+	case string:
+		if m := snippetParamPattern.FindStringSubmatch(v); m != nil && m[0] == strings.TrimSpace(v) {
+			param, ok := params[m[1]]
+			if !ok {
+				return nil, fmt.Errorf("unknown parameter %q", m[1])
+			}
+			return param, nil
+		}
+
+		var missing string
+		bound := snippetParamPattern.ReplaceAllStringFunc(v, func(placeholder string) string {
+			name := snippetParamPattern.FindStringSubmatch(placeholder)[1]
+			param, ok := params[name]
+			if !ok {
+				missing = name
+				return placeholder
+			}
+			if param == nil {
+				return ""
+			}
+			return fmt.Sprint(param)
+		})
+		if missing != "" {
+			return nil, fmt.Errorf("unknown parameter %q", missing)
+		}
+		return bound, nil
+
+	default:
+		return v, nil
+	}
+}
+
+func hasSnippetReference(steps []interface{}) bool {
+	for _, raw := range steps {
+		if step, ok := raw.(map[string]interface{}); ok && step["type"] == string(models.StepTypeSnippet) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordSnippetProvenance stores the expanded references in metadata.snippets. When
+// the schema was saved without references, earlier entries are kept as long as all
+// of their steps still exist.
+func recordSnippetProvenance(metadata, schema models.JSONB, provenance []models.SnippetProvenance) {
+	if provenance != nil {
+		metadata["snippets"] = provenance
+		return
+	}
+
+	var previous []models.SnippetProvenance
+	raw, err := json.Marshal(metadata["snippets"])
+	if err != nil || json.Unmarshal(raw, &previous) != nil || len(previous) == 0 {
+		delete(metadata, "snippets")
+		return
+	}
+
+	ids := make(map[string]bool)
+	if steps, ok := schema["steps"].([]interface{}); ok {
+		for _, raw := range steps {
+			if step, ok := raw.(map[string]interface{}); ok {
+				ids[fmt.Sprint(step["id"])] = true
+			}
+		}
+	}
+
+	kept := make([]models.SnippetProvenance, 0, len(previous))
+	for _, entry := range previous {
+		intact := true
+		for _, id := range entry.Steps {
+			intact = intact && ids[id]
+		}
+		if intact {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == 0 {
+		delete(metadata, "snippets")
+		return
+	}
+	metadata["snippets"] = kept
+}
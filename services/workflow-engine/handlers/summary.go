@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"chorus/pkg/apierror"
+	"chorus/workflow-engine/models"
+)
+
+const (
+	// How long a computed summary is served from Redis
+	summaryCacheTTL = 30 * time.Second
+
+	// Longest window a summary may cover
+	maxSummaryWindow = 90 * 24 * time.Hour
+)
+
+// Columns an instance summary can be pivoted by
+var summaryGroupColumns = map[string]string{
+	"template":   "i.template_id::text",
+	"status":     "i.status",
+	"created_by": "i.created_by",
+}
+
+// GetInstanceSummary handles GET /api/v1/instances/summary
+func (h *InstanceHandler) GetInstanceSummary(c *gin.Context) {
+	groupBy := c.DefaultQuery("group_by", "template")
+	column, ok := summaryGroupColumns[groupBy]
+	if !ok {
+		apierror.Abort(c, http.StatusBadRequest, "group_by must be one of template, status or created_by", nil)
+		return
+	}
+
+	windowParam := c.DefaultQuery("window", "24h")
+	window, err := parseWindow(windowParam)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid window", err.Error())
+		return
+	}
+
+	category := c.Query("category")
+	label := c.Query("label")
+	includeTest := c.Query("include_test") == "true"
+	caller := principalFrom(c)
+
+	// The result depends on what the caller can see, so the principal is part of the key
+	keyData, _ := json.Marshal([]interface{}{groupBy, windowParam, category, label, includeTest, caller.userID, caller.team, caller.admin})
+	sum := sha256.Sum256(keyData)
+	cacheKey := "instance_summary:" + hex.EncodeToString(sum[:16])
+	if cached, ok := h.engine.CachedResponse(c.Request.Context(), cacheKey); ok {
+		c.Header("X-Cache", "HIT")
+		c.Data(http.StatusOK, "application/json; charset=utf-8", cached)
+		return
+	}
+
+	now := time.Now()
+	since := now.Add(-window)
+
+	query := h.db.Table("workflow.instances AS i").
+		Select(column+" AS key, MAX(t.name) AS name, i.status, COUNT(*) AS count").
+		Joins("JOIN workflow.templates AS t ON t.id = i.template_id").
+		Where("i.created_at >= ?", since).
+		Where("i.template_id IN (?)", caller.scopeTemplates(h.db.Model(&models.WorkflowTemplate{}).Select("id")))
+	if !includeTest {
+		query = query.Where("i.is_test = false")
+	}
+	if category != "" {
+		query = query.Where("t.category = ?", category)
+	}
+	if label != "" {
+		labels, _ := json.Marshal([]string{label})
+		query = query.Where("t.metadata->'labels' @> ?::jsonb", string(labels))
+	}
+
+	var rows []struct {
+		Key    string
+		Name   string
+		Status string
+		Count  int64
+	}
+	if err := query.Group(column + ", i.status").Order("key").Scan(&rows).Error; err != nil {
+		h.logger.Error("Failed to summarize instances", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to summarize instances", nil)
+		return
+	}
+
+	summary := models.InstanceSummary{
+		GroupBy:     groupBy,
+		Window:      windowParam,
+		Since:       since,
+		GeneratedAt: now,
+		Groups:      []models.InstanceSummaryGroup{},
+	}
+	for _, row := range rows {
+		n := len(summary.Groups)
+		if n == 0 || summary.Groups[n-1].Key != row.Key {
+			group := models.InstanceSummaryGroup{Key: row.Key, Counts: make(map[string]int64)}
+			if groupBy == "template" {
+				group.Name = row.Name
+			}
+			summary.Groups = append(summary.Groups, group)
+			n++
+		}
+		summary.Groups[n-1].Counts[row.Status] += row.Count
+		summary.Groups[n-1].Total += row.Count
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		h.logger.Error("Failed to encode instance summary", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to summarize instances", nil)
+		return
+	}
+	h.engine.SetCachedResponse(c.Request.Context(), cacheKey, data, summaryCacheTTL)
+
+	c.Header("X-Cache", "MISS")
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
+
+// parseWindow parses a Go duration, also accepting whole days such as "7d"
+func parseWindow(s string) (time.Duration, error) {
+	var window time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q", s)
+		}
+		window = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if window, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("invalid window %q", s)
+		}
+	}
+
+	if window <= 0 || window > maxSummaryWindow {
+		return 0, fmt.Errorf("window must be between 1s and 90d")
+	}
+	return window, nil
+}
@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+	"chorus/workflow-engine/models"
+	"chorus/workflow-engine/services"
+)
+
+// Most tasks listed at once
+const maxTaskPage = 200
+
+// Statuses a task list may be filtered on, "all" for any of them
+var taskStatuses = []string{
+	models.TaskStatusOpen,
+	models.TaskStatusApproved,
+	models.TaskStatusRejected,
+	models.TaskStatusCompleted,
+	models.TaskStatusCancelled,
+	"all",
+}
+
+type TaskHandler struct {
+	db           *gorm.DB
+	engine       *services.Engine
+	managerRoles []string
+	logger       *logging.Logger
+}
+
+// NewTaskHandler serves the task inbox. Admins and holders of managerRoles see and
+// reassign the tasks of every assignee.
+func NewTaskHandler(db *gorm.DB, engine *services.Engine, managerRoles []string, logger *logging.Logger) *TaskHandler {
+	return &TaskHandler{
+		db:           db,
+		engine:       engine,
+		managerRoles: managerRoles,
+		logger:       logger,
+	}
+}
+
+// ListTasks handles GET /api/v1/tasks. ?assignee= is "me" (default) or a user ID,
+// ?status= "open" (default), another task status or "all"; open tasks come soonest
+// due first.
+func (h *TaskHandler) ListTasks(c *gin.Context) {
+	caller := principalFrom(c)
+
+	assignee := c.DefaultQuery("assignee", "me")
+	if assignee == "me" {
+		assignee = caller.userID
+	}
+	if assignee == "" {
+		apierror.Abort(c, http.StatusBadRequest, "assignee could not be determined", nil)
+		return
+	}
+	if assignee != caller.userID && !caller.hasRole(h.managerRoles) {
+		apierror.Abort(c, http.StatusForbidden, "You may only list your own tasks", nil)
+		return
+	}
+
+	status := c.DefaultQuery("status", models.TaskStatusOpen)
+	if !slices.Contains(taskStatuses, status) {
+		apierror.Abort(c, http.StatusBadRequest, fmt.Sprintf("status must be one of %v", taskStatuses), nil)
+		return
+	}
+
+	limit := 50
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxTaskPage {
+			apierror.Abort(c, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxTaskPage), nil)
+			return
+		}
+		limit = parsed
+	}
+
+	query := h.db.Where("assignee = ?", assignee)
+	if status != "all" {
+		query = query.Where("status = ?", status)
+	}
+	if value := c.Query("instance_id"); value != "" {
+		instanceID, err := uuid.Parse(value)
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, "Invalid instance ID", nil)
+			return
+		}
+		query = query.Where("instance_id = ?", instanceID)
+	}
+
+	var tasks []models.Task
+	if err := query.Order("due_at ASC NULLS LAST, created_at ASC").Limit(limit).Find(&tasks).Error; err != nil {
+		h.logger.Error("Failed to fetch tasks", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch tasks", nil)
+		return
+	}
+
+	now := time.Now()
+	responses := make([]models.TaskResponse, len(tasks))
+	for i := range tasks {
+		responses[i] = taskResponse(&tasks[i], now)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tasks": responses,
+	})
+}
+
+// GetTask handles GET /api/v1/tasks/:id, with the task's audit trail
+func (h *TaskHandler) GetTask(c *gin.Context) {
+	task, ok := h.loadTask(c)
+	if !ok {
+		return
+	}
+
+	var events []models.TaskEvent
+	if err := h.db.Where("task_id = ?", task.ID).Order("created_at ASC").Find(&events).Error; err != nil {
+		h.logger.Error("Failed to fetch task events", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch task", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.TaskDetailResponse{
+		TaskResponse: taskResponse(task, time.Now()),
+		Events:       events,
+	})
+}
+
+// ApproveTask handles POST /api/v1/tasks/:id/approve
+func (h *TaskHandler) ApproveTask(c *gin.Context) {
+	h.resolveTask(c, models.TaskActionApprove)
+}
+
+// RejectTask handles POST /api/v1/tasks/:id/reject
+func (h *TaskHandler) RejectTask(c *gin.Context) {
+	h.resolveTask(c, models.TaskActionReject)
+}
+
+// CompleteTask handles POST /api/v1/tasks/:id/complete
+func (h *TaskHandler) CompleteTask(c *gin.Context) {
+	h.resolveTask(c, models.TaskActionComplete)
+}
+
+// resolveTask records action on an open task and queues its instance, whose manual
+// step then completes with the outcome
+func (h *TaskHandler) resolveTask(c *gin.Context, action string) {
+	task, ok := h.loadTask(c)
+	if !ok {
+		return
+	}
+
+	var req models.ResolveTaskRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+			return
+		}
+	}
+	req.Comment = strings.TrimSpace(req.Comment)
+	if utf8.RuneCountInString(req.Comment) > maxCommentLength {
+		apierror.Abort(c, http.StatusBadRequest, "Comment is too long", gin.H{
+			"max_length": maxCommentLength,
+		})
+		return
+	}
+
+	if task.Status != models.TaskStatusOpen {
+		apierror.Abort(c, http.StatusConflict, "Task is already "+task.Status, nil)
+		return
+	}
+	if !task.Actions.Contains(action) {
+		apierror.Abort(c, http.StatusConflict, fmt.Sprintf("Task cannot be resolved with %s", action), gin.H{
+			"actions": task.Actions,
+		})
+		return
+	}
+
+	actor := c.GetString("userID")
+	now := time.Now()
+	status := models.TaskActionStatus[action]
+	resolved := false
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Task{}).
+			Where("id = ? AND status = ?", task.ID, models.TaskStatusOpen).
+			Updates(map[string]interface{}{
+				"status":      status,
+				"resolved_by": actor,
+				"resolved_at": now,
+				"comment":     req.Comment,
+				"data":        req.Data,
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		resolved = true
+		return tx.Create(&models.TaskEvent{
+			TaskID: task.ID,
+			Event:  models.TaskEventResolved,
+			Actor:  actor,
+			Status: status,
+			Reason: req.Comment,
+		}).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to resolve task", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to resolve task", nil)
+		return
+	}
+	if !resolved {
+		apierror.Abort(c, http.StatusConflict, "Task was resolved concurrently", nil)
+		return
+	}
+
+	// The engine's periodic check resumes the step if the queue is full
+	if err := h.engine.QueueInstance(task.InstanceID); err != nil {
+		h.logger.Warn("Failed to queue instance after task resolution", "instance_id", task.InstanceID, "error", err)
+	}
+
+	task.Status = status
+	task.ResolvedBy = actor
+	task.ResolvedAt = &now
+	task.Comment = req.Comment
+	task.Data = req.Data
+	h.logger.Info("Task resolved", "task_id", task.ID, "instance_id", task.InstanceID, "status", status, "resolved_by", actor)
+	c.JSON(http.StatusOK, taskResponse(task, now))
+}
+
+// ReassignTask handles PUT /api/v1/tasks/:id/assignee
+func (h *TaskHandler) ReassignTask(c *gin.Context) {
+	task, ok := h.loadTask(c)
+	if !ok {
+		return
+	}
+
+	var req models.ReassignTaskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+	req.Assignee = strings.TrimSpace(req.Assignee)
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Assignee == "" {
+		apierror.Abort(c, http.StatusBadRequest, "assignee cannot be empty", nil)
+		return
+	}
+	if utf8.RuneCountInString(req.Reason) > maxCommentLength {
+		apierror.Abort(c, http.StatusBadRequest, "Reason is too long", gin.H{
+			"max_length": maxCommentLength,
+		})
+		return
+	}
+
+	if task.Status != models.TaskStatusOpen {
+		apierror.Abort(c, http.StatusConflict, "Task is already "+task.Status, nil)
+		return
+	}
+	if req.Assignee == task.Assignee {
+		c.JSON(http.StatusOK, taskResponse(task, time.Now()))
+		return
+	}
+
+	actor := c.GetString("userID")
+	reassigned := false
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Task{}).
+			Where("id = ? AND status = ? AND assignee = ?", task.ID, models.TaskStatusOpen, task.Assignee).
+			Update("assignee", req.Assignee)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		reassigned = true
+		return tx.Create(&models.TaskEvent{
+			TaskID:       task.ID,
+			Event:        models.TaskEventReassigned,
+			Actor:        actor,
+			FromAssignee: task.Assignee,
+			ToAssignee:   req.Assignee,
+			Reason:       req.Reason,
+		}).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to reassign task", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to reassign task", nil)
+		return
+	}
+	if !reassigned {
+		apierror.Abort(c, http.StatusConflict, "Task was changed concurrently", nil)
+		return
+	}
+
+	h.logger.Info("Task reassigned", "task_id", task.ID, "from", task.Assignee, "to", req.Assignee, "by", actor)
+	task.Assignee = req.Assignee
+	c.JSON(http.StatusOK, taskResponse(task, time.Now()))
+}
+
+// loadTask fetches the task of the request, which the caller must be able to act
+// on, answering the request otherwise
+func (h *TaskHandler) loadTask(c *gin.Context) (*models.Task, bool) {
+	taskID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid task ID", nil)
+		return nil, false
+	}
+
+	var task models.Task
+	if err := h.db.First(&task, "id = ?", taskID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apierror.Abort(c, http.StatusNotFound, "Task not found", nil)
+			return nil, false
+		}
+		h.logger.Error("Failed to fetch task", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch task", nil)
+		return nil, false
+	}
+
+	if !principalFrom(c).canHandleTask(&task, h.managerRoles) {
+		apierror.Abort(c, http.StatusNotFound, "Task not found", nil)
+		return nil, false
+	}
+	return &task, true
+}
+
+// taskResponse adds what an inbox shows to a task: whether it is overdue and, while
+// it is open, the paths acting on it
+func taskResponse(task *models.Task, now time.Time) models.TaskResponse {
+	response := models.TaskResponse{Task: *task}
+	if task.Status != models.TaskStatusOpen {
+		return response
+	}
+
+	response.Overdue = task.DueAt != nil && !now.Before(*task.DueAt)
+	base := "/api/v1/tasks/" + task.ID.String()
+	links := &models.TaskLinks{Reassign: base + "/assignee"}
+	if task.Actions.Contains(models.TaskActionApprove) {
+		links.Approve = base + "/approve"
+	}
+	if task.Actions.Contains(models.TaskActionReject) {
+		links.Reject = base + "/reject"
+	}
+	if task.Actions.Contains(models.TaskActionComplete) {
+		links.Complete = base + "/complete"
+	}
+	response.Links = links
+	return response
+}
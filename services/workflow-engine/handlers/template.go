@@ -1,49 +1,59 @@
 package handlers
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
-	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
 	"gorm.io/gorm"
 
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+	"chorus/workflow-engine/config"
 	"chorus/workflow-engine/models"
-	"chorus/workflow-engine/utils"
+	"chorus/workflow-engine/services"
 )
 
 type TemplateHandler struct {
-	db     *gorm.DB
-	logger *utils.Logger
+	db         *gorm.DB
+	engine     *services.Engine // sends the template webhooks
+	pagination config.PaginationConfig
+	logger     *logging.Logger
+
+	freeTextCategories bool // categories are not checked against the managed ones
 }
 
-func NewTemplateHandler(db *gorm.DB, logger *utils.Logger) *TemplateHandler {
+// NewTemplateHandler serves templates. Unless freeTextCategories, a template's
+// category must be one of the managed categories.
+func NewTemplateHandler(db *gorm.DB, engine *services.Engine, pagination config.PaginationConfig, freeTextCategories bool, logger *logging.Logger) *TemplateHandler {
 	return &TemplateHandler{
-		db:     db,
-		logger: logger,
+		db:                 db,
+		engine:             engine,
+		pagination:         pagination,
+		logger:             logger,
+		freeTextCategories: freeTextCategories,
 	}
 }
 
-// ListTemplates handles GET /api/v1/templates
+// ListTemplates handles GET /api/v1/templates; ?category= is matched ignoring case
 func (h *TemplateHandler) ListTemplates(c *gin.Context) {
 	// Parse query parameters
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	page, ok := parsePagination(c, h.pagination, 0)
+	if !ok {
+		return
+	}
 	category := c.Query("category")
 	isActive := c.Query("is_active")
 
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 20
-	}
-
-	// Build query
-	query := h.db.Model(&models.WorkflowTemplate{})
+	// Build query, limited to the templates the caller can see
+	query := principalFrom(c).scopeTemplates(h.db.Model(&models.WorkflowTemplate{}))
 
 	if category != "" {
-		query = query.Where("category = ?", category)
+		query = query.Where("LOWER(category) = LOWER(?)", category)
 	}
 
 	if isActive != "" {
@@ -58,57 +68,50 @@ func (h *TemplateHandler) ListTemplates(c *gin.Context) {
 	var total int64
 	if err := query.Count(&total).Error; err != nil {
 		h.logger.Error("Failed to count templates", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to count templates",
-		})
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to count templates", nil)
 		return
 	}
 
 	// Get templates with pagination
 	var templates []models.WorkflowTemplate
-	offset := (page - 1) * pageSize
-	if err := query.Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&templates).Error; err != nil {
+	if err := page.apply(query, true).Find(&templates).Error; err != nil {
 		h.logger.Error("Failed to fetch templates", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch templates",
-		})
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch templates", nil)
 		return
 	}
 
-	totalPages := int((total + int64(pageSize) - 1) / int64(pageSize))
-
-	response := models.ListResponse[models.WorkflowTemplate]{
-		Data:       templates,
-		Total:      total,
-		Page:       page,
-		PageSize:   pageSize,
-		TotalPages: totalPages,
-	}
-
-	c.JSON(http.StatusOK, response)
+	c.JSON(http.StatusOK, listResponse(page, templates, total, func(t *models.WorkflowTemplate) pageCursor {
+		return pageCursor{CreatedAt: t.CreatedAt, ID: t.ID}
+	}))
 }
 
 // CreateTemplate handles POST /api/v1/templates
 func (h *TemplateHandler) CreateTemplate(c *gin.Context) {
 	var req models.CreateTemplateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request body",
-			"details": err.Error(),
-		})
+		apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
 		return
 	}
 
 	// Get user ID from context
 	userID, _ := c.Get("userID")
+	caller := principalFrom(c)
+
+	category, ok := h.resolveCategory(c, req.Category)
+	if !ok {
+		return
+	}
 
 	template := models.WorkflowTemplate{
 		Name:        req.Name,
 		Description: req.Description,
-		Category:    req.Category,
+		Category:    category,
 		Version:     req.Version,
 		Schema:      req.Schema,
 		Metadata:    req.Metadata,
+		Visibility:  req.Visibility,
+		Owners:      models.StringList{userID.(string)},
+		Team:        caller.team,
 		CreatedBy:   userID.(string),
 	}
 
@@ -118,25 +121,59 @@ func (h *TemplateHandler) CreateTemplate(c *gin.Context) {
 	if template.Metadata == nil {
 		template.Metadata = make(models.JSONB)
 	}
+	if template.Visibility == "" {
+		template.Visibility = models.TemplateVisibilityPrivate
+	}
+	for _, owner := range req.Owners {
+		if owner != "" && !template.Owners.Contains(owner) {
+			template.Owners = append(template.Owners, owner)
+		}
+	}
+	if req.Team != "" {
+		if req.Team != caller.team && !caller.admin {
+			apierror.Abort(c, http.StatusForbidden, "Templates can only be shared with your own team", nil)
+			return
+		}
+		template.Team = req.Team
+	}
+	if err := validateVisibility(template.Visibility); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid template visibility", err.Error())
+		return
+	}
+	if req.EncryptVariables && !models.EncryptionConfigured() {
+		apierror.Abort(c, http.StatusBadRequest, encryptionNotConfigured, nil)
+		return
+	}
+	template.EncryptVariables = req.EncryptVariables
+
+	// Snippet references are materialized so execution never depends on snippets
+	schema, provenance, err := newSnippetExpander(h.db).expandSchema(template.Schema)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid workflow schema", err.Error())
+		return
+	}
+	template.Schema = schema
 
 	// Validate workflow schema
 	if err := h.validateWorkflowSchema(template.Schema); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid workflow schema",
-			"details": err.Error(),
-		})
+		apierror.Abort(c, http.StatusBadRequest, "Invalid workflow schema", err.Error())
 		return
 	}
 
+	if err := validateTemplateMetadata(template.Metadata, template.Schema); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid template metadata", err.Error())
+		return
+	}
+	recordSnippetProvenance(template.Metadata, template.Schema, provenance)
+
 	if err := h.db.Create(&template).Error; err != nil {
 		h.logger.Error("Failed to create template", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create template",
-		})
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to create template", nil)
 		return
 	}
 
 	h.logger.Info("Template created", "id", template.ID, "name", template.Name)
+	h.engine.EmitTemplateEvent(&template, models.TemplateEventCreated, c.GetString("userID"), nil)
 	c.JSON(http.StatusCreated, template)
 }
 
@@ -145,24 +182,23 @@ func (h *TemplateHandler) GetTemplate(c *gin.Context) {
 	id := c.Param("id")
 	templateID, err := uuid.Parse(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid template ID",
-		})
+		apierror.Abort(c, http.StatusBadRequest, "Invalid template ID", nil)
 		return
 	}
 
 	var template models.WorkflowTemplate
 	if err := h.db.First(&template, templateID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "Template not found",
-			})
+			apierror.Abort(c, http.StatusNotFound, "Template not found", nil)
 			return
 		}
 		h.logger.Error("Failed to fetch template", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch template",
-		})
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch template", nil)
+		return
+	}
+
+	if !principalFrom(c).canView(&template) {
+		apierror.Abort(c, http.StatusNotFound, "Template not found", nil)
 		return
 	}
 
@@ -174,33 +210,33 @@ func (h *TemplateHandler) UpdateTemplate(c *gin.Context) {
 	id := c.Param("id")
 	templateID, err := uuid.Parse(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid template ID",
-		})
+		apierror.Abort(c, http.StatusBadRequest, "Invalid template ID", nil)
 		return
 	}
 
 	var req models.UpdateTemplateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request body",
-			"details": err.Error(),
-		})
+		apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
 		return
 	}
 
 	var template models.WorkflowTemplate
 	if err := h.db.First(&template, templateID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "Template not found",
-			})
+			apierror.Abort(c, http.StatusNotFound, "Template not found", nil)
 			return
 		}
 		h.logger.Error("Failed to fetch template", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch template",
-		})
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch template", nil)
+		return
+	}
+
+	if !principalFrom(c).canView(&template) {
+		apierror.Abort(c, http.StatusNotFound, "Template not found", nil)
+		return
+	}
+	if !principalFrom(c).canEdit(&template) {
+		apierror.Abort(c, http.StatusForbidden, "You do not have permission to modify this template", nil)
 		return
 	}
 
@@ -211,35 +247,97 @@ func (h *TemplateHandler) UpdateTemplate(c *gin.Context) {
 	if req.Description != nil {
 		template.Description = *req.Description
 	}
-	if req.Category != nil {
-		template.Category = *req.Category
+	if req.Category != nil && *req.Category != template.Category {
+		category, ok := h.resolveCategory(c, *req.Category)
+		if !ok {
+			return
+		}
+		template.Category = category
 	}
+	var provenance []models.SnippetProvenance
 	if req.Schema != nil {
-		if err := h.validateWorkflowSchema(*req.Schema); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid workflow schema",
-				"details": err.Error(),
-			})
+		schema, expanded, err := newSnippetExpander(h.db).expandSchema(*req.Schema)
+		if err == nil {
+			err = h.validateWorkflowSchema(schema)
+		}
+		if err != nil {
+			apierror.Abort(c, http.StatusBadRequest, "Invalid workflow schema", err.Error())
 			return
 		}
-		template.Schema = *req.Schema
+		template.Schema = schema
+		provenance = expanded
 	}
 	if req.Metadata != nil {
+		if err := validateTemplateMetadata(*req.Metadata, template.Schema); err != nil {
+			apierror.Abort(c, http.StatusBadRequest, "Invalid template metadata", err.Error())
+			return
+		}
+		// metadata.snippets is maintained by the engine
+		snippets, hadSnippets := template.Metadata["snippets"]
 		template.Metadata = *req.Metadata
+		if hadSnippets {
+			template.Metadata["snippets"] = snippets
+		}
 	}
+	if req.Schema != nil {
+		if template.Metadata == nil {
+			template.Metadata = make(models.JSONB)
+		}
+		recordSnippetProvenance(template.Metadata, template.Schema, provenance)
+	}
+	published := req.IsActive != nil && *req.IsActive && !template.IsActive
 	if req.IsActive != nil {
 		template.IsActive = *req.IsActive
 	}
+	// Instances keep the setting they were created with
+	if req.EncryptVariables != nil {
+		if *req.EncryptVariables && !models.EncryptionConfigured() {
+			apierror.Abort(c, http.StatusBadRequest, encryptionNotConfigured, nil)
+			return
+		}
+		template.EncryptVariables = *req.EncryptVariables
+	}
+	if req.Visibility != nil || req.Owners != nil || req.Team != nil {
+		caller := principalFrom(c)
+		if !caller.canShare(&template) {
+			apierror.Abort(c, http.StatusForbidden, "Only owners can change who can access this template", nil)
+			return
+		}
+		if req.Visibility != nil {
+			if err := validateVisibility(*req.Visibility); err != nil {
+				apierror.Abort(c, http.StatusBadRequest, "Invalid template visibility", err.Error())
+				return
+			}
+			template.Visibility = *req.Visibility
+		}
+		if req.Owners != nil {
+			template.Owners = models.StringList{}
+			for _, owner := range *req.Owners {
+				if owner != "" && !template.Owners.Contains(owner) {
+					template.Owners = append(template.Owners, owner)
+				}
+			}
+		}
+		if req.Team != nil {
+			if *req.Team != "" && *req.Team != caller.team && !caller.admin {
+				apierror.Abort(c, http.StatusForbidden, "Templates can only be shared with your own team", nil)
+				return
+			}
+			template.Team = *req.Team
+		}
+	}
 
 	if err := h.db.Save(&template).Error; err != nil {
 		h.logger.Error("Failed to update template", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to update template",
-		})
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to update template", nil)
 		return
 	}
 
 	h.logger.Info("Template updated", "id", template.ID, "name", template.Name)
+	h.engine.EmitTemplateEvent(&template, models.TemplateEventUpdated, c.GetString("userID"), nil)
+	if published {
+		h.engine.EmitTemplateEvent(&template, models.TemplateEventPublished, c.GetString("userID"), nil)
+	}
 	c.JSON(http.StatusOK, template)
 }
 
@@ -248,9 +346,7 @@ func (h *TemplateHandler) DeleteTemplate(c *gin.Context) {
 	id := c.Param("id")
 	templateID, err := uuid.Parse(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid template ID",
-		})
+		apierror.Abort(c, http.StatusBadRequest, "Invalid template ID", nil)
 		return
 	}
 
@@ -258,15 +354,20 @@ func (h *TemplateHandler) DeleteTemplate(c *gin.Context) {
 	var template models.WorkflowTemplate
 	if err := h.db.First(&template, templateID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "Template not found",
-			})
+			apierror.Abort(c, http.StatusNotFound, "Template not found", nil)
 			return
 		}
 		h.logger.Error("Failed to fetch template", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch template",
-		})
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch template", nil)
+		return
+	}
+
+	if !principalFrom(c).canView(&template) {
+		apierror.Abort(c, http.StatusNotFound, "Template not found", nil)
+		return
+	}
+	if !principalFrom(c).canEdit(&template) {
+		apierror.Abort(c, http.StatusForbidden, "You do not have permission to modify this template", nil)
 		return
 	}
 
@@ -274,16 +375,12 @@ func (h *TemplateHandler) DeleteTemplate(c *gin.Context) {
 	var instanceCount int64
 	if err := h.db.Model(&models.WorkflowInstance{}).Where("template_id = ? AND status IN ?", templateID, []string{"pending", "running", "paused"}).Count(&instanceCount).Error; err != nil {
 		h.logger.Error("Failed to check active instances", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to check active instances",
-		})
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to check active instances", nil)
 		return
 	}
 
 	if instanceCount > 0 {
-		c.JSON(http.StatusConflict, gin.H{
-			"error": "Cannot delete template with active instances",
-		})
+		apierror.Abort(c, http.StatusConflict, "Cannot delete template with active instances", nil)
 		return
 	}
 
@@ -291,18 +388,220 @@ func (h *TemplateHandler) DeleteTemplate(c *gin.Context) {
 	template.IsActive = false
 	if err := h.db.Save(&template).Error; err != nil {
 		h.logger.Error("Failed to delete template", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to delete template",
-		})
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to delete template", nil)
 		return
 	}
 
 	h.logger.Info("Template deleted", "id", template.ID, "name", template.Name)
+	h.engine.EmitTemplateEvent(&template, models.TemplateEventDeleted, c.GetString("userID"), nil)
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Template deleted successfully",
 	})
 }
 
+// ExportTemplate handles GET /api/v1/templates/:id/export
+func (h *TemplateHandler) ExportTemplate(c *gin.Context) {
+	id := c.Param("id")
+	templateID, err := uuid.Parse(id)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid template ID", nil)
+		return
+	}
+
+	var template models.WorkflowTemplate
+	if err := h.db.First(&template, templateID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apierror.Abort(c, http.StatusNotFound, "Template not found", nil)
+			return
+		}
+		h.logger.Error("Failed to fetch template", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch template", nil)
+		return
+	}
+
+	if !principalFrom(c).canView(&template) {
+		apierror.Abort(c, http.StatusNotFound, "Template not found", nil)
+		return
+	}
+
+	export := models.TemplateExport{
+		Name:        template.Name,
+		Description: template.Description,
+		Category:    template.Category,
+		Version:     template.Version,
+		Schema:      template.Schema,
+		Metadata:    template.Metadata,
+	}
+
+	filename := fmt.Sprintf("%s-%s", template.Name, template.Version)
+	switch c.NegotiateFormat(gin.MIMEJSON, "application/yaml", gin.MIMEYAML, "text/yaml") {
+	case "application/yaml", gin.MIMEYAML, "text/yaml":
+		data, err := yaml.Marshal(export)
+		if err != nil {
+			h.logger.Error("Failed to encode template as YAML", "error", err)
+			apierror.Abort(c, http.StatusInternalServerError, "Failed to export template", nil)
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".yaml"))
+		c.Data(http.StatusOK, "application/yaml; charset=utf-8", data)
+	default:
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".json"))
+		c.JSON(http.StatusOK, export)
+	}
+}
+
+// GetTemplateStats handles GET /api/v1/templates/:id/stats
+func (h *TemplateHandler) GetTemplateStats(c *gin.Context) {
+	id := c.Param("id")
+	templateID, err := uuid.Parse(id)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid template ID", nil)
+		return
+	}
+
+	var template models.WorkflowTemplate
+	if err := h.db.First(&template, templateID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apierror.Abort(c, http.StatusNotFound, "Template not found", nil)
+			return
+		}
+		h.logger.Error("Failed to fetch template", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch template", nil)
+		return
+	}
+
+	if !principalFrom(c).canView(&template) {
+		apierror.Abort(c, http.StatusNotFound, "Template not found", nil)
+		return
+	}
+
+	stats := models.TemplateStats{
+		TemplateID:        templateID,
+		InstancesByStatus: make(map[string]int64),
+		Steps:             []models.StepStats{},
+	}
+
+	var statusCounts []struct {
+		Status string
+		Count  int64
+	}
+	if err := h.db.Model(&models.WorkflowInstance{}).
+		Select("status, COUNT(*) AS count").
+		Where("template_id = ? AND is_test = false", templateID).
+		Group("status").
+		Scan(&statusCounts).Error; err != nil {
+		h.logger.Error("Failed to aggregate instance statuses", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to compute template stats", nil)
+		return
+	}
+	for _, sc := range statusCounts {
+		stats.InstancesByStatus[sc.Status] = sc.Count
+	}
+
+	if err := h.db.Table("workflow.steps AS s").
+		Select(`s.step_id,
+			COUNT(*) AS executions,
+			COUNT(*) FILTER (WHERE s.status = 'failed') AS failures,
+			COALESCE(AVG(EXTRACT(EPOCH FROM (s.completed_at - s.started_at))), 0) AS avg_duration_seconds,
+			COALESCE(MAX(EXTRACT(EPOCH FROM (s.completed_at - s.started_at))), 0) AS max_duration_seconds,
+			COUNT(*) FILTER (WHERE s.warnings @> ?::jsonb) AS budget_breaches`, `[{"type":"`+models.StepWarningSlow+`"}]`).
+		Joins("JOIN workflow.instances AS i ON i.id = s.instance_id").
+		Where("i.template_id = ? AND i.is_test = false", templateID).
+		Group("s.step_id").
+		Order("s.step_id").
+		Scan(&stats.Steps).Error; err != nil {
+		h.logger.Error("Failed to aggregate step stats", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to compute template stats", nil)
+		return
+	}
+
+	if err := h.db.Model(&models.WorkflowInstance{}).
+		Select(`COUNT(*) AS instances,
+			COALESCE(AVG((timing->>'queue_wait_ms')::bigint), 0) AS avg_queue_wait_ms,
+			COALESCE(AVG((timing->>'step_execution_ms')::bigint), 0) AS avg_step_execution_ms,
+			COALESCE(AVG((timing->>'retry_delay_ms')::bigint), 0) AS avg_retry_delay_ms,
+			COALESCE(AVG((timing->>'wait_step_ms')::bigint), 0) AS avg_wait_step_ms,
+			COALESCE(AVG((timing->>'wall_clock_ms')::bigint), 0) AS avg_wall_clock_ms,
+			COALESCE(MAX((timing->>'wall_clock_ms')::bigint), 0) AS max_wall_clock_ms`).
+		Where("template_id = ? AND is_test = false AND timing IS NOT NULL", templateID).
+		Scan(&stats.Timing).Error; err != nil {
+		h.logger.Error("Failed to aggregate instance timing", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to compute template stats", nil)
+		return
+	}
+
+	// Attach the declared budgets so authors can compare them with observed durations
+	var schema models.WorkflowSchema
+	if data, err := json.Marshal(template.Schema); err == nil {
+		json.Unmarshal(data, &schema)
+	}
+	for i := range stats.Steps {
+		for _, stepDef := range schema.Steps {
+			if stepDef.ID == stats.Steps[i].StepID {
+				stats.Steps[i].ExpectedDurationSeconds = stepDef.ExpectedDurationSeconds
+				break
+			}
+		}
+		if stats.Steps[i].Executions > 0 {
+			stats.Steps[i].BudgetBreachRate = float64(stats.Steps[i].BudgetBreaches) / float64(stats.Steps[i].Executions)
+		}
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// GetLaunchForm handles GET /api/v1/templates/:id/launch-form
+func (h *TemplateHandler) GetLaunchForm(c *gin.Context) {
+	id := c.Param("id")
+	templateID, err := uuid.Parse(id)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid template ID", nil)
+		return
+	}
+
+	var template models.WorkflowTemplate
+	if err := h.db.First(&template, templateID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apierror.Abort(c, http.StatusNotFound, "Template not found", nil)
+			return
+		}
+		h.logger.Error("Failed to fetch template", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch template", nil)
+		return
+	}
+
+	if !principalFrom(c).canView(&template) {
+		apierror.Abort(c, http.StatusNotFound, "Template not found", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, buildLaunchForm(&template))
+}
+
+// resolveCategory returns the category a template asks to be filed under: the slug
+// of a managed category, or in free-text mode the category as given. An unknown
+// category is answered with 400.
+func (h *TemplateHandler) resolveCategory(c *gin.Context, requested string) (string, bool) {
+	if h.freeTextCategories || strings.TrimSpace(requested) == "" {
+		return requested, true
+	}
+
+	slug := models.CategorySlug(requested)
+	var count int64
+	if err := h.db.Model(&models.TemplateCategory{}).Where("slug = ?", slug).Count(&count).Error; err != nil {
+		h.logger.Error("Failed to look up category", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to look up category", nil)
+		return "", false
+	}
+	if count == 0 {
+		apierror.Abort(c, http.StatusBadRequest, fmt.Sprintf("Unknown category %q", requested), gin.H{
+			"slug": slug,
+		})
+		return "", false
+	}
+	return slug, true
+}
+
 // validateWorkflowSchema validates the workflow schema structure
 func (h *TemplateHandler) validateWorkflowSchema(schema models.JSONB) error {
 	// Basic schema validation - in a real implementation, you might want more sophisticated validation
@@ -310,6 +609,10 @@ func (h *TemplateHandler) validateWorkflowSchema(schema models.JSONB) error {
 		return nil
 	}
 
+	if err := validateWorkflowInputs(schema); err != nil {
+		return err
+	}
+
 	steps, ok := schema["steps"]
 	if !ok {
 		return nil // Steps are optional in some cases
@@ -334,6 +637,82 @@ func (h *TemplateHandler) validateWorkflowSchema(schema models.JSONB) error {
 		if _, ok := stepMap["type"]; !ok {
 			return nil
 		}
+
+		if err := validateStepAssertions(stepMap); err != nil {
+			return fmt.Errorf("step %v: %w", stepMap["id"], err)
+		}
+		if err := validateStepConditions(stepMap); err != nil {
+			return fmt.Errorf("step %v: %w", stepMap["id"], err)
+		}
+	}
+
+	return nil
+}
+
+// Operators understood by condition steps and step assertions
+var conditionOperators = map[string]bool{
+	"eq": true, "equals": true,
+	"ne": true, "not_equals": true,
+	"gt": true, "greater_than": true,
+	"lt": true, "less_than": true,
+	"contains": true,
+}
+
+// validateStepConditions checks the on_error of a condition step's conditions, which
+// applies when the data source of their field fails
+func validateStepConditions(stepMap map[string]interface{}) error {
+	conditions, _ := stepMap["conditions"].([]interface{})
+	for i, item := range conditions {
+		condition, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("conditions[%d] must be an object", i)
+		}
+		switch onError := condition["on_error"].(type) {
+		case nil:
+		case string:
+			if onError != "" && onError != "false" && onError != "fail" {
+				return fmt.Errorf("conditions[%d] has on_error %q, which must be false or fail", i, onError)
+			}
+		default:
+			return fmt.Errorf("conditions[%d] has on_error %v, which must be false or fail", i, onError)
+		}
+	}
+	return nil
+}
+
+// validateStepAssertions checks that a step's assert block is a list of conditions
+// with dot-separated field paths and known operators
+func validateStepAssertions(stepMap map[string]interface{}) error {
+	raw, ok := stepMap["assert"]
+	if !ok || raw == nil {
+		return nil
+	}
+
+	assertions, ok := raw.([]interface{})
+	if !ok {
+		return fmt.Errorf("assert must be a list of conditions")
+	}
+
+	for i, item := range assertions {
+		condition, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("assert[%d] must be an object", i)
+		}
+
+		field, _ := condition["field"].(string)
+		if field == "" || strings.ContainsAny(field, " \t\n[]{}") {
+			return fmt.Errorf("assert[%d] has invalid field path %q", i, field)
+		}
+		for _, part := range strings.Split(field, ".") {
+			if part == "" {
+				return fmt.Errorf("assert[%d] has invalid field path %q", i, field)
+			}
+		}
+
+		operator, _ := condition["operator"].(string)
+		if !conditionOperators[operator] {
+			return fmt.Errorf("assert[%d] has unsupported operator %q", i, operator)
+		}
 	}
 
 	return nil
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"chorus/workflow-engine/models"
+)
+
+// Limits on template metadata written by the workflow builder
+const (
+	// Serialized size of metadata keys the engine does not know about
+	maxMetadataExtraSize = 16 * 1024
+	maxUIFormFields      = 100
+	maxUIIconLength      = 64
+)
+
+var (
+	uiColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	uiIconPattern  = regexp.MustCompile(`^[a-z0-9][a-z0-9:_-]*$`)
+
+	knownUIKeys = map[string]bool{"positions": true, "colors": true, "icons": true, "form": true}
+	uiWidgets   = map[string]bool{"text": true, "textarea": true, "number": true, "checkbox": true, "select": true, "json": true, "date": true}
+	inputTypes  = map[string]bool{"string": true, "number": true, "boolean": true, "object": true, "array": true}
+)
+
+// parseWorkflowSchema decodes the typed view of a template schema
+func parseWorkflowSchema(schema models.JSONB) models.WorkflowSchema {
+	var parsed models.WorkflowSchema
+	if data, err := json.Marshal(schema); err == nil {
+		json.Unmarshal(data, &parsed)
+	}
+	return parsed
+}
+
+// decodeTemplateUI decodes metadata.ui, failing on values of the wrong type
+func decodeTemplateUI(metadata models.JSONB) (models.TemplateUI, error) {
+	var ui models.TemplateUI
+	raw, ok := metadata["ui"]
+	if !ok || raw == nil {
+		return ui, nil
+	}
+	if _, isObject := raw.(map[string]interface{}); !isObject {
+		return ui, fmt.Errorf("metadata.ui must be an object")
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return ui, err
+	}
+	if err := json.Unmarshal(data, &ui); err != nil {
+		return ui, fmt.Errorf("metadata.ui: %v", err)
+	}
+	return ui, nil
+}
+
+// validateWorkflowInputs checks the inputs declared in a template schema
+func validateWorkflowInputs(schema models.JSONB) error {
+	raw, ok := schema["inputs"]
+	if !ok || raw == nil {
+		return nil
+	}
+	if _, isList := raw.([]interface{}); !isList {
+		return fmt.Errorf("inputs must be a list")
+	}
+
+	seen := make(map[string]bool)
+	for i, input := range parseWorkflowSchema(schema).Inputs {
+		if input.Name == "" {
+			return fmt.Errorf("inputs[%d]: name is required", i)
+		}
+		if seen[input.Name] {
+			return fmt.Errorf("inputs[%d]: duplicate input %q", i, input.Name)
+		}
+		seen[input.Name] = true
+		if input.Type != "" && !inputTypes[input.Type] {
+			return fmt.Errorf("inputs[%d]: unsupported type %q", i, input.Type)
+		}
+	}
+	return nil
+}
+
+// validateTemplateMetadata checks metadata.ui against the template schema, and the
+// shape of metadata.notifications. Keys the engine does not know about are kept, but
+// their combined size is capped.
+func validateTemplateMetadata(metadata models.JSONB, schema models.JSONB) error {
+	extra := 0
+	for key, value := range metadata {
+		if key != "ui" && key != "snippets" {
+			extra += jsonSize(key, value)
+		}
+	}
+	if uiMap, ok := metadata["ui"].(map[string]interface{}); ok {
+		for key, value := range uiMap {
+			if !knownUIKeys[key] {
+				extra += jsonSize(key, value)
+			}
+		}
+	}
+	if extra > maxMetadataExtraSize {
+		return fmt.Errorf("unrecognized metadata keys take %d bytes, the limit is %d", extra, maxMetadataExtraSize)
+	}
+
+	if _, err := models.DecodeTemplateNotifications(metadata); err != nil {
+		return err
+	}
+
+	ui, err := decodeTemplateUI(metadata)
+	if err != nil {
+		return err
+	}
+
+	parsed := parseWorkflowSchema(schema)
+	steps := make(map[string]bool)
+	for _, step := range parsed.Steps {
+		steps[step.ID] = true
+	}
+
+	for stepID := range ui.Positions {
+		if !steps[stepID] {
+			return fmt.Errorf("metadata.ui.positions: unknown step %q", stepID)
+		}
+	}
+	for stepID, color := range ui.Colors {
+		if !steps[stepID] {
+			return fmt.Errorf("metadata.ui.colors: unknown step %q", stepID)
+		}
+		if !uiColorPattern.MatchString(color) {
+			return fmt.Errorf("metadata.ui.colors.%s: %q is not a hex color", stepID, color)
+		}
+	}
+	for stepID, icon := range ui.Icons {
+		if !steps[stepID] {
+			return fmt.Errorf("metadata.ui.icons: unknown step %q", stepID)
+		}
+		if len(icon) > maxUIIconLength || !uiIconPattern.MatchString(icon) {
+			return fmt.Errorf("metadata.ui.icons.%s: invalid icon name %q", stepID, icon)
+		}
+	}
+
+	if ui.Form == nil {
+		return nil
+	}
+	if len(ui.Form.Fields) > maxUIFormFields {
+		return fmt.Errorf("metadata.ui.form: at most %d fields are allowed", maxUIFormFields)
+	}
+
+	inputs := make(map[string]models.WorkflowInput)
+	for _, input := range parsed.Inputs {
+		inputs[input.Name] = input
+	}
+	seen := make(map[string]bool)
+	for i, field := range ui.Form.Fields {
+		if field.Name == "" {
+			return fmt.Errorf("metadata.ui.form.fields[%d]: name is required", i)
+		}
+		if seen[field.Name] {
+			return fmt.Errorf("metadata.ui.form.fields[%d]: duplicate field %q", i, field.Name)
+		}
+		seen[field.Name] = true
+
+		input, declared := inputs[field.Name]
+		if len(parsed.Inputs) > 0 && !declared {
+			return fmt.Errorf("metadata.ui.form.fields[%d]: %q is not a declared input", i, field.Name)
+		}
+		if field.Widget != "" && !uiWidgets[field.Widget] {
+			return fmt.Errorf("metadata.ui.form.fields[%d]: unsupported widget %q", i, field.Widget)
+		}
+		if field.Widget == "select" && len(field.Options) == 0 && len(input.Enum) == 0 {
+			return fmt.Errorf("metadata.ui.form.fields[%d]: select fields need options", i)
+		}
+	}
+
+	return nil
+}
+
+// buildLaunchForm merges the inputs declared in the schema with metadata.ui.form.
+// Without declared inputs the form fields are taken from the UI metadata alone.
+func buildLaunchForm(template *models.WorkflowTemplate) models.LaunchForm {
+	parsed := parseWorkflowSchema(template.Schema)
+	// Metadata is validated on save; templates saved before that render without hints
+	ui, _ := decodeTemplateUI(template.Metadata)
+
+	form := models.LaunchForm{
+		TemplateID:  template.ID,
+		Title:       template.Name,
+		Description: template.Description,
+		Fields:      []models.LaunchFormField{},
+	}
+
+	overrides := make(map[string]models.UIFormField)
+	if ui.Form != nil {
+		if ui.Form.Title != "" {
+			form.Title = ui.Form.Title
+		}
+		if ui.Form.Description != "" {
+			form.Description = ui.Form.Description
+		}
+		for _, field := range ui.Form.Fields {
+			overrides[field.Name] = field
+		}
+	}
+
+	inputs := parsed.Inputs
+	if len(inputs) == 0 && ui.Form != nil {
+		for _, field := range ui.Form.Fields {
+			inputs = append(inputs, models.WorkflowInput{Name: field.Name})
+		}
+	}
+
+	type orderedField struct {
+		field models.LaunchFormField
+		order int
+	}
+	var fields []orderedField
+	for _, input := range inputs {
+		override := overrides[input.Name]
+		if override.Hidden {
+			continue
+		}
+
+		field := models.LaunchFormField{
+			Name:        input.Name,
+			Label:       humanizeName(input.Name),
+			Type:        input.Type,
+			Widget:      defaultWidget(input),
+			Required:    input.Required,
+			Default:     input.Default,
+			Description: input.Description,
+			Placeholder: override.Placeholder,
+			HelpText:    override.HelpText,
+			Options:     override.Options,
+		}
+		if field.Type == "" {
+			field.Type = "string"
+		}
+		if override.Label != "" {
+			field.Label = override.Label
+		}
+		if override.Widget != "" {
+			field.Widget = override.Widget
+		}
+		if len(field.Options) == 0 {
+			for _, value := range input.Enum {
+				field.Options = append(field.Options, models.UIFormOption{Label: fmt.Sprint(value), Value: value})
+			}
+		}
+
+		fields = append(fields, orderedField{field: field, order: override.Order})
+	}
+
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].order < fields[j].order })
+	for _, f := range fields {
+		form.Fields = append(form.Fields, f.field)
+	}
+
+	return form
+}
+
+func defaultWidget(input models.WorkflowInput) string {
+	if len(input.Enum) > 0 {
+		return "select"
+	}
+	switch input.Type {
+	case "number":
+		return "number"
+	case "boolean":
+		return "checkbox"
+	case "object", "array":
+		return "json"
+	default:
+		return "text"
+	}
+}
+
+// humanizeName turns a variable name such as customer_email into "Customer email"
+func humanizeName(name string) string {
+	label := strings.TrimSpace(strings.NewReplacer("_", " ", "-", " ").Replace(name))
+	if label == "" {
+		return name
+	}
+	return strings.ToUpper(label[:1]) + label[1:]
+}
+
+func jsonSize(key string, value interface{}) int {
+	data, _ := json.Marshal(value)
+	return len(key) + len(data)
+}
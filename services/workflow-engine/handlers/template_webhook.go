@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+	"chorus/workflow-engine/models"
+)
+
+// Most deliveries listed at once
+const maxDeliveryPage = 200
+
+type TemplateWebhookHandler struct {
+	db     *gorm.DB
+	logger *logging.Logger
+}
+
+func NewTemplateWebhookHandler(db *gorm.DB, logger *logging.Logger) *TemplateWebhookHandler {
+	return &TemplateWebhookHandler{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// ListWebhooks handles GET /api/v1/templates/:id/webhooks
+func (h *TemplateWebhookHandler) ListWebhooks(c *gin.Context) {
+	template, ok := h.loadTemplate(c)
+	if !ok {
+		return
+	}
+
+	var webhooks []models.TemplateWebhook
+	if err := h.db.Where("template_id = ?", template.ID).Order("created_at ASC").Find(&webhooks).Error; err != nil {
+		h.logger.Error("Failed to fetch template webhooks", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch template webhooks", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"webhooks": webhooks,
+	})
+}
+
+// CreateWebhook handles POST /api/v1/templates/:id/webhooks
+func (h *TemplateWebhookHandler) CreateWebhook(c *gin.Context) {
+	template, ok := h.loadTemplate(c)
+	if !ok {
+		return
+	}
+
+	var req models.CreateTemplateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	webhook := models.TemplateWebhook{
+		TemplateID:           template.ID,
+		URL:                  req.URL,
+		Secret:               req.Secret,
+		Events:               models.StringList(req.Events),
+		IsActive:             true,
+		FailureWindowMinutes: 60,
+		FailureMinInstances:  10,
+		CreatedBy:            c.GetString("userID"),
+	}
+	if webhook.Events == nil {
+		webhook.Events = models.StringList{}
+	}
+	if req.FailureRate != nil {
+		applyFailureRate(&webhook, req.FailureRate)
+	}
+	if err := validateWebhook(&webhook); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid webhook", err.Error())
+		return
+	}
+	if webhook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			h.logger.Error("Failed to generate webhook secret", "error", err)
+			apierror.Abort(c, http.StatusInternalServerError, "Failed to create webhook", nil)
+			return
+		}
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+
+	if err := h.db.Create(&webhook).Error; err != nil {
+		h.logger.Error("Failed to create template webhook", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to create webhook", nil)
+		return
+	}
+
+	h.logger.Info("Template webhook created", "id", webhook.ID, "template_id", template.ID)
+	c.JSON(http.StatusCreated, models.CreateTemplateWebhookResponse{
+		TemplateWebhook: webhook,
+		Secret:          webhook.Secret,
+	})
+}
+
+// UpdateWebhook handles PUT /api/v1/templates/:id/webhooks/:webhook_id
+func (h *TemplateWebhookHandler) UpdateWebhook(c *gin.Context) {
+	webhook, ok := h.loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateTemplateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+
+	if req.URL != nil {
+		webhook.URL = *req.URL
+	}
+	if req.Events != nil {
+		webhook.Events = models.StringList(*req.Events)
+		if webhook.Events == nil {
+			webhook.Events = models.StringList{}
+		}
+	}
+	if req.IsActive != nil {
+		webhook.IsActive = *req.IsActive
+	}
+	if req.FailureRate != nil {
+		applyFailureRate(webhook, req.FailureRate)
+		webhook.FailureAlerting = false
+	}
+	if err := validateWebhook(webhook); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid webhook", err.Error())
+		return
+	}
+
+	if err := h.db.Save(webhook).Error; err != nil {
+		h.logger.Error("Failed to update template webhook", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to update webhook", nil)
+		return
+	}
+
+	h.logger.Info("Template webhook updated", "id", webhook.ID, "template_id", webhook.TemplateID)
+	c.JSON(http.StatusOK, webhook)
+}
+
+// DeleteWebhook handles DELETE /api/v1/templates/:id/webhooks/:webhook_id, removing
+// its deliveries with it
+func (h *TemplateWebhookHandler) DeleteWebhook(c *gin.Context) {
+	webhook, ok := h.loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", webhook.ID).Delete(&models.TemplateWebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(webhook).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to delete template webhook", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to delete webhook", nil)
+		return
+	}
+
+	h.logger.Info("Template webhook deleted", "id", webhook.ID, "template_id", webhook.TemplateID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Webhook deleted successfully",
+	})
+}
+
+// ListDeliveries handles GET /api/v1/templates/:id/webhooks/:webhook_id/deliveries,
+// newest first, optionally filtered by ?status= and ?event=
+func (h *TemplateWebhookHandler) ListDeliveries(c *gin.Context) {
+	webhook, ok := h.loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxDeliveryPage {
+			apierror.Abort(c, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxDeliveryPage), nil)
+			return
+		}
+		limit = parsed
+	}
+
+	query := h.db.Where("webhook_id = ?", webhook.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if event := c.Query("event"); event != "" {
+		query = query.Where("event = ?", event)
+	}
+
+	var deliveries []models.TemplateWebhookDelivery
+	if err := query.Order("created_at DESC").Limit(limit).Find(&deliveries).Error; err != nil {
+		h.logger.Error("Failed to fetch template webhook deliveries", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch deliveries", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveries,
+	})
+}
+
+// loadTemplate fetches the template of the request, which the caller must be able
+// to edit, answering the request otherwise
+func (h *TemplateWebhookHandler) loadTemplate(c *gin.Context) (*models.WorkflowTemplate, bool) {
+	templateID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid template ID", nil)
+		return nil, false
+	}
+
+	var template models.WorkflowTemplate
+	if err := h.db.First(&template, templateID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apierror.Abort(c, http.StatusNotFound, "Template not found", nil)
+			return nil, false
+		}
+		h.logger.Error("Failed to fetch template", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch template", nil)
+		return nil, false
+	}
+
+	caller := principalFrom(c)
+	if !caller.canView(&template) {
+		apierror.Abort(c, http.StatusNotFound, "Template not found", nil)
+		return nil, false
+	}
+	if !caller.canEdit(&template) {
+		apierror.Abort(c, http.StatusForbidden, "You do not have permission to manage this template's webhooks", nil)
+		return nil, false
+	}
+	return &template, true
+}
+
+// loadWebhook fetches the webhook of the request, of a template the caller can edit
+func (h *TemplateWebhookHandler) loadWebhook(c *gin.Context) (*models.TemplateWebhook, bool) {
+	template, ok := h.loadTemplate(c)
+	if !ok {
+		return nil, false
+	}
+	webhookID, err := uuid.Parse(c.Param("webhook_id"))
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid webhook ID", nil)
+		return nil, false
+	}
+
+	var webhook models.TemplateWebhook
+	if err := h.db.Where("id = ? AND template_id = ?", webhookID, template.ID).First(&webhook).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apierror.Abort(c, http.StatusNotFound, "Webhook not found", nil)
+			return nil, false
+		}
+		h.logger.Error("Failed to fetch template webhook", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch webhook", nil)
+		return nil, false
+	}
+	return &webhook, true
+}
+
+// applyFailureRate copies the failure rate settings of a request, keeping the
+// defaults for those left out
+func applyFailureRate(webhook *models.TemplateWebhook, settings *models.FailureRateSettings) {
+	webhook.FailureRateThreshold = settings.Threshold
+	if settings.WindowMinutes != 0 {
+		webhook.FailureWindowMinutes = settings.WindowMinutes
+	}
+	if settings.MinInstances != 0 {
+		webhook.FailureMinInstances = settings.MinInstances
+	}
+}
+
+// validateWebhook checks a webhook from a request
+func validateWebhook(webhook *models.TemplateWebhook) error {
+	target, err := url.Parse(webhook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	for _, event := range webhook.Events {
+		if !slices.Contains(models.TemplateEvents, event) {
+			return fmt.Errorf("unknown event %q, expected one of %v", event, models.TemplateEvents)
+		}
+	}
+	if webhook.FailureRateThreshold < 0 || webhook.FailureRateThreshold > 1 {
+		return fmt.Errorf("failure_rate.threshold must be between 0 and 1")
+	}
+	if webhook.FailureWindowMinutes < 1 || webhook.FailureWindowMinutes > 7*24*60 {
+		return fmt.Errorf("failure_rate.window_minutes must be between 1 and 10080")
+	}
+	if webhook.FailureMinInstances < 1 {
+		return fmt.Errorf("failure_rate.min_instances must be at least 1")
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+	"chorus/workflow-engine/models"
+	"chorus/workflow-engine/services"
+)
+
+type TriggerHandler struct {
+	db     *gorm.DB
+	engine *services.Engine
+	logger *logging.Logger
+}
+
+func NewTriggerHandler(db *gorm.DB, engine *services.Engine, logger *logging.Logger) *TriggerHandler {
+	return &TriggerHandler{
+		db:     db,
+		engine: engine,
+		logger: logger,
+	}
+}
+
+// GetTriggerEvaluations handles GET /api/v1/triggers/:id/evaluations
+func (h *TriggerHandler) GetTriggerEvaluations(c *gin.Context) {
+	id := c.Param("id")
+	triggerID, err := uuid.Parse(id)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid trigger ID", nil)
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	var trigger models.WorkflowTrigger
+	if err := h.db.First(&trigger, triggerID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apierror.Abort(c, http.StatusNotFound, "Trigger not found", nil)
+			return
+		}
+		h.logger.Error("Failed to fetch trigger", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch trigger", nil)
+		return
+	}
+
+	evaluations, err := h.engine.TriggerEvaluations(c.Request.Context(), triggerID, limit)
+	if err != nil {
+		h.logger.Error("Failed to fetch trigger evaluations", "trigger_id", triggerID, "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch trigger evaluations", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"trigger_id":        trigger.ID,
+		"trigger_type":      trigger.TriggerType,
+		"last_triggered_at": trigger.LastTriggeredAt,
+		"evaluations":       evaluations,
+	})
+}
+
+// Webhook slugs are URL path segments: lowercase letters, digits and dashes
+var webhookSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,98}[a-z0-9]$`)
+
+// UpdateTrigger handles PUT /api/v1/triggers/:id
+func (h *TriggerHandler) UpdateTrigger(c *gin.Context) {
+	id := c.Param("id")
+	triggerID, err := uuid.Parse(id)
+	if err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid trigger ID", nil)
+		return
+	}
+
+	var req models.UpdateTriggerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+	if req.KeepOldSlugHours < 0 {
+		apierror.Abort(c, http.StatusBadRequest, "keep_old_slug_hours must not be negative", nil)
+		return
+	}
+
+	var trigger models.WorkflowTrigger
+	if err := h.db.Preload("Template").First(&trigger, triggerID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			apierror.Abort(c, http.StatusNotFound, "Trigger not found", nil)
+			return
+		}
+		h.logger.Error("Failed to fetch trigger", "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to fetch trigger", nil)
+		return
+	}
+
+	caller := principalFrom(c)
+	if !caller.canView(&trigger.Template) {
+		apierror.Abort(c, http.StatusNotFound, "Trigger not found", nil)
+		return
+	}
+	if !caller.canEdit(&trigger.Template) {
+		apierror.Abort(c, http.StatusForbidden, "Not allowed to edit this trigger", nil)
+		return
+	}
+
+	var oldSlug, newSlug string
+	if trigger.TriggerType == models.TriggerTypeWebhook {
+		var cfg models.WebhookTriggerConfig
+		decodeJSONB(trigger.TriggerConfig, &cfg)
+		oldSlug, newSlug = cfg.Slug, cfg.Slug
+	}
+
+	if req.TriggerConfig != nil {
+		if trigger.TriggerType == models.TriggerTypeWebhook {
+			var cfg models.WebhookTriggerConfig
+			if err := decodeJSONB(*req.TriggerConfig, &cfg); err != nil {
+				apierror.Abort(c, http.StatusBadRequest, "Invalid trigger config", err.Error())
+				return
+			}
+			if cfg.Slug != "" && !webhookSlugPattern.MatchString(cfg.Slug) {
+				apierror.Abort(c, http.StatusBadRequest, "Invalid trigger config", "slug must be 2-100 lowercase letters, digits or dashes")
+				return
+			}
+			newSlug = cfg.Slug
+		}
+		if trigger.TriggerType == models.TriggerTypePresence {
+			var cfg models.PresenceTriggerConfig
+			err := decodeJSONB(*req.TriggerConfig, &cfg)
+			if err == nil {
+				err = cfg.Validate()
+			}
+			if err != nil {
+				apierror.Abort(c, http.StatusBadRequest, "Invalid trigger config", err.Error())
+				return
+			}
+		}
+		trigger.TriggerConfig = *req.TriggerConfig
+	}
+	if req.IsActive != nil {
+		trigger.IsActive = *req.IsActive
+	}
+
+	if newSlug != oldSlug && newSlug != "" {
+		taken, err := h.slugTaken(newSlug, trigger.ID)
+		if err != nil {
+			h.logger.Error("Failed to check webhook slug", "error", err)
+			apierror.Abort(c, http.StatusInternalServerError, "Failed to update trigger", nil)
+			return
+		}
+		if taken {
+			apierror.Abort(c, http.StatusConflict, fmt.Sprintf("Webhook slug %q is already in use", newSlug), nil)
+			return
+		}
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Template").Save(&trigger).Error; err != nil {
+			return err
+		}
+		if newSlug == oldSlug {
+			return nil
+		}
+
+		// The new slug no longer redirects anywhere; the old one may for a while
+		if err := tx.Delete(&models.WebhookSlugRedirect{}, "slug = ?", newSlug).Error; err != nil {
+			return err
+		}
+		if oldSlug != "" && req.KeepOldSlugHours > 0 {
+			redirect := models.WebhookSlugRedirect{
+				Slug:      oldSlug,
+				TriggerID: trigger.ID,
+				ExpiresAt: time.Now().Add(time.Duration(req.KeepOldSlugHours) * time.Hour),
+			}
+			return tx.Save(&redirect).Error
+		}
+		return nil
+	})
+	if err != nil {
+		h.logger.Error("Failed to update trigger", "trigger_id", trigger.ID, "error", err)
+		apierror.Abort(c, http.StatusInternalServerError, "Failed to update trigger", nil)
+		return
+	}
+
+	h.logger.Info("Trigger updated", "trigger_id", trigger.ID, "old_slug", oldSlug, "slug", newSlug)
+	c.JSON(http.StatusOK, trigger)
+}
+
+// slugTaken reports whether another webhook trigger uses slug, or still redirects from it
+func (h *TriggerHandler) slugTaken(slug string, triggerID uuid.UUID) (bool, error) {
+	var count int64
+	if err := h.db.Model(&models.WorkflowTrigger{}).
+		Where("trigger_type = 'webhook' AND trigger_config->>'slug' = ? AND id <> ?", slug, triggerID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+
+	if err := h.db.Model(&models.WebhookSlugRedirect{}).
+		Where("slug = ? AND trigger_id <> ? AND expires_at > ?", slug, triggerID, time.Now()).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// decodeJSONB converts a JSONB value such as a trigger_config into its typed form
+func decodeJSONB(config models.JSONB, target interface{}) error {
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, target)
+}
@@ -0,0 +1,174 @@
+// Package jsonpath selects fragments of decoded JSON with a subset of JSONPath:
+// $.a.b, $['a'], $.items[3], $.items[-1], $.items[*].sku and $.*. The leading $
+// is optional, so plain dot/bracket paths like response.items[0] work too.
+package jsonpath
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Path is a compiled path expression
+type Path struct {
+	expr     string
+	segments []segment
+}
+
+type segmentKind int
+
+const (
+	segmentKey segmentKind = iota
+	segmentIndex
+	segmentWildcard
+)
+
+type segment struct {
+	kind  segmentKind
+	key   string
+	index int
+}
+
+// Compile parses a path expression
+func Compile(expr string) (*Path, error) {
+	p := &Path{expr: expr}
+	s := strings.TrimSpace(expr)
+	s = strings.TrimPrefix(s, "$")
+
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '.':
+			i++
+			if i < len(s) && s[i] == '.' {
+				return nil, fmt.Errorf("invalid path %q: recursive descent (..) is not supported", expr)
+			}
+			end := i
+			for end < len(s) && s[end] != '.' && s[end] != '[' {
+				end++
+			}
+			name := s[i:end]
+			if name == "" {
+				return nil, fmt.Errorf("invalid path %q: empty name at offset %d", expr, i)
+			}
+			p.segments = append(p.segments, nameSegment(name))
+			i = end
+
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: unclosed [", expr)
+			}
+			seg, err := bracketSegment(s[i+1 : i+end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: %w", expr, err)
+			}
+			p.segments = append(p.segments, seg)
+			i += end + 1
+
+		default:
+			// A bare first name, as in response.items
+			if i != 0 {
+				return nil, fmt.Errorf("invalid path %q: unexpected %q at offset %d", expr, s[i], i)
+			}
+			s = "." + s
+		}
+	}
+
+	return p, nil
+}
+
+func nameSegment(name string) segment {
+	if name == "*" {
+		return segment{kind: segmentWildcard}
+	}
+	return segment{kind: segmentKey, key: name}
+}
+
+func bracketSegment(inner string) (segment, error) {
+	inner = strings.TrimSpace(inner)
+	switch {
+	case inner == "*":
+		return segment{kind: segmentWildcard}, nil
+	case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+		return segment{kind: segmentKey, key: inner[1 : len(inner)-1]}, nil
+	}
+
+	index, err := strconv.Atoi(inner)
+	if err != nil {
+		return segment{}, fmt.Errorf("expected an index, * or a quoted name in [%s]", inner)
+	}
+	return segment{kind: segmentIndex, index: index}, nil
+}
+
+// String returns the expression the path was compiled from
+func (p *Path) String() string {
+	return p.expr
+}
+
+// Definite reports whether the path selects at most one value, i.e. has no wildcards
+func (p *Path) Definite() bool {
+	for _, seg := range p.segments {
+		if seg.kind == segmentWildcard {
+			return false
+		}
+	}
+	return true
+}
+
+// Select returns the values the path matches in data, in document order for arrays
+func (p *Path) Select(data interface{}) []interface{} {
+	current := []interface{}{data}
+	for _, seg := range p.segments {
+		var next []interface{}
+		for _, value := range current {
+			next = append(next, seg.apply(value)...)
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		current = next
+	}
+	return current
+}
+
+func (seg segment) apply(value interface{}) []interface{} {
+	switch seg.kind {
+	case segmentKey:
+		if m, ok := value.(map[string]interface{}); ok {
+			if v, ok := m[seg.key]; ok {
+				return []interface{}{v}
+			}
+		}
+
+	case segmentIndex:
+		if list, ok := value.([]interface{}); ok {
+			index := seg.index
+			if index < 0 {
+				index += len(list)
+			}
+			if index >= 0 && index < len(list) {
+				return []interface{}{list[index]}
+			}
+		}
+
+	case segmentWildcard:
+		switch v := value.(type) {
+		case []interface{}:
+			return v
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			values := make([]interface{}, 0, len(v))
+			for _, key := range keys {
+				values = append(values, v[key])
+			}
+			return values
+		}
+	}
+	return nil
+}
@@ -7,15 +7,13 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // schedule time zones must resolve without a system zoneinfo
 
-	"github.com/gin-gonic/gin"
-
+	"chorus/pkg/env"
+	"chorus/pkg/logging"
+	"chorus/pkg/tracing"
+	"chorus/workflow-engine/app"
 	"chorus/workflow-engine/config"
-	"chorus/workflow-engine/db"
-	"chorus/workflow-engine/handlers"
-	"chorus/workflow-engine/middleware"
-	"chorus/workflow-engine/services"
-	"chorus/workflow-engine/utils"
 )
 
 func main() {
@@ -23,85 +21,32 @@ func main() {
 	cfg := config.LoadConfig()
 	
 	// Initialize logger
-	logger := utils.NewLogger()
+	logger := logging.NewLogger()
 	
-	// Connect to database
-	database, err := db.Connect(cfg)
+	if err := cfg.Validate(); err != nil {
+		logger.Fatal("Invalid configuration", "error", err)
+	}
+	shutdownTracing, err := tracing.Setup("workflow-engine")
 	if err != nil {
-		logger.Fatal("Failed to connect to database", "error", err)
+		logger.Fatal("Failed to set up tracing", "error", err)
+	}
+	logger.Info("Configuration loaded", "settings", env.Dump())
+	application, err := app.New(cfg, logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize", "error", err)
 	}
-	
-	// Initialize services
-	engine := services.NewEngine(database, cfg, logger)
-	
-	// Initialize handlers
-	templateHandler := handlers.NewTemplateHandler(database, logger)
-	instanceHandler := handlers.NewInstanceHandler(database, engine, logger)
 	
 	// Start workflow engine
 	go func() {
-		if err := engine.Start(); err != nil {
-			logger.Error("Failed to start workflow engine", "error", err)
+		if err := application.Start(); err != nil {
+			logger.Fatal("Failed to start workflow engine", "error", err)
 		}
 	}()
 	
-	// Setup Gin router
-	if cfg.Environment == "production" {
-		gin.SetMode(gin.ReleaseMode)
-	}
-	
-	router := gin.New()
-	router.Use(gin.Recovery())
-	router.Use(middleware.Logger(logger))
-	router.Use(middleware.CORS())
-	
-	// Health check endpoint
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status":  "healthy",
-			"service": "workflow-engine",
-			"version": "1.0.0",
-		})
-	})
-	
-	// API routes
-	v1 := router.Group("/api/v1")
-	v1.Use(middleware.Auth(cfg.JWTSecret))
-	{
-		// Template routes
-		templates := v1.Group("/templates")
-		{
-			templates.GET("", templateHandler.ListTemplates)
-			templates.POST("", templateHandler.CreateTemplate)
-			templates.GET("/:id", templateHandler.GetTemplate)
-			templates.PUT("/:id", templateHandler.UpdateTemplate)
-			templates.DELETE("/:id", templateHandler.DeleteTemplate)
-		}
-		
-		// Instance routes
-		instances := v1.Group("/instances")
-		{
-			instances.GET("", instanceHandler.ListInstances)
-			instances.POST("", instanceHandler.CreateInstance)
-			instances.GET("/:id", instanceHandler.GetInstance)
-			instances.PUT("/:id/start", instanceHandler.StartInstance)
-			instances.PUT("/:id/pause", instanceHandler.PauseInstance)
-			instances.PUT("/:id/resume", instanceHandler.ResumeInstance)
-			instances.PUT("/:id/cancel", instanceHandler.CancelInstance)
-			instances.GET("/:id/steps", instanceHandler.GetInstanceSteps)
-		}
-		
-		// Trigger routes
-		triggers := v1.Group("/triggers")
-		{
-			triggers.POST("/webhook/:template_id", instanceHandler.TriggerWebhook)
-		}
-	}
-	
 	// Create HTTP server
 	srv := &http.Server{
 		Addr:         ":" + cfg.Port,
-		Handler:      router,
+		Handler:      application.Handler,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
@@ -123,7 +68,7 @@ func main() {
 	logger.Info("Shutting down server...")
 	
 	// Stop workflow engine
-	engine.Stop()
+	application.Stop()
 	
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -133,5 +78,9 @@ func main() {
 		logger.Fatal("Server forced to shutdown", "error", err)
 	}
 	
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("Failed to flush spans", "error", err)
+	}
+	
 	logger.Info("Server exited")
 }
\ No newline at end of file
@@ -1,77 +1,140 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
-	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 
-	"chorus/workflow-engine/utils"
+	"chorus/pkg/apierror"
+	"chorus/pkg/auth"
+	"chorus/pkg/cors"
+	"chorus/pkg/logging"
 )
 
-// Auth middleware validates JWT tokens
-func Auth(secret string) gin.HandlerFunc {
+// Auth middleware validates JWT tokens, or service API keys sent as X-API-Key
+func Auth(validator *auth.Validator, apiKeys *APIKeyAuthenticator, logger *logging.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Extract token from Authorization header
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Missing authorization header",
-			})
-			c.Abort()
+		if presented := c.GetHeader("X-API-Key"); presented != "" {
+			authenticateAPIKey(c, apiKeys, presented, logger)
 			return
 		}
 
-		// Check Bearer prefix
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid authorization header format",
-			})
-			c.Abort()
+		// Validate the bearer token
+		claims, err := validator.Authenticate(c.Request)
+		if err != nil {
+			if !errors.Is(err, auth.ErrMissingToken) {
+				logger.Warn("Authentication failed",
+					"reason", auth.Reason(err),
+					"path", c.Request.URL.Path,
+					"client_ip", c.ClientIP(),
+				)
+			}
+			apierror.Abort(c, http.StatusUnauthorized, auth.Message(err), nil)
 			return
 		}
 
-		// Parse and validate token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate the alg is what we expect
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return []byte(secret), nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid token",
-			})
-			c.Abort()
+		// Add user information to context
+		c.Set("authType", AuthTypeUser)
+		c.Set("userID", claims.UserID)
+		if claims.OrgID != "" {
+			c.Set("tenantID", claims.OrgID)
+		}
+		if role := claims.Role(); role != "" {
+			c.Set("role", role)
+			c.Set("roles", claims.Roles)
+		}
+		if team := claims.String("team"); team != "" {
+			c.Set("team", team)
+		}
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), claims))
+
+		c.Next()
+	}
+}
+
+// authenticateAPIKey sets a synthetic service:<name> principal for a valid API key.
+// Keys with the delegate role may instead act as the user named in X-On-Behalf-Of,
+// and their team in X-On-Behalf-Of-Team, such as the WebSocket gateway for the users
+// it authenticated.
+func authenticateAPIKey(c *gin.Context, apiKeys *APIKeyAuthenticator, presented string, logger *logging.Logger) {
+	key, err := apiKeys.Authenticate(presented)
+	if err != nil {
+		if err != errInvalidAPIKey && err != errRevokedAPIKey {
+			logger.Error("Failed to verify API key", "error", err)
+			apierror.Abort(c, http.StatusInternalServerError, "Failed to verify API key", nil)
 			return
 		}
 
-		// Extract claims
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			// Add user information to context
-			if userID, ok := claims["user_id"].(string); ok {
-				c.Set("userID", userID)
-			}
-			if tenantID, ok := claims["tenant_id"].(string); ok {
-				c.Set("tenantID", tenantID)
-			}
-			if role, ok := claims["role"].(string); ok {
-				c.Set("role", role)
+		logger.Warn("Authentication failed",
+			"reason", err.Error(),
+			"path", c.Request.URL.Path,
+			"client_ip", c.ClientIP(),
+		)
+		apierror.Abort(c, http.StatusUnauthorized, "Invalid API key", nil)
+		return
+	}
+
+	c.Set("authType", AuthTypeService)
+	c.Set("apiKeyID", key.ID)
+
+	if onBehalfOf := c.GetHeader("X-On-Behalf-Of"); onBehalfOf != "" {
+		if apiKeys.delegateRole == "" || key.Role != apiKeys.delegateRole {
+			logger.Warn("Delegation refused",
+				"key", key.Name,
+				"path", c.Request.URL.Path,
+			)
+			apierror.Abort(c, http.StatusForbidden, "This API key may not act on behalf of users", nil)
+			return
+		}
+		// The user's own permissions apply, never the key's role
+		c.Set("userID", onBehalfOf)
+		c.Set("delegatedBy", "service:"+key.Name)
+		if team := c.GetHeader("X-On-Behalf-Of-Team"); team != "" {
+			c.Set("team", team)
+		}
+		c.Next()
+		return
+	}
+
+	c.Set("userID", "service:"+key.Name)
+	c.Set("role", key.Role)
+	c.Set("roles", []string{key.Role})
+
+	c.Next()
+}
+
+// RequireAuthType rejects requests authenticated in any other way than the given types
+func RequireAuthType(types ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authType := c.GetString("authType")
+		for _, allowed := range types {
+			if authType == allowed {
+				c.Next()
+				return
 			}
 		}
 
+		apierror.Abort(c, http.StatusForbidden, "This endpoint does not accept "+authType+" credentials", nil)
+	}
+}
+
+// RequireRole rejects requests whose principal does not have the given role
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("role") != role {
+			apierror.Abort(c, http.StatusForbidden, "Insufficient permissions", nil)
+			return
+		}
+
 		c.Next()
 	}
 }
 
 // Logger middleware logs requests
-func Logger(logger *utils.Logger) gin.HandlerFunc {
+func Logger(logger *logging.Logger) gin.HandlerFunc {
 	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		logger.Info("HTTP Request",
+		logger.InfoContext(param.Request.Context(), "HTTP Request",
 			"method", param.Method,
 			"path", param.Path,
 			"status", param.StatusCode,
@@ -83,17 +146,11 @@ func Logger(logger *utils.Logger) gin.HandlerFunc {
 	})
 }
 
-// CORS middleware handles Cross-Origin Resource Sharing
-func CORS() gin.HandlerFunc {
+// CORS middleware handles Cross-Origin Resource Sharing using the shared policy
+func CORS(policy *cors.Policy) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Tenant-ID")
-		c.Header("Access-Control-Expose-Headers", "Content-Length")
-		c.Header("Access-Control-Allow-Credentials", "true")
-
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
+		if status := policy.Apply(c.Writer.Header(), c.Request); status != 0 {
+			c.AbortWithStatus(status)
 			return
 		}
 
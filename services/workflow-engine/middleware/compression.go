@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		gz, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return gz
+	},
+}
+
+// Compression gzips responses for clients that accept it. Responses smaller than
+// minSize bytes, streaming (SSE) responses and WebSocket upgrades are passed through.
+func Compression(minSize int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !shouldCompress(c.Request) {
+			c.Next()
+			return
+		}
+
+		writer := &compressWriter{
+			ResponseWriter: c.Writer,
+			minSize:        minSize,
+			status:         c.Writer.Status(),
+		}
+		c.Writer = writer
+		c.Header("Vary", "Accept-Encoding")
+
+		c.Next()
+
+		writer.finish()
+		c.Writer = writer.ResponseWriter
+	}
+}
+
+// shouldCompress reports whether the request negotiates gzip and is not a streaming request
+func shouldCompress(r *http.Request) bool {
+	if r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+		return false
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") || strings.HasSuffix(r.URL.Path, "/stream") {
+		return false
+	}
+
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter buffers the start of a response until it knows whether the body
+// is large enough to be worth compressing
+type compressWriter struct {
+	gin.ResponseWriter
+	minSize     int
+	status      int
+	wroteHeader bool
+	buf         bytes.Buffer
+	gz          *gzip.Writer
+	passthrough bool
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	w.status = code
+	w.wroteHeader = true
+}
+
+func (w *compressWriter) WriteHeaderNow() {
+	if w.gz == nil && !w.passthrough {
+		w.startPassthrough()
+	}
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	switch {
+	case w.gz != nil:
+		return w.gz.Write(data)
+	case w.passthrough:
+		return w.ResponseWriter.Write(data)
+	}
+
+	w.buf.Write(data)
+	if w.buf.Len() >= w.minSize {
+		if err := w.startCompression(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends buffered data uncompressed; handlers that flush are streaming
+func (w *compressWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	} else if !w.passthrough {
+		w.startPassthrough()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.passthrough = true
+	return w.ResponseWriter.Hijack()
+}
+
+func (w *compressWriter) Status() int {
+	if w.gz == nil && !w.passthrough {
+		return w.status
+	}
+	return w.ResponseWriter.Status()
+}
+
+func (w *compressWriter) startCompression() error {
+	header := w.ResponseWriter.Header()
+	if header.Get("Content-Encoding") != "" || !bodyAllowed(w.status) {
+		w.startPassthrough()
+		return nil
+	}
+
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+
+	gz := gzipWriterPool.Get().(*gzip.Writer)
+	gz.Reset(w.ResponseWriter)
+	w.gz = gz
+
+	_, err := gz.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+func (w *compressWriter) startPassthrough() {
+	w.passthrough = true
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() > 0 {
+		w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+	} else {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+// finish writes out whatever is still buffered once the handler chain is done
+func (w *compressWriter) finish() {
+	switch {
+	case w.gz != nil:
+		w.gz.Close()
+		w.gz.Reset(nil)
+		gzipWriterPool.Put(w.gz)
+		w.gz = nil
+	case !w.passthrough && (w.wroteHeader || w.buf.Len() > 0):
+		w.startPassthrough()
+	}
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
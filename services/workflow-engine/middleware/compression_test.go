@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"chorus/workflow-engine/models"
+)
+
+const testMinSize = 1024
+
+// newCompressedRouter serves body for any method on any path behind Compression
+func newCompressedRouter(body []byte) *gin.Engine {
+	router := gin.New()
+	router.Use(Compression(testMinSize))
+	router.NoRoute(func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", body)
+	})
+	return router
+}
+
+func compressedRequest(router http.Handler, method, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodedBody returns the body of rec, gunzipped when it was compressed
+func decodedBody(t *testing.T, rec *httptest.ResponseRecorder) []byte {
+	t.Helper()
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		return rec.Body.Bytes()
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("gunzip: %v", err)
+	}
+	return body
+}
+
+func TestCompressionMinSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int
+		compressed bool
+	}{
+		{"empty", 0, false},
+		{"below the threshold", testMinSize - 1, false},
+		{"at the threshold", testMinSize, true},
+		{"above the threshold", 64 * testMinSize, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := bytes.Repeat([]byte("a"), tt.size)
+			rec := compressedRequest(newCompressedRouter(body), http.MethodGet, "/api/v1/instances", nil)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got %d, want 200", rec.Code)
+			}
+			if compressed := rec.Header().Get("Content-Encoding") == "gzip"; compressed != tt.compressed {
+				t.Errorf("compressed %v, want %v", compressed, tt.compressed)
+			}
+			if vary := rec.Header().Get("Vary"); vary != "Accept-Encoding" {
+				t.Errorf("Vary %q, want Accept-Encoding", vary)
+			}
+			if got := decodedBody(t, rec); !bytes.Equal(got, body) {
+				t.Errorf("body of %d bytes, want the %d sent", len(got), len(body))
+			}
+		})
+	}
+}
+
+// Requests that cannot take a gzipped body, or would stream it, are passed through
+func TestCompressionSkips(t *testing.T) {
+	body := bytes.Repeat([]byte("a"), 4*testMinSize)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header http.Header
+	}{
+		{"HEAD", http.MethodHead, "/api/v1/instances", nil},
+		{"WebSocket upgrade", http.MethodGet, "/ws", http.Header{"Upgrade": {"websocket"}}},
+		{"event stream", http.MethodGet, "/api/v1/events", http.Header{"Accept": {"text/event-stream"}}},
+		{"stream path", http.MethodGet, "/api/v1/instances/1/stream", nil},
+		{"gzip refused", http.MethodGet, "/api/v1/instances", http.Header{"Accept-Encoding": {"gzip;q=0"}}},
+		{"gzip not accepted", http.MethodGet, "/api/v1/instances", http.Header{"Accept-Encoding": {"identity"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := compressedRequest(newCompressedRouter(body), tt.method, tt.path, tt.header)
+			if encoding := rec.Header().Get("Content-Encoding"); encoding != "" {
+				t.Errorf("Content-Encoding %q, want none", encoding)
+			}
+			if tt.method != http.MethodHead && !bytes.Equal(rec.Body.Bytes(), body) {
+				t.Errorf("body of %d bytes, want the %d sent as is", rec.Body.Len(), len(body))
+			}
+		})
+	}
+}
+
+// BenchmarkCompressionListInstances measures gzipping a page of instances as
+// GET /api/v1/instances lists them, reporting the compressed size as a share of
+// the uncompressed one
+func BenchmarkCompressionListInstances(b *testing.B) {
+	template := models.WorkflowTemplate{
+		ID:         uuid.New(),
+		Name:       "order-fulfilment",
+		Version:    "1.0.0",
+		Category:   "operations",
+		Schema:     models.JSONB{"steps": []interface{}{}},
+		IsActive:   true,
+		Visibility: models.TemplateVisibilityTeam,
+		Team:       "payments",
+		CreatedBy:  "author",
+	}
+	instances := make([]models.WorkflowInstance, 50)
+	for i := range instances {
+		started := time.Date(2026, 1, 1, 0, i, 0, 0, time.UTC)
+		instances[i] = models.WorkflowInstance{
+			ID:          uuid.New(),
+			TemplateID:  template.ID,
+			Template:    template,
+			Name:        fmt.Sprintf("Order %d", 1000+i),
+			Status:      models.WorkflowStatusCompleted,
+			Context:     models.JSONB{"source": "api", "request_id": uuid.NewString()},
+			Variables:   models.JSONB{"order_id": 1000 + i, "customer": fmt.Sprintf("customer-%d", i), "total": 99.5},
+			CurrentStep: "notify",
+			StartedAt:   &started,
+			CreatedBy:   "author",
+			CreatedAt:   started,
+			UpdatedAt:   started,
+		}
+	}
+
+	router := gin.New()
+	router.Use(Compression(testMinSize))
+	router.GET("/api/v1/instances", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"data":       instances,
+			"pagination": gin.H{"page": 1, "limit": len(instances), "total": len(instances)},
+		})
+	})
+
+	raw := compressedRequest(router, http.MethodGet, "/api/v1/instances", http.Header{"Accept-Encoding": {"identity"}}).Body.Len()
+	b.SetBytes(int64(raw))
+	b.ReportAllocs()
+	b.ResetTimer()
+	var compressed int
+	for i := 0; i < b.N; i++ {
+		rec := compressedRequest(router, http.MethodGet, "/api/v1/instances", nil)
+		if rec.Header().Get("Content-Encoding") != "gzip" {
+			b.Fatal("the list was not compressed")
+		}
+		compressed = rec.Body.Len()
+	}
+	b.ReportMetric(float64(compressed)/float64(raw), "compressed/raw")
+}
@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"chorus/pkg/apierror"
+	"chorus/pkg/logging"
+	"chorus/pkg/metrics"
+	"chorus/workflow-engine/config"
+)
+
+// tokenBucketScript refills the bucket based on elapsed time, then takes one token.
+// Returns {allowed, remaining tokens, retry after (ms)}.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(data[1]) or burst
+local ts = tonumber(data[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate) + 1000)
+return {allowed, math.floor(tokens), retry}
+`)
+
+var (
+	rateLimitedTotal = metrics.Default.Counter(
+		"workflow_rate_limited_total",
+		"Requests rejected with 429 by the rate limiter",
+		"bucket",
+	)
+	rateLimiterErrorsTotal = metrics.Default.Counter(
+		"workflow_rate_limiter_errors_total",
+		"Rate limiter checks that failed open because Redis was unavailable",
+	)
+)
+
+// RateLimiter enforces per-principal token buckets stored in Redis
+type RateLimiter struct {
+	redis  redis.UniversalClient
+	config config.RateLimitConfig
+	logger *logging.Logger
+
+	// Buckets of the routes overriding the default ones, by method and route path.
+	// Written while the routes are registered, before serving.
+	overrides map[string]gin.HandlerFunc
+}
+
+func NewRateLimiter(redisClient redis.UniversalClient, cfg config.RateLimitConfig, logger *logging.Logger) *RateLimiter {
+	return &RateLimiter{
+		redis:  redisClient,
+		config: cfg,
+		logger: logger,
+
+		overrides: make(map[string]gin.HandlerFunc),
+	}
+}
+
+// Override makes the route of method and path, the full path it is registered
+// with, take its tokens from the named bucket in place of the default read or
+// write one
+func (l *RateLimiter) Override(method, path, bucket string, limit config.RateLimit) {
+	l.overrides[method+" "+path] = l.Limit(bucket, limit)
+}
+
+// Default limits reads (GET/HEAD) and writes with the configured read/write buckets,
+// or with the bucket of the route's override
+func (l *RateLimiter) Default() gin.HandlerFunc {
+	read := l.Limit("read", l.config.Read)
+	write := l.Limit("write", l.config.Write)
+
+	return func(c *gin.Context) {
+		if override, ok := l.overrides[c.Request.Method+" "+c.FullPath()]; ok {
+			override(c)
+		} else if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			read(c)
+		} else {
+			write(c)
+		}
+	}
+}
+
+// Limit applies a named bucket. Routes behind Default should use Override instead,
+// or they take a token from both buckets.
+func (l *RateLimiter) Limit(bucket string, limit config.RateLimit) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !l.config.Enabled || limit.PerMinute <= 0 {
+			c.Next()
+			return
+		}
+		if l.config.BypassRole != "" && c.GetString("role") == l.config.BypassRole {
+			c.Next()
+			return
+		}
+
+		principal := c.GetString("userID")
+		if principal == "" {
+			principal = "ip:" + c.ClientIP()
+		}
+
+		allowed, remaining, retryAfter, err := l.take(c.Request.Context(), "ratelimit:"+bucket+":"+principal, limit)
+		if err != nil {
+			// Fail open: an unavailable Redis must not take the API down with it
+			rateLimiterErrorsTotal.Inc()
+			l.logger.Warn("Rate limiter unavailable, allowing request", "bucket", bucket, "error", err)
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit.PerMinute))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+
+		if !allowed {
+			rateLimitedTotal.Inc(bucket)
+			l.logger.Warn("Rate limit exceeded", "bucket", bucket, "principal", principal, "path", c.Request.URL.Path)
+
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			apierror.Abort(c, http.StatusTooManyRequests, "Rate limit exceeded", gin.H{
+				"retry_after": math.Ceil(retryAfter.Seconds()),
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func (l *RateLimiter) take(ctx context.Context, key string, limit config.RateLimit) (bool, int, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = limit.PerMinute
+	}
+	ratePerMs := float64(limit.PerMinute) / float64(time.Minute.Milliseconds())
+
+	result, err := tokenBucketScript.Run(ctx, l.redis, []string{key}, ratePerMs, burst, time.Now().UnixMilli()).Int64Slice()
+	if err != nil {
+		return false, 0, 0, err
+	}
+
+	return result[0] == 1, int(result[1]), time.Duration(result[2]) * time.Millisecond, nil
+}
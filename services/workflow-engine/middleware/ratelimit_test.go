@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"chorus/pkg/logging"
+	"chorus/workflow-engine/config"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func testLogger() *logging.Logger {
+	return &logging.Logger{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+}
+
+// newLimitedRouter serves GET and POST /api/v1/items and POST /api/v1/items/create
+// behind a rate limiter, the latter with an override
+func newLimitedRouter(t *testing.T, redisAddr string, cfg config.RateLimitConfig) *gin.Engine {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: redisAddr, MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+
+	limiter := NewRateLimiter(client, cfg, testLogger())
+	router := gin.New()
+	v1 := router.Group("/api/v1")
+	v1.Use(func(c *gin.Context) {
+		c.Set("userID", c.GetHeader("X-User"))
+		c.Set("role", c.GetHeader("X-Role"))
+	})
+	v1.Use(limiter.Default())
+	limiter.Override(http.MethodPost, v1.BasePath()+"/items/create", "instance_create", cfg.InstanceCreate)
+
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	v1.GET("/items", ok)
+	v1.POST("/items", ok)
+	v1.POST("/items/create", ok)
+	return router
+}
+
+func testRateLimits() config.RateLimitConfig {
+	return config.RateLimitConfig{
+		Enabled:        true,
+		BypassRole:     "automation",
+		Read:           config.RateLimit{PerMinute: 60, Burst: 5},
+		Write:          config.RateLimit{PerMinute: 60, Burst: 2},
+		InstanceCreate: config.RateLimit{PerMinute: 60, Burst: 3},
+	}
+}
+
+func doRequest(router http.Handler, method, path, user, role string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("X-User", user)
+	req.Header.Set("X-Role", role)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	mr := miniredis.RunT(t)
+	router := newLimitedRouter(t, mr.Addr(), testRateLimits())
+
+	for i := 0; i < 5; i++ {
+		rec := doRequest(router, http.MethodGet, "/api/v1/items", "alice", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d of the burst: got %d, want 200", i+1, rec.Code)
+		}
+	}
+
+	rec := doRequest(router, http.MethodGet, "/api/v1/items", "alice", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request past the burst: got %d, want 429", rec.Code)
+	}
+	// One token a second comes back at 60 a minute
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+	if got := rec.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 0", got)
+	}
+
+	// Buckets are per principal
+	if rec := doRequest(router, http.MethodGet, "/api/v1/items", "bob", ""); rec.Code != http.StatusOK {
+		t.Errorf("other principal: got %d, want 200", rec.Code)
+	}
+	// and per bucket
+	if rec := doRequest(router, http.MethodPost, "/api/v1/items", "alice", ""); rec.Code != http.StatusOK {
+		t.Errorf("write after reads: got %d, want 200", rec.Code)
+	}
+}
+
+func TestRateLimiterOverrideReplacesDefault(t *testing.T) {
+	mr := miniredis.RunT(t)
+	router := newLimitedRouter(t, mr.Addr(), testRateLimits())
+
+	// The override's burst of 3 applies, not the write burst of 2
+	for i := 0; i < 3; i++ {
+		rec := doRequest(router, http.MethodPost, "/api/v1/items/create", "alice", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d of the override burst: got %d, want 200", i+1, rec.Code)
+		}
+	}
+	if rec := doRequest(router, http.MethodPost, "/api/v1/items/create", "alice", ""); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request past the override burst: got %d, want 429", rec.Code)
+	}
+
+	// and the write bucket was left alone
+	if mr.Exists("ratelimit:write:alice") {
+		t.Error("the overridden route took a token from the write bucket")
+	}
+	for i := 0; i < 2; i++ {
+		if rec := doRequest(router, http.MethodPost, "/api/v1/items", "alice", ""); rec.Code != http.StatusOK {
+			t.Fatalf("write %d: got %d, want 200", i+1, rec.Code)
+		}
+	}
+}
+
+func TestRateLimiterBypassRole(t *testing.T) {
+	mr := miniredis.RunT(t)
+	router := newLimitedRouter(t, mr.Addr(), testRateLimits())
+
+	for i := 0; i < 10; i++ {
+		if rec := doRequest(router, http.MethodPost, "/api/v1/items", "bot", "automation"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d with the bypass role: got %d, want 200", i+1, rec.Code)
+		}
+	}
+}
+
+func TestRateLimiterFailsOpenWithoutRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+	router := newLimitedRouter(t, addr, testRateLimits())
+
+	before := rateLimiterErrorsTotal.Value()
+	for i := 0; i < 10; i++ {
+		rec := doRequest(router, http.MethodPost, "/api/v1/items", "alice", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d with Redis down: got %d, want 200", i+1, rec.Code)
+		}
+		if rec.Header().Get("X-RateLimit-Limit") != "" {
+			t.Fatal("rate limit headers set although no bucket was checked")
+		}
+	}
+	if got := rateLimiterErrorsTotal.Value() - before; got != 10 {
+		t.Errorf("workflow_rate_limiter_errors_total grew by %v, want 10", got)
+	}
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey is a credential used by internal services to call the API without a user JWT.
+// Only the SHA-256 hash of the key is stored; Prefix identifies the key for lookup.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	Name       string     `json:"name" gorm:"not null"`
+	Prefix     string     `json:"prefix" gorm:"not null;uniqueIndex"`
+	KeyHash    string     `json:"-" gorm:"not null"`
+	Role       string     `json:"role" gorm:"not null;default:service"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+func (APIKey) TableName() string {
+	return "workflow.api_keys"
+}
+
+type CreateAPIKeyRequest struct {
+	Name string `json:"name" binding:"required"`
+	Role string `json:"role"`
+}
+
+// CreateAPIKeyResponse carries the plaintext key, which is only ever returned once
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
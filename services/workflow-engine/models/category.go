@@ -0,0 +1,60 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TemplateCategory is a managed category templates are filed under. Templates store
+// its slug, the normalized form of its name, so "Billing" and "billing" are one
+// category.
+type TemplateCategory struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	Slug      string    `json:"slug" gorm:"not null;uniqueIndex"`
+	Name      string    `json:"name" gorm:"not null"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (TemplateCategory) TableName() string {
+	return "workflow.template_categories"
+}
+
+// Longest category slug
+const MaxCategorySlugLength = 100
+
+var categorySeparators = regexp.MustCompile(`[^a-z0-9]+`)
+
+// CategorySlug normalizes a category name: lowercase letters and digits, with runs
+// of anything else replaced by a dash and no leading or trailing dash
+func CategorySlug(name string) string {
+	slug := categorySeparators.ReplaceAllString(strings.ToLower(strings.TrimSpace(name)), "-")
+	return strings.Trim(slug, "-")
+}
+
+// TemplateCategoryResponse is a category with the number of templates filed under
+// it that the caller can see
+type TemplateCategoryResponse struct {
+	TemplateCategory
+	TemplateCount int64 `json:"template_count"`
+}
+
+type CreateCategoryRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type RenameCategoryRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// RenameCategoryResponse is a renamed category, with the templates moved to its
+// new slug
+type RenameCategoryResponse struct {
+	TemplateCategory
+	PreviousSlug   string `json:"previous_slug"`
+	TemplatesMoved int64  `json:"templates_moved"`
+}
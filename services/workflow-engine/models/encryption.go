@@ -0,0 +1,297 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"chorus/workflow-engine/encryption"
+)
+
+// EncryptedKey holds the envelope of the sealed part of a JSONB value. A value
+// without it is plaintext, so rows written before their template opted in, or
+// before encryption was configured, read as they are.
+const EncryptedKey = "_encrypted"
+
+// Keys of step output data left out of encryption: engine bookkeeping the engine
+// queries in SQL, holding IDs and statuses rather than workflow data
+var clearOutputKeys = map[string]bool{
+	"presence_wait":     true,
+	"manual_task":       true,
+	"presence_override": true,
+}
+
+// errNoCipher is returned for encrypted data while no master keys are configured
+var errNoCipher = errors.New("encrypted data cannot be read or written: no encryption keys configured")
+
+// dataCipher seals the JSONB fields of encrypted rows; nil while encryption is not
+// configured
+var dataCipher *encryption.Cipher
+
+// SetCipher sets the cipher the rows of templates with encrypt_variables are
+// sealed and opened with, before the database is used
+func SetCipher(c *encryption.Cipher) {
+	dataCipher = c
+}
+
+// EncryptionConfigured reports whether a cipher is set
+func EncryptionConfigured() bool {
+	return dataCipher != nil
+}
+
+// ActiveKeyID names the master key rows are sealed under, "" while encryption is
+// not configured
+func ActiveKeyID() string {
+	if dataCipher == nil {
+		return ""
+	}
+	return dataCipher.ActiveKeyID()
+}
+
+// Set on a query with WithoutDecryption
+const skipDecryptionKey = "chorus:skip_decryption"
+
+// WithoutDecryption returns db for queries that do not return the encrypted fields
+// they load, such as list projections. Sealed fields are left empty rather than
+// opened.
+func WithoutDecryption(db *gorm.DB) *gorm.DB {
+	return db.Set(skipDecryptionKey, true)
+}
+
+// sealJSONB encrypts value under the active key, leaving the keys clear returns
+// true for in the clear beside the envelope. field is authenticated with it, so a
+// sealed value cannot be moved to another column. A sealed value is returned as is.
+func sealJSONB(ctx context.Context, field string, value JSONB, clear func(string) bool) (JSONB, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if _, sealed := value[EncryptedKey]; sealed {
+		return value, nil
+	}
+	if dataCipher == nil {
+		return nil, errNoCipher
+	}
+
+	sealed := make(JSONB)
+	secret := make(JSONB, len(value))
+	for key, item := range value {
+		if clear != nil && clear(key) {
+			sealed[key] = item
+		} else {
+			secret[key] = item
+		}
+	}
+	plaintext, err := json.Marshal(secret)
+	if err != nil {
+		return nil, err
+	}
+	envelope, err := dataCipher.Seal(ctx, plaintext, []byte(field))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt %s: %w", field, err)
+	}
+	sealed[EncryptedKey] = envelope
+	return sealed, nil
+}
+
+// openJSONB decrypts a value sealed by sealJSONB; plaintext is returned as is
+func openJSONB(ctx context.Context, field string, value JSONB) (JSONB, error) {
+	raw, sealed := value[EncryptedKey]
+	if !sealed {
+		return value, nil
+	}
+	if dataCipher == nil {
+		return nil, errNoCipher
+	}
+
+	var envelope encryption.Envelope
+	if stored, err := json.Marshal(raw); err != nil || json.Unmarshal(stored, &envelope) != nil {
+		return nil, fmt.Errorf("invalid envelope in %s", field)
+	}
+	plaintext, err := dataCipher.Open(ctx, &envelope, []byte(field))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", field, err)
+	}
+	var opened JSONB
+	if err := json.Unmarshal(plaintext, &opened); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", field, err)
+	}
+	for key, item := range value {
+		if key != EncryptedKey {
+			opened[key] = item
+		}
+	}
+	return opened, nil
+}
+
+// sealedField is a JSONB field of a row, with whether some of it stays in the clear
+type sealedField struct {
+	name  string
+	value *JSONB
+	clear func(string) bool
+}
+
+// sealFields encrypts fields in place, returning their plaintext for restoreFields
+func sealFields(ctx context.Context, fields []sealedField) ([]JSONB, error) {
+	plain := make([]JSONB, len(fields))
+	for i, field := range fields {
+		plain[i] = *field.value
+		sealed, err := sealJSONB(ctx, field.name, *field.value, field.clear)
+		if err != nil {
+			restoreFields(fields, plain[:i])
+			return nil, err
+		}
+		*field.value = sealed
+	}
+	return plain, nil
+}
+
+// restoreFields puts back the plaintext of fields sealed by sealFields
+func restoreFields(fields []sealedField, plain []JSONB) {
+	for i := range plain {
+		*fields[i].value = plain[i]
+	}
+}
+
+// openFields decrypts fields in place, or empties the sealed ones when the query
+// was made WithoutDecryption
+func openFields(tx *gorm.DB, fields []sealedField) error {
+	_, skip := tx.Get(skipDecryptionKey)
+	for _, field := range fields {
+		if skip {
+			if _, sealed := (*field.value)[EncryptedKey]; sealed {
+				*field.value = nil
+			}
+			continue
+		}
+		opened, err := openJSONB(tx.Statement.Context, field.name, *field.value)
+		if err != nil {
+			return err
+		}
+		*field.value = opened
+	}
+	return nil
+}
+
+// writesRow reports whether a statement writes the fields of its model, as Create
+// and Save do, rather than columns listed in a map
+func writesRow(tx *gorm.DB) bool {
+	_, columns := tx.Statement.Dest.(map[string]interface{})
+	return !columns
+}
+
+func (i *WorkflowInstance) sealedFields() []sealedField {
+	return []sealedField{
+		{name: "context", value: &i.Context},
+		{name: "variables", value: &i.Variables},
+	}
+}
+
+// BeforeSave encrypts the context and variables of an encrypted instance; AfterSave
+// puts their plaintext back
+func (i *WorkflowInstance) BeforeSave(tx *gorm.DB) error {
+	if !i.Encrypted || !writesRow(tx) {
+		return nil
+	}
+	plain, err := sealFields(tx.Statement.Context, i.sealedFields())
+	if err != nil {
+		return err
+	}
+	i.plaintext = plain
+	i.EncryptionKeyID = dataCipher.ActiveKeyID()
+	return nil
+}
+
+func (i *WorkflowInstance) AfterSave(tx *gorm.DB) error {
+	if i.plaintext != nil {
+		restoreFields(i.sealedFields(), i.plaintext)
+		i.plaintext = nil
+	}
+	return nil
+}
+
+func (i *WorkflowInstance) AfterFind(tx *gorm.DB) error {
+	return openFields(tx, i.sealedFields())
+}
+
+func (s *WorkflowStep) sealedFields() []sealedField {
+	return []sealedField{
+		{name: "input_data", value: &s.InputData},
+		{name: "output_data", value: &s.OutputData, clear: func(key string) bool { return clearOutputKeys[key] }},
+	}
+}
+
+// attemptFields are the input snapshots of a step's attempts
+func (s *WorkflowStep) attemptFields() []sealedField {
+	fields := make([]sealedField, len(s.Attempts))
+	for i := range s.Attempts {
+		fields[i] = sealedField{name: "attempts", value: &s.Attempts[i].Inputs}
+	}
+	return fields
+}
+
+// BeforeSave encrypts the input and output data and the attempt inputs of the step
+// of an encrypted instance; AfterSave puts their plaintext back
+func (s *WorkflowStep) BeforeSave(tx *gorm.DB) error {
+	if !s.Encrypted || !writesRow(tx) {
+		return nil
+	}
+	fields := append(s.sealedFields(), s.attemptFields()...)
+	plain, err := sealFields(tx.Statement.Context, fields)
+	if err != nil {
+		return err
+	}
+	s.plaintext = plain
+	s.EncryptionKeyID = dataCipher.ActiveKeyID()
+	return nil
+}
+
+func (s *WorkflowStep) AfterSave(tx *gorm.DB) error {
+	if s.plaintext != nil {
+		restoreFields(append(s.sealedFields(), s.attemptFields()...), s.plaintext)
+		s.plaintext = nil
+	}
+	return nil
+}
+
+func (s *WorkflowStep) AfterFind(tx *gorm.DB) error {
+	return openFields(tx, append(s.sealedFields(), s.attemptFields()...))
+}
+
+func (p *StepPayload) sealedFields() []sealedField {
+	return []sealedField{{name: "payload", value: &p.Data}}
+}
+
+// BeforeSave encrypts the data of a payload offloaded from an encrypted step
+func (p *StepPayload) BeforeSave(tx *gorm.DB) error {
+	if !p.Encrypted || !writesRow(tx) {
+		return nil
+	}
+	plain, err := sealFields(tx.Statement.Context, p.sealedFields())
+	if err != nil {
+		return err
+	}
+	p.plaintext = plain
+	p.EncryptionKeyID = dataCipher.ActiveKeyID()
+	return nil
+}
+
+func (p *StepPayload) AfterSave(tx *gorm.DB) error {
+	if p.plaintext != nil {
+		restoreFields(p.sealedFields(), p.plaintext)
+		p.plaintext = nil
+	}
+	return nil
+}
+
+func (p *StepPayload) AfterFind(tx *gorm.DB) error {
+	return openFields(tx, p.sealedFields())
+}
+
+// SealVariables encrypts variables for an update of the variables column of an
+// encrypted instance, which the instance's hooks do not see
+func SealVariables(ctx context.Context, variables JSONB) (JSONB, error) {
+	return sealJSONB(ctx, "variables", variables, nil)
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EngineEvent is an event published on workflow:events, kept for
+// EVENT_RETENTION_HOURS so it can be replayed to consumers that missed it
+type EngineEvent struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;index:idx_engine_events_created_at,priority:2"` // the event's event_id
+	Type       string     `json:"type" gorm:"not null"`
+	InstanceID *uuid.UUID `json:"instance_id,omitempty" gorm:"type:uuid"`
+	TemplateID *uuid.UUID `json:"template_id,omitempty" gorm:"type:uuid"` // when the event names it
+	Payload    JSONB      `json:"payload" gorm:"type:jsonb;not null"`     // the event as published, without the envelope
+	CreatedAt  time.Time  `json:"created_at" gorm:"index:idx_engine_events_created_at,priority:1"`
+}
+
+func (EngineEvent) TableName() string {
+	return "workflow.engine_events"
+}
+
+type EventReplayStatus string
+
+const (
+	EventReplayRunning   EventReplayStatus = "running"
+	EventReplayCompleted EventReplayStatus = "completed"
+	EventReplayFailed    EventReplayStatus = "failed"
+	EventReplayCancelled EventReplayStatus = "cancelled" // by the engine stopping
+)
+
+// EventReplayFilter selects the kept events a replay republishes
+type EventReplayFilter struct {
+	From        time.Time   `json:"from"`
+	To          time.Time   `json:"to"`
+	EventTypes  []string    `json:"event_types,omitempty"`
+	TemplateIDs []uuid.UUID `json:"template_ids,omitempty"`
+}
+
+func (f EventReplayFilter) Value() (driver.Value, error) {
+	return json.Marshal(f)
+}
+
+func (f *EventReplayFilter) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(bytes, f)
+}
+
+// EventReplay is a job republishing kept events, with its progress
+type EventReplay struct {
+	ID          uuid.UUID         `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	Status      EventReplayStatus `json:"status" gorm:"not null"`
+	Filter      EventReplayFilter `json:"filter" gorm:"type:jsonb;not null"`
+	Matched     int64             `json:"matched"`  // events matching the filter when it started
+	Replayed    int64             `json:"replayed"` // events republished so far
+	Error       string            `json:"error,omitempty"`
+	RequestedBy string            `json:"requested_by"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+}
+
+func (EventReplay) TableName() string {
+	return "workflow.event_replays"
+}
+
+// ReplayEventsRequest asks to republish the kept events of a time range, optionally
+// of some types or templates only
+type ReplayEventsRequest struct {
+	From        time.Time   `json:"from" binding:"required"`
+	To          *time.Time  `json:"to"` // now by default
+	EventTypes  []string    `json:"event_types"`
+	TemplateIDs []uuid.UUID `json:"template_ids"`
+	DryRun      bool        `json:"dry_run"` // only count the matching events
+}
+
+// ReplayEventsDryRunResponse is the answer to a dry run
+type ReplayEventsDryRunResponse struct {
+	DryRun  bool              `json:"dry_run"`
+	Filter  EventReplayFilter `json:"filter"`
+	Matched int64             `json:"matched"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// TemplateNotifications is the "notifications" section of a template's metadata,
+// choosing the outcomes of its instances pushed to the user who started them
+type TemplateNotifications struct {
+	OnComplete bool `json:"on_complete,omitempty"`
+	OnFailure  bool `json:"on_failure,omitempty"`
+
+	// Instance variables sent as the outputs of the workflow
+	Outputs []string `json:"outputs,omitempty"`
+
+	// Address emailed when the user has no live connection; placeholders such as
+	// {{ requester_email }} are resolved against the instance
+	FallbackEmail string `json:"fallback_email,omitempty"`
+}
+
+// DecodeTemplateNotifications decodes metadata.notifications, failing on values of
+// the wrong type
+func DecodeTemplateNotifications(metadata JSONB) (TemplateNotifications, error) {
+	var notifications TemplateNotifications
+	raw, ok := metadata["notifications"]
+	if !ok || raw == nil {
+		return notifications, nil
+	}
+	if _, isObject := raw.(map[string]interface{}); !isObject {
+		return notifications, fmt.Errorf("metadata.notifications must be an object")
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return notifications, err
+	}
+	if err := json.Unmarshal(data, &notifications); err != nil {
+		return notifications, fmt.Errorf("metadata.notifications: %v", err)
+	}
+	return notifications, nil
+}
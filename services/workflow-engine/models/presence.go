@@ -0,0 +1,69 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Statuses a presence trigger's transition may name, "*" matching any
+var presenceTransitionStatuses = []string{"online", "away", "busy", "dnd", "offline", "*"}
+
+// PresenceTriggerConfig is the trigger_config of a presence trigger
+type PresenceTriggerConfig struct {
+	// "from→to" (or "from->to"), either side a status or "*"
+	Transition string `json:"transition"`
+
+	// Users watched: those listed, and the members of the Redis set
+	// workflow:labels:<label>; every user without either
+	UserIDs []string `json:"user_ids,omitempty"`
+	Label   string   `json:"label,omitempty"`
+
+	// Per user, after an instance was started for them
+	CooldownSeconds int `json:"cooldown_seconds,omitempty"`
+}
+
+// transition returns the statuses of the transition, lowercased
+func (c PresenceTriggerConfig) transition() (from, to string, err error) {
+	from, to, ok := strings.Cut(c.Transition, "→")
+	if !ok {
+		from, to, ok = strings.Cut(c.Transition, "->")
+	}
+	if !ok {
+		return "", "", fmt.Errorf("transition must look like offline→online, not %q", c.Transition)
+	}
+	from, to = strings.ToLower(strings.TrimSpace(from)), strings.ToLower(strings.TrimSpace(to))
+	for _, status := range []string{from, to} {
+		if !slices.Contains(presenceTransitionStatuses, status) {
+			return "", "", fmt.Errorf("unknown presence status %q in transition", status)
+		}
+	}
+	return from, to, nil
+}
+
+// Validate checks the transition and the cooldown
+func (c PresenceTriggerConfig) Validate() error {
+	if _, _, err := c.transition(); err != nil {
+		return err
+	}
+	if c.CooldownSeconds < 0 {
+		return fmt.Errorf("cooldown_seconds must not be negative")
+	}
+	return nil
+}
+
+// Matches reports whether a change from oldStatus to newStatus fits the transition.
+// An unknown old status counts as offline, and a status kept never matches.
+func (c PresenceTriggerConfig) Matches(oldStatus, newStatus string) bool {
+	from, to, err := c.transition()
+	if err != nil {
+		return false
+	}
+	if oldStatus == "" {
+		oldStatus = "offline"
+	}
+	if oldStatus == newStatus {
+		return false
+	}
+	return (from == "*" || from == oldStatus) && (to == "*" || to == newStatus)
+}
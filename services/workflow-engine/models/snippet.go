@@ -0,0 +1,117 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Step type of a schema entry that references a snippet. Such entries only exist
+// in requests; they are expanded into the snippet's steps before a template is stored.
+const StepTypeSnippet StepType = "snippet"
+
+// WorkflowSnippet is a reusable list of steps with declared parameters. Template
+// schemas reference a snippet by name and version, and the reference is expanded
+// when the template is saved, so changing a snippet never changes stored templates.
+type WorkflowSnippet struct {
+	ID          uuid.UUID         `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	Name        string            `json:"name" gorm:"not null;uniqueIndex:idx_snippet_name_version"`
+	Version     string            `json:"version" gorm:"not null;default:1.0.0;uniqueIndex:idx_snippet_name_version"`
+	Description string            `json:"description"`
+	Parameters  SnippetParameters `json:"parameters" gorm:"type:jsonb;default:'[]'"`
+	Steps       SnippetSteps      `json:"steps" gorm:"type:jsonb;not null"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	CreatedBy   string            `json:"created_by"`
+}
+
+func (WorkflowSnippet) TableName() string {
+	return "workflow.snippets"
+}
+
+// SnippetParameters is stored as a JSONB array. Parameters are declared like
+// template inputs and referenced in step configs as {{ params.name }}.
+type SnippetParameters []WorkflowInput
+
+func (p SnippetParameters) Value() (driver.Value, error) {
+	if p == nil {
+		return json.Marshal([]WorkflowInput{})
+	}
+	return json.Marshal(p)
+}
+
+func (p *SnippetParameters) Scan(value interface{}) error {
+	if value == nil {
+		*p = nil
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+
+	return json.Unmarshal(bytes, p)
+}
+
+// SnippetSteps is stored as a JSONB array of raw step definitions, which may
+// themselves reference other snippets
+type SnippetSteps []map[string]interface{}
+
+func (s SnippetSteps) Value() (driver.Value, error) {
+	if s == nil {
+		return json.Marshal([]map[string]interface{}{})
+	}
+	return json.Marshal(s)
+}
+
+func (s *SnippetSteps) Scan(value interface{}) error {
+	if value == nil {
+		*s = nil
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+
+	return json.Unmarshal(bytes, s)
+}
+
+// SnippetReference is a schema step of type "snippet"
+type SnippetReference struct {
+	ID        string                 `json:"id"` // prefix of the expanded step IDs
+	Snippet   string                 `json:"snippet"`
+	Version   string                 `json:"version,omitempty"` // latest when empty
+	With      map[string]interface{} `json:"with,omitempty"`
+	NextSteps []string               `json:"next_steps,omitempty"`
+	Fork      bool                   `json:"fork,omitempty"` // of the snippet's last steps, to NextSteps
+}
+
+// SnippetProvenance records one expanded reference in the template's metadata.snippets
+type SnippetProvenance struct {
+	StepID    string                 `json:"step_id"`
+	Snippet   string                 `json:"snippet"`
+	Version   string                 `json:"version"`
+	SnippetID uuid.UUID              `json:"snippet_id"`
+	With      map[string]interface{} `json:"with,omitempty"`
+	Steps     []string               `json:"steps"`            // expanded step IDs
+	Parent    string                 `json:"parent,omitempty"` // reference step ID when nested
+}
+
+type CreateSnippetRequest struct {
+	Name        string                   `json:"name" binding:"required"`
+	Version     string                   `json:"version"`
+	Description string                   `json:"description"`
+	Parameters  []WorkflowInput          `json:"parameters"`
+	Steps       []map[string]interface{} `json:"steps" binding:"required"`
+}
+
+type UpdateSnippetRequest struct {
+	Description *string                   `json:"description"`
+	Parameters  *[]WorkflowInput          `json:"parameters"`
+	Steps       *[]map[string]interface{} `json:"steps"`
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StepTypeManual parks its instance until the assignee of the task it opens
+// approves, rejects or completes it
+const StepTypeManual StepType = "manual"
+
+// Statuses of a Task
+const (
+	TaskStatusOpen      = "open"
+	TaskStatusApproved  = "approved"
+	TaskStatusRejected  = "rejected"
+	TaskStatusCompleted = "completed"
+	TaskStatusCancelled = "cancelled" // its instance ended before anyone acted on it
+)
+
+// Actions that resolve a task, and the status each leaves it in
+const (
+	TaskActionApprove  = "approve"
+	TaskActionReject   = "reject"
+	TaskActionComplete = "complete"
+)
+
+// TaskActions lists the actions a manual step may offer, all of them by default
+var TaskActions = []string{TaskActionApprove, TaskActionReject, TaskActionComplete}
+
+// TaskActionStatus maps each action to the status it resolves a task with
+var TaskActionStatus = map[string]string{
+	TaskActionApprove:  TaskStatusApproved,
+	TaskActionReject:   TaskStatusRejected,
+	TaskActionComplete: TaskStatusCompleted,
+}
+
+// Task is the work a manual step waits for, in its assignee's inbox. Context is a
+// masked snapshot of the instance when the task was opened.
+type Task struct {
+	ID               uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	InstanceID       uuid.UUID  `json:"instance_id" gorm:"type:uuid;not null;index"`
+	TemplateID       uuid.UUID  `json:"template_id" gorm:"type:uuid;not null"`
+	StepRecordID     uuid.UUID  `json:"step_record_id" gorm:"type:uuid;not null;index"` // the workflow.steps row waiting
+	StepID           string     `json:"step_id" gorm:"not null"`                        // of the step definition
+	Title            string     `json:"title" gorm:"not null"`
+	Instructions     string     `json:"instructions,omitempty"`
+	Actions          StringList `json:"actions" gorm:"type:jsonb;default:'[]'"`
+	Assignee         string     `json:"assignee" gorm:"not null;index"`
+	FallbackAssignee string     `json:"fallback_assignee,omitempty"` // takes over once the task is overdue
+	Status           string     `json:"status" gorm:"not null;default:open;index"`
+	Context          JSONB      `json:"context" gorm:"type:jsonb;default:'{}'"`
+	DueAt            *time.Time `json:"due_at,omitempty" gorm:"index"`
+	EscalatedAt      *time.Time `json:"escalated_at,omitempty"`
+	ResolvedBy       string     `json:"resolved_by,omitempty"`
+	ResolvedAt       *time.Time `json:"resolved_at,omitempty"`
+	Comment          string     `json:"comment,omitempty"`
+	Data             JSONB      `json:"data,omitempty" gorm:"type:jsonb"` // submitted when resolving it
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+func (Task) TableName() string {
+	return "workflow.tasks"
+}
+
+// Events of a task's audit trail
+const (
+	TaskEventCreated    = "created"
+	TaskEventReassigned = "reassigned"
+	TaskEventEscalated  = "escalated"
+	TaskEventResolved   = "resolved"
+	TaskEventCancelled  = "cancelled"
+)
+
+// TaskEvent records who did what to a task. The engine acts as "system".
+type TaskEvent struct {
+	ID           uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	TaskID       uuid.UUID `json:"task_id" gorm:"type:uuid;not null;index"`
+	Event        string    `json:"event" gorm:"not null"`
+	Actor        string    `json:"actor" gorm:"not null"`
+	FromAssignee string    `json:"from_assignee,omitempty"`
+	ToAssignee   string    `json:"to_assignee,omitempty"`
+	Status       string    `json:"status,omitempty"` // of a resolved task
+	Reason       string    `json:"reason,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (TaskEvent) TableName() string {
+	return "workflow.task_events"
+}
+
+// TaskLinks are the API paths acting on an open task
+type TaskLinks struct {
+	Approve  string `json:"approve,omitempty"`
+	Reject   string `json:"reject,omitempty"`
+	Complete string `json:"complete,omitempty"`
+	Reassign string `json:"reassign"`
+}
+
+// TaskResponse is a task as listed in an inbox
+type TaskResponse struct {
+	Task
+	Overdue bool       `json:"overdue"`
+	Links   *TaskLinks `json:"links,omitempty"` // open tasks only
+}
+
+// TaskDetailResponse is a task with its audit trail
+type TaskDetailResponse struct {
+	TaskResponse
+	Events []TaskEvent `json:"events"`
+}
+
+type ResolveTaskRequest struct {
+	Comment string `json:"comment"`
+	Data    JSONB  `json:"data"`
+}
+
+type ReassignTaskRequest struct {
+	Assignee string `json:"assignee" binding:"required"`
+	Reason   string `json:"reason"`
+}
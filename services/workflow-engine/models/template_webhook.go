@@ -0,0 +1,114 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Template lifecycle events sent to template webhooks
+const (
+	TemplateEventCreated     = "template.created"
+	TemplateEventUpdated     = "template.updated"
+	TemplateEventPublished   = "template.published" // an inactive template was activated
+	TemplateEventDeleted     = "template.deleted"
+	TemplateEventFailureRate = "template.failure_rate" // the failure rate of its instances crossed the webhook's threshold
+)
+
+// TemplateEvents lists the events a webhook may filter on
+var TemplateEvents = []string{
+	TemplateEventCreated,
+	TemplateEventUpdated,
+	TemplateEventPublished,
+	TemplateEventDeleted,
+	TemplateEventFailureRate,
+}
+
+// TemplateWebhook notifies an external system of the lifecycle events of a
+// template. Deliveries are signed with Secret, which is only returned when the
+// webhook is created.
+type TemplateWebhook struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	TemplateID uuid.UUID  `json:"template_id" gorm:"type:uuid;not null;index"`
+	URL        string     `json:"url" gorm:"not null"`
+	Secret     string     `json:"-" gorm:"not null"`
+	Events     StringList `json:"events" gorm:"type:jsonb;default:'[]'"` // empty for all of them
+	IsActive   bool       `json:"is_active" gorm:"default:true"`
+
+	// template.failure_rate fires when at least FailureMinInstances instances
+	// finished within the last FailureWindowMinutes and the share of them that
+	// failed reaches FailureRateThreshold; 0 disables it. It fires again only after
+	// the rate fell back below the threshold.
+	FailureRateThreshold float64 `json:"failure_rate_threshold"`
+	FailureWindowMinutes int     `json:"failure_window_minutes" gorm:"default:60"`
+	FailureMinInstances  int     `json:"failure_min_instances" gorm:"default:10"`
+	FailureAlerting      bool    `json:"failure_alerting" gorm:"default:false"` // the rate is above the threshold
+
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (TemplateWebhook) TableName() string {
+	return "workflow.template_webhooks"
+}
+
+// Wants reports whether the webhook is sent event
+func (w *TemplateWebhook) Wants(event string) bool {
+	return w.IsActive && (len(w.Events) == 0 || w.Events.Contains(event))
+}
+
+// Statuses of a TemplateWebhookDelivery
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusFailed    = "failed" // every attempt failed
+)
+
+// TemplateWebhookDelivery records one event sent, or being sent, to a webhook
+type TemplateWebhookDelivery struct {
+	ID             uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	WebhookID      uuid.UUID  `json:"webhook_id" gorm:"type:uuid;not null;index"`
+	TemplateID     uuid.UUID  `json:"template_id" gorm:"type:uuid;not null"`
+	Event          string     `json:"event" gorm:"not null"`
+	Payload        JSONB      `json:"payload" gorm:"type:jsonb;not null"`
+	Status         string     `json:"status" gorm:"not null;default:pending;index"`
+	Attempts       int        `json:"attempts" gorm:"default:0"`
+	ResponseStatus int        `json:"response_status,omitempty"` // of the last attempt
+	Error          string     `json:"error,omitempty"`           // of the last attempt
+	LeaseUntil     time.Time  `json:"-" gorm:"index"`            // a pending delivery is being sent until then
+	CreatedAt      time.Time  `json:"created_at"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+}
+
+func (TemplateWebhookDelivery) TableName() string {
+	return "workflow.template_webhook_deliveries"
+}
+
+// FailureRateSettings are the failure rate fields of a webhook request
+type FailureRateSettings struct {
+	Threshold     float64 `json:"threshold"` // 0 to 1, 0 disabling template.failure_rate
+	WindowMinutes int     `json:"window_minutes"`
+	MinInstances  int     `json:"min_instances"`
+}
+
+type CreateTemplateWebhookRequest struct {
+	URL         string               `json:"url" binding:"required"`
+	Secret      string               `json:"secret"` // generated when empty
+	Events      []string             `json:"events"`
+	FailureRate *FailureRateSettings `json:"failure_rate"`
+}
+
+type UpdateTemplateWebhookRequest struct {
+	URL         *string              `json:"url"`
+	Events      *[]string            `json:"events"`
+	IsActive    *bool                `json:"is_active"`
+	FailureRate *FailureRateSettings `json:"failure_rate"`
+}
+
+// CreateTemplateWebhookResponse carries the secret, which is only ever returned once
+type CreateTemplateWebhookResponse struct {
+	TemplateWebhook
+	Secret string `json:"secret"`
+}
@@ -29,6 +29,36 @@ func (j *JSONB) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, j)
 }
 
+// Clone copies j and the objects and arrays nested in it, so that changes to the
+// copy at any depth leave j alone. Values of other types are shared.
+func (j JSONB) Clone() JSONB {
+	if j == nil {
+		return nil
+	}
+	return cloneJSONValue(map[string]interface{}(j)).(map[string]interface{})
+}
+
+func cloneJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		clone := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			clone[key] = cloneJSONValue(item)
+		}
+		return clone
+	case JSONB:
+		return v.Clone()
+	case []interface{}:
+		clone := make([]interface{}, len(v))
+		for i, item := range v {
+			clone[i] = cloneJSONValue(item)
+		}
+		return clone
+	default:
+		return value
+	}
+}
+
 // WorkflowTemplate represents a workflow template
 type WorkflowTemplate struct {
 	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
@@ -39,6 +69,12 @@ type WorkflowTemplate struct {
 	Schema      JSONB     `json:"schema" gorm:"type:jsonb;not null" binding:"required"`
 	Metadata    JSONB     `json:"metadata" gorm:"type:jsonb;default:'{}'"`
 	IsActive    bool      `json:"is_active" gorm:"default:true"`
+	Visibility  string     `json:"visibility" gorm:"default:public"`
+	Owners      StringList `json:"owners" gorm:"type:jsonb;default:'[]'"`
+	Team        string     `json:"team"`
+	// Instances created from the template keep their variables, context and step
+	// data encrypted at rest
+	EncryptVariables bool `json:"encrypt_variables" gorm:"default:false"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 	CreatedBy   string    `json:"created_by"`
@@ -48,6 +84,47 @@ func (WorkflowTemplate) TableName() string {
 	return "workflow.templates"
 }
 
+// Template visibility levels
+const (
+	TemplateVisibilityPrivate = "private" // owners only
+	TemplateVisibilityTeam    = "team"    // owners and members of the template's team
+	TemplateVisibilityPublic  = "public"  // every authenticated caller; only owners edit
+)
+
+// StringList is stored as a JSONB array of strings
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return json.Marshal([]string{})
+	}
+	return json.Marshal([]string(l))
+}
+
+func (l *StringList) Scan(value interface{}) error {
+	if value == nil {
+		*l = nil
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+
+	return json.Unmarshal(bytes, l)
+}
+
+// Contains reports whether the list holds s
+func (l StringList) Contains(s string) bool {
+	for _, item := range l {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 // WorkflowInstance represents a workflow instance
 type WorkflowInstance struct {
 	ID          uuid.UUID         `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
@@ -63,16 +140,89 @@ type WorkflowInstance struct {
 	CreatedAt   time.Time         `json:"created_at"`
 	UpdatedAt   time.Time         `json:"updated_at"`
 	CreatedBy   string            `json:"created_by"`
+	OrgID       string            `json:"org_id,omitempty" gorm:"index"` // of the creator's token, whose org members may follow it
+	RerunOf     *uuid.UUID        `json:"rerun_of,omitempty" gorm:"type:uuid"`
+	IsTest      bool              `json:"is_test" gorm:"default:false"` // throwaway run, left out of stats and lists
+	QueuedAt    *time.Time        `json:"queued_at"`
+	QueueWaitMs int64             `json:"-" gorm:"default:0"`
+	Timing      *InstanceTiming   `json:"timing,omitempty" gorm:"type:jsonb"`
+	TraceParent string            `json:"trace_parent,omitempty" gorm:"size:55"` // W3C traceparent of the request that created it
+	Frontier    *ExecutionFrontier `json:"frontier,omitempty" gorm:"type:jsonb"` // where its branches are, while it runs
+	Encrypted       bool   `json:"encrypted" gorm:"default:false"` // of a template with encrypt_variables when created
+	EncryptionKeyID string `json:"-"`                               // master key its context and variables are sealed under
+
+	plaintext []JSONB // of the fields sealed while it is saved
 	
 	// Relations
-	Template WorkflowTemplate `json:"template,omitempty" gorm:"foreignKey:TemplateID"`
-	Steps    []WorkflowStep   `json:"steps,omitempty" gorm:"foreignKey:InstanceID"`
+	Template WorkflowTemplate   `json:"template,omitempty" gorm:"foreignKey:TemplateID"`
+	Steps    []WorkflowStep     `json:"steps,omitempty" gorm:"foreignKey:InstanceID"`
+	Comments []InstanceComment  `json:"comments,omitempty" gorm:"foreignKey:InstanceID"`
+	Reruns   []WorkflowInstance `json:"reruns,omitempty" gorm:"foreignKey:RerunOf"`
 }
 
 func (WorkflowInstance) TableName() string {
 	return "workflow.instances"
 }
 
+// InstanceTiming breaks down where the time of a finished instance went
+type InstanceTiming struct {
+	QueueWaitMs     int64 `json:"queue_wait_ms"`
+	StepExecutionMs int64 `json:"step_execution_ms"` // excluding wait steps
+	RetryDelayMs    int64 `json:"retry_delay_ms"`
+	WaitStepMs      int64 `json:"wait_step_ms"`
+	WallClockMs     int64 `json:"wall_clock_ms"`
+	Steps           int64 `json:"steps"`
+	Retries         int64 `json:"retries"`
+}
+
+func (t InstanceTiming) Value() (driver.Value, error) {
+	return json.Marshal(t)
+}
+
+func (t *InstanceTiming) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(bytes, t)
+}
+
+// ExecutionFrontier is where a running instance is in its step graph, kept so that a
+// resumed instance continues every branch it had
+type ExecutionFrontier struct {
+	// Steps whose predecessors finished and that did not finish yet, including
+	// those running or waiting
+	Ready []string `json:"ready"`
+	// Join steps some of whose predecessors finished: predecessor to whether its
+	// edge to the join was taken, rather than left out by a condition
+	Arrived map[string]map[string]bool `json:"arrived,omitempty"`
+}
+
+func (f ExecutionFrontier) Value() (driver.Value, error) {
+	return json.Marshal(f)
+}
+
+func (f *ExecutionFrontier) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(bytes, f)
+}
+
+// InstanceComment is an operator note attached to a workflow instance
+type InstanceComment struct {
+	ID         uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	InstanceID uuid.UUID `json:"instance_id" gorm:"type:uuid;not null"`
+	Author     string    `json:"author" gorm:"not null"`
+	Body       string    `json:"body" gorm:"not null"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (InstanceComment) TableName() string {
+	return "workflow.instance_comments"
+}
+
 // WorkflowStep represents a workflow step execution
 type WorkflowStep struct {
 	ID          uuid.UUID   `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
@@ -83,11 +233,21 @@ type WorkflowStep struct {
 	InputData   JSONB       `json:"input_data" gorm:"type:jsonb;default:'{}'"`
 	OutputData  JSONB       `json:"output_data" gorm:"type:jsonb;default:'{}'"`
 	ErrorData   JSONB       `json:"error_data" gorm:"type:jsonb"`
+	// Hash of the step definition the latest attempt ran with
+	DefinitionHash string       `json:"definition_hash"`
+	Attempts       StepAttempts `json:"attempts,omitempty" gorm:"type:jsonb;default:'[]'"`
 	StartedAt   *time.Time  `json:"started_at"`
 	CompletedAt *time.Time  `json:"completed_at"`
 	RetryCount  int         `json:"retry_count" gorm:"default:0"`
+	ExecutionMs  int64      `json:"execution_ms" gorm:"default:0"`
+	RetryDelayMs int64      `json:"retry_delay_ms" gorm:"default:0"`
+	Warnings    StepWarnings `json:"warnings,omitempty" gorm:"type:jsonb;default:'[]'"`
+	Encrypted       bool   `json:"-" gorm:"default:false"` // as its instance
+	EncryptionKeyID string `json:"-"`
 	CreatedAt   time.Time   `json:"created_at"`
 	UpdatedAt   time.Time   `json:"updated_at"`
+
+	plaintext []JSONB
 	
 	// Relations
 	Instance WorkflowInstance `json:"instance,omitempty" gorm:"foreignKey:InstanceID"`
@@ -97,6 +257,100 @@ func (WorkflowStep) TableName() string {
 	return "workflow.steps"
 }
 
+// StepPayload holds a step input or output that was too large to store inline
+type StepPayload struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	StepID    uuid.UUID `json:"step_id" gorm:"type:uuid;not null"`
+	Kind      string    `json:"kind" gorm:"not null"`
+	Data      JSONB     `json:"data" gorm:"type:jsonb;not null"`
+	SizeBytes int       `json:"size_bytes" gorm:"not null"`
+	Encrypted       bool   `json:"-" gorm:"default:false"` // as its step
+	EncryptionKeyID string `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+
+	plaintext []JSONB
+}
+
+func (StepPayload) TableName() string {
+	return "workflow.step_payloads"
+}
+
+// Step payload kinds
+const (
+	PayloadKindInput  = "input"
+	PayloadKindOutput = "output"
+)
+
+// StepAttempt is the input snapshot of one execution of a step: the resolved config
+// it ran with, secrets masked
+type StepAttempt struct {
+	Attempt        int       `json:"attempt"`
+	DefinitionHash string    `json:"definition_hash"`
+	Inputs         JSONB     `json:"inputs"`
+	StartedAt      time.Time `json:"started_at"`
+}
+
+// StepAttempts is stored as a JSONB array
+type StepAttempts []StepAttempt
+
+func (a StepAttempts) Value() (driver.Value, error) {
+	if a == nil {
+		return json.Marshal([]StepAttempt{})
+	}
+	return json.Marshal(a)
+}
+
+func (a *StepAttempts) Scan(value interface{}) error {
+	if value == nil {
+		*a = nil
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+
+	return json.Unmarshal(bytes, a)
+}
+
+// StepWarning is a non-fatal annotation recorded on a step execution
+type StepWarning struct {
+	Type      string                 `json:"type"`
+	Message   string                 `json:"message"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// StepWarnings is stored as a JSONB array
+type StepWarnings []StepWarning
+
+func (w StepWarnings) Value() (driver.Value, error) {
+	if w == nil {
+		return json.Marshal([]StepWarning{})
+	}
+	return json.Marshal(w)
+}
+
+func (w *StepWarnings) Scan(value interface{}) error {
+	if value == nil {
+		*w = nil
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+
+	return json.Unmarshal(bytes, w)
+}
+
+const (
+	StepWarningSlow = "step_slow"
+	StepWarningHook = "step_hook" // annotation of a step hook
+)
+
 // WorkflowTrigger represents a workflow trigger
 type WorkflowTrigger struct {
 	ID              uuid.UUID     `json:"id" gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
@@ -105,6 +359,7 @@ type WorkflowTrigger struct {
 	TriggerConfig   JSONB         `json:"trigger_config" gorm:"type:jsonb;not null"`
 	IsActive        bool          `json:"is_active" gorm:"default:true"`
 	LastTriggeredAt *time.Time    `json:"last_triggered_at"`
+	Metadata        JSONB         `json:"metadata" gorm:"type:jsonb;default:'{}'"`
 	CreatedAt       time.Time     `json:"created_at"`
 	UpdatedAt       time.Time     `json:"updated_at"`
 	
@@ -116,6 +371,24 @@ func (WorkflowTrigger) TableName() string {
 	return "workflow.triggers"
 }
 
+// WebhookTriggerConfig is the trigger_config of a webhook trigger
+type WebhookTriggerConfig struct {
+	// Unique name used in /api/v1/triggers/webhook/by-slug/:slug
+	Slug string `json:"slug,omitempty"`
+}
+
+// WebhookSlugRedirect keeps a renamed webhook slug pointing at its trigger for a grace period
+type WebhookSlugRedirect struct {
+	Slug      string    `json:"slug" gorm:"primary_key"`
+	TriggerID uuid.UUID `json:"trigger_id" gorm:"type:uuid;not null"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (WebhookSlugRedirect) TableName() string {
+	return "workflow.webhook_slug_redirects"
+}
+
 // Enums
 type WorkflowStatus string
 
@@ -136,6 +409,7 @@ const (
 	StepStatusCompleted StepStatus = "completed"
 	StepStatusFailed    StepStatus = "failed"
 	StepStatusSkipped   StepStatus = "skipped"
+	StepStatusWaiting   StepStatus = "waiting" // parked until an event resumes it
 )
 
 type StepType string
@@ -156,6 +430,7 @@ const (
 	TriggerTypeEvent     TriggerType = "event"
 	TriggerTypeWebhook   TriggerType = "webhook"
 	TriggerTypeCondition TriggerType = "condition"
+	TriggerTypePresence  TriggerType = "presence"
 )
 
 // Request/Response DTOs
@@ -166,6 +441,10 @@ type CreateTemplateRequest struct {
 	Version     string `json:"version"`
 	Schema      JSONB  `json:"schema" binding:"required"`
 	Metadata    JSONB  `json:"metadata"`
+	Visibility  string   `json:"visibility"` // defaults to private
+	Owners      []string `json:"owners"`     // the creator is always an owner
+	Team        string   `json:"team"`       // defaults to the creator's team
+	EncryptVariables bool `json:"encrypt_variables"`
 }
 
 type UpdateTemplateRequest struct {
@@ -175,6 +454,10 @@ type UpdateTemplateRequest struct {
 	Schema      *JSONB  `json:"schema"`
 	Metadata    *JSONB  `json:"metadata"`
 	IsActive    *bool   `json:"is_active"`
+	Visibility  *string   `json:"visibility"`
+	Owners      *[]string `json:"owners"`
+	Team        *string   `json:"team"`
+	EncryptVariables *bool `json:"encrypt_variables"` // applies to instances created afterwards
 }
 
 type CreateInstanceRequest struct {
@@ -182,24 +465,187 @@ type CreateInstanceRequest struct {
 	Name       string    `json:"name" binding:"required"`
 	Variables  JSONB     `json:"variables"`
 	Context    JSONB     `json:"context"`
+	IsTest     bool      `json:"is_test"`
+}
+
+// RerunInstanceRequest is the optional body of POST /instances/:id/rerun.
+// Variables and Context are shallow-merged over the source instance's values.
+type RerunInstanceRequest struct {
+	Name      string `json:"name"`
+	Variables JSONB  `json:"variables"`
+	Context   JSONB  `json:"context"`
+	Start     bool   `json:"start"`
+}
+
+// InstanceStatusResponse is the body of GET /instances/:id/status
+type InstanceStatusResponse struct {
+	ID           uuid.UUID      `json:"id"`
+	Name         string         `json:"name"`
+	Status       WorkflowStatus `json:"status"`
+	CurrentStep  string         `json:"current_step"`
+	ErrorMessage string         `json:"error_message,omitempty"`
+	CreatedBy    string         `json:"created_by"`
+	StartedAt    *time.Time     `json:"started_at"`
+	CompletedAt  *time.Time     `json:"completed_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+}
+
+// Reasons of InstanceAccessResponse
+const (
+	InstanceAccessCreator = "creator" // the caller created the instance
+	InstanceAccessOrg     = "org"     // the caller is of the instance's org
+	InstanceAccessRole    = "role"    // the caller has a role that may see every instance
+	InstanceAccessDenied  = "denied"
+)
+
+// InstanceAccessResponse is the body of GET /instances/:id/can-view
+type InstanceAccessResponse struct {
+	InstanceID uuid.UUID `json:"instance_id"`
+	Allowed    bool      `json:"allowed"`
+	Reason     string    `json:"reason"`
+}
+
+type CreateCommentRequest struct {
+	Body string `json:"body" binding:"required"`
+}
+
+type UpdateTriggerRequest struct {
+	TriggerConfig *JSONB `json:"trigger_config"`
+	IsActive      *bool  `json:"is_active"`
+
+	// When a webhook slug changes, keep the old one redirecting for this many hours
+	KeepOldSlugHours int `json:"keep_old_slug_hours"`
 }
 
 type TriggerWebhookRequest struct {
 	Variables JSONB `json:"variables"`
 	Context   JSONB `json:"context"`
+	IsTest    bool  `json:"is_test"`
+}
+
+// TemplateExport is the portable representation of a template returned by the export endpoint
+type TemplateExport struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description" yaml:"description"`
+	Category    string `json:"category" yaml:"category"`
+	Version     string `json:"version" yaml:"version"`
+	Schema      JSONB  `json:"schema" yaml:"schema"`
+	Metadata    JSONB  `json:"metadata" yaml:"metadata"`
+}
+
+// Version of the instance report format; bumped on incompatible changes
+const InstanceReportVersion = 1
+
+// ReportInstance is the header of an instance execution report
+type ReportInstance struct {
+	ReportVersion   int        `json:"report_version"`
+	GeneratedAt     time.Time  `json:"generated_at"`
+	InstanceID      uuid.UUID  `json:"instance_id"`
+	Name            string     `json:"name"`
+	TemplateID      uuid.UUID  `json:"template_id"`
+	TemplateName    string     `json:"template_name"`
+	TemplateVersion string     `json:"template_version"`
+	Status          string     `json:"status"`
+	CreatedBy       string     `json:"created_by"`
+	StartedAt       *time.Time `json:"started_at"`
+	CompletedAt     *time.Time `json:"completed_at"`
+	DurationSeconds *float64   `json:"duration_seconds"`
+	ErrorMessage    string     `json:"error_message"`
+	Variables       JSONB      `json:"variables"`
+}
+
+// ReportStep is one executed step in an instance execution report. Inputs and
+// outputs are compact JSON, truncated to a fixed size.
+type ReportStep struct {
+	Sequence        int        `json:"sequence"`
+	StepID          string     `json:"step_id"`
+	Name            string     `json:"name"`
+	Type            string     `json:"type"`
+	Status          string     `json:"status"`
+	StartedAt       *time.Time `json:"started_at"`
+	CompletedAt     *time.Time `json:"completed_at"`
+	DurationSeconds *float64   `json:"duration_seconds"`
+	Attempts        int        `json:"attempts"`
+	Input           string     `json:"input"`
+	Output          string     `json:"output"`
+	Error           string     `json:"error"`
+}
+
+// InstanceSummary counts instances per status, pivoted by template, status or creator
+type InstanceSummary struct {
+	GroupBy     string                 `json:"group_by"`
+	Window      string                 `json:"window"`
+	Since       time.Time              `json:"since"`
+	GeneratedAt time.Time              `json:"generated_at"`
+	Groups      []InstanceSummaryGroup `json:"groups"`
+}
+
+// InstanceSummaryGroup is one row of an InstanceSummary
+type InstanceSummaryGroup struct {
+	Key    string           `json:"key"`            // template ID, status or creator
+	Name   string           `json:"name,omitempty"` // template name when grouped by template
+	Counts map[string]int64 `json:"counts"`         // by status
+	Total  int64            `json:"total"`
+}
+
+// TemplateStats summarizes the executions of a template
+type TemplateStats struct {
+	TemplateID        uuid.UUID        `json:"template_id"`
+	InstancesByStatus map[string]int64 `json:"instances_by_status"`
+	Steps             []StepStats      `json:"steps"`
+	Timing            TimingStats      `json:"timing"`
+}
+
+// TimingStats averages the timing breakdown of finished instances of a template
+type TimingStats struct {
+	Instances          int64   `json:"instances"`
+	AvgQueueWaitMs     float64 `json:"avg_queue_wait_ms"`
+	AvgStepExecutionMs float64 `json:"avg_step_execution_ms"`
+	AvgRetryDelayMs    float64 `json:"avg_retry_delay_ms"`
+	AvgWaitStepMs      float64 `json:"avg_wait_step_ms"`
+	AvgWallClockMs     float64 `json:"avg_wall_clock_ms"`
+	MaxWallClockMs     int64   `json:"max_wall_clock_ms"`
+}
+
+// StepStats summarizes the executions of a single step of a template
+type StepStats struct {
+	StepID                  string  `json:"step_id"`
+	Executions              int64   `json:"executions"`
+	Failures                int64   `json:"failures"`
+	AvgDurationSeconds      float64 `json:"avg_duration_seconds"`
+	MaxDurationSeconds      float64 `json:"max_duration_seconds"`
+	ExpectedDurationSeconds int     `json:"expected_duration_seconds,omitempty"`
+	BudgetBreaches          int64   `json:"budget_breaches"`
+	BudgetBreachRate        float64 `json:"budget_breach_rate"`
 }
 
 type ListResponse[T any] struct {
-	Data       []T   `json:"data"`
-	Total      int64 `json:"total"`
-	Page       int   `json:"page"`
-	PageSize   int   `json:"page_size"`
-	TotalPages int   `json:"total_pages"`
+	Data       []T    `json:"data"`
+	Total      int64  `json:"total"`
+	Page       int    `json:"page,omitempty"` // left out when paging with a cursor
+	PageSize   int    `json:"page_size"`
+	TotalPages int    `json:"total_pages"`
+	NextCursor string `json:"next_cursor,omitempty"` // when paging with a cursor and more follow
 }
 
 // WorkflowSchema represents the structure of a workflow definition
 type WorkflowSchema struct {
-	Steps []WorkflowStepDefinition `json:"steps"`
+	Steps  []WorkflowStepDefinition `json:"steps"`
+	Inputs []WorkflowInput          `json:"inputs,omitempty"`
+
+	// Most steps of an instance run at once, at most MAX_INSTANCE_PARALLELISM;
+	// 0 for that limit
+	MaxParallelism int `json:"max_parallelism,omitempty"`
+}
+
+// WorkflowInput declares a variable expected when an instance is launched
+type WorkflowInput struct {
+	Name        string        `json:"name"`
+	Type        string        `json:"type"` // string, number, boolean, object, array
+	Required    bool          `json:"required,omitempty"`
+	Default     interface{}   `json:"default,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Enum        []interface{} `json:"enum,omitempty"`
 }
 
 type WorkflowStepDefinition struct {
@@ -210,15 +656,175 @@ type WorkflowStepDefinition struct {
 	NextSteps   []string               `json:"next_steps,omitempty"`
 	Conditions  []StepCondition        `json:"conditions,omitempty"`
 	RetryPolicy *RetryPolicy           `json:"retry_policy,omitempty"`
+
+	// Starts every one of NextSteps as a concurrent branch; without it a step that is
+	// not a condition continues to the first only. Ignored on condition steps.
+	Fork bool `json:"fork,omitempty"`
+
+	// Maps instance variable names to (dot-separated) paths in the step output
+	OutputMapping map[string]string `json:"output_mapping,omitempty"`
+
+	// Soft duration budget; exceeding it records a step_slow warning
+	ExpectedDurationSeconds int `json:"expected_duration_seconds,omitempty"`
+
+	// Conditions on the step output; the step fails if any of them does not hold
+	Assert []StepCondition `json:"assert,omitempty"`
 }
 
 type StepCondition struct {
 	Field    string      `json:"field"`
 	Operator string      `json:"operator"`
 	Value    interface{} `json:"value"`
+
+	// When the data source of a condition step's field fails: false (default) or fail
+	OnError string `json:"on_error,omitempty"`
+}
+
+// AssertionResult is the outcome of one assert condition of a step
+type AssertionResult struct {
+	Field    string      `json:"field"`
+	Operator string      `json:"operator"`
+	Expected interface{} `json:"expected"`
+	Actual   interface{} `json:"actual,omitempty"`
+	Passed   bool        `json:"passed"`
 }
 
 type RetryPolicy struct {
 	MaxRetries int `json:"max_retries"`
 	Delay      int `json:"delay"` // in seconds
-}
\ No newline at end of file
+}
+
+// ScheduleTriggerConfig is the trigger_config of a schedule trigger
+type ScheduleTriggerConfig struct {
+	Cron      string `json:"cron"`
+	Timezone  string `json:"timezone,omitempty"` // IANA name, defaults to UTC
+	Variables JSONB  `json:"variables,omitempty"`
+
+	// What to do with occurrences missed while the engine was down
+	CatchUp    string `json:"catch_up,omitempty"`     // none, one (default) or all
+	MaxCatchUp int    `json:"max_catch_up,omitempty"` // cap for "all", defaults to 10
+}
+
+// Catch-up policies of schedule triggers
+const (
+	ScheduleCatchUpNone = "none"
+	ScheduleCatchUpOne  = "one"
+	ScheduleCatchUpAll  = "all"
+)
+
+// ScheduleState is kept in a schedule trigger's metadata under "schedule"
+type ScheduleState struct {
+	// Latest occurrence handled, fired or skipped
+	LastScheduledFor *time.Time  `json:"last_scheduled_for,omitempty"`
+	MissedTotal      int         `json:"missed_total"`
+	Missed           []MissedRun `json:"missed,omitempty"` // most recent first
+}
+
+// MissedRun is an occurrence skipped by a schedule trigger's catch-up policy
+type MissedRun struct {
+	ScheduledFor time.Time `json:"scheduled_for"`
+	SkippedAt    time.Time `json:"skipped_at"`
+	Policy       string    `json:"policy"`
+}
+
+// ConditionTriggerConfig is the trigger_config of a condition trigger
+type ConditionTriggerConfig struct {
+	Conditions      []StepCondition `json:"conditions"`
+	Source          ConditionSource `json:"source"`
+	CooldownSeconds int             `json:"cooldown_seconds,omitempty"`
+}
+
+// ConditionSource describes where a condition trigger reads its data from
+type ConditionSource struct {
+	Type    string            `json:"type"` // http, redis
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Key     string            `json:"key,omitempty"`
+}
+
+// TriggerEvaluation records a single evaluation of a polled trigger
+type TriggerEvaluation struct {
+	EvaluatedAt time.Time  `json:"evaluated_at"`
+	Result      bool       `json:"result"`
+	Fired       bool       `json:"fired"`
+	InstanceID  *uuid.UUID `json:"instance_id,omitempty"`
+	Skipped     int        `json:"skipped,omitempty"` // missed schedule occurrences not fired
+	Error       string     `json:"error,omitempty"`
+}
+
+// TemplateUI is the typed "ui" section of a template's metadata, written by the
+// workflow builder. Keys not listed here are preserved as-is.
+type TemplateUI struct {
+	Positions map[string]UIPosition `json:"positions,omitempty"` // keyed by step id
+	Colors    map[string]string     `json:"colors,omitempty"`    // keyed by step id
+	Icons     map[string]string     `json:"icons,omitempty"`     // keyed by step id
+	Form      *UIForm               `json:"form,omitempty"`
+}
+
+type UIPosition struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// UIForm customizes the form used to launch instances of a template
+type UIForm struct {
+	Title       string        `json:"title,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Fields      []UIFormField `json:"fields,omitempty"`
+}
+
+// UIFormField customizes how one input variable is rendered
+type UIFormField struct {
+	Name        string         `json:"name"`
+	Label       string         `json:"label,omitempty"`
+	Widget      string         `json:"widget,omitempty"` // text, textarea, number, checkbox, select, json, date
+	Placeholder string         `json:"placeholder,omitempty"`
+	HelpText    string         `json:"help_text,omitempty"`
+	Order       int            `json:"order,omitempty"`
+	Hidden      bool           `json:"hidden,omitempty"`
+	Options     []UIFormOption `json:"options,omitempty"`
+}
+
+type UIFormOption struct {
+	Label string      `json:"label"`
+	Value interface{} `json:"value"`
+}
+
+// LaunchForm is the ready-to-render form returned by GET /templates/:id/launch-form
+type LaunchForm struct {
+	TemplateID  uuid.UUID         `json:"template_id"`
+	Title       string            `json:"title"`
+	Description string            `json:"description,omitempty"`
+	Fields      []LaunchFormField `json:"fields"`
+}
+
+type LaunchFormField struct {
+	Name        string         `json:"name"`
+	Label       string         `json:"label"`
+	Type        string         `json:"type"`
+	Widget      string         `json:"widget"`
+	Required    bool           `json:"required"`
+	Default     interface{}    `json:"default,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Placeholder string         `json:"placeholder,omitempty"`
+	HelpText    string         `json:"help_text,omitempty"`
+	Options     []UIFormOption `json:"options,omitempty"`
+}
+
+// QueueBacklog describes the instances waiting for an engine worker in one replica
+type QueueBacklog struct {
+	Queued                 int       `json:"queued"`
+	Running                int       `json:"running"`
+	Capacity               int       `json:"capacity"` // MAX_CONCURRENT_WORKFLOWS
+	OldestQueuedSeconds    float64   `json:"oldest_queued_seconds"`
+	QueueLatencyP95Seconds float64   `json:"queue_latency_p95_seconds"` // over the last 5 minutes
+	ReportedAt             time.Time `json:"reported_at"`
+}
+
+// EngineBacklog is the queue backlog across engine replicas. Queued, Running and
+// Capacity are summed; oldest age and p95 latency are the highest of any replica.
+type EngineBacklog struct {
+	QueueBacklog
+	AlertThresholdSeconds int                     `json:"alert_threshold_seconds"`
+	Replicas              map[string]QueueBacklog `json:"replicas"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import "testing"
+
+func TestJSONBClone(t *testing.T) {
+	original := JSONB{
+		"ticket": "T-1",
+		"customer": map[string]interface{}{
+			"name": "Ada",
+			"tags": []interface{}{"vip", map[string]interface{}{"tier": 1}},
+		},
+		"nested": JSONB{"count": 1},
+	}
+	clone := original.Clone()
+
+	clone["ticket"] = "T-2"
+	customer := clone["customer"].(map[string]interface{})
+	customer["name"] = "Grace"
+	tags := customer["tags"].([]interface{})
+	tags[0] = "regular"
+	tags[1].(map[string]interface{})["tier"] = 2
+	clone["nested"].(JSONB)["count"] = 2
+
+	if original["ticket"] != "T-1" {
+		t.Errorf("ticket = %v, want T-1", original["ticket"])
+	}
+	customer = original["customer"].(map[string]interface{})
+	if customer["name"] != "Ada" {
+		t.Errorf("customer name = %v, want Ada", customer["name"])
+	}
+	tags = customer["tags"].([]interface{})
+	if tags[0] != "vip" || tags[1].(map[string]interface{})["tier"] != 1 {
+		t.Errorf("customer tags = %v, want [vip map[tier:1]]", tags)
+	}
+	if original["nested"].(JSONB)["count"] != 1 {
+		t.Errorf("nested count = %v, want 1", original["nested"])
+	}
+
+	if JSONB(nil).Clone() != nil {
+		t.Error("the clone of nil is not nil")
+	}
+}
@@ -0,0 +1,172 @@
+// Package redisclient builds the Redis client shared by the engine, the executor and
+// the rate limiter, and exposes its pool statistics as metrics.
+package redisclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"chorus/pkg/metrics"
+	"chorus/workflow-engine/config"
+)
+
+var (
+	poolConnections = metrics.Default.Gauge(
+		"workflow_redis_pool_connections",
+		"Connections in the Redis pool, by state",
+		"state",
+	)
+	poolEvents = metrics.Default.Counter(
+		"workflow_redis_pool_events_total",
+		"Redis pool lookups: hits reused an idle connection, misses dialed a new one, timeouts waited too long for one",
+		"event",
+	)
+)
+
+// New builds a client for the configured mode. It does not contact Redis.
+func New(cfg config.RedisConfig) (redis.UniversalClient, error) {
+	tlsConfig, err := tlsConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Mode {
+	case "", config.RedisModeStandalone:
+		opts, err := redis.ParseURL(cfg.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+		if cfg.DB >= 0 {
+			opts.DB = cfg.DB
+		}
+		opts.PoolSize = cfg.PoolSize
+		opts.MinIdleConns = cfg.MinIdleConns
+		opts.DialTimeout = cfg.DialTimeout
+		opts.ReadTimeout = cfg.ReadTimeout
+		opts.WriteTimeout = cfg.WriteTimeout
+		opts.TLSConfig = mergeTLS(opts.TLSConfig, tlsConfig)
+		return redis.NewClient(opts), nil
+
+	case config.RedisModeSentinel:
+		if cfg.MasterName == "" || len(cfg.Addrs) == 0 {
+			return nil, fmt.Errorf("sentinel mode requires REDIS_MASTER_NAME and REDIS_ADDRS")
+		}
+		db := cfg.DB
+		if db < 0 {
+			db = 0
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.MasterName,
+			SentinelAddrs:    cfg.Addrs,
+			SentinelPassword: cfg.SentinelPassword,
+			Username:         cfg.Username,
+			Password:         cfg.Password,
+			DB:               db,
+			PoolSize:         cfg.PoolSize,
+			MinIdleConns:     cfg.MinIdleConns,
+			DialTimeout:      cfg.DialTimeout,
+			ReadTimeout:      cfg.ReadTimeout,
+			WriteTimeout:     cfg.WriteTimeout,
+			TLSConfig:        tlsConfig,
+		}), nil
+
+	case config.RedisModeCluster:
+		opts := &redis.ClusterOptions{Addrs: cfg.Addrs}
+		if len(cfg.Addrs) == 0 {
+			if opts, err = redis.ParseClusterURL(cfg.URL); err != nil {
+				return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+			}
+		}
+		if cfg.Username != "" {
+			opts.Username = cfg.Username
+		}
+		if cfg.Password != "" {
+			opts.Password = cfg.Password
+		}
+		opts.PoolSize = cfg.PoolSize
+		opts.MinIdleConns = cfg.MinIdleConns
+		opts.DialTimeout = cfg.DialTimeout
+		opts.ReadTimeout = cfg.ReadTimeout
+		opts.WriteTimeout = cfg.WriteTimeout
+		opts.TLSConfig = mergeTLS(opts.TLSConfig, tlsConfig)
+		return redis.NewClusterClient(opts), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported REDIS_MODE %q", cfg.Mode)
+	}
+}
+
+// Ping checks that Redis answers. On a cluster every shard must answer.
+func Ping(ctx context.Context, client redis.UniversalClient) error {
+	if cluster, ok := client.(*redis.ClusterClient); ok {
+		return cluster.ForEachShard(ctx, func(ctx context.Context, shard *redis.Client) error {
+			return shard.Ping(ctx).Err()
+		})
+	}
+	return client.Ping(ctx).Err()
+}
+
+// CollectPoolStats publishes the client's pool statistics every interval until ctx is done
+func CollectPoolStats(ctx context.Context, client redis.UniversalClient, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last redis.PoolStats
+	for {
+		stats := client.PoolStats()
+		poolConnections.Set(float64(stats.TotalConns-stats.IdleConns), "active")
+		poolConnections.Set(float64(stats.IdleConns), "idle")
+		poolConnections.Set(float64(stats.StaleConns), "stale")
+		poolEvents.Add(float64(stats.Hits-last.Hits), "hit")
+		poolEvents.Add(float64(stats.Misses-last.Misses), "miss")
+		poolEvents.Add(float64(stats.Timeouts-last.Timeouts), "timeout")
+		last = *stats
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tlsConfig returns the TLS settings requested explicitly, or nil
+func tlsConfig(cfg config.RedisConfig) (*tls.Config, error) {
+	if !cfg.TLS && cfg.TLSCAPath == "" && !cfg.TLSInsecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.TLSInsecureSkipVerify,
+	}
+	if cfg.TLSCAPath != "" {
+		pem, err := os.ReadFile(cfg.TLSCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read REDIS_TLS_CA_PATH: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("REDIS_TLS_CA_PATH contains no certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// mergeTLS applies explicit TLS settings on top of those derived from a rediss:// URL
+func mergeTLS(fromURL, explicit *tls.Config) *tls.Config {
+	if explicit == nil {
+		return fromURL
+	}
+	if fromURL != nil && explicit.ServerName == "" {
+		explicit.ServerName = fromURL.ServerName
+	}
+	return explicit
+}
@@ -0,0 +1,66 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+)
+
+// How many days ahead BusinessHours.Next looks for an open day
+const searchDays = 370
+
+// BusinessHours is a daily window in a time zone, optionally restricted to weekdays
+// and excluding holidays
+type BusinessHours struct {
+	Location *time.Location
+	// Minutes after midnight; End <= Start means the window has no end, i.e. only
+	// the start time matters
+	Start, End   int
+	WeekdaysOnly bool
+	Holidays     map[string]bool // YYYY-MM-DD in Location
+}
+
+// ParseClock parses "HH:MM" into minutes after midnight
+func ParseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Next returns now if now falls inside the window on an open day, otherwise the
+// start of the next window. It returns the zero time if no open day is found.
+func (b BusinessHours) Next(now time.Time) time.Time {
+	loc := b.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	wall := now.In(loc)
+	for offset := 0; offset < searchDays; offset++ {
+		// Calendar arithmetic at noon is safe from zones that switch DST at midnight
+		day := time.Date(wall.Year(), wall.Month(), wall.Day()+offset, 12, 0, 0, 0, loc)
+		if !b.isOpen(day) {
+			continue
+		}
+
+		start := inZone(time.Date(day.Year(), day.Month(), day.Day(), b.Start/60, b.Start%60, 0, 0, time.UTC), loc)
+		if now.Before(start) {
+			return start
+		}
+		if b.End > b.Start {
+			end := inZone(time.Date(day.Year(), day.Month(), day.Day(), b.End/60, b.End%60, 0, 0, time.UTC), loc)
+			if now.Before(end) {
+				return now
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func (b BusinessHours) isOpen(day time.Time) bool {
+	if b.WeekdaysOnly && (day.Weekday() == time.Saturday || day.Weekday() == time.Sunday) {
+		return false
+	}
+	return !b.Holidays[day.Format("2006-01-02")]
+}
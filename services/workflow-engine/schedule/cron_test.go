@@ -0,0 +1,121 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// In America/New_York, 2026-03-08 jumps from 02:00 EST to 03:00 EDT and
+// 2026-11-01 goes back from 02:00 EDT to 01:00 EST
+func TestCronNextAcrossDST(t *testing.T) {
+	loc, err := LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(s string) time.Time {
+		t.Helper()
+		parsed, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name  string
+		expr  string
+		after string
+		want  []string // successive runs, in UTC
+	}{
+		{
+			name:  "daily in the skipped hour fires at the shifted instant",
+			expr:  "30 2 * * *",
+			after: "2026-03-07T17:00:00Z",
+			want:  []string{"2026-03-08T07:30:00Z", "2026-03-09T06:30:00Z"},
+		},
+		{
+			name:  "daily at the start of the skipped hour",
+			expr:  "0 2 * * *",
+			after: "2026-03-07T17:00:00Z",
+			want:  []string{"2026-03-08T07:00:00Z", "2026-03-09T06:00:00Z"},
+		},
+		{
+			name:  "half-hourly through the skipped hour",
+			expr:  "*/30 * * * *",
+			after: "2026-03-08T06:45:00Z", // 01:45 EST
+			want:  []string{"2026-03-08T07:00:00Z", "2026-03-08T07:30:00Z", "2026-03-08T08:00:00Z"},
+		},
+		{
+			name:  "daily outside the skipped hour keeps its wall time",
+			expr:  "0 9 * * *",
+			after: "2026-03-07T17:00:00Z",
+			want:  []string{"2026-03-08T13:00:00Z", "2026-03-09T13:00:00Z"},
+		},
+		{
+			name:  "daily in the repeated hour fires once",
+			expr:  "30 1 * * *",
+			after: "2026-10-31T17:00:00Z",
+			want:  []string{"2026-11-01T05:30:00Z", "2026-11-02T06:30:00Z"},
+		},
+		{
+			name:  "daily in the repeated hour from its second pass",
+			expr:  "30 1 * * *",
+			after: "2026-11-01T06:10:00Z", // 01:10 EST, after 01:30 EDT
+			want:  []string{"2026-11-02T06:30:00Z"},
+		},
+		{
+			name:  "half-hourly through the repeated hour",
+			expr:  "*/30 * * * *",
+			after: "2026-11-01T04:45:00Z", // 00:45 EDT
+			want:  []string{"2026-11-01T05:00:00Z", "2026-11-01T05:30:00Z", "2026-11-01T07:00:00Z"},
+		},
+		{
+			name:  "daily outside the repeated hour keeps its wall time",
+			expr:  "0 9 * * *",
+			after: "2026-10-31T17:00:00Z",
+			want:  []string{"2026-11-01T14:00:00Z", "2026-11-02T14:00:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			after := utc(tt.after)
+			for i, want := range tt.want {
+				got := c.Next(after, loc)
+				if !got.Equal(utc(want)) {
+					t.Fatalf("run %d: Next = %s (%s), want %s", i+1, got.UTC().Format(time.RFC3339), got.Format(time.RFC3339), want)
+				}
+				after = got
+			}
+		})
+	}
+}
+
+func TestInZone(t *testing.T) {
+	loc, err := LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		wall time.Time
+		want string
+	}{
+		{time.Date(2026, 3, 8, 1, 59, 0, 0, time.UTC), "2026-03-08T01:59:00-05:00"},
+		{time.Date(2026, 3, 8, 2, 0, 0, 0, time.UTC), "2026-03-08T03:00:00-04:00"},
+		{time.Date(2026, 3, 8, 2, 30, 0, 0, time.UTC), "2026-03-08T03:30:00-04:00"},
+		{time.Date(2026, 3, 8, 3, 0, 0, 0, time.UTC), "2026-03-08T03:00:00-04:00"},
+		// The repeated hour reads as its first pass
+		{time.Date(2026, 11, 1, 1, 30, 0, 0, time.UTC), "2026-11-01T01:30:00-04:00"},
+		{time.Date(2026, 11, 1, 2, 0, 0, 0, time.UTC), "2026-11-01T02:00:00-05:00"},
+	}
+	for _, tt := range tests {
+		if got := inZone(tt.wall, loc).Format(time.RFC3339); got != tt.want {
+			t.Errorf("inZone(%s) = %s, want %s", tt.wall.Format("2006-01-02 15:04"), got, tt.want)
+		}
+	}
+}
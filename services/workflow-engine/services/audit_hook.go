@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"chorus/pkg/eventbus"
+	"chorus/pkg/logging"
+	"chorus/workflow-engine/config"
+)
+
+// auditHook publishes a record of every step of the audited actions to the audit
+// stream, whether it succeeded, failed or was vetoed
+type auditHook struct {
+	bus     *eventbus.Bus
+	topic   eventbus.Topic[eventbus.StepAuditEvent]
+	actions map[string]bool
+	logger  *logging.Logger
+}
+
+func newAuditHook(bus *eventbus.Bus, cfg config.StepHooksConfig, logger *logging.Logger) *auditHook {
+	topic := eventbus.StepAuditEvents
+	topic.Stream = &eventbus.StreamOptions{MaxLen: int64(cfg.AuditStreamMaxLen)}
+
+	actions := make(map[string]bool, len(cfg.AuditActions))
+	for _, action := range cfg.AuditActions {
+		actions[action] = true
+	}
+	return &auditHook{bus: bus, topic: topic, actions: actions, logger: logger}
+}
+
+func (h *auditHook) BeforeStep(ctx context.Context, hc *StepHookContext) error {
+	return nil
+}
+
+func (h *auditHook) AfterStep(ctx context.Context, hc *StepHookContext, result *StepResult, err error) error {
+	action := hc.Action()
+	if !h.actions[action] {
+		return nil
+	}
+
+	event := eventbus.StepAuditEvent{
+		InstanceID: hc.Instance.ID.String(),
+		TemplateID: hc.Instance.TemplateID.String(),
+		StepID:     hc.Step.ID,
+		Action:     action,
+		Attempt:    hc.Attempt,
+		CreatedBy:  hc.Instance.CreatedBy,
+		IsTest:     hc.Instance.IsTest,
+		Outcome:    "succeeded",
+		DurationMs: time.Since(hc.StartedAt).Milliseconds(),
+		Timestamp:  time.Now(),
+	}
+	if !hc.Instance.Encrypted {
+		event.Inputs = hc.Inputs
+	}
+	if action == "http_request" {
+		event.Method, _ = hc.Inputs["method"].(string)
+		event.Method = strings.ToUpper(event.Method)
+		if event.Method == "" {
+			event.Method = "GET"
+		}
+		rawURL, _ := hc.Inputs["url"].(string)
+		event.URL = redactURL(rawURL)
+		event.Destination, _ = hc.Inputs["destination"].(string)
+		if result != nil {
+			event.StatusCode, _ = result.Data["status_code"].(int)
+		}
+	}
+
+	var policyErr *StepPolicyError
+	var statusErr *httpStatusError
+	switch {
+	case errors.As(err, &policyErr):
+		event.Outcome = "vetoed"
+		event.Policy = policyErr.Hook
+		event.Error = err.Error()
+	case err != nil:
+		event.Outcome = "failed"
+		event.Error = err.Error()
+		if errors.As(err, &statusErr) {
+			event.StatusCode = statusErr.statusCode
+		}
+	}
+
+	// Published even when the step was cancelled, so the record is not lost
+	if pubErr := eventbus.Publish(context.WithoutCancel(ctx), h.bus, h.topic, event); pubErr != nil {
+		h.logger.ErrorContext(ctx, "Failed to publish step audit event", "instance_id", event.InstanceID, "step_id", event.StepID, "error", pubErr)
+	}
+	return nil
+}
+
+// redactURL masks the password of a URL, which may be relative to a destination
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Redacted()
+}
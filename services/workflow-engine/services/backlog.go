@@ -0,0 +1,209 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"chorus/workflow-engine/models"
+)
+
+const (
+	// Redis hash of replica ID to that replica's latest QueueBacklog
+	backlogKey = "workflow:backlog"
+
+	// Queue latencies older than this are left out of the p95
+	queueLatencyWindow = 5 * time.Minute
+
+	// Most queue latency samples kept for the p95
+	maxQueueLatencySamples = 4096
+)
+
+// replicaID identifies this engine process in the shared backlog
+var replicaID = func() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "engine"
+	}
+	return fmt.Sprintf("%s-%s", host, uuid.New().String()[:8])
+}()
+
+type latencySample struct {
+	at      time.Time
+	seconds float64
+}
+
+// latencyWindow keeps recent queue latencies to compute percentiles over
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []latencySample
+}
+
+func (w *latencyWindow) add(at time.Time, seconds float64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.samples = append(w.samples, latencySample{at: at, seconds: seconds})
+	if len(w.samples) > maxQueueLatencySamples {
+		w.samples = w.samples[len(w.samples)-maxQueueLatencySamples:]
+	}
+}
+
+// percentile returns the p-th percentile (0-1) of the samples taken within the
+// window before now, or 0 without samples
+func (w *latencyWindow) percentile(now time.Time, p float64) float64 {
+	w.mu.Lock()
+	cutoff := now.Add(-queueLatencyWindow)
+	first := sort.Search(len(w.samples), func(i int) bool {
+		return !w.samples[i].at.Before(cutoff)
+	})
+	w.samples = w.samples[first:]
+	values := make([]float64, len(w.samples))
+	for i, sample := range w.samples {
+		values[i] = sample.seconds
+	}
+	w.mu.Unlock()
+
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	rank := int(p*float64(len(values))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(values) {
+		rank = len(values) - 1
+	}
+	return values[rank]
+}
+
+// LocalBacklog returns the queue backlog of this replica
+func (e *Engine) LocalBacklog() models.QueueBacklog {
+	now := time.Now()
+	backlog := models.QueueBacklog{
+		Capacity:               e.config.MaxConcurrentWorkflows,
+		QueueLatencyP95Seconds: e.queueLatency.percentile(now, 0.95),
+		ReportedAt:             now.UTC(),
+	}
+
+	e.queuedAt.Range(func(_, value interface{}) bool {
+		backlog.Queued++
+		if age := now.Sub(value.(time.Time)).Seconds(); age > backlog.OldestQueuedSeconds {
+			backlog.OldestQueuedSeconds = age
+		}
+		return true
+	})
+	e.instances.Range(func(_, _ interface{}) bool {
+		backlog.Running++
+		return true
+	})
+
+	return backlog
+}
+
+// Backlog returns the queue backlog of all replicas that reported recently. If Redis
+// cannot be read, only this replica is included.
+func (e *Engine) Backlog() models.EngineBacklog {
+	local := e.LocalBacklog()
+	replicas := map[string]models.QueueBacklog{replicaID: local}
+
+	reported, err := e.redis.HGetAll(e.ctx, backlogKey).Result()
+	if err != nil {
+		e.logger.Warn("Failed to read replica backlogs", "error", err)
+	}
+	stale := e.backlogTTL()
+	for id, raw := range reported {
+		if id == replicaID {
+			continue
+		}
+		var backlog models.QueueBacklog
+		if json.Unmarshal([]byte(raw), &backlog) != nil || time.Since(backlog.ReportedAt) > stale {
+			continue
+		}
+		replicas[id] = backlog
+	}
+
+	total := models.EngineBacklog{
+		QueueBacklog:          models.QueueBacklog{ReportedAt: local.ReportedAt},
+		AlertThresholdSeconds: e.config.QueueAgeAlertThreshold,
+		Replicas:              replicas,
+	}
+	for _, backlog := range replicas {
+		total.Queued += backlog.Queued
+		total.Running += backlog.Running
+		total.Capacity += backlog.Capacity
+		if backlog.OldestQueuedSeconds > total.OldestQueuedSeconds {
+			total.OldestQueuedSeconds = backlog.OldestQueuedSeconds
+		}
+		if backlog.QueueLatencyP95Seconds > total.QueueLatencyP95Seconds {
+			total.QueueLatencyP95Seconds = backlog.QueueLatencyP95Seconds
+		}
+	}
+	return total
+}
+
+// backlogTTL is how long a replica's reported backlog counts as current
+func (e *Engine) backlogTTL() time.Duration {
+	return 3 * time.Duration(e.config.WorkflowCheckInterval) * time.Second
+}
+
+// reportBacklog updates the backlog gauges, shares this replica's backlog with the
+// others and warns when the oldest queued instance is past the alert threshold
+func (e *Engine) reportBacklog() {
+	backlog := e.LocalBacklog()
+
+	queueBacklog.Set(float64(backlog.Queued))
+	queueOldestAgeSeconds.Set(backlog.OldestQueuedSeconds)
+	queueLatencyP95Seconds.Set(backlog.QueueLatencyP95Seconds)
+
+	if data, err := json.Marshal(backlog); err == nil {
+		pipe := e.redis.TxPipeline()
+		pipe.HSet(e.ctx, backlogKey, replicaID, data)
+		pipe.Expire(e.ctx, backlogKey, e.backlogTTL())
+		if _, err := pipe.Exec(e.ctx); err != nil {
+			e.logger.Warn("Failed to report backlog", "error", err)
+		}
+	}
+
+	threshold := float64(e.config.QueueAgeAlertThreshold)
+	if threshold <= 0 {
+		return
+	}
+	switch alerting := backlog.OldestQueuedSeconds > threshold; {
+	case alerting && !e.queueAlerting:
+		queueAgeAlertsTotal.Inc()
+		e.logger.Warn("Queued instances are waiting longer than the alert threshold",
+			"oldest_queued_seconds", backlog.OldestQueuedSeconds,
+			"threshold_seconds", e.config.QueueAgeAlertThreshold,
+			"queued", backlog.Queued,
+			"running", backlog.Running,
+		)
+		e.executor.publishEvent(e.ctx, map[string]interface{}{
+			"type":                  "queue_latency_alert",
+			"replica":               replicaID,
+			"oldest_queued_seconds": backlog.OldestQueuedSeconds,
+			"threshold_seconds":     e.config.QueueAgeAlertThreshold,
+			"queued":                backlog.Queued,
+			"timestamp":             time.Now().Unix(),
+		})
+	case !alerting && e.queueAlerting:
+		e.logger.Info("Queue wait is back under the alert threshold",
+			"oldest_queued_seconds", backlog.OldestQueuedSeconds,
+			"threshold_seconds", e.config.QueueAgeAlertThreshold,
+		)
+		e.executor.publishEvent(e.ctx, map[string]interface{}{
+			"type":                  "queue_latency_recovered",
+			"replica":               replicaID,
+			"oldest_queued_seconds": backlog.OldestQueuedSeconds,
+			"threshold_seconds":     e.config.QueueAgeAlertThreshold,
+			"timestamp":             time.Now().Unix(),
+		})
+	}
+	e.queueAlerting = backlog.OldestQueuedSeconds > threshold
+}
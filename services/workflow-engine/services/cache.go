@@ -0,0 +1,28 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// CachedResponse returns a response cached by SetCachedResponse, if it has not expired
+func (e *Engine) CachedResponse(ctx context.Context, key string) ([]byte, bool) {
+	data, err := e.redis.Get(ctx, "workflow:cache:"+key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			e.logger.Warn("Failed to read response cache", "key", key, "error", err)
+		}
+		return nil, false
+	}
+	return data, true
+}
+
+// SetCachedResponse caches a response for ttl. Failures are logged, not returned,
+// since the cache is only an optimization.
+func (e *Engine) SetCachedResponse(ctx context.Context, key string, data []byte, ttl time.Duration) {
+	if err := e.redis.Set(ctx, "workflow:cache:"+key, data, ttl).Err(); err != nil {
+		e.logger.Warn("Failed to write response cache", "key", key, "error", err)
+	}
+}
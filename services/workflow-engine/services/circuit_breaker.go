@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"chorus/pkg/logging"
+	"chorus/workflow-engine/config"
+)
+
+// circuitBreaker fails the http_request steps to a destination whose requests kept
+// failing, rather than sending more. After the failure threshold the circuit opens;
+// once the cooldown passed one step is let through as a trial, which closes it if it
+// succeeds and opens it again if not. The steps that were already running when the
+// circuit opened do not change it. Circuits are kept per replica.
+//
+// Only the failures of the destination count: requests that could not be sent or
+// got no answer, and 5xx responses. A 4xx response is the request's fault.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	logger    *logging.Logger
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// circuit is the state of one destination's circuit
+type circuit struct {
+	failures int       // consecutive
+	openedAt time.Time // zero while closed
+
+	// Trial of the open circuit and when it was let through, nil if none
+	trial   *StepHookContext
+	trialAt time.Time
+}
+
+func newCircuitBreaker(cfg config.StepHooksConfig, logger *logging.Logger) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: cfg.BreakerFailureThreshold,
+		cooldown:  cfg.BreakerCooldown,
+		logger:    logger,
+		circuits:  make(map[string]*circuit),
+	}
+}
+
+func (b *circuitBreaker) BeforeStep(ctx context.Context, hc *StepHookContext) error {
+	if hc.Action() != "http_request" {
+		return nil
+	}
+	destination := breakerDestination(hc)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[destination]
+	if !ok || c.openedAt.IsZero() {
+		return nil
+	}
+
+	now := time.Now()
+	if retryAt := c.openedAt.Add(b.cooldown); now.Before(retryAt) {
+		return fmt.Errorf("circuit of destination %s is open after %d consecutive failures, until %s", destination, c.failures, retryAt.UTC().Format(time.RFC3339))
+	}
+	// A trial that never reported back is given up on after another cooldown
+	if !c.trialAt.IsZero() && now.Before(c.trialAt.Add(b.cooldown)) {
+		return fmt.Errorf("circuit of destination %s is open, a trial request is in flight", destination)
+	}
+
+	c.trial, c.trialAt = hc, now
+	hc.Annotate("trial request to a destination whose circuit is open", map[string]interface{}{
+		"destination": destination,
+		"failures":    c.failures,
+	})
+	return nil
+}
+
+func (b *circuitBreaker) AfterStep(ctx context.Context, hc *StepHookContext, result *StepResult, err error) error {
+	if hc.Action() != "http_request" || isPolicyError(err) || ctx.Err() != nil {
+		return nil
+	}
+	destination := breakerDestination(hc)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[destination]
+	if ok && !c.openedAt.IsZero() && c.trial != hc {
+		return nil
+	}
+	if !destinationFailed(err) {
+		if ok {
+			delete(b.circuits, destination)
+			if !c.openedAt.IsZero() {
+				circuitBreakerTransitionsTotal.Inc(destination, "closed")
+				b.logger.InfoContext(ctx, "Circuit closed", "destination", destination)
+			}
+		}
+		return nil
+	}
+
+	if !ok {
+		c = &circuit{}
+		b.circuits[destination] = c
+	}
+	c.failures++
+	if c.openedAt.IsZero() && c.failures < b.threshold {
+		return nil
+	}
+	// The threshold was reached, or the trial of the open circuit failed
+	c.openedAt, c.trial, c.trialAt = time.Now(), nil, time.Time{}
+	circuitBreakerTransitionsTotal.Inc(destination, "open")
+	b.logger.WarnContext(ctx, "Circuit opened", "destination", destination, "failures", c.failures, "cooldown", b.cooldown, "error", err)
+	return nil
+}
+
+// destinationFailed reports whether the error of an http_request step is the
+// destination's failure
+func destinationFailed(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.statusCode >= 500
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// breakerDestination names the circuit of an http_request step: its destination
+// profile, or the host of its URL
+func breakerDestination(hc *StepHookContext) string {
+	if destination, _ := hc.Step.Config["destination"].(string); destination != "" {
+		return destination
+	}
+	rawURL, _ := hc.Step.Config["url"].(string)
+	if parsed, err := url.Parse(rawURL); err == nil && parsed.Host != "" {
+		return parsed.Host
+	}
+	return defaultDestination
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"chorus/workflow-engine/models"
+)
+
+// on_error of a condition whose data source failed that fails the step, which may be
+// retried; by default ("false") the condition does not hold
+const conditionOnErrorFail = "fail"
+
+// DataSource resolves the fields of condition steps read from outside the instance.
+// A field "<name>:<key>.<path>" is resolved by the source registered as name, which
+// is given key; path selects a nested field of the value it returns.
+type DataSource interface {
+	Resolve(ctx context.Context, key string) (interface{}, error)
+}
+
+// DataSourceFunc adapts a function to DataSource
+type DataSourceFunc func(ctx context.Context, key string) (interface{}, error)
+
+func (f DataSourceFunc) Resolve(ctx context.Context, key string) (interface{}, error) {
+	return f(ctx, key)
+}
+
+// dataSources holds the registered sources and the values they resolved, cached per
+// instance so the conditions of a run do not ask twice
+type dataSources struct {
+	mu      sync.Mutex
+	sources map[string]DataSource
+	cache   map[sourceCacheKey]cachedSourceValue
+	ttl     time.Duration
+}
+
+type sourceCacheKey struct {
+	instanceID uuid.UUID
+	source     string
+	key        string
+}
+
+type cachedSourceValue struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newDataSources(ttl time.Duration) *dataSources {
+	return &dataSources{
+		sources: make(map[string]DataSource),
+		cache:   make(map[sourceCacheKey]cachedSourceValue),
+		ttl:     ttl,
+	}
+}
+
+// RegisterDataSource makes source resolve the condition fields prefixed with
+// "name:". A source registered under the same name before is replaced.
+func (e *Executor) RegisterDataSource(name string, source DataSource) {
+	e.dataSources.mu.Lock()
+	defer e.dataSources.mu.Unlock()
+	e.dataSources.sources[name] = source
+}
+
+// RegisterDataSource makes source resolve the condition fields prefixed with "name:"
+func (e *Engine) RegisterDataSource(name string, source DataSource) {
+	e.executor.RegisterDataSource(name, source)
+}
+
+// parseSourceField splits a condition field into a registered source, its key and the
+// path into the resolved value. The key may be a placeholder such as {{approver}},
+// whose value may contain dots. Fields naming no registered source are instance
+// variables.
+func (e *Executor) parseSourceField(field string) (name string, source DataSource, key, path string, ok bool) {
+	name, rest, found := strings.Cut(field, ":")
+	if !found {
+		return "", nil, "", "", false
+	}
+	e.dataSources.mu.Lock()
+	source, ok = e.dataSources.sources[name]
+	e.dataSources.mu.Unlock()
+	if !ok {
+		return "", nil, "", "", false
+	}
+
+	if strings.HasPrefix(rest, "{{") {
+		if end := strings.Index(rest, "}}"); end >= 0 {
+			key, path = rest[:end+2], strings.TrimPrefix(rest[end+2:], ".")
+			return name, source, key, path, true
+		}
+	}
+	key, path, _ = strings.Cut(rest, ".")
+	return name, source, key, path, true
+}
+
+// resolveConditionField returns the value of a condition field of instance, asking
+// its data source for fields naming one
+func (e *Executor) resolveConditionField(ctx context.Context, instance *models.WorkflowInstance, field string) (interface{}, bool, error) {
+	name, source, key, path, ok := e.parseSourceField(field)
+	if !ok {
+		value, exists := lookupField(instance.Variables, field)
+		return value, exists, nil
+	}
+
+	key, _ = interpolate(key, instance, false).(string)
+	if key == "" || placeholderPattern.MatchString(key) {
+		return nil, false, fmt.Errorf("condition field %s names no key for data source %s", field, name)
+	}
+
+	value, err := e.resolveSource(ctx, instance.ID, name, source, key)
+	if err != nil {
+		return nil, false, fmt.Errorf("data source %s failed for %s: %w", name, key, err)
+	}
+	if path == "" {
+		return value, true, nil
+	}
+	data, isMap := value.(map[string]interface{})
+	if !isMap {
+		return nil, false, nil
+	}
+	value, exists := lookupField(data, path)
+	return value, exists, nil
+}
+
+// resolveSource resolves key with source, reusing a value resolved for the instance
+// within CONDITION_SOURCE_CACHE_SECONDS. Failures are not cached.
+func (e *Executor) resolveSource(ctx context.Context, instanceID uuid.UUID, name string, source DataSource, key string) (interface{}, error) {
+	sources := e.dataSources
+	cacheKey := sourceCacheKey{instanceID: instanceID, source: name, key: key}
+	if sources.ttl > 0 {
+		sources.mu.Lock()
+		cached, ok := sources.cache[cacheKey]
+		sources.mu.Unlock()
+		if ok && time.Now().Before(cached.expires) {
+			return cached.value, nil
+		}
+	}
+
+	value, err := source.Resolve(ctx, key)
+	if err != nil || sources.ttl <= 0 {
+		return value, err
+	}
+
+	now := time.Now()
+	sources.mu.Lock()
+	for k, cached := range sources.cache {
+		if !now.Before(cached.expires) {
+			delete(sources.cache, k)
+		}
+	}
+	sources.cache[cacheKey] = cachedSourceValue{value: value, expires: now.Add(sources.ttl)}
+	sources.mu.Unlock()
+	return value, nil
+}
+
+// presenceSource resolves presence:<user_id> to the user's current status, as
+// {"status": ..., "online": ...}, from the presence service
+func (e *Executor) presenceSource(ctx context.Context, userID string) (interface{}, error) {
+	status, err := e.presenceStatus(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"status": status,
+		"online": status == "online",
+	}, nil
+}
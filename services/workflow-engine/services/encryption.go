@@ -0,0 +1,202 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"chorus/workflow-engine/models"
+)
+
+// Rows re-encrypted per query of a re-encryption job
+const reencryptBatchSize = 100
+
+var (
+	ErrEncryptionNotConfigured = errors.New("encryption is not configured")
+	ErrReencryptionRunning     = errors.New("a re-encryption job is already running")
+)
+
+// ReencryptionStatus is the progress of the latest re-encryption job of this replica
+type ReencryptionStatus struct {
+	Running    bool       `json:"running"`
+	KeyID      string     `json:"key_id,omitempty"` // the active key rows are re-encrypted under
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Instances  int64      `json:"instances"`
+	Steps      int64      `json:"steps"`
+	Payloads   int64      `json:"payloads"`
+	Failed     int64      `json:"failed"` // rows left under their previous key
+	Error      string     `json:"error,omitempty"`
+
+	// Encrypted rows of every replica still sealed under another key than the
+	// active one, when the status was read
+	Pending int64 `json:"pending"`
+}
+
+// reencryption tracks the re-encryption job of this replica
+type reencryption struct {
+	mu     sync.Mutex
+	status ReencryptionStatus
+}
+
+// encryptedTable is a table of encrypted rows, with the columns a re-encryption
+// rewrites
+type encryptedTable struct {
+	name    string
+	model   func() interface{}
+	columns []string
+	count   func(*ReencryptionStatus) *int64
+}
+
+var encryptedTables = []encryptedTable{
+	{
+		name:    "instances",
+		model:   func() interface{} { return &models.WorkflowInstance{} },
+		columns: []string{"context", "variables"},
+		count:   func(s *ReencryptionStatus) *int64 { return &s.Instances },
+	},
+	{
+		name:    "steps",
+		model:   func() interface{} { return &models.WorkflowStep{} },
+		columns: []string{"input_data", "output_data", "attempts"},
+		count:   func(s *ReencryptionStatus) *int64 { return &s.Steps },
+	},
+	{
+		name:    "payloads",
+		model:   func() interface{} { return &models.StepPayload{} },
+		columns: []string{"data"},
+		count:   func(s *ReencryptionStatus) *int64 { return &s.Payloads },
+	},
+}
+
+// StartReencryption starts re-encrypting the encrypted rows sealed under another
+// key than the active one, in the background. Once it finished without failures,
+// the retired keys can be removed from the configuration.
+func (e *Engine) StartReencryption() (ReencryptionStatus, error) {
+	keyID := models.ActiveKeyID()
+	if keyID == "" {
+		return ReencryptionStatus{}, ErrEncryptionNotConfigured
+	}
+
+	e.reencryption.mu.Lock()
+	defer e.reencryption.mu.Unlock()
+	if e.reencryption.status.Running {
+		return e.reencryption.status, ErrReencryptionRunning
+	}
+	now := time.Now()
+	e.reencryption.status = ReencryptionStatus{Running: true, KeyID: keyID, StartedAt: &now}
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.reencrypt(keyID)
+	}()
+	return e.reencryption.status, nil
+}
+
+// ReencryptionStatus returns the progress of the latest re-encryption job, with the
+// rows left to re-encrypt
+func (e *Engine) ReencryptionStatus() (ReencryptionStatus, error) {
+	keyID := models.ActiveKeyID()
+	if keyID == "" {
+		return ReencryptionStatus{}, ErrEncryptionNotConfigured
+	}
+
+	e.reencryption.mu.Lock()
+	status := e.reencryption.status
+	e.reencryption.mu.Unlock()
+
+	for _, table := range encryptedTables {
+		var pending int64
+		if err := e.db.Model(table.model()).
+			Where("encrypted AND encryption_key_id IS DISTINCT FROM ?", keyID).
+			Count(&pending).Error; err != nil {
+			return status, fmt.Errorf("failed to count %s: %w", table.name, err)
+		}
+		status.Pending += pending
+	}
+	return status, nil
+}
+
+func (e *Engine) reencrypt(keyID string) {
+	e.logger.Info("Re-encryption started", "key_id", keyID)
+	var jobErr error
+	for _, table := range encryptedTables {
+		if jobErr = e.reencryptTable(table, keyID); jobErr != nil {
+			break
+		}
+	}
+
+	e.reencryption.mu.Lock()
+	defer e.reencryption.mu.Unlock()
+	now := time.Now()
+	status := &e.reencryption.status
+	status.Running = false
+	status.FinishedAt = &now
+	if jobErr != nil {
+		status.Error = jobErr.Error()
+		e.logger.Error("Re-encryption stopped", "key_id", keyID, "error", jobErr)
+		return
+	}
+	e.logger.Info("Re-encryption finished", "key_id", keyID, "instances", status.Instances, "steps", status.Steps, "payloads", status.Payloads, "failed", status.Failed)
+}
+
+// reencryptTable re-encrypts the rows of a table under keyID, in batches ordered by
+// ID. A row that fails is counted and skipped.
+func (e *Engine) reencryptTable(table encryptedTable, keyID string) error {
+	var after uuid.UUID
+	for {
+		if e.ctx.Err() != nil {
+			return e.ctx.Err()
+		}
+
+		var ids []uuid.UUID
+		if err := e.db.Model(table.model()).
+			Where("encrypted AND encryption_key_id IS DISTINCT FROM ? AND id > ?", keyID, after).
+			Order("id").Limit(reencryptBatchSize).
+			Pluck("id", &ids).Error; err != nil {
+			return fmt.Errorf("failed to list %s: %w", table.name, err)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		for _, id := range ids {
+			err := e.reencryptRow(table, id)
+			e.reencryption.mu.Lock()
+			if err != nil {
+				e.reencryption.status.Failed++
+			} else {
+				*table.count(&e.reencryption.status)++
+			}
+			e.reencryption.mu.Unlock()
+			if err != nil {
+				e.logger.Error("Failed to re-encrypt row", "table", table.name, "id", id, "error", err)
+			}
+		}
+		after = ids[len(ids)-1]
+	}
+}
+
+// reencryptRow rewrites the encrypted columns of a row, which its hooks open under
+// the key they were sealed with and seal under the active one. The row is locked
+// meanwhile, so a concurrent write waits rather than being overwritten.
+func (e *Engine) reencryptRow(table encryptedTable, id uuid.UUID) error {
+	return e.db.Transaction(func(tx *gorm.DB) error {
+		row := table.model()
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select(append([]string{"id", "encrypted"}, table.columns...)).
+			First(row, "id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Model(row).
+			Select(append(table.columns, "encryption_key_id")).
+			Omit("updated_at").
+			Updates(row).Error
+	})
+}
@@ -3,68 +3,116 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 
+	"chorus/pkg/eventbus"
+	"chorus/pkg/logging"
+	"chorus/pkg/tracing"
 	"chorus/workflow-engine/config"
 	"chorus/workflow-engine/models"
+	"chorus/workflow-engine/redisclient"
 	"chorus/workflow-engine/utils"
 )
 
+// How long handled event IDs are remembered for re-delivery dedup
+const handledEventTTL = 10 * time.Minute
+
 type Engine struct {
 	db       *gorm.DB
-	redis    *redis.Client
+	redis    redis.UniversalClient
+	bus      *eventbus.Bus // workflow events of every replica and presence events
 	config   *config.Config
-	logger   *utils.Logger
+	logger   *logging.Logger
 	executor *Executor
 
 	// Internal state
 	ctx       context.Context
 	cancel    context.CancelFunc
 	wg        sync.WaitGroup
-	instances sync.Map // Map of running instance IDs
+	ready     atomic.Bool // set once DB and Redis were reachable at startup
+	instances sync.Map    // Map of running instance IDs
+	queuedAt  sync.Map    // Map of instance ID to the time it was enqueued
 	queue     chan uuid.UUID
+
+	queueLatency latencyWindow // recent enqueue to execution start latencies
+
+	schemas *schemaCache // parsed schemas of recently run templates
+
+	reencryption reencryption // started through the admin API
+
+	// Active presence triggers, reloaded by periodicChecker and matched by eventListener
+	presenceTriggers atomic.Pointer[[]presenceTrigger]
+
+	lastTestPurge    time.Time // only touched by periodicChecker
+	lastEventPurge   time.Time // only touched by periodicChecker
+	lastWebhookCheck time.Time // only touched by periodicChecker
+	queueAlerting    bool      // only touched by periodicChecker
 }
 
-func NewEngine(db *gorm.DB, cfg *config.Config, logger *utils.Logger) *Engine {
+func NewEngine(db *gorm.DB, cfg *config.Config, logger *logging.Logger) *Engine {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	// Initialize Redis client
-	opt, err := redis.ParseURL(cfg.RedisURL)
+	// Initialize the shared Redis client; Start waits for it to be reachable
+	redisClient, err := redisclient.New(cfg.Redis)
 	if err != nil {
-		logger.Fatal("Failed to parse Redis URL", "error", err)
-	}
-	redisClient := redis.NewClient(opt)
-
-	// Test Redis connection
-	if err := redisClient.Ping(ctx).Err(); err != nil {
-		logger.Fatal("Failed to connect to Redis", "error", err)
+		logger.Fatal("Invalid Redis configuration", "error", err)
 	}
 
 	engine := &Engine{
 		db:     db,
 		redis:  redisClient,
+		bus:    eventbus.New(redisClient, "workflow-engine", logger, eventbus.Metrics(), eventbus.Tracing()),
 		config: cfg,
 		logger: logger,
 		ctx:    ctx,
 		cancel: cancel,
 		queue:  make(chan uuid.UUID, cfg.MaxConcurrentWorkflows),
 	}
+	engine.schemas = newSchemaCache(cfg.SchemaCacheSize)
 
-	engine.executor = NewExecutor(db, redisClient, cfg, logger)
+	engine.executor = NewExecutor(db, redisClient, engine.bus, cfg, logger)
 
 	return engine
 }
 
-// Start begins the workflow engine processing
+// Redis returns the engine's Redis client
+func (e *Engine) Redis() redis.UniversalClient {
+	return e.redis
+}
+
+// Start waits for the database and Redis to become reachable, then begins the
+// workflow engine processing. It returns an error if they are still unreachable
+// after the configured number of startup attempts.
 func (e *Engine) Start() error {
 	e.logger.Info("Starting workflow engine")
 
+	if err := e.waitForDependencies(); err != nil {
+		if e.ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	e.ready.Store(true)
+	e.logger.Info("Workflow engine ready")
+
+	// Publish Redis pool statistics
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		redisclient.CollectPoolStats(e.ctx, e.redis, 15*time.Second)
+	}()
+
 	// Start the main processing loop
 	e.wg.Add(1)
 	go e.processQueue()
@@ -73,13 +121,51 @@ func (e *Engine) Start() error {
 	e.wg.Add(1)
 	go e.periodicChecker()
 
-	// Start Redis event listener for workflow events
+	// Start Redis event listener for workflow and presence events
 	e.wg.Add(1)
 	go e.eventListener()
 
 	return nil
 }
 
+// waitForDependencies retries the database and Redis with backoff until both respond
+func (e *Engine) waitForDependencies() error {
+	return utils.Retry(e.ctx, e.config.StartupMaxAttempts, e.checkDependencies, func(attempt int, err error, wait time.Duration) {
+		e.logger.Warn("Dependencies not ready, retrying",
+			"attempt", attempt,
+			"max_attempts", e.config.StartupMaxAttempts,
+			"retry_in", wait.String(),
+			"error", err,
+		)
+	})
+}
+
+// checkDependencies pings the database and Redis
+func (e *Engine) checkDependencies() error {
+	ctx, cancel := context.WithTimeout(e.ctx, 5*time.Second)
+	defer cancel()
+
+	sqlDB, err := e.db.DB()
+	if err != nil {
+		return fmt.Errorf("database: %w", err)
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return fmt.Errorf("database: %w", err)
+	}
+	if err := redisclient.Ping(ctx, e.redis); err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	return nil
+}
+
+// Readiness reports whether the engine has started and its dependencies respond
+func (e *Engine) Readiness() error {
+	if !e.ready.Load() {
+		return fmt.Errorf("workflow engine is starting")
+	}
+	return e.checkDependencies()
+}
+
 // Stop gracefully shuts down the workflow engine
 func (e *Engine) Stop() {
 	e.logger.Info("Stopping workflow engine")
@@ -100,11 +186,16 @@ func (e *Engine) Stop() {
 
 // QueueInstance queues a workflow instance for execution
 func (e *Engine) QueueInstance(instanceID uuid.UUID) error {
+	_, alreadyQueued := e.queuedAt.LoadOrStore(instanceID, time.Now())
+
 	select {
 	case e.queue <- instanceID:
 		e.logger.Debug("Instance queued", "instance_id", instanceID)
 		return nil
 	default:
+		if !alreadyQueued {
+			e.queuedAt.Delete(instanceID)
+		}
 		return fmt.Errorf("workflow queue is full")
 	}
 }
@@ -121,6 +212,7 @@ func (e *Engine) processQueue() {
 			// Check if instance is already running
 			if _, running := e.instances.Load(instanceID); running {
 				e.logger.Debug("Instance already running", "instance_id", instanceID)
+				e.queuedAt.Delete(instanceID)
 				continue
 			}
 
@@ -135,15 +227,32 @@ func (e *Engine) processQueue() {
 func (e *Engine) processInstance(instanceID uuid.UUID) {
 	defer e.wg.Done()
 
+	// A panic here would otherwise leave the instance running forever
+	defer func() {
+		if r := recover(); r != nil {
+			panicsTotal.Inc("engine")
+			e.logger.Error("Workflow instance panicked",
+				"instance_id", instanceID,
+				"panic", fmt.Sprint(r),
+				"stack", string(debug.Stack()),
+			)
+			e.failInstance(instanceID, fmt.Sprintf("Workflow engine panic: %v", r))
+		}
+	}()
+
 	// Mark instance as running
 	e.instances.Store(instanceID, true)
 	defer e.instances.Delete(instanceID)
+	queuedAt, wasQueued := e.queuedAt.LoadAndDelete(instanceID)
 
 	e.logger.Info("Starting workflow instance", "instance_id", instanceID)
 
-	// Load instance with template
+	// Load instance with the template columns steps use; the schema comes from the
+	// schema cache
 	var instance models.WorkflowInstance
-	if err := e.db.Preload("Template").First(&instance, instanceID).Error; err != nil {
+	if err := e.db.Preload("Template", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "name", "version", "metadata", "updated_at")
+	}).First(&instance, instanceID).Error; err != nil {
 		e.logger.Error("Failed to load instance", "instance_id", instanceID, "error", err)
 		return
 	}
@@ -154,79 +263,201 @@ func (e *Engine) processInstance(instanceID uuid.UUID) {
 		return
 	}
 
+	if wasQueued {
+		e.recordQueueWait(&instance, queuedAt.(time.Time))
+	}
+
+	// Each run, including resumes, continues the trace of the request that created
+	// the instance
+	ctx, span := tracing.Start(tracing.WithTraceParent(e.ctx, instance.TraceParent), "workflow instance", trace.WithAttributes(
+		attribute.String("workflow.instance_id", instanceID.String()),
+		attribute.String("workflow.template_id", instance.TemplateID.String()),
+		attribute.String("workflow.template_name", instance.Template.Name),
+	))
+	defer span.End()
+
 	// Parse workflow schema
-	var schema models.WorkflowSchema
-	if err := e.parseSchema(instance.Template.Schema, &schema); err != nil {
-		e.logger.Error("Failed to parse workflow schema", "instance_id", instanceID, "error", err)
-		e.failInstance(instanceID, fmt.Sprintf("Invalid workflow schema: %v", err))
+	schema, err := e.templateSchema(&instance.Template)
+	if err != nil && !errors.Is(err, errInvalidSchema) {
+		e.logger.ErrorContext(ctx, "Failed to load workflow schema", "instance_id", instanceID, "error", err)
+		tracing.Fail(span, err)
+		return
+	}
+	if err != nil {
+		e.logger.ErrorContext(ctx, "Failed to parse workflow schema", "instance_id", instanceID, "error", err)
+		tracing.Fail(span, err)
+		e.failInstance(instanceID, err.Error())
 		return
 	}
 
 	// Execute workflow
-	if err := e.executeWorkflow(&instance, &schema); err != nil {
-		e.logger.Error("Workflow execution failed", "instance_id", instanceID, "error", err)
+	if err := e.executeWorkflow(ctx, &instance, schema); err != nil {
+		if errors.Is(err, errStepWaiting) {
+			e.logger.InfoContext(ctx, "Workflow instance waiting", "instance_id", instanceID)
+			return
+		}
+		// Paused or cancelled meanwhile: the status is the operator's, not a failure
+		if errors.Is(err, errInstanceStatusChanged) {
+			e.logger.InfoContext(ctx, "Workflow instance stopped", "instance_id", instanceID, "reason", err.Error())
+			return
+		}
+		e.logger.ErrorContext(ctx, "Workflow execution failed", "instance_id", instanceID, "error", err)
+		tracing.Fail(span, err)
 		e.failInstance(instanceID, err.Error())
 		return
 	}
 
-	e.logger.Info("Workflow instance completed", "instance_id", instanceID)
+	e.logger.InfoContext(ctx, "Workflow instance completed", "instance_id", instanceID)
+}
+
+// branchResult is a step that finished running in a branch of executeWorkflow
+type branchResult struct {
+	stepID  string
+	branch  *models.WorkflowInstance
+	started int // completions seen when the step started
+	result  *StepResult
+	err     error
 }
 
-// executeWorkflow executes a workflow instance
-func (e *Engine) executeWorkflow(instance *models.WorkflowInstance, schema *models.WorkflowSchema) error {
+// executeWorkflow runs the ready steps of an instance's frontier, those whose
+// dependencies are satisfied, up to its parallelism at once, until none is left
+// or every one left is waiting. Each step runs on a copy of the instance with its
+// own variables and context; the variables steps set are merged into the stored
+// instance atomically, and the frontier is stored after each step so a resumed
+// instance continues every branch.
+// A failed step lets the running ones finish, then fails the instance.
+func (e *Engine) executeWorkflow(ctx context.Context, instance *models.WorkflowInstance, schema *models.WorkflowSchema) error {
 	if len(schema.Steps) == 0 {
 		return e.completeInstance(instance.ID)
 	}
 
-	// Find the starting step
-	currentStepID := instance.CurrentStep
-	if currentStepID == "" {
-		currentStepID = schema.Steps[0].ID
+	plan := newExecutionPlan(schema)
+	frontier := instance.Frontier
+	if frontier == nil || len(frontier.Ready) == 0 {
+		frontier = plan.newFrontier(instance.CurrentStep)
 	}
+	limit := e.config.MaxInstanceParallelism
+	if schema.MaxParallelism > 0 && schema.MaxParallelism < limit {
+		limit = schema.MaxParallelism
+	}
+
+	finished := make(chan branchResult, limit)
+	running := make(map[string]bool)
+	waiting := make(map[string]bool)
+	completions := 0
+	var failure error
 
 	for {
-		// Check if workflow was cancelled or paused
-		if err := e.checkInstanceStatus(instance.ID); err != nil {
-			return err
-		}
+		for _, stepID := range frontier.Ready {
+			if failure != nil || len(running) >= limit {
+				break
+			}
+			if running[stepID] || waiting[stepID] {
+				continue
+			}
+			// Check if workflow was cancelled or paused
+			if err := e.checkInstanceStatus(instance.ID); err != nil {
+				failure = err
+				break
+			}
+			stepDef := plan.steps[stepID]
+			if stepDef == nil {
+				failure = fmt.Errorf("step definition not found: %s", stepID)
+				break
+			}
+			if err := e.updateInstanceCurrentStep(instance.ID, stepID); err != nil {
+				e.logger.Error("Failed to update current step", "instance_id", instance.ID, "step", stepID, "error", err)
+			}
 
-		// Find current step definition
-		stepDef := e.findStepDefinition(schema.Steps, currentStepID)
-		if stepDef == nil {
-			return fmt.Errorf("step definition not found: %s", currentStepID)
+			running[stepID] = true
+			// Steps of other branches may run meanwhile, so each gets its own variables
+			// and context down to their nested values
+			branch := *instance
+			branch.Variables = instance.Variables.Clone()
+			branch.Context = instance.Context.Clone()
+			go func(stepID string, branch *models.WorkflowInstance, started int) {
+				// runStep recovers the panics of step implementations; this covers the rest
+				defer func() {
+					if r := recover(); r != nil {
+						panicsTotal.Inc("engine")
+						e.logger.Error("Step branch panicked", "instance_id", branch.ID, "step_id", stepID, "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+						finished <- branchResult{stepID: stepID, branch: branch, started: started, err: fmt.Errorf("step panicked: %v", r)}
+					}
+				}()
+				result, err := e.executor.ExecuteStep(ctx, branch, stepDef)
+				finished <- branchResult{stepID: stepID, branch: branch, started: started, result: result, err: err}
+			}(stepID, &branch, completions)
 		}
-
-		// Execute step
-		stepResult, err := e.executor.ExecuteStep(instance, stepDef)
-		if err != nil {
-			return fmt.Errorf("step execution failed: %w", err)
+		if len(running) == 0 {
+			break
 		}
 
-		// Update instance current step
-		if err := e.updateInstanceCurrentStep(instance.ID, currentStepID); err != nil {
-			e.logger.Error("Failed to update current step", "instance_id", instance.ID, "step", currentStepID, "error", err)
+		done := <-finished
+		delete(running, done.stepID)
+		completions++
+		e.adoptVariables(instance, done, completions)
+
+		switch {
+		case errors.Is(done.err, errStepWaiting):
+			// The instance is queued again once the step stops waiting
+			waiting[done.stepID] = true
+			continue
+		case done.err != nil:
+			if failure == nil {
+				failure = fmt.Errorf("step execution failed: %w", done.err)
+			}
+			continue
 		}
 
-		// Determine next step
-		nextStepID, err := e.determineNextStep(stepDef, stepResult)
+		stepDef := plan.steps[done.stepID]
+		nextStepID, err := e.determineNextStep(stepDef, done.result)
 		if err != nil {
-			return fmt.Errorf("failed to determine next step: %w", err)
+			if failure == nil {
+				failure = fmt.Errorf("failed to determine next step: %w", err)
+			}
+			continue
 		}
-
-		if nextStepID == "" {
-			// Workflow completed
-			return e.completeInstance(instance.ID)
+		plan.complete(frontier, done.stepID, plan.follows(done.stepID, nextStepID))
+		if err := e.saveFrontier(instance.ID, frontier); err != nil {
+			e.logger.Error("Failed to save frontier", "instance_id", instance.ID, "error", err)
 		}
 
-		currentStepID = nextStepID
-
 		// Add a small delay to prevent tight loops
 		select {
 		case <-e.ctx.Done():
-			return fmt.Errorf("workflow engine shutting down")
+			if failure == nil {
+				failure = fmt.Errorf("workflow engine shutting down")
+			}
 		case <-time.After(100 * time.Millisecond):
 		}
 	}
+
+	if failure != nil {
+		return failure
+	}
+	if len(waiting) > 0 {
+		if err := e.saveFrontier(instance.ID, frontier); err != nil {
+			e.logger.Error("Failed to save frontier", "instance_id", instance.ID, "error", err)
+		}
+		return errStepWaiting
+	}
+	return e.completeInstance(instance.ID)
+}
+
+// adoptVariables brings the variables of the instance up to date after a step. A
+// step that ran alone saw every change, so its copy is taken as is; otherwise other
+// steps merged theirs meanwhile and the stored variables are read back.
+func (e *Engine) adoptVariables(instance *models.WorkflowInstance, done branchResult, completions int) {
+	if done.started == completions-1 {
+		instance.Variables = done.branch.Variables
+		return
+	}
+	var stored models.WorkflowInstance
+	if err := e.db.Select("variables").First(&stored, "id = ?", instance.ID).Error; err != nil {
+		e.logger.Error("Failed to reload variables", "instance_id", instance.ID, "error", err)
+		return
+	}
+	instance.Variables = stored.Variables
 }
 
 // periodicChecker periodically checks for pending workflows and timeouts
@@ -236,6 +467,11 @@ func (e *Engine) periodicChecker() {
 	ticker := time.NewTicker(time.Duration(e.config.WorkflowCheckInterval) * time.Second)
 	defer ticker.Stop()
 
+	// Apply catch-up policies to schedules missed while the engine was down
+	e.checkScheduleTriggers()
+	e.checkPresenceWaits(true)
+	e.loadPresenceTriggers()
+
 	for {
 		select {
 		case <-e.ctx.Done():
@@ -243,33 +479,65 @@ func (e *Engine) periodicChecker() {
 		case <-ticker.C:
 			e.checkPendingWorkflows()
 			e.checkTimeouts()
+			e.checkPresenceWaits(false)
+			e.revertPresenceOverrides(nil)
+			e.checkConditionTriggers()
+			e.checkScheduleTriggers()
+			e.loadPresenceTriggers()
+			e.purgeTestInstances()
+			e.purgeEvents()
+			e.checkTemplateWebhooks()
+			e.checkTasks()
+			e.reportBacklog()
 		}
 	}
 }
 
-// eventListener listens for Redis pub/sub events
+// purgeTestInstances deletes finished test instances past their retention, at most once an hour
+func (e *Engine) purgeTestInstances() {
+	if e.config.TestInstanceRetention <= 0 || time.Since(e.lastTestPurge) < time.Hour {
+		return
+	}
+	e.lastTestPurge = time.Now()
+
+	cutoff := time.Now().Add(-time.Duration(e.config.TestInstanceRetention) * time.Hour)
+	result := e.db.Where("is_test = true AND created_at < ? AND status IN ?", cutoff, []models.WorkflowStatus{
+		models.WorkflowStatusCompleted,
+		models.WorkflowStatusFailed,
+		models.WorkflowStatusCancelled,
+	}).Delete(&models.WorkflowInstance{})
+	if result.Error != nil {
+		e.logger.Error("Failed to purge test instances", "error", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		e.logger.Info("Purged test instances", "count", result.RowsAffected, "older_than", cutoff)
+	}
+}
+
+// eventListener handles the workflow events of every replica and the status changes
+// of the presence service until the engine stops. The subscriptions survive Redis
+// restarts; events published meanwhile are lost.
 func (e *Engine) eventListener() {
 	defer e.wg.Done()
 
-	pubsub := e.redis.Subscribe(e.ctx, "workflow:events")
-	defer pubsub.Close()
-
-	for {
-		select {
-		case <-e.ctx.Done():
-			return
-		default:
-			msg, err := pubsub.ReceiveMessage(e.ctx)
-			if err != nil {
-				if err != context.Canceled {
-					e.logger.Error("Redis pubsub error", "error", err)
-				}
-				continue
-			}
-
-			e.handleEvent(msg.Payload)
-		}
-	}
+	var subscriptions sync.WaitGroup
+	subscriptions.Add(2)
+	go func() {
+		defer subscriptions.Done()
+		eventbus.Subscribe(e.ctx, e.bus, eventbus.WorkflowEvents, func(ctx context.Context, msg eventbus.Message[eventbus.WorkflowEvent]) error {
+			e.handleEvent(msg.Data)
+			return nil
+		})
+	}()
+	go func() {
+		defer subscriptions.Done()
+		eventbus.Subscribe(e.ctx, e.bus, eventbus.PresenceEvents, func(ctx context.Context, msg eventbus.Message[eventbus.PresenceEvent]) error {
+			e.handlePresenceEvent(msg)
+			return nil
+		})
+	}()
+	subscriptions.Wait()
 }
 
 // Helper methods
@@ -283,15 +551,6 @@ func (e *Engine) parseSchema(schemaData models.JSONB, schema *models.WorkflowSch
 	return json.Unmarshal(data, schema)
 }
 
-func (e *Engine) findStepDefinition(steps []models.WorkflowStepDefinition, stepID string) *models.WorkflowStepDefinition {
-	for _, step := range steps {
-		if step.ID == stepID {
-			return &step
-		}
-	}
-	return nil
-}
-
 func (e *Engine) determineNextStep(stepDef *models.WorkflowStepDefinition, result *StepResult) (string, error) {
 	if len(stepDef.NextSteps) == 0 {
 		return "", nil // End of workflow
@@ -318,6 +577,10 @@ func (e *Engine) determineNextStep(stepDef *models.WorkflowStepDefinition, resul
 	return stepDef.NextSteps[0], nil
 }
 
+// errInstanceStatusChanged is returned when an instance being run was paused or
+// cancelled, so its run stops without failing it
+var errInstanceStatusChanged = errors.New("workflow instance is no longer running")
+
 func (e *Engine) checkInstanceStatus(instanceID uuid.UUID) error {
 	var instance models.WorkflowInstance
 	if err := e.db.Select("status").First(&instance, instanceID).Error; err != nil {
@@ -325,7 +588,7 @@ func (e *Engine) checkInstanceStatus(instanceID uuid.UUID) error {
 	}
 
 	if instance.Status != models.WorkflowStatusRunning {
-		return fmt.Errorf("workflow instance status changed to %s", instance.Status)
+		return fmt.Errorf("%w: status changed to %s", errInstanceStatusChanged, instance.Status)
 	}
 
 	return nil
@@ -337,14 +600,35 @@ func (e *Engine) updateInstanceCurrentStep(instanceID uuid.UUID, stepID string)
 		Update("current_step", stepID).Error
 }
 
-func (e *Engine) completeInstance(instanceID uuid.UUID) error {
-	now := time.Now()
+// saveFrontier stores where the branches of an instance are
+func (e *Engine) saveFrontier(instanceID uuid.UUID, frontier *models.ExecutionFrontier) error {
 	return e.db.Model(&models.WorkflowInstance{}).
 		Where("id = ?", instanceID).
+		Update("frontier", frontier).Error
+}
+
+// completeInstance marks a running instance completed. One paused or cancelled while
+// its last steps ran keeps that status, and errInstanceStatusChanged is returned.
+func (e *Engine) completeInstance(instanceID uuid.UUID) error {
+	now := time.Now()
+	result := e.db.Model(&models.WorkflowInstance{}).
+		Where("id = ? AND status = ?", instanceID, models.WorkflowStatusRunning).
 		Updates(map[string]interface{}{
 			"status":       models.WorkflowStatusCompleted,
 			"completed_at": now,
-		}).Error
+			"frontier":     nil,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errInstanceStatusChanged
+	}
+
+	e.recordTiming(instanceID, now)
+	e.notifyOutcomeAsync(instanceID, outcomeCompleted, "")
+	e.revertPresenceOverridesAsync(instanceID)
+	return nil
 }
 
 func (e *Engine) failInstance(instanceID uuid.UUID, errorMsg string) {
@@ -357,6 +641,75 @@ func (e *Engine) failInstance(instanceID uuid.UUID, errorMsg string) {
 			"error_message": errorMsg,
 		}).Error; err != nil {
 		e.logger.Error("Failed to update failed instance", "instance_id", instanceID, "error", err)
+		return
+	}
+
+	e.recordTiming(instanceID, now)
+	e.notifyOutcomeAsync(instanceID, outcomeFailed, errorMsg)
+	e.revertPresenceOverridesAsync(instanceID)
+
+	var isTest bool
+	e.db.Model(&models.WorkflowInstance{}).Where("id = ?", instanceID).Select("is_test").Scan(&isTest)
+	e.executor.publishEvent(e.ctx, map[string]interface{}{
+		"type":        "instance_failed",
+		"instance_id": instanceID.String(),
+		"error":       errorMsg,
+		"is_test":     isTest,
+		"timestamp":   now.Unix(),
+	})
+}
+
+// recordQueueWait adds the time an instance spent in the queue to its running total
+func (e *Engine) recordQueueWait(instance *models.WorkflowInstance, queuedAt time.Time) {
+	wait := time.Since(queuedAt)
+	updates := map[string]interface{}{
+		"queue_wait_ms": gorm.Expr("queue_wait_ms + ?", wait.Milliseconds()),
+	}
+	if instance.QueuedAt == nil {
+		updates["queued_at"] = queuedAt
+		instance.QueuedAt = &queuedAt
+	}
+
+	if err := e.db.Model(&models.WorkflowInstance{}).Where("id = ?", instance.ID).Updates(updates).Error; err != nil {
+		e.logger.Error("Failed to record queue wait", "instance_id", instance.ID, "error", err)
+	}
+
+	queueWaitSeconds.Observe(wait.Seconds(), instance.TemplateID.String())
+	e.queueLatency.add(time.Now(), wait.Seconds())
+}
+
+// recordTiming computes and stores the timing breakdown of a finished instance
+func (e *Engine) recordTiming(instanceID uuid.UUID, completedAt time.Time) {
+	var instance models.WorkflowInstance
+	if err := e.db.Select("id", "created_at", "started_at", "queued_at", "queue_wait_ms").First(&instance, instanceID).Error; err != nil {
+		e.logger.Error("Failed to load instance for timing", "instance_id", instanceID, "error", err)
+		return
+	}
+
+	var timing models.InstanceTiming
+	if err := e.db.Model(&models.WorkflowStep{}).
+		Select(`COALESCE(SUM(execution_ms) FILTER (WHERE step_type <> ?), 0) AS step_execution_ms,
+			COALESCE(SUM(execution_ms) FILTER (WHERE step_type = ?), 0) AS wait_step_ms,
+			COALESCE(SUM(retry_delay_ms), 0) AS retry_delay_ms,
+			COUNT(*) AS steps,
+			COALESCE(SUM(retry_count), 0) AS retries`, models.StepTypeWait, models.StepTypeWait).
+		Where("instance_id = ?", instanceID).
+		Scan(&timing).Error; err != nil {
+		e.logger.Error("Failed to aggregate step timing", "instance_id", instanceID, "error", err)
+		return
+	}
+
+	start := instance.CreatedAt
+	if instance.QueuedAt != nil {
+		start = *instance.QueuedAt
+	} else if instance.StartedAt != nil {
+		start = *instance.StartedAt
+	}
+	timing.QueueWaitMs = instance.QueueWaitMs
+	timing.WallClockMs = completedAt.Sub(start).Milliseconds()
+
+	if err := e.db.Model(&models.WorkflowInstance{}).Where("id = ?", instanceID).Update("timing", timing).Error; err != nil {
+		e.logger.Error("Failed to save instance timing", "instance_id", instanceID, "error", err)
 	}
 }
 
@@ -393,30 +746,59 @@ func (e *Engine) checkTimeouts() {
 	}
 }
 
-func (e *Engine) handleEvent(payload string) {
-	var event map[string]interface{}
-	if err := json.Unmarshal([]byte(payload), &event); err != nil {
-		e.logger.Error("Failed to parse event", "error", err)
+// handleEvent reacts to events on the workflow events channel. Events the engine
+// does not act on are ignored.
+func (e *Engine) handleEvent(event eventbus.WorkflowEvent) {
+	eventType := event.Type()
+	// Replays are for downstream consumers; the engine acted on the events already
+	if replayed, _ := event["replayed"].(bool); replayed {
+		eventsSkippedTotal.Inc(eventType, "replayed")
 		return
 	}
-
-	eventType, ok := event["type"].(string)
-	if !ok {
-		return
-	}
-
 	switch eventType {
 	case "step_completed":
 		// Handle step completion events
-		if instanceIDStr, ok := event["instance_id"].(string); ok {
-			if instanceID, err := uuid.Parse(instanceIDStr); err == nil {
-				if err := e.QueueInstance(instanceID); err != nil {
-					e.logger.Error("Failed to queue instance after step completion", "instance_id", instanceID, "error", err)
-				}
-			}
+		instanceID, err := uuid.Parse(event.InstanceID())
+		if err != nil {
+			return
+		}
+
+		// This process is executing the instance and moves on to the next step itself
+		if _, running := e.instances.Load(instanceID); running {
+			eventsSkippedTotal.Inc(eventType, "owned")
+			e.markEventHandled(event)
+			return
+		}
+		if !e.markEventHandled(event) {
+			eventsSkippedTotal.Inc(eventType, "duplicate")
+			return
+		}
+
+		if err := e.QueueInstance(instanceID); err != nil {
+			e.logger.Error("Failed to queue instance after step completion", "instance_id", instanceID, "error", err)
 		}
 	case "workflow_triggered":
 		// Handle external workflow triggers
-		e.logger.Info("Workflow triggered", "event", event)
+		if e.markEventHandled(event) {
+			e.logger.Debug("Workflow triggered", "event", event)
+		}
+	}
+}
+
+// markEventHandled records the event's ID and reports whether it was seen for the
+// first time, across all engine replicas. Events without an ID are always new.
+func (e *Engine) markEventHandled(event map[string]interface{}) bool {
+	eventID, _ := event["event_id"].(string)
+	if eventID == "" {
+		return true
 	}
-}
\ No newline at end of file
+
+	key := fmt.Sprintf("workflow:events:handled:%s", eventID)
+	first, err := e.redis.SetNX(e.ctx, key, 1, handledEventTTL).Result()
+	if err != nil {
+		// Re-queuing twice is harmless; dropping the event is not
+		e.logger.Warn("Failed to record handled event", "event_id", eventID, "error", err)
+		return true
+	}
+	return first
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"chorus/pkg/logging"
+	"chorus/workflow-engine/config"
+	"chorus/workflow-engine/db/dbtest"
+	"chorus/workflow-engine/models"
+)
+
+// newTestEngine returns an engine on a SQLite database and an in-memory Redis,
+// not started: tests run its methods directly
+func newTestEngine(t testing.TB) *Engine {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	t.Setenv("REDIS_URL", "redis://"+mr.Addr())
+
+	cfg := config.LoadConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("test config: %v", err)
+	}
+	logger := &logging.Logger{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	engine := NewEngine(dbtest.New(t), cfg, logger)
+	t.Cleanup(engine.Stop)
+	return engine
+}
+
+// createTestTemplate stores an active public template with the given steps
+func createTestTemplate(t testing.TB, db *gorm.DB, steps ...models.WorkflowStepDefinition) models.WorkflowTemplate {
+	t.Helper()
+	data, err := json.Marshal(models.WorkflowSchema{Steps: steps})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema models.JSONB
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	template := models.WorkflowTemplate{
+		Name:       "test-" + uuid.NewString()[:8],
+		Schema:     schema,
+		IsActive:   true,
+		Visibility: models.TemplateVisibilityPublic,
+		CreatedBy:  "author",
+	}
+	if err := db.Create(&template).Error; err != nil {
+		t.Fatalf("create template: %v", err)
+	}
+	return template
+}
+
+// createTestInstance stores an instance of template in status
+func createTestInstance(t *testing.T, db *gorm.DB, template models.WorkflowTemplate, status models.WorkflowStatus) models.WorkflowInstance {
+	t.Helper()
+	instance := models.WorkflowInstance{
+		TemplateID: template.ID,
+		Name:       "instance",
+		Status:     status,
+		Variables:  models.JSONB{},
+		Context:    models.JSONB{},
+		CreatedBy:  "author",
+	}
+	if err := db.Create(&instance).Error; err != nil {
+		t.Fatalf("create instance: %v", err)
+	}
+	return instance
+}
+
+// runInstance runs an instance to its end, waiting or failure, as processQueue does
+func runInstance(e *Engine, instanceID uuid.UUID) {
+	e.wg.Add(1)
+	e.processInstance(instanceID)
+}
+
+func loadInstance(t *testing.T, db *gorm.DB, id uuid.UUID) models.WorkflowInstance {
+	t.Helper()
+	var instance models.WorkflowInstance
+	if err := db.First(&instance, "id = ?", id).Error; err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	return instance
+}
+
+func TestProcessInstancePanickingStepFailsInstance(t *testing.T) {
+	e := newTestEngine(t)
+	// A custom data source with the nil-map write that once left instances running
+	e.RegisterDataSource("broken", DataSourceFunc(func(ctx context.Context, key string) (interface{}, error) {
+		var fields map[string]interface{}
+		fields[key] = true
+		return fields, nil
+	}))
+
+	template := createTestTemplate(t, e.db, models.WorkflowStepDefinition{
+		ID:   "check",
+		Type: models.StepTypeCondition,
+		Conditions: []models.StepCondition{
+			{Field: "broken:ticket.open", Operator: "equals", Value: true},
+		},
+	})
+	instance := createTestInstance(t, e.db, template, models.WorkflowStatusRunning)
+
+	panicsBefore := panicsTotal.Value("executor")
+	runInstance(e, instance.ID)
+
+	got := loadInstance(t, e.db, instance.ID)
+	if got.Status != models.WorkflowStatusFailed {
+		t.Fatalf("instance status = %s, want failed", got.Status)
+	}
+	if !strings.Contains(got.ErrorMessage, "step panicked") {
+		t.Errorf("error message = %q, want it to name the panic", got.ErrorMessage)
+	}
+	if got.CompletedAt == nil {
+		t.Error("failed instance has no completed_at")
+	}
+
+	var step models.WorkflowStep
+	if err := e.db.First(&step, "instance_id = ? AND step_id = ?", instance.ID, "check").Error; err != nil {
+		t.Fatalf("load step: %v", err)
+	}
+	if step.Status != models.StepStatusFailed {
+		t.Errorf("step status = %s, want failed", step.Status)
+	}
+	if step.ErrorData["panic"] != true {
+		t.Errorf("step error data does not flag the panic: %v", step.ErrorData)
+	}
+	stack, _ := step.ErrorData["stack"].(string)
+	if stack == "" || len(stack) > maxPanicStackSize {
+		t.Errorf("step stack has %d bytes, want 1 to %d", len(stack), maxPanicStackSize)
+	}
+
+	if got := panicsTotal.Value("executor") - panicsBefore; got != 1 {
+		t.Errorf("workflow panics counter grew by %v, want 1", got)
+	}
+
+	var failed int64
+	e.db.Model(&models.EngineEvent{}).Where("type = ? AND instance_id = ?", "instance_failed", instance.ID).Count(&failed)
+	if failed != 1 {
+		t.Errorf("published %d instance_failed events, want 1", failed)
+	}
+}
@@ -1,26 +1,45 @@
 package services
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
+	"chorus/pkg/eventbus"
+	"chorus/pkg/logging"
+	"chorus/pkg/tracing"
 	"chorus/workflow-engine/config"
 	"chorus/workflow-engine/models"
-	"chorus/workflow-engine/utils"
+	"chorus/workflow-engine/schedule"
 )
 
 type Executor struct {
-	db     *gorm.DB
-	redis  *redis.Client
-	config *config.Config
-	logger *utils.Logger
+	db       *gorm.DB
+	redis    redis.UniversalClient
+	config   *config.Config
+	logger   *logging.Logger
+	outbound *outboundClients
+	bus      *eventbus.Bus // publishes workflow events
+
+	// Sources of the condition fields read from outside the instance
+	dataSources *dataSources
+
+	// Hooks run around every step
+	stepHooks stepHooks
 }
 
 type StepResult struct {
@@ -29,66 +48,149 @@ type StepResult struct {
 	Error   string                 `json:"error,omitempty"`
 }
 
-func NewExecutor(db *gorm.DB, redis *redis.Client, cfg *config.Config, logger *utils.Logger) *Executor {
-	return &Executor{
-		db:     db,
-		redis:  redis,
-		config: cfg,
-		logger: logger,
+func NewExecutor(db *gorm.DB, redis redis.UniversalClient, bus *eventbus.Bus, cfg *config.Config, logger *logging.Logger) *Executor {
+	outbound, err := newOutboundClients(cfg.Outbound)
+	if err != nil {
+		logger.Fatal("Failed to configure outbound HTTP", "error", err)
+	}
+
+	executor := &Executor{
+		db:          db,
+		redis:       redis,
+		config:      cfg,
+		logger:      logger,
+		outbound:    outbound,
+		bus:         bus,
+		dataSources: newDataSources(time.Duration(cfg.ConditionSourceCacheTTL) * time.Second),
 	}
+	executor.RegisterDataSource("presence", DataSourceFunc(executor.presenceSource))
+	executor.registerBuiltinHooks()
+	return executor
 }
 
-// ExecuteStep executes a single workflow step
-func (e *Executor) ExecuteStep(instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition) (*StepResult, error) {
+// ExecuteStep executes a single workflow step, in a span under the instance's span
+// in ctx
+func (e *Executor) ExecuteStep(ctx context.Context, instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition) (result *StepResult, err error) {
+	ctx, span := tracing.Start(ctx, "step "+stepDef.ID, trace.WithAttributes(
+		attribute.String("workflow.step_id", stepDef.ID),
+		attribute.String("workflow.step_type", string(stepDef.Type)),
+	))
+	defer func() {
+		if err != nil && !errors.Is(err, errStepWaiting) {
+			tracing.Fail(span, err)
+		}
+		span.End()
+	}()
+
 	// Create or update step record
-	step, err := e.createOrUpdateStep(instance.ID, stepDef)
+	step, err := e.createOrUpdateStep(instance, stepDef)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create step record: %w", err)
 	}
 
+	// A waiting step resumes the attempt that parked it
+	resuming := step.Status == models.StepStatusWaiting
+
 	// Mark step as running
 	now := time.Now()
+	if step.RetryCount > 0 && step.Status == models.StepStatusPending && !step.UpdatedAt.IsZero() {
+		// Time between the failed attempt being reset for retry and this attempt
+		step.RetryDelayMs += now.Sub(step.UpdatedAt).Milliseconds()
+	}
 	step.Status = models.StepStatusRunning
 	step.StartedAt = &now
 
+	// Run with placeholders resolved and keep a masked snapshot of what was used
+	resolvedDef := *stepDef
+	var snapshot models.JSONB
+	resolvedDef.Config, snapshot = resolveStepConfig(stepDef.Config, instance)
+	if !resuming {
+		e.recordStepInputs(instance, stepDef, step, snapshot, now)
+		delete(step.OutputData, "presence_wait")
+		delete(step.OutputData, "manual_task")
+	}
+
 	if err := e.db.Save(step).Error; err != nil {
 		return nil, fmt.Errorf("failed to update step status: %w", err)
 	}
 
-	e.logger.Info("Executing step", "instance_id", instance.ID, "step_id", stepDef.ID, "step_type", stepDef.Type)
+	e.logger.InfoContext(ctx, "Executing step", "instance_id", instance.ID, "step_id", stepDef.ID, "step_type", stepDef.Type)
 
-	// Execute step based on type
-	var result *StepResult
-	switch stepDef.Type {
-	case models.StepTypeAction:
-		result, err = e.executeActionStep(instance, stepDef, step)
-	case models.StepTypeCondition:
-		result, err = e.executeConditionStep(instance, stepDef, step)
-	case models.StepTypeParallel:
-		result, err = e.executeParallelStep(instance, stepDef, step)
-	case models.StepTypeWait:
-		result, err = e.executeWaitStep(instance, stepDef, step)
-	case models.StepTypeSubflow:
-		result, err = e.executeSubflowStep(instance, stepDef, step)
-	default:
-		err = fmt.Errorf("unsupported step type: %s", stepDef.Type)
+	// Execute step based on type, between the step hooks
+	hooks := e.stepHooks.list()
+	hc := &StepHookContext{
+		Instance:  instance,
+		Step:      &resolvedDef,
+		Inputs:    snapshot,
+		Attempt:   step.RetryCount + 1,
+		StartedAt: now,
+		record:    step,
+	}
+	if err = e.beforeStep(ctx, hooks, hc); err == nil {
+		result, err = e.runStep(ctx, instance, &resolvedDef, step)
+	}
+	if errors.Is(err, errStepWaiting) {
+		step.Status = models.StepStatusWaiting
+		step.ExecutionMs += time.Since(now).Milliseconds()
+		if saveErr := e.db.Save(step).Error; saveErr != nil {
+			return nil, fmt.Errorf("failed to park waiting step: %w", saveErr)
+		}
+		return nil, err
+	}
+	result, err = e.afterStep(ctx, hooks, hc, result, err)
+
+	var assertions []models.AssertionResult
+	if err == nil && len(stepDef.Assert) > 0 {
+		var data map[string]interface{}
+		if result != nil {
+			data = result.Data
+		}
+		assertions, err = e.checkAssertions(stepDef, data)
 	}
 
 	// Update step with result
 	completedAt := time.Now()
 	step.CompletedAt = &completedAt
+	step.ExecutionMs += completedAt.Sub(now).Milliseconds()
+	e.checkDurationBudget(ctx, instance, stepDef, step, completedAt.Sub(now))
 
 	if err != nil {
 		step.Status = models.StepStatusFailed
 		step.ErrorData = models.JSONB{"error": err.Error()}
+		if panicErr, ok := err.(*stepPanicError); ok {
+			step.ErrorData["panic"] = true
+			step.ErrorData["stack"] = truncateUTF8(panicErr.stack, maxPanicStackSize)
+		}
+		var policyErr *StepPolicyError
+		if errors.As(err, &policyErr) {
+			step.ErrorData["policy"] = policyErr.Hook
+		}
+		if assertions != nil {
+			step.ErrorData["assertions"] = assertions
+		}
 		result = &StepResult{Success: false, Error: err.Error()}
 	} else {
 		step.Status = models.StepStatusCompleted
 		if result != nil {
+			// Output mapping runs on the full result, before any offloading
+			e.applyOutputMapping(instance, stepDef, result.Data)
+			if assertions != nil {
+				if result.Data == nil {
+					result.Data = make(map[string]interface{})
+				}
+				result.Data["_assertions"] = assertions
+			}
+
 			if resultData, jsonErr := json.Marshal(result.Data); jsonErr == nil {
 				var jsonbData models.JSONB
 				if json.Unmarshal(resultData, &jsonbData) == nil {
-					step.OutputData = jsonbData
+					inline, payload := e.capPayload(step.ID, models.PayloadKindOutput, jsonbData)
+					if payload != nil {
+						if err := e.savePayload(payload, instance); err != nil {
+							e.logger.Error("Failed to offload step output", "step_id", step.ID, "error", err)
+						}
+					}
+					step.OutputData = inline
 				}
 			}
 		}
@@ -99,34 +201,95 @@ func (e *Executor) ExecuteStep(instance *models.WorkflowInstance, stepDef *model
 	}
 
 	// Publish step completion event
-	e.publishStepEvent("step_completed", instance.ID, stepDef.ID, result)
+	e.publishStepEvent(ctx, "step_completed", instance, stepDef.ID, result)
 
 	return result, err
 }
 
+// Bytes of the goroutine stack kept in a step's ErrorData after a panic
+const maxPanicStackSize = 8 * 1024
+
+// stepPanicError is returned by runStep when the step implementation panicked
+type stepPanicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (p *stepPanicError) Error() string {
+	return fmt.Sprintf("step panicked: %v", p.value)
+}
+
+// runStep dispatches on the step type, turning a panic into a step failure
+func (e *Executor) runStep(ctx context.Context, instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep) (result *StepResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			panicsTotal.Inc("executor")
+			e.logger.Error("Step panicked",
+				"instance_id", instance.ID,
+				"step_id", stepDef.ID,
+				"panic", fmt.Sprint(r),
+				"stack", string(stack),
+			)
+			result, err = nil, &stepPanicError{value: r, stack: stack}
+		}
+	}()
+
+	switch stepDef.Type {
+	case models.StepTypeAction:
+		return e.executeActionStep(ctx, instance, stepDef, step)
+	case models.StepTypeCondition:
+		return e.executeConditionStep(ctx, instance, stepDef, step)
+	case models.StepTypeParallel:
+		return e.executeParallelStep(instance, stepDef, step)
+	case models.StepTypeWait:
+		return e.executeWaitStep(ctx, instance, stepDef, step)
+	case models.StepTypeSubflow:
+		return e.executeSubflowStep(instance, stepDef, step)
+	case models.StepTypeManual:
+		return e.executeManualStep(ctx, instance, stepDef, step)
+	default:
+		return nil, fmt.Errorf("unsupported step type: %s", stepDef.Type)
+	}
+}
+
 // executeActionStep executes an action step
-func (e *Executor) executeActionStep(instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep) (*StepResult, error) {
+func (e *Executor) executeActionStep(ctx context.Context, instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep) (*StepResult, error) {
 	action, ok := stepDef.Config["action"].(string)
 	if !ok {
 		return nil, fmt.Errorf("action not specified in step config")
 	}
 
+	// Test instances can skip side effects of actions that opt in
+	if simulate, _ := stepDef.Config["simulate_in_test"].(bool); simulate && instance.IsTest {
+		e.logger.Info("Simulating action for test instance", "instance_id", instance.ID, "step_id", stepDef.ID, "action", action)
+		return &StepResult{Success: true, Data: map[string]interface{}{"action": action, "simulated": true}}, nil
+	}
+
 	switch action {
 	case "http_request":
-		return e.executeHTTPRequest(instance, stepDef, step)
+		return e.executeHTTPRequest(ctx, instance, stepDef, step)
 	case "send_email":
 		return e.executeSendEmail(instance, stepDef, step)
 	case "log_message":
 		return e.executeLogMessage(instance, stepDef, step)
 	case "update_variables":
 		return e.executeUpdateVariables(instance, stepDef, step)
+	case "check_presence":
+		return e.executeCheckPresence(ctx, instance, stepDef, step)
+	case "set_presence":
+		return e.executeSetPresence(ctx, instance, stepDef, step)
+	case "notify_user":
+		return e.executeNotifyUser(ctx, instance, stepDef, step)
 	default:
 		return nil, fmt.Errorf("unsupported action: %s", action)
 	}
 }
 
-// executeConditionStep executes a condition step
-func (e *Executor) executeConditionStep(instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep) (*StepResult, error) {
+// executeConditionStep executes a condition step. Fields naming a data source, such
+// as presence:<user_id>.status, are resolved live; when the source fails the
+// condition does not hold, or with on_error "fail" the step fails.
+func (e *Executor) executeConditionStep(ctx context.Context, instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep) (*StepResult, error) {
 	conditions := stepDef.Conditions
 	if len(conditions) == 0 {
 		return &StepResult{Success: false, Error: "no conditions defined"}, nil
@@ -134,7 +297,15 @@ func (e *Executor) executeConditionStep(instance *models.WorkflowInstance, stepD
 
 	// Evaluate all conditions (AND logic)
 	for _, condition := range conditions {
-		if !e.evaluateCondition(condition, instance.Variables) {
+		value, exists, err := e.resolveConditionField(ctx, instance, condition.Field)
+		if err != nil {
+			if condition.OnError == conditionOnErrorFail {
+				return nil, err
+			}
+			e.logger.WarnContext(ctx, "Condition data source failed, condition does not hold", "instance_id", instance.ID, "step_id", stepDef.ID, "field", condition.Field, "error", err)
+			return &StepResult{Success: false, Data: map[string]interface{}{"reason": "condition not met", "error": err.Error()}}, nil
+		}
+		if !exists || !compareCondition(condition, value) {
 			return &StepResult{Success: false, Data: map[string]interface{}{"reason": "condition not met"}}, nil
 		}
 	}
@@ -175,7 +346,7 @@ func (e *Executor) executeParallelStep(instance *models.WorkflowInstance, stepDe
 }
 
 // executeWaitStep executes a wait step
-func (e *Executor) executeWaitStep(instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep) (*StepResult, error) {
+func (e *Executor) executeWaitStep(ctx context.Context, instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep) (*StepResult, error) {
 	waitType, ok := stepDef.Config["wait_type"].(string)
 	if !ok {
 		return nil, fmt.Errorf("wait_type not specified")
@@ -204,12 +375,78 @@ func (e *Executor) executeWaitStep(instance *models.WorkflowInstance, stepDef *m
 		// For now, we'll just return success after a short delay
 		time.Sleep(1 * time.Second)
 		return &StepResult{Success: true, Data: map[string]interface{}{"event": eventName}}, nil
-		
+
+	case "wait_until":
+		hours, err := e.businessHours(instance, stepDef.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		until := hours.Next(now)
+		if until.IsZero() {
+			return nil, fmt.Errorf("no open day found within a year")
+		}
+
+		e.logger.Info("Waiting until", "until", until, "instance_id", instance.ID)
+		time.Sleep(until.Sub(now))
+		return &StepResult{Success: true, Data: map[string]interface{}{
+			"waited_until":   until.Format(time.RFC3339),
+			"waited_seconds": int(until.Sub(now).Seconds()),
+		}}, nil
+
+	case "wait_for_presence":
+		return e.executeWaitForPresence(ctx, instance, stepDef, step)
+
 	default:
 		return nil, fmt.Errorf("unsupported wait type: %s", waitType)
 	}
 }
 
+// businessHours reads the window of a wait_until step: "time" (HH:MM) is required,
+// "end", "timezone", "weekdays_only" and "skip_holidays" are optional. Holidays come
+// from the template metadata's "holidays" list of YYYY-MM-DD dates.
+func (e *Executor) businessHours(instance *models.WorkflowInstance, config models.JSONB) (schedule.BusinessHours, error) {
+	var hours schedule.BusinessHours
+
+	start, _ := config["time"].(string)
+	if start == "" {
+		return hours, fmt.Errorf("time not specified for wait_until")
+	}
+	var err error
+	if hours.Start, err = schedule.ParseClock(start); err != nil {
+		return hours, err
+	}
+	if end, _ := config["end"].(string); end != "" {
+		if hours.End, err = schedule.ParseClock(end); err != nil {
+			return hours, err
+		}
+	}
+	timezone, _ := config["timezone"].(string)
+	if hours.Location, err = schedule.LoadLocation(timezone); err != nil {
+		return hours, err
+	}
+	hours.WeekdaysOnly, _ = config["weekdays_only"].(bool)
+
+	if skip, _ := config["skip_holidays"].(bool); skip {
+		template := instance.Template
+		if template.ID == uuid.Nil {
+			if err := e.db.First(&template, "id = ?", instance.TemplateID).Error; err != nil {
+				return hours, fmt.Errorf("failed to load template holidays: %w", err)
+			}
+		}
+		holidays, _ := template.Metadata["holidays"].([]interface{})
+		hours.Holidays = make(map[string]bool, len(holidays))
+		for _, day := range holidays {
+			if date, ok := day.(string); ok {
+				hours.Holidays[date] = true
+			}
+		}
+	}
+
+	return hours, nil
+}
+
 // executeSubflowStep executes a subflow step
 func (e *Executor) executeSubflowStep(instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep) (*StepResult, error) {
 	subflowID, ok := stepDef.Config["subflow_id"].(string)
@@ -233,9 +470,13 @@ func (e *Executor) executeSubflowStep(instance *models.WorkflowInstance, stepDef
 	}, nil
 }
 
-// executeHTTPRequest executes an HTTP request action
-func (e *Executor) executeHTTPRequest(instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep) (*StepResult, error) {
-	url, ok := stepDef.Config["url"].(string)
+// Responses larger than this are truncated before being stored as step output
+const maxHTTPResponseSize = 1 << 20
+
+// executeHTTPRequest executes an HTTP request action. The step config may reference a
+// named destination profile, whose base_url is prepended to relative URLs.
+func (e *Executor) executeHTTPRequest(ctx context.Context, instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep) (*StepResult, error) {
+	rawURL, ok := stepDef.Config["url"].(string)
 	if !ok {
 		return nil, fmt.Errorf("url not specified for HTTP request")
 	}
@@ -244,22 +485,118 @@ func (e *Executor) executeHTTPRequest(instance *models.WorkflowInstance, stepDef
 	if !ok {
 		method = "GET"
 	}
+	method = strings.ToUpper(method)
+
+	destination, _ := stepDef.Config["destination"].(string)
+	client, profile, err := e.outbound.resolve(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	readTimeout := e.config.Outbound.ReadTimeout
+	var connectTimeout time.Duration
+	headers := make(map[string]string)
+	if profile != nil {
+		if profile.BaseURL != "" && !strings.Contains(rawURL, "://") {
+			rawURL = strings.TrimRight(profile.BaseURL, "/") + "/" + strings.TrimLeft(rawURL, "/")
+		}
+		if profile.ReadTimeoutSeconds > 0 {
+			readTimeout = time.Duration(profile.ReadTimeoutSeconds) * time.Second
+		}
+		for key, value := range profile.Headers {
+			headers[key] = value
+		}
+	}
+	if seconds, ok := stepDef.Config["timeout_seconds"].(float64); ok && seconds > 0 {
+		readTimeout = time.Duration(seconds * float64(time.Second))
+	}
+	if seconds, ok := stepDef.Config["connect_timeout_seconds"].(float64); ok && seconds > 0 {
+		connectTimeout = time.Duration(seconds * float64(time.Second))
+	}
+	if stepHeaders, ok := stepDef.Config["headers"].(map[string]interface{}); ok {
+		for key, value := range stepHeaders {
+			headers[key] = fmt.Sprint(value)
+		}
+	}
+
+	var body io.Reader
+	switch payload := stepDef.Config["body"].(type) {
+	case nil:
+	case string:
+		body = strings.NewReader(payload)
+	default:
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request body: %w", err)
+		}
+		body = bytes.NewReader(data)
+		if _, set := headers["Content-Type"]; !set {
+			headers["Content-Type"] = "application/json"
+		}
+	}
 
-	// For demo purposes, simulate HTTP request
-	e.logger.Info("Simulating HTTP request", "method", method, "url", url)
-	time.Sleep(200 * time.Millisecond)
+	if readTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, readTimeout)
+		defer cancel()
+	}
+	if connectTimeout > 0 {
+		ctx = context.WithValue(ctx, connectTimeoutKey{}, connectTimeout)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP request: %w", err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	e.logger.InfoContext(ctx, "Sending HTTP request", "instance_id", instance.ID, "method", method, "url", req.URL.Redacted(), "destination", destination)
+
+	resp, err := e.outbound.do(client, req, destination)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HTTP response: %w", err)
+	}
+
+	var response interface{} = string(raw)
+	if strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		var decoded interface{}
+		if json.Unmarshal(raw, &decoded) == nil {
+			response = decoded
+		}
+	}
+
+	if resp.StatusCode >= 400 {
+		return nil, &httpStatusError{statusCode: resp.StatusCode}
+	}
 
 	return &StepResult{
 		Success: true,
 		Data: map[string]interface{}{
 			"method":      method,
-			"url":         url,
-			"status_code": 200,
-			"response":    "OK",
+			"url":         req.URL.Redacted(),
+			"status_code": resp.StatusCode,
+			"response":    response,
 		},
 	}, nil
 }
 
+// httpStatusError fails an http_request step answered with an error status
+type httpStatusError struct {
+	statusCode int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP request returned status %d", e.statusCode)
+}
+
 // executeSendEmail executes a send email action
 func (e *Executor) executeSendEmail(instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep) (*StepResult, error) {
 	to, ok := stepDef.Config["to"].(string)
@@ -325,19 +662,7 @@ func (e *Executor) executeUpdateVariables(instance *models.WorkflowInstance, ste
 		return nil, fmt.Errorf("updates not specified for update variables action")
 	}
 
-	// Update instance variables
-	if instance.Variables == nil {
-		instance.Variables = make(models.JSONB)
-	}
-
-	for key, value := range updates {
-		instance.Variables[key] = value
-	}
-
-	// Save updated variables
-	if err := e.db.Model(&models.WorkflowInstance{}).
-		Where("id = ?", instance.ID).
-		Update("variables", instance.Variables).Error; err != nil {
+	if err := e.mergeVariables(instance, updates); err != nil {
 		return nil, fmt.Errorf("failed to update variables: %w", err)
 	}
 
@@ -351,26 +676,24 @@ func (e *Executor) executeUpdateVariables(instance *models.WorkflowInstance, ste
 
 // Helper methods
 
-func (e *Executor) createOrUpdateStep(instanceID uuid.UUID, stepDef *models.WorkflowStepDefinition) (*models.WorkflowStep, error) {
+func (e *Executor) createOrUpdateStep(instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition) (*models.WorkflowStep, error) {
 	var step models.WorkflowStep
 	
 	// Try to find existing step
-	err := e.db.Where("instance_id = ? AND step_id = ?", instanceID, stepDef.ID).First(&step).Error
+	err := e.db.Where("instance_id = ? AND step_id = ?", instance.ID, stepDef.ID).First(&step).Error
 	if err == gorm.ErrRecordNotFound {
 		// Create new step
 		step = models.WorkflowStep{
-			InstanceID: instanceID,
+			ID:         uuid.New(),
+			InstanceID: instance.ID,
 			StepID:     stepDef.ID,
 			StepType:   stepDef.Type,
 			Status:     models.StepStatusPending,
 			InputData:  make(models.JSONB),
+			Encrypted:  instance.Encrypted,
 		}
 		
-		// Set input data from step config
-		if configData, err := json.Marshal(stepDef.Config); err == nil {
-			json.Unmarshal(configData, &step.InputData)
-		}
-		
+		// Input data is recorded per attempt, once the config has been resolved
 		if err := e.db.Create(&step).Error; err != nil {
 			return nil, err
 		}
@@ -382,11 +705,15 @@ func (e *Executor) createOrUpdateStep(instanceID uuid.UUID, stepDef *models.Work
 }
 
 func (e *Executor) evaluateCondition(condition models.StepCondition, variables models.JSONB) bool {
-	value, exists := variables[condition.Field]
+	value, exists := lookupField(variables, condition.Field)
 	if !exists {
 		return false
 	}
+	return compareCondition(condition, value)
+}
 
+// compareCondition applies the operator of a condition to the value of its field
+func compareCondition(condition models.StepCondition, value interface{}) bool {
 	switch condition.Operator {
 	case "eq", "equals":
 		return value == condition.Value
@@ -415,11 +742,161 @@ func (e *Executor) evaluateCondition(condition models.StepCondition, variables m
 	return false
 }
 
-func (e *Executor) publishStepEvent(eventType string, instanceID uuid.UUID, stepID string, result *StepResult) {
+// checkAssertions evaluates a step's assert conditions against its output. It
+// returns the outcome of every condition, and an error naming the failed ones.
+func (e *Executor) checkAssertions(stepDef *models.WorkflowStepDefinition, data map[string]interface{}) ([]models.AssertionResult, error) {
+	results := make([]models.AssertionResult, 0, len(stepDef.Assert))
+	var failed []string
+	for _, condition := range stepDef.Assert {
+		actual, _ := lookupField(data, condition.Field)
+		passed := e.evaluateCondition(condition, data)
+		results = append(results, models.AssertionResult{
+			Field:    condition.Field,
+			Operator: condition.Operator,
+			Expected: condition.Value,
+			Actual:   actual,
+			Passed:   passed,
+		})
+		if !passed {
+			failed = append(failed, fmt.Sprintf("%s %s %v", condition.Field, condition.Operator, condition.Value))
+		}
+	}
+
+	if len(failed) > 0 {
+		return results, fmt.Errorf("assertion failed: %s", strings.Join(failed, "; "))
+	}
+	return results, nil
+}
+
+// applyOutputMapping copies values from the step output into instance variables
+func (e *Executor) applyOutputMapping(instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, data map[string]interface{}) {
+	if len(stepDef.OutputMapping) == 0 || data == nil {
+		return
+	}
+
+	updates := make(map[string]interface{}, len(stepDef.OutputMapping))
+	for variable, path := range stepDef.OutputMapping {
+		if value, exists := lookupField(data, path); exists {
+			updates[variable] = value
+		}
+	}
+
+	if err := e.mergeVariables(instance, updates); err != nil {
+		e.logger.Error("Failed to apply output mapping", "instance_id", instance.ID, "step_id", stepDef.ID, "error", err)
+	}
+}
+
+// mergeVariables sets variables of instance, in memory and in the database. The
+// stored variables are merged with updates in one statement, so steps running
+// concurrently on copies of the instance do not overwrite each other's variables;
+// of two steps setting the same variable, the last to finish wins.
+func (e *Executor) mergeVariables(instance *models.WorkflowInstance, updates map[string]interface{}) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	if instance.Variables == nil {
+		instance.Variables = make(models.JSONB)
+	}
+	for key, value := range updates {
+		instance.Variables[key] = value
+	}
+
+	// Sealed variables cannot be merged in SQL: the row is locked while they are
+	// decrypted, merged and sealed again
+	if instance.Encrypted {
+		return e.db.Transaction(func(tx *gorm.DB) error {
+			var stored models.WorkflowInstance
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Select("id", "encrypted", "variables").
+				First(&stored, "id = ?", instance.ID).Error; err != nil {
+				return err
+			}
+			if stored.Variables == nil {
+				stored.Variables = make(models.JSONB)
+			}
+			for key, value := range updates {
+				stored.Variables[key] = value
+			}
+			return tx.Model(&stored).Select("variables", "encryption_key_id").Updates(&stored).Error
+		})
+	}
+
+	return e.db.Model(&models.WorkflowInstance{}).
+		Where("id = ?", instance.ID).
+		Update("variables", gorm.Expr("COALESCE(variables, '{}'::jsonb) || ?::jsonb", models.JSONB(updates))).Error
+}
+
+// lookupField resolves a field name, falling back to a dot-separated path into nested objects
+func lookupField(data map[string]interface{}, field string) (interface{}, bool) {
+	if value, exists := data[field]; exists {
+		return value, true
+	}
+
+	var current interface{} = data
+	for _, part := range strings.Split(field, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// checkDurationBudget records a warning when a step ran longer than its expected duration
+func (e *Executor) checkDurationBudget(ctx context.Context, instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep, duration time.Duration) {
+	if stepDef.ExpectedDurationSeconds <= 0 {
+		return
+	}
+
+	expected := time.Duration(stepDef.ExpectedDurationSeconds) * time.Second
+	hardTimeout := time.Duration(e.config.StepTimeout) * time.Second
+	if duration <= expected || duration >= hardTimeout {
+		return
+	}
+
+	step.Warnings = append(step.Warnings, models.StepWarning{
+		Type:    models.StepWarningSlow,
+		Message: fmt.Sprintf("step took %.1fs, expected at most %ds", duration.Seconds(), stepDef.ExpectedDurationSeconds),
+		Details: map[string]interface{}{
+			"duration_seconds":          duration.Seconds(),
+			"expected_duration_seconds": stepDef.ExpectedDurationSeconds,
+			"attempt":                   step.RetryCount + 1,
+		},
+		CreatedAt: time.Now(),
+	})
+
+	stepSlowTotal.Inc(instance.TemplateID.String(), stepDef.ID)
+
+	e.logger.Warn("Step exceeded expected duration",
+		"instance_id", instance.ID,
+		"step_id", stepDef.ID,
+		"duration", duration,
+		"expected", expected,
+	)
+
+	e.publishEvent(ctx, map[string]interface{}{
+		"type":                      "step_slow",
+		"instance_id":               instance.ID.String(),
+		"template_id":               instance.TemplateID.String(),
+		"step_id":                   stepDef.ID,
+		"duration_seconds":          duration.Seconds(),
+		"expected_duration_seconds": stepDef.ExpectedDurationSeconds,
+		"is_test":                   instance.IsTest,
+		"timestamp":                 time.Now().Unix(),
+	})
+}
+
+func (e *Executor) publishStepEvent(ctx context.Context, eventType string, instance *models.WorkflowInstance, stepID string, result *StepResult) {
 	event := map[string]interface{}{
 		"type":        eventType,
-		"instance_id": instanceID.String(),
+		"instance_id": instance.ID.String(),
+		"template_id": instance.TemplateID.String(),
 		"step_id":     stepID,
+		"is_test":     instance.IsTest,
 		"timestamp":   time.Now().Unix(),
 	}
 
@@ -430,8 +907,18 @@ func (e *Executor) publishStepEvent(eventType string, instanceID uuid.UUID, step
 		}
 	}
 
-	if eventData, err := json.Marshal(event); err == nil {
-		e.redis.Publish(context.Background(), "workflow:events", string(eventData))
+	e.publishEvent(ctx, event)
+}
+
+// publishEvent publishes an event on eventbus.WorkflowEvents, in the trace of ctx.
+// The bus gives each event an event_id so consumers can drop re-deliveries. Events
+// are kept for replay first while EVENT_RETENTION_HOURS is set.
+func (e *Executor) publishEvent(ctx context.Context, event map[string]interface{}) {
+	if e.config.EventRetention > 0 {
+		e.recordEvent(ctx, event)
+	}
+	if err := eventbus.Publish(ctx, e.bus, eventbus.WorkflowEvents, eventbus.WorkflowEvent(event)); err != nil {
+		e.logger.WarnContext(ctx, "Failed to publish workflow event", "type", event["type"], "instance_id", event["instance_id"], "error", err)
 	}
 }
 
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"chorus/workflow-engine/config"
+	"chorus/workflow-engine/models"
+)
+
+// StepHook runs around the steps of every instance, for policies that apply across
+// templates such as auditing or circuit breaking. Hooks run in the order they were
+// registered, the built-in ones enabled by STEP_HOOKS first.
+type StepHook interface {
+	// BeforeStep runs before the step. An error vetoes the step, which fails with a
+	// StepPolicyError; the hooks after the vetoing one are not asked.
+	BeforeStep(ctx context.Context, hc *StepHookContext) error
+
+	// AfterStep runs once the step ran or was vetoed, for every hook, with the
+	// step's error and its result, nil when it failed. It may add to result.Data,
+	// which output mapping and assertions then see. An error fails a step that
+	// succeeded with a StepPolicyError.
+	AfterStep(ctx context.Context, hc *StepHookContext, result *StepResult, err error) error
+}
+
+// StepHookContext is the step hooks are run around
+type StepHookContext struct {
+	Instance  *models.WorkflowInstance
+	Step      *models.WorkflowStepDefinition // with placeholders resolved
+	Inputs    models.JSONB                   // the step's resolved config, secrets masked
+	Attempt   int                            // 1 for the first
+	StartedAt time.Time
+
+	record *models.WorkflowStep
+	hook   string // name of the hook being run
+}
+
+// Action of an action step, "" for other steps
+func (hc *StepHookContext) Action() string {
+	if hc.Step.Type != models.StepTypeAction {
+		return ""
+	}
+	action, _ := hc.Step.Config["action"].(string)
+	return action
+}
+
+// Annotate records a warning on the step execution, naming the hook
+func (hc *StepHookContext) Annotate(message string, details map[string]interface{}) {
+	annotated := map[string]interface{}{"hook": hc.hook}
+	for key, value := range details {
+		annotated[key] = value
+	}
+	hc.record.Warnings = append(hc.record.Warnings, models.StepWarning{
+		Type:      models.StepWarningHook,
+		Message:   message,
+		Details:   annotated,
+		CreatedAt: time.Now(),
+	})
+}
+
+// StepPolicyError fails a step a hook vetoed
+type StepPolicyError struct {
+	Hook string
+	Err  error
+}
+
+func (e *StepPolicyError) Error() string {
+	return fmt.Sprintf("vetoed by %s: %v", e.Hook, e.Err)
+}
+
+func (e *StepPolicyError) Unwrap() error {
+	return e.Err
+}
+
+// stepHooks holds the registered hooks in the order they run
+type stepHooks struct {
+	mu    sync.RWMutex
+	hooks []namedStepHook
+}
+
+type namedStepHook struct {
+	name string
+	hook StepHook
+}
+
+// RegisterStepHook runs hook around every step, after the hooks registered before
+// it. A hook registered under the same name before is replaced in its place.
+func (e *Executor) RegisterStepHook(name string, hook StepHook) {
+	e.stepHooks.mu.Lock()
+	defer e.stepHooks.mu.Unlock()
+	for i := range e.stepHooks.hooks {
+		if e.stepHooks.hooks[i].name == name {
+			e.stepHooks.hooks[i].hook = hook
+			return
+		}
+	}
+	e.stepHooks.hooks = append(e.stepHooks.hooks, namedStepHook{name: name, hook: hook})
+}
+
+// RegisterStepHook runs hook around every step, after the hooks registered before it
+func (e *Engine) RegisterStepHook(name string, hook StepHook) {
+	e.executor.RegisterStepHook(name, hook)
+}
+
+func (h *stepHooks) list() []namedStepHook {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]namedStepHook(nil), h.hooks...)
+}
+
+// registerBuiltinHooks registers the built-in hooks STEP_HOOKS enables, in its order
+func (e *Executor) registerBuiltinHooks() {
+	for _, name := range e.config.StepHooks.Enabled {
+		switch name {
+		case config.StepHookAudit:
+			e.RegisterStepHook(name, newAuditHook(e.bus, e.config.StepHooks, e.logger))
+		case config.StepHookCircuitBreaker:
+			e.RegisterStepHook(name, newCircuitBreaker(e.config.StepHooks, e.logger))
+		}
+	}
+}
+
+// beforeStep runs the BeforeStep of hooks up to the first veto
+func (e *Executor) beforeStep(ctx context.Context, hooks []namedStepHook, hc *StepHookContext) error {
+	for _, h := range hooks {
+		hc.hook = h.name
+		err := e.callHook(hc, func() error { return h.hook.BeforeStep(ctx, hc) })
+		if err != nil {
+			return e.vetoed(ctx, hc, h.name, err)
+		}
+	}
+	return nil
+}
+
+// afterStep runs the AfterStep of hooks, returning the step's result and error as
+// they left them
+func (e *Executor) afterStep(ctx context.Context, hooks []namedStepHook, hc *StepHookContext, result *StepResult, stepErr error) (*StepResult, error) {
+	if len(hooks) == 0 {
+		return result, stepErr
+	}
+	if stepErr == nil && result == nil {
+		// So hooks can enrich the output of steps that returned none
+		result = &StepResult{Success: true}
+	}
+
+	for _, h := range hooks {
+		hc.hook = h.name
+		err := e.callHook(hc, func() error { return h.hook.AfterStep(ctx, hc, result, stepErr) })
+		if err == nil {
+			continue
+		}
+		if stepErr != nil {
+			e.logger.WarnContext(ctx, "Step hook failed after a failed step", "instance_id", hc.Instance.ID, "step_id", hc.Step.ID, "hook", h.name, "error", err)
+			continue
+		}
+		result, stepErr = nil, e.vetoed(ctx, hc, h.name, err)
+	}
+	return result, stepErr
+}
+
+func (e *Executor) vetoed(ctx context.Context, hc *StepHookContext, hook string, err error) error {
+	stepHookVetoesTotal.Inc(hook)
+	e.logger.WarnContext(ctx, "Step vetoed by hook", "instance_id", hc.Instance.ID, "step_id", hc.Step.ID, "hook", hook, "error", err)
+	return &StepPolicyError{Hook: hook, Err: err}
+}
+
+// callHook turns a panic of the hook being run into an error, which vetoes the step
+func (e *Executor) callHook(hc *StepHookContext, call func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicsTotal.Inc("step_hook")
+			e.logger.Error("Step hook panicked",
+				"instance_id", hc.Instance.ID,
+				"step_id", hc.Step.ID,
+				"hook", hc.hook,
+				"panic", fmt.Sprint(r),
+				"stack", string(debug.Stack()),
+			)
+			err = fmt.Errorf("hook panicked: %v", r)
+		}
+	}()
+	return call()
+}
+
+// isPolicyError reports whether err is a step hook's veto
+func isPolicyError(err error) bool {
+	var policyErr *StepPolicyError
+	return errors.As(err, &policyErr)
+}
@@ -0,0 +1,354 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"chorus/pkg/logging"
+	"chorus/workflow-engine/models"
+)
+
+var (
+	ErrInstanceNotFound = errors.New("instance not found")
+	ErrTemplateNotFound = errors.New("template not found or inactive")
+	ErrTemplateInactive = errors.New("template of the source instance is inactive")
+
+	// Returned with the instance, which was saved as running
+	ErrInstanceNotQueued = errors.New("failed to queue instance for execution")
+)
+
+// InstanceOperation changes the status of an instance
+type InstanceOperation string
+
+const (
+	InstanceStart  InstanceOperation = "start"
+	InstancePause  InstanceOperation = "pause"
+	InstanceResume InstanceOperation = "resume"
+	InstanceCancel InstanceOperation = "cancel"
+	InstanceRetry  InstanceOperation = "retry"
+)
+
+// PastTense of the operation, as in "cannot be started"
+func (o InstanceOperation) PastTense() string {
+	switch o {
+	case InstanceStart:
+		return "started"
+	case InstancePause:
+		return "paused"
+	case InstanceResume:
+		return "resumed"
+	case InstanceCancel:
+		return "cancelled"
+	case InstanceRetry:
+		return "retried"
+	}
+	return string(o)
+}
+
+// instanceTransition is the statuses an operation applies to and the one it leads to
+type instanceTransition struct {
+	from []models.WorkflowStatus
+	to   models.WorkflowStatus
+}
+
+var instanceTransitions = map[InstanceOperation]instanceTransition{
+	InstanceStart: {
+		from: []models.WorkflowStatus{models.WorkflowStatusPending, models.WorkflowStatusPaused},
+		to:   models.WorkflowStatusRunning,
+	},
+	InstancePause: {
+		from: []models.WorkflowStatus{models.WorkflowStatusRunning},
+		to:   models.WorkflowStatusPaused,
+	},
+	InstanceResume: {
+		from: []models.WorkflowStatus{models.WorkflowStatusPaused},
+		to:   models.WorkflowStatusRunning,
+	},
+	// Anything that has not ended
+	InstanceCancel: {
+		from: []models.WorkflowStatus{models.WorkflowStatusPending, models.WorkflowStatusRunning, models.WorkflowStatusPaused},
+		to:   models.WorkflowStatusCancelled,
+	},
+	InstanceRetry: {
+		from: []models.WorkflowStatus{models.WorkflowStatusFailed},
+		to:   models.WorkflowStatusRunning,
+	},
+}
+
+// InstanceTransitionError is returned for an operation the instance's status does
+// not allow
+type InstanceTransitionError struct {
+	Operation InstanceOperation
+	Status    models.WorkflowStatus
+}
+
+func (e *InstanceTransitionError) Error() string {
+	return fmt.Sprintf("instance cannot be %s in status %s", e.Operation.PastTense(), e.Status)
+}
+
+// Actor is who asks for an operation on instances
+type Actor struct {
+	UserID      string
+	OrgID       string // of the actor's token, whose members may follow the instances it creates
+	TraceParent string // of the request, which the runs of the instances it creates continue
+
+	// CanLaunch reports whether the actor may launch instances of a template; nil
+	// allows every template
+	CanLaunch func(*models.WorkflowTemplate) bool
+}
+
+func (a Actor) canLaunch(t *models.WorkflowTemplate) bool {
+	return a.CanLaunch == nil || a.CanLaunch(t)
+}
+
+// InstanceService holds the rules of creating instances and moving them between
+// statuses, for the HTTP handlers and any other surface. Status changes are made
+// under a row lock, published on workflow:events and queued with the engine when
+// they lead to running.
+type InstanceService struct {
+	db     *gorm.DB
+	engine *Engine
+	logger *logging.Logger
+}
+
+func NewInstanceService(db *gorm.DB, engine *Engine, logger *logging.Logger) *InstanceService {
+	return &InstanceService{
+		db:     db,
+		engine: engine,
+		logger: logger,
+	}
+}
+
+// Create creates a pending instance of an active template the actor may launch
+func (s *InstanceService) Create(ctx context.Context, actor Actor, req models.CreateInstanceRequest) (*models.WorkflowInstance, error) {
+	var template models.WorkflowTemplate
+	if err := s.db.WithContext(ctx).Where("id = ? AND is_active = true", req.TemplateID).First(&template).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTemplateNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch template: %w", err)
+	}
+	if !actor.canLaunch(&template) {
+		return nil, ErrTemplateNotFound
+	}
+
+	instance := models.WorkflowInstance{
+		TemplateID:  req.TemplateID,
+		Name:        req.Name,
+		Variables:   req.Variables,
+		Context:     req.Context,
+		Status:      models.WorkflowStatusPending,
+		CreatedBy:   actor.UserID,
+		OrgID:       actor.OrgID,
+		IsTest:      req.IsTest,
+		TraceParent: actor.TraceParent,
+		Encrypted:   template.EncryptVariables,
+	}
+	if instance.Variables == nil {
+		instance.Variables = make(models.JSONB)
+	}
+	if instance.Context == nil {
+		instance.Context = make(models.JSONB)
+	}
+
+	if err := s.db.WithContext(ctx).Create(&instance).Error; err != nil {
+		return nil, fmt.Errorf("failed to create instance: %w", err)
+	}
+	instance.Template = template
+
+	s.logger.Info("Instance created", "id", instance.ID, "name", instance.Name, "template", template.Name)
+	s.publish(ctx, "instance_created", &instance, actor, "")
+	return &instance, nil
+}
+
+// Rerun creates an instance of the template of another with its variables and
+// context, overridden by those of req, and starts it if req asks to. The template
+// must still be active.
+func (s *InstanceService) Rerun(ctx context.Context, actor Actor, sourceID uuid.UUID, req models.RerunInstanceRequest) (*models.WorkflowInstance, error) {
+	var source models.WorkflowInstance
+	if err := s.db.WithContext(ctx).Preload("Template").First(&source, "id = ?", sourceID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInstanceNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch instance: %w", err)
+	}
+	if !actor.canLaunch(&source.Template) {
+		return nil, ErrInstanceNotFound
+	}
+	if !source.Template.IsActive {
+		return nil, ErrTemplateInactive
+	}
+
+	name := req.Name
+	if name == "" {
+		name = source.Name
+	}
+
+	instance := models.WorkflowInstance{
+		TemplateID:  source.TemplateID,
+		Name:        name,
+		Variables:   mergeJSONB(source.Variables, req.Variables),
+		Context:     mergeJSONB(source.Context, req.Context),
+		Status:      models.WorkflowStatusPending,
+		CreatedBy:   actor.UserID,
+		OrgID:       actor.OrgID,
+		RerunOf:     &source.ID,
+		IsTest:      source.IsTest,
+		TraceParent: actor.TraceParent,
+		// A rerun carries the source's data, so it stays encrypted if the source was
+		Encrypted: source.Encrypted || source.Template.EncryptVariables,
+	}
+	if req.Start {
+		now := time.Now()
+		instance.Status = models.WorkflowStatusRunning
+		instance.StartedAt = &now
+	}
+
+	if err := s.db.WithContext(ctx).Create(&instance).Error; err != nil {
+		return nil, fmt.Errorf("failed to create instance: %w", err)
+	}
+	instance.Template = source.Template
+
+	s.logger.Info("Instance re-run", "id", instance.ID, "rerun_of", source.ID, "started", req.Start)
+	s.publish(ctx, "instance_created", &instance, actor, "")
+
+	if req.Start {
+		if err := s.engine.QueueInstance(instance.ID); err != nil {
+			s.logger.Error("Failed to queue instance", "error", err, "instance_id", instance.ID)
+			return &instance, fmt.Errorf("%w: %v", ErrInstanceNotQueued, err)
+		}
+	}
+	return &instance, nil
+}
+
+// Start runs a pending or paused instance
+func (s *InstanceService) Start(ctx context.Context, actor Actor, id uuid.UUID) (*models.WorkflowInstance, error) {
+	return s.transition(ctx, actor, id, InstanceStart)
+}
+
+// Pause stops a running instance before its next step; steps already running finish
+// and the instance stays paused
+func (s *InstanceService) Pause(ctx context.Context, actor Actor, id uuid.UUID) (*models.WorkflowInstance, error) {
+	return s.transition(ctx, actor, id, InstancePause)
+}
+
+// Resume runs a paused instance again
+func (s *InstanceService) Resume(ctx context.Context, actor Actor, id uuid.UUID) (*models.WorkflowInstance, error) {
+	return s.transition(ctx, actor, id, InstanceResume)
+}
+
+// Cancel ends an instance that has not ended
+func (s *InstanceService) Cancel(ctx context.Context, actor Actor, id uuid.UUID) (*models.WorkflowInstance, error) {
+	return s.transition(ctx, actor, id, InstanceCancel)
+}
+
+// Retry runs a failed instance again from the steps that were ready when it failed,
+// the failed one included
+func (s *InstanceService) Retry(ctx context.Context, actor Actor, id uuid.UUID) (*models.WorkflowInstance, error) {
+	return s.transition(ctx, actor, id, InstanceRetry)
+}
+
+// transition applies op to an instance of a template the actor may launch, checking
+// its status under a row lock so a concurrent operation sees the outcome
+func (s *InstanceService) transition(ctx context.Context, actor Actor, id uuid.UUID, op InstanceOperation) (*models.WorkflowInstance, error) {
+	rule := instanceTransitions[op]
+
+	var instance models.WorkflowInstance
+	var previous models.WorkflowStatus
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&instance, "id = ?", id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInstanceNotFound
+			}
+			return fmt.Errorf("failed to fetch instance: %w", err)
+		}
+		if err := tx.First(&instance.Template, "id = ?", instance.TemplateID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInstanceNotFound
+			}
+			return fmt.Errorf("failed to fetch template: %w", err)
+		}
+		// Instances of templates the actor may not launch are as good as missing
+		if !actor.canLaunch(&instance.Template) {
+			return ErrInstanceNotFound
+		}
+		if !slices.Contains(rule.from, instance.Status) {
+			return &InstanceTransitionError{Operation: op, Status: instance.Status}
+		}
+
+		previous = instance.Status
+		now := time.Now()
+		updates := map[string]interface{}{"status": rule.to}
+		switch op {
+		case InstanceStart:
+			updates["started_at"] = now
+			instance.StartedAt = &now
+		case InstanceCancel:
+			updates["completed_at"] = now
+			instance.CompletedAt = &now
+		case InstanceRetry:
+			updates["completed_at"] = nil
+			updates["error_message"] = ""
+			instance.CompletedAt = nil
+			instance.ErrorMessage = ""
+		}
+		instance.Status = rule.to
+		if err := tx.Model(&instance).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update instance: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Instance "+op.PastTense(), "id", instance.ID, "name", instance.Name, "by", actor.UserID)
+	s.publish(ctx, "instance_"+op.PastTense(), &instance, actor, previous)
+
+	if rule.to == models.WorkflowStatusRunning {
+		if err := s.engine.QueueInstance(instance.ID); err != nil {
+			s.logger.Error("Failed to queue instance", "error", err, "instance_id", instance.ID)
+			return &instance, fmt.Errorf("%w: %v", ErrInstanceNotQueued, err)
+		}
+	}
+	return &instance, nil
+}
+
+// publish records an operation on an instance on workflow:events
+func (s *InstanceService) publish(ctx context.Context, eventType string, instance *models.WorkflowInstance, actor Actor, previous models.WorkflowStatus) {
+	event := map[string]interface{}{
+		"type":        eventType,
+		"instance_id": instance.ID.String(),
+		"template_id": instance.TemplateID.String(),
+		"status":      instance.Status,
+		"by":          actor.UserID,
+		"is_test":     instance.IsTest,
+		"timestamp":   time.Now().Unix(),
+	}
+	if previous != "" {
+		event["previous_status"] = previous
+	}
+	if instance.RerunOf != nil {
+		event["rerun_of"] = instance.RerunOf.String()
+	}
+	s.engine.executor.publishEvent(ctx, event)
+}
+
+// mergeJSONB returns a copy of base with the top-level keys of overrides applied
+func mergeJSONB(base, overrides models.JSONB) models.JSONB {
+	merged := make(models.JSONB, len(base)+len(overrides))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range overrides {
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,325 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"chorus/workflow-engine/models"
+)
+
+var allStatuses = []models.WorkflowStatus{
+	models.WorkflowStatusPending,
+	models.WorkflowStatusRunning,
+	models.WorkflowStatusPaused,
+	models.WorkflowStatusCompleted,
+	models.WorkflowStatusFailed,
+	models.WorkflowStatusCancelled,
+}
+
+func newTestInstanceService(t *testing.T) (*InstanceService, *Engine) {
+	t.Helper()
+	e := newTestEngine(t)
+	return NewInstanceService(e.db, e, e.logger), e
+}
+
+// expectQueued fails the test unless instanceID is the next instance in the queue
+func expectQueued(t *testing.T, e *Engine, instanceID uuid.UUID) {
+	t.Helper()
+	select {
+	case queued := <-e.queue:
+		if queued != instanceID {
+			t.Errorf("queued %s, want %s", queued, instanceID)
+		}
+	default:
+		t.Errorf("%s was not queued", instanceID)
+	}
+}
+
+// Every operation from every status: the allowed ones lead to their status, the
+// others are refused and leave the instance alone
+func TestInstanceTransitions(t *testing.T) {
+	allowed := map[InstanceOperation]map[models.WorkflowStatus]models.WorkflowStatus{
+		InstanceStart: {
+			models.WorkflowStatusPending: models.WorkflowStatusRunning,
+			models.WorkflowStatusPaused:  models.WorkflowStatusRunning,
+		},
+		InstancePause: {
+			models.WorkflowStatusRunning: models.WorkflowStatusPaused,
+		},
+		InstanceResume: {
+			models.WorkflowStatusPaused: models.WorkflowStatusRunning,
+		},
+		InstanceCancel: {
+			models.WorkflowStatusPending: models.WorkflowStatusCancelled,
+			models.WorkflowStatusRunning: models.WorkflowStatusCancelled,
+			models.WorkflowStatusPaused:  models.WorkflowStatusCancelled,
+		},
+		InstanceRetry: {
+			models.WorkflowStatusFailed: models.WorkflowStatusRunning,
+		},
+	}
+	if len(allowed) != len(instanceTransitions) {
+		t.Fatalf("the test covers %d operations, the service has %d", len(allowed), len(instanceTransitions))
+	}
+
+	s, e := newTestInstanceService(t)
+	template := createTestTemplate(t, e.db)
+	operations := map[InstanceOperation]func(context.Context, Actor, uuid.UUID) (*models.WorkflowInstance, error){
+		InstanceStart:  s.Start,
+		InstancePause:  s.Pause,
+		InstanceResume: s.Resume,
+		InstanceCancel: s.Cancel,
+		InstanceRetry:  s.Retry,
+	}
+
+	for op, to := range allowed {
+		for _, from := range allStatuses {
+			t.Run(string(op)+" from "+string(from), func(t *testing.T) {
+				instance := createTestInstance(t, e.db, template, from)
+				got, err := operations[op](context.Background(), Actor{UserID: "operator"}, instance.ID)
+				stored := loadInstance(t, e.db, instance.ID)
+
+				want, ok := to[from]
+				if !ok {
+					var transitionErr *InstanceTransitionError
+					if !errors.As(err, &transitionErr) {
+						t.Fatalf("error = %v, want an InstanceTransitionError", err)
+					}
+					if transitionErr.Operation != op || transitionErr.Status != from {
+						t.Errorf("error = %+v, want %s from %s", transitionErr, op, from)
+					}
+					if stored.Status != from {
+						t.Errorf("stored status = %s, want it left at %s", stored.Status, from)
+					}
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("error = %v, want %s", err, want)
+				}
+				if got.Status != want || stored.Status != want {
+					t.Errorf("status = %s, stored %s, want %s", got.Status, stored.Status, want)
+				}
+				if want == models.WorkflowStatusRunning {
+					expectQueued(t, e, instance.ID)
+				}
+			})
+		}
+	}
+	if len(e.queue) != 0 {
+		t.Errorf("%d instances queued by refused operations", len(e.queue))
+	}
+}
+
+func TestInstanceServiceErrors(t *testing.T) {
+	s, e := newTestInstanceService(t)
+	ctx := context.Background()
+	operator := Actor{UserID: "operator"}
+	template := createTestTemplate(t, e.db)
+
+	t.Run("unknown instance", func(t *testing.T) {
+		if _, err := s.Start(ctx, operator, uuid.New()); !errors.Is(err, ErrInstanceNotFound) {
+			t.Errorf("Start error = %v, want ErrInstanceNotFound", err)
+		}
+		if _, err := s.Rerun(ctx, operator, uuid.New(), models.RerunInstanceRequest{}); !errors.Is(err, ErrInstanceNotFound) {
+			t.Errorf("Rerun error = %v, want ErrInstanceNotFound", err)
+		}
+	})
+
+	t.Run("template the actor may not launch", func(t *testing.T) {
+		instance := createTestInstance(t, e.db, template, models.WorkflowStatusRunning)
+		outsider := Actor{UserID: "outsider", CanLaunch: func(*models.WorkflowTemplate) bool { return false }}
+		for op, operation := range map[InstanceOperation]func(context.Context, Actor, uuid.UUID) (*models.WorkflowInstance, error){
+			InstancePause:  s.Pause,
+			InstanceCancel: s.Cancel,
+		} {
+			if _, err := operation(ctx, outsider, instance.ID); !errors.Is(err, ErrInstanceNotFound) {
+				t.Errorf("%s error = %v, want ErrInstanceNotFound", op, err)
+			}
+		}
+		if got := loadInstance(t, e.db, instance.ID); got.Status != models.WorkflowStatusRunning {
+			t.Errorf("status = %s, want it left running", got.Status)
+		}
+		if _, err := s.Rerun(ctx, outsider, instance.ID, models.RerunInstanceRequest{}); !errors.Is(err, ErrInstanceNotFound) {
+			t.Errorf("Rerun error = %v, want ErrInstanceNotFound", err)
+		}
+		if _, err := s.Create(ctx, outsider, models.CreateInstanceRequest{TemplateID: template.ID, Name: "denied"}); !errors.Is(err, ErrTemplateNotFound) {
+			t.Errorf("Create error = %v, want ErrTemplateNotFound", err)
+		}
+	})
+
+	t.Run("inactive template", func(t *testing.T) {
+		inactive := createTestTemplate(t, e.db)
+		instance := createTestInstance(t, e.db, inactive, models.WorkflowStatusCompleted)
+		if err := e.db.Model(&inactive).Update("is_active", false).Error; err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Rerun(ctx, operator, instance.ID, models.RerunInstanceRequest{}); !errors.Is(err, ErrTemplateInactive) {
+			t.Errorf("Rerun error = %v, want ErrTemplateInactive", err)
+		}
+		if _, err := s.Create(ctx, operator, models.CreateInstanceRequest{TemplateID: inactive.ID, Name: "inactive"}); !errors.Is(err, ErrTemplateNotFound) {
+			t.Errorf("Create error = %v, want ErrTemplateNotFound", err)
+		}
+	})
+
+	t.Run("full queue", func(t *testing.T) {
+		// Nothing reads a queue without room, as if every worker were busy
+		queue := e.queue
+		e.queue = make(chan uuid.UUID)
+		defer func() { e.queue = queue }()
+
+		instance := createTestInstance(t, e.db, template, models.WorkflowStatusPending)
+		got, err := s.Start(ctx, operator, instance.ID)
+		if !errors.Is(err, ErrInstanceNotQueued) {
+			t.Fatalf("Start error = %v, want ErrInstanceNotQueued", err)
+		}
+		if got == nil || got.ID != instance.ID {
+			t.Fatalf("Start returned %v with ErrInstanceNotQueued, want the instance", got)
+		}
+		if stored := loadInstance(t, e.db, instance.ID); stored.Status != models.WorkflowStatusRunning {
+			t.Errorf("stored status = %s, want running", stored.Status)
+		}
+
+		rerun, err := s.Rerun(ctx, operator, instance.ID, models.RerunInstanceRequest{Start: true})
+		if !errors.Is(err, ErrInstanceNotQueued) || rerun == nil {
+			t.Errorf("Rerun = %v, %v, want the instance and ErrInstanceNotQueued", rerun, err)
+		}
+	})
+}
+
+// Retry clears the failure of an instance and runs it again from the step that
+// failed
+func TestInstanceRetry(t *testing.T) {
+	s, e := newTestInstanceService(t)
+	failing := true
+	e.RegisterDataSource("flaky", DataSourceFunc(func(ctx context.Context, key string) (interface{}, error) {
+		if failing {
+			return nil, errors.New("flaky data source is down")
+		}
+		return map[string]interface{}{"open": true}, nil
+	}))
+	template := createTestTemplate(t, e.db,
+		models.WorkflowStepDefinition{
+			ID:        "check",
+			Type:      models.StepTypeCondition,
+			NextSteps: []string{"done"},
+			Conditions: []models.StepCondition{
+				{Field: "flaky:ticket.open", Operator: "equals", Value: true, OnError: "fail"},
+			},
+		},
+		models.WorkflowStepDefinition{
+			ID:     "done",
+			Type:   models.StepTypeAction,
+			Config: map[string]interface{}{"action": "log_message", "message": "done"},
+		},
+	)
+	instance := createTestInstance(t, e.db, template, models.WorkflowStatusRunning)
+	runInstance(e, instance.ID)
+	if got := loadInstance(t, e.db, instance.ID); got.Status != models.WorkflowStatusFailed {
+		t.Fatalf("status = %s, want failed", got.Status)
+	}
+
+	failing = false
+	retried, err := s.Retry(context.Background(), Actor{UserID: "operator"}, instance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried.ErrorMessage != "" || retried.CompletedAt != nil {
+		t.Errorf("retried instance keeps error %q and completed_at %v", retried.ErrorMessage, retried.CompletedAt)
+	}
+	expectQueued(t, e, instance.ID)
+	runInstance(e, instance.ID)
+
+	got := loadInstance(t, e.db, instance.ID)
+	if got.Status != models.WorkflowStatusCompleted || got.ErrorMessage != "" {
+		t.Errorf("status = %s, error %q, want completed", got.Status, got.ErrorMessage)
+	}
+}
+
+// An instance paused while a step runs stays paused once the step finishes, and
+// continues with the next step when resumed
+func TestPausedInstanceStaysPaused(t *testing.T) {
+	s, e := newTestInstanceService(t)
+	operator := Actor{UserID: "operator"}
+	var instanceID uuid.UUID
+	// Pauses the instance from inside its first step, as an operator would meanwhile
+	e.RegisterDataSource("pause", DataSourceFunc(func(ctx context.Context, key string) (interface{}, error) {
+		if _, err := s.Pause(ctx, operator, instanceID); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"paused": true}, nil
+	}))
+	template := createTestTemplate(t, e.db,
+		models.WorkflowStepDefinition{
+			ID:        "check",
+			Type:      models.StepTypeCondition,
+			NextSteps: []string{"done"},
+			Conditions: []models.StepCondition{
+				{Field: "pause:instance.paused", Operator: "equals", Value: true},
+			},
+		},
+		models.WorkflowStepDefinition{
+			ID:     "done",
+			Type:   models.StepTypeAction,
+			Config: map[string]interface{}{"action": "log_message", "message": "done"},
+		},
+	)
+	instance := createTestInstance(t, e.db, template, models.WorkflowStatusRunning)
+	instanceID = instance.ID
+
+	runInstance(e, instance.ID)
+	got := loadInstance(t, e.db, instance.ID)
+	if got.Status != models.WorkflowStatusPaused || got.ErrorMessage != "" {
+		t.Fatalf("status = %s, error %q, want paused", got.Status, got.ErrorMessage)
+	}
+	if got.Frontier == nil || len(got.Frontier.Ready) != 1 || got.Frontier.Ready[0] != "done" {
+		t.Errorf("frontier = %+v, want done ready", got.Frontier)
+	}
+
+	e.RegisterDataSource("pause", DataSourceFunc(func(ctx context.Context, key string) (interface{}, error) {
+		return map[string]interface{}{"paused": false}, nil
+	}))
+	if _, err := s.Resume(context.Background(), operator, instance.ID); err != nil {
+		t.Fatal(err)
+	}
+	expectQueued(t, e, instance.ID)
+	runInstance(e, instance.ID)
+	if got := loadInstance(t, e.db, instance.ID); got.Status != models.WorkflowStatusCompleted {
+		t.Errorf("status after resuming = %s, want completed", got.Status)
+	}
+}
+
+// An instance cancelled while its last step runs is not marked completed
+func TestCancelledInstanceStaysCancelled(t *testing.T) {
+	s, e := newTestInstanceService(t)
+	var instanceID uuid.UUID
+	e.RegisterDataSource("cancel", DataSourceFunc(func(ctx context.Context, key string) (interface{}, error) {
+		if _, err := s.Cancel(ctx, Actor{UserID: "operator"}, instanceID); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"cancelled": true}, nil
+	}))
+	template := createTestTemplate(t, e.db, models.WorkflowStepDefinition{
+		ID:   "check",
+		Type: models.StepTypeCondition,
+		Conditions: []models.StepCondition{
+			{Field: "cancel:instance.cancelled", Operator: "equals", Value: true},
+		},
+	})
+	instance := createTestInstance(t, e.db, template, models.WorkflowStatusRunning)
+	instanceID = instance.ID
+
+	start := time.Now()
+	runInstance(e, instance.ID)
+	got := loadInstance(t, e.db, instance.ID)
+	if got.Status != models.WorkflowStatusCancelled {
+		t.Errorf("status = %s, want cancelled", got.Status)
+	}
+	if got.CompletedAt == nil || got.CompletedAt.Before(start.Add(-time.Second)) {
+		t.Errorf("completed_at = %v, want the cancellation's", got.CompletedAt)
+	}
+}
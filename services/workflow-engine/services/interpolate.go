@@ -0,0 +1,151 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"chorus/workflow-engine/models"
+)
+
+// Value stored in input snapshots in place of secrets
+const maskedValue = "***"
+
+// Attempts kept in a step's input history; older ones are dropped
+const maxStepAttempts = 20
+
+// {{ name }}, {{ variables.name }} or {{ context.name }}; dots select nested fields
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// Config keys and variable names containing one of these are treated as secrets
+var secretKeyParts = []string{"password", "secret", "token", "api_key", "apikey", "authorization", "credential", "private_key"}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, part := range secretKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveStepConfig substitutes placeholders in the string values of a step config
+// with instance variables or context. A value consisting of a single placeholder
+// takes the referenced value as-is, so it may be a number or an object; unknown
+// placeholders are left untouched. It returns the config the step runs with and
+// a snapshot of it in which secrets are masked.
+func resolveStepConfig(config map[string]interface{}, instance *models.WorkflowInstance) (map[string]interface{}, models.JSONB) {
+	resolved := make(map[string]interface{}, len(config))
+	snapshot := make(models.JSONB, len(config))
+	for key, value := range config {
+		resolved[key], snapshot[key] = resolveValue(key, value, instance)
+	}
+	return resolved, snapshot
+}
+
+func resolveValue(key string, value interface{}, instance *models.WorkflowInstance) (interface{}, interface{}) {
+	switch v := value.(type) {
+	case string:
+		if isSecretKey(key) {
+			return interpolate(v, instance, false), maskedValue
+		}
+		return interpolate(v, instance, false), interpolate(v, instance, true)
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(v))
+		masked := make(map[string]interface{}, len(v))
+		for childKey, child := range v {
+			resolved[childKey], masked[childKey] = resolveValue(childKey, child, instance)
+		}
+		return resolved, masked
+	case []interface{}:
+		resolved := make([]interface{}, len(v))
+		masked := make([]interface{}, len(v))
+		for i, child := range v {
+			resolved[i], masked[i] = resolveValue(key, child, instance)
+		}
+		return resolved, masked
+	default:
+		if isSecretKey(key) {
+			return value, maskedValue
+		}
+		return value, value
+	}
+}
+
+// interpolate resolves the placeholders in s. With mask set, placeholders that
+// reference secret variables are replaced by the mask instead of their value.
+func interpolate(s string, instance *models.WorkflowInstance, mask bool) interface{} {
+	lookup := func(path string) (interface{}, bool) {
+		source := map[string]interface{}(instance.Variables)
+		if rest, ok := strings.CutPrefix(path, "variables."); ok {
+			path = rest
+		} else if rest, ok := strings.CutPrefix(path, "context."); ok {
+			source, path = instance.Context, rest
+		}
+		value, exists := lookupField(source, path)
+		if exists && mask && isSecretKey(path) {
+			return maskedValue, true
+		}
+		return value, exists
+	}
+
+	// A lone placeholder keeps the type of the referenced value
+	if match := placeholderPattern.FindStringSubmatch(s); match != nil && match[0] == strings.TrimSpace(s) {
+		if value, exists := lookup(match[1]); exists {
+			return value
+		}
+		return s
+	}
+
+	return placeholderPattern.ReplaceAllStringFunc(s, func(placeholder string) string {
+		value, exists := lookup(placeholderPattern.FindStringSubmatch(placeholder)[1])
+		if !exists {
+			return placeholder
+		}
+		if str, ok := value.(string); ok {
+			return str
+		}
+		if data, err := json.Marshal(value); err == nil {
+			return string(data)
+		}
+		return fmt.Sprint(value)
+	})
+}
+
+// definitionHash identifies the version of a step definition an attempt ran with
+func definitionHash(stepDef *models.WorkflowStepDefinition) string {
+	data, err := json.Marshal(stepDef)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// recordStepInputs stores the masked, resolved inputs of the attempt about to run as
+// the step's input data and appends them to its attempt history
+func (e *Executor) recordStepInputs(instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep, snapshot models.JSONB, startedAt time.Time) {
+	inline, payload := e.capPayload(step.ID, models.PayloadKindInput, snapshot)
+	if payload != nil {
+		if err := e.savePayload(payload, instance); err != nil {
+			e.logger.Error("Failed to offload step input", "step_id", step.ID, "error", err)
+		}
+	}
+
+	step.InputData = inline
+	step.DefinitionHash = definitionHash(stepDef)
+	step.Attempts = append(step.Attempts, models.StepAttempt{
+		Attempt:        step.RetryCount + 1,
+		DefinitionHash: step.DefinitionHash,
+		Inputs:         inline,
+		StartedAt:      startedAt,
+	})
+	if len(step.Attempts) > maxStepAttempts {
+		step.Attempts = step.Attempts[len(step.Attempts)-maxStepAttempts:]
+	}
+}
@@ -0,0 +1,130 @@
+package services
+
+import "chorus/pkg/metrics"
+
+// Engine metrics exposed on /metrics
+var (
+	stepSlowTotal = metrics.Default.Counter(
+		"workflow_step_slow_total",
+		"Step executions that exceeded their expected_duration_seconds budget",
+		"template_id", "step_id",
+	)
+
+	payloadsOffloadedTotal = metrics.Default.Counter(
+		"workflow_step_payloads_offloaded_total",
+		"Step inputs/outputs larger than MAX_STEP_PAYLOAD_SIZE stored in workflow.step_payloads",
+		"template_id", "kind",
+	)
+
+	queueWaitSeconds = metrics.Default.Histogram(
+		"workflow_instance_queue_wait_seconds",
+		"Time instances spent queued before an engine worker picked them up",
+		[]float64{0.01, 0.05, 0.1, 0.5, 1, 5, 15, 60, 300},
+		"template_id",
+	)
+
+	queueBacklog = metrics.Default.Gauge(
+		"workflow_queue_backlog",
+		"Instances queued in this replica and not yet picked up by a worker",
+	)
+
+	queueOldestAgeSeconds = metrics.Default.Gauge(
+		"workflow_queue_oldest_age_seconds",
+		"How long the oldest instance queued in this replica has been waiting",
+	)
+
+	queueLatencyP95Seconds = metrics.Default.Gauge(
+		"workflow_queue_latency_p95_seconds",
+		"95th percentile of queue wait over the last 5 minutes in this replica",
+	)
+
+	queueAgeAlertsTotal = metrics.Default.Counter(
+		"workflow_queue_age_alerts_total",
+		"Times the oldest queued instance went past QUEUE_AGE_ALERT_SECONDS",
+	)
+
+	outboundRequestsTotal = metrics.Default.Counter(
+		"workflow_outbound_requests_total",
+		"http_request actions sent, by destination profile and response status",
+		"destination", "status",
+	)
+
+	outboundRequestDuration = metrics.Default.Histogram(
+		"workflow_outbound_request_duration_seconds",
+		"Duration of http_request actions until the response headers arrived",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		"destination",
+	)
+
+	outboundInFlight = metrics.Default.Gauge(
+		"workflow_outbound_requests_in_flight",
+		"http_request actions currently waiting for a response",
+		"destination",
+	)
+
+	outboundOpenConnections = metrics.Default.Gauge(
+		"workflow_outbound_open_connections",
+		"Open connections in the outbound HTTP pool",
+		"destination",
+	)
+
+	outboundConnectionsTotal = metrics.Default.Counter(
+		"workflow_outbound_connections_total",
+		"Connections handed to http_request actions, by whether they were reused from the pool",
+		"destination", "reused",
+	)
+
+	panicsTotal = metrics.Default.Counter(
+		"workflow_panics_total",
+		"Panics recovered while processing instances, by where they were caught",
+		"component",
+	)
+
+	scheduleOccurrencesTotal = metrics.Default.Counter(
+		"workflow_schedule_occurrences_total",
+		"Due schedule trigger occurrences, by whether they were fired or skipped by the catch-up policy",
+		"outcome",
+	)
+
+	templateWebhookDeliveriesTotal = metrics.Default.Counter(
+		"workflow_template_webhook_deliveries_total",
+		"Template webhook delivery attempts, by event and whether they were delivered, retried or failed for good",
+		"event", "outcome",
+	)
+
+	eventsSkippedTotal = metrics.Default.Counter(
+		"workflow_events_skipped_total",
+		"Workflow events not acted on because they were already handled, were replayed or the instance is running in this process",
+		"type", "reason",
+	)
+
+	eventsReplayedTotal = metrics.Default.Counter(
+		"workflow_events_replayed_total",
+		"Kept workflow events republished by event replays, by type",
+		"type",
+	)
+
+	schemaCacheLookupsTotal = metrics.Default.Counter(
+		"workflow_schema_cache_lookups_total",
+		"Template schema lookups when an instance runs, by whether the parsed schema was cached",
+		"result",
+	)
+
+	stepHookVetoesTotal = metrics.Default.Counter(
+		"workflow_step_hook_vetoes_total",
+		"Steps failed by a step hook's policy, by hook",
+		"hook",
+	)
+
+	circuitBreakerTransitionsTotal = metrics.Default.Counter(
+		"workflow_circuit_breaker_transitions_total",
+		"Circuits of http_request destinations opened or closed by the circuit_breaker hook",
+		"destination", "state",
+	)
+
+	tasksEscalatedTotal = metrics.Default.Counter(
+		"workflow_tasks_escalated_total",
+		"Manual step tasks escalated past their due date, by whether they were handed to a fallback assignee",
+		"rerouted",
+	)
+)
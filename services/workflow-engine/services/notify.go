@@ -0,0 +1,253 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"chorus/pkg/tracing"
+	"chorus/workflow-engine/models"
+)
+
+// Outcomes reported by user notifications
+const (
+	outcomeCompleted = "completed"
+	outcomeFailed    = "failed"
+	outcomeRunning   = "running" // sent by notify_user steps
+)
+
+var errGatewayDisabled = errors.New("websocket gateway is not configured (GATEWAY_URL)")
+
+// userNotification is pushed by the gateway to every live connection of a user
+type userNotification struct {
+	Type         string                 `json:"type"` // always workflow_notification
+	InstanceID   string                 `json:"instance_id"`
+	InstanceName string                 `json:"instance_name"`
+	TemplateID   string                 `json:"template_id"`
+	TemplateName string                 `json:"template_name"`
+	Outcome      string                 `json:"outcome"` // completed, failed or running
+	Message      string                 `json:"message,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+	Outputs      map[string]interface{} `json:"outputs,omitempty"`
+	Timestamp    time.Time              `json:"timestamp"`
+}
+
+// gatewaySendResponse is the answer of the gateway's POST /users/{user_id}/send
+type gatewaySendResponse struct {
+	Online           bool     `json:"online"`
+	LocalConnections int      `json:"local_connections"`
+	Instances        []string `json:"instances"`
+	Stored           bool     `json:"stored"`
+}
+
+// newUserNotification describes an instance and its outcome, with the values of the
+// instance variables named by outputs
+func newUserNotification(instance *models.WorkflowInstance, outcome string, outputs []string) userNotification {
+	notification := userNotification{
+		Type:         "workflow_notification",
+		InstanceID:   instance.ID.String(),
+		InstanceName: instance.Name,
+		TemplateID:   instance.TemplateID.String(),
+		TemplateName: instance.Template.Name,
+		Outcome:      outcome,
+		Timestamp:    time.Now().UTC(),
+	}
+	if len(outputs) > 0 {
+		notification.Outputs = make(map[string]interface{}, len(outputs))
+		for _, name := range outputs {
+			if value, ok := instance.Variables[name]; ok {
+				notification.Outputs[name] = value
+			}
+		}
+	}
+	return notification
+}
+
+// startedByUser returns the user who started an instance, or "" for instances
+// started by a service key, a trigger or a webhook
+func startedByUser(instance *models.WorkflowInstance) string {
+	if instance.CreatedBy == "webhook" || strings.Contains(instance.CreatedBy, ":") {
+		return ""
+	}
+	return instance.CreatedBy
+}
+
+// sendToGateway asks the gateway to push a notification to the connections of userID
+func (e *Executor) sendToGateway(ctx context.Context, userID string, notification userNotification, persist bool) (*gatewaySendResponse, error) {
+	if e.config.Gateway.URL == "" {
+		return nil, errGatewayDisabled
+	}
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	target := e.config.Gateway.URL + "/users/" + url.PathEscape(userID) + "/send"
+	if persist {
+		target += "?persist_if_offline=true"
+	}
+	ctx, cancel := context.WithTimeout(ctx, e.config.Gateway.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.config.Gateway.APIKey != "" {
+		req.Header.Set("X-API-Key", e.config.Gateway.APIKey)
+	}
+
+	resp, err := tracing.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("gateway unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gateway returned status %d", resp.StatusCode)
+	}
+
+	var sent gatewaySendResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sent); err != nil {
+		return nil, errors.New("gateway returned an invalid response")
+	}
+	return &sent, nil
+}
+
+// deliverNotification pushes a notification to userID through the gateway. When the
+// user has no live connection, or the gateway cannot be reached, it is emailed to
+// fallbackEmail instead if set. Failures are reported in the returned delivery and
+// never as errors, so notifications cannot fail a workflow.
+func (e *Executor) deliverNotification(ctx context.Context, instance *models.WorkflowInstance, userID string, notification userNotification, fallbackEmail string, persist bool) map[string]interface{} {
+	delivery := map[string]interface{}{
+		"user_id":   userID,
+		"delivered": false,
+	}
+
+	sent, err := e.sendToGateway(ctx, userID, notification, persist)
+	if err != nil {
+		delivery["error"] = err.Error()
+		e.logger.Warn("Failed to notify user", "instance_id", instance.ID, "user_id", userID, "error", err)
+	} else {
+		delivery["delivered"] = sent.Online
+		delivery["local_connections"] = sent.LocalConnections
+		delivery["instances"] = len(sent.Instances)
+		delivery["stored"] = sent.Stored
+	}
+
+	if (sent == nil || !sent.Online) && fallbackEmail != "" {
+		subject := fmt.Sprintf("%s: %s", notification.TemplateName, notification.Outcome)
+		body := notification.Message
+		if body == "" {
+			body = fmt.Sprintf("Workflow %s is %s.", notification.InstanceName, notification.Outcome)
+		}
+		if notification.Error != "" {
+			body += "\n\n" + notification.Error
+		}
+		email, err := e.executeSendEmail(instance, &models.WorkflowStepDefinition{Config: map[string]interface{}{
+			"to":      fallbackEmail,
+			"subject": subject,
+			"body":    body,
+		}}, nil)
+		if err != nil {
+			delivery["fallback_error"] = err.Error()
+		} else {
+			delivery["fallback"] = "email"
+			delivery["email"] = email.Data
+		}
+	}
+
+	return delivery
+}
+
+// executeNotifyUser pushes a notification about the instance to user_id, by default
+// the user who started it. It succeeds whether or not the notification was
+// delivered; the step output tells.
+func (e *Executor) executeNotifyUser(ctx context.Context, instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep) (*StepResult, error) {
+	userID, _ := stepDef.Config["user_id"].(string)
+	if userID == "" {
+		userID = startedByUser(instance)
+	}
+	if userID == "" {
+		return nil, fmt.Errorf("user_id not specified for notify_user")
+	}
+
+	settings, _ := models.DecodeTemplateNotifications(instance.Template.Metadata)
+	outputs := settings.Outputs
+	if names := stringList(stepDef.Config["outputs"]); len(names) > 0 {
+		outputs = names
+	}
+	outcome, _ := stepDef.Config["outcome"].(string)
+	if outcome == "" {
+		outcome = outcomeRunning
+	}
+
+	notification := newUserNotification(instance, outcome, outputs)
+	notification.Message, _ = stepDef.Config["message"].(string)
+	fallbackEmail, _ := stepDef.Config["fallback_email"].(string)
+	if fallbackEmail == "" {
+		fallbackEmail, _ = interpolate(settings.FallbackEmail, instance, false).(string)
+	}
+	persist, _ := stepDef.Config["persist_if_offline"].(bool)
+
+	return &StepResult{
+		Success: true,
+		Data:    e.deliverNotification(ctx, instance, userID, notification, fallbackEmail, persist),
+	}, nil
+}
+
+// notifyOutcome pushes the outcome of a finished instance to the user who started it
+// when its template's metadata.notifications asks for it, and publishes the delivery
+// as a user_notified event
+func (e *Engine) notifyOutcome(instanceID uuid.UUID, outcome, errorMsg string) {
+	var instance models.WorkflowInstance
+	if err := e.db.Preload("Template").First(&instance, instanceID).Error; err != nil {
+		e.logger.Error("Failed to load instance for notification", "instance_id", instanceID, "error", err)
+		return
+	}
+
+	settings, err := models.DecodeTemplateNotifications(instance.Template.Metadata)
+	if err != nil {
+		e.logger.Warn("Invalid template notifications", "template_id", instance.TemplateID, "error", err)
+		return
+	}
+	if (outcome == outcomeCompleted && !settings.OnComplete) || (outcome == outcomeFailed && !settings.OnFailure) {
+		return
+	}
+	userID := startedByUser(&instance)
+	if userID == "" {
+		return
+	}
+
+	notification := newUserNotification(&instance, outcome, settings.Outputs)
+	notification.Error = errorMsg
+	fallbackEmail, _ := interpolate(settings.FallbackEmail, &instance, false).(string)
+
+	ctx := tracing.WithTraceParent(e.ctx, instance.TraceParent)
+	delivery := e.executor.deliverNotification(ctx, &instance, userID, notification, fallbackEmail, false)
+	e.executor.publishEvent(ctx, map[string]interface{}{
+		"type":        "user_notified",
+		"instance_id": instanceID.String(),
+		"user_id":     userID,
+		"outcome":     outcome,
+		"delivery":    delivery,
+		"is_test":     instance.IsTest,
+		"timestamp":   time.Now().Unix(),
+	})
+}
+
+// notifyOutcomeAsync runs notifyOutcome without holding up the instance's goroutine
+func (e *Engine) notifyOutcomeAsync(instanceID uuid.UUID, outcome, errorMsg string) {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.notifyOutcome(instanceID, outcome, errorMsg)
+	}()
+}
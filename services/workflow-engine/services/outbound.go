@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"chorus/pkg/tracing"
+	"chorus/workflow-engine/config"
+)
+
+// Label used for requests that do not reference a destination profile
+const defaultDestination = "default"
+
+type connectTimeoutKey struct{}
+
+// outboundClients holds the shared transports used by http_request actions: one
+// built from the global settings and one per named destination profile
+type outboundClients struct {
+	config       config.OutboundHTTPConfig
+	client       *http.Client
+	destinations map[string]*outboundDestination
+}
+
+type outboundDestination struct {
+	profile config.HTTPDestination
+	client  *http.Client
+}
+
+func newOutboundClients(cfg config.OutboundHTTPConfig) (*outboundClients, error) {
+	transport, err := newOutboundTransport(cfg, defaultDestination, cfg.CABundlePath, false, cfg.ProxyURL, cfg.ConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := &outboundClients{
+		config:       cfg,
+		client:       &http.Client{Transport: tracing.Transport(transport)},
+		destinations: make(map[string]*outboundDestination),
+	}
+
+	for name, profile := range cfg.Destinations {
+		caBundle := profile.CABundlePath
+		if caBundle == "" {
+			caBundle = cfg.CABundlePath
+		}
+		proxyURL := profile.ProxyURL
+		if proxyURL == "" {
+			proxyURL = cfg.ProxyURL
+		}
+		connectTimeout := cfg.ConnectTimeout
+		if profile.ConnectTimeoutSeconds > 0 {
+			connectTimeout = time.Duration(profile.ConnectTimeoutSeconds) * time.Second
+		}
+
+		transport, err := newOutboundTransport(cfg, name, caBundle, profile.InsecureSkipVerify, proxyURL, connectTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("destination %q: %w", name, err)
+		}
+		clients.destinations[name] = &outboundDestination{
+			profile: profile,
+			client:  &http.Client{Transport: tracing.Transport(transport)},
+		}
+	}
+
+	return clients, nil
+}
+
+func newOutboundTransport(cfg config.OutboundHTTPConfig, destination, caBundle string, insecure bool, proxyURL string, connectTimeout time.Duration) (*http.Transport, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: insecure,
+	}
+	if caBundle != "" {
+		pem, err := os.ReadFile(caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %s contains no certificates", caBundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if proxyURL != "" {
+		parsed, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		proxy = http.ProxyURL(parsed)
+	}
+
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           countingDialer(destination, connectTimeout),
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   connectTimeout,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		ExpectContinueTimeout: time.Second,
+	}, nil
+}
+
+// countingDialer dials with the connect timeout of the request (set per step through
+// the context) and keeps the open connection gauge of the destination up to date
+func countingDialer(destination string, connectTimeout time.Duration) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		timeout := connectTimeout
+		if override, ok := ctx.Value(connectTimeoutKey{}).(time.Duration); ok && override > 0 {
+			timeout = override
+		}
+
+		dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		outboundOpenConnections.Add(1, destination)
+		return &countedConn{Conn: conn, destination: destination}, nil
+	}
+}
+
+type countedConn struct {
+	net.Conn
+	destination string
+	closeOnce   sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.closeOnce.Do(func() { outboundOpenConnections.Add(-1, c.destination) })
+	return c.Conn.Close()
+}
+
+// resolve returns the client and profile for a step's destination ("" for none)
+func (o *outboundClients) resolve(destination string) (*http.Client, *config.HTTPDestination, error) {
+	if destination == "" {
+		return o.client, nil, nil
+	}
+	dest, ok := o.destinations[destination]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown HTTP destination: %s", destination)
+	}
+	return dest.client, &dest.profile, nil
+}
+
+// do sends req through client, recording pool and request metrics under destination
+func (o *outboundClients) do(client *http.Client, req *http.Request, destination string) (*http.Response, error) {
+	if destination == "" {
+		destination = defaultDestination
+	}
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			outboundConnectionsTotal.Inc(destination, strconv.FormatBool(info.Reused))
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	outboundInFlight.Add(1, destination)
+	defer outboundInFlight.Add(-1, destination)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	outboundRequestDuration.Observe(time.Since(start).Seconds(), destination)
+	if err != nil {
+		outboundRequestsTotal.Inc(destination, "error")
+		return nil, err
+	}
+	outboundRequestsTotal.Inc(destination, strconv.Itoa(resp.StatusCode))
+	return resp, nil
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"encoding/json"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"chorus/workflow-engine/models"
+)
+
+// Number of bytes of the serialized payload kept inline when a payload is offloaded
+const payloadPreviewSize = 2048
+
+// capPayload checks data against the configured payload limit. When the limit is
+// exceeded it returns an inline preview referencing a StepPayload holding the full
+// data; the caller must persist the payload once the step row exists.
+func (e *Executor) capPayload(stepID uuid.UUID, kind string, data models.JSONB) (models.JSONB, *models.StepPayload) {
+	limit := e.config.MaxStepPayloadSize
+	if limit <= 0 || data == nil {
+		return data, nil
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil || len(raw) <= limit {
+		return data, nil
+	}
+
+	payload := &models.StepPayload{
+		ID:        uuid.New(),
+		StepID:    stepID,
+		Kind:      kind,
+		Data:      data,
+		SizeBytes: len(raw),
+	}
+
+	inline := models.JSONB{
+		"_truncated":  true,
+		"_payload_id": payload.ID.String(),
+		"_size_bytes": len(raw),
+		"preview":     truncateUTF8(raw, payloadPreviewSize),
+	}
+
+	return inline, payload
+}
+
+// savePayload stores an offloaded payload of a step of instance, replacing any
+// earlier payload of the same kind. Payloads of encrypted instances are encrypted.
+func (e *Executor) savePayload(payload *models.StepPayload, instance *models.WorkflowInstance) error {
+	payload.Encrypted = instance.Encrypted
+	if err := e.db.Where("step_id = ? AND kind = ?", payload.StepID, payload.Kind).
+		Delete(&models.StepPayload{}).Error; err != nil {
+		return err
+	}
+	if err := e.db.Create(payload).Error; err != nil {
+		return err
+	}
+
+	payloadsOffloadedTotal.Inc(instance.TemplateID.String(), payload.Kind)
+	e.logger.Info("Step payload offloaded",
+		"step_id", payload.StepID,
+		"kind", payload.Kind,
+		"size_bytes", payload.SizeBytes,
+	)
+	return nil
+}
+
+// truncateUTF8 returns at most n bytes of raw without splitting a multi-byte character
+func truncateUTF8(raw []byte, n int) string {
+	if len(raw) <= n {
+		return string(raw)
+	}
+	for n > 0 && !utf8.RuneStart(raw[n]) {
+		n--
+	}
+	return string(raw[:n])
+}
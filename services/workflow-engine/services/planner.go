@@ -0,0 +1,158 @@
+package services
+
+import (
+	"slices"
+
+	"chorus/workflow-engine/models"
+)
+
+// executionPlan is the step graph of a schema as the planner walks it. A step runs
+// once its dependencies are satisfied: right away for a step with one predecessor,
+// and for a join, a step with several, once every predecessor finished or was left
+// out by a condition. A fork step starts all of its next steps as concurrent
+// branches; any other step takes one edge, and its other next steps count as left
+// out, so templates written before forks run as they did.
+//
+// Edges leading back to a step on the way to them (loops) do not make joins; taking
+// one makes its target ready again.
+type executionPlan struct {
+	steps map[string]*models.WorkflowStepDefinition
+	start string
+	preds map[string][]string // forward predecessors of each reachable step
+	back  map[[2]string]bool  // loop edges, from and to
+}
+
+func newExecutionPlan(schema *models.WorkflowSchema) *executionPlan {
+	plan := &executionPlan{
+		steps: make(map[string]*models.WorkflowStepDefinition, len(schema.Steps)),
+		start: schema.Steps[0].ID,
+		preds: make(map[string][]string),
+		back:  make(map[[2]string]bool),
+	}
+	for i := range schema.Steps {
+		plan.steps[schema.Steps[i].ID] = &schema.Steps[i]
+	}
+
+	// Depth-first from the start step: an edge to a step still on the path is a loop
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+	state := make(map[string]int, len(plan.steps))
+	var visit func(id string)
+	visit = func(id string) {
+		state[id] = onPath
+		if step := plan.steps[id]; step != nil {
+			for _, next := range step.NextSteps {
+				switch state[next] {
+				case onPath:
+					plan.back[[2]string{id, next}] = true
+					continue
+				case unvisited:
+					visit(next)
+				}
+				if !slices.Contains(plan.preds[next], id) {
+					plan.preds[next] = append(plan.preds[next], id)
+				}
+			}
+		}
+		state[id] = done
+	}
+	visit(plan.start)
+	return plan
+}
+
+// newFrontier returns the frontier of an instance that has none: its current step
+// for instances that ran before frontiers were kept, otherwise the start step
+func (p *executionPlan) newFrontier(currentStep string) *models.ExecutionFrontier {
+	if currentStep == "" {
+		currentStep = p.start
+	}
+	return &models.ExecutionFrontier{Ready: []string{currentStep}}
+}
+
+// follows returns whether finishing stepID takes the edge to a next step, given
+// the next step the engine determined for it: every edge of a fork, otherwise only
+// the one to chosen
+func (p *executionPlan) follows(stepID, chosen string) func(next string) bool {
+	step := p.steps[stepID]
+	fork := step != nil && step.Fork && step.Type != models.StepTypeCondition
+	return func(next string) bool {
+		return fork || next == chosen
+	}
+}
+
+// complete removes a finished step from the frontier and follows its edges, taken
+// to next unless next was left out by a condition
+func (p *executionPlan) complete(frontier *models.ExecutionFrontier, stepID string, taken func(next string) bool) {
+	frontier.Ready = slices.DeleteFunc(frontier.Ready, func(id string) bool { return id == stepID })
+	step := p.steps[stepID]
+	if step == nil {
+		return
+	}
+	for _, next := range step.NextSteps {
+		p.arrive(frontier, stepID, next, taken(next))
+	}
+}
+
+// arrive follows the edge from one step to another
+func (p *executionPlan) arrive(frontier *models.ExecutionFrontier, from, to string, taken bool) {
+	if p.back[[2]string{from, to}] {
+		if taken {
+			p.enter(frontier, to)
+		}
+		return
+	}
+
+	preds := p.preds[to]
+	if len(preds) <= 1 {
+		if taken {
+			p.enter(frontier, to)
+		} else {
+			p.skip(frontier, to)
+		}
+		return
+	}
+
+	if frontier.Arrived == nil {
+		frontier.Arrived = make(map[string]map[string]bool)
+	}
+	arrived := frontier.Arrived[to]
+	if arrived == nil {
+		arrived = make(map[string]bool, len(preds))
+		frontier.Arrived[to] = arrived
+	}
+	arrived[from] = taken
+	if len(arrived) < len(preds) {
+		return
+	}
+
+	delete(frontier.Arrived, to)
+	for _, wasTaken := range arrived {
+		if wasTaken {
+			p.enter(frontier, to)
+			return
+		}
+	}
+	p.skip(frontier, to)
+}
+
+// enter makes a step ready
+func (p *executionPlan) enter(frontier *models.ExecutionFrontier, stepID string) {
+	if !slices.Contains(frontier.Ready, stepID) {
+		frontier.Ready = append(frontier.Ready, stepID)
+	}
+}
+
+// skip leaves out a step no taken edge leads to, and with it the edges it would
+// have taken, so joins after it do not wait for it
+func (p *executionPlan) skip(frontier *models.ExecutionFrontier, stepID string) {
+	step := p.steps[stepID]
+	if step == nil {
+		return
+	}
+	for _, next := range step.NextSteps {
+		p.arrive(frontier, stepID, next, false)
+	}
+}
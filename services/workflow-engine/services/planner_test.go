@@ -0,0 +1,259 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"testing"
+
+	"chorus/workflow-engine/models"
+)
+
+// testPlan makes the plan of a schema whose steps are given as ID and next steps;
+// the steps named in conditions are condition steps, the others fork actions
+func testPlan(steps [][]string, conditions ...string) *executionPlan {
+	schema := &models.WorkflowSchema{}
+	for _, step := range steps {
+		stepType := models.StepTypeAction
+		if slices.Contains(conditions, step[0]) {
+			stepType = models.StepTypeCondition
+		}
+		schema.Steps = append(schema.Steps, models.WorkflowStepDefinition{
+			ID:        step[0],
+			Type:      stepType,
+			NextSteps: step[1:],
+			Fork:      stepType != models.StepTypeCondition,
+		})
+	}
+	return newExecutionPlan(schema)
+}
+
+// finish completes a ready step, taking the edges to the next steps named, or to
+// all of them when none is
+func finish(t *testing.T, plan *executionPlan, frontier *models.ExecutionFrontier, stepID string, taken ...string) {
+	t.Helper()
+	if !slices.Contains(frontier.Ready, stepID) {
+		t.Fatalf("%s finished while not ready, ready %v", stepID, frontier.Ready)
+	}
+	plan.complete(frontier, stepID, func(next string) bool {
+		return len(taken) == 0 || slices.Contains(taken, next)
+	})
+}
+
+// expectReady fails the test unless the frontier's ready steps are want, in order
+func expectReady(t *testing.T, frontier *models.ExecutionFrontier, want ...string) {
+	t.Helper()
+	if !slices.Equal(frontier.Ready, want) {
+		t.Fatalf("ready %v, want %v", frontier.Ready, want)
+	}
+}
+
+// A step with several next steps starts them all, and a join waits for every one
+func TestPlanFanOutAndJoin(t *testing.T) {
+	plan := testPlan([][]string{
+		{"fetch", "email", "slack", "audit"},
+		{"email", "join"},
+		{"slack", "join"},
+		{"audit", "join"},
+		{"join", "done"},
+		{"done"},
+	})
+	if preds := plan.preds["join"]; len(preds) != 3 {
+		t.Fatalf("join has predecessors %v, want email, slack and audit", preds)
+	}
+
+	frontier := plan.newFrontier("")
+	expectReady(t, frontier, "fetch")
+	finish(t, plan, frontier, "fetch")
+	expectReady(t, frontier, "email", "slack", "audit")
+
+	finish(t, plan, frontier, "slack")
+	finish(t, plan, frontier, "email")
+	expectReady(t, frontier, "audit")
+	if arrived := frontier.Arrived["join"]; !reflect.DeepEqual(arrived, map[string]bool{"email": true, "slack": true}) {
+		t.Errorf("arrived at join %v, want email and slack", arrived)
+	}
+
+	finish(t, plan, frontier, "audit")
+	expectReady(t, frontier, "join")
+	if len(frontier.Arrived) != 0 {
+		t.Errorf("arrived %v after the join, want none", frontier.Arrived)
+	}
+	finish(t, plan, frontier, "join")
+	finish(t, plan, frontier, "done")
+	expectReady(t, frontier)
+}
+
+// The branch a condition does not take is left out up to the join, which then
+// waits only for the branch taken
+func TestPlanConditionSkipsUntakenBranch(t *testing.T) {
+	plan := testPlan([][]string{
+		{"check", "approve", "reject"},
+		{"approve", "notify"},
+		{"reject", "log", "undo"},
+		{"log", "notify"},
+		{"undo", "notify"},
+		{"notify"},
+	}, "check")
+
+	frontier := plan.newFrontier("")
+	finish(t, plan, frontier, "check", "approve")
+	expectReady(t, frontier, "approve")
+	// reject, log and undo are left out, and their edges reach notify as not taken
+	if arrived := frontier.Arrived["notify"]; !reflect.DeepEqual(arrived, map[string]bool{"log": false, "undo": false}) {
+		t.Errorf("arrived at notify %v, want log and undo left out", arrived)
+	}
+
+	finish(t, plan, frontier, "approve")
+	expectReady(t, frontier, "notify")
+	finish(t, plan, frontier, "notify")
+	expectReady(t, frontier)
+}
+
+// A join none of whose predecessors ran is left out too, and so are the steps only
+// it leads to
+func TestPlanSkipsJoinOfUntakenBranches(t *testing.T) {
+	plan := testPlan([][]string{
+		{"check", "quick", "split"},
+		{"quick", "end"},
+		{"split", "left", "right"},
+		{"left", "join"},
+		{"right", "join"},
+		{"join", "report"},
+		{"report", "end"},
+		{"end"},
+	}, "check")
+
+	frontier := plan.newFrontier("")
+	finish(t, plan, frontier, "check", "quick")
+	expectReady(t, frontier, "quick")
+	if _, ok := frontier.Arrived["join"]; ok {
+		t.Errorf("join still waits on %v after both its predecessors were left out", frontier.Arrived["join"])
+	}
+	if arrived := frontier.Arrived["end"]; !reflect.DeepEqual(arrived, map[string]bool{"report": false}) {
+		t.Errorf("arrived at end %v, want report left out", arrived)
+	}
+
+	finish(t, plan, frontier, "quick")
+	expectReady(t, frontier, "end")
+}
+
+// An edge back to an earlier step makes a loop rather than a join, and taking it
+// makes that step ready again
+func TestPlanLoop(t *testing.T) {
+	plan := testPlan([][]string{
+		{"poll", "check"},
+		{"check", "poll", "done"},
+		{"done"},
+	}, "check")
+	if !plan.back[[2]string{"check", "poll"}] {
+		t.Fatalf("check to poll is not a loop edge, loops %v", plan.back)
+	}
+	if preds := plan.preds["poll"]; len(preds) != 0 {
+		t.Errorf("poll has predecessors %v, want none: the loop edge must not make it a join", preds)
+	}
+
+	frontier := plan.newFrontier("")
+	for range 3 {
+		finish(t, plan, frontier, "poll")
+		expectReady(t, frontier, "check")
+		finish(t, plan, frontier, "check", "poll")
+		expectReady(t, frontier, "poll")
+	}
+	finish(t, plan, frontier, "poll")
+	finish(t, plan, frontier, "check", "done")
+	expectReady(t, frontier, "done")
+	if len(frontier.Arrived) != 0 {
+		t.Errorf("arrived %v, want none", frontier.Arrived)
+	}
+}
+
+// A loop around a fan-out and its join runs the branches again on every pass
+func TestPlanLoopAroundJoin(t *testing.T) {
+	plan := testPlan([][]string{
+		{"start", "a", "b"},
+		{"a", "join"},
+		{"b", "join"},
+		{"join", "start", "done"},
+		{"done"},
+	}, "join")
+
+	frontier := plan.newFrontier("")
+	for range 2 {
+		finish(t, plan, frontier, "start")
+		expectReady(t, frontier, "a", "b")
+		finish(t, plan, frontier, "a")
+		finish(t, plan, frontier, "b")
+		expectReady(t, frontier, "join")
+		finish(t, plan, frontier, "join", "start")
+		expectReady(t, frontier, "start")
+	}
+	finish(t, plan, frontier, "start")
+	finish(t, plan, frontier, "b")
+	finish(t, plan, frontier, "a")
+	finish(t, plan, frontier, "join", "done")
+	expectReady(t, frontier, "done")
+}
+
+// A frontier stored midway, with a join half arrived, continues where it was under
+// a plan made again from the schema
+func TestPlanResumesFromSavedFrontier(t *testing.T) {
+	steps := [][]string{
+		{"fetch", "email", "slack"},
+		{"email", "join"},
+		{"slack", "join"},
+		{"join"},
+	}
+	plan := testPlan(steps)
+	frontier := plan.newFrontier("")
+	finish(t, plan, frontier, "fetch")
+	finish(t, plan, frontier, "email")
+
+	data, err := json.Marshal(frontier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved models.ExecutionFrontier
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := testPlan(steps)
+	expectReady(t, &saved, "slack")
+	finish(t, resumed, &saved, "slack")
+	expectReady(t, &saved, "join")
+}
+
+// A template written before forks, whose action lists several next steps, follows
+// the first of them only, as the engine did before it ran branches
+func TestPlanWithoutForkFollowsFirstNextStep(t *testing.T) {
+	schema := &models.WorkflowSchema{Steps: []models.WorkflowStepDefinition{
+		{ID: "fetch", Type: models.StepTypeAction, NextSteps: []string{"email", "slack"}},
+		{ID: "email", Type: models.StepTypeAction, NextSteps: []string{"done"}},
+		{ID: "slack", Type: models.StepTypeAction, NextSteps: []string{"done"}},
+		{ID: "done", Type: models.StepTypeAction},
+	}}
+	plan := newExecutionPlan(schema)
+	engine := &Engine{}
+
+	frontier := plan.newFrontier("")
+	for _, want := range [][]string{{"email"}, {"done"}, {}} {
+		stepID := frontier.Ready[0]
+		chosen, err := engine.determineNextStep(plan.steps[stepID], &StepResult{Success: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan.complete(frontier, stepID, plan.follows(stepID, chosen))
+		expectReady(t, frontier, want...)
+	}
+	if len(frontier.Arrived) != 0 {
+		t.Errorf("arrived %v, want none: slack is left out rather than awaited", frontier.Arrived)
+	}
+}
+
+// Instances that ran before frontiers were kept continue from their current step
+func TestPlanNewFrontier(t *testing.T) {
+	plan := testPlan([][]string{{"first", "second"}, {"second"}})
+	expectReady(t, plan.newFrontier(""), "first")
+	expectReady(t, plan.newFrontier("second"), "second")
+}
@@ -0,0 +1,334 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"chorus/pkg/eventbus"
+	"chorus/pkg/tracing"
+	"chorus/workflow-engine/config"
+	"chorus/workflow-engine/models"
+)
+
+// Most users a check_presence step may ask about
+const maxPresenceUsers = 100
+
+const presenceOffline = "offline"
+
+// Statuses of the presence service that steps may wait for
+var presenceStatuses = []string{"online", "away", "busy", "dnd", presenceOffline}
+
+var errPresenceDisabled = errors.New("presence service is not configured (PRESENCE_URL)")
+
+// errStepWaiting is returned by ExecuteStep for a step that parked its instance until
+// an event resumes it
+var errStepWaiting = errors.New("step is waiting")
+
+// presenceWait is the state of a waiting wait_for_presence step, kept in its output
+// data as "presence_wait" so the wait outlives the engine process
+type presenceWait struct {
+	UserID   string    `json:"user_id"`
+	Statuses []string  `json:"statuses"` // any of which ends the wait
+	Status   string    `json:"status"`   // last known status of the user
+	Since    time.Time `json:"since"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// readPresenceWait returns the wait state stored in the output data of a step, and
+// false without one
+func readPresenceWait(data models.JSONB) (presenceWait, bool) {
+	var wait presenceWait
+	raw, ok := data["presence_wait"]
+	if !ok {
+		return wait, false
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil || json.Unmarshal(encoded, &wait) != nil || wait.UserID == "" {
+		return wait, false
+	}
+	return wait, true
+}
+
+// stringList reads a config value holding a string or a list of strings
+func stringList(value interface{}) []string {
+	var values []string
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			values = append(values, v)
+		}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" && !slices.Contains(values, s) {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+// presenceFallback returns the on_unavailable of a step, or PRESENCE_ON_UNAVAILABLE
+func (e *Executor) presenceFallback(stepConfig models.JSONB) (string, error) {
+	fallback, _ := stepConfig["on_unavailable"].(string)
+	switch fallback {
+	case "":
+		return e.config.Presence.OnUnavailable, nil
+	case config.PresenceUnavailableFail, config.PresenceUnavailableAssumeOffline:
+		return fallback, nil
+	default:
+		return "", fmt.Errorf("on_unavailable must be fail or assume_offline, not %q", fallback)
+	}
+}
+
+// presenceStatus asks the presence service for the current status of userID
+func (e *Executor) presenceStatus(ctx context.Context, userID string) (string, error) {
+	if e.config.Presence.URL == "" {
+		return "", errPresenceDisabled
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.config.Presence.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.config.Presence.URL+"/presence/status?user_id="+url.QueryEscape(userID), nil)
+	if err != nil {
+		return "", err
+	}
+	if e.config.Presence.APIKey != "" {
+		req.Header.Set("X-API-Key", e.config.Presence.APIKey)
+	}
+
+	resp, err := tracing.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("presence service unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("presence service returned status %d", resp.StatusCode)
+	}
+
+	var status struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxHTTPResponseSize)).Decode(&status); err != nil || status.Status == "" {
+		return "", errors.New("presence service returned no status")
+	}
+	return status.Status, nil
+}
+
+// executeCheckPresence reads the current status of the users of user_ids, or of
+// user_id, from the presence service. A status that cannot be read fails the step,
+// or counts as offline with on_unavailable "assume_offline".
+func (e *Executor) executeCheckPresence(ctx context.Context, instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep) (*StepResult, error) {
+	userIDs := stringList(stepDef.Config["user_ids"])
+	if len(userIDs) == 0 {
+		userIDs = stringList(stepDef.Config["user_id"])
+	}
+	if len(userIDs) == 0 {
+		return nil, fmt.Errorf("user_ids not specified for check_presence")
+	}
+	if len(userIDs) > maxPresenceUsers {
+		return nil, fmt.Errorf("check_presence accepts at most %d users, not %d", maxPresenceUsers, len(userIDs))
+	}
+	fallback, err := e.presenceFallback(stepDef.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]string, len(userIDs))
+	errs := make([]error, len(userIDs))
+	var wg sync.WaitGroup
+	for i, userID := range userIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i], errs[i] = e.presenceStatus(ctx, userID)
+		}()
+	}
+	wg.Wait()
+
+	byUser := make(map[string]interface{}, len(userIDs))
+	online := []string{}
+	unavailable := []string{}
+	for i, userID := range userIDs {
+		if errs[i] != nil {
+			if fallback == config.PresenceUnavailableFail {
+				return nil, fmt.Errorf("failed to check presence of %s: %w", userID, errs[i])
+			}
+			statuses[i] = presenceOffline
+			unavailable = append(unavailable, userID)
+		}
+		byUser[userID] = statuses[i]
+		if statuses[i] == "online" {
+			online = append(online, userID)
+		}
+	}
+	if len(unavailable) > 0 {
+		e.logger.Warn("Presence unavailable, assuming offline", "instance_id", instance.ID, "step_id", stepDef.ID, "users", len(unavailable), "error", errors.Join(errs...))
+	}
+
+	data := map[string]interface{}{
+		"statuses":    byUser,
+		"online":      online,
+		"any_online":  len(online) > 0,
+		"all_online":  len(online) == len(userIDs),
+		"unavailable": unavailable,
+	}
+	if len(userIDs) == 1 {
+		data["status"] = statuses[0]
+	}
+	return &StepResult{Success: true, Data: data}, nil
+}
+
+// executeWaitForPresence waits until user_id has one of the statuses of status,
+// online by default, or timeout_seconds have passed. Rather than holding a goroutine
+// the step is parked as waiting with its state in the output data, and the engine
+// resumes it on a matching presence event, once the deadline has passed, and at
+// startup. Each resume that is not for a matching event asks the presence service
+// again.
+func (e *Executor) executeWaitForPresence(ctx context.Context, instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep) (*StepResult, error) {
+	now := time.Now()
+	wait, resumed := readPresenceWait(step.OutputData)
+	if !resumed {
+		userID, _ := stepDef.Config["user_id"].(string)
+		if userID == "" {
+			return nil, fmt.Errorf("user_id not specified for presence wait")
+		}
+		statuses := stringList(stepDef.Config["status"])
+		if len(statuses) == 0 {
+			statuses = []string{"online"}
+		}
+		for _, status := range statuses {
+			if !slices.Contains(presenceStatuses, status) {
+				return nil, fmt.Errorf("unknown presence status %q", status)
+			}
+		}
+		timeout, ok := stepDef.Config["timeout_seconds"].(float64)
+		if !ok || timeout <= 0 {
+			return nil, fmt.Errorf("timeout_seconds must be positive for presence wait")
+		}
+		fallback, err := e.presenceFallback(stepDef.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		status, err := e.presenceStatus(ctx, userID)
+		if err != nil {
+			if fallback == config.PresenceUnavailableFail {
+				return nil, fmt.Errorf("failed to check presence of %s: %w", userID, err)
+			}
+			e.logger.Warn("Presence unavailable, assuming offline", "instance_id", instance.ID, "step_id", stepDef.ID, "error", err)
+			status = presenceOffline
+		}
+		wait = presenceWait{
+			UserID:   userID,
+			Statuses: statuses,
+			Status:   status,
+			Since:    now,
+			Deadline: now.Add(time.Duration(timeout * float64(time.Second))),
+		}
+	} else if !slices.Contains(wait.Statuses, wait.Status) {
+		// Resumed at the deadline or at startup, when events may have been missed;
+		// the wait goes on without the presence service
+		if status, err := e.presenceStatus(ctx, wait.UserID); err == nil {
+			wait.Status = status
+		} else {
+			e.logger.Warn("Failed to recheck presence", "instance_id", instance.ID, "step_id", stepDef.ID, "error", err)
+		}
+	}
+
+	if reached := slices.Contains(wait.Statuses, wait.Status); reached || !now.Before(wait.Deadline) {
+		return &StepResult{Success: true, Data: map[string]interface{}{
+			"user_id":        wait.UserID,
+			"status":         wait.Status,
+			"timed_out":      !reached,
+			"waited_seconds": int(now.Sub(wait.Since).Seconds()),
+		}}, nil
+	}
+
+	step.OutputData = models.JSONB{"presence_wait": wait}
+	e.logger.Info("Waiting for presence", "instance_id", instance.ID, "step_id", stepDef.ID, "user_id", wait.UserID, "statuses", wait.Statuses, "deadline", wait.Deadline)
+	return nil, errStepWaiting
+}
+
+// handlePresenceEvent fires the presence triggers matching a status change and
+// resumes the wait_for_presence steps waiting for the new status
+func (e *Engine) handlePresenceEvent(msg eventbus.Message[eventbus.PresenceEvent]) {
+	if !msg.Data.StatusChange() {
+		return
+	}
+
+	e.firePresenceTriggers(msg.Data, decodeConditionData(msg.Payload))
+	e.resumePresenceWaits(msg.Data)
+}
+
+// resumePresenceWaits resumes the wait_for_presence steps waiting for the status a
+// user changed to
+func (e *Engine) resumePresenceWaits(event eventbus.PresenceEvent) {
+	var steps []models.WorkflowStep
+	if err := e.db.Select("id", "instance_id", "output_data").
+		Where("status = ? AND output_data->'presence_wait'->>'user_id' = ?", models.StepStatusWaiting, event.UserID).
+		Find(&steps).Error; err != nil {
+		e.logger.Error("Failed to fetch presence waits", "user_id", event.UserID, "error", err)
+		return
+	}
+
+	for _, step := range steps {
+		wait, ok := readPresenceWait(step.OutputData)
+		if !ok || !slices.Contains(wait.Statuses, event.NewStatus) {
+			continue
+		}
+
+		// Every replica gets the event; the one recording the status resumes the step
+		result := e.db.Model(&models.WorkflowStep{}).
+			Where("id = ? AND status = ? AND output_data->'presence_wait'->>'status' IS DISTINCT FROM ?", step.ID, models.StepStatusWaiting, event.NewStatus).
+			Update("output_data", gorm.Expr(`jsonb_set(output_data, '{presence_wait,status}', to_jsonb(?::text))`, event.NewStatus))
+		if result.Error != nil {
+			e.logger.Error("Failed to record presence", "step_id", step.ID, "error", result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		if err := e.QueueInstance(step.InstanceID); err != nil {
+			e.logger.Error("Failed to queue instance after presence change", "instance_id", step.InstanceID, "error", err)
+		}
+	}
+}
+
+// checkPresenceWaits resumes the wait_for_presence steps of running instances that
+// are past their deadline, or whose status was recorded but could not be queued; or
+// all of them, at startup, to catch up with events missed while the engine was down
+func (e *Engine) checkPresenceWaits(all bool) {
+	running := e.db.Model(&models.WorkflowInstance{}).Select("id").Where("status = ?", models.WorkflowStatusRunning)
+
+	var steps []models.WorkflowStep
+	if err := e.db.Select("id", "instance_id", "output_data").
+		Where("status = ? AND output_data->'presence_wait' IS NOT NULL AND instance_id IN (?)", models.StepStatusWaiting, running).
+		Find(&steps).Error; err != nil {
+		e.logger.Error("Failed to fetch presence waits", "error", err)
+		return
+	}
+
+	now := time.Now()
+	for _, step := range steps {
+		wait, ok := readPresenceWait(step.OutputData)
+		if !ok {
+			continue
+		}
+		if all || !now.Before(wait.Deadline) || slices.Contains(wait.Statuses, wait.Status) {
+			if err := e.QueueInstance(step.InstanceID); err != nil {
+				e.logger.Error("Failed to queue instance waiting for presence", "instance_id", step.InstanceID, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"chorus/pkg/tracing"
+	"chorus/workflow-engine/models"
+)
+
+// Statuses a set_presence step may set
+var presenceOverrideStatuses = []string{"online", "away", "busy"}
+
+// presenceOverride is kept in the output data of a set_presence step with
+// revert_on_end as "presence_override", until the override is cleared after the
+// instance ends
+type presenceOverride struct {
+	UserID   string `json:"user_id"`
+	Source   string `json:"source"`
+	Reverted bool   `json:"reverted"`
+}
+
+// presenceOverrideRequest is the body of the presence service's PUT /presence/override;
+// without a status it clears the override set by source
+type presenceOverrideRequest struct {
+	UserID        string `json:"user_id"`
+	Status        string `json:"status,omitempty"`
+	StatusMessage string `json:"status_message,omitempty"`
+	Source        string `json:"source"`
+	TTLSeconds    int    `json:"ttl_seconds,omitempty"`
+}
+
+type presenceOverrideResponse struct {
+	Status   string `json:"status"` // effective status of the user
+	Cleared  bool   `json:"cleared"`
+	Override *struct {
+		ExpiresAt *time.Time `json:"expires_at"`
+	} `json:"override"`
+}
+
+// presenceOverrideSource names the overrides of an instance, so that it only ever
+// clears its own
+func presenceOverrideSource(instanceID uuid.UUID) string {
+	return "workflow:" + instanceID.String()
+}
+
+// readPresenceOverride returns the override stored in the output data of a step, and
+// false without one
+func readPresenceOverride(data models.JSONB) (presenceOverride, bool) {
+	var override presenceOverride
+	raw, ok := data["presence_override"]
+	if !ok {
+		return override, false
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil || json.Unmarshal(encoded, &override) != nil || override.UserID == "" {
+		return override, false
+	}
+	return override, true
+}
+
+// putPresenceOverride sets or clears a status override through the presence service,
+// with the engine's service credentials
+func (e *Executor) putPresenceOverride(ctx context.Context, override presenceOverrideRequest) (*presenceOverrideResponse, error) {
+	if e.config.Presence.URL == "" {
+		return nil, errPresenceDisabled
+	}
+	body, err := json.Marshal(override)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode status override: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.config.Presence.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, e.config.Presence.URL+"/presence/override", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.config.Presence.APIKey != "" {
+		req.Header.Set("X-API-Key", e.config.Presence.APIKey)
+	}
+
+	resp, err := tracing.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("presence service unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("presence service returned status %d", resp.StatusCode)
+	}
+
+	var result presenceOverrideResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxHTTPResponseSize)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("presence service returned an invalid response")
+	}
+	return &result, nil
+}
+
+// executeSetPresence overrides the status of user_id with status (online, away or
+// busy) and an optional status_message in the presence service, or with clear
+// removes the override the instance set. The override lapses after
+// revert_after_seconds if given, and with revert_on_end it is cleared once the
+// instance completes, fails or is cancelled, so a workflow that stops early does not
+// leave the user busy.
+func (e *Executor) executeSetPresence(ctx context.Context, instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep) (*StepResult, error) {
+	userID, _ := stepDef.Config["user_id"].(string)
+	if userID == "" {
+		return nil, fmt.Errorf("user_id not specified for set_presence")
+	}
+	clear, _ := stepDef.Config["clear"].(bool)
+	revertOnEnd, _ := stepDef.Config["revert_on_end"].(bool)
+
+	req := presenceOverrideRequest{UserID: userID, Source: presenceOverrideSource(instance.ID)}
+	if !clear {
+		req.Status, _ = stepDef.Config["status"].(string)
+		if !slices.Contains(presenceOverrideStatuses, req.Status) {
+			return nil, fmt.Errorf("status must be online, away or busy for set_presence, not %q", req.Status)
+		}
+		req.StatusMessage, _ = stepDef.Config["status_message"].(string)
+		if value, ok := stepDef.Config["revert_after_seconds"]; ok {
+			seconds, ok := value.(float64)
+			if !ok || seconds <= 0 || seconds != math.Trunc(seconds) {
+				return nil, fmt.Errorf("revert_after_seconds must be a positive whole number")
+			}
+			req.TTLSeconds = int(seconds)
+		}
+	}
+
+	resp, err := e.putPresenceOverride(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set presence of %s: %w", userID, err)
+	}
+
+	data := map[string]interface{}{
+		"user_id": userID,
+		"status":  resp.Status,
+		"cleared": resp.Cleared,
+	}
+	if !clear {
+		data["override_status"] = req.Status
+		if resp.Override != nil && resp.Override.ExpiresAt != nil {
+			data["reverts_at"] = *resp.Override.ExpiresAt
+		}
+		if revertOnEnd {
+			data["presence_override"] = presenceOverride{UserID: userID, Source: req.Source}
+		}
+	}
+	e.logger.Info("Set presence", "instance_id", instance.ID, "step_id", stepDef.ID, "user_id", userID, "status", req.Status, "clear", clear)
+	return &StepResult{Success: true, Data: data}, nil
+}
+
+// revertPresenceOverridesAsync runs revertPresenceOverrides for an instance that just
+// ended without holding up its goroutine
+func (e *Engine) revertPresenceOverridesAsync(instanceID uuid.UUID) {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.revertPresenceOverrides(&instanceID)
+	}()
+}
+
+// revertPresenceOverrides clears the status overrides that set_presence steps with
+// revert_on_end left for instances that have ended, those of instanceID only if
+// given. Overrides the presence service could not clear are tried again at the next
+// check; cancelled instances are only reverted by the check.
+func (e *Engine) revertPresenceOverrides(instanceID *uuid.UUID) {
+	if e.config.Presence.URL == "" {
+		return
+	}
+	ended := e.db.Model(&models.WorkflowInstance{}).Select("id").Where("status IN ?", []models.WorkflowStatus{
+		models.WorkflowStatusCompleted,
+		models.WorkflowStatusFailed,
+		models.WorkflowStatusCancelled,
+	})
+	query := e.db.Select("id", "instance_id", "output_data").
+		Where("status = ? AND output_data->'presence_override' IS NOT NULL AND output_data->'presence_override'->>'reverted' IS DISTINCT FROM 'true' AND instance_id IN (?)", models.StepStatusCompleted, ended)
+	if instanceID != nil {
+		query = query.Where("instance_id = ?", *instanceID)
+	}
+
+	var steps []models.WorkflowStep
+	if err := query.Find(&steps).Error; err != nil {
+		e.logger.Error("Failed to fetch presence overrides", "error", err)
+		return
+	}
+
+	for _, step := range steps {
+		override, ok := readPresenceOverride(step.OutputData)
+		if !ok || override.Reverted {
+			continue
+		}
+		if _, err := e.executor.putPresenceOverride(e.ctx, presenceOverrideRequest{UserID: override.UserID, Source: override.Source}); err != nil {
+			e.logger.Warn("Failed to revert presence, retrying later", "instance_id", step.InstanceID, "step_id", step.ID, "user_id", override.UserID, "error", err)
+			continue
+		}
+		if err := e.db.Model(&models.WorkflowStep{}).
+			Where("id = ?", step.ID).
+			Update("output_data", gorm.Expr(`jsonb_set(output_data, '{presence_override,reverted}', 'true'::jsonb)`)).Error; err != nil {
+			e.logger.Error("Failed to record presence revert", "step_id", step.ID, "error", err)
+			continue
+		}
+		e.logger.Info("Reverted presence", "instance_id", step.InstanceID, "step_id", step.ID, "user_id", override.UserID)
+	}
+}
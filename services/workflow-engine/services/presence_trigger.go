@@ -0,0 +1,132 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"chorus/pkg/eventbus"
+	"chorus/workflow-engine/models"
+)
+
+// presenceTrigger is an active presence trigger with its decoded config
+type presenceTrigger struct {
+	trigger models.WorkflowTrigger
+	config  models.PresenceTriggerConfig
+}
+
+// loadPresenceTriggers reloads the active presence triggers matched against presence
+// events. Triggers with an invalid config are left out, recording the error as a
+// failed evaluation. The previous set is kept if they cannot be read.
+func (e *Engine) loadPresenceTriggers() {
+	var triggers []models.WorkflowTrigger
+	if err := e.db.Preload("Template").
+		Where("trigger_type = ? AND is_active = true", models.TriggerTypePresence).
+		Find(&triggers).Error; err != nil {
+		e.logger.Error("Failed to fetch presence triggers", "error", err)
+		return
+	}
+
+	loaded := make([]presenceTrigger, 0, len(triggers))
+	for _, trigger := range triggers {
+		var cfg models.PresenceTriggerConfig
+		err := decodeJSONB(trigger.TriggerConfig, &cfg)
+		if err == nil {
+			err = cfg.Validate()
+		}
+		if err != nil {
+			e.recordTriggerEvaluation(trigger.ID, &models.TriggerEvaluation{
+				EvaluatedAt: time.Now(),
+				Error:       fmt.Sprintf("invalid trigger config: %v", err),
+			})
+			continue
+		}
+		loaded = append(loaded, presenceTrigger{trigger: trigger, config: cfg})
+	}
+	e.presenceTriggers.Store(&loaded)
+}
+
+// firePresenceTriggers starts an instance of every presence trigger matching a status
+// change, with the event in variables.presence
+func (e *Engine) firePresenceTriggers(event eventbus.PresenceEvent, payload map[string]interface{}) {
+	triggers := e.presenceTriggers.Load()
+	if triggers == nil {
+		return
+	}
+
+	for i := range *triggers {
+		pt := &(*triggers)[i]
+		if !pt.config.Matches(event.OldStatus, event.NewStatus) {
+			continue
+		}
+		watched, err := e.watchesUser(&pt.config, event.UserID)
+		if err != nil {
+			e.recordTriggerEvaluation(pt.trigger.ID, &models.TriggerEvaluation{
+				EvaluatedAt: time.Now(),
+				Error:       fmt.Sprintf("failed to read label %s: %v", pt.config.Label, err),
+			})
+			continue
+		}
+		if watched {
+			e.firePresenceTrigger(pt, event, payload)
+		}
+	}
+}
+
+// watchesUser reports whether userID is listed by a presence trigger or carries its
+// label; a trigger naming neither watches every user
+func (e *Engine) watchesUser(cfg *models.PresenceTriggerConfig, userID string) (bool, error) {
+	if len(cfg.UserIDs) == 0 && cfg.Label == "" {
+		return true, nil
+	}
+	if slices.Contains(cfg.UserIDs, userID) {
+		return true, nil
+	}
+	if cfg.Label == "" {
+		return false, nil
+	}
+	return e.redis.SIsMember(e.ctx, "workflow:labels:"+cfg.Label, userID).Result()
+}
+
+// firePresenceTrigger starts an instance of a presence trigger for the user of event,
+// unless the user is in the trigger's cooldown. The cooldown, or the event itself
+// without one, is claimed in Redis so only one engine replica fires.
+func (e *Engine) firePresenceTrigger(pt *presenceTrigger, event eventbus.PresenceEvent, payload map[string]interface{}) {
+	key := fmt.Sprintf("workflow:trigger:%s:presence:%s:%d", pt.trigger.ID, event.UserID, event.Timestamp.UnixNano())
+	ttl := handledEventTTL
+	if pt.config.CooldownSeconds > 0 {
+		key = fmt.Sprintf("workflow:trigger:%s:cooldown:%s", pt.trigger.ID, event.UserID)
+		ttl = time.Duration(pt.config.CooldownSeconds) * time.Second
+	}
+
+	evaluation := models.TriggerEvaluation{EvaluatedAt: time.Now(), Result: true}
+	claimed, err := e.redis.SetNX(e.ctx, key, 1, ttl).Result()
+	if err != nil {
+		// Firing without the claim could start the instance on every replica
+		evaluation.Error = fmt.Sprintf("failed to claim presence event: %v", err)
+		e.recordTriggerEvaluation(pt.trigger.ID, &evaluation)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	instance, err := e.createTriggeredInstance(&pt.trigger, "Presence Triggered", models.JSONB{"presence": payload}, models.JSONB{
+		"trigger_id":   pt.trigger.ID.String(),
+		"trigger_type": string(models.TriggerTypePresence),
+		"user_id":      event.UserID,
+	})
+	if err != nil {
+		// The user is not in a cooldown for an instance that was never started
+		e.redis.Del(e.ctx, key)
+		evaluation.Error = err.Error()
+		e.recordTriggerEvaluation(pt.trigger.ID, &evaluation)
+		e.logger.Error("Failed to fire presence trigger", "trigger_id", pt.trigger.ID, "user_id", event.UserID, "error", err)
+		return
+	}
+
+	evaluation.Fired = true
+	evaluation.InstanceID = &instance.ID
+	e.recordTriggerEvaluation(pt.trigger.ID, &evaluation)
+	e.logger.Info("Presence trigger fired", "trigger_id", pt.trigger.ID, "user_id", event.UserID, "instance_id", instance.ID)
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"chorus/pkg/eventbus"
+	"chorus/workflow-engine/models"
+)
+
+// Kept events read per query of a replay
+const replayBatchSize = 100
+
+var (
+	ErrEventsNotKept      = errors.New("events are not kept: EVENT_RETENTION_HOURS is 0")
+	ErrEventReplayRunning = errors.New("an event replay is already running")
+)
+
+// recordEvent keeps an event for replay, under an event_id the bus then publishes
+// it with
+func (e *Executor) recordEvent(ctx context.Context, event map[string]interface{}) {
+	id := uuid.New()
+	event["event_id"] = id.String()
+
+	record := models.EngineEvent{
+		ID:         id,
+		Type:       eventbus.WorkflowEvent(event).Type(),
+		InstanceID: parseEventUUID(event, "instance_id"),
+		TemplateID: parseEventUUID(event, "template_id"),
+		Payload:    models.JSONB(event),
+	}
+	if err := e.db.WithContext(ctx).Create(&record).Error; err != nil {
+		e.logger.WarnContext(ctx, "Failed to keep workflow event for replay", "type", record.Type, "instance_id", event["instance_id"], "error", err)
+	}
+}
+
+func parseEventUUID(event map[string]interface{}, key string) *uuid.UUID {
+	value, _ := event[key].(string)
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return nil
+	}
+	return &id
+}
+
+// replayQuery selects the kept events of filter
+func (e *Engine) replayQuery(filter models.EventReplayFilter) *gorm.DB {
+	query := e.db.Model(&models.EngineEvent{}).
+		Where("created_at >= ? AND created_at < ?", filter.From, filter.To)
+	if len(filter.EventTypes) > 0 {
+		query = query.Where("type IN ?", filter.EventTypes)
+	}
+	if len(filter.TemplateIDs) > 0 {
+		// Events such as step_completed name their instance only
+		query = query.Where("(template_id IN ? OR instance_id IN (?))", filter.TemplateIDs,
+			e.db.Model(&models.WorkflowInstance{}).Select("id").Where("template_id IN ?", filter.TemplateIDs))
+	}
+	return query
+}
+
+// CountReplayEvents counts the kept events a replay of filter would republish
+func (e *Engine) CountReplayEvents(filter models.EventReplayFilter) (int64, error) {
+	if e.config.EventRetention <= 0 {
+		return 0, ErrEventsNotKept
+	}
+	var count int64
+	err := e.replayQuery(filter).Count(&count).Error
+	return count, err
+}
+
+// StartEventReplay starts republishing the kept events of filter on workflow:events
+// in the background, oldest first, at most EVENT_REPLAY_RATE a second. Replayed
+// events keep their event_id and carry replayed: true and the replay's replay_id.
+func (e *Engine) StartEventReplay(filter models.EventReplayFilter, requestedBy string) (*models.EventReplay, error) {
+	if e.config.EventRetention <= 0 {
+		return nil, ErrEventsNotKept
+	}
+
+	var running int64
+	if err := e.db.Model(&models.EventReplay{}).Where("status = ?", models.EventReplayRunning).Count(&running).Error; err != nil {
+		return nil, fmt.Errorf("failed to check running replays: %w", err)
+	}
+	if running > 0 {
+		return nil, ErrEventReplayRunning
+	}
+
+	matched, err := e.CountReplayEvents(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count events: %w", err)
+	}
+	replay := &models.EventReplay{
+		Status:      models.EventReplayRunning,
+		Filter:      filter,
+		Matched:     matched,
+		RequestedBy: requestedBy,
+	}
+	if err := e.db.Create(replay).Error; err != nil {
+		return nil, fmt.Errorf("failed to create replay: %w", err)
+	}
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.replayEvents(*replay)
+	}()
+	return replay, nil
+}
+
+// EventReplay returns a replay with its progress
+func (e *Engine) EventReplay(id uuid.UUID) (*models.EventReplay, error) {
+	var replay models.EventReplay
+	if err := e.db.First(&replay, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &replay, nil
+}
+
+// replayEvents republishes the events of a replay in batches ordered by when they
+// were published, recording its progress after each batch
+func (e *Engine) replayEvents(replay models.EventReplay) {
+	e.logger.Info("Event replay started", "replay_id", replay.ID, "matched", replay.Matched, "from", replay.Filter.From, "to", replay.Filter.To)
+
+	ticker := time.NewTicker(time.Second / time.Duration(e.config.EventReplayRate))
+	defer ticker.Stop()
+
+	var replayed int64
+	var afterAt time.Time
+	var afterID uuid.UUID
+	jobErr := func() error {
+		for {
+			var events []models.EngineEvent
+			query := e.replayQuery(replay.Filter)
+			if replayed > 0 {
+				query = query.Where("(created_at, id) > (?, ?)", afterAt, afterID)
+			}
+			if err := query.Order("created_at, id").Limit(replayBatchSize).Find(&events).Error; err != nil {
+				return fmt.Errorf("failed to read events: %w", err)
+			}
+			if len(events) == 0 {
+				return nil
+			}
+
+			for _, event := range events {
+				select {
+				case <-e.ctx.Done():
+					return e.ctx.Err()
+				case <-ticker.C:
+				}
+
+				payload := eventbus.WorkflowEvent(event.Payload)
+				payload["event_id"] = event.ID.String()
+				payload["replayed"] = true
+				payload["replay_id"] = replay.ID.String()
+				if err := eventbus.Publish(e.ctx, e.bus, eventbus.WorkflowEvents, payload); err != nil {
+					return err
+				}
+				replayed++
+				eventsReplayedTotal.Inc(event.Type)
+				afterAt, afterID = event.CreatedAt, event.ID
+			}
+
+			if err := e.db.Model(&replay).Update("replayed", replayed).Error; err != nil {
+				e.logger.Warn("Failed to record replay progress", "replay_id", replay.ID, "error", err)
+			}
+		}
+	}()
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":       models.EventReplayCompleted,
+		"replayed":     replayed,
+		"completed_at": now,
+	}
+	switch {
+	case errors.Is(jobErr, context.Canceled):
+		updates["status"] = models.EventReplayCancelled
+		updates["error"] = "engine stopped"
+	case jobErr != nil:
+		updates["status"] = models.EventReplayFailed
+		updates["error"] = jobErr.Error()
+	}
+	if err := e.db.Model(&replay).Updates(updates).Error; err != nil {
+		e.logger.Error("Failed to record replay outcome", "replay_id", replay.ID, "error", err)
+	}
+
+	if jobErr != nil {
+		e.logger.Error("Event replay stopped", "replay_id", replay.ID, "replayed", replayed, "error", jobErr)
+		return
+	}
+	e.logger.Info("Event replay finished", "replay_id", replay.ID, "replayed", replayed)
+}
+
+// purgeEvents deletes the events kept past EVENT_RETENTION_HOURS, at most once an hour
+func (e *Engine) purgeEvents() {
+	if e.config.EventRetention <= 0 || time.Since(e.lastEventPurge) < time.Hour {
+		return
+	}
+	e.lastEventPurge = time.Now()
+
+	cutoff := time.Now().Add(-time.Duration(e.config.EventRetention) * time.Hour)
+	result := e.db.Where("created_at < ?", cutoff).Delete(&models.EngineEvent{})
+	if result.Error != nil {
+		e.logger.Error("Failed to purge kept events", "error", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		e.logger.Info("Purged kept events", "count", result.RowsAffected, "older_than", cutoff)
+	}
+}
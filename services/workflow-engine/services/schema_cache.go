@@ -0,0 +1,106 @@
+package services
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"chorus/workflow-engine/models"
+)
+
+// errInvalidSchema wraps the errors of a template schema that cannot be parsed
+var errInvalidSchema = errors.New("invalid workflow schema")
+
+// schemaCache keeps the parsed schemas of the templates run most recently, so the
+// instances of a hot template do not decode its JSONB schema on every run. An entry
+// only serves the updated_at it was parsed at: an edited template misses and is
+// parsed again. Cached schemas are shared between instances and must not be modified.
+type schemaCache struct {
+	mu      sync.Mutex
+	size    int // most templates kept; 0 disables the cache
+	entries map[uuid.UUID]*list.Element
+	order   *list.List // of *schemaEntry, most recently used first
+}
+
+type schemaEntry struct {
+	templateID uuid.UUID
+	updatedAt  time.Time
+	schema     *models.WorkflowSchema
+}
+
+func newSchemaCache(size int) *schemaCache {
+	return &schemaCache{
+		size:    size,
+		entries: make(map[uuid.UUID]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the schema of a template as of updatedAt
+func (c *schemaCache) get(templateID uuid.UUID, updatedAt time.Time) (*models.WorkflowSchema, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[templateID]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*schemaEntry)
+	if !entry.updatedAt.Equal(updatedAt) {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.schema, true
+}
+
+// put caches the schema of a template as of updatedAt, replacing any other version
+// of it and evicting the least recently used template when full
+func (c *schemaCache) put(templateID uuid.UUID, updatedAt time.Time, schema *models.WorkflowSchema) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[templateID]; ok {
+		entry := element.Value.(*schemaEntry)
+		// A run of a stale read must not replace a newer version
+		if entry.updatedAt.After(updatedAt) {
+			return
+		}
+		entry.updatedAt, entry.schema = updatedAt, schema
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[templateID] = c.order.PushFront(&schemaEntry{templateID: templateID, updatedAt: updatedAt, schema: schema})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*schemaEntry).templateID)
+	}
+}
+
+// templateSchema returns the parsed schema of template, whose ID and updated_at must
+// be loaded. On a cache miss the schema column is read and parsed; the schema is
+// cached under the updated_at read with it, in case the template changed meanwhile.
+func (e *Engine) templateSchema(template *models.WorkflowTemplate) (*models.WorkflowSchema, error) {
+	if schema, ok := e.schemas.get(template.ID, template.UpdatedAt); ok {
+		schemaCacheLookupsTotal.Inc("hit")
+		return schema, nil
+	}
+	schemaCacheLookupsTotal.Inc("miss")
+
+	var stored models.WorkflowTemplate
+	if err := e.db.Select("id", "schema", "updated_at").First(&stored, "id = ?", template.ID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load template schema: %w", err)
+	}
+	var schema models.WorkflowSchema
+	if err := e.parseSchema(stored.Schema, &schema); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidSchema, err)
+	}
+	e.schemas.put(stored.ID, stored.UpdatedAt, &schema)
+	return &schema, nil
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"chorus/workflow-engine/models"
+)
+
+func TestSchemaCache(t *testing.T) {
+	cache := newSchemaCache(2)
+	first, second, third := uuid.New(), uuid.New(), uuid.New()
+	updatedAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	schema := func(id string) *models.WorkflowSchema {
+		return &models.WorkflowSchema{Steps: []models.WorkflowStepDefinition{{ID: id}}}
+	}
+
+	cache.put(first, updatedAt, schema("first"))
+	if got, ok := cache.get(first, updatedAt); !ok || got.Steps[0].ID != "first" {
+		t.Fatalf("get = %v, %v, want the first schema", got, ok)
+	}
+	if _, ok := cache.get(first, updatedAt.Add(time.Second)); ok {
+		t.Error("an edited template hit the schema of its previous version")
+	}
+
+	// A stale read does not replace the newer version
+	cache.put(first, updatedAt.Add(time.Second), schema("edited"))
+	cache.put(first, updatedAt, schema("stale"))
+	if got, ok := cache.get(first, updatedAt.Add(time.Second)); !ok || got.Steps[0].ID != "edited" {
+		t.Errorf("get = %v, %v, want the edited schema", got, ok)
+	}
+
+	// The least recently used template is evicted
+	cache.put(second, updatedAt, schema("second"))
+	cache.get(first, updatedAt.Add(time.Second))
+	cache.put(third, updatedAt, schema("third"))
+	if _, ok := cache.get(second, updatedAt); ok {
+		t.Error("the least recently used template was kept")
+	}
+	if _, ok := cache.get(first, updatedAt.Add(time.Second)); !ok {
+		t.Error("a recently used template was evicted")
+	}
+
+	disabled := newSchemaCache(0)
+	disabled.put(first, updatedAt, schema("first"))
+	if _, ok := disabled.get(first, updatedAt); ok {
+		t.Error("a cache of size 0 kept a schema")
+	}
+}
+
+// BenchmarkTemplateSchema measures getting the parsed schema of a hot template for
+// each of its instances, loading and parsing it every time as without the cache,
+// and from the cache
+func BenchmarkTemplateSchema(b *testing.B) {
+	steps := make([]models.WorkflowStepDefinition, 20)
+	for i := range steps {
+		steps[i] = models.WorkflowStepDefinition{
+			ID:   fmt.Sprintf("step-%d", i),
+			Name: fmt.Sprintf("Step %d", i),
+			Type: models.StepTypeAction,
+			Config: map[string]interface{}{
+				"action":  "http_request",
+				"url":     "https://example.com/api/{{variables.ticket}}",
+				"method":  "POST",
+				"headers": map[string]interface{}{"Content-Type": "application/json"},
+				"body":    map[string]interface{}{"step": i, "ticket": "{{variables.ticket}}"},
+			},
+			NextSteps:     []string{fmt.Sprintf("step-%d", i+1)},
+			OutputMapping: map[string]string{fmt.Sprintf("result_%d", i): "body.id"},
+		}
+	}
+	steps[len(steps)-1].NextSteps = nil
+
+	for _, bench := range []struct {
+		name      string
+		cacheSize int
+	}{
+		{"uncached", 0},
+		{"cached", 256},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			e := newTestEngine(b)
+			e.schemas = newSchemaCache(bench.cacheSize)
+			template := createTestTemplate(b, e.db, steps...)
+			if _, err := e.templateSchema(&template); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := e.templateSchema(&template); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
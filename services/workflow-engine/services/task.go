@@ -0,0 +1,326 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"chorus/workflow-engine/models"
+)
+
+// Actor of the task events recorded by the engine itself
+const taskSystemActor = "system"
+
+// What a rejected task does to its manual step
+const (
+	taskRejectFail     = "fail"     // the step fails (default)
+	taskRejectContinue = "continue" // the step completes with outcome "rejected"
+)
+
+// executeManualStep opens a task for assigned_to and parks the step as waiting, with
+// the task ID in its output data as "manual_task", until the task is resolved
+// through the task API. The step's result is the outcome of the task; a rejection
+// fails the step unless on_reject is "continue".
+func (e *Executor) executeManualStep(ctx context.Context, instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep) (*StepResult, error) {
+	var task models.Task
+	if raw, ok := step.OutputData["manual_task"].(string); ok {
+		taskID, err := uuid.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid manual task ID %q", raw)
+		}
+		if err := e.db.First(&task, "id = ?", taskID).Error; err != nil {
+			return nil, fmt.Errorf("failed to load task %s: %w", taskID, err)
+		}
+	} else {
+		opened, err := e.openTask(instance, stepDef, step)
+		if err != nil {
+			return nil, err
+		}
+		task = *opened
+	}
+
+	onReject, _ := stepDef.Config["on_reject"].(string)
+	switch task.Status {
+	case models.TaskStatusOpen:
+		step.OutputData = models.JSONB{"manual_task": task.ID.String()}
+		e.logger.InfoContext(ctx, "Waiting for task", "instance_id", instance.ID, "step_id", stepDef.ID, "task_id", task.ID, "assignee", task.Assignee)
+		return nil, errStepWaiting
+	case models.TaskStatusCancelled:
+		return nil, fmt.Errorf("task %s was cancelled", task.ID)
+	case models.TaskStatusRejected:
+		if onReject != taskRejectContinue {
+			reason := ""
+			if task.Comment != "" {
+				reason = ": " + task.Comment
+			}
+			return nil, fmt.Errorf("task rejected by %s%s", task.ResolvedBy, reason)
+		}
+	}
+
+	data := map[string]interface{}{
+		"task_id":     task.ID.String(),
+		"outcome":     task.Status,
+		"resolved_by": task.ResolvedBy,
+		"comment":     task.Comment,
+		"data":        map[string]interface{}(task.Data),
+		"escalated":   task.EscalatedAt != nil,
+	}
+	if task.ResolvedAt != nil {
+		data["waited_seconds"] = int(task.ResolvedAt.Sub(task.CreatedAt).Seconds())
+	}
+	return &StepResult{Success: true, Data: data}, nil
+}
+
+// openTask creates the task of a manual step, or returns the one already open for
+// it if the engine stopped before parking the step
+func (e *Executor) openTask(instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep) (*models.Task, error) {
+	var existing models.Task
+	err := e.db.Where("step_record_id = ? AND status = ?", step.ID, models.TaskStatusOpen).First(&existing).Error
+	if err == nil {
+		return &existing, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, fmt.Errorf("failed to look up open task: %w", err)
+	}
+
+	task, err := newTask(instance, stepDef, step, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	err = e.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(task).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.TaskEvent{
+			TaskID:     task.ID,
+			Event:      models.TaskEventCreated,
+			Actor:      taskSystemActor,
+			ToAssignee: task.Assignee,
+		}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create task: %w", err)
+	}
+	return task, nil
+}
+
+// newTask reads the config of a manual step into the task it opens
+func newTask(instance *models.WorkflowInstance, stepDef *models.WorkflowStepDefinition, step *models.WorkflowStep, now time.Time) (*models.Task, error) {
+	assignee, _ := stepDef.Config["assigned_to"].(string)
+	if assignee == "" {
+		return nil, fmt.Errorf("assigned_to not specified for manual step")
+	}
+	fallback, _ := stepDef.Config["fallback_assignee"].(string)
+
+	actions := stringList(stepDef.Config["actions"])
+	if len(actions) == 0 {
+		actions = models.TaskActions
+	}
+	for _, action := range actions {
+		if !slices.Contains(models.TaskActions, action) {
+			return nil, fmt.Errorf("unknown task action %q, expected one of %v", action, models.TaskActions)
+		}
+	}
+	switch onReject, _ := stepDef.Config["on_reject"].(string); onReject {
+	case "", taskRejectFail, taskRejectContinue:
+	default:
+		return nil, fmt.Errorf("on_reject must be fail or continue, not %q", onReject)
+	}
+
+	var dueAt *time.Time
+	if seconds, ok := stepDef.Config["due_in_seconds"].(float64); ok {
+		if seconds <= 0 {
+			return nil, fmt.Errorf("due_in_seconds must be positive")
+		}
+		due := now.Add(time.Duration(seconds * float64(time.Second)))
+		dueAt = &due
+	} else if raw, ok := stepDef.Config["due_at"].(string); ok && raw != "" {
+		due, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, fmt.Errorf("due_at must be an RFC 3339 time: %w", err)
+		}
+		dueAt = &due
+	}
+
+	title, _ := stepDef.Config["title"].(string)
+	if title == "" {
+		title = stepDef.Name
+	}
+	if title == "" {
+		title = stepDef.ID
+	}
+	instructions, _ := stepDef.Config["instructions"].(string)
+
+	taskContext := models.JSONB{"instance_name": instance.Name}
+	// The inbox is not encrypted, so encrypted instances keep their data out of it
+	if !instance.Encrypted {
+		taskContext["context"] = maskSecrets("", map[string]interface{}(instance.Context))
+		taskContext["variables"] = maskSecrets("", map[string]interface{}(instance.Variables))
+	}
+
+	return &models.Task{
+		InstanceID:       instance.ID,
+		TemplateID:       instance.TemplateID,
+		StepRecordID:     step.ID,
+		StepID:           stepDef.ID,
+		Title:            title,
+		Instructions:     instructions,
+		Actions:          models.StringList(actions),
+		Assignee:         assignee,
+		FallbackAssignee: fallback,
+		Status:           models.TaskStatusOpen,
+		Context:          taskContext,
+		DueAt:            dueAt,
+	}, nil
+}
+
+// maskSecrets copies value, masking the values under secret keys
+func maskSecrets(key string, value interface{}) interface{} {
+	if key != "" && isSecretKey(key) {
+		return maskedValue
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(v))
+		for childKey, child := range v {
+			masked[childKey] = maskSecrets(childKey, child)
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, child := range v {
+			masked[i] = maskSecrets("", child)
+		}
+		return masked
+	default:
+		return value
+	}
+}
+
+// checkTasks escalates overdue tasks, cancels the open tasks of instances that ended,
+// and resumes the manual steps of running instances whose task was resolved, in case
+// queueing them failed when it was
+func (e *Engine) checkTasks() {
+	e.escalateOverdueTasks()
+	e.cancelOrphanedTasks()
+
+	resolved := e.db.Model(&models.Task{}).Select("id::text").Where("status <> ?", models.TaskStatusOpen)
+	running := e.db.Model(&models.WorkflowInstance{}).Select("id").Where("status = ?", models.WorkflowStatusRunning)
+	var steps []models.WorkflowStep
+	if err := e.db.Select("id", "instance_id").
+		Where("status = ? AND output_data->>'manual_task' IN (?) AND instance_id IN (?)", models.StepStatusWaiting, resolved, running).
+		Find(&steps).Error; err != nil {
+		e.logger.Error("Failed to fetch resolved tasks", "error", err)
+		return
+	}
+	for _, step := range steps {
+		if err := e.QueueInstance(step.InstanceID); err != nil {
+			e.logger.Error("Failed to queue instance after task resolution", "instance_id", step.InstanceID, "error", err)
+		}
+	}
+}
+
+// escalateOverdueTasks marks the open tasks past their due date as escalated, once,
+// hands them to their fallback assignee if they have one, and publishes a
+// task_escalated event for each
+func (e *Engine) escalateOverdueTasks() {
+	now := time.Now()
+	var tasks []models.Task
+	if err := e.db.Where("status = ? AND escalated_at IS NULL AND due_at <= ?", models.TaskStatusOpen, now).
+		Find(&tasks).Error; err != nil {
+		e.logger.Error("Failed to fetch overdue tasks", "error", err)
+		return
+	}
+
+	for _, task := range tasks {
+		assignee := task.Assignee
+		if task.FallbackAssignee != "" {
+			assignee = task.FallbackAssignee
+		}
+
+		// Every replica sees the task overdue; the one marking it escalates it
+		escalated := false
+		err := e.db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&models.Task{}).
+				Where("id = ? AND status = ? AND escalated_at IS NULL", task.ID, models.TaskStatusOpen).
+				Updates(map[string]interface{}{"escalated_at": now, "assignee": assignee})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			escalated = true
+			return tx.Create(&models.TaskEvent{
+				TaskID:       task.ID,
+				Event:        models.TaskEventEscalated,
+				Actor:        taskSystemActor,
+				FromAssignee: task.Assignee,
+				ToAssignee:   assignee,
+				Reason:       "overdue since " + task.DueAt.UTC().Format(time.RFC3339),
+			}).Error
+		})
+		if err != nil {
+			e.logger.Error("Failed to escalate task", "task_id", task.ID, "error", err)
+			continue
+		}
+		if !escalated {
+			continue
+		}
+
+		rerouted := assignee != task.Assignee
+		tasksEscalatedTotal.Inc(strconv.FormatBool(rerouted))
+		e.logger.Warn("Task overdue, escalated", "task_id", task.ID, "instance_id", task.InstanceID, "step_id", task.StepID, "assignee", assignee, "previous_assignee", task.Assignee)
+		e.executor.publishEvent(e.ctx, map[string]interface{}{
+			"type":              "task_escalated",
+			"instance_id":       task.InstanceID.String(),
+			"step_id":           task.StepID,
+			"task_id":           task.ID.String(),
+			"assignee":          assignee,
+			"previous_assignee": task.Assignee,
+			"rerouted":          rerouted,
+			"due_at":            task.DueAt.Unix(),
+			"timestamp":         now.Unix(),
+		})
+	}
+}
+
+// cancelOrphanedTasks cancels the open tasks of instances that completed, failed or
+// were cancelled
+func (e *Engine) cancelOrphanedTasks() {
+	ended := e.db.Model(&models.WorkflowInstance{}).Select("id").Where("status IN ?", []models.WorkflowStatus{
+		models.WorkflowStatusCompleted,
+		models.WorkflowStatusFailed,
+		models.WorkflowStatusCancelled,
+	})
+	var tasks []models.Task
+	if err := e.db.Select("id").Where("status = ? AND instance_id IN (?)", models.TaskStatusOpen, ended).
+		Find(&tasks).Error; err != nil {
+		e.logger.Error("Failed to fetch orphaned tasks", "error", err)
+		return
+	}
+
+	now := time.Now()
+	for _, task := range tasks {
+		err := e.db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&models.Task{}).
+				Where("id = ? AND status = ?", task.ID, models.TaskStatusOpen).
+				Updates(map[string]interface{}{"status": models.TaskStatusCancelled, "resolved_at": now})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			return tx.Create(&models.TaskEvent{
+				TaskID: task.ID,
+				Event:  models.TaskEventCancelled,
+				Actor:  taskSystemActor,
+				Status: models.TaskStatusCancelled,
+				Reason: "instance ended",
+			}).Error
+		})
+		if err != nil {
+			e.logger.Error("Failed to cancel task", "task_id", task.ID, "error", err)
+		}
+	}
+}
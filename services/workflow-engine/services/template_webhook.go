@@ -0,0 +1,319 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chorus/workflow-engine/models"
+	"chorus/workflow-engine/utils"
+)
+
+const (
+	// How long a pending delivery is left to the replica sending it before another
+	// one picks it up, such as after a restart
+	templateWebhookLease = 5 * time.Minute
+
+	// Deliveries are kept this long for the deliveries log
+	templateWebhookRetention = 7 * 24 * time.Hour
+
+	// Destination label of template webhook requests in the outbound HTTP metrics
+	templateWebhookDestination = "template_webhook"
+)
+
+// SignTemplateWebhook returns the X-Chorus-Signature of a delivery: the hex HMAC-SHA256
+// of "<timestamp>.<body>" keyed with the webhook's secret, prefixed with "sha256="
+func SignTemplateWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// EmitTemplateEvent sends event to the active webhooks of template that want it.
+// actor is the user who caused it, "" for the engine itself. The deliveries are
+// recorded before this returns and sent in the background.
+func (e *Engine) EmitTemplateEvent(template *models.WorkflowTemplate, event, actor string, data map[string]interface{}) {
+	var webhooks []models.TemplateWebhook
+	if err := e.db.Where("template_id = ? AND is_active = true", template.ID).Find(&webhooks).Error; err != nil {
+		e.logger.Error("Failed to load template webhooks", "template_id", template.ID, "error", err)
+		return
+	}
+	for i := range webhooks {
+		if webhooks[i].Wants(event) {
+			e.queueTemplateWebhook(&webhooks[i], template, event, actor, data)
+		}
+	}
+}
+
+// queueTemplateWebhook records a delivery of event to webhook and sends it
+func (e *Engine) queueTemplateWebhook(webhook *models.TemplateWebhook, template *models.WorkflowTemplate, event, actor string, data map[string]interface{}) {
+	payload := models.JSONB{
+		"event":       event,
+		"occurred_at": time.Now().UTC().Format(time.RFC3339),
+		"template": map[string]interface{}{
+			"id":         template.ID.String(),
+			"name":       template.Name,
+			"version":    template.Version,
+			"category":   template.Category,
+			"is_active":  template.IsActive,
+			"visibility": template.Visibility,
+		},
+	}
+	if actor != "" {
+		payload["actor"] = actor
+	}
+	if data != nil {
+		payload["data"] = data
+	}
+
+	delivery := models.TemplateWebhookDelivery{
+		WebhookID:  webhook.ID,
+		TemplateID: template.ID,
+		Event:      event,
+		Payload:    payload,
+		Status:     models.DeliveryStatusPending,
+		LeaseUntil: time.Now().Add(templateWebhookLease),
+	}
+	if err := e.db.Create(&delivery).Error; err != nil {
+		e.logger.Error("Failed to record template webhook delivery", "webhook_id", webhook.ID, "event", event, "error", err)
+		return
+	}
+
+	e.wg.Add(1)
+	go e.sendTemplateWebhook(*webhook, delivery)
+}
+
+// sendTemplateWebhook posts a delivery, retrying with backoff until it succeeds or
+// its attempts run out. A delivery interrupted by shutdown stays pending and is
+// resumed by another replica, or this one after a restart, once its lease expires.
+func (e *Engine) sendTemplateWebhook(webhook models.TemplateWebhook, delivery models.TemplateWebhookDelivery) {
+	defer e.wg.Done()
+
+	body, err := json.Marshal(delivery.Payload)
+	if err != nil {
+		e.finishTemplateWebhook(&delivery, fmt.Sprintf("failed to encode payload: %v", err))
+		return
+	}
+
+	attempts := e.config.TemplateWebhookAttempts - delivery.Attempts
+	err = utils.Retry(e.ctx, attempts, func() error {
+		status, err := e.postTemplateWebhook(&webhook, &delivery, body)
+		now := time.Now()
+		delivery.Attempts++
+		updates := map[string]interface{}{
+			"attempts":        delivery.Attempts,
+			"last_attempt_at": now,
+			"response_status": status,
+			"error":           "",
+			"lease_until":     now.Add(templateWebhookLease),
+		}
+		if err != nil {
+			updates["error"] = err.Error()
+		} else {
+			updates["status"] = models.DeliveryStatusDelivered
+			updates["delivered_at"] = now
+		}
+		if dbErr := e.db.Model(&models.TemplateWebhookDelivery{}).Where("id = ?", delivery.ID).Updates(updates).Error; dbErr != nil {
+			e.logger.Error("Failed to record template webhook attempt", "delivery_id", delivery.ID, "error", dbErr)
+		}
+		return err
+	}, func(attempt int, err error, wait time.Duration) {
+		templateWebhookDeliveriesTotal.Inc(delivery.Event, "retried")
+		e.logger.Warn("Template webhook delivery failed, retrying",
+			"delivery_id", delivery.ID,
+			"webhook_id", webhook.ID,
+			"attempt", attempt,
+			"retry_in", wait,
+			"error", err,
+		)
+	})
+
+	switch {
+	case err == nil:
+		templateWebhookDeliveriesTotal.Inc(delivery.Event, "delivered")
+	case e.ctx.Err() != nil:
+		// Shutting down; the lease lets another replica finish it
+	default:
+		e.finishTemplateWebhook(&delivery, err.Error())
+	}
+}
+
+// finishTemplateWebhook marks a delivery failed for good
+func (e *Engine) finishTemplateWebhook(delivery *models.TemplateWebhookDelivery, reason string) {
+	templateWebhookDeliveriesTotal.Inc(delivery.Event, "failed")
+	e.logger.Error("Template webhook delivery failed", "delivery_id", delivery.ID, "event", delivery.Event, "attempts", delivery.Attempts, "error", reason)
+	if err := e.db.Model(&models.TemplateWebhookDelivery{}).Where("id = ?", delivery.ID).Updates(map[string]interface{}{
+		"status": models.DeliveryStatusFailed,
+		"error":  reason,
+	}).Error; err != nil {
+		e.logger.Error("Failed to record template webhook failure", "delivery_id", delivery.ID, "error", err)
+	}
+}
+
+// postTemplateWebhook makes one attempt at a delivery, returning the response status,
+// 0 if none came
+func (e *Engine) postTemplateWebhook(webhook *models.TemplateWebhook, delivery *models.TemplateWebhookDelivery, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(e.ctx, time.Duration(e.config.TemplateWebhookTimeout)*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chorus-workflow-engine")
+	req.Header.Set("X-Chorus-Event", delivery.Event)
+	req.Header.Set("X-Chorus-Delivery", delivery.ID.String())
+	req.Header.Set("X-Chorus-Timestamp", timestamp)
+	req.Header.Set("X-Chorus-Signature", SignTemplateWebhook(webhook.Secret, timestamp, body))
+
+	outbound := e.executor.outbound
+	resp, err := outbound.do(outbound.client, req, templateWebhookDestination)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// checkTemplateWebhooks evaluates the failure rate thresholds, resumes deliveries
+// whose sender went away and deletes old ones, at most once a minute
+func (e *Engine) checkTemplateWebhooks() {
+	if time.Since(e.lastWebhookCheck) < time.Minute {
+		return
+	}
+	e.lastWebhookCheck = time.Now()
+
+	e.checkFailureRates()
+	e.resumeTemplateWebhooks()
+
+	cutoff := time.Now().Add(-templateWebhookRetention)
+	if err := e.db.Where("created_at < ? AND status <> ?", cutoff, models.DeliveryStatusPending).
+		Delete(&models.TemplateWebhookDelivery{}).Error; err != nil {
+		e.logger.Error("Failed to delete old template webhook deliveries", "error", err)
+	}
+}
+
+// checkFailureRates sends template.failure_rate to the webhooks whose template's
+// failure rate over their window just reached their threshold
+func (e *Engine) checkFailureRates() {
+	var webhooks []models.TemplateWebhook
+	if err := e.db.Where("is_active = true AND failure_rate_threshold > 0").Find(&webhooks).Error; err != nil {
+		e.logger.Error("Failed to load template webhooks", "error", err)
+		return
+	}
+
+	for i := range webhooks {
+		webhook := &webhooks[i]
+		if !webhook.Wants(models.TemplateEventFailureRate) {
+			continue
+		}
+
+		window := time.Duration(max(webhook.FailureWindowMinutes, 1)) * time.Minute
+		var counts struct {
+			Total  int64
+			Failed int64
+		}
+		err := e.db.Model(&models.WorkflowInstance{}).
+			Select("COUNT(*) AS total, COUNT(*) FILTER (WHERE status = ?) AS failed", models.WorkflowStatusFailed).
+			Where("template_id = ? AND is_test = false AND status IN ? AND completed_at >= ?",
+				webhook.TemplateID,
+				[]models.WorkflowStatus{models.WorkflowStatusCompleted, models.WorkflowStatusFailed},
+				time.Now().Add(-window),
+			).
+			Scan(&counts).Error
+		if err != nil {
+			e.logger.Error("Failed to count finished instances", "template_id", webhook.TemplateID, "error", err)
+			continue
+		}
+
+		var rate float64
+		if counts.Total > 0 {
+			rate = float64(counts.Failed) / float64(counts.Total)
+		}
+		above := counts.Total >= int64(webhook.FailureMinInstances) && rate >= webhook.FailureRateThreshold
+		if above == webhook.FailureAlerting {
+			continue
+		}
+
+		// Every replica evaluates the thresholds; only the one flipping the flag sends
+		result := e.db.Model(&models.TemplateWebhook{}).
+			Where("id = ? AND failure_alerting = ?", webhook.ID, webhook.FailureAlerting).
+			Update("failure_alerting", above)
+		if result.Error != nil {
+			e.logger.Error("Failed to update template webhook", "webhook_id", webhook.ID, "error", result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 || !above {
+			continue
+		}
+
+		var template models.WorkflowTemplate
+		if err := e.db.First(&template, webhook.TemplateID).Error; err != nil {
+			e.logger.Error("Failed to load template", "template_id", webhook.TemplateID, "error", err)
+			continue
+		}
+		e.logger.Warn("Template failure rate above threshold",
+			"template_id", template.ID,
+			"failure_rate", rate,
+			"threshold", webhook.FailureRateThreshold,
+		)
+		e.queueTemplateWebhook(webhook, &template, models.TemplateEventFailureRate, "", map[string]interface{}{
+			"failure_rate":   rate,
+			"threshold":      webhook.FailureRateThreshold,
+			"failed":         counts.Failed,
+			"finished":       counts.Total,
+			"window_minutes": int(window / time.Minute),
+		})
+	}
+}
+
+// resumeTemplateWebhooks sends the pending deliveries whose lease expired, claiming
+// each so that only one replica does
+func (e *Engine) resumeTemplateWebhooks() {
+	now := time.Now()
+	var deliveries []models.TemplateWebhookDelivery
+	if err := e.db.Where("status = ? AND lease_until < ?", models.DeliveryStatusPending, now).
+		Order("created_at").Limit(100).Find(&deliveries).Error; err != nil {
+		e.logger.Error("Failed to load pending template webhook deliveries", "error", err)
+		return
+	}
+
+	for i := range deliveries {
+		delivery := deliveries[i]
+		result := e.db.Model(&models.TemplateWebhookDelivery{}).
+			Where("id = ? AND status = ? AND lease_until < ?", delivery.ID, models.DeliveryStatusPending, now).
+			Update("lease_until", now.Add(templateWebhookLease))
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+
+		var webhook models.TemplateWebhook
+		if err := e.db.First(&webhook, delivery.WebhookID).Error; err != nil || !webhook.IsActive {
+			e.finishTemplateWebhook(&delivery, "webhook was removed or deactivated")
+			continue
+		}
+		if delivery.Attempts >= e.config.TemplateWebhookAttempts {
+			e.finishTemplateWebhook(&delivery, delivery.Error)
+			continue
+		}
+		e.wg.Add(1)
+		go e.sendTemplateWebhook(webhook, delivery)
+	}
+}
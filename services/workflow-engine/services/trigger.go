@@ -0,0 +1,448 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"chorus/pkg/tracing"
+	"chorus/workflow-engine/models"
+	"chorus/workflow-engine/schedule"
+)
+
+const (
+	// Number of evaluations kept per condition trigger for debugging
+	triggerHistoryLimit = 100
+
+	// Maximum size of a condition source response
+	maxConditionSourceBytes = 1 << 20
+
+	// Missed occurrences fired by the "all" catch-up policy unless max_catch_up is set
+	defaultMaxCatchUp = 10
+
+	// Due occurrences enumerated per check; longer outages are worked off over
+	// several checks
+	maxScheduleScan = 10000
+
+	// Skipped occurrences kept in a schedule trigger's metadata
+	missedRunHistoryLimit = 50
+)
+
+// checkConditionTriggers evaluates all active condition triggers
+func (e *Engine) checkConditionTriggers() {
+	var triggers []models.WorkflowTrigger
+	if err := e.db.Preload("Template").
+		Where("trigger_type = ? AND is_active = true", models.TriggerTypeCondition).
+		Find(&triggers).Error; err != nil {
+		e.logger.Error("Failed to fetch condition triggers", "error", err)
+		return
+	}
+
+	for i := range triggers {
+		e.evaluateConditionTrigger(&triggers[i])
+	}
+}
+
+// evaluateConditionTrigger evaluates a single condition trigger and fires it on a rising edge
+func (e *Engine) evaluateConditionTrigger(trigger *models.WorkflowTrigger) {
+	evaluation := models.TriggerEvaluation{EvaluatedAt: time.Now()}
+	defer e.recordTriggerEvaluation(trigger.ID, &evaluation)
+
+	var cfg models.ConditionTriggerConfig
+	if err := decodeJSONB(trigger.TriggerConfig, &cfg); err != nil {
+		evaluation.Error = fmt.Sprintf("invalid trigger config: %v", err)
+		return
+	}
+	if len(cfg.Conditions) == 0 {
+		evaluation.Error = "no conditions defined"
+		return
+	}
+
+	data, err := e.fetchConditionData(&cfg.Source)
+	if err != nil {
+		evaluation.Error = err.Error()
+		e.logger.Warn("Failed to fetch condition trigger data", "trigger_id", trigger.ID, "error", err)
+		return
+	}
+
+	result := true
+	for _, condition := range cfg.Conditions {
+		if !e.executor.evaluateCondition(condition, data) {
+			result = false
+			break
+		}
+	}
+	evaluation.Result = result
+
+	stateKey := fmt.Sprintf("workflow:trigger:%s:last_result", trigger.ID)
+	previous, err := e.redis.Get(e.ctx, stateKey).Result()
+	if err != nil && err != redis.Nil {
+		evaluation.Error = fmt.Sprintf("failed to load trigger state: %v", err)
+		return
+	}
+	if err := e.redis.Set(e.ctx, stateKey, fmt.Sprintf("%t", result), 0).Err(); err != nil {
+		e.logger.Error("Failed to store trigger state", "trigger_id", trigger.ID, "error", err)
+	}
+
+	if !result {
+		return
+	}
+
+	risingEdge := previous != "true"
+	cooldown := time.Duration(cfg.CooldownSeconds) * time.Second
+	cooledDown := trigger.LastTriggeredAt == nil || time.Since(*trigger.LastTriggeredAt) >= cooldown
+
+	// Fire on the rising edge, or again while still true once the cooldown has elapsed
+	if !cooledDown || (!risingEdge && cooldown == 0) {
+		return
+	}
+
+	instance, err := e.createTriggeredInstance(trigger, "Condition Triggered", models.JSONB{}, models.JSONB{
+		"trigger_id":   trigger.ID.String(),
+		"trigger_type": string(models.TriggerTypeCondition),
+		"data":         data,
+	})
+	if err != nil {
+		evaluation.Error = err.Error()
+		e.logger.Error("Failed to fire condition trigger", "trigger_id", trigger.ID, "error", err)
+		return
+	}
+
+	evaluation.Fired = true
+	evaluation.InstanceID = &instance.ID
+	e.logger.Info("Condition trigger fired", "trigger_id", trigger.ID, "instance_id", instance.ID)
+}
+
+// checkScheduleTriggers fires the schedule triggers whose next occurrence has passed
+func (e *Engine) checkScheduleTriggers() {
+	var triggers []models.WorkflowTrigger
+	if err := e.db.Preload("Template").
+		Where("trigger_type = ? AND is_active = true", models.TriggerTypeSchedule).
+		Find(&triggers).Error; err != nil {
+		e.logger.Error("Failed to fetch schedule triggers", "error", err)
+		return
+	}
+
+	now := time.Now()
+	for i := range triggers {
+		e.evaluateScheduleTrigger(&triggers[i], now)
+	}
+}
+
+// evaluateScheduleTrigger fires the occurrences due since the trigger last ran.
+// Occurrences older than two check intervals were missed while the engine was down
+// and are handled by the trigger's catch-up policy.
+func (e *Engine) evaluateScheduleTrigger(trigger *models.WorkflowTrigger, now time.Time) {
+	var cfg models.ScheduleTriggerConfig
+	if err := decodeJSONB(trigger.TriggerConfig, &cfg); err != nil {
+		e.recordScheduleError(trigger, now, fmt.Sprintf("invalid trigger config: %v", err))
+		return
+	}
+	cron, err := schedule.ParseCron(cfg.Cron)
+	if err != nil {
+		e.recordScheduleError(trigger, now, err.Error())
+		return
+	}
+	loc, err := schedule.LoadLocation(cfg.Timezone)
+	if err != nil {
+		e.recordScheduleError(trigger, now, err.Error())
+		return
+	}
+	policy := cfg.CatchUp
+	switch policy {
+	case "":
+		policy = models.ScheduleCatchUpOne
+	case models.ScheduleCatchUpNone, models.ScheduleCatchUpOne, models.ScheduleCatchUpAll:
+	default:
+		e.recordScheduleError(trigger, now, fmt.Sprintf("catch_up must be one of none, one or all, got %q", cfg.CatchUp))
+		return
+	}
+
+	state := scheduleStateOf(trigger)
+	last := trigger.CreatedAt
+	if trigger.LastTriggeredAt != nil {
+		last = *trigger.LastTriggeredAt
+	}
+	if state.LastScheduledFor != nil && state.LastScheduledFor.After(last) {
+		last = *state.LastScheduledFor
+	}
+
+	var due []time.Time
+	for next := cron.Next(last, loc); !next.IsZero() && !next.After(now) && len(due) < maxScheduleScan; next = cron.Next(next, loc) {
+		due = append(due, next)
+	}
+	if len(due) == 0 {
+		return
+	}
+	latest := due[len(due)-1]
+
+	// Only one replica handles a given batch of occurrences
+	lockKey := fmt.Sprintf("workflow:trigger:%s:fired:%d", trigger.ID, latest.Unix())
+	acquired, err := e.redis.SetNX(e.ctx, lockKey, "1", 24*time.Hour).Result()
+	if err != nil || !acquired {
+		return
+	}
+
+	grace := 2 * time.Duration(e.config.WorkflowCheckInterval) * time.Second
+	onTime := now.Sub(latest) <= grace
+
+	var fire []time.Time
+	switch policy {
+	case models.ScheduleCatchUpNone:
+		if onTime {
+			fire = due[len(due)-1:]
+		}
+	case models.ScheduleCatchUpOne:
+		fire = due[len(due)-1:]
+	case models.ScheduleCatchUpAll:
+		limit := cfg.MaxCatchUp
+		if limit <= 0 {
+			limit = defaultMaxCatchUp
+		}
+		fire = due
+		if len(fire) > limit {
+			fire = fire[len(fire)-limit:]
+		}
+	}
+	if len(due) == maxScheduleScan {
+		// More are due than one check enumerates; the next check fires the most recent
+		fire = nil
+	}
+	skipped := due[:len(due)-len(fire)]
+
+	evaluation := models.TriggerEvaluation{EvaluatedAt: now, Result: true, Skipped: len(skipped)}
+	defer e.recordTriggerEvaluation(trigger.ID, &evaluation)
+
+	if len(skipped) > 0 {
+		e.logger.Warn("Skipped missed schedule occurrences", "trigger_id", trigger.ID, "policy", policy,
+			"skipped", len(skipped), "first", skipped[0], "last", skipped[len(skipped)-1])
+		scheduleOccurrencesTotal.Add(float64(len(skipped)), "skipped")
+	}
+	e.saveScheduleState(trigger, state, latest, skipped, policy, now)
+
+	for _, scheduledFor := range fire {
+		variables := models.JSONB{}
+		for key, value := range cfg.Variables {
+			variables[key] = value
+		}
+		instance, err := e.createTriggeredInstance(trigger, "Scheduled", variables, models.JSONB{
+			"trigger_id":    trigger.ID.String(),
+			"trigger_type":  string(models.TriggerTypeSchedule),
+			"scheduled_for": scheduledFor.In(loc).Format(time.RFC3339),
+			"timezone":      loc.String(),
+			"catch_up":      !onTime || scheduledFor.Before(latest),
+		})
+		if err != nil {
+			evaluation.Error = err.Error()
+			e.logger.Error("Failed to fire schedule trigger", "trigger_id", trigger.ID, "scheduled_for", scheduledFor, "error", err)
+			return
+		}
+
+		scheduleOccurrencesTotal.Inc("fired")
+		evaluation.Fired = true
+		evaluation.InstanceID = &instance.ID
+		e.logger.Info("Schedule trigger fired", "trigger_id", trigger.ID, "instance_id", instance.ID, "scheduled_for", scheduledFor)
+	}
+}
+
+// scheduleStateOf reads the schedule bookkeeping from a trigger's metadata
+func scheduleStateOf(trigger *models.WorkflowTrigger) models.ScheduleState {
+	var state models.ScheduleState
+	if raw, ok := trigger.Metadata["schedule"]; ok {
+		if data, err := json.Marshal(raw); err == nil {
+			json.Unmarshal(data, &state)
+		}
+	}
+	return state
+}
+
+// saveScheduleState advances the trigger past latest and records the skipped
+// occurrences so operators can see what was missed
+func (e *Engine) saveScheduleState(trigger *models.WorkflowTrigger, state models.ScheduleState, latest time.Time, skipped []time.Time, policy string, now time.Time) {
+	state.LastScheduledFor = &latest
+	state.MissedTotal += len(skipped)
+
+	missed := make([]models.MissedRun, 0, len(skipped))
+	for i := len(skipped) - 1; i >= 0 && len(missed) < missedRunHistoryLimit; i-- {
+		missed = append(missed, models.MissedRun{ScheduledFor: skipped[i], SkippedAt: now, Policy: policy})
+	}
+	state.Missed = append(missed, state.Missed...)
+	if len(state.Missed) > missedRunHistoryLimit {
+		state.Missed = state.Missed[:missedRunHistoryLimit]
+	}
+
+	if trigger.Metadata == nil {
+		trigger.Metadata = models.JSONB{}
+	}
+	trigger.Metadata["schedule"] = state
+	if err := e.db.Model(&models.WorkflowTrigger{}).
+		Where("id = ?", trigger.ID).
+		Update("metadata", trigger.Metadata).Error; err != nil {
+		e.logger.Error("Failed to save schedule state", "trigger_id", trigger.ID, "error", err)
+	}
+}
+
+// recordScheduleError records a misconfigured schedule trigger, at most once per hour
+func (e *Engine) recordScheduleError(trigger *models.WorkflowTrigger, now time.Time, message string) {
+	key := fmt.Sprintf("workflow:trigger:%s:config_error", trigger.ID)
+	if acquired, err := e.redis.SetNX(e.ctx, key, message, time.Hour).Result(); err != nil || !acquired {
+		return
+	}
+
+	e.logger.Warn("Invalid schedule trigger", "trigger_id", trigger.ID, "error", message)
+	e.recordTriggerEvaluation(trigger.ID, &models.TriggerEvaluation{EvaluatedAt: now, Error: message})
+}
+
+// fetchConditionData loads the data a condition trigger is evaluated against
+func (e *Engine) fetchConditionData(source *models.ConditionSource) (map[string]interface{}, error) {
+	switch source.Type {
+	case "http":
+		if source.URL == "" {
+			return nil, fmt.Errorf("url not specified for http source")
+		}
+
+		ctx, cancel := context.WithTimeout(e.ctx, 10*time.Second)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source.URL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build request: %w", err)
+		}
+		for key, value := range source.Headers {
+			req.Header.Set(key, value)
+		}
+
+		resp, err := tracing.Client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("http source request failed: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			return nil, fmt.Errorf("http source returned status %d", resp.StatusCode)
+		}
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxConditionSourceBytes))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read http source response: %w", err)
+		}
+		return decodeConditionData(body), nil
+
+	case "redis":
+		if source.Key == "" {
+			return nil, fmt.Errorf("key not specified for redis source")
+		}
+
+		value, err := e.redis.Get(e.ctx, source.Key).Result()
+		if err == redis.Nil {
+			return map[string]interface{}{}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("redis source read failed: %w", err)
+		}
+		return decodeConditionData([]byte(value)), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported condition source type: %s", source.Type)
+	}
+}
+
+// recordTriggerEvaluation appends an evaluation to the trigger's capped history list
+func (e *Engine) recordTriggerEvaluation(triggerID uuid.UUID, evaluation *models.TriggerEvaluation) {
+	data, err := json.Marshal(evaluation)
+	if err != nil {
+		return
+	}
+
+	key := fmt.Sprintf("workflow:trigger:%s:evaluations", triggerID)
+	pipe := e.redis.Pipeline()
+	pipe.LPush(e.ctx, key, data)
+	pipe.LTrim(e.ctx, key, 0, triggerHistoryLimit-1)
+	if _, err := pipe.Exec(e.ctx); err != nil {
+		e.logger.Error("Failed to record trigger evaluation", "trigger_id", triggerID, "error", err)
+	}
+}
+
+// TriggerEvaluations returns the most recent evaluations of a polled trigger, newest first
+func (e *Engine) TriggerEvaluations(ctx context.Context, triggerID uuid.UUID, limit int) ([]models.TriggerEvaluation, error) {
+	key := fmt.Sprintf("workflow:trigger:%s:evaluations", triggerID)
+	entries, err := e.redis.LRange(ctx, key, 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	evaluations := make([]models.TriggerEvaluation, 0, len(entries))
+	for _, entry := range entries {
+		var evaluation models.TriggerEvaluation
+		if err := json.Unmarshal([]byte(entry), &evaluation); err == nil {
+			evaluations = append(evaluations, evaluation)
+		}
+	}
+
+	return evaluations, nil
+}
+
+// createTriggeredInstance creates and starts an instance on behalf of a trigger
+func (e *Engine) createTriggeredInstance(trigger *models.WorkflowTrigger, label string, variables, instanceContext models.JSONB) (*models.WorkflowInstance, error) {
+	if !trigger.Template.IsActive {
+		return nil, fmt.Errorf("template %s is inactive", trigger.TemplateID)
+	}
+
+	now := time.Now()
+	instance := models.WorkflowInstance{
+		TemplateID: trigger.TemplateID,
+		Name:       fmt.Sprintf("%s (%s)", trigger.Template.Name, label),
+		Variables:  variables,
+		Context:    instanceContext,
+		Status:     models.WorkflowStatusRunning,
+		StartedAt:  &now,
+		CreatedBy:  "trigger:" + string(trigger.TriggerType),
+		Encrypted:  trigger.Template.EncryptVariables,
+	}
+
+	if err := e.db.Create(&instance).Error; err != nil {
+		return nil, fmt.Errorf("failed to create instance: %w", err)
+	}
+
+	if err := e.db.Model(&models.WorkflowTrigger{}).
+		Where("id = ?", trigger.ID).
+		Update("last_triggered_at", now).Error; err != nil {
+		e.logger.Error("Failed to update trigger last triggered time", "trigger_id", trigger.ID, "error", err)
+	}
+	trigger.LastTriggeredAt = &now
+
+	if err := e.QueueInstance(instance.ID); err != nil {
+		e.logger.Error("Failed to queue triggered instance", "instance_id", instance.ID, "error", err)
+	}
+
+	return &instance, nil
+}
+
+// decodeConditionData parses a JSON object, wrapping any other payload under "value"
+func decodeConditionData(raw []byte) map[string]interface{} {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return map[string]interface{}{"value": string(raw)}
+	}
+
+	if data, ok := value.(map[string]interface{}); ok {
+		return data
+	}
+	return map[string]interface{}{"value": value}
+}
+
+// decodeJSONB converts a JSONB column into a typed struct
+func decodeJSONB(data models.JSONB, target interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, target)
+}
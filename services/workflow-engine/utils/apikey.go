@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// API keys look like "chorus_<prefix>_<secret>". The prefix is stored in clear text
+// for lookup; only the SHA-256 hash of the whole key is persisted.
+const apiKeyScheme = "chorus"
+
+// GenerateAPIKey returns a new random key together with its prefix and hash
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	buf := make([]byte, 28)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+
+	prefix = hex.EncodeToString(buf[:4])
+	key = fmt.Sprintf("%s_%s_%s", apiKeyScheme, prefix, hex.EncodeToString(buf[4:]))
+	return key, prefix, HashAPIKey(key), nil
+}
+
+// ParseAPIKeyPrefix extracts the lookup prefix from a presented key
+func ParseAPIKeyPrefix(key string) (string, bool) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != apiKeyScheme || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// HashAPIKey returns the hex-encoded SHA-256 hash of key
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
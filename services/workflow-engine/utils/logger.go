@@ -1,58 +0,0 @@
-package utils
-
-import (
-	"log/slog"
-	"os"
-)
-
-// Logger wraps slog.Logger for structured logging
-type Logger struct {
-	*slog.Logger
-}
-
-// NewLogger creates a new structured logger
-func NewLogger() *Logger {
-	// Create a JSON handler for structured logging
-	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	})
-	
-	logger := slog.New(handler)
-	
-	return &Logger{
-		Logger: logger,
-	}
-}
-
-// Info logs an info level message with optional key-value pairs
-func (l *Logger) Info(msg string, args ...interface{}) {
-	l.Logger.Info(msg, args...)
-}
-
-// Error logs an error level message with optional key-value pairs
-func (l *Logger) Error(msg string, args ...interface{}) {
-	l.Logger.Error(msg, args...)
-}
-
-// Warn logs a warning level message with optional key-value pairs
-func (l *Logger) Warn(msg string, args ...interface{}) {
-	l.Logger.Warn(msg, args...)
-}
-
-// Debug logs a debug level message with optional key-value pairs
-func (l *Logger) Debug(msg string, args ...interface{}) {
-	l.Logger.Debug(msg, args...)
-}
-
-// Fatal logs a fatal level message and exits the program
-func (l *Logger) Fatal(msg string, args ...interface{}) {
-	l.Logger.Error(msg, args...)
-	os.Exit(1)
-}
-
-// With returns a new logger with the given key-value pairs added to the context
-func (l *Logger) With(args ...interface{}) *Logger {
-	return &Logger{
-		Logger: l.Logger.With(args...),
-	}
-}
\ No newline at end of file
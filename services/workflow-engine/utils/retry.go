@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"context"
+	"time"
+)
+
+// Backoff bounds for Retry
+const (
+	retryInitialDelay = 500 * time.Millisecond
+	retryMaxDelay     = 15 * time.Second
+)
+
+// Retry calls fn until it succeeds, up to attempts times, doubling the delay between
+// attempts. onRetry is called before each wait. It returns the last error, or the
+// context's error if ctx is cancelled while waiting.
+func Retry(ctx context.Context, attempts int, fn func() error, onRetry func(attempt int, err error, wait time.Duration)) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	delay := retryInitialDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || attempt >= attempts {
+			return err
+		}
+
+		if onRetry != nil {
+			onRetry(attempt, err, delay)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+}